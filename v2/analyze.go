@@ -0,0 +1,113 @@
+package arc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// analyzeSampleSize is how much of each entry is compressed to predict its ratio
+const analyzeSampleSize = 1 << 20
+
+// EntryAnalysis describes how compressible an archive entry is.
+type EntryAnalysis struct {
+	Name string
+	Size int64
+	// Shannon entropy of the entry in bits per byte, from 0 (constant) to 8 (random)
+	Entropy float64
+	// compressed size divided by original size for each registered codec,
+	// measured on the first analyzeSampleSize bytes of the entry
+	Ratios map[string]float64
+}
+
+// AnalyzeCodecs returns the sorted names of the codecs used by Analyze.
+func AnalyzeCodecs() []string {
+	registered := compressions()
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Analyze reads every regular file in archive and reports its entropy and the
+// predicted compression ratio per codec. This helps choosing a codec, and
+// spotting already compressed files that are better stored as is.
+func Analyze(archive string) ([]EntryAnalysis, error) {
+	logging("Analyzing %s", archive)
+	var results []EntryAnalysis
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		if !f.Mode().IsRegular() || f.LinkTarget != "" {
+			return nil
+		}
+		reader, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", f.NameInArchive, err)
+		}
+		defer reader.Close()
+
+		result, err := AnalyzeReader(reader)
+		if err != nil {
+			return fmt.Errorf("analyze %s: %w", f.NameInArchive, err)
+		}
+		result.Name = f.NameInArchive
+		results = append(results, result)
+		return nil
+	}
+	if err := walkArchive(archive, handler); err != nil {
+		return nil, fmt.Errorf("analyzing entries: %w", err)
+	}
+	return results, nil
+}
+
+// AnalyzeReader computes the entropy of everything read from r, and the
+// compression ratio per codec of its first bytes.
+func AnalyzeReader(r io.Reader) (EntryAnalysis, error) {
+	var result EntryAnalysis
+	var counts [256]int64
+	sample := make([]byte, 0, analyzeSampleSize)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		if room := analyzeSampleSize - len(sample); room > 0 {
+			sample = append(sample, buf[:min(n, room)]...)
+		}
+		result.Size += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(result.Size)
+		result.Entropy -= p * math.Log2(p)
+	}
+
+	registered := compressions()
+	result.Ratios = make(map[string]float64, len(registered))
+	if len(sample) == 0 {
+		return result, nil
+	}
+	for name, compression := range registered {
+		compressed, err := Compress(sample, compression)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", name, err)
+		}
+		result.Ratios[name] = float64(len(compressed)) / float64(len(sample))
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+//go:build !arc_minimal && !arc_no_brotli && !arc_no_bzip2 && !arc_no_xz && !arc_no_lz4 && !arc_no_lzip && !arc_no_minlz && !arc_no_snappy && !arc_no_7z && !arc_no_rar
+
+package arc_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/mholt/archives"
+)
+
+// TestUpstreamTypes checks that programs pass the formats of mholt/archives,
+// which builds without a tag leaving codecs out take.
+func TestUpstreamTypes(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("upstream types\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	for _, format := range []struct {
+		name        string
+		compression archives.Compression
+		archival    archives.Archival
+	}{
+		{"a.tar.gz", archives.Gz{}, archives.Tar{}},
+		{"a.tar.xz", archives.Xz{}, archives.Tar{}},
+		{"a.tar.zst", archives.Zstd{}, archives.Tar{}},
+		{"a.zip", nil, archives.Zip{}},
+	} {
+		archive := filepath.Join(out, format.name)
+		if err := arc.Archive(src, archive, format.compression, format.archival); err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+		if err := arc.Unarchive(archive, filepath.Join(out, format.name+".d")); err != nil {
+			t.Fatalf("%s: %v", format.name, err)
+		}
+	}
+
+	data := []byte("compressed with the types of mholt/archives")
+	compressed, err := arc.Compress(data, archives.Bz2{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := arc.Decompress(compressed, archives.Bz2{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatalf("decompressed %q, expected %q", decompressed, data)
+	}
+}
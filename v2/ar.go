@@ -0,0 +1,230 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Ar is the ar format of Debian packages and static libraries. It reads the
+// common, GNU and BSD variants, and writes the GNU one, with the names
+// longer than 15 bytes in a table up front. Ar archives are flat: only
+// regular files are written, under their base name, and two files with the
+// same base name are an error.
+type Ar struct{}
+
+var arMagic = []byte("!<arch>\n")
+
+// arHeaderSize is the size of the header of a member.
+const arHeaderSize = 60
+
+func (Ar) Extension() string { return ".a" }
+func (Ar) MediaType() string { return "application/x-archive" }
+
+// Match matches .a files by name, and the extensions of archivalAliases
+// that stand for ar, like .deb.
+func (a Ar) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	ext := strings.ToLower(path.Ext(filename))
+	mr.ByName = ext == a.Extension() || archivalAliases[ext] == a.Extension()
+	if stream == nil {
+		return mr, nil
+	}
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(stream, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(magic, arMagic)
+	return mr, nil
+}
+
+// Archive writes the regular files to output as an ar archive, in order.
+func (Ar) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	var members []archives.FileInfo
+	var names []string
+	stored := make(map[string]string)
+	var longNames bytes.Buffer
+	for _, f := range files {
+		if !f.Mode().IsRegular() || f.LinkTarget != "" {
+			if !f.IsDir() {
+				logging("Skipping %s, ar archives only hold regular files", f.NameInArchive)
+			}
+			continue
+		}
+		name := path.Base(f.NameInArchive)
+		if other, ok := stored[name]; ok {
+			return fmt.Errorf("'%s' and '%s' would both be stored as '%s', ar archives have no directories", other, f.NameInArchive, name)
+		}
+		stored[name] = f.NameInArchive
+		// GNU ar ends names with a slash, so names may have spaces
+		if len(name) < 16 {
+			names = append(names, name+"/")
+		} else {
+			names = append(names, "/"+strconv.Itoa(longNames.Len()))
+			longNames.WriteString(name + "/\n")
+		}
+		members = append(members, f)
+	}
+
+	w := bufio.NewWriter(output)
+	if _, err := w.Write(arMagic); err != nil {
+		return err
+	}
+	if longNames.Len() > 0 {
+		if longNames.Len()%2 == 1 {
+			longNames.WriteByte('\n')
+		}
+		if _, err := fmt.Fprintf(w, "%-48s%-10d`\n", "//", longNames.Len()); err != nil {
+			return err
+		}
+		if _, err := w.Write(longNames.Bytes()); err != nil {
+			return err
+		}
+	}
+	for i, f := range members {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeArMember(ctx, w, f, names[i]); err != nil {
+			return fmt.Errorf("%s: %w", f.NameInArchive, err)
+		}
+	}
+	return w.Flush()
+}
+
+// writeArMember writes the header of f, stored as name, and its content.
+func writeArMember(ctx context.Context, w io.Writer, f archives.FileInfo, name string) error {
+	size := f.Size()
+	if size > 9999999999 {
+		return fmt.Errorf("%d bytes is above the 10 digits of ar sizes", size)
+	}
+	// owned by root, like ar D writes for reproducible builds
+	_, err := fmt.Fprintf(w, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, max(f.ModTime().Unix(), 0), 0, 0, unixMode(f.Mode()), size)
+	if err != nil {
+		return err
+	}
+	file, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := io.CopyN(w, ctxReader{Reader: file, ctx: ctx}, size)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("file shrank to %d bytes while being archived", n)
+	}
+	if err != nil {
+		return err
+	}
+	if size%2 == 1 {
+		_, err = w.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// Extract calls handleFile for each member of the ar archive, leaving out
+// the symbol tables of static libraries.
+func (Ar) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	r := bufio.NewReader(archive)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, arMagic) {
+		return errors.New("not an ar archive")
+	}
+
+	var longNames []byte
+	header := make([]byte, arHeaderSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated ar header: %w", err)
+		}
+		if string(header[58:]) != "`\n" {
+			return errors.New("malformed ar header")
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("malformed ar header: size %q", header[48:58])
+		}
+		body := io.LimitReader(r, size)
+		entry := EntryInfo{Size: size, Mode: 0o644}
+		if mode, err := strconv.ParseUint(strings.TrimSpace(string(header[40:48])), 8, 32); err == nil {
+			entry.Mode = fileMode(uint32(mode)).Perm()
+		}
+		if mtime, err := strconv.ParseInt(strings.TrimSpace(string(header[16:28])), 10, 64); err == nil {
+			entry.ModTime = time.Unix(mtime, 0)
+		}
+
+		name := strings.TrimRight(string(header[:16]), " ")
+		switch {
+		case name == "//":
+			if longNames, err = io.ReadAll(body); err != nil {
+				return fmt.Errorf("read long names: %w", err)
+			}
+			name = ""
+		case name == "/" || name == "/SYM64/":
+			name = ""
+		case strings.HasPrefix(name, "#1/"):
+			// BSD ar stores the name after the header, as part of the content
+			n, err := strconv.Atoi(name[3:])
+			if err != nil || n < 0 || int64(n) > size {
+				return fmt.Errorf("malformed ar header: name %q", name)
+			}
+			raw := make([]byte, n)
+			if _, err := io.ReadFull(body, raw); err != nil {
+				return fmt.Errorf("truncated ar header: %w", err)
+			}
+			name = string(bytes.TrimRight(raw, "\x00"))
+			entry.Size -= int64(n)
+		case strings.HasPrefix(name, "/"):
+			offset, err := strconv.Atoi(name[1:])
+			if err != nil || offset < 0 || offset >= len(longNames) {
+				return fmt.Errorf("malformed ar header: name %q", name)
+			}
+			name, _, _ = strings.Cut(string(longNames[offset:]), "\n")
+			name = strings.TrimSuffix(name, "/")
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+		if strings.HasPrefix(name, "__.SYMDEF") {
+			name = ""
+		}
+
+		if name != "" {
+			entry.Name = name
+			err := handleFile(ctx, sourceFileInfo(entry, io.NopCloser(body)))
+			if errors.Is(err, fs.SkipAll) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("handling file: %s: %w", name, err)
+			}
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		if size%2 == 1 {
+			if _, err := r.Discard(1); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("read %s: %w", name, err)
+			}
+		}
+	}
+}
+
+// Interface guard
+var _ archives.Archival = Ar{}
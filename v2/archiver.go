@@ -1,56 +1,108 @@
 package arc
 
 import (
-	"context"
+	"archive/tar"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 
-	"github.com/mholt/archives"
+	"github.com/jm33-m0/arc/v2/internal/archives"
 )
 
-// Maps to handle compression and archival types
-var CompressionMap = map[string]archives.Compression{
-	"gz":   archives.Gz{},
-	"bz2":  archives.Bz2{},
-	"xz":   archives.Xz{},
-	"zst":  archives.Zstd{},
-	"lz4":  archives.Lz4{},
-	"br":   archives.Brotli{},
-	"lzip": archives.Lzip{},
-	"sz":   archives.Sz{},
-	"zlib": archives.Zlib{},
-}
-
-var ArchivalMap = map[string]archives.Archival{
-	"tar": archives.Tar{},
-	"zip": archives.Zip{},
-}
-
 // check if a path exists
 func isExist(path string) bool {
 	_, statErr := os.Stat(path)
 	return !os.IsNotExist(statErr)
 }
 
+// WithSources adds more files and directories to the archive of dir, each
+// stored under its base name like dir itself. Two sources with the same base
+// name are an error.
+func WithSources(paths ...string) Option {
+	return func(o *options) {
+		o.sources = append(o.sources, paths...)
+	}
+}
+
+// WithDirectory resolves relative sources against dir instead of the working
+// directory, like tar -C, so "." archives the content of dir without a top
+// level directory. Names in the archive don't change.
+func WithDirectory(dir string) Option {
+	return func(o *options) {
+		o.directory = dir
+	}
+}
+
+// diskPath returns where the source is on disk, see WithDirectory.
+func diskPath(source string, o *options) string {
+	if o.directory == "" || filepath.IsAbs(source) {
+		return source
+	}
+	return filepath.Join(o.directory, source)
+}
+
+// filesFromDisk maps dir and the sources of WithSources to their paths in
+// the archive, and returns all files below them that aren't ignored, see
+// WithIgnoreFiles. With WithFileList, it returns the listed files instead.
+func filesFromDisk(dir string, o *options) ([]archives.FileInfo, error) {
+	if o.fileList != nil {
+		return filesFromList(o)
+	}
+	paths := make(map[string]string)
+	names := make(map[string]string)
+	for _, source := range append([]string{dir}, o.sources...) {
+		if !isExist(diskPath(source, o)) {
+			return nil, fmt.Errorf("source '%s' does not exist", source)
+		}
+		name := filepath.Base(filepath.Clean(source))
+		onDisk := diskPath(source, o)
+		// "." is the root of the archive, a trailing separator stores the
+		// content of the directory without its name
+		if source == "." {
+			name = ""
+			onDisk += string(filepath.Separator)
+		}
+		if other, ok := names[name]; ok && filepath.Clean(other) != filepath.Clean(source) {
+			return nil, fmt.Errorf("sources '%s' and '%s' would both be stored as '%s'", other, source, name)
+		}
+		names[name] = source
+		paths[onDisk] = name
+	}
+	files, err := archives.FilesFromDisk(o.context(), nil, paths)
+	if err != nil || !o.ignoreFiles {
+		return files, err
+	}
+	return applyIgnoreFiles(files)
+}
+
 // Archive is a function that archives the files in a directory
 // dir: the directory to Archive
 // outfile: the output file
 // compression: the compression to use (gzip, bzip2, etc.)
 // archival: the archival to use (tar, zip, etc.)
-func Archive(dir, outfile string, compression archives.Compression, archival archives.Archival) error {
+// opts: optional settings, see Option
+func Archive(dir, outfile string, compression archives.Compression, archival archives.Archival, opts ...Option) error {
+	o := newOptions(opts)
 	logging("Starting the archival process for directory: %s", dir)
 
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(outfile); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
 	// remove outfile
 	logging("Removing any existing output file: %s", outfile)
-	if err := os.RemoveAll(outfile); err != nil {
+	if err := removeOutput(outfile, o); err != nil {
 		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
 
-	if !isExist(dir) {
+	if !isExist(diskPath(dir, o)) {
 		errMsg := fmt.Errorf("directory '%s' does not exist, cannot proceed with archival", dir)
 		logging("%s", errMsg.Error())
 		return errMsg
@@ -58,13 +110,7 @@ func Archive(dir, outfile string, compression archives.Compression, archival arc
 
 	// map files on disk to their paths in the archive
 	logging("Mapping files in directory: %s", dir)
-	archiveDirName := filepath.Base(filepath.Clean(dir))
-	if dir == "." {
-		archiveDirName = ""
-	}
-	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{
-		dir: archiveDirName,
-	})
+	files, err := filesFromDisk(dir, o)
 	if err != nil {
 		errMsg := fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
 		logging("%s", errMsg.Error())
@@ -72,19 +118,6 @@ func Archive(dir, outfile string, compression archives.Compression, archival arc
 	}
 	logging("Successfully mapped files for directory: %s", dir)
 
-	// create the output file we'll write to
-	logging("Creating output file: %s", outfile)
-	outf, err := os.Create(outfile)
-	if err != nil {
-		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	defer func() {
-		logging("Closing output file: %s", outfile)
-		outf.Close()
-	}()
-
 	// define the archive format
 	logging("Defining the archive format with compression: %T and archival: %T", compression, archival)
 	format := archives.CompressedArchive{
@@ -92,16 +125,7 @@ func Archive(dir, outfile string, compression archives.Compression, archival arc
 		Archival:    archival,
 	}
 
-	// create the archive
-	logging("Starting archive creation: %s", outfile)
-	err = format.Archive(context.Background(), outf, files)
-	if err != nil {
-		errMsg := fmt.Errorf("error during archive creation for output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	logging("Archive created successfully: %s", outfile)
-	return nil
+	return writeArchive(outfile, format, files, o)
 }
 
 // ArchiveWithFilter is a function that archives the files in a directory
@@ -111,18 +135,39 @@ func Archive(dir, outfile string, compression archives.Compression, archival arc
 // compression: the compression to use (gzip, bzip2, etc.)
 // archival: the archival to use (tar, zip, etc.)
 // filter: a function that returns true for files to be excluded
-func ArchiveWithFilter(dir, outfile string, compression archives.Compression, archival archives.Archival, filter func(string) bool) error {
+// opts: optional settings, see Option
+func ArchiveWithFilter(dir, outfile string, compression archives.Compression, archival archives.Archival, filter func(string) bool, opts ...Option) error {
+	return ArchiveWithFileFilter(dir, outfile, compression, archival, NameFilter(filter), opts...)
+}
+
+// ArchiveWithFileFilter is ArchiveWithFilter with a filter that sees the whole
+// FileInfo, so files can be excluded by size, mode or mtime
+// dir: the directory to Archive
+// outfile: the output file
+// compression: the compression to use (gzip, bzip2, etc.)
+// archival: the archival to use (tar, zip, etc.)
+// filter: a function that returns true for files to be excluded
+// opts: optional settings, see Option
+func ArchiveWithFileFilter(dir, outfile string, compression archives.Compression, archival archives.Archival, filter FileFilter, opts ...Option) error {
+	o := newOptions(opts)
 	logging("Starting the archival process for directory: %s with filter", dir)
 
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(outfile); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
 	// remove outfile
 	logging("Removing any existing output file: %s", outfile)
-	if err := os.RemoveAll(outfile); err != nil {
+	if err := removeOutput(outfile, o); err != nil {
 		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
 
-	if !isExist(dir) {
+	if !isExist(diskPath(dir, o)) {
 		errMsg := fmt.Errorf("directory '%s' does not exist, cannot proceed with archival", dir)
 		logging("%s", errMsg.Error())
 		return errMsg
@@ -130,13 +175,7 @@ func ArchiveWithFilter(dir, outfile string, compression archives.Compression, ar
 
 	// map files on disk to their paths in the archive
 	logging("Mapping files in directory: %s with filter", dir)
-	archiveDirName := filepath.Base(filepath.Clean(dir))
-	if dir == "." {
-		archiveDirName = ""
-	}
-	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{
-		dir: archiveDirName,
-	})
+	files, err := filesFromDisk(dir, o)
 	if err != nil {
 		errMsg := fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
 		logging("%s", errMsg.Error())
@@ -146,40 +185,223 @@ func ArchiveWithFilter(dir, outfile string, compression archives.Compression, ar
 	// apply the filter to exclude certain files
 	filteredFiles := make([]archives.FileInfo, 0, len(files))
 	for _, fi := range files {
-		if !filter(fi.Name()) {
+		if !filter(fi) {
 			filteredFiles = append(filteredFiles, fi)
 		}
 	}
 	logging("Successfully mapped and filtered files for directory: %s", dir)
 
+	// define the archive format
+	logging("Defining the archive format with compression: %T and archival: %T", compression, archival)
+	format := archives.CompressedArchive{
+		Compression: compression,
+		Archival:    archival,
+	}
+
+	return writeArchive(outfile, format, filteredFiles, o)
+}
+
+// writeArchive creates outfile and writes files into it using format,
+// failures at an entry are returned as an EntryError
+func writeArchive(outfile string, format archives.Archiver, files []archives.FileInfo, o *options) error {
+	if f, ok := format.(archives.Format); ok {
+		if err := compiledIn(f); err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	var xattrs map[string]map[string]string
+	if o.xattrs {
+		var err error
+		if xattrs, err = readXattrs(files); err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	var routed map[string]bool
+	if len(o.routes) > 0 {
+		var cleanup func()
+		files, routed, cleanup = routeFiles(files, o.routes)
+		defer cleanup()
+	}
+	// sort before the manifest is added, it has to stay the last entry
+	if o.deterministic {
+		sortFiles(files)
+	}
+	var m *manifest
+	if o.manifest || o.manifestFile != "" {
+		logging("Computing SHA-256 manifest for %d entries", len(files))
+		files, m = addManifest(files, o.manifest)
+	}
+	if o.deterministic {
+		epoch, clamp, err := sourceDateEpoch()
+		if err != nil {
+			errMsg := fmt.Errorf("error creating deterministic archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		logging("Normalizing metadata of %d entries to %s", len(files), epoch)
+		for i := range files {
+			files[i] = normalizeFile(files[i], epoch, clamp)
+		}
+	} else {
+		for i := range files {
+			files[i] = utcFile(files[i])
+		}
+	}
+	if o.tarFormat != tar.FormatUnknown || o.xattrs {
+		withHeaders, err := tarHeaderFormat(format, o.tarFormat, xattrs)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = withHeaders
+	}
+	if o.seekable {
+		seekable, err := seekableFormat(format)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating seekable archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = seekable
+	}
+	if o.rsyncable {
+		rsyncable, err := rsyncableFormat(format)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating rsyncable archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = rsyncable
+	}
+	if o.password != "" {
+		encrypted, err := encryptFormat(format, o.password)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating encrypted archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = encrypted
+	}
+	if o.zipMeta != nil {
+		withMeta, err := zipMetaFormat(format, o)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = withMeta
+	}
+	if o.zopfli {
+		zopfli, err := zopfliFormat(format)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating zopfli compressed archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = zopfli
+	}
+	if o.zipLevelSet || len(o.zipStore) > 0 {
+		zipCompression, err := zipCompressionFormat(format, o)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = zipCompression
+	}
+	if o.zip64 != Zip64Auto {
+		zip64, err := zip64Format(format, o.zip64)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		format = zip64
+	}
+	if len(routed) > 0 {
+		format = storeRouted(format, routed)
+	}
+
+	progress := newProgress(o)
+	files = progress.files(files)
+	between, within, stop := operationContext(o)
+	defer stop()
+	files = cancelFiles(between, within, files)
+	entries := newEntryTracker(outfile)
+	files = entries.files(files)
+
 	// create the output file we'll write to
 	logging("Creating output file: %s", outfile)
-	outf, err := os.Create(outfile)
+	outf, err := createOutput(outfile, o)
 	if err != nil {
 		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
+	// an archive that wasn't written to the end is of no use
+	complete := false
 	defer func() {
 		logging("Closing output file: %s", outfile)
 		outf.Close()
+		if !complete {
+			discardOutput(outfile, o)
+		}
 	}()
 
-	// define the archive format
-	logging("Defining the archive format with compression: %T and archival: %T", compression, archival)
-	format := archives.CompressedArchive{
-		Compression: compression,
-		Archival:    archival,
+	// encrypt the whole stream when recipients were given
+	var output io.Writer = outf
+	var encrypter io.WriteCloser
+	if len(o.recipients) > 0 {
+		if encrypter, err = encryptWriter(outf, o); err != nil {
+			errMsg := fmt.Errorf("error setting up encryption for '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		output = encrypter
 	}
 
 	// create the archive
 	logging("Starting archive creation: %s", outfile)
-	err = format.Archive(context.Background(), outf, filteredFiles)
+	err = entries.wrap(format.Archive(within, entries.writer(output), files))
 	if err != nil {
 		errMsg := fmt.Errorf("error during archive creation for output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			errMsg := fmt.Errorf("error finishing encryption of '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	if err := finishPipeline(outf); err != nil {
+		errMsg := fmt.Errorf("error finishing the pipeline of '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if err := finishTrailer(outf); err != nil {
+		errMsg := fmt.Errorf("error writing checksum trailer of '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	complete = true
+
+	// write the sidecar manifest once all entries have been hashed
+	if o.manifestFile != "" {
+		logging("Writing manifest file: %s", o.manifestFile)
+		if err := os.WriteFile(o.manifestFile, m.bytes(), 0o644); err != nil {
+			errMsg := fmt.Errorf("error writing manifest file '%s': %w", o.manifestFile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	progress.done()
 	logging("Archive created successfully: %s", outfile)
 	return nil
 }
@@ -228,18 +450,27 @@ func IncludeFilesFilter(includePatterns []string) (func(string) bool, error) {
 // dir: the directory to archive
 // outfile: the output file
 // compressionMethod: compression method (8=deflate, 0=store)
-func Zip(dir, outfile string, compressionMethod int) error {
+// opts: optional settings, see Option
+func Zip(dir, outfile string, compressionMethod int, opts ...Option) error {
+	o := newOptions(opts)
 	logging("Starting ZIP archival process for directory: %s", dir)
 
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(outfile); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
 	// remove outfile
 	logging("Removing any existing output file: %s", outfile)
-	if err := os.RemoveAll(outfile); err != nil {
+	if err := removeOutput(outfile, o); err != nil {
 		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
 
-	if !isExist(dir) {
+	if !isExist(diskPath(dir, o)) {
 		errMsg := fmt.Errorf("directory '%s' does not exist, cannot proceed with archival", dir)
 		logging("%s", errMsg.Error())
 		return errMsg
@@ -247,13 +478,7 @@ func Zip(dir, outfile string, compressionMethod int) error {
 
 	// map files on disk to their paths in the archive
 	logging("Mapping files in directory: %s", dir)
-	archiveDirName := filepath.Base(filepath.Clean(dir))
-	if dir == "." {
-		archiveDirName = ""
-	}
-	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{
-		dir: archiveDirName,
-	})
+	files, err := filesFromDisk(dir, o)
 	if err != nil {
 		errMsg := fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
 		logging("%s", errMsg.Error())
@@ -261,35 +486,13 @@ func Zip(dir, outfile string, compressionMethod int) error {
 	}
 	logging("Successfully mapped files for directory: %s", dir)
 
-	// create the output file we'll write to
-	logging("Creating output file: %s", outfile)
-	outf, err := os.Create(outfile)
-	if err != nil {
-		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	defer func() {
-		logging("Closing output file: %s", outfile)
-		outf.Close()
-	}()
-
 	// define the ZIP archive format with custom settings
 	logging("Defining ZIP archive format with compression method: %d", compressionMethod)
 	zipFormat := archives.Zip{
 		Compression: uint16(compressionMethod),
 	}
 
-	// create the archive
-	logging("Starting ZIP archive creation: %s", outfile)
-	err = zipFormat.Archive(context.Background(), outf, files)
-	if err != nil {
-		errMsg := fmt.Errorf("error during ZIP archive creation for output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	logging("ZIP archive created successfully: %s", outfile)
-	return nil
+	return writeArchive(outfile, zipFormat, files, o)
 }
 
 const (
@@ -302,20 +505,42 @@ const (
 // and allows filtering files
 // dir: the directory to archive
 // outfile: the output file
+// compressionLevel: deflate level (1-9, 0=store), see WithZipLevel
 // compressionMethod: compression method (8=deflate, 0=store)
 // filter: a function that returns true for files to be excluded
-func ZipWithFilter(dir, outfile string, compressionLevel, compressionMethod int, filter func(string) bool) error {
+// opts: optional settings, see Option
+func ZipWithFilter(dir, outfile string, compressionLevel, compressionMethod int, filter func(string) bool, opts ...Option) error {
+	return ZipWithFileFilter(dir, outfile, compressionLevel, compressionMethod, NameFilter(filter), opts...)
+}
+
+// ZipWithFileFilter is ZipWithFilter with a filter that sees the whole
+// FileInfo, so files can be excluded by size, mode or mtime
+// dir: the directory to archive
+// outfile: the output file
+// compressionLevel: deflate level (1-9, 0=store), see WithZipLevel
+// compressionMethod: compression method (8=deflate, 0=store)
+// filter: a function that returns true for files to be excluded
+// opts: optional settings, see Option
+func ZipWithFileFilter(dir, outfile string, compressionLevel, compressionMethod int, filter FileFilter, opts ...Option) error {
+	o := newOptions(append([]Option{WithZipLevel(compressionLevel)}, opts...))
 	logging("Starting ZIP archival process for directory: %s with filter", dir)
 
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(outfile); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
 	// remove outfile
 	logging("Removing any existing output file: %s", outfile)
-	if err := os.RemoveAll(outfile); err != nil {
+	if err := removeOutput(outfile, o); err != nil {
 		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", outfile, err)
 		logging("%s", errMsg.Error())
 		return errMsg
 	}
 
-	if !isExist(dir) {
+	if !isExist(diskPath(dir, o)) {
 		errMsg := fmt.Errorf("directory '%s' does not exist, cannot proceed with archival", dir)
 		logging("%s", errMsg.Error())
 		return errMsg
@@ -323,13 +548,7 @@ func ZipWithFilter(dir, outfile string, compressionLevel, compressionMethod int,
 
 	// map files on disk to their paths in the archive
 	logging("Mapping files in directory: %s with filter", dir)
-	archiveDirName := filepath.Base(filepath.Clean(dir))
-	if dir == "." {
-		archiveDirName = ""
-	}
-	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{
-		dir: archiveDirName,
-	})
+	files, err := filesFromDisk(dir, o)
 	if err != nil {
 		errMsg := fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
 		logging("%s", errMsg.Error())
@@ -339,39 +558,17 @@ func ZipWithFilter(dir, outfile string, compressionLevel, compressionMethod int,
 	// apply the filter to exclude certain files
 	filteredFiles := make([]archives.FileInfo, 0, len(files))
 	for _, fi := range files {
-		if !filter(fi.Name()) {
+		if !filter(fi) {
 			filteredFiles = append(filteredFiles, fi)
 		}
 	}
 	logging("Successfully mapped and filtered files for directory: %s", dir)
 
-	// create the output file we'll write to
-	logging("Creating output file: %s", outfile)
-	outf, err := os.Create(outfile)
-	if err != nil {
-		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	defer func() {
-		logging("Closing output file: %s", outfile)
-		outf.Close()
-	}()
-
 	// define the ZIP archive format with custom settings
 	logging("Defining ZIP archive format with compression level: %d and method: %d", compressionLevel, compressionMethod)
 	zipFormat := archives.Zip{
 		Compression: uint16(compressionMethod),
 	}
 
-	// create the archive
-	logging("Starting ZIP archive creation: %s", outfile)
-	err = zipFormat.Archive(context.Background(), outf, filteredFiles)
-	if err != nil {
-		errMsg := fmt.Errorf("error during ZIP archive creation for output file '%s': %w", outfile, err)
-		logging("%s", errMsg.Error())
-		return errMsg
-	}
-	logging("ZIP archive created successfully: %s", outfile)
-	return nil
+	return writeArchive(outfile, zipFormat, filteredFiles, o)
 }
@@ -0,0 +1,84 @@
+package arc
+
+import (
+	"fmt"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+const (
+	// estimated ratio above which AutoCompression stores the files, most of
+	// their content is already compressed and compressing it again only
+	// costs time
+	autoStoreRatio = 0.9
+	// estimated ratio above which AutoCompression picks a fast codec, there
+	// is little to gain from a stronger one
+	autoFastRatio = 0.7
+)
+
+// AutoChoice is the compression AutoCompression picked.
+type AutoChoice struct {
+	CompressionChoice
+	// Ratio is the estimated size of the archive compressed with the probe,
+	// zstd or gzip, divided by its stored size
+	Ratio float64
+}
+
+// Store reports whether the files are better stored as they are, the
+// Compression of the choice is nil then.
+func (c AutoChoice) Store() bool {
+	return c.Compression == nil
+}
+
+// String returns the name of the choice and the estimated ratio, like
+// "zst (estimated 42% of the stored size)".
+func (c AutoChoice) String() string {
+	return fmt.Sprintf("%s (estimated %.0f%% of the stored size)", c.Name, c.Ratio*100)
+}
+
+// AutoCompression picks a compression for archiving the files of dir, for
+// users who don't know or care which one to use. It estimates the ratio
+// like EstimateArchive, from the start of a share of the files of each
+// extension, and picks:
+//   - none, to store the files, when most of their content is already
+//     compressed, like trees of photos and videos
+//   - lz4, when there is little to gain from compressing
+//   - zstd otherwise
+//
+// Builds without lz4 or zstd fall back to gzip.
+// dir: the directory to archive
+// archival: the archival, for the size of its headers
+// filter: a function that returns true for files to be excluded, may be nil
+// opts: optional settings, like WithDirectory and WithSources
+func AutoCompression(dir string, archival archives.Archival, filter FileFilter, opts ...Option) (AutoChoice, error) {
+	var choice AutoChoice
+	probe, err := SelectCompression("zst,gz")
+	if err != nil {
+		return choice, err
+	}
+	stored, err := EstimateArchive(dir, nil, archival, filter, opts...)
+	if err != nil {
+		return choice, err
+	}
+	compressed, err := EstimateArchive(dir, probe.Compression, archival, filter, opts...)
+	if err != nil {
+		return choice, err
+	}
+	choice.Ratio = 1
+	if stored.Size > 0 {
+		choice.Ratio = float64(compressed.Size) / float64(stored.Size)
+	}
+
+	switch {
+	case choice.Ratio > autoStoreRatio:
+		choice.Name = "none"
+	case choice.Ratio > autoFastRatio:
+		if choice.CompressionChoice, err = SelectCompression("lz4,gz"); err != nil {
+			return choice, err
+		}
+	default:
+		choice.CompressionChoice = probe
+	}
+	logging("Estimated ratio of %s with %s: %.3f, picked %s", dir, probe.Name, choice.Ratio, choice.Name)
+	return choice, nil
+}
@@ -0,0 +1,249 @@
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// benchMinChunk is the least read of each file for the sample of Bench, so
+// directories of many files still sample more than their headers.
+const benchMinChunk = 4 << 10
+
+// benchLevels are the levels Bench measures per codec: the fastest, the
+// default of its usual tool and the smallest. Codecs without levels are
+// measured once, at level 0.
+var benchLevels = map[string][]int{
+	"gz":      {1, 6, 9},
+	"zlib":    {1, 6, 9},
+	"deflate": {1, 6, 9},
+	"sz":      {1, 3},
+	"s2":      {1, 3},
+	"bz2":     {1, 9},
+	"lz4":     {1, 9},
+	"zst":     {1, 3, 19},
+	"br":      {1, 6, 11},
+}
+
+// BenchResult is how a codec at a level fared on the sample of Bench.
+type BenchResult struct {
+	// Codec is the name of the compression, like "zst", see LookupCompression
+	Codec string
+	// Level is the level in the scale of CompressionLevel, 0 for codecs
+	// without levels
+	Level      int
+	Raw        int64
+	Compressed int64
+	// Ratio is Compressed divided by Raw
+	Ratio float64
+	// CompressSpeed and DecompressSpeed are in raw bytes per second
+	CompressSpeed   float64
+	DecompressSpeed float64
+	// Memory is the heap allocated to compress and decompress the sample,
+	// mostly the windows and tables of the codec
+	Memory uint64
+}
+
+// Name returns the codec and its level, like "zst:19".
+func (r BenchResult) Name() string {
+	if r.Level == 0 {
+		return r.Codec
+	}
+	return fmt.Sprintf("%s:%d", r.Codec, r.Level)
+}
+
+// Bench compresses a sample of the files of dir with each registered codec
+// that can compress and is compiled in, at a few levels from fastest to
+// smallest, and decompresses it again, to compare their ratio, speed and
+// memory on this kind of data. The sample is the start of every file, up to
+// sampleSize bytes in total, concatenated like in a tar archive. Results are
+// in the order of the codec names and levels.
+// dir: the directory to sample
+// sampleSize: how much of the files to compress, like 16 MiB
+// codecs: the codecs to measure, all if none
+// opts: optional settings, like WithContext, WithDirectory and WithIgnoreFiles
+func Bench(dir string, sampleSize int64, codecs []string, opts ...Option) ([]BenchResult, error) {
+	o := newOptions(opts)
+	ctx := o.context()
+	logging("Benchmarking codecs on directory: %s", dir)
+	if !isExist(diskPath(dir, o)) {
+		return nil, fmt.Errorf("directory '%s' does not exist, cannot benchmark", dir)
+	}
+	sample, err := benchSample(dir, sampleSize, o)
+	if err != nil {
+		return nil, err
+	}
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("no file content to benchmark in %s", dir)
+	}
+
+	if len(codecs) == 0 {
+		codecs = AnalyzeCodecs()
+	}
+	var results []BenchResult
+	for _, name := range codecs {
+		compression, ok := LookupCompression(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown compression %q", name)
+		}
+		if DecompressOnly(name) {
+			continue
+		}
+		if err := compiledIn(compression); err != nil {
+			logging("Not benchmarking %s: %v", name, err)
+			continue
+		}
+		levels, ok := benchLevels[name]
+		if !ok {
+			levels = []int{0}
+		}
+		for _, level := range levels {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			leveled := compression
+			if level != 0 {
+				if leveled, err = CompressionLevel(compression, level); err != nil {
+					return results, err
+				}
+			}
+			result, err := benchCodec(sample, leveled)
+			if err != nil {
+				return results, fmt.Errorf("benchmarking %s: %w", name, err)
+			}
+			result.Codec, result.Level = name, level
+			logging("Benchmarked %s: ratio %.3f", result.Name(), result.Ratio)
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// benchSample reads the start of each regular file of dir, an even share of
+// sampleSize but at least benchMinChunk, until sampleSize bytes are read.
+func benchSample(dir string, sampleSize int64, o *options) ([]byte, error) {
+	files, err := filesFromDisk(dir, o)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
+	}
+	files = slices.DeleteFunc(files, func(f archives.FileInfo) bool {
+		return !f.Mode().IsRegular() || f.Size() == 0
+	})
+	if len(files) == 0 {
+		return nil, nil
+	}
+	chunk := max(sampleSize/int64(len(files)), benchMinChunk)
+
+	var sample bytes.Buffer
+	for _, f := range files {
+		if int64(sample.Len()) >= sampleSize {
+			break
+		}
+		r, err := f.Open()
+		if err != nil {
+			logging("Not sampling %s: %v", f.NameInArchive, err)
+			continue
+		}
+		_, err = io.Copy(&sample, io.LimitReader(r, min(chunk, sampleSize-int64(sample.Len()))))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("sampling %s: %w", f.NameInArchive, err)
+		}
+	}
+	return sample.Bytes(), nil
+}
+
+// benchCodec compresses and decompresses sample with compression.
+func benchCodec(sample []byte, compression archives.Compression) (BenchResult, error) {
+	result := BenchResult{Raw: int64(len(sample))}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var compressed bytes.Buffer
+	start := time.Now()
+	if err := CompressStream(&compressed, bytes.NewReader(sample), compression); err != nil {
+		return result, err
+	}
+	result.CompressSpeed = speed(result.Raw, time.Since(start))
+	result.Compressed = int64(compressed.Len())
+	result.Ratio = float64(result.Compressed) / float64(result.Raw)
+
+	var decompressed countingWriter
+	start = time.Now()
+	if err := DecompressStream(&decompressed, &compressed, compression); err != nil {
+		return result, err
+	}
+	result.DecompressSpeed = speed(result.Raw, time.Since(start))
+	if decompressed.n != result.Raw {
+		return result, fmt.Errorf("decompressed %d bytes of %d", decompressed.n, result.Raw)
+	}
+
+	runtime.ReadMemStats(&after)
+	// the compressed copy is the caller's data, not the codec's
+	result.Memory = after.TotalAlloc - before.TotalAlloc - uint64(compressed.Cap())
+	return result, nil
+}
+
+// speed returns n bytes over d in bytes per second.
+func speed(n int64, d time.Duration) float64 {
+	return float64(n) / max(d.Seconds(), 1e-9)
+}
+
+// BenchGoal is what RecommendCodec optimizes for.
+type BenchGoal string
+
+const (
+	// BenchSmallest picks the smallest output, whatever the time it takes
+	BenchSmallest BenchGoal = "size"
+	// BenchFastest picks the fastest compression
+	BenchFastest BenchGoal = "speed"
+	// BenchBalanced picks the smallest output among the faster half of the
+	// codecs, those compressing at least as fast as the median
+	BenchBalanced BenchGoal = "balanced"
+)
+
+// RecommendCodec returns the result of Bench that best meets goal, false if
+// there is none.
+func RecommendCodec(results []BenchResult, goal BenchGoal) (BenchResult, bool) {
+	if len(results) == 0 {
+		return BenchResult{}, false
+	}
+	bySize := func(a, b BenchResult) int { return int(a.Compressed - b.Compressed) }
+	switch goal {
+	case BenchSmallest:
+		return slices.MinFunc(results, bySize), true
+	case BenchFastest:
+		return slices.MaxFunc(results, func(a, b BenchResult) int {
+			return cmpFloat(a.CompressSpeed, b.CompressSpeed)
+		}), true
+	case BenchBalanced:
+		speeds := make([]float64, len(results))
+		for i, r := range results {
+			speeds[i] = r.CompressSpeed
+		}
+		slices.Sort(speeds)
+		median := speeds[(len(speeds)-1)/2]
+		fast := slices.DeleteFunc(slices.Clone(results), func(r BenchResult) bool {
+			return r.CompressSpeed < median
+		})
+		return slices.MinFunc(fast, bySize), true
+	}
+	return BenchResult{}, false
+}
+
+// cmpFloat compares a and b like cmp.Compare.
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
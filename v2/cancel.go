@@ -0,0 +1,191 @@
+package arc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// WithContext ties archiving and extraction to ctx. Once it is canceled no
+// further entry is started, the entry in progress is aborted, see
+// WithCancelGrace, and the operation returns an error wrapping ctx.Err().
+// Partially written archives and files are removed, and the temp files,
+// file handles and goroutines of the operation are released before it
+// returns.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithCancelGrace lets the entry in progress finish for up to d after the
+// context of WithContext is canceled, so a large file isn't thrown away when
+// it is nearly done. No further entry is started either way. The default is
+// to abort right away.
+func WithCancelGrace(d time.Duration) Option {
+	return func(o *options) {
+		o.cancelGrace = d
+	}
+}
+
+// context returns the context of WithContext, or context.Background.
+func (o *options) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// operationContext returns the contexts of an operation: between is checked
+// before each entry, within while the content of an entry is copied, it is
+// canceled once the grace period is over. stop releases them once the
+// operation returns.
+func operationContext(o *options) (between, within context.Context, stop func()) {
+	between = o.context()
+	if o.cancelGrace <= 0 {
+		return between, between, func() {}
+	}
+
+	within, cancel := context.WithCancelCause(context.WithoutCancel(between))
+	stopGrace := context.AfterFunc(between, func() {
+		logging("Canceled, letting the current entry finish for %s", o.cancelGrace)
+		timer := time.NewTimer(o.cancelGrace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel(context.Cause(between))
+		case <-within.Done():
+		}
+	})
+	return between, within, func() {
+		stopGrace()
+		cancel(nil)
+	}
+}
+
+// canceled returns the error ending an operation whose context is done.
+func canceled(ctx context.Context) error {
+	return fmt.Errorf("canceled: %w", context.Cause(ctx))
+}
+
+// cancelFiles makes the files to archive fail to open once between is
+// canceled, and their reads once within is.
+func cancelFiles(between, within context.Context, files []archives.FileInfo) []archives.FileInfo {
+	if between.Done() == nil {
+		return files
+	}
+	for i, f := range files {
+		if f.Open == nil {
+			continue
+		}
+		open := f.Open
+		files[i].Open = func() (fs.File, error) {
+			if between.Err() != nil {
+				return nil, canceled(between)
+			}
+			file, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return &ctxFile{File: file, ctx: within}, nil
+		}
+	}
+	return files
+}
+
+// cancelHandler makes handler refuse entries once between is canceled, and
+// their reads fail once within is.
+func cancelHandler(between, within context.Context, handler archives.FileHandler) archives.FileHandler {
+	if between.Done() == nil {
+		return handler
+	}
+	return func(ctx context.Context, f archives.FileInfo) error {
+		if between.Err() != nil {
+			return canceled(between)
+		}
+		if open := f.Open; open != nil {
+			f.Open = func() (fs.File, error) {
+				file, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return &ctxFile{File: file, ctx: within}, nil
+			}
+		}
+		return handler(ctx, f)
+	}
+}
+
+// ctxFile fails its reads once ctx is canceled.
+type ctxFile struct {
+	fs.File
+	ctx context.Context
+}
+
+func (f *ctxFile) Read(b []byte) (int, error) {
+	if f.ctx.Err() != nil {
+		return 0, canceled(f.ctx)
+	}
+	return f.File.Read(b)
+}
+
+// ctxReader fails its reads once ctx is canceled.
+type ctxReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r ctxReader) Read(b []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, canceled(r.ctx)
+	}
+	return r.Reader.Read(b)
+}
+
+// ctxReadCloser is ctxReader for readers that have to be closed.
+type ctxReadCloser struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (r ctxReadCloser) Read(b []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, canceled(r.ctx)
+	}
+	return r.ReadCloser.Read(b)
+}
+
+// discarder is a writer of the Sink that can throw away what was written so
+// far, instead of keeping an incomplete file.
+type discarder interface {
+	Discard() error
+}
+
+// discardWriter discards w if it supports it, and closes it otherwise.
+func discardWriter(w io.WriteCloser) error {
+	if d, ok := w.(discarder); ok {
+		return d.Discard()
+	}
+	return w.Close()
+}
+
+// discardOutput removes the incomplete archive outfile, or its volumes, after
+// creating it failed. Streamed archives are left to the caller.
+func discardOutput(outfile string, o *options) {
+	if o.output != nil {
+		return
+	}
+	logging("Removing incomplete output file: %s", outfile)
+	if o.volumeSize() > 0 {
+		for _, volume := range SplitVolumes(outfile) {
+			os.Remove(volume)
+		}
+		return
+	}
+	os.Remove(outfile)
+}
@@ -0,0 +1,304 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// blockingReader serves the bytes of r, then blocks until ctx is canceled,
+// closing started once it does.
+type blockingReader struct {
+	r       io.Reader
+	ctx     context.Context
+	started chan struct{}
+	once    sync.Once
+}
+
+func newBlockingReader(ctx context.Context, r io.Reader) *blockingReader {
+	return &blockingReader{r: r, ctx: ctx, started: make(chan struct{})}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 || err != io.EOF {
+		return n, err
+	}
+	b.once.Do(func() { close(b.started) })
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func (b *blockingReader) Close() error {
+	return nil
+}
+
+// cancelBlocked cancels the operation once it is blocked on b and returns
+// its error, done receives it.
+func cancelBlocked(t *testing.T, b *blockingReader, cancel context.CancelFunc, done <-chan error) error {
+	t.Helper()
+	select {
+	case <-b.started:
+	case err := <-done:
+		t.Fatalf("operation returned before blocking: %v", err)
+	}
+	cancel()
+	return <-done
+}
+
+// resources is what an operation must have released once it returns.
+type resources struct {
+	fds        int
+	goroutines int
+}
+
+// openFDs counts the file descriptors of the process, -1 where /proc isn't
+// available.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func baseline() resources {
+	return resources{fds: openFDs(), goroutines: runtime.NumGoroutine()}
+}
+
+// checkReleased fails t if the file descriptors and goroutines don't get back
+// to base, or if temp files of arc remain in tmp. Goroutines that were told to
+// stop may take a moment to exit, so the counts are polled for a while.
+func checkReleased(t *testing.T, base resources, tmp string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		now := baseline()
+		released := now.goroutines <= base.goroutines && (base.fds < 0 || now.fds <= base.fds)
+		if released {
+			break
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("not released: %d fds and %d goroutines, %d and %d before\n%s",
+				now.fds, now.goroutines, base.fds, base.goroutines, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leftover, err := filepath.Glob(filepath.Join(tmp, "arc-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftover) > 0 {
+		t.Fatalf("temp files left: %v", leftover)
+	}
+}
+
+// checkCanceled fails t unless err is the cancellation of an operation.
+func checkCanceled(t *testing.T, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("canceled operation succeeded")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error doesn't wrap context.Canceled: %v", err)
+	}
+}
+
+// testTree writes a few files to a new directory and returns it.
+func testTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("arc cancellation test\n"), 1<<16)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// checkExtracted fails t if a file of dst isn't complete, partially
+// extracted files must be removed. Archives of src hold its base name.
+func checkExtracted(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		want, err := os.ReadFile(filepath.Join(filepath.Dir(src), rel))
+		if err != nil {
+			return fmt.Errorf("unexpected file %s: %w", rel, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("partially extracted %s left: %d bytes of %d", rel, len(got), len(want))
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveFromSourceCancel(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	out := filepath.Join(t.TempDir(), "out.tar.gz")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the second entry blocks halfway, until the operation is canceled
+	blocked := newBlockingReader(ctx, bytes.NewReader(make([]byte, 1<<16)))
+	entries := []struct {
+		name string
+		rc   io.ReadCloser
+	}{
+		{"first.txt", io.NopCloser(bytes.NewReader([]byte("first\n")))},
+		{"blocked.bin", blocked},
+	}
+	next := 0
+	src := SourceFunc(func() (EntryInfo, io.ReadCloser, error) {
+		if next == len(entries) {
+			t.Error("entry requested after cancellation")
+			return EntryInfo{}, nil, io.EOF
+		}
+		entry := entries[next]
+		next++
+		size := int64(6)
+		if entry.rc == blocked {
+			size = 1 << 17
+		}
+		return EntryInfo{Name: entry.name, Size: size, Mode: 0o644}, entry.rc, nil
+	})
+
+	base := baseline()
+	done := make(chan error, 1)
+	go func() {
+		done <- ArchiveFromSource(src, out, archives.Gz{Multithreaded: true}, archives.Tar{}, WithContext(ctx))
+	}()
+	checkCanceled(t, cancelBlocked(t, blocked, cancel, done))
+
+	if _, err := os.Lstat(out); !os.IsNotExist(err) {
+		t.Fatalf("partial archive left: %v", err)
+	}
+	checkReleased(t, base, tmp)
+}
+
+func TestArchiveCancel(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dir := testTree(t)
+	out := filepath.Join(t.TempDir(), "out.tar.gz")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// canceled from within, as soon as the second file is started
+	started := 0
+	progress := WithProgress(func(ev ProgressEvent) {
+		if ev.Kind == ProgressEntryStarted {
+			if started++; started == 2 {
+				cancel()
+			}
+		}
+	})
+
+	base := baseline()
+	checkCanceled(t, Archive(dir, out, archives.Gz{}, archives.Tar{}, WithContext(ctx), progress))
+	if _, err := os.Lstat(out); !os.IsNotExist(err) {
+		t.Fatalf("partial archive left: %v", err)
+	}
+	checkReleased(t, base, tmp)
+}
+
+func TestUnarchiveReaderCancel(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression archives.Compression
+		archival    archives.Archival
+		ext         string
+	}{
+		{"tar.gz", archives.Gz{Multithreaded: true}, archives.Tar{}, ".tar.gz"},
+		// zip streams are spooled to a temp file before being extracted
+		{"zip", nil, archives.Zip{}, ".zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testTree(t)
+			archive := filepath.Join(t.TempDir(), "in"+tt.ext)
+			if err := Archive(src, archive, tt.compression, tt.archival); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+			dst := filepath.Join(t.TempDir(), "dst")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// the stream stops halfway, until the operation is canceled
+			blocked := newBlockingReader(ctx, bytes.NewReader(data[:len(data)/2]))
+			base := baseline()
+			done := make(chan error, 1)
+			go func() {
+				done <- UnarchiveReader(blocked, dst, WithContext(ctx))
+			}()
+			checkCanceled(t, cancelBlocked(t, blocked, cancel, done))
+
+			checkExtracted(t, src, dst)
+			checkReleased(t, base, tmp)
+		})
+	}
+}
+
+func TestUnarchiveCancel(t *testing.T) {
+	src := testTree(t)
+	archive := filepath.Join(t.TempDir(), "in.tar.gz")
+	if err := Archive(src, archive, archives.Gz{}, archives.Tar{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dst := filepath.Join(t.TempDir(), "dst")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// canceled from within, as soon as the second file is started
+	started := 0
+	progress := WithProgress(func(ev ProgressEvent) {
+		if ev.Kind == ProgressEntryStarted {
+			if started++; started == 2 {
+				cancel()
+			}
+		}
+	})
+
+	base := baseline()
+	checkCanceled(t, Unarchive(archive, dst, WithContext(ctx), progress))
+	checkExtracted(t, src, dst)
+	checkReleased(t, base, tmp)
+}
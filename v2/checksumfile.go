@@ -0,0 +1,100 @@
+package arc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumExt is appended to the archive name to get its checksum file, see
+// WriteChecksumFile.
+const ChecksumExt = ".sha256"
+
+// ErrNoChecksum is returned by VerifyChecksumFile when the archive has no
+// checksum file.
+var ErrNoChecksum = errors.New("no checksum file")
+
+// WriteChecksumFile writes the SHA-256 of archive to archive + ChecksumExt,
+// in the format of sha256sum, "<hex>  <name>", so download pipelines can
+// check it with sha256sum -c next to the archive. It returns the SHA-256 as
+// hex.
+// archive: the archive to checksum
+func WriteChecksumFile(archive string) (string, error) {
+	sum, err := sha256File(archive)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archive))
+	if err := os.WriteFile(archive+ChecksumExt, []byte(line), 0o644); err != nil {
+		return "", fmt.Errorf("write checksum file: %w", err)
+	}
+	logging("Wrote SHA-256 of %s to %s", archive, archive+ChecksumExt)
+	return sum, nil
+}
+
+// VerifyChecksumFile checks archive against the SHA-256 in archive +
+// ChecksumExt, and returns ErrNoChecksum if there is no such file.
+// archive: the archive to verify
+func VerifyChecksumFile(archive string) error {
+	sumFile, err := os.Open(archive + ChecksumExt)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", archive, ErrNoChecksum)
+	}
+	if err != nil {
+		return fmt.Errorf("open checksum file: %w", err)
+	}
+	defer sumFile.Close()
+	want, err := ParseChecksumFile(sumFile, archive)
+	if err != nil {
+		return err
+	}
+	got, err := sha256File(archive)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%s: checksum mismatch: got sha256:%s, want sha256:%s", archive, got, want)
+	}
+	return nil
+}
+
+// ParseChecksumFile returns the SHA-256 of the file called name in r, a
+// checksum file in the format of sha256sum, as hex. A line for name is
+// looked for by its base name, a file of a single line is taken as the
+// checksum of name whatever the name on it, and a bare checksum is accepted
+// too.
+// r: the content of the checksum file
+// name: the file, or URL, whose checksum to return
+func ParseChecksumFile(r io.Reader, name string) (string, error) {
+	base := path.Base(filepath.ToSlash(name))
+	var sums []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		sum := strings.ToLower(strings.TrimPrefix(fields[0], "sha256:"))
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return "", fmt.Errorf("invalid checksum file: %q is not a SHA-256", fields[0])
+		}
+		if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[1], "*")) == base {
+			return sum, nil
+		}
+		sums = append(sums, sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read checksum file: %w", err)
+	}
+	if len(sums) != 1 {
+		return "", fmt.Errorf("checksum file has no SHA-256 for %s", base)
+	}
+	return sums[0], nil
+}
@@ -0,0 +1,74 @@
+// Command arc-sfx is the extractor stub of self-extracting archives created
+// with arc sfx. Built for the target platform, like
+//
+//	GOOS=windows GOARCH=amd64 go build ./cmd/arc-sfx
+//
+// it extracts the archive appended to it when run, then runs the post
+// command, so machines without arc, tar or zstd can unpack it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func main() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sfx, err := arc.OpenSelfExtractor(exe)
+	if errors.Is(err, arc.ErrNotSelfExtracting) {
+		log.Fatal("No archive embedded, this is the stub of self-extracting archives, see arc sfx")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sfx.Close()
+
+	destination := sfx.Config.Destination
+	if destination == "" {
+		destination = "."
+	}
+	directory := flag.String("C", destination, "Extract into this directory")
+	noPostCmd := flag.Bool("no-post-cmd", false, "Don't run the post command after extracting")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options]\n", os.Args[0])
+		fmt.Printf("Extracts the embedded %s", sfx.Config.Name)
+		if sfx.Config.PostCommand != "" {
+			fmt.Printf(", then runs %q in the destination", sfx.Config.PostCommand)
+		}
+		fmt.Println(".")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := sfx.Extract(*directory); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Extracted %s to %s\n", sfx.Config.Name, *directory)
+	if sfx.Config.PostCommand == "" || *noPostCmd {
+		return
+	}
+
+	log.Printf("Running post command: %s\n", sfx.Config.PostCommand)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", sfx.Config.PostCommand)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", sfx.Config.PostCommand)
+	}
+	cmd.Dir = *directory
+	cmd.Env = append(os.Environ(), "ARC_DESTINATION="+*directory)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Post command failed: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleAnalyze(cmd *flag.FlagSet, args []string) {
+	// Flags for the compressibility report
+	archiveFile := cmd.String("f", "", "Archive file to analyze (required)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc analyze [options]")
+		fmt.Println("Reports entropy (bits/byte) and compressed size per codec (% of original) for each entry.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+
+	results, err := arc.Analyze(*archiveFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	codecs := arc.AnalyzeCodecs()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "SIZE\tENTROPY\t%s\t  NAME\n", strings.ToUpper(strings.Join(codecs, "\t")))
+	for _, result := range results {
+		fmt.Fprintf(w, "%d\t%.2f\t", result.Size, result.Entropy)
+		for _, codec := range codecs {
+			if ratio, ok := result.Ratios[codec]; ok {
+				fmt.Fprintf(w, "%.1f%%\t", ratio*100)
+			} else {
+				fmt.Fprint(w, "-\t")
+			}
+		}
+		fmt.Fprintf(w, "  %s\n", result.Name)
+	}
+	w.Flush()
+}
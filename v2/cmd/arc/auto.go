@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// isAuto reports whether -c asks for the compression to be picked from a
+// sample of the sources.
+func isAuto(compressionType string) bool {
+	return strings.EqualFold(compressionType, "auto")
+}
+
+// autoCompression picks the compression of -c auto for source.
+func autoCompression(source string, archival archives.Archival, filter arc.FileFilter, opts []arc.Option) arc.AutoChoice {
+	if source == "-" {
+		log.Fatal("-c auto samples the sources, it can't read them from stdin")
+	}
+	choice, err := arc.AutoCompression(source, archival, filter, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if choice.Fallback() {
+		log.Printf("Warning: %s not available in this build, using %s\n", strings.Join(choice.Skipped, ", "), choice.Name)
+	}
+	infof("Compression: %s\n", choice)
+	return choice
+}
+
+// autoName returns name with the extension of its compression replaced by
+// that of compression, none if nil, so the name tells what -c auto picked.
+// Names ending in .tar get the extension, other names are kept as given.
+func autoName(name string, compression archives.Compression) string {
+	hasExt := func(ext string) bool {
+		return len(name) > len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext)
+	}
+	if name == "-" {
+		return name
+	}
+	named := hasExt(".tar")
+	names, _ := arc.AvailableFormats()
+	for _, n := range names {
+		known, _ := arc.LookupCompression(n)
+		if ext := known.Extension(); hasExt(ext) {
+			name, named = name[:len(name)-len(ext)], true
+			break
+		}
+	}
+	if named && compression != nil {
+		name += compression.Extension()
+	}
+	return name
+}
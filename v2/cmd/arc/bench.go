@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleBench(cmd *flag.FlagSet, args []string) {
+	// Flags for benchmarking codecs
+	sampleFlag := cmd.String("sample", "16M", "How much of the files to compress, from the start of each, like 64M")
+	codecsFlag := cmd.String("codecs", "", "Comma separated codecs to benchmark, like 'gz,zst,xz', all if empty")
+	recommendFlag := cmd.String("recommend", "", "Recommend a codec and level for 'size', 'speed' or 'balanced'")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc bench [options] <dir>")
+		fmt.Println("Compresses a sample of the files of dir with each codec at a few levels, and reports the")
+		fmt.Println("compressed size (% of original), speed and memory of each.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.NArg() != 1 {
+		fmt.Println("Error: A directory is required")
+		cmd.Usage()
+		return
+	}
+	sampleSize, err := parseSize(*sampleFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var codecs []string
+	if *codecsFlag != "" {
+		codecs = strings.Split(*codecsFlag, ",")
+	}
+	goal := arc.BenchGoal(*recommendFlag)
+	switch goal {
+	case "", arc.BenchSmallest, arc.BenchFastest, arc.BenchBalanced:
+	default:
+		log.Fatalf("invalid -recommend %q, use size, speed or balanced", *recommendFlag)
+	}
+
+	results, err := arc.Bench(cmd.Arg(0), sampleSize, codecs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(results) == 0 {
+		log.Fatal("no codec to benchmark")
+	}
+
+	infof("Sampled %s of %s\n", formatSize(results[0].Raw), cmd.Arg(0))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CODEC\tSIZE\tRATIO\tCOMPRESS\tDECOMPRESS\tMEMORY\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s/s\t%s/s\t%s\t\n", r.Name(), formatSize(r.Compressed), r.Ratio*100,
+			formatSize(int64(r.CompressSpeed)), formatSize(int64(r.DecompressSpeed)), formatSize(int64(r.Memory)))
+	}
+	w.Flush()
+
+	if goal != "" {
+		best, _ := arc.RecommendCodec(results, goal)
+		fmt.Printf("Recommended for %s: %s (-c %s", goal, best.Name(), best.Codec)
+		if best.Level != 0 {
+			fmt.Printf(" -level %d", best.Level)
+		}
+		fmt.Println(")")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// addCacheFlags adds the flags of the entry cache to cmd, and returns a
+// function giving their options once parsed.
+func addCacheFlags(cmd *flag.FlagSet) func() []arc.Option {
+	dir := cmd.String("cache-dir", "", "Keep the entries read in this directory, so reading them again skips decompressing the archive")
+	size := cmd.String("cache-size", "1G", "Size of the -cache-dir cache, the least recently used entries are evicted beyond it (e.g. 512M, 1G)")
+
+	return func() []arc.Option {
+		if *dir == "" {
+			return nil
+		}
+		maxSize, err := parseSize(*size)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cache, err := arc.NewEntryCache(*dir, maxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return []arc.Option{arc.WithEntryCache(cache)}
+	}
+}
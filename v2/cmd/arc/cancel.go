@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// addCancelFlags registers -cancel-grace on commands creating or extracting
+// archives, the returned function turns it into options that stop the
+// operation cleanly on Ctrl-C or SIGTERM once cmd has been parsed. A second
+// signal kills arc right away.
+func addCancelFlags(cmd *flag.FlagSet) func() []arc.Option {
+	grace := cmd.Duration("cancel-grace", 0, "When interrupted, let the current entry finish for up to this long, e.g. 10s")
+
+	return func() []arc.Option {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		context.AfterFunc(ctx, stop)
+		return []arc.Option{arc.WithContext(ctx), arc.WithCancelGrace(*grace)}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleCat(cmd *flag.FlagSet, args []string) {
+	// Flags for printing entries
+	archiveFile := cmd.String("f", "", "Archive file to read (required), - for stdin")
+	password := cmd.String("p", "", "Password of an encrypted ZIP archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+	cacheOptions := addCacheFlags(cmd)
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc cat [options] <path_in_archive>...")
+		fmt.Println("Writes the content of entries to stdout, in the order given, without extracting the archive.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+	if cmd.NArg() < 1 {
+		fmt.Println("Error: Path of the entry is required")
+		cmd.Usage()
+		return
+	}
+	if *archiveFile == "-" && cmd.NArg() > 1 {
+		log.Fatal("An archive streamed to stdin can only be read once, give a single entry")
+	}
+
+	opts := cacheOptions()
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+
+	extractEntry := arc.ExtractEntry
+	if *archiveFile == "-" {
+		// Read an archive streamed to stdin
+		extractEntry = func(_, name string, w io.Writer, opts ...arc.Option) error {
+			return arc.ExtractEntryReader(os.Stdin, name, w, opts...)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for _, name := range cmd.Args() {
+		if err := extractEntry(*archiveFile, name, out, opts...); err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// completion is registered here rather than in commands, which it lists
+func init() {
+	commands = append(commands, command{"completion", nil, "Print a shell completion script for bash, zsh, fish or powershell", handleCompletion})
+}
+
+// completionFlag is a flag of a command, as completion scripts need it.
+type completionFlag struct {
+	name  string
+	usage string
+	// takesValue is false for boolean flags
+	takesValue bool
+	// values are the known values of the flag, files are completed otherwise
+	values []string
+}
+
+// completionCommand is a command with its flags.
+type completionCommand struct {
+	command
+	flags []completionFlag
+}
+
+func handleCompletion(cmd *flag.FlagSet, args []string) {
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc completion bash|zsh|fish|powershell")
+		fmt.Println("Prints a completion script of subcommands, flags and the formats of this build, e.g.")
+		fmt.Println("  bash:       source <(arc completion bash)")
+		fmt.Println("  zsh:        arc completion zsh > \"${fpath[1]}/_arc\"")
+		fmt.Println("  fish:       arc completion fish > ~/.config/fish/completions/arc.fish")
+		fmt.Println("  powershell: arc completion powershell | Out-String | Invoke-Expression")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	globals := flagsOf("", flag.CommandLine)
+	var cmds []completionCommand
+	for _, c := range commands {
+		cmds = append(cmds, completionCommand{c, commandFlags(c)})
+	}
+
+	switch cmd.Arg(0) {
+	case "bash":
+		bashCompletion(os.Stdout, globals, cmds)
+	case "zsh":
+		zshCompletion(os.Stdout, globals, cmds)
+	case "fish":
+		fishCompletion(os.Stdout, globals, cmds)
+	case "powershell", "pwsh":
+		powershellCompletion(os.Stdout, globals, cmds)
+	default:
+		log.Fatalf("Unsupported shell: %s", cmd.Arg(0))
+	}
+}
+
+// commandFlags returns the flags of c. Commands define their flags when
+// they run, so c is run with -h on a flag set that panics instead of exiting
+// once they are defined, with its help discarded.
+func commandFlags(c command) (flags []completionFlag) {
+	if c.name == "completion" {
+		return nil
+	}
+	cmd := flag.NewFlagSet(c.name, flag.PanicOnError)
+	cmd.SetOutput(io.Discard)
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+		if recover() == nil {
+			log.Fatalf("Command %s ran instead of printing its help", c.name)
+		}
+		flags = flagsOf(c.name, cmd)
+	}()
+	c.run(cmd, []string{"-h"})
+	return nil
+}
+
+// flagsOf returns the flags defined in set for command.
+func flagsOf(command string, set *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	set.VisitAll(func(f *flag.Flag) {
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:       f.Name,
+			usage:      f.Usage,
+			takesValue: !ok || !boolFlag.IsBoolFlag(),
+			values:     flagValues(command, f.Name),
+		})
+	})
+	return flags
+}
+
+// flagValues returns the formats of this build that are values of the -c
+// and -t flags of command.
+func flagValues(command, name string) []string {
+	compressions, archivals := arc.AvailableFormats()
+	switch {
+	case name == "t" && command == "decompress":
+		return compressions
+	case name == "c" && command != "", name == "t" && command == "compress":
+		return slices.DeleteFunc(compressions, arc.DecompressOnly)
+	case name == "t" && command == "create":
+		return append(archivals, "none")
+	case name == "t" && command != "":
+		return archivals
+	case name == "engine":
+		return []string{"zopfli"}
+	case name == "method":
+		return []string{"store", "deflate", "bzip2", "zstd", "xz"}
+	case name == "zip64":
+		return []string{"auto", "force", "forbid"}
+	case name == "tar-format":
+		return []string{"auto", "ustar", "pax", "gnu"}
+	}
+	return nil
+}
+
+// names returns the name and aliases of c.
+func (c completionCommand) names() []string {
+	return append([]string{c.name}, c.aliases...)
+}
+
+func bashCompletion(w io.Writer, globals []completionFlag, cmds []completionCommand) {
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.names()...)
+	}
+
+	fmt.Fprintln(w, "# bash completion for arc, generated by arc completion bash")
+	fmt.Fprintln(w, "_arc() {")
+	fmt.Fprintln(w, `  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" flags="" i`)
+	fmt.Fprintln(w, `  for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `    case "${COMP_WORDS[i]}" in -*) ;; *) cmd="${COMP_WORDS[i]}"; break ;; esac`)
+	fmt.Fprintln(w, "  done")
+	fmt.Fprintln(w, `  case "${cmd}" in`)
+	fmt.Fprintln(w, `  "")`)
+	fmt.Fprintf(w, "    if [[ ${cur} != -* ]]; then\n      COMPREPLY=($(compgen -W %q -- \"${cur}\"))\n      return\n    fi\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "    flags=%q\n", bashFlags(globals))
+	fmt.Fprintln(w, "    ;;")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %s)\n", strings.Join(c.names(), "|"))
+		var valueCases []string
+		for _, f := range c.flags {
+			if len(f.values) > 0 {
+				valueCases = append(valueCases, fmt.Sprintf("    -%s | --%s) COMPREPLY=($(compgen -W %q -- \"${cur}\")); return ;;\n", f.name, f.name, strings.Join(f.values, " ")))
+			}
+		}
+		if len(valueCases) > 0 {
+			fmt.Fprintln(w, `    case "${prev}" in`)
+			fmt.Fprint(w, strings.Join(valueCases, ""))
+			fmt.Fprintln(w, "    esac")
+		}
+		if c.name == "completion" {
+			fmt.Fprintln(w, `    [[ ${cur} != -* ]] && COMPREPLY=($(compgen -W "bash zsh fish powershell" -- "${cur}")) && return`)
+		}
+		fmt.Fprintf(w, "    flags=%q\n", bashFlags(c.flags))
+		fmt.Fprintln(w, "    ;;")
+	}
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintln(w, `  if [[ ${cur} == -* ]]; then`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "${flags}" -- "${cur}"))`)
+	fmt.Fprintln(w, "  else")
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -f -- "${cur}"))`)
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _arc arc")
+}
+
+// bashFlags lists flags as words for compgen.
+func bashFlags(flags []completionFlag) string {
+	var words []string
+	for _, f := range flags {
+		words = append(words, "-"+f.name)
+	}
+	return strings.Join(words, " ")
+}
+
+func zshCompletion(w io.Writer, globals []completionFlag, cmds []completionCommand) {
+	fmt.Fprintln(w, "#compdef arc")
+	fmt.Fprintln(w, "# zsh completion for arc, generated by arc completion zsh")
+	fmt.Fprintln(w, "_arc() {")
+	fmt.Fprintln(w, `  local curcontext="$curcontext" state line`)
+	fmt.Fprintln(w, "  local -a commands")
+	fmt.Fprintln(w, "  commands=(")
+	for _, c := range cmds {
+		for _, name := range c.names() {
+			fmt.Fprintf(w, "    %s\n", zshQuote(name+":"+strings.ReplaceAll(c.summary, ":", `\:`)))
+		}
+	}
+	fmt.Fprintln(w, "  )")
+	fmt.Fprintln(w, "  _arguments -C \\")
+	for _, f := range globals {
+		fmt.Fprintf(w, "    %s \\\n", zshQuote(zshSpec(f)))
+	}
+	fmt.Fprintln(w, "    '1: :->command' \\")
+	fmt.Fprintln(w, "    '*:: :->args'")
+	fmt.Fprintln(w, "  case $state in")
+	fmt.Fprintln(w, "  command)")
+	fmt.Fprintln(w, "    _describe -t commands 'arc command' commands")
+	fmt.Fprintln(w, "    ;;")
+	fmt.Fprintln(w, "  args)")
+	fmt.Fprintln(w, "    case $words[1] in")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "    %s)\n", strings.Join(c.names(), "|"))
+		fmt.Fprintln(w, "      _arguments \\")
+		for _, f := range c.flags {
+			fmt.Fprintf(w, "        %s \\\n", zshQuote(zshSpec(f)))
+		}
+		if c.name == "completion" {
+			fmt.Fprintln(w, "        '1:shell:(bash zsh fish powershell)'")
+		} else {
+			fmt.Fprintln(w, "        '*:file:_files'")
+		}
+		fmt.Fprintln(w, "      ;;")
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "    ;;")
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_arc "$@"`)
+}
+
+// zshSpec returns the _arguments spec of f.
+func zshSpec(f completionFlag) string {
+	usage := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(f.usage)
+	spec := fmt.Sprintf("-%s[%s]", f.name, usage)
+	switch {
+	case len(f.values) > 0:
+		spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+	case f.takesValue:
+		spec += fmt.Sprintf(":%s:_files", f.name)
+	}
+	return spec
+}
+
+// zshQuote quotes s for zsh and other POSIX shells.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishCompletion(w io.Writer, globals []completionFlag, cmds []completionCommand) {
+	fmt.Fprintln(w, "# fish completion for arc, generated by arc completion fish")
+	for _, f := range globals {
+		fmt.Fprintf(w, "complete -c arc -n __fish_use_subcommand %s\n", fishSpec(f))
+	}
+	for _, c := range cmds {
+		for _, name := range c.names() {
+			fmt.Fprintf(w, "complete -c arc -n __fish_use_subcommand -f -a %s -d %s\n", name, zshQuote(c.summary))
+		}
+	}
+	for _, c := range cmds {
+		condition := zshQuote("__fish_seen_subcommand_from " + strings.Join(c.names(), " "))
+		if c.name == "completion" {
+			fmt.Fprintf(w, "complete -c arc -n %s -f -a 'bash zsh fish powershell'\n", condition)
+		}
+		for _, f := range c.flags {
+			fmt.Fprintf(w, "complete -c arc -n %s %s\n", condition, fishSpec(f))
+		}
+	}
+}
+
+// fishSpec returns the options of complete describing f.
+func fishSpec(f completionFlag) string {
+	spec := fmt.Sprintf("-o %s -d %s", f.name, zshQuote(f.usage))
+	switch {
+	case len(f.values) > 0:
+		spec += fmt.Sprintf(" -x -a %s", zshQuote(strings.Join(f.values, " ")))
+	case f.takesValue:
+		spec += " -r -F"
+	}
+	return spec
+}
+
+func powershellCompletion(w io.Writer, globals []completionFlag, cmds []completionCommand) {
+	fmt.Fprintln(w, "# PowerShell completion for arc, generated by arc completion powershell")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName arc, arc.exe -ScriptBlock {")
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $commands = [ordered]@{")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psQuote(c.summary))
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $aliases = @{")
+	for _, c := range cmds {
+		for _, alias := range c.aliases {
+			fmt.Fprintf(w, "        %s = %s\n", psQuote(alias), psQuote(c.name))
+		}
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $flags = @{")
+	fmt.Fprintf(w, "        '' = %s\n", psFlags(globals))
+	for _, c := range cmds {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psFlags(c.flags))
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $values = @{")
+	fmt.Fprintln(w, "        'completion' = @('bash', 'zsh', 'fish', 'powershell')")
+	for _, c := range cmds {
+		for _, f := range c.flags {
+			if len(f.values) > 0 {
+				fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name+" -"+f.name), psList(f.values))
+			}
+		}
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprint(w, `    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '') {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+    $command = @($words | Where-Object { $_ -notlike '-*' } | Select-Object -First 1)[0]
+    if ($command -and $aliases.ContainsKey($command)) {
+        $command = $aliases[$command]
+    }
+    $previous = if ($words.Count -gt 0) { $words[-1] -replace '^--', '-' } else { '' }
+
+    $candidates = @()
+    if (-not $command) {
+        if ($wordToComplete -like '-*') {
+            $candidates = $flags['']
+        } else {
+            $candidates = @($commands.Keys)
+        }
+    } elseif ($values.ContainsKey("$command $previous")) {
+        $candidates = $values["$command $previous"]
+    } elseif ($wordToComplete -like '-*') {
+        $candidates = $flags[$command]
+    } elseif ($command -eq 'completion') {
+        $candidates = $values['completion']
+    }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        $description = if ($commands.Contains($_)) { $commands[$_] } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $description)
+    }
+}
+`)
+}
+
+// psQuote quotes s as a PowerShell string literal.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// psList renders values as a PowerShell array.
+func psList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = psQuote(v)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+// psFlags renders the names of flags as a PowerShell array.
+func psFlags(flags []completionFlag) string {
+	var names []string
+	for _, f := range flags {
+		names = append(names, "-"+f.name)
+	}
+	slices.Sort(names)
+	return psList(names)
+}
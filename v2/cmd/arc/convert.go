@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+func handleConvert(cmd *flag.FlagSet, args []string) {
+	// Flags for the output format
+	compressionType := cmd.String("c", "", "Compression type of the output: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc., or a fallback list like zst,gz (default inferred from the output name, else zst)")
+	archivalType := cmd.String("t", "", "Archival type of the output: tar, zip, etc. (default inferred from the output name, else tar)")
+	level, withLevel := addLevelFlags(cmd, 0, "Compression level of the output: ZIP 0-9, gzip/bz2/lz4 1-9, zst 1-22, br 0-11, or max (default the format's default)")
+	useZopfli := addEngineFlag(cmd)
+	compressionMethod, zipOptions := addZipFlags(cmd, "ZIP compression method of the output: store, deflate, bzip2, zstd, xz or its number (default deflate)")
+	tarOptions := addTarFlags(cmd)
+	password := cmd.String("p", "", "Password of an encrypted ZIP input archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc convert [options] <input_archive> <output_archive>")
+		fmt.Println("The input archive may be - for stdin.")
+		fmt.Println("Converts an archive to the format of the output name, like arc convert in.zip out.tar.zst,")
+		fmt.Println("streaming the entries from one to the other without extracting them.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	if cmd.NArg() < 2 {
+		fmt.Println("Error: Input and output archives are required")
+		cmd.Usage()
+		return
+	}
+	input := cmd.Arg(0)
+	output := cmd.Arg(1)
+
+	var opts []arc.Option
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if useZopfli() {
+		opts = append(opts, arc.WithZopfli())
+	}
+
+	// Formats not given explicitly are inferred from the output name
+	compression, archival := resolveFormat(cmd, output, *compressionType, *archivalType)
+	if archival == nil {
+		log.Fatalf("%s is a single compressed file, convert needs an archive format like tar or zip", output)
+	}
+	if _, isZip := archival.(archives.Zip); isZip {
+		compression = nil
+		archival = archives.Zip{Compression: uint16(*compressionMethod)}
+		opts = append(opts, zipOptions()...)
+		if flagWasSet(cmd, "level") || flagWasSet(cmd, "q") {
+			opts = append(opts, arc.WithZipLevel(*level))
+		}
+	} else {
+		compression = withLevel(compression)
+	}
+	opts = append(opts, tarOptions()...)
+
+	transcode := arc.Transcode
+	if input == "-" {
+		// Convert an archive streamed to stdin
+		transcode = func(_, output string, compression archives.Compression, archival archives.Archival, opts ...arc.Option) error {
+			return arc.TranscodeReader(os.Stdin, output, compression, archival, opts...)
+		}
+	}
+	if err := transcode(input, output, compression, archival, opts...); err != nil {
+		log.Fatal(err)
+	}
+	infof("Archive converted: %s -> %s\n", input, output)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleDiff(cmd *flag.FlagSet, args []string) {
+	// Flags for comparing archives
+	jsonOutput := cmd.Bool("json", false, "Print each entry as a JSON object on its own line, with sizes and SHA-256")
+	all := cmd.Bool("a", false, "List identical entries too")
+	stripComponents := cmd.Int("strip-components", 0, "Remove this many leading path elements from the entries of archives")
+	password := cmd.String("p", "", "Password of encrypted ZIP archives (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc diff [options] <old> <new>")
+		fmt.Println("Compares two archives, or an archive and a directory, and lists the added, removed and changed")
+		fmt.Println("entries of <new>. Files are compared by size and SHA-256. Exits with 1 if anything differs, like diff.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.NArg() != 2 {
+		fmt.Println("Error: Two archives or directories are required")
+		cmd.Usage()
+		return
+	}
+
+	var opts []arc.Option
+	if *stripComponents > 0 {
+		opts = append(opts, arc.WithStripComponents(*stripComponents))
+	}
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+
+	diff, err := arc.Diff(cmd.Arg(0), cmd.Arg(1), opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	encoder := json.NewEncoder(out)
+	counts := make(map[arc.DiffStatus]int)
+	for _, entry := range diff {
+		counts[entry.Status]++
+		if entry.Status == arc.DiffIdentical && !*all {
+			continue
+		}
+		if *jsonOutput {
+			if err := encoder.Encode(newDiffEntry(entry)); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "%-9s %s\n", entry.Status, entry.Name)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	infof("%d added, %d removed, %d changed, %d identical\n",
+		counts[arc.DiffAdded], counts[arc.DiffRemoved], counts[arc.DiffChanged], counts[arc.DiffIdentical])
+	if counts[arc.DiffIdentical] != len(diff) {
+		os.Exit(1)
+	}
+}
+
+// diffEntry is an entry printed by arc diff -json.
+type diffEntry struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Type      string `json:"type"`
+	OldSize   *int64 `json:"old_size,omitempty"`
+	NewSize   *int64 `json:"new_size,omitempty"`
+	OldSHA256 string `json:"old_sha256,omitempty"`
+	NewSHA256 string `json:"new_sha256,omitempty"`
+}
+
+// newDiffEntry describes entry, with sizes only for the files of each side.
+func newDiffEntry(entry arc.EntryDiff) diffEntry {
+	d := diffEntry{
+		Name:      entry.Name,
+		Status:    string(entry.Status),
+		Type:      entry.Type,
+		OldSHA256: entry.OldSHA256,
+		NewSHA256: entry.NewSHA256,
+	}
+	if entry.OldSHA256 != "" {
+		d.OldSize = &entry.OldSize
+	}
+	if entry.NewSHA256 != "" {
+		d.NewSize = &entry.NewSize
+	}
+	return d
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// zipMethodCompressions stand in for the compression methods of zip entries
+// when estimating sizes, zip compresses each entry on its own.
+var zipMethodCompressions = map[int]archives.Compression{
+	0:  nil,
+	8:  archives.Gz{},
+	12: archives.Bz2{},
+	14: archives.Xz{},
+	93: archives.Zstd{},
+	95: archives.Xz{},
+}
+
+// estimateCompression returns the compression to estimate an archive with.
+func estimateCompression(compression archives.Compression, archival archives.Archival, zipMethod int) archives.Compression {
+	if _, isZip := archival.(archives.Zip); !isZip {
+		return compression
+	}
+	if c, ok := zipMethodCompressions[zipMethod]; ok {
+		return c
+	}
+	return archives.Gz{}
+}
+
+// estimateModelPath returns the model file to use for source, modelFile if
+// given.
+func estimateModelPath(source, modelFile string) string {
+	if modelFile != "" {
+		return modelFile
+	}
+	path, err := arc.DefaultEstimateModelPath(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+// printEstimate reports what archiving source, and the sources added by
+// opts, would produce without writing anything, and updates the estimate
+// model of source.
+func printEstimate(source string, compression archives.Compression, archival archives.Archival, zipMethod int, filter arc.FileFilter, modelFile string, opts ...arc.Option) {
+	modelPath := estimateModelPath(source, modelFile)
+	model, err := arc.LoadEstimateModel(modelPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	compression = estimateCompression(compression, archival, zipMethod)
+	estimate, err := arc.EstimateArchive(source, compression, archival, filter, append(opts, arc.WithEstimateModel(model))...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := model.Save(modelPath); err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range estimate.Names {
+		fmt.Println(name)
+	}
+	fmt.Printf("Entries:   %d\n", estimate.Entries)
+	fmt.Printf("Content:   %s\n", formatSize(estimate.Bytes))
+	fmt.Printf("Estimated: %s (sampled %s)\n", formatSize(estimate.Size), formatSize(estimate.SampledBytes))
+}
+
+// observeArchive records the size of the created archive in the estimate
+// model of source, if a dry run created one.
+func observeArchive(source, archiveFile string, compression archives.Compression, archival archives.Archival, zipMethod int, modelFile string) {
+	modelPath := estimateModelPath(source, modelFile)
+	info, err := os.Stat(archiveFile)
+	if _, statErr := os.Stat(modelPath); err != nil || statErr != nil {
+		return
+	}
+	model, err := arc.LoadEstimateModel(modelPath)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		return
+	}
+	model.Observe(estimateCompression(compression, archival, zipMethod), archival, info.Size())
+	if err := model.Save(modelPath); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+}
+
+// formatSize renders a byte count in binary units, like 1.5 MiB.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// filterFlags are the flags selecting which files are archived.
+type filterFlags struct {
+	include      stringList
+	exclude      stringList
+	excludeFrom  stringList
+	includeRegex *string
+	excludeRegex *string
+	maxSize      *string
+	minSize      *string
+	newer        *string
+	gitignore    *bool
+}
+
+// addFilterFlags registers the filter flags on cmd, build turns them into a
+// filter once cmd has been parsed.
+func addFilterFlags(cmd *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	cmd.Var(&f.include, "include", "Only archive files matching this glob pattern, e.g. '*.so' or 'src/**/*.go', can be repeated; regular expressions go to -include-regex")
+	cmd.Var(&f.exclude, "exclude", "Leave out files matching this glob pattern, e.g. 'node_modules/**', can be repeated; regular expressions go to -exclude-regex")
+	cmd.Var(&f.excludeFrom, "exclude-from", "Read exclude glob patterns from this file, one per line, can be repeated")
+	f.includeRegex = cmd.String("include-regex", "", "Only archive files whose name matches one of these regex patterns (comma separated)")
+	f.excludeRegex = cmd.String("exclude-regex", "", "Leave out files whose name matches one of these regex patterns (comma separated)")
+	f.maxSize = cmd.String("max-size", "", "Exclude files larger than this size (e.g. 50M, 50MB)")
+	f.minSize = cmd.String("min-size", "", "Exclude files smaller than this size (e.g. 1K, 1KB)")
+	f.newer = cmd.String("newer", "", "Only include files modified since this date (2006-01-02 or RFC 3339) or duration ago (e.g. 24h)")
+	f.gitignore = cmd.Bool("respect-gitignore", false, "Leave out files matched by .gitignore and .arcignore files in the tree, and .git directories, like git archive")
+	return f
+}
+
+// options returns the options of the filter flags that the library applies
+// itself.
+func (f *filterFlags) options() []arc.Option {
+	if *f.gitignore {
+		return []arc.Option{arc.WithIgnoreFiles()}
+	}
+	return nil
+}
+
+// regexSyntax matches what only regular expressions use: anchors, an
+// escaped dot and alternatives in parentheses. -include and -exclude took
+// regular expressions before they took globs, which match these literally,
+// so nothing in practice.
+var regexSyntax = regexp.MustCompile(`^\^|\$$|\\\.|\(.*\|.*\)`)
+
+// checkGlobs refuses patterns of the flag name that look like regular
+// expressions, pointing at the flag that takes them.
+func checkGlobs(name, regexFlag string, patterns []string) error {
+	for _, pattern := range patterns {
+		if regexSyntax.MatchString(pattern) {
+			return fmt.Errorf("-%s %q looks like a regular expression, but it takes glob patterns like '*.log'; use -%s for regular expressions", name, pattern, regexFlag)
+		}
+	}
+	return nil
+}
+
+// build combines the filter flags, it returns nil when none is set.
+func (f *filterFlags) build() (arc.FileFilter, error) {
+	var filters []arc.FileFilter
+	if err := checkGlobs("include", "include-regex", f.include); err != nil {
+		return nil, err
+	}
+	if err := checkGlobs("exclude", "exclude-regex", f.exclude); err != nil {
+		return nil, err
+	}
+	exclude := f.exclude
+	for _, name := range f.excludeFrom {
+		patterns, err := readPatterns(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkGlobs("exclude-from", "exclude-regex", patterns); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		exclude = append(exclude, patterns...)
+	}
+	if len(f.include) > 0 || len(exclude) > 0 {
+		filter, err := arc.GlobFilter(f.include, exclude)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if *f.includeRegex != "" {
+		filter, err := arc.IncludeFilesFilter(strings.Split(*f.includeRegex, ","))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, arc.NameFilter(filter))
+	}
+	if *f.excludeRegex != "" {
+		filter, err := arc.ExcludeFilesFilter(strings.Split(*f.excludeRegex, ","))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, arc.NameFilter(filter))
+	}
+	if *f.maxSize != "" {
+		size, err := parseSize(*f.maxSize)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, arc.MaxSizeFilter(size))
+	}
+	if *f.minSize != "" {
+		size, err := parseSize(*f.minSize)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, arc.MinSizeFilter(size))
+	}
+	if *f.newer != "" {
+		since, err := parseSince(*f.newer)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, arc.ModifiedSinceFilter(since))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return arc.CombineFilters(filters...), nil
+}
+
+// readPatterns reads the patterns of an exclude file, one per line, ignoring
+// empty lines and comments starting with #.
+func readPatterns(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open pattern file: %w", err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read pattern file %s: %w", name, err)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleFsck(cmd *flag.FlagSet, args []string) {
+	// Flags for checking archive stores
+	catalog := cmd.String("catalog", "", "Catalog recording the checks, to detect content changing without a new size or mtime (default <dir>/.arc-catalog.json)")
+	schedule := cmd.Duration("schedule", 0, "Keep running and check again at this interval, e.g. 24h, alerting through -webhook")
+	webhook := cmd.String("webhook", "", "URL to POST a JSON report to when archives are corrupted or missing")
+	password := cmd.String("p", "", "Password of encrypted ZIP archives (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc fsck [options] <dir>")
+		fmt.Println("Verifies every archive below <dir> like arc test, and against the SHA-256 recorded in the catalog")
+		fmt.Println("by earlier runs to detect silent storage corruption. Exits with status 1 if any archive is")
+		fmt.Println("corrupted or missing, unless -schedule is given.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.NArg() != 1 {
+		fmt.Println("Error: The directory of the archives is required")
+		cmd.Usage()
+		return
+	}
+	dir := cmd.Arg(0)
+	if *catalog == "" {
+		*catalog = filepath.Join(dir, ".arc-catalog.json")
+	}
+
+	var opts []arc.Option
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+
+	for {
+		failed := runFsck(dir, *catalog, *webhook, opts)
+		if *schedule <= 0 {
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*schedule)
+	}
+}
+
+// fsckReport is the JSON body posted to -webhook.
+type fsckReport struct {
+	Dir      string           `json:"dir"`
+	Checked  int              `json:"checked"`
+	Failures []arc.FsckResult `json:"failures"`
+}
+
+// runFsck checks the archives of dir once, and returns how many failed.
+func runFsck(dir, catalog, webhook string, opts []arc.Option) int {
+	results, err := arc.Fsck(dir, catalog, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := fsckReport{Dir: dir, Checked: len(results)}
+	for _, result := range results {
+		if !result.Failed() {
+			infof("Archive OK: %s\n", result.Name)
+			continue
+		}
+		report.Failures = append(report.Failures, result)
+		if result.Error != "" {
+			log.Printf("Archive %s: %s: %s\n", result.Status, result.Name, result.Error)
+		} else {
+			log.Printf("Archive %s: %s\n", result.Status, result.Name)
+		}
+	}
+	infof("%d archives checked, %d failed\n", len(results), len(report.Failures))
+	if len(report.Failures) > 0 && webhook != "" {
+		if err := postReport(webhook, report); err != nil {
+			log.Printf("Error sending the report to %s: %v", webhook, err)
+		}
+	}
+	return len(report.Failures)
+}
+
+// postReport sends report to the webhook url.
+func postReport(url string, report fsckReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
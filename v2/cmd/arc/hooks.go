@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// shellCommand returns a command running command with the shell of the
+// system, with env added to the environment.
+func shellCommand(command string, env []string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd
+}
+
+// runWithHooks runs the pre command, then op, then the post command with
+// the outcome of op in $ARC_STATUS and $ARC_ERROR. op doesn't run if the pre
+// command fails, the post command runs whether op failed or not. Empty
+// commands are skipped.
+func runWithHooks(preCmd, postCmd string, env []string, op func() error) error {
+	if preCmd != "" {
+		infof("Running pre command: %s\n", preCmd)
+		if err := shellCommand(preCmd, env).Run(); err != nil {
+			return fmt.Errorf("pre command failed, nothing was extracted: %w", err)
+		}
+	}
+
+	opErr := op()
+	if postCmd == "" {
+		return opErr
+	}
+	status, message := "success", ""
+	if opErr != nil {
+		status, message = "failure", opErr.Error()
+	}
+	infof("Running post command: %s\n", postCmd)
+	postErr := shellCommand(postCmd, append(env, "ARC_STATUS="+status, "ARC_ERROR="+message)).Run()
+	if postErr != nil {
+		postErr = fmt.Errorf("post command failed: %w", postErr)
+	}
+	return errors.Join(opErr, postErr)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// levelValue is a compression level flag, a number or max for the highest
+// level of the format.
+type levelValue int
+
+func (l *levelValue) String() string {
+	if l != nil && int(*l) == arc.MaxLevel {
+		return "max"
+	}
+	if l == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*l))
+}
+
+func (l *levelValue) Set(s string) error {
+	if s == "max" {
+		*l = levelValue(arc.MaxLevel)
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return errors.New("expected a level or max")
+	}
+	*l = levelValue(n)
+	return nil
+}
+
+// addLevelFlags adds -level, its alias -q and the per-format aliases to cmd.
+// The returned function sets the chosen level on a compression, which is
+// left alone when no level was given.
+func addLevelFlags(cmd *flag.FlagSet, defaultLevel int, usage string) (*int, func(archives.Compression) archives.Compression) {
+	level := levelValue(defaultLevel)
+	cmd.Var(&level, "level", usage)
+	cmd.Var(&level, "q", "Alias of -level")
+	zstdLevel := cmd.Int("zstd-level", 0, "Zstandard compression level (1-22), like zstd -19")
+	brotliQuality := cmd.Int("brotli-quality", 0, "Brotli quality (0-11), like brotli -q 11")
+
+	return (*int)(&level), func(compression archives.Compression) archives.Compression {
+		value, set := int(level), flagWasSet(cmd, "level") || flagWasSet(cmd, "q")
+		if flagWasSet(cmd, "zstd-level") {
+			if _, ok := compression.(archives.Zstd); !ok {
+				log.Fatalf("-zstd-level requires zstd compression, not %T", compression)
+			}
+			value, set = *zstdLevel, true
+		}
+		if flagWasSet(cmd, "brotli-quality") {
+			if _, ok := compression.(archives.Brotli); !ok {
+				log.Fatalf("-brotli-quality requires brotli compression, not %T", compression)
+			}
+			value, set = *brotliQuality, true
+		}
+		if !set {
+			return compression
+		}
+		compression, err := arc.CompressionLevel(compression, value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return compression
+	}
+}
+
+// addEngineFlag adds -engine to cmd. The returned function tells whether the
+// zopfli encoder was chosen.
+func addEngineFlag(cmd *flag.FlagSet) func() bool {
+	engine := cmd.String("engine", "", "Deflate encoder of gzip, zlib and ZIP output: zopfli for the smallest output, around 100 times slower to compress (default the standard encoder)")
+	return func() bool {
+		switch *engine {
+		case "":
+			return false
+		case "zopfli":
+			return true
+		}
+		log.Fatalf("Unknown engine %q, expected zopfli", *engine)
+		return false
+	}
+}
+
+// methodValue is a ZIP compression method flag, a name like store or the
+// number of the method.
+type methodValue int
+
+func (m *methodValue) String() string {
+	if m == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*m))
+}
+
+func (m *methodValue) Set(s string) error {
+	method, err := arc.ParseZipMethod(s)
+	if err != nil {
+		return err
+	}
+	*m = methodValue(method)
+	return nil
+}
+
+// zip64Modes are the values of -zip64.
+var zip64Modes = map[string]arc.Zip64Mode{
+	"auto":   arc.Zip64Auto,
+	"force":  arc.Zip64Force,
+	"forbid": arc.Zip64Forbid,
+}
+
+// addZipFlags adds -method, -store and -zip64 to cmd. The returned function
+// gives the options storing the extensions of -store and using Zip64 as
+// -zip64 says.
+func addZipFlags(cmd *flag.FlagSet, usage string) (*int, func() []arc.Option) {
+	method := methodValue(8)
+	cmd.Var(&method, "method", usage)
+	store := cmd.String("store", "", "Comma separated extensions of ZIP entries to store rather than compress, like .png,.mp4, or compressed for the usual compressed formats (images, videos, archives)")
+	zip64 := cmd.String("zip64", "auto", "Zip64 records of ZIP archives: auto where 4 GiB or 65535 entries are reached, force for all entries, or forbid to fail rather than need them")
+
+	return (*int)(&method), func() []arc.Option {
+		mode, ok := zip64Modes[*zip64]
+		if !ok {
+			log.Fatalf("Unknown -zip64 %q, expected auto, force or forbid", *zip64)
+		}
+		var opts []arc.Option
+		if mode != arc.Zip64Auto {
+			opts = append(opts, arc.WithZip64(mode))
+		}
+		if *store == "" {
+			return opts
+		}
+		var exts []string
+		for _, ext := range strings.Split(*store, ",") {
+			switch ext = strings.TrimSpace(ext); ext {
+			case "":
+			case "compressed":
+				exts = append(exts, arc.CompressedExtensions...)
+			default:
+				exts = append(exts, ext)
+			}
+		}
+		return append(opts, arc.WithZipStore(exts...))
+	}
+}
+
+// tarFormats are the values of -tar-format.
+var tarFormats = map[string]tar.Format{
+	"auto":  tar.FormatUnknown,
+	"ustar": tar.FormatUSTAR,
+	"pax":   tar.FormatPAX,
+	"gnu":   tar.FormatGNU,
+}
+
+// addTarFlags adds -tar-format and -xattrs to cmd. The returned function
+// gives the options writing tar headers as they say.
+func addTarFlags(cmd *flag.FlagSet) func() []arc.Option {
+	format := cmd.String("tar-format", "auto", "Header format of tar archives: auto for the first of ustar, pax and gnu holding each entry, ustar for picky extractors (fails on long names, files of 8 GiB and more and extended attributes), pax or gnu")
+	xattrs := cmd.Bool("xattrs", false, "Record extended attributes of files in tar archives, as PAX records")
+
+	return func() []arc.Option {
+		tarFormat, ok := tarFormats[*format]
+		if !ok {
+			log.Fatalf("Unknown -tar-format %q, expected auto, ustar, pax or gnu", *format)
+		}
+		var opts []arc.Option
+		if tarFormat != tar.FormatUnknown {
+			opts = append(opts, arc.WithTarFormat(tarFormat))
+		}
+		if *xattrs {
+			opts = append(opts, arc.WithXattrs())
+		}
+		return opts
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// limitSpec describes arc.Limits with sizes like 100M, as given by the flags
+// of addLimitFlags or in the -limits file of serve.
+type limitSpec struct {
+	MaxArchiveSize string   `json:"max_archive_size"`
+	MaxSize        string   `json:"max_size"`
+	MaxEntries     int64    `json:"max_entries"`
+	Formats        []string `json:"formats"`
+}
+
+// limits parses the sizes of spec.
+func (spec limitSpec) limits() (arc.Limits, error) {
+	limits := arc.Limits{MaxEntries: spec.MaxEntries, Formats: spec.Formats}
+	var err error
+	if spec.MaxArchiveSize != "" {
+		if limits.MaxArchiveSize, err = parseSize(spec.MaxArchiveSize); err != nil {
+			return limits, err
+		}
+	}
+	if spec.MaxSize != "" {
+		if limits.MaxSize, err = parseSize(spec.MaxSize); err != nil {
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+// addLimitFlags registers the flags limiting the archives read, for archives
+// of untrusted origin. The returned function turns them into arc.Limits once
+// cmd has been parsed, nil if none is set.
+func addLimitFlags(cmd *flag.FlagSet) func() *arc.Limits {
+	maxArchiveSize := cmd.String("max-archive-size", "", "Refuse archives larger than this (e.g. 100M), for untrusted archives")
+	maxSize := cmd.String("max-size", "", "Stop once the extracted files exceed this total size (e.g. 1G)")
+	maxEntries := cmd.Int64("max-entries", 0, "Stop once the archive has more entries than this (0 for no limit)")
+	formats := cmd.String("formats", "", "Only extract archives of these formats, by extension (comma separated, e.g. zip,tar.gz)")
+
+	return func() *arc.Limits {
+		if *maxArchiveSize == "" && *maxSize == "" && *maxEntries == 0 && *formats == "" {
+			return nil
+		}
+		spec := limitSpec{MaxArchiveSize: *maxArchiveSize, MaxSize: *maxSize, MaxEntries: *maxEntries}
+		if *formats != "" {
+			spec.Formats = strings.Split(*formats, ",")
+		}
+		limits, err := spec.limits()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return &limits
+	}
+}
+
+// errUnknownToken is the error of requests with a bearer token serve doesn't
+// know.
+var errUnknownToken = errors.New("unknown bearer token")
+
+// serveLimits are the limits of the archives serve reads: by bearer token,
+// by route, the longest prefix of the path of the request, or else the
+// defaults of the flags. Routes and tokens come from the -limits file:
+//
+//	{
+//	  "routes": {"/browse/uploads/": {"max_entries": 1000, "formats": ["zip", "tar.gz"]}},
+//	  "tokens": {"<token>": {"max_archive_size": "10G", "max_size": "50G"}}
+//	}
+type serveLimits struct {
+	defaults arc.Limits
+	routes   map[string]arc.Limits
+	tokens   map[string]arc.Limits
+}
+
+// loadServeLimits reads the routes and tokens of file, if any.
+func loadServeLimits(file string, defaults arc.Limits) (serveLimits, error) {
+	l := serveLimits{defaults: defaults}
+	if file == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return l, err
+	}
+	var config struct {
+		Routes map[string]limitSpec `json:"routes"`
+		Tokens map[string]limitSpec `json:"tokens"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return l, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	l.routes = make(map[string]arc.Limits, len(config.Routes))
+	for route, spec := range config.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return l, fmt.Errorf("%s: route %s doesn't start with /", file, route)
+		}
+		if l.routes[route], err = spec.limits(); err != nil {
+			return l, fmt.Errorf("%s: route %s: %w", file, route, err)
+		}
+	}
+	l.tokens = make(map[string]arc.Limits, len(config.Tokens))
+	for token, spec := range config.Tokens {
+		if token == "" {
+			return l, fmt.Errorf("%s: empty token", file)
+		}
+		if l.tokens[token], err = spec.limits(); err != nil {
+			return l, fmt.Errorf("%s: token: %w", file, err)
+		}
+	}
+	return l, nil
+}
+
+// forRequest returns the limits of r, errUnknownToken if it has a bearer
+// token other than those of the -limits file.
+func (l serveLimits) forRequest(r *http.Request) (arc.Limits, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" && len(l.tokens) > 0 {
+		token, isBearer := strings.CutPrefix(auth, "Bearer ")
+		if !isBearer {
+			return arc.Limits{}, errUnknownToken
+		}
+		// compared in constant time, not to give away the tokens
+		for known, limits := range l.tokens {
+			if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+				return limits, nil
+			}
+		}
+		return arc.Limits{}, errUnknownToken
+	}
+
+	limits, longest := l.defaults, -1
+	for route, routeLimits := range l.routes {
+		if strings.HasPrefix(r.URL.Path, route) && len(route) > longest {
+			limits, longest = routeLimits, len(route)
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+	// -tz works on systems without a zoneinfo database, like Windows
+	_ "time/tzdata"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+func handleList(cmd *flag.FlagSet, args []string) {
+	// Flags for listing
+	archiveFile := cmd.String("f", "", "Archive file to list (required), - for stdin")
+	password := cmd.String("p", "", "Password of an encrypted ZIP archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+	offset := cmd.Int("offset", 0, "Skip this many entries")
+	limit := cmd.Int("limit", 0, "Print at most this many entries (0 means all)")
+	long := cmd.Bool("l", false, "Long listing: mode, size, modification time and name of each entry")
+	jsonOutput := cmd.Bool("json", false, "Print each entry as a JSON object on its own line")
+	timeZone := cmd.String("tz", "Local", "Time zone modification times are shown in, like UTC or Europe/Berlin; arc stores them in UTC")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc list [options]")
+		fmt.Println("Prints the entries of an archive without extracting it.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+
+	var opts []arc.Option
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+
+	location, err := time.LoadLocation(*timeZone)
+	if err != nil {
+		log.Fatalf("Invalid time zone: %v", err)
+	}
+
+	if *offset < 0 || *limit < 0 {
+		log.Fatalf("Invalid offset or limit: %d, %d", *offset, *limit)
+	}
+
+	// Print entries as they are read, archives may hold millions of them
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	encoder := json.NewEncoder(out)
+	index, printed := 0, 0
+	walk := arc.Walk
+	if *archiveFile == "-" {
+		// List an archive streamed to stdin
+		walk = func(_ string, fn arc.WalkFunc, opts ...arc.Option) error {
+			return arc.WalkReader(os.Stdin, fn, opts...)
+		}
+	}
+	err = walk(*archiveFile, func(f archives.FileInfo) error {
+		defer func() { index++ }()
+		if index < *offset {
+			return nil
+		}
+		if *limit > 0 && printed == *limit {
+			return fs.SkipAll
+		}
+		printed++
+		switch {
+		case *jsonOutput:
+			return encoder.Encode(newListEntry(f, location))
+		case *long:
+			name := f.NameInArchive
+			if f.LinkTarget != "" {
+				name += " -> " + f.LinkTarget
+			}
+			_, err := fmt.Fprintf(out, "%s %12d %s %s\n", f.Mode(), f.Size(), f.ModTime().In(location).Format("2006-01-02 15:04:05"), name)
+			return err
+		}
+		_, err := fmt.Fprintln(out, f.NameInArchive)
+		return err
+	}, opts...)
+	if err != nil {
+		out.Flush()
+		log.Fatal(err)
+	}
+}
+
+// listEntry is an entry printed by arc list -json.
+type listEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	Link    string    `json:"link,omitempty"`
+}
+
+// newListEntry describes f, with its mtime in location.
+func newListEntry(f archives.FileInfo, location *time.Location) listEntry {
+	entryType := "file"
+	switch {
+	case f.IsDir():
+		entryType = "dir"
+	case f.Mode()&fs.ModeSymlink != 0:
+		entryType = "symlink"
+	case f.LinkTarget != "":
+		entryType = "hardlink"
+	case !f.Mode().IsRegular():
+		entryType = "other"
+	}
+	return listEntry{
+		Name:    f.NameInArchive,
+		Type:    entryType,
+		Size:    f.Size(),
+		Mode:    fmt.Sprintf("%04o", f.Mode().Perm()),
+		ModTime: f.ModTime().In(location),
+		Link:    f.LinkTarget,
+	}
+}
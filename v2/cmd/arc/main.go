@@ -1,33 +1,95 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
 )
 
-func main() {
-	// Define subcommands
-	archiveCommand := flag.NewFlagSet("archive", flag.ExitOnError)
-	extractCommand := flag.NewFlagSet("extract", flag.ExitOnError)
-	compressCommand := flag.NewFlagSet("compress", flag.ExitOnError)
-	decompressCommand := flag.NewFlagSet("decompress", flag.ExitOnError)
+// command is a subcommand of arc, with its own flag set and help.
+type command struct {
+	name    string
+	aliases []string
+	summary string
+	run     func(cmd *flag.FlagSet, args []string)
+}
+
+// commands lists the subcommands in the order printUsage shows them.
+var commands = []command{
+	{"create", []string{"archive"}, "Create an archive with optional compression", handleArchive},
+	{"extract", nil, "Extract an archive", handleExtract},
+	{"list", []string{"ls"}, "List the entries of an archive", handleList},
+	{"cat", nil, "Write the content of entries of an archive to stdout", handleCat},
+	{"restore", nil, "Undo the splitting, encryption, compression and archiving of a file", handleRestore},
+	{"test", []string{"verify"}, "Verify the integrity of an archive", handleTest},
+	{"fsck", nil, "Check a directory of archives for corruption, periodically with -schedule", handleFsck},
+	{"compress", nil, "Compress a single file", handleCompress},
+	{"decompress", nil, "Decompress a single file", handleDecompress},
+	{"convert", nil, "Convert an archive to another format", handleConvert},
+	{"diff", nil, "Compare two archives, or an archive and a directory", handleDiff},
+	{"precompress", nil, "Write compressed copies of web assets next to them, with a codec per extension", handlePrecompress},
+	{"watch", nil, "Create an archive of a directory again whenever its files change", handleWatch},
+	{"touch", nil, "Set key=value metadata embedded in an archive, in place", handleTouch},
+	{"preview", nil, "Browse an archive over HTTP without extracting it", handlePreview},
+	{"serve", nil, "Serve a directory of archives over HTTP, and its directories as archives", handleServe},
+	{"layer", nil, "Create a deterministic OCI image layer of a directory, with whiteouts against a base", handleLayer},
+	{"image", nil, "Extract the root file system of an OCI layout or docker save archive", handleImage},
+	{"sfx", nil, "Create a self-extracting executable from an archive", handleSfx},
+	{"keygen", nil, "Generate a minisign-compatible signing key pair", handleKeygen},
+	{"bench", nil, "Compare the ratio, speed and memory of each codec on a sample of a directory", handleBench},
+	{"analyze", nil, "Report entropy and compressibility of each entry", handleAnalyze},
+	{"sample", nil, "Extract a random sample of the files of an archive", handleSample},
+}
+
+// findCommand returns the subcommand called name or one of its aliases.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
 
+func main() {
 	// Set custom usage function to show our help message
 	flag.Usage = printUsage
 
 	// Global flags
-	verboseFlag := flag.Bool("v", false, "Verbose mode")
+	quietFlag := flag.Bool("q", false, "Quiet mode, only print warnings and errors")
+	verboseFlag := flag.Bool("v", false, "Verbose mode, print every file archived or extracted")
+	debugFlag := flag.Bool("vv", false, "Debug mode, like -v with the debug messages of the library")
+	logJSONFlag := flag.Bool("log-json", false, "Print messages and every file processed as JSON objects, one per line, on stderr")
+	versionFlag := flag.Bool("version", false, "Print the version, commit, build date and the formats compiled into this build")
 	flag.Parse()
 
-	// Enable verbose mode if -v is set
-	if *verboseFlag {
-		arc.DEBUG = true
+	if *versionFlag {
+		printVersion()
+		return
+	}
+
+	level := normal
+	switch {
+	case *debugFlag:
+		level = debug
+	case *verboseFlag:
+		level = verbose
+	case *quietFlag:
+		level = quiet
 	}
+	setupLogging(level, *logJSONFlag)
 
 	// Check if a subcommand is provided
 	if len(flag.Args()) < 1 {
@@ -35,54 +97,99 @@ func main() {
 		return
 	}
 
-	// Handle subcommands
-	switch flag.Args()[0] {
-	case "archive":
-		handleArchive(archiveCommand, flag.Args()[1:])
-	case "extract":
-		handleExtract(extractCommand, flag.Args()[1:])
-	case "compress":
-		handleCompress(compressCommand, flag.Args()[1:])
-	case "decompress":
-		handleDecompress(decompressCommand, flag.Args()[1:])
-	default:
+	// Handle subcommands, each parses its own flags
+	c, ok := findCommand(flag.Arg(0))
+	if !ok {
+		fmt.Printf("Error: Unknown command: %s\n", flag.Arg(0))
 		printUsage()
+		os.Exit(2)
 	}
+	c.run(flag.NewFlagSet(c.name, flag.ExitOnError), flag.Args()[1:])
 }
 
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  arc [options] <command> [command options]")
 	fmt.Println("\nGlobal Options:")
-	fmt.Println("  -v\tVerbose mode")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
 	fmt.Println("\nCommands:")
-	fmt.Println("  archive\tCreate an archive with optional compression")
-	fmt.Println("  extract\tExtract an archive")
-	fmt.Println("  compress\tCompress a single file")
-	fmt.Println("  decompress\tDecompress a single file")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, c := range commands {
+		summary := c.summary
+		if len(c.aliases) > 0 {
+			summary += fmt.Sprintf(" (alias: %s)", strings.Join(c.aliases, ", "))
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, summary)
+	}
+	w.Flush()
+	fmt.Println("\nEnvironment:")
+	fmt.Println("  ARC_KEY_PASSWORD\tPassword of the encrypted secret signing key")
+	fmt.Println("  ARC_PASSWORD\t\tPassword of encrypted zip archives, if -p and -password-file are unset")
+	fmt.Println("  ARC_OVERWRITE\t\tOverwrite policy of extract, if -overwrite, -force and -keep are unset")
+	fmt.Println("  ARC_BEARER_TOKEN\tBearer token for remote requests, if -bearer-token is unset")
+	fmt.Println("  HTTP(S)_PROXY\t\tProxy for remote requests, if -proxy is unset")
 	fmt.Println("\nFor help with a specific command, use:")
 	fmt.Println("  arc <command> -h")
 }
 
 func handleArchive(cmd *flag.FlagSet, args []string) {
 	// Flags for archive creation
-	compressionType := cmd.String("c", "zst", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc.")
-	archivalType := cmd.String("t", "tar", "Archival type: tar, zip, etc.")
-	archiveFile := cmd.String("f", "", "Archive file to create (required)")
-	includeFilter := cmd.String("include", "", "Include filter (regex pattern)")
-	excludeFilter := cmd.String("exclude", "", "Exclude filter (regex pattern)")
+	compressionType := cmd.String("c", "", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc., a fallback list like zst,gz for builds without some codecs, or auto to pick one from a sample of the sources (default inferred from -f, else zst)")
+	archivalType := cmd.String("t", "", "Archival type: tar, zip, 7z, cpio, ar, or none to compress a single file (default inferred from -f, else tar)")
+	archiveFile := cmd.String("f", "", "Archive file to create (required), - for stdout, its extension selects the format unless -c or -t is given; {date}, {time}, {datetime}, {unix} and {host} are replaced, like backup-{date}.tar.zst")
+	rotate := cmd.Int("rotate", 0, "Keep only the N newest archives named after the -f template, removing older ones once the archive is created")
+	directory := cmd.String("C", "", "Change to this directory for the sources, like tar -C; '-C build .' archives the content of build")
+	filesFrom := cmd.String("files-from", "", "Archive exactly the paths listed in this file, - for stdin, separated by NUL like find -print0 or by newlines; listed directories are stored without their content")
+	filterFlags := addFilterFlags(cmd)
+	progressOptions := addProgressFlags(cmd, "add")
+	cancelOptions := addCancelFlags(cmd)
+	compressionLevel, withLevel := addLevelFlags(cmd, 6, "Compression level: ZIP 0-9, gzip/bz2/lz4 1-9, zst 1-22, br 0-11, or max (default 6 for ZIP, else the format's default)")
+	useZopfli := addEngineFlag(cmd)
+	route := cmd.String("route", "", "Store files compressed with a codec per extension, named like app.wasm.br, e.g. '.wasm=br:11,.js=gz:9,.bin=zst:19'")
 	// New flags for ZIP compression
-	compressionLevel := cmd.Int("level", 6, "ZIP compression level (0-9, 0=none, 9=best)")
-	compressionMethod := cmd.Int("method", 8, "ZIP compression method, see https://github.com/mholt/archives/blob/main/zip.go")
+	compressionMethod, zipOptions := addZipFlags(cmd, "ZIP compression method: store, deflate, bzip2, zstd, xz or its number (default deflate)")
+	tarOptions := addTarFlags(cmd)
+	manifest := cmd.Bool("manifest", false, "Embed a SHA256SUMS manifest of all files in the archive")
+	manifestFile := cmd.String("manifest-file", "", "Write a SHA256SUMS manifest of all files to this path")
+	signKey := cmd.String("sign", "", "Sign the archive with this minisign secret key, creating <archive>.minisig")
+	checksum := cmd.String("checksum", "", "Write a checksum file next to the archive, in the format of sha256sum: sha256 for <archive>.sha256")
+	password := cmd.String("p", "", "Encrypt the ZIP archive with this password (AES-256)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	deterministic := cmd.Bool("deterministic", false, "Create a reproducible archive: sorted entries, fixed or SOURCE_DATE_EPOCH clamped mtimes, no owners")
+	splitSize := cmd.String("split", "", "Split the archive into volumes of this size (e.g. 100M, 100MB), named <archive>.part001...")
+	encryptTo := cmd.String("encrypt", "", "Encrypt the archive for these age recipients, SSH public keys or OpenPGP public key files (comma separated)")
+	rsyncable := cmd.Bool("rsyncable", false, "Make gzip or zstd output rsync friendly, so small changes only alter nearby compressed bytes")
+	seekable := cmd.Bool("seekable", false, "Write zstd output as independent frames with a seek table, so single entries are read without decompressing the archive before them")
+	trailer := cmd.Bool("checksum-trailer", false, "Append the SHA-256 of the archive to it, verified by arc when reading")
+	update := cmd.Bool("update", false, "Leave an existing archive alone when its entries match the sources by name, size and mtime, rewriting it only when something changed")
+	dryRun := cmd.Bool("dry-run", false, "List the entries that would be archived, after filters, with their size and the estimated archive size, without creating it")
+	estimateModel := cmd.String("estimate-model", "", "File remembering ratios and errors of -dry-run estimates of this source, to refine them (default in the user cache directory)")
+	allowedRoots := cmd.String("allowed-roots", "", "Refuse to write the archive outside of these directories (comma separated, e.g. /srv/backups,/tmp), a safeguard against a wrong destination")
+	profileName := cmd.String("profile", "", "Take the sources and flags not given on the command line from this profile of the profile file")
+	profileFile := cmd.String("profile-file", "", "File of -profile (default the first arc.yaml, arc.yml or .arcrc in the current directory or its parents, else ~/.arcrc)")
 
 	cmd.Usage = func() {
-		fmt.Println("Usage: arc archive [options] <source_directory>")
+		fmt.Println("Usage: arc create [options] <source>...")
+		fmt.Println("Sources are directories, files or quoted glob patterns like 'config/*.yaml', each stored under its base name; '.' stores the content of the current or -C directory.")
 		cmd.PrintDefaults()
 	}
 
 	if err := cmd.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	sourceArgs := cmd.Args()
+	if *profileName != "" {
+		p, dir, err := loadProfile(*profileFile, *profileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sourceArgs, err = p.apply(cmd, sourceArgs, dir); err != nil {
+			log.Fatal(err)
+		}
+	} else if *profileFile != "" {
+		log.Fatal("-profile-file requires -profile")
+	}
 
 	// Validate required flags
 	if *archiveFile == "" {
@@ -90,87 +197,554 @@ func handleArchive(cmd *flag.FlagSet, args []string) {
 		cmd.Usage()
 		return
 	}
+	template := *archiveFile
+	*archiveFile = arc.ExpandOutputName(template, time.Now())
+	if *rotate > 0 && *archiveFile == template {
+		log.Fatal("Rotation (-rotate) requires a -f template with a placeholder, like backup-{date}.tar.zst")
+	}
+	if *rotate < 0 {
+		log.Fatal("-rotate must be at least 1")
+	}
 
-	// Get source directory
-	if cmd.NArg() < 1 {
+	// Get sources, glob patterns are expanded here so they can be quoted
+	var fileList []string
+	if *filesFrom != "" {
+		if len(sourceArgs) > 0 {
+			log.Fatal("Sources can't be given both as arguments and with -files-from")
+		}
+		fileList = readFileList(*filesFrom)
+	} else if len(sourceArgs) < 1 {
 		fmt.Println("Error: Source directory is required")
 		cmd.Usage()
 		return
 	}
-	source := cmd.Arg(0)
+	sources, err := expandSources(*directory, sourceArgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the listed paths are stored relative to the current or -C directory
+	if fileList != nil {
+		sources = []string{"."}
+	}
+	source := sources[0]
 
-	// Handle ZIP format specifically due to its constraints
-	if strings.ToLower(*archivalType) == "zip" {
-		// Handle filters for ZIP format
-		var filter func(string) bool
-		var err error
-		if *includeFilter != "" {
-			filter, err = arc.IncludeFilesFilter(strings.Split(*includeFilter, ","))
-		} else if *excludeFilter != "" {
-			filter, err = arc.ExcludeFilesFilter(strings.Split(*excludeFilter, ","))
+	opts := append(progressOptions(), cancelOptions()...)
+	sourceOpts := filterFlags.options()
+	if fileList != nil {
+		sourceOpts = append(sourceOpts, arc.WithFileList(fileList...))
+	}
+	if *directory != "" {
+		sourceOpts = append(sourceOpts, arc.WithDirectory(*directory))
+		// the estimate model belongs to the source directory, not to -C
+		if *estimateModel == "" && source != "-" {
+			*estimateModel = estimateModelPath(filepath.Join(*directory, source), "")
 		}
-
+	}
+	if len(sources) > 1 {
+		if slices.Contains(sources, "-") {
+			log.Fatal("Reading the source from stdin (-) can't be combined with other sources")
+		}
+		sourceOpts = append(sourceOpts, arc.WithSources(sources[1:]...))
+	}
+	opts = append(opts, sourceOpts...)
+	if *archiveFile == "-" {
+		if *signKey != "" {
+			log.Fatal("Signing (-sign) requires an archive file, not stdout")
+		}
+		if *rotate > 0 {
+			log.Fatal("Rotation (-rotate) requires an archive file, not stdout")
+		}
+		if *update {
+			log.Fatal("-update compares against an existing archive file, not stdout")
+		}
+		if *checksum != "" {
+			log.Fatal("A checksum file (-checksum) requires an archive file, not stdout")
+		}
+		opts = append(opts, arc.WithOutputWriter(os.Stdout))
+	}
+	if *manifest {
+		opts = append(opts, arc.WithManifest())
+	}
+	if *manifestFile != "" {
+		opts = append(opts, arc.WithManifestFile(*manifestFile))
+	}
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *encryptTo != "" {
+		opts = append(opts, arc.WithEncryption(strings.Split(*encryptTo, ",")...))
+	}
+	if *deterministic {
+		opts = append(opts, arc.WithDeterministic())
+	}
+	if *allowedRoots != "" {
+		opts = append(opts, arc.WithAllowedRoots(strings.Split(*allowedRoots, ",")...))
+	}
+	if *seekable {
+		opts = append(opts, arc.WithSeekable())
+	}
+	if *rsyncable {
+		opts = append(opts, arc.WithRsyncable())
+	}
+	if *trailer {
+		opts = append(opts, arc.WithChecksumTrailer())
+	}
+	if *route != "" {
+		opts = append(opts, arc.WithRoutes(parseRoutes(*route)...))
+	}
+	if *checksum != "" && *checksum != "sha256" {
+		log.Fatalf("Unsupported checksum %q, only sha256 is supported", *checksum)
+	}
+	if *splitSize != "" {
+		if *signKey != "" {
+			log.Fatal("Signing (-sign) can't be combined with -split")
+		}
+		if *checksum != "" {
+			log.Fatal("A checksum file (-checksum) can't be combined with -split")
+		}
+		size, err := parseSize(*splitSize)
 		if err != nil {
 			log.Fatal(err)
 		}
+		opts = append(opts, arc.WithSplitSize(size))
+	}
+
+	// Handle filters
+	filter, err := filterFlags.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Formats not given explicitly are inferred from the archive name
+	compression, archival := resolveFormat(cmd, *archiveFile, *compressionType, *archivalType)
+	// levels don't apply to files -c auto stores
+	stored := false
+	if isAuto(*compressionType) && flagWasSet(cmd, "c") {
+		if _, is7z := archival.(arc.SevenZip); is7z {
+			log.Fatal("7z archives are compressed with LZMA2, -c auto applies to tar and zip")
+		}
+		choice := autoCompression(source, archival, filter, sourceOpts)
+		stored = choice.Store()
+		if _, isZip := archival.(archives.Zip); isZip {
+			if choice.Store() {
+				*compressionMethod = 0
+			}
+		} else {
+			if archival == nil && choice.Store() {
+				log.Fatalf("%s is already compressed, there is nothing to gain from compressing it", source)
+			}
+			compression = choice.Compression
+			*archiveFile = autoName(*archiveFile, compression)
+			template = autoName(template, compression)
+		}
+	}
+	if _, isZip := archival.(archives.Zip); !isZip && !stored {
+		compression = withLevel(compression)
+	}
+	zopfli := useZopfli()
+	if zopfli && archival != nil {
+		opts = append(opts, arc.WithZopfli())
+	}
+
+	if *dryRun {
+		printEstimate(source, compression, archival, *compressionMethod, filter, *estimateModel, sourceOpts...)
+		return
+	}
+
+	if source == "-" && archival != nil {
+		log.Fatal("Reading the source from stdin (-) requires compressing a single file, with -t none")
+	}
+	if *update {
+		if archival == nil {
+			log.Fatal("-update compares the entries of an archive, not a single compressed file")
+		}
+		if archiveUpToDate(source, *archiveFile, filter, opts) {
+			infof("Archive up to date: %s\n", *archiveFile)
+			return
+		}
+	}
+
+	// A compressed name without archival, like app.wasm.br, is a single compressed file
+	if archival == nil {
+		if len(sources) > 1 {
+			log.Fatalf("%s is a single compressed file, it can't hold %d sources", *archiveFile, len(sources))
+		}
+		if *allowedRoots != "" {
+			log.Fatal("-allowed-roots restricts where archives are written, it can't be combined with a single compressed file")
+		}
+		if *route != "" {
+			log.Fatal("Routes (-route) apply to the files of an archive, use -c for a single compressed file")
+		}
+		if *seekable {
+			if compression, err = arc.Seekable(compression); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *rsyncable {
+			if compression, err = arc.Rsyncable(compression); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if zopfli {
+			if compression, err = arc.Zopfli(compression); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *directory != "" && source != "-" && !filepath.IsAbs(source) {
+			source = filepath.Join(*directory, source)
+		}
+		compressFile(source, *archiveFile, compression)
+		writeChecksum(*archiveFile, *checksum)
+		signArchive(*archiveFile, *signKey)
+		rotateArchives(template, *rotate)
+		return
+	}
 
+	// Handle ZIP format specifically due to its constraints
+	opts = append(opts, tarOptions()...)
+	if _, isZip := archival.(archives.Zip); isZip {
 		// Use the new Zip function with custom compression options
+		opts = append(opts, zipOptions()...)
+		if flagWasSet(cmd, "level") || flagWasSet(cmd, "q") {
+			opts = append(opts, arc.WithZipLevel(*compressionLevel))
+		}
 		if filter != nil {
-			err = arc.ZipWithFilter(source, *archiveFile, *compressionLevel, *compressionMethod, filter)
+			err = arc.ZipWithFileFilter(source, *archiveFile, *compressionLevel, *compressionMethod, filter, opts...)
 		} else {
-			err = arc.Zip(source, *archiveFile, *compressionMethod)
+			err = arc.Zip(source, *archiveFile, *compressionMethod, opts...)
 		}
 
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("ZIP archive created: %s\n", *archiveFile)
+		infof("ZIP archive created: %s\n", *archiveFile)
+		observeArchive(source, *archiveFile, compression, archival, *compressionMethod, *estimateModel)
+		writeChecksum(*archiveFile, *checksum)
+		signArchive(*archiveFile, *signKey)
+		rotateArchives(template, *rotate)
 		return
 	}
 
-	// For other archive types, proceed with normal compression
-	compression, ok := arc.CompressionMap[strings.ToLower(*compressionType)]
-	if !ok {
-		log.Fatalf("Unsupported compression type: %s", *compressionType)
+	// Create archive
+	if filter != nil {
+		err = arc.ArchiveWithFileFilter(source, *archiveFile, compression, archival, filter, opts...)
+	} else {
+		err = arc.Archive(source, *archiveFile, compression, archival, opts...)
+	}
+
+	if err != nil {
+		log.Fatal(err)
 	}
+	infof("Archive created: %s\n", *archiveFile)
+	observeArchive(source, *archiveFile, compression, archival, *compressionMethod, *estimateModel)
+	writeChecksum(*archiveFile, *checksum)
+	signArchive(*archiveFile, *signKey)
+	rotateArchives(template, *rotate)
+}
 
-	archival, ok := arc.ArchivalMap[strings.ToLower(*archivalType)]
+// resolveFormat returns the compression and archival to create archiveFile
+// with: the format of its extension, unless -c or -t is given. A nil
+// archival, from -t none or a name like app.wasm.br, means a single
+// compressed file. The compression of -c auto is nil, to be picked by
+// autoCompression.
+func resolveFormat(cmd *flag.FlagSet, archiveFile, compressionType, archivalType string) (archives.Compression, archives.Archival) {
+	// the defaults when neither the flags nor the name tell
+	if compressionType == "" {
+		compressionType = "zst"
+	}
+	if archivalType == "" {
+		archivalType = "tar"
+	}
+	// rather than a tar.gz named like an .iso image
+	if _, _, err := arc.FormatFromName(archiveFile); errors.Is(err, arc.ErrReadOnly) && !flagWasSet(cmd, "t") {
+		log.Fatal(err)
+	}
+	if !flagWasSet(cmd, "c") && !flagWasSet(cmd, "t") {
+		if compression, archival, err := arc.FormatFromName(archiveFile); err == nil {
+			return compression, archival
+		}
+	}
+	// -c auto is resolved once the sources are known, the archival still
+	// follows the name
+	var compression archives.Compression
+	if !isAuto(compressionType) {
+		compression = selectCompression(compressionType)
+	} else if !flagWasSet(cmd, "t") {
+		if _, archival, err := arc.FormatFromName(archiveFile); err == nil {
+			return nil, archival
+		}
+	}
+	if strings.ToLower(archivalType) == "none" {
+		return compression, nil
+	}
+	archival, ok := arc.LookupArchival(archivalType)
 	if !ok {
-		log.Fatalf("Unsupported archival type: %s", *archivalType)
+		log.Fatalf("Unsupported archival type: %s", archivalType)
 	}
+	// 7z compresses with LZMA2 itself, and the members of ar archives, like
+	// those of a .deb, are compressed already: neither is compressed again
+	// unless asked
+	switch archival.(type) {
+	case arc.SevenZip, arc.Ar:
+		if !flagWasSet(cmd, "c") {
+			compression = nil
+		}
+	}
+	return compression, archival
+}
 
-	// Handle filters
-	var filter func(string) bool
-	var err error
-	if *includeFilter != "" {
-		filter, err = arc.IncludeFilesFilter(strings.Split(*includeFilter, ","))
-	} else if *excludeFilter != "" {
-		filter, err = arc.ExcludeFilesFilter(strings.Split(*excludeFilter, ","))
+// readFileList reads the paths of -files-from from name, - for stdin.
+func readFileList(name string) []string {
+	input := os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	paths, err := arc.ReadFileList(input)
+	if err != nil {
+		log.Fatal(err)
 	}
+	if len(paths) == 0 {
+		log.Fatalf("No paths to archive in -files-from %s", name)
+	}
+	return paths
+}
 
+// selectCompression returns the first available compression of the comma
+// separated list, warning when it had to fall back.
+func selectCompression(list string) archives.Compression {
+	choice, err := arc.SelectCompression(list)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if choice.Fallback() {
+		log.Printf("Warning: %s not available in this build, using %s\n", strings.Join(choice.Skipped, ", "), choice.Name)
+	}
+	return choice.Compression
+}
 
-	// Create archive
-	if filter != nil {
-		err = arc.ArchiveWithFilter(source, *archiveFile, compression, archival, filter)
-	} else {
-		err = arc.Archive(source, *archiveFile, compression, archival)
+// expandSources expands the glob patterns among args, relative to dir if
+// not empty, which fail when they match nothing. Existing paths are taken as
+// they are, even if they contain glob characters.
+func expandSources(dir string, args []string) ([]string, error) {
+	var sources []string
+	for _, arg := range args {
+		full := arg
+		if dir != "" && !filepath.IsAbs(arg) {
+			full = filepath.Join(dir, arg)
+		}
+		if _, err := os.Lstat(full); err == nil || arg == "-" || !strings.ContainsAny(arg, "*?[") {
+			sources = append(sources, arg)
+			continue
+		}
+		matches, err := filepath.Glob(full)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		for _, match := range matches {
+			if full != arg {
+				if match, err = filepath.Rel(dir, match); err != nil {
+					return nil, err
+				}
+			}
+			sources = append(sources, match)
+		}
+	}
+	return sources, nil
+}
+
+// flagWasSet reports whether the flag name was given on the command line.
+func flagWasSet(cmd *flag.FlagSet, name string) bool {
+	set := false
+	cmd.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// compressFile compresses the single file source to outfile, either can be
+// - for stdin and stdout.
+func compressFile(source, outfile string, compression archives.Compression) {
+	if info, err := os.Stat(source); source != "-" && err != nil {
+		log.Fatal(err)
+	} else if source != "-" && info.IsDir() {
+		log.Fatalf("%s is a single compressed file, the source must be a file, not a directory", outfile)
+	}
+	input := openInput(source)
+	defer input.Close()
+	output := createOutput(outfile)
+	if err := arc.CompressStream(output, input, compression); err != nil {
+		output.abort()
+		log.Fatalf("Error compressing file %s: %v", source, err)
+	}
+	output.finish()
+	infof("File compressed: %s -> %s\n", source, outfile)
+}
+
+// rotateArchives removes the archives named after template but the keep
+// newest ones, if keep is set.
+func rotateArchives(template string, keep int) {
+	if keep == 0 {
+		return
+	}
+	removed, err := arc.Rotate(template, keep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range removed {
+		infof("Old archive removed: %s\n", name)
 	}
+}
 
+// archiveUpToDate reports whether archiveFile already holds the sources, see
+// -update. Archives that can't be read, like those encrypted for someone
+// else, are rewritten.
+func archiveUpToDate(source, archiveFile string, filter arc.FileFilter, opts []arc.Option) bool {
+	upToDate, err := arc.ArchiveUpToDate(source, archiveFile, filter, opts...)
 	if err != nil {
+		log.Printf("Warning: rewriting %s: %v\n", archiveFile, err)
+		return false
+	}
+	return upToDate
+}
+
+// writeChecksum writes the checksum file of -checksum, if given.
+func writeChecksum(archiveFile, algorithm string) {
+	if algorithm == "" {
+		return
+	}
+	if _, err := arc.WriteChecksumFile(archiveFile); err != nil {
+		log.Fatal(err)
+	}
+	infof("Checksum written: %s%s\n", archiveFile, arc.ChecksumExt)
+}
+
+// signArchive creates a detached signature if a secret key was given.
+func signArchive(archiveFile, keyFile string) {
+	if keyFile == "" {
+		return
+	}
+	if err := arc.SignWithPassword(archiveFile, keyFile, os.Getenv("ARC_KEY_PASSWORD")); err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Archive created: %s\n", *archiveFile)
+	infof("Signature created: %s%s\n", archiveFile, arc.SignatureExt)
+}
+
+// parseSize parses a size like 1048576, 512K or 100MB. K, M and G are
+// powers of 1024, KB, MB and GB powers of 1000, as with split(1).
+func parseSize(size string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	}
+	upper := strings.ToUpper(size)
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, factor = strings.TrimSuffix(upper, unit.suffix), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return n * factor, nil
+}
+
+// parseSince parses a date, an RFC 3339 time or a duration before now.
+func parseSince(since string) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, since, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date or duration: %s", since)
+}
+
+// readPassword returns the archive password from the -p flag, the
+// -password-file flag or $ARC_PASSWORD, in that order.
+func readPassword(password, passwordFile string) string {
+	if password != "" {
+		return password
+	}
+	if passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			log.Fatalf("Error reading password file %s: %v", passwordFile, err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return strings.TrimSuffix(line, "\r")
+	}
+	return os.Getenv("ARC_PASSWORD")
+}
+
+// verifyArchiveSignature checks the detached signature if a public key was given.
+func verifyArchiveSignature(archiveFile, sigFile, pubKey string) {
+	if pubKey == "" {
+		return
+	}
+	if err := arc.VerifySignature(archiveFile, sigFile, pubKey); err != nil {
+		log.Fatal(err)
+	}
+	infof("Signature verified: %s\n", archiveFile)
 }
 
 func handleExtract(cmd *flag.FlagSet, args []string) {
 	// Flags for archive extraction
-	archiveFile := cmd.String("f", "", "Archive file to extract (required)")
+	archiveFile := cmd.String("f", "", "Archive file or http(s) URL to extract (required), - for stdin")
+	directory := cmd.String("C", "", "Extract into this directory, like tar -C (default the argument, else the current directory)")
+	linkCache := cmd.String("link-cache", "", "Store file contents in this content-addressed cache and link to them")
+	hardlink := cmd.Bool("hardlink", false, "Use hard links instead of symlinks with -link-cache")
+	verifyManifest := cmd.Bool("verify-manifest", false, "Verify the archive against its SHA256SUMS manifest before extracting")
+	manifestFile := cmd.String("manifest-file", "", "Verify against this SHA256SUMS file instead of the embedded manifest (implies -verify-manifest)")
+	pubKey := cmd.String("pubkey", "", "Verify the archive signature with this minisign public key (file or base64) before extracting")
+	sigFile := cmd.String("sig", "", "Signature file to verify with -pubkey (default <archive>.minisig)")
+	verifyChecksum := cmd.Bool("verify-checksum", false, "Verify the archive against its checksum file before extracting, <archive>.sha256 next to it or at the URL with .sha256 appended")
+	password := cmd.String("p", "", "Password of an encrypted ZIP archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+	overwrite := cmd.String("overwrite", "", "What to do with existing files: overwrite, skip-existing, error-if-exists, keep-both, newer-only or prompt (default $ARC_OVERWRITE, else prompt when run in a terminal and overwrite otherwise)")
+	force := cmd.Bool("force", false, "Overwrite existing files without asking, like -overwrite overwrite")
+	keep := cmd.Bool("keep", false, "Keep existing files without asking, like -overwrite skip-existing")
+	stripComponents := cmd.Int("strip-components", 0, "Remove this many leading path elements from extracted entries")
+	recursive := cmd.Bool("recursive", false, "Also extract the archives found among the extracted files, each into a directory named after it, and remove them")
+	recursiveDepth := cmd.Int("recursive-depth", 3, "Levels of archives within archives -recursive extracts")
+	packageMembers := cmd.Bool("package-members", false, "Extract the members of a .deb, its control and data tarballs, instead of the files the package installs")
+	syncDest := cmd.Bool("sync", false, "Make the destination a mirror of the archive, removing the files it doesn't have once extracted, like rsync --delete; requires an explicit destination")
+	preserve := cmd.Bool("preserve", false, "Restore symlinks, modification times and extended attributes, where the destination supports them")
+	strict := cmd.Bool("strict", false, "Fail instead of warning when -preserve can't restore something")
+	symlinks := cmd.String("symlinks", "skip", "With -preserve, what to do with symlinks the destination can't have: skip, copy (the target) or junction (Windows, copies files)")
+	onLocked := cmd.String("on-locked", "fail", "What to do with files in use by another process, like a running binary: fail, retry, rename (to <name>.old) or schedule-reboot-replace (Windows, as administrator)")
+	toCommand := cmd.String("to-command", "", "Pipe the content of each file to this shell command instead of writing it, with $ARC_FILENAME and $ARC_MODE set")
+	preCmd := cmd.String("pre-cmd", "", "Shell command to run before extracting, e.g. 'systemctl stop app'; extraction is aborted if it fails")
+	postCmd := cmd.String("post-cmd", "", "Shell command to run after extracting, even if it failed, with $ARC_STATUS set to success or failure and $ARC_ERROR to the error")
+	dryRun := cmd.Bool("dry-run", false, "List the paths that would be created, overwritten, renamed or skipped, without writing anything or running hooks")
+	diffDest := cmd.Bool("diff-dest", false, "Instead of extracting, report which files are new, changed (by SHA-256) or identical in the destination")
+	limitFlags := addLimitFlags(cmd)
+	allowedRoots := cmd.String("allowed-roots", "", "Refuse to write outside of these directories (comma separated, e.g. /srv/apps,/tmp), a safeguard against a wrong destination")
+	remoteOptions := addRemoteFlags(cmd)
+	progressOptions := addProgressFlags(cmd, "extract")
+	cancelOptions := addCancelFlags(cmd)
+	var mirrors stringList
+	cmd.Var(&mirrors, "mirror", "Fallback URL of the archive given with -f, tried in order, can be repeated; requires -sha256 or -verify-checksum")
 
 	cmd.Usage = func() {
-		fmt.Println("Usage: arc extract [options] <destination_directory>")
+		fmt.Println("Usage: arc extract [options] [destination_directory]")
 		cmd.PrintDefaults()
 	}
 
@@ -188,73 +762,359 @@ func handleExtract(cmd *flag.FlagSet, args []string) {
 	// Get destination directory
 	destination := "."
 	if cmd.NArg() > 0 {
+		if *directory != "" {
+			log.Fatal("Give the destination either with -C or as argument, not both")
+		}
 		destination = cmd.Arg(0)
+	} else if *directory != "" {
+		destination = *directory
+	}
+
+	policy, err := overwritePolicy(*overwrite, *force, *keep, *archiveFile != "-" && *toCommand == "" && !*dryRun && !*diffDest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockedPolicy, err := arc.ParseLockedPolicy(*onLocked)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []arc.Option{
+		arc.WithOverwrite(policy),
+		arc.WithOnLocked(lockedPolicy),
+		arc.WithStripComponents(*stripComponents),
+		arc.WithWarnings(func(err error) {
+			log.Printf("Warning: %v\n", err)
+		}),
+	}
+	if policy == arc.Prompt {
+		opts = append(opts, arc.WithOverwritePrompt(overwritePrompt(os.Stdin)))
+	}
+	opts = append(opts, progressOptions()...)
+	opts = append(opts, cancelOptions()...)
+	if *preserve {
+		opts = append(opts, arc.WithPreserve())
+	}
+	if *strict {
+		opts = append(opts, arc.WithStrict())
+	}
+	fallback, err := arc.ParseSymlinkFallback(*symlinks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, arc.WithSymlinkFallback(fallback))
+	if *linkCache != "" {
+		mode := arc.LinkSymlink
+		if *hardlink {
+			mode = arc.LinkHardlink
+		}
+		opts = append(opts, arc.WithLinkFarm(*linkCache, mode))
+	}
+	if *verifyManifest || *manifestFile != "" {
+		opts = append(opts, arc.WithVerifyManifest(), arc.WithManifestFile(*manifestFile))
+	}
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+	if limits := limitFlags(); limits != nil {
+		opts = append(opts, arc.WithLimits(*limits))
+	}
+	if *allowedRoots != "" {
+		opts = append(opts, arc.WithAllowedRoots(strings.Split(*allowedRoots, ",")...))
 	}
+	if *recursive {
+		if *toCommand != "" {
+			log.Fatal("Recursive extraction (-recursive) writes nested archives to a directory, it can't be combined with -to-command")
+		}
+		opts = append(opts, arc.WithRecursive(*recursiveDepth))
+	}
+	if *syncDest {
+		if *toCommand != "" {
+			log.Fatal("Sync (-sync) mirrors the archive to a directory, it can't be combined with -to-command")
+		}
+		if cmd.NArg() == 0 && *directory == "" {
+			log.Fatal("Sync (-sync) removes what the archive doesn't have from the destination, give it explicitly")
+		}
+		opts = append(opts, arc.WithSync())
+	}
+
+	if *dryRun || *diffDest {
+		if *archiveFile == "-" || arc.IsURL(*archiveFile) || *toCommand != "" {
+			log.Fatal("A dry run (-dry-run or -diff-dest) requires a local archive extracted to a directory")
+		}
+		if *diffDest {
+			printDiff(*archiveFile, destination, opts...)
+			return
+		}
+		printPlan(*archiveFile, destination, opts...)
+		return
+	}
+
+	// Pick how to extract, everything that can fail early is checked before
+	// the hooks run
+	var extract func() error
+	switch {
+	case *archiveFile == "-":
+		// Extract an archive streamed to stdin
+		if *pubKey != "" || *toCommand != "" || len(mirrors) > 0 || *verifyChecksum {
+			log.Fatal("Signature verification (-pubkey), piping to a command (-to-command), mirrors (-mirror) and checksum files (-verify-checksum) require an archive file, not stdin")
+		}
+		extract = func() error {
+			return arc.UnarchiveReader(os.Stdin, destination, opts...)
+		}
+	case arc.IsURL(*archiveFile):
+		// Extract a remote archive while downloading it
+		if *pubKey != "" {
+			log.Fatal("Signature verification (-pubkey) requires a local archive")
+		}
+		if *toCommand != "" {
+			log.Fatal("Piping to a command (-to-command) requires a local archive")
+		}
+		opts = append(opts, remoteOptions()...)
+		if *verifyChecksum {
+			if flagWasSet(cmd, "sha256") {
+				log.Fatal("Give the checksum either with -sha256 or -verify-checksum, not both")
+			}
+			opts = append(opts, remoteChecksum(*archiveFile, opts))
+		} else if len(mirrors) > 0 && !flagWasSet(cmd, "sha256") {
+			log.Fatal("Mirrors (-mirror) require the checksum of the archive, with -sha256 or -verify-checksum, so that they serve the same file")
+		}
+		extract = func() error {
+			if len(mirrors) > 0 {
+				return arc.UnarchiveMirrors(append([]string{*archiveFile}, mirrors...), destination, opts...)
+			}
+			return arc.UnarchiveURL(*archiveFile, destination, opts...)
+		}
+	default:
+		if len(mirrors) > 0 {
+			log.Fatal("Mirrors (-mirror) require a URL archive")
+		}
+		if *verifyChecksum {
+			if err := arc.VerifyChecksumFile(*archiveFile); err != nil {
+				log.Fatal(err)
+			}
+			infof("Checksum verified: %s\n", *archiveFile)
+		}
+		verifyArchiveSignature(*archiveFile, *sigFile, *pubKey)
+		extract = func() error {
+			// Stream entries to a command
+			if *toCommand != "" {
+				return arc.UnarchiveToSink(*archiveFile, &arc.CommandSink{Command: *toCommand}, opts...)
+			}
+			// .deb and .rpm packages are extracted to the files they install
+			if !*packageMembers && arc.IsPackage(*archiveFile) {
+				return arc.ExtractPackage(*archiveFile, destination, opts...)
+			}
+			return arc.Unarchive(*archiveFile, destination, opts...)
+		}
+	}
+
+	hookEnv := []string{"ARC_ARCHIVE=" + *archiveFile, "ARC_DESTINATION=" + destination}
+	if err := runWithHooks(*preCmd, *postCmd, hookEnv, extract); err != nil {
+		log.Fatal(err)
+	}
+	if *toCommand == "" {
+		infof("Archive extracted to: %s\n", destination)
+	}
+}
+
+// printPlan lists what extracting archive to destination would do, and
+// exits with an error if the extraction would fail.
+func printPlan(archive, destination string, opts ...arc.Option) {
+	plan, err := arc.PlanUnarchive(archive, destination, opts...)
+	for _, entry := range plan {
+		fmt.Printf("%-9s %s\n", entry.Action, entry.Path)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(plan) > 0 && plan[len(plan)-1].Action == arc.PlanFail {
+		log.Fatalf("Extraction would fail, %s exists", plan[len(plan)-1].Path)
+	}
+}
 
-	// Extract archive
-	err := arc.Unarchive(*archiveFile, destination)
+// printDiff lists how the entries of archive compare to destination, and
+// sums them up.
+func printDiff(archive, destination string, opts ...arc.Option) {
+	diff, err := arc.DiffDestination(archive, destination, opts...)
+	counts := make(map[arc.DiffStatus]int)
+	for _, entry := range diff {
+		fmt.Printf("%-9s %s\n", entry.Status, entry.Path)
+		counts[entry.Status]++
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Archive extracted to: %s\n", destination)
+	infof("%d new, %d changed, %d identical\n", counts[arc.DiffNew], counts[arc.DiffChanged], counts[arc.DiffIdentical])
 }
 
 func handleCompress(cmd *flag.FlagSet, args []string) {
 	// Flags for file compression
-	inputFile := cmd.String("i", "", "Input file to compress (required)")
-	outputFile := cmd.String("o", "", "Output file (required)")
-	compressionType := cmd.String("t", "zst", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc.")
+	inputFile := cmd.String("i", "-", "Input file to compress, - for stdin, or the first argument")
+	outputFile := cmd.String("o", "-", "Output file, - for stdout")
+	compressionType := cmd.String("t", "", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc., or a fallback list like zst,gz (default inferred from -o, else zst)")
+	cmd.StringVar(compressionType, "c", "", "Alias of -t")
+	rsyncable := cmd.Bool("rsyncable", false, "Make gzip or zstd output rsync friendly, so small changes only alter nearby compressed bytes")
+	seekable := cmd.Bool("seekable", false, "Write zstd output as independent frames with a seek table")
+	_, withLevel := addLevelFlags(cmd, 0, "Compression level: gzip/bz2/lz4 1-9, zst 1-22, br 0-11, or max (default the format's default)")
+	useZopfli := addEngineFlag(cmd)
 
 	cmd.Usage = func() {
-		fmt.Println("Usage: arc compress [options]")
+		fmt.Println("Usage: arc compress [options] [input_file]")
+		fmt.Println("Compresses a single file without any tar or zip container.")
 		cmd.PrintDefaults()
 	}
 
 	if err := cmd.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	if cmd.NArg() > 0 && !flagWasSet(cmd, "i") {
+		*inputFile = cmd.Arg(0)
+	}
 
-	// Validate required flags
-	if *inputFile == "" || *outputFile == "" {
-		fmt.Println("Error: Input (-i) and output (-o) files are required")
-		cmd.Usage()
-		return
+	// Get compression type, from the output name if not given
+	if *compressionType == "" {
+		*compressionType = "zst"
+	}
+	compression := selectCompression(*compressionType)
+	if !flagWasSet(cmd, "t") && !flagWasSet(cmd, "c") {
+		if inferred, archival, err := arc.FormatFromName(*outputFile); err == nil && archival == nil {
+			compression = inferred
+		}
+	}
+	compression = withLevel(compression)
+	if *seekable {
+		var err error
+		if compression, err = arc.Seekable(compression); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *rsyncable {
+		var err error
+		if compression, err = arc.Rsyncable(compression); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if useZopfli() {
+		var err error
+		if compression, err = arc.Zopfli(compression); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// Get compression type
-	compression, ok := arc.CompressionMap[strings.ToLower(*compressionType)]
-	if !ok {
-		log.Fatalf("Unsupported compression type: %s", *compressionType)
+	compressFile(*inputFile, *outputFile, compression)
+}
+
+func handleDecompress(cmd *flag.FlagSet, args []string) {
+	// Flags for file decompression
+	inputFile := cmd.String("i", "-", "Input compressed file, - for stdin")
+	outputFile := cmd.String("o", "-", "Output file, - for stdout")
+	compressionType := cmd.String("t", "", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc. (default detected)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc decompress [options]")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	inputReader := openInput(*inputFile)
+	defer inputReader.Close()
+	var input io.Reader = inputReader
+
+	// Get compression type, from the content if not given
+	var compression archives.Compression
+	if *compressionType != "" {
+		var ok bool
+		compression, ok = arc.LookupCompression(*compressionType)
+		if !ok {
+			log.Fatalf("Unsupported compression type: %s", *compressionType)
+		}
+	} else {
+		var err error
+		if compression, input, err = arc.IdentifyCompression(*inputFile, input); err != nil {
+			log.Fatal(err)
+		}
+	}
+	output := createOutput(*outputFile)
+
+	// Decompress data
+	if err := arc.DecompressStream(output, input, compression); err != nil {
+		output.abort()
+		log.Fatalf("Error decompressing file %s: %v", *inputFile, err)
 	}
+	output.finish()
+
+	infof("File decompressed: %s -> %s\n", *inputFile, *outputFile)
+}
 
-	// Read input file
-	data, err := os.ReadFile(*inputFile)
+// openInput opens the file name for reading, or stdin for "-".
+func openInput(name string) io.ReadCloser {
+	if name == "-" {
+		return io.NopCloser(os.Stdin)
+	}
+	f, err := os.Open(name)
 	if err != nil {
-		log.Fatalf("Error reading file %s: %v", *inputFile, err)
+		log.Fatalf("Error reading file %s: %v", name, err)
 	}
+	return f
+}
 
-	// Compress data
-	out, err := arc.Compress(data, compression)
+// outputFile is a file being written by the CLI, or stdout.
+type outputFile struct {
+	*os.File
+	name string
+}
+
+// createOutput creates the file name, or returns stdout for "-".
+func createOutput(name string) *outputFile {
+	if name == "-" {
+		return &outputFile{File: os.Stdout, name: name}
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
-		log.Fatalf("Error compressing file %s: %v", *inputFile, err)
+		log.Fatalf("Error writing to file %s: %v", name, err)
 	}
+	return &outputFile{File: f, name: name}
+}
 
-	// Write output file
-	if err := os.WriteFile(*outputFile, out, 0o644); err != nil {
-		log.Fatalf("Error writing to file %s: %v", *outputFile, err)
+// finish closes the output, reporting write errors.
+func (o *outputFile) finish() {
+	if o.name == "-" {
+		return
+	}
+	if err := o.Close(); err != nil {
+		log.Fatalf("Error writing to file %s: %v", o.name, err)
 	}
+}
 
-	log.Printf("File compressed: %s -> %s\n", *inputFile, *outputFile)
+// abort closes and removes an incomplete output file.
+func (o *outputFile) abort() {
+	if o.name == "-" {
+		return
+	}
+	o.Close()
+	os.Remove(o.name)
 }
 
-func handleDecompress(cmd *flag.FlagSet, args []string) {
-	// Flags for file decompression
-	inputFile := cmd.String("i", "", "Input compressed file (required)")
-	outputFile := cmd.String("o", "", "Output file (required)")
-	compressionType := cmd.String("t", "", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc. (required)")
+func handleTest(cmd *flag.FlagSet, args []string) {
+	// Flags for archive verification
+	archiveFile := cmd.String("f", "", "Archive file to verify, - for stdin, more can be given as arguments")
+	pubKey := cmd.String("pubkey", "", "Also verify the archive signature with this minisign public key (file or base64)")
+	sigFile := cmd.String("sig", "", "Signature file to verify with -pubkey (default <archive>.minisig)")
+	stripTrailer := cmd.Bool("strip-trailer", false, "Remove the checksum trailer once the archive is verified, for other tools to read it")
+	password := cmd.String("p", "", "Password of an encrypted ZIP archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
 
 	cmd.Usage = func() {
-		fmt.Println("Usage: arc decompress [options]")
+		fmt.Println("Usage: arc test [options] [archive]...")
+		fmt.Println("Reads every entry of the archives end-to-end, checking the checksums of their format,")
+		fmt.Println("checksum trailer and embedded manifest, and exits with status 1 if any is corrupted.")
 		cmd.PrintDefaults()
 	}
 
@@ -263,34 +1123,87 @@ func handleDecompress(cmd *flag.FlagSet, args []string) {
 	}
 
 	// Validate required flags
-	if *inputFile == "" || *outputFile == "" || *compressionType == "" {
-		fmt.Println("Error: Input (-i), output (-o), and compression type (-t) are required")
+	archiveFiles := cmd.Args()
+	if *archiveFile != "" {
+		archiveFiles = append([]string{*archiveFile}, archiveFiles...)
+	}
+	if len(archiveFiles) == 0 {
+		fmt.Println("Error: Archive file (-f) is required")
 		cmd.Usage()
 		return
 	}
+	if *sigFile != "" && len(archiveFiles) > 1 {
+		log.Fatal("A signature file (-sig) can only be given for a single archive")
+	}
+	if stdin := slices.DeleteFunc(slices.Clone(archiveFiles), func(archive string) bool { return archive != "-" }); len(stdin) > 0 {
+		if len(stdin) > 1 {
+			log.Fatal("Stdin (-) can only be verified once")
+		}
+		if *pubKey != "" || *stripTrailer {
+			log.Fatal("Signature verification (-pubkey) and trailer removal (-strip-trailer) require an archive file, not stdin")
+		}
+	}
 
-	// Get compression type
-	compression, ok := arc.CompressionMap[strings.ToLower(*compressionType)]
-	if !ok {
-		log.Fatalf("Unsupported compression type: %s", *compressionType)
+	var opts []arc.Option
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
 	}
 
-	// Read input file
-	data, err := os.ReadFile(*inputFile)
-	if err != nil {
-		log.Fatalf("Error reading file %s: %v", *inputFile, err)
+	// Verify every archive, reporting all the corrupted ones
+	failed := 0
+	for _, archive := range archiveFiles {
+		verifyArchiveSignature(archive, *sigFile, *pubKey)
+		verify := arc.Verify
+		if archive == "-" {
+			// Verify an archive streamed to stdin
+			verify = func(_ string, opts ...arc.Option) error {
+				return arc.VerifyReader(os.Stdin, opts...)
+			}
+		}
+		if err := verify(archive, opts...); err != nil {
+			log.Printf("Verification failed: %s: %v\n", archive, err)
+			failed++
+			continue
+		}
+		infof("Archive OK: %s\n", archive)
+
+		if *stripTrailer {
+			stripped, err := arc.StripTrailer(archive)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if stripped {
+				infof("Checksum trailer removed: %s\n", archive)
+			} else {
+				infof("No checksum trailer: %s\n", archive)
+			}
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d archives failed verification", failed, len(archiveFiles))
 	}
+}
 
-	// Decompress data
-	out, err := arc.Decompress(data, compression)
-	if err != nil {
-		log.Fatalf("Error decompressing file %s: %v", *inputFile, err)
+func handleKeygen(cmd *flag.FlagSet, args []string) {
+	// Flags for key generation
+	secKeyFile := cmd.String("s", "arc.key", "Secret key file to create")
+	pubKeyFile := cmd.String("p", "arc.pub", "Public key file to create")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc keygen [options]")
+		fmt.Println("The secret key is encrypted with $ARC_KEY_PASSWORD if it is set.")
+		cmd.PrintDefaults()
 	}
 
-	// Write output file
-	if err := os.WriteFile(*outputFile, out, 0o644); err != nil {
-		log.Fatalf("Error writing to file %s: %v", *outputFile, err)
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("File decompressed: %s -> %s\n", *inputFile, *outputFile)
+	if err := arc.GenerateSigningKey(*secKeyFile, *pubKeyFile, os.Getenv("ARC_KEY_PASSWORD")); err != nil {
+		log.Fatal(err)
+	}
+	infof("Key pair created: %s, %s\n", *secKeyFile, *pubKeyFile)
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleLayer(cmd *flag.FlagSet, args []string) {
+	// Flags for image layers
+	outputFile := cmd.String("o", "", "Output layer (required), like layer.tar.gz")
+	base := cmd.String("base", "", "Previous version of the directory, or an archive of it: only the changes are written, with whiteouts for removed paths")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc layer -o <layer.tar.gz> [-base <dir|archive>] <dir>")
+		fmt.Println("Writes a deterministic OCI image layer of a directory and prints its digest, diff ID and size.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *outputFile == "" || cmd.NArg() != 1 {
+		fmt.Println("Error: An output layer (-o) and a directory are required")
+		cmd.Usage()
+		return
+	}
+
+	var opts []arc.Option
+	if *base != "" {
+		opts = append(opts, arc.WithLayerBase(*base))
+	}
+	layer, err := arc.ArchiveOCILayer(cmd.Arg(0), *outputFile, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("digest: %s\n", layer.Digest)
+	fmt.Printf("diff_id: %s\n", layer.DiffID)
+	fmt.Printf("size: %d\n", layer.Size)
+}
+
+func handleImage(cmd *flag.FlagSet, args []string) {
+	// Flags for image extraction
+	outputDir := cmd.String("o", "", "Output directory (required), the root file system of the image")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc image -o <dir> <oci-layout|docker-save.tar>")
+		fmt.Println("Extracts the root file system of an image, applying its layers and their whiteouts in order.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *outputDir == "" || cmd.NArg() != 1 {
+		fmt.Println("Error: An output directory (-o) and an image are required")
+		cmd.Usage()
+		return
+	}
+	if err := arc.ExtractImage(cmd.Arg(0), *outputDir); err != nil {
+		log.Fatal(err)
+	}
+	infof("Image %s extracted to %s\n", cmd.Arg(0), *outputDir)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// verbosity is how much arc prints, set with the global -q, -v and -vv
+// flags.
+type verbosity int
+
+const (
+	// quiet only prints warnings and errors
+	quiet verbosity = iota
+	// normal also prints what a command did, the default
+	normal
+	// verbose also prints every file archived or extracted
+	verbose
+	// debug also prints the debug messages of the library
+	debug
+)
+
+var (
+	logLevel = normal
+	// every message is a JSON object on its own line, see -log-json
+	logJSON bool
+	// serializes the JSON events of the loggers and file reports
+	logMu sync.Mutex
+)
+
+// logEvent is a line of -log-json output.
+type logEvent struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message,omitempty"`
+	// set for the events about single files
+	Event  string `json:"event,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// setupLogging applies the global output flags.
+func setupLogging(level verbosity, jsonOutput bool) {
+	logLevel, logJSON = level, jsonOutput
+	arc.DEBUG = arc.DEBUG || level >= debug
+	if !jsonOutput {
+		return
+	}
+	// the messages of log.Fatal can't be told apart from others, the
+	// informational ones go through infof instead
+	log.SetFlags(0)
+	log.SetOutput(jsonLog{level: "error"})
+	arc.Logger = log.New(jsonLog{level: "debug"}, "", 0)
+}
+
+// writeEvent writes e to stderr as a line of JSON.
+func writeEvent(e logEvent) {
+	e.Time = time.Now().Format(time.RFC3339Nano)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// jsonLog turns the lines of a logger into JSON events of level, or warning
+// for lines starting with "Warning: ".
+type jsonLog struct {
+	level string
+}
+
+func (j jsonLog) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	level := j.level
+	if rest, ok := strings.CutPrefix(message, "Warning: "); ok {
+		level, message = "warning", rest
+	}
+	writeEvent(logEvent{Level: level, Message: message})
+	return len(p), nil
+}
+
+// infof prints what a command did, unless -q is given.
+func infof(format string, a ...any) {
+	if logLevel < normal {
+		return
+	}
+	if logJSON {
+		writeEvent(logEvent{Level: "info", Message: strings.TrimRight(fmt.Sprintf(format, a...), "\n")})
+		return
+	}
+	log.Printf(format, a...)
+}
+
+// fileReporter returns a progress reporter printing every file processed
+// with -v or -log-json, action names what is done to them, or nil.
+func fileReporter(action string) func(arc.ProgressEvent) {
+	if logLevel < normal || (!logJSON && logLevel < verbose) {
+		return nil
+	}
+	return func(e arc.ProgressEvent) {
+		if e.Kind != arc.ProgressEntryFinished && e.Kind != arc.ProgressEntryFailed {
+			return
+		}
+		if logJSON {
+			event := logEvent{Level: "info", Event: "file", Path: e.Name, Size: e.Size, Action: action}
+			if e.Err != nil {
+				event.Level, event.Error = "error", e.Err.Error()
+			}
+			writeEvent(event)
+			return
+		}
+		if e.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.Name, e.Err)
+			return
+		}
+		fmt.Fprintln(os.Stderr, e.Name)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handlePrecompress(cmd *flag.FlagSet, args []string) {
+	// Flags for precompressing web assets
+	route := cmd.String("route", "", "Codec of each extension (required), like '.wasm=br:11,.js,.css=gz:9,.bin=zst:19'")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc precompress -route <routes> <dir|file>...")
+		fmt.Println("Writes a compressed copy next to each file with a routed extension, like app.wasm.br next to")
+		fmt.Println("app.wasm, for web servers that send precompressed files.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *route == "" {
+		fmt.Println("Error: Routes (-route) are required")
+		cmd.Usage()
+		return
+	}
+	if cmd.NArg() < 1 {
+		fmt.Println("Error: A directory or file is required")
+		cmd.Usage()
+		return
+	}
+	routes := parseRoutes(*route)
+
+	var count int
+	var size, compressed int64
+	for _, dir := range cmd.Args() {
+		files, err := arc.Precompress(dir, routes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, file := range files {
+			fmt.Printf("%s %d -> %d\n", file.Output, file.Size, file.CompressedSize)
+			count++
+			size += file.Size
+			compressed += file.CompressedSize
+		}
+	}
+	infof("%d files precompressed, %d -> %d bytes\n", count, size, compressed)
+}
+
+// parseRoutes parses the routes of -route.
+func parseRoutes(spec string) []arc.Route {
+	routes, err := arc.ParseRoutes(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return routes
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handlePreview(cmd *flag.FlagSet, args []string) {
+	// Flags for the preview server
+	archiveFile := cmd.String("f", "", "Archive file to preview (required)")
+	listenAddr := cmd.String("listen", "127.0.0.1:8080", "Address to listen on")
+	cacheOptions := addCacheFlags(cmd)
+	limitFlags := addLimitFlags(cmd)
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc preview [options]")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+
+	// the archive is checked against the limits once, before serving it
+	opts := cacheOptions()
+	if limits := limitFlags(); limits != nil {
+		opts = append(opts, arc.WithLimits(*limits))
+	}
+	fsys, err := arc.OpenArchiveFS(*archiveFile, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the archive file system reads a single stream, serve one request at a time
+	var mu sync.Mutex
+	fileServer := http.FileServer(http.FS(fsys))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		infof("%s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read-only preview", http.StatusMethodNotAllowed)
+			return
+		}
+		servePreview(w, r, fsys, fileServer)
+	})
+
+	infof("Serving %s on http://%s/\n", *archiveFile, *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, handler))
+}
+
+// servePreview streams files from fsys and leaves directory listings to
+// fileServer. Entries of compressed archives can't seek, which rules out
+// http.FileServer (and range requests) for file contents.
+func servePreview(w http.ResponseWriter, r *http.Request, fsys fs.FS, fileServer http.Handler) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		fileServer.ServeHTTP(w, r)
+		return
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("Error serving %s: %v", name, err)
+	}
+}
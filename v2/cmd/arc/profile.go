@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileFileNames are the files looked up for profiles, in each directory
+// from the current one up to the root.
+var profileFileNames = []string{"arc.yaml", "arc.yml", ".arcrc"}
+
+// profileFile is an arc.yaml or .arcrc file:
+//
+//	profiles:
+//	  release:
+//	    directory: build
+//	    sources: [bin, README.md]
+//	    exclude: ["*.map"]
+//	    compression: zst
+//	    level: 19
+//	    output: dist/app-{date}.tar.zst
+//	    rotate: 5
+//	    flags:
+//	      deterministic: "true"
+type profileFile struct {
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// profile is a named set of create flags, relative paths are relative to
+// the directory of its file.
+type profile struct {
+	Sources     []string `yaml:"sources"`
+	Directory   string   `yaml:"directory"`
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+	Compression string   `yaml:"compression"`
+	Format      string   `yaml:"format"`
+	Level       string   `yaml:"level"`
+	Output      string   `yaml:"output"`
+	Rotate      int      `yaml:"rotate"`
+	// Flags are any other flags of create, by name without the dash
+	Flags map[string]string `yaml:"flags"`
+}
+
+// profileFlag is a flag set by a profile, with its values.
+type profileFlag struct {
+	flag   string
+	values []string
+}
+
+// findProfileFile returns the first profile file in the current directory
+// or its parents, else ~/.arcrc.
+func findProfileFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range profileFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".arcrc")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no %s found in the current directory, its parents or ~/.arcrc", strings.Join(profileFileNames, ", "))
+}
+
+// loadProfile returns the profile called name of file, looked up with
+// findProfileFile if "", and the directory its relative paths are in.
+func loadProfile(file, name string) (profile, string, error) {
+	if file == "" {
+		found, err := findProfileFile()
+		if err != nil {
+			return profile{}, "", err
+		}
+		file = found
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return profile{}, "", err
+	}
+	var pf profileFile
+	if err := yaml.Unmarshal(data, &pf); err != nil {
+		return profile{}, "", fmt.Errorf("parse %s: %w", file, err)
+	}
+	p, ok := pf.Profiles[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(pf.Profiles))
+		return profile{}, "", fmt.Errorf("no profile %q in %s, it has: %s", name, file, strings.Join(names, ", "))
+	}
+
+	// paths are shown relative to the current directory when possible
+	dir := filepath.Dir(file)
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, dir); err == nil {
+			dir = rel
+		}
+	}
+	return p, dir, nil
+}
+
+// apply sets the flags of cmd that p defines and the command line doesn't,
+// and returns the sources: args if any, else those of p. Relative paths of
+// p are resolved against dir.
+func (p profile) apply(cmd *flag.FlagSet, args []string, dir string) ([]string, error) {
+	resolve := func(path string) string {
+		if path == "" || path == "-" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	// the sources are relative to -C, which defaults to the profile's
+	// directory
+	directory := p.Directory
+	if directory == "" && dir != "." {
+		directory = "."
+	}
+	values := []profileFlag{
+		{"C", []string{resolve(directory)}},
+		{"f", []string{resolve(p.Output)}},
+		{"c", []string{p.Compression}},
+		{"t", []string{p.Format}},
+		{"level", []string{p.Level}},
+		{"include", p.Include},
+		{"exclude", p.Exclude},
+	}
+	if p.Rotate != 0 {
+		values = append(values, profileFlag{"rotate", []string{strconv.Itoa(p.Rotate)}})
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Flags)) {
+		values = append(values, profileFlag{name, []string{p.Flags[name]}})
+	}
+
+	for _, v := range values {
+		if flagWasSet(cmd, v.flag) {
+			continue
+		}
+		for _, value := range v.values {
+			if value == "" {
+				continue
+			}
+			if err := cmd.Set(v.flag, value); err != nil {
+				return nil, fmt.Errorf("profile flag %s: %w", v.flag, err)
+			}
+		}
+	}
+	if len(args) > 0 {
+		return args, nil
+	}
+	return p.Sources, nil
+}
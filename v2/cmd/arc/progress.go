@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// progressEvent is a line of -progress-json output.
+type progressEvent struct {
+	Event          string  `json:"event"`
+	Name           string  `json:"name,omitempty"`
+	Size           int64   `json:"size,omitempty"`
+	Bytes          int64   `json:"bytes"`
+	TotalBytes     int64   `json:"total_bytes,omitempty"`
+	Entries        int     `json:"entries"`
+	TotalEntries   int     `json:"total_entries,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	ETASeconds     float64 `json:"eta_seconds,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// addProgressFlags registers the progress flags of commands creating or
+// extracting archives, the returned function turns them into options once
+// cmd has been parsed. action names what the command does to files in the
+// output of -v and -log-json.
+func addProgressFlags(cmd *flag.FlagSet, action string) func() []arc.Option {
+	fd := cmd.Int("progress-json", 0, "Write progress as newline-delimited JSON events to this file descriptor, e.g. 2 for stderr or 3 for a pipe set up by the caller")
+	bar := cmd.Bool("progress", false, "Show the current file, a progress bar, the throughput and the ETA on stderr")
+
+	return func() []arc.Option {
+		var reporters []func(arc.ProgressEvent)
+		if *fd > 0 {
+			reporters = append(reporters, jsonProgress(*fd))
+		}
+		if report := fileReporter(action); report != nil {
+			reporters = append(reporters, report)
+		}
+		if *bar {
+			reporters = append(reporters, (&progressBar{output: os.Stderr, width: terminalWidth()}).report)
+		}
+		if len(reporters) == 0 {
+			return nil
+		}
+		return []arc.Option{arc.WithProgress(func(e arc.ProgressEvent) {
+			for _, report := range reporters {
+				report(e)
+			}
+		})}
+	}
+}
+
+// jsonProgress returns a reporter writing the events to the file descriptor
+// fd, see -progress-json.
+func jsonProgress(fd int) func(arc.ProgressEvent) {
+	output := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if output == nil {
+		log.Fatalf("Invalid file descriptor for -progress-json: %d", fd)
+	}
+	encoder := json.NewEncoder(output)
+	return func(e arc.ProgressEvent) {
+		var errMsg string
+		if e.Err != nil {
+			errMsg = e.Err.Error()
+		}
+		err := encoder.Encode(progressEvent{
+			Event:          string(e.Kind),
+			Name:           e.Name,
+			Size:           e.Size,
+			Bytes:          e.Bytes,
+			TotalBytes:     e.TotalBytes,
+			Entries:        e.Entries,
+			TotalEntries:   e.TotalEntries,
+			ElapsedSeconds: e.Elapsed.Seconds(),
+			ETASeconds:     e.ETA().Seconds(),
+			Error:          errMsg,
+		})
+		if err != nil {
+			log.Fatalf("Writing progress to -progress-json %d: %v", fd, err)
+		}
+	}
+}
+
+// barRedrawInterval limits how often the progress bar is redrawn, entries
+// finishing in between aren't shown.
+const barRedrawInterval = 100 * time.Millisecond
+
+// progressBar draws the progress of -progress on a single line that is
+// overwritten, ending it once the operation is done.
+type progressBar struct {
+	output io.Writer
+	width  int
+	// length of the last line, to blank what a shorter one leaves over
+	lastLen  int
+	lastDraw time.Time
+}
+
+func (b *progressBar) report(e arc.ProgressEvent) {
+	done := e.Kind == arc.ProgressDone
+	if !done && time.Since(b.lastDraw) < barRedrawInterval {
+		return
+	}
+	b.lastDraw = time.Now()
+
+	var status []string
+	if e.TotalBytes > 0 {
+		percent := float64(e.Bytes) / float64(e.TotalBytes)
+		status = append(status, drawBar(percent, 20), fmt.Sprintf("%3.0f%%", percent*100),
+			formatSize(e.Bytes)+"/"+formatSize(e.TotalBytes))
+	} else {
+		status = append(status, formatSize(e.Bytes))
+	}
+	if e.TotalEntries > 0 {
+		status = append(status, fmt.Sprintf("%d/%d files", e.Entries, e.TotalEntries))
+	} else {
+		status = append(status, fmt.Sprintf("%d files", e.Entries))
+	}
+	if seconds := e.Elapsed.Seconds(); seconds > 0 {
+		status = append(status, formatSize(int64(float64(e.Bytes)/seconds))+"/s")
+	}
+	if eta := e.ETA(); eta > 0 {
+		status = append(status, "ETA "+formatETA(eta))
+	}
+	if done {
+		status = append(status, "in "+formatETA(e.Elapsed))
+	}
+	line := strings.Join(status, "  ")
+	if e.Name != "" {
+		line += "  " + fitName(e.Name, b.width-len(line)-3)
+	}
+
+	padding := ""
+	if len(line) < b.lastLen {
+		padding = strings.Repeat(" ", b.lastLen-len(line))
+	}
+	b.lastLen = len(line)
+	fmt.Fprintf(b.output, "\r%s%s", line, padding)
+	if done {
+		fmt.Fprintln(b.output)
+	}
+}
+
+// drawBar renders fraction as a bar of width cells, like [=====>    ].
+func drawBar(fraction float64, width int) string {
+	filled := min(int(fraction*float64(width)), width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// formatETA renders d like 1:05 or 2:03:04.
+func formatETA(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// fitName shortens name to at most width characters, keeping its end which
+// holds the file name.
+func fitName(name string, width int) string {
+	if width < 8 {
+		return ""
+	}
+	if len(name) <= width {
+		return name
+	}
+	return "..." + name[len(name)-width+3:]
+}
+
+// terminalWidth returns the width of the terminal from $COLUMNS, 80 if it
+// isn't set.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+	"golang.org/x/term"
+)
+
+// overwritePolicy picks the overwrite policy of extract: -force and -keep,
+// else -overwrite, else $ARC_OVERWRITE, else prompting like unzip when
+// interactive is true and arc runs in a terminal, else overwriting.
+func overwritePolicy(name string, force, keep, interactive bool) (arc.OverwritePolicy, error) {
+	switch {
+	case force && keep:
+		return arc.Overwrite, errors.New("-force and -keep can't be combined")
+	case (force || keep) && name != "":
+		return arc.Overwrite, errors.New("-force and -keep can't be combined with -overwrite")
+	case force:
+		return arc.Overwrite, nil
+	case keep:
+		return arc.SkipExisting, nil
+	case name != "":
+		return arc.ParseOverwritePolicy(name)
+	}
+	if env := os.Getenv("ARC_OVERWRITE"); env != "" {
+		policy, err := arc.ParseOverwritePolicy(env)
+		if err != nil {
+			return policy, fmt.Errorf("ARC_OVERWRITE: %w", err)
+		}
+		return policy, nil
+	}
+	if interactive && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+		return arc.Prompt, nil
+	}
+	return arc.Overwrite, nil
+}
+
+// overwritePrompt asks on stderr whether to replace an existing file and
+// reads the answer from in, like unzip: [y]es, [n]o, [A]ll or [N]one, the
+// last two apply to the remaining files too.
+func overwritePrompt(in io.Reader) func(path string) (bool, error) {
+	reader := bufio.NewReader(in)
+	var all, none bool
+	return func(path string) (bool, error) {
+		switch {
+		case all:
+			return true, nil
+		case none:
+			return false, nil
+		}
+		for {
+			fmt.Fprintf(os.Stderr, "replace %s? [y]es, [n]o, [A]ll, [N]one: ", path)
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Fprintln(os.Stderr)
+				return false, fmt.Errorf("no answer: %w", err)
+			}
+			switch strings.TrimSpace(line) {
+			case "y", "yes":
+				return true, nil
+			case "n", "no":
+				return false, nil
+			case "A", "all":
+				all = true
+				return true, nil
+			case "N", "none":
+				none = true
+				return false, nil
+			}
+			fmt.Fprintln(os.Stderr, "Invalid answer, type y, n, A or N")
+		}
+	}
+}
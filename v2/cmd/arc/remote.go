@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// addRemoteFlags registers the flags of commands reading URLs, the returned
+// function turns them into options once cmd has been parsed.
+func addRemoteFlags(cmd *flag.FlagSet) func() []arc.Option {
+	connections := cmd.Int("connections", 4, "Parallel connections when downloading a URL that supports range requests")
+	retries := cmd.Int("retries", 2, "Retries of each failed request of a download, a broken download is resumed where it stopped if the server supports it")
+	proxy := cmd.String("proxy", "", "Proxy URL for remote requests (default from HTTP_PROXY/HTTPS_PROXY)")
+	caCert := cmd.String("cacert", "", "PEM bundle of additional CA certificates to trust")
+	clientCert := cmd.String("cert", "", "PEM client certificate for mutual TLS (requires -key)")
+	clientKey := cmd.String("key", "", "PEM private key of the client certificate")
+	token := cmd.String("bearer-token", "", "Bearer token sent with remote requests (default $ARC_BEARER_TOKEN)")
+	timeout := cmd.Duration("timeout", 0, "Timeout to connect and receive response headers, e.g. 30s (0 for none)")
+	checksum := cmd.String("sha256", "", "Expected SHA-256 of the downloaded archive, a mismatching mirror is skipped")
+	var headers stringList
+	cmd.Var(&headers, "header", "Extra header for remote requests as 'Name: value', can be repeated")
+
+	return func() []arc.Option {
+		opts := []arc.Option{arc.WithConnections(*connections), arc.WithRetries(*retries), arc.WithTimeout(*timeout)}
+		if *proxy != "" {
+			opts = append(opts, arc.WithProxy(*proxy))
+		}
+		if *caCert != "" {
+			opts = append(opts, arc.WithCACert(*caCert))
+		}
+		if *clientCert != "" || *clientKey != "" {
+			if *clientCert == "" || *clientKey == "" {
+				log.Fatal("Both -cert and -key are required for a client certificate")
+			}
+			opts = append(opts, arc.WithClientCert(*clientCert, *clientKey))
+		}
+		for _, header := range headers {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok {
+				log.Fatalf("Invalid header %q, expected 'Name: value'", header)
+			}
+			opts = append(opts, arc.WithHeader(strings.TrimSpace(name), strings.TrimSpace(value)))
+		}
+		if *checksum != "" {
+			opts = append(opts, arc.WithChecksum(*checksum))
+		}
+		if *token == "" {
+			*token = os.Getenv("ARC_BEARER_TOKEN")
+		}
+		if *token != "" {
+			opts = append(opts, arc.WithBearerToken(*token))
+		}
+		return opts
+	}
+}
+
+// remoteChecksum downloads the checksum file of the archive at rawURL, the
+// URL with .sha256 appended to its path, and pins the checksum in it.
+func remoteChecksum(rawURL string, opts []arc.Option) arc.Option {
+	sumURL, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sumURL.Path += arc.ChecksumExt
+	sumURL.RawPath = ""
+	body, err := arc.OpenURL(sumURL.String(), opts...)
+	if err != nil {
+		log.Fatalf("Checksum file of %s: %v", rawURL, err)
+	}
+	defer body.Close()
+	sum, err := arc.ParseChecksumFile(body, strings.TrimSuffix(sumURL.Path, arc.ChecksumExt))
+	if err != nil {
+		log.Fatalf("Checksum file of %s: %v", rawURL, err)
+	}
+	return arc.WithChecksum(sum)
+}
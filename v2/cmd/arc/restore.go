@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleRestore(cmd *flag.FlagSet, args []string) {
+	// Flags for restoring
+	inputFile := cmd.String("f", "", "File produced by arc, or one of its volumes (required)")
+	password := cmd.String("p", "", "Password of an encrypted ZIP archive (AES or ZipCrypto)")
+	passwordFile := cmd.String("password-file", "", "Read the ZIP password from the first line of this file")
+	identities := cmd.String("identity", "", "Decrypt with these age identity, SSH private key or OpenPGP secret key files (comma separated)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc restore [options] <destination>")
+		fmt.Println("Undoes every step arc took to produce a file, detected from its name and content:")
+		fmt.Println("joins volumes, verifies the checksum trailer, decrypts, decompresses and extracts.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *inputFile == "" {
+		fmt.Println("Error: Input file (-f) is required")
+		cmd.Usage()
+		return
+	}
+	if cmd.NArg() < 1 {
+		fmt.Println("Error: Destination directory is required")
+		cmd.Usage()
+		return
+	}
+
+	var opts []arc.Option
+	if pass := readPassword(*password, *passwordFile); pass != "" {
+		opts = append(opts, arc.WithPassword(pass))
+	}
+	if *identities != "" {
+		opts = append(opts, arc.WithDecryption(strings.Split(*identities, ",")...))
+	}
+
+	restored, err := arc.Restore(*inputFile, cmd.Arg(0), opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, step := range restored.Steps {
+		infof("%s", step)
+	}
+	if restored.File != "" {
+		infof("Restored %s to %s", *inputFile, restored.File)
+	} else {
+		infof("Restored %s to %s", *inputFile, cmd.Arg(0))
+	}
+}
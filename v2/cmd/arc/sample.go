@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleSample(cmd *flag.FlagSet, args []string) {
+	// Flags for sampled extraction
+	archiveFile := cmd.String("f", "", "Archive file to sample (required)")
+	percent := cmd.Float64("percent", 1, "Percentage of the files to extract")
+	seed := cmd.Uint64("seed", 0, "Seed selecting the sample, the same seed selects the same files (default random)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc sample [options] <destination_directory>")
+		fmt.Println("Extracts a random sample of the files of an archive.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+	if *percent <= 0 || *percent > 100 {
+		log.Fatalf("Invalid percentage: %g", *percent)
+	}
+
+	// Get destination directory
+	destination := "."
+	if cmd.NArg() > 0 {
+		destination = cmd.Arg(0)
+	}
+
+	if !flagWasSet(cmd, "seed") {
+		*seed = rand.Uint64()
+	}
+
+	if err := arc.Unarchive(*archiveFile, destination, arc.WithSample(*percent, *seed)); err != nil {
+		log.Fatal(err)
+	}
+	infof("Sample of %g%% extracted to: %s (seed %d)\n", *percent, destination, *seed)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+func handleServe(cmd *flag.FlagSet, args []string) {
+	// Flags for the archive server
+	dir := cmd.String("dir", ".", "Directory of the archives and directories to serve")
+	listenAddr := cmd.String("listen", "127.0.0.1:8080", "Address to listen on")
+	cacheOptions := addCacheFlags(cmd)
+	limitFlags := addLimitFlags(cmd)
+	limitsFile := cmd.String("limits", "", "JSON file of limits by route prefix and bearer token, overriding the -max-* and -formats flags")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc serve [options]")
+		fmt.Println("Serves the archives below -dir over HTTP, read-only:")
+		fmt.Println("  /                      the archives and directories, as JSON with ?format=json")
+		fmt.Println("  /files/<archive>       the archive itself")
+		fmt.Println("  /browse/<archive>/     the entries of the archive, each can be downloaded")
+		fmt.Println("  /archive/<dir>.<ext>   the directory as an archive of that format, like .tar.zst or .zip, created on the fly")
+		fmt.Println("Archives browsed beyond the limits are refused with a JSON error, 413 or 415. The -limits file sets")
+		fmt.Println("them by route and by bearer token, a request with another token is refused with 401:")
+		fmt.Println(`  {"routes": {"/browse/uploads/": {"max_entries": 1000, "formats": ["zip"]}},`)
+		fmt.Println(`   "tokens": {"<token>": {"max_archive_size": "10G", "max_size": "50G"}}}`)
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if info, err := os.Stat(*dir); err != nil {
+		log.Fatal(err)
+	} else if !info.IsDir() {
+		log.Fatalf("%s is not a directory", *dir)
+	}
+
+	var defaults arc.Limits
+	if limits := limitFlags(); limits != nil {
+		defaults = *limits
+	}
+	limits, err := loadServeLimits(*limitsFile, defaults)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := server{root: *dir, opts: cacheOptions(), limits: limits}
+	infof("Serving %s on http://%s/\n", *dir, *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, s.handler()))
+}
+
+// server serves the archives and directories below root, reading them
+// with opts within limits.
+type server struct {
+	root   string
+	opts   []arc.Option
+	limits serveLimits
+}
+
+// handler routes the requests of s, refusing those that could modify
+// something and those with an unknown bearer token.
+func (s server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", s.serveIndex)
+	mux.HandleFunc("/files/", s.serveFile)
+	mux.HandleFunc("/browse/", s.serveBrowse)
+	mux.HandleFunc("/archive/", s.serveArchive)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		infof("%s %s", r.Method, r.URL.Path)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read-only server", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, err := s.limits.forRequest(r); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeError answers with err as JSON, with the status of a LimitError and
+// the limit it reports if it is one, else with status.
+func writeError(w http.ResponseWriter, err error, status int) {
+	body := struct {
+		Error  string `json:"error"`
+		Limit  string `json:"limit,omitempty"`
+		Max    int64  `json:"max,omitempty"`
+		Format string `json:"format,omitempty"`
+	}{Error: err.Error()}
+	var limitErr *arc.LimitError
+	if errors.As(err, &limitErr) {
+		status = limitErr.StatusCode()
+		body.Limit, body.Max, body.Format = limitErr.Limit, limitErr.Max, limitErr.Format
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error answering with %v: %v", body.Error, err)
+	}
+}
+
+// servedEntry is an archive or directory listed by the index.
+type servedEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// path returns the file of root a slash separated name stands for, which
+// can't be outside of root.
+func (s server) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// serveIndex lists the archives and the directories below root.
+func (s server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	var entries []servedEntry
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := servedEntry{Name: filepath.ToSlash(rel), ModTime: info.ModTime().UTC()}
+		switch {
+		case d.IsDir():
+			entry.Type = "dir"
+		case d.Type().IsRegular() && isArchiveName(d.Name()):
+			entry.Type, entry.Size = "archive", info.Size()
+		default:
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if entries == nil {
+			entries = []servedEntry{}
+		}
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			log.Printf("Error serving the index: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!doctype html>\n<pre>")
+	for _, entry := range entries {
+		link := (&url.URL{Path: entry.Name}).EscapedPath()
+		name := html.EscapeString(entry.Name)
+		if entry.Type == "dir" {
+			fmt.Fprintf(w, "%s/  <a href=\"/archive/%s.tar.zst\">tar.zst</a> <a href=\"/archive/%s.zip\">zip</a>\n", name, link, link)
+			continue
+		}
+		fmt.Fprintf(w, "<a href=\"/browse/%s/\">%s</a>  %d bytes  <a href=\"/files/%s\">download</a>\n", link, name, entry.Size, link)
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+// isArchiveName reports whether name is named like an archive, rather than
+// a single compressed file or any other file.
+func isArchiveName(name string) bool {
+	_, archival, err := arc.FormatFromName(name)
+	return err == nil && archival != nil
+}
+
+// serveFile sends an archive as it is, with support for range requests.
+func (s server) serveFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	if !isArchiveName(name) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, s.path(name))
+}
+
+// serveBrowse serves the entries of the archive the path starts with, the
+// first of its prefixes that is a file.
+func (s server) serveBrowse(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(path.Clean(r.URL.Path), "/browse/")
+	archive, parts := "", strings.Split(rest, "/")
+	for i := range parts {
+		candidate := strings.Join(parts[:i+1], "/")
+		if info, err := os.Stat(s.path(candidate)); err == nil && info.Mode().IsRegular() {
+			archive = candidate
+			break
+		}
+	}
+	if archive == "" {
+		http.NotFound(w, r)
+		return
+	}
+	prefix := "/browse/" + archive
+	if r.URL.Path == prefix {
+		http.Redirect(w, r, path.Base(prefix)+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	// each request reads the archive on its own, the file systems aren't
+	// safe for concurrent use
+	limits, err := s.limits.forRequest(r)
+	if err != nil {
+		writeError(w, err, http.StatusUnauthorized)
+		return
+	}
+	fsys, err := arc.OpenArchiveFS(s.path(archive), append(slices.Clip(s.opts), arc.WithLimits(limits))...)
+	if err != nil {
+		writeError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	fileServer := http.FileServer(http.FS(fsys))
+	http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePreview(w, r, fsys, fileServer)
+	})).ServeHTTP(w, r)
+}
+
+// serveArchive streams a directory as an archive in the format of the
+// extension requested, created while it is sent.
+func (s server) serveArchive(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/archive/")
+	compression, archival, err := arc.FormatFromName(name)
+	if err != nil || archival == nil {
+		http.Error(w, "unsupported archive format", http.StatusNotFound)
+		return
+	}
+	// the directory is the name without its extensions, like build-1.2 for
+	// build-1.2.tar.zst
+	dir := ""
+	for candidate := name; path.Ext(candidate) != ""; {
+		candidate = strings.TrimSuffix(candidate, path.Ext(candidate))
+		if info, err := os.Stat(s.path(candidate)); err == nil && info.IsDir() {
+			dir = s.path(candidate)
+			break
+		}
+	}
+	if dir == "" || dir == filepath.Clean(s.root) {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// the status is sent with the first bytes, later errors can only cut
+	// the archive short
+	opts := []arc.Option{arc.WithOutputWriter(w), arc.WithContext(r.Context())}
+	if _, isZip := archival.(archives.Zip); isZip {
+		err = arc.Zip(dir, name, 8, opts...)
+	} else {
+		err = arc.Archive(dir, name, compression, archival, opts...)
+	}
+	if err != nil {
+		log.Printf("Error serving %s: %v", name, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// testServer serves a directory with many.tar.gz, holding 5 files of 2 KiB
+// in a directory, and small.zip, holding one, with these limits: 3 entries
+// by default, no zip archives below /browse/small.zip/, none for the token
+// "unlimited", 1K of content for the token "small".
+func testServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	root, src := t.TempDir(), t.TempDir()
+	content := bytes.Repeat([]byte("x"), 2048)
+	for dir, files := range map[string]int{"many": 5, "small": 1} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i := range files {
+			name := filepath.Join(src, dir, string(rune('a'+i))+".txt")
+			if err := os.WriteFile(name, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := arc.Archive(filepath.Join(src, "many"), filepath.Join(root, "many.tar.gz"), archives.Gz{}, archives.Tar{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := arc.Archive(filepath.Join(src, "small"), filepath.Join(root, "small.zip"), nil, archives.Zip{}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(t.TempDir(), "limits.json")
+	err := os.WriteFile(config, []byte(`{
+		"routes": {"/browse/small.zip/": {"formats": ["tar.gz"]}},
+		"tokens": {"unlimited": {}, "small": {"max_size": "1K"}}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := loadServeLimits(config, arc.Limits{MaxEntries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(server{root: root, limits: limits}.handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestServeLimits(t *testing.T) {
+	ts := testServer(t)
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		limit  string
+		max    int64
+		format string
+	}{
+		{"entries above the default", "/browse/many.tar.gz/", "", http.StatusRequestEntityTooLarge, "entries", 3, ""},
+		{"format refused by route", "/browse/small.zip/", "", http.StatusUnsupportedMediaType, "format", 0, "zip"},
+		{"size above that of the token", "/browse/many.tar.gz/", "small", http.StatusRequestEntityTooLarge, "size", 1024, ""},
+		{"token without limits", "/browse/many.tar.gz/many/a.txt", "unlimited", http.StatusOK, "", 0, ""},
+		{"unknown token", "/", "guessed", http.StatusUnauthorized, "", 0, ""},
+		{"download not limited", "/files/many.tar.gz", "", http.StatusOK, "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, expected %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("Content-Type %s, expected application/json", contentType)
+			}
+			var body struct {
+				Error  string `json:"error"`
+				Limit  string `json:"limit"`
+				Max    int64  `json:"max"`
+				Format string `json:"format"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error == "" || body.Limit != tt.limit || body.Max != tt.max || body.Format != tt.format {
+				t.Fatalf("unexpected error %+v", body)
+			}
+		})
+	}
+}
+
+func TestLoadServeLimits(t *testing.T) {
+	for _, config := range []string{
+		`{"routes": {"browse/": {}}}`,
+		`{"tokens": {"": {}}}`,
+		`{"tokens": {"t": {"max_size": "lots"}}}`,
+		`{"route": {}}`,
+	} {
+		file := filepath.Join(t.TempDir(), "limits.json")
+		if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadServeLimits(file, arc.Limits{}); err == nil {
+			t.Errorf("invalid limits accepted: %s", config)
+		}
+	}
+}
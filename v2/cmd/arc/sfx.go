@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleSfx(cmd *flag.FlagSet, args []string) {
+	// Flags for self-extracting executables
+	archiveFile := cmd.String("f", "", "Archive to embed (required)")
+	outputFile := cmd.String("o", "", "Executable to create (required)")
+	stub := cmd.String("stub", "", "Extractor stub built for the target platform, like GOOS=windows GOARCH=amd64 go build ./cmd/arc-sfx (default arc-sfx next to arc, else in $PATH)")
+	destination := cmd.String("dest", "", "Directory the executable extracts to by default, relative to where it runs (default the working directory)")
+	postCmd := cmd.String("post-cmd", "", "Shell command the executable runs in the destination once extracted, e.g. ./install.sh")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc sfx -f <archive> -o <executable> [options]")
+		fmt.Println("Creates an executable that extracts the archive when run, for machines without arc, tar or zstd.")
+		fmt.Println("The executable takes -C to extract elsewhere and -no-post-cmd to skip the post command.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" || *outputFile == "" {
+		fmt.Println("Error: Archive file (-f) and output file (-o) are required")
+		cmd.Usage()
+		return
+	}
+	if *stub == "" {
+		*stub = findStub()
+	}
+
+	config := arc.SelfExtractConfig{Destination: *destination, PostCommand: *postCmd}
+	if err := arc.CreateSelfExtractor(*stub, *archiveFile, *outputFile, config); err != nil {
+		log.Fatal(err)
+	}
+	infof("Self-extracting executable created: %s\n", *outputFile)
+}
+
+// findStub returns the arc-sfx stub of this platform, next to arc or in
+// $PATH.
+func findStub() string {
+	name := "arc-sfx"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	if exe, err := os.Executable(); err == nil {
+		stub := filepath.Join(filepath.Dir(exe), name)
+		if _, err := os.Stat(stub); err == nil {
+			return stub
+		}
+	}
+	stub, err := exec.LookPath(name)
+	if err != nil {
+		log.Fatalf("No %s stub found next to arc or in $PATH, give one with -stub", name)
+	}
+	return stub
+}
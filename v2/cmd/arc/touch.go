@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+func handleTouch(cmd *flag.FlagSet, args []string) {
+	// Flags for stamping metadata
+	archiveFile := cmd.String("f", "", "Archive file to update in place (required): zip, or zstd, lz4 or gzip compressed")
+	var set stringList
+	cmd.Var(&set, "set", "Set metadata as key=value, key= removes the key, can be repeated")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc touch -f <archive> [-set key=value]...")
+		fmt.Println("Updates the key=value metadata embedded in the archive without rewriting its entries,")
+		fmt.Println("and prints the metadata afterwards.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+
+	if len(set) > 0 {
+		meta := map[string]string{}
+		for _, pair := range set {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				log.Fatalf("Invalid metadata %q, expected key=value", pair)
+			}
+			meta[key] = value
+		}
+		if err := arc.SetMetadata(*archiveFile, meta); err != nil {
+			log.Fatal(err)
+		}
+		infof("Metadata updated: %s\n", *archiveFile)
+	}
+
+	meta, err := arc.ReadMetadata(*archiveFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(meta)) {
+		fmt.Printf("%s=%s\n", key, meta[key])
+	}
+}
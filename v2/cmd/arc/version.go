@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	rdebug "runtime/debug"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2"
+)
+
+// Build information, set by release builds with
+//
+//	go build -ldflags "-X main.version=v2.1.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// and otherwise taken from the build info go embeds, see buildInfo.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildInfo returns the version, commit and build date of this binary,
+// "unknown" for what neither -ldflags nor the go toolchain recorded, and its
+// build tags, like arc_minimal.
+func buildInfo() (v, c, d, tags string) {
+	v, c, d = version, commit, date
+	if info, ok := rdebug.ReadBuildInfo(); ok {
+		modified := false
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			case "-tags":
+				tags = setting.Value
+			}
+		}
+		if modified && commit == "" && c != "" {
+			c += "-dirty"
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	if tags == "" {
+		tags = "none"
+	}
+	return v, c, d, tags
+}
+
+// printVersion prints the build information and the formats compiled into
+// this build, for -version.
+func printVersion() {
+	v, c, d, tags := buildInfo()
+	compressions, archivals := arc.AvailableFormats()
+	readOnly := arc.ReadOnlyFormats()
+	fmt.Printf("arc %s\n", v)
+	fmt.Printf("commit:       %s\n", c)
+	fmt.Printf("built:        %s\n", d)
+	fmt.Printf("go:           %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("tags:         %s\n", tags)
+	fmt.Printf("compressions: %s\n", strings.Join(compressions, " "))
+	fmt.Printf("archivals:    %s\n", strings.Join(archivals, " "))
+	fmt.Printf("read-only:    %s\n", strings.Join(readOnly, " "))
+}
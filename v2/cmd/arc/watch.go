@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jm33-m0/arc/v2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+func handleWatch(cmd *flag.FlagSet, args []string) {
+	// Flags for rebuilding an archive on change
+	archiveFile := cmd.String("f", "", "Archive file to keep up to date (required), its extension selects the format unless -c or -t is given")
+	compressionType := cmd.String("c", "", "Compression type: gzip/gz, bzip2/bz2, xz, zst, lz4, br, etc. (default inferred from -f, else zst)")
+	archivalType := cmd.String("t", "", "Archival type: tar or zip (default inferred from -f, else tar)")
+	directory := cmd.String("C", "", "Change to this directory for the source, like tar -C; '-C dist .' archives the content of dist")
+	debounce := cmd.Duration("debounce", 2*time.Second, "Wait for this long without changes before rebuilding")
+	postCmd := cmd.String("post-cmd", "", "Shell command to run after each rebuild, with $ARC_STATUS set to success or failure, $ARC_ERROR to the error and $ARC_ARCHIVE to the archive")
+	filterFlags := addFilterFlags(cmd)
+	compressionLevel, withLevel := addLevelFlags(cmd, 6, "Compression level: ZIP 0-9, gzip/bz2/lz4 1-9, zst 1-22, br 0-11, or max (default 6 for ZIP, else the format's default)")
+
+	cmd.Usage = func() {
+		fmt.Println("Usage: arc watch -f <archive> [options] <dir>")
+		fmt.Println("Creates the archive of <dir> like arc create, then creates it again whenever files below <dir>")
+		fmt.Println("change. The archive is replaced once complete, readers never see a partial one.")
+		cmd.PrintDefaults()
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate required flags
+	if *archiveFile == "" {
+		fmt.Println("Error: Archive file (-f) is required")
+		cmd.Usage()
+		return
+	}
+	if cmd.NArg() != 1 {
+		fmt.Println("Error: The directory to watch is required")
+		cmd.Usage()
+		return
+	}
+	source := cmd.Arg(0)
+	root := source
+	if *directory != "" && !filepath.IsAbs(source) {
+		root = filepath.Join(*directory, source)
+	}
+	if info, err := os.Stat(root); err != nil {
+		log.Fatal(err)
+	} else if !info.IsDir() {
+		log.Fatalf("%s is not a directory", root)
+	}
+
+	compression, archival := resolveFormat(cmd, *archiveFile, *compressionType, *archivalType)
+	if archival == nil {
+		log.Fatalf("%s is a single compressed file, arc watch creates archives", *archiveFile)
+	}
+	_, isZip := archival.(archives.Zip)
+	if !isZip {
+		compression = withLevel(compression)
+	}
+	filter, err := filterFlags.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := filterFlags.options()
+	if *directory != "" {
+		opts = append(opts, arc.WithDirectory(*directory))
+	}
+
+	// the archive is written next to its final name and renamed over it
+	output, err := filepath.Abs(*archiveFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	temp := filepath.Join(filepath.Dir(output), "."+filepath.Base(output)+".tmp")
+	build := func() error {
+		var err error
+		switch {
+		case isZip && filter != nil:
+			err = arc.ZipWithFileFilter(source, temp, *compressionLevel, 8, filter, opts...)
+		case isZip:
+			err = arc.Zip(source, temp, 8, opts...)
+		case filter != nil:
+			err = arc.ArchiveWithFileFilter(source, temp, compression, archival, filter, opts...)
+		default:
+			err = arc.Archive(source, temp, compression, archival, opts...)
+		}
+		if err != nil {
+			os.Remove(temp)
+			return err
+		}
+		return os.Rename(temp, output)
+	}
+	rebuild := func() {
+		err := runWithHooks("", *postCmd, []string{"ARC_ARCHIVE=" + *archiveFile}, build)
+		if err != nil {
+			log.Printf("Error rebuilding %s: %v", *archiveFile, err)
+			return
+		}
+		infof("Archive rebuilt: %s\n", *archiveFile)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+	if err := watchTree(watcher, root); err != nil {
+		log.Fatal(err)
+	}
+	rebuild()
+	infof("Watching %s for changes\n", root)
+
+	// a burst of changes, like a build writing many files, rebuilds once
+	var settled <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if abs, _ := filepath.Abs(event.Name); abs == output || abs == temp {
+				continue
+			}
+			// directories created later are watched too, fsnotify isn't recursive
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name); err != nil {
+						log.Printf("Warning: can't watch %s: %v", event.Name, err)
+					}
+				}
+			}
+			if logLevel >= verbose {
+				infof("Changed: %s\n", event.Name)
+			}
+			settled = time.After(*debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Warning: watching %s: %v", root, err)
+		case <-settled:
+			settled = nil
+			rebuild()
+		}
+	}
+}
+
+// watchTree adds root and the directories below it to watcher.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(p)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,256 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// knownFormat is a format arc supports, whether or not it is compiled into
+// this build.
+type knownFormat struct {
+	// name it is registered under
+	name string
+	ext  string
+	// build tag leaving the format out
+	tag string
+}
+
+// knownFormats are the formats arc registers, each can be left out of small
+// builds with its tag, like go build -tags arc_no_brotli,arc_no_xz, and all
+// but minimalFormats with arc_minimal. The tags leave out the files of the
+// formats, in arc and in its fork of mholt/archives, which arc uses in place
+// of mholt/archives when a tag leaves one of its codecs out, see
+// internal/archives, with the imports of their codecs; the rar and MinLZ formats arc only extracts have their own,
+// arc_no_rar and arc_no_minlz. A codec another format compiled in needs
+// stays linked, like brotli, which 7z archives may use, and the xz package,
+// which lzma, lzip, 7z and squashfs use too.
+var knownFormats = []knownFormat{
+	{"gz", ".gz", "arc_no_gzip"},
+	{"bz2", ".bz2", "arc_no_bzip2"},
+	{"xz", ".xz", "arc_no_xz"},
+	{"zst", ".zst", "arc_no_zstd"},
+	{"lz4", ".lz4", "arc_no_lz4"},
+	{"br", ".br", "arc_no_brotli"},
+	{"lzip", ".lz", "arc_no_lzip"},
+	{"lzma", ".lzma", "arc_no_lzma"},
+	{"sz", ".sz", "arc_no_snappy"},
+	{"s2", ".s2", "arc_no_snappy"},
+	{"zlib", ".zz", "arc_no_zlib"},
+	{"deflate", ".deflate", "arc_no_deflate"},
+	{"z", ".Z", "arc_no_compress"},
+	{"tar", ".tar", "arc_no_tar"},
+	{"zip", ".zip", "arc_no_zip"},
+	{"7z", ".7z", "arc_no_7z"},
+	{"cpio", ".cpio", "arc_no_cpio"},
+	{"ar", ".a", "arc_no_ar"},
+	{"squashfs", ".squashfs", "arc_no_squashfs"},
+	{"iso", ".iso", "arc_no_iso"},
+}
+
+// minimalFormats are the formats kept by the arc_minimal tag: tar.gz and zip
+// archives, which every platform can read. Leaving out the codecs of the
+// others, brotli, bzip2, xz, lz4, lzip, MinLZ, snappy, 7z and rar, makes the
+// arc command about 16% smaller on linux/amd64, 18.5 MB instead of 22 MB;
+// most of what is left is shared by all builds, like the cryptography of
+// encryption and signatures.
+var minimalFormats = []string{"gz", "tar", "zip"}
+
+// compressionAliases are other names of compressions, by the name they stand
+// for.
+var compressionAliases = map[string]string{
+	"gzip":   "gz",
+	"bzip2":  "bz2",
+	"zstd":   "zst",
+	"brotli": "br",
+	"snappy": "sz",
+	"lz":     "lzip",
+	"zz":     "zlib",
+}
+
+// isKnownFormat reports whether name is a key of a known format.
+func isKnownFormat(name string) bool {
+	return slices.ContainsFunc(knownFormats, func(f knownFormat) bool { return f.name == name })
+}
+
+// AvailableFormats returns the names of the compressions and archivals
+// compiled into this build, in the order of knownFormats, followed by those
+// registered by the application with RegisterCompression or
+// RegisterArchival, sorted.
+func AvailableFormats() (compressionNames, archivalNames []string) {
+	registeredCompressions, registeredArchivals := compressions(), archivals()
+	for _, f := range knownFormats {
+		if _, ok := registeredCompressions[f.name]; ok {
+			compressionNames = append(compressionNames, f.name)
+		}
+		if _, ok := registeredArchivals[f.name]; ok {
+			archivalNames = append(archivalNames, f.name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(registeredCompressions)) {
+		if !isKnownFormat(name) {
+			compressionNames = append(compressionNames, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(registeredArchivals)) {
+		if !isKnownFormat(name) {
+			archivalNames = append(archivalNames, name)
+		}
+	}
+	return compressionNames, archivalNames
+}
+
+// ReadOnlyFormats returns the names of the formats registered with
+// RegisterExtraction, which are extracted and listed but not created, in the
+// same order as AvailableFormats.
+func ReadOnlyFormats() []string {
+	var names []string
+	registered := extractions()
+	for _, f := range knownFormats {
+		if _, ok := registered[f.name]; ok {
+			names = append(names, f.name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(registered)) {
+		if !isKnownFormat(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DecompressOnly reports whether the compression registered under name
+// can't be written, like the legacy z of compress(1).
+func DecompressOnly(name string) bool {
+	compression, _ := LookupCompression(name)
+	_, ok := compression.(UnixCompress)
+	return ok
+}
+
+// compiledIn returns an error wrapping ErrCompressionUnavailable if format,
+// or the compression or archival it combines, was left out of this build.
+// Formats that aren't in knownFormats, like rar, are always available.
+func compiledIn(format archives.Format) error {
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		var parts []archives.Format
+		if compressed.Compression != nil {
+			parts = append(parts, compressed.Compression)
+		}
+		if compressed.Archival != nil {
+			parts = append(parts, compressed.Archival)
+		} else if extraction, ok := compressed.Extraction.(archives.Format); ok {
+			parts = append(parts, extraction)
+		}
+		for _, part := range parts {
+			if err := compiledIn(part); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return extensionCompiledIn(format.Extension())
+}
+
+// extensionCompiledIn is compiledIn for the known format of the extension
+// ext, like ".xz".
+func extensionCompiledIn(ext string) error {
+	i := slices.IndexFunc(knownFormats, func(f knownFormat) bool { return f.ext == ext })
+	if i < 0 {
+		return nil
+	}
+	if _, ok := LookupCompression(knownFormats[i].name); ok {
+		return nil
+	}
+	if _, ok := LookupArchival(knownFormats[i].name); ok {
+		return nil
+	}
+	if _, ok := LookupExtraction(knownFormats[i].name); ok {
+		return nil
+	}
+	tags := knownFormats[i].tag
+	if !slices.Contains(minimalFormats, knownFormats[i].name) {
+		tags += " or arc_minimal"
+	}
+	return fmt.Errorf("%s (built with %s): %w", knownFormats[i].name, tags, ErrCompressionUnavailable)
+}
+
+// identify is archives.Identify, which can't identify the formats left out
+// of this build as they aren't registered: when nothing matches, or what
+// matches doesn't end with the extension of name, like the tar of a .tar.xz
+// matched by name, the extension tells whether it is one of them.
+func identify(ctx context.Context, name string, stream io.Reader) (archives.Format, io.Reader, error) {
+	format, input, err := archives.Identify(ctx, name, stream)
+	ext := path.Ext(name)
+	if errors.Is(err, archives.NoMatch) || (err == nil && !strings.HasSuffix(format.Extension(), ext)) {
+		if availErr := extensionCompiledIn(ext); availErr != nil {
+			return nil, input, availErr
+		}
+	}
+	return format, input, err
+}
+
+// ErrCompressionUnavailable is returned for compressions and archivals left
+// out of this build with a build tag, see knownFormats.
+var ErrCompressionUnavailable = errors.New("format not available in this build")
+
+// CompressionChoice is the compression SelectCompression picked.
+type CompressionChoice struct {
+	// Name is the name Compression is registered under
+	Name        string
+	Compression archives.Compression
+	// Skipped are the preferred compressions that aren't available in this
+	// build, in order, empty unless arc fell back
+	Skipped []string
+}
+
+// Fallback reports whether the first choice wasn't available.
+func (c CompressionChoice) Fallback() bool {
+	return len(c.Skipped) > 0
+}
+
+// SelectCompression returns the first of names available in this build, in
+// order of preference, so stripped down builds without some codecs can fall
+// back to another one. Names are those of registered compressions or their
+// aliases, like gzip for gz, and can also be given comma separated, like
+// "zst,gz".
+// Names arc doesn't know at all are an error rather than skipped.
+func SelectCompression(names ...string) (CompressionChoice, error) {
+	var choice CompressionChoice
+	var candidates []string
+	for _, name := range names {
+		for _, candidate := range strings.Split(name, ",") {
+			candidate = strings.ToLower(strings.TrimSpace(candidate))
+			if alias, ok := compressionAliases[candidate]; ok {
+				candidate = alias
+			}
+			if candidate != "" {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return choice, errors.New("no compression given")
+	}
+
+	for _, name := range candidates {
+		if compression, ok := LookupCompression(name); ok {
+			choice.Name, choice.Compression = name, compression
+			if choice.Fallback() {
+				logging("Compressions %s aren't available, falling back to %s", strings.Join(choice.Skipped, ", "), name)
+			}
+			return choice, nil
+		}
+		if !isKnownFormat(name) {
+			return choice, fmt.Errorf("unsupported compression type: %s", name)
+		}
+		choice.Skipped = append(choice.Skipped, name)
+	}
+	return choice, fmt.Errorf("%s: %w", strings.Join(choice.Skipped, ", "), ErrCompressionUnavailable)
+}
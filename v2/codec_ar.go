@@ -0,0 +1,10 @@
+//go:build !arc_no_ar && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterArchival("ar", Ar{})
+	archives.RegisterFormat(Ar{})
+}
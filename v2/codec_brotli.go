@@ -0,0 +1,10 @@
+//go:build !arc_no_brotli && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("br", archives.Brotli{})
+	archives.RegisterFormat(archives.Brotli{})
+}
@@ -0,0 +1,28 @@
+//go:build !arc_no_bzip2 && !arc_minimal
+
+package arc
+
+import (
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+func init() {
+	RegisterCompression("bz2", archives.Bz2{})
+	archives.RegisterFormat(archives.Bz2{})
+
+	archives.RegisterZipMethod(ZipMethodBzip2,
+		func(out io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(out, &bzip2.WriterConfig{})
+		},
+		func(r io.Reader) io.ReadCloser {
+			bz2r, err := bzip2.NewReader(r, nil)
+			if err != nil {
+				return nil
+			}
+			return bz2r
+		},
+	)
+}
@@ -0,0 +1,10 @@
+//go:build !arc_no_compress && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("z", UnixCompress{})
+	archives.RegisterFormat(UnixCompress{})
+}
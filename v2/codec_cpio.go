@@ -0,0 +1,10 @@
+//go:build !arc_no_cpio && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterArchival("cpio", Cpio{})
+	archives.RegisterFormat(Cpio{})
+}
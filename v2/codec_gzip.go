@@ -0,0 +1,10 @@
+//go:build !arc_no_gzip
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("gz", archives.Gz{})
+	archives.RegisterFormat(archives.Gz{})
+}
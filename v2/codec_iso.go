@@ -0,0 +1,10 @@
+//go:build !arc_no_iso && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterExtraction("iso", ISO9660{})
+	archives.RegisterFormat(ISO9660{})
+}
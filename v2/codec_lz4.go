@@ -0,0 +1,10 @@
+//go:build !arc_no_lz4 && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("lz4", archives.Lz4{})
+	archives.RegisterFormat(archives.Lz4{})
+}
@@ -0,0 +1,10 @@
+//go:build !arc_no_lzip && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("lzip", archives.Lzip{})
+	archives.RegisterFormat(archives.Lzip{})
+}
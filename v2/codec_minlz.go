@@ -0,0 +1,10 @@
+//go:build !arc_no_minlz && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	// MinLZ streams are only extracted
+	archives.RegisterFormat(archives.MinLZ{})
+}
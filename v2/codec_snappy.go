@@ -0,0 +1,14 @@
+//go:build !arc_no_snappy && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	// the zero value of S2.Compression writes uncompressed blocks
+	sz := archives.Sz{S2: archives.S2{Compression: archives.S2LevelFast}}
+	RegisterCompression("sz", sz)
+	RegisterCompression("s2", S2{Sz: sz})
+	archives.RegisterFormat(archives.Sz{})
+	archives.RegisterFormat(S2{Sz: sz})
+}
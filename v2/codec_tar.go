@@ -0,0 +1,10 @@
+//go:build !arc_no_tar
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterArchival("tar", archives.Tar{})
+	archives.RegisterFormat(archives.Tar{})
+}
@@ -0,0 +1,28 @@
+//go:build !arc_no_xz && !arc_minimal
+
+package arc
+
+import (
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/ulikunitz/xz"
+)
+
+func init() {
+	RegisterCompression("xz", archives.Xz{})
+	archives.RegisterFormat(archives.Xz{})
+
+	archives.RegisterZipMethod(ZipMethodXz,
+		func(out io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(out)
+		},
+		func(r io.Reader) io.ReadCloser {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil
+			}
+			return io.NopCloser(xr)
+		},
+	)
+}
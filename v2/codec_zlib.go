@@ -0,0 +1,10 @@
+//go:build !arc_no_zlib && !arc_minimal
+
+package arc
+
+import "github.com/jm33-m0/arc/v2/internal/archives"
+
+func init() {
+	RegisterCompression("zlib", archives.Zlib{})
+	archives.RegisterFormat(archives.Zlib{})
+}
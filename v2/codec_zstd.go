@@ -0,0 +1,28 @@
+//go:build !arc_no_zstd && !arc_minimal
+
+package arc
+
+import (
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	RegisterCompression("zst", archives.Zstd{})
+	archives.RegisterFormat(archives.Zstd{})
+
+	archives.RegisterZipMethod(ZipMethodZstd,
+		func(out io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(out)
+		},
+		func(r io.Reader) io.ReadCloser {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil
+			}
+			return zr.IOReadCloser()
+		},
+	)
+}
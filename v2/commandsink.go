@@ -0,0 +1,97 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// CommandSink pipes the content of every regular file to a shell command
+// instead of writing it, like tar --to-command. The command runs once per
+// file with the name of the entry in $ARC_FILENAME and its permissions in
+// $ARC_MODE. Directories and symlinks are ignored.
+type CommandSink struct {
+	// Command is run with /bin/sh -c, or cmd /C on Windows
+	Command string
+	// Stdout and Stderr of the command, os.Stdout and os.Stderr if nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// CreateDir does nothing, commands only receive file content.
+func (c *CommandSink) CreateDir(name string, mode fs.FileMode) error {
+	return nil
+}
+
+// CreateFile starts the command for the file name, what is written is its
+// standard input. Closing waits for the command to exit.
+func (c *CommandSink) CreateFile(name string, mode fs.FileMode) (io.WriteCloser, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", c.Command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", c.Command)
+	}
+	cmd.Env = append(os.Environ(),
+		"ARC_FILENAME="+name,
+		fmt.Sprintf("ARC_MODE=%04o", mode.Perm()),
+	)
+	cmd.Stdout, cmd.Stderr = c.Stdout, c.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("command pipe: %w", err)
+	}
+	logging("Piping %s to: %s", name, c.Command)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start command: %w", err)
+	}
+	return &commandInput{stdin: stdin, cmd: cmd, name: name}, nil
+}
+
+// Symlink does nothing, commands only receive file content.
+func (c *CommandSink) Symlink(name, target string) error {
+	logging("Skipping symlink: %s -> %s", name, target)
+	return nil
+}
+
+// commandInput is the standard input of a command run for one entry.
+type commandInput struct {
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+	name  string
+	// the command exited without reading all of its input
+	closed bool
+}
+
+func (c *commandInput) Write(p []byte) (int, error) {
+	if c.closed {
+		return len(p), nil
+	}
+	n, err := c.stdin.Write(p)
+	// commands like head stop reading early, the rest is discarded
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+		c.closed = true
+		return len(p), nil
+	}
+	return n, err
+}
+
+func (c *commandInput) Close() error {
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("command for %s: %w", c.name, err)
+	}
+	return nil
+}
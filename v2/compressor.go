@@ -2,55 +2,99 @@ package arc
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 
-	"github.com/mholt/archives"
+	"github.com/jm33-m0/arc/v2/internal/archives"
 )
 
 // Compress compresses input data using specified compressor.
 func Compress(data []byte, compression archives.Compression) ([]byte, error) {
 	var compressedBuf bytes.Buffer
+	if err := CompressStream(&compressedBuf, bytes.NewReader(data), compression); err != nil {
+		return nil, err
+	}
+	return compressedBuf.Bytes(), nil
+}
+
+// CompressStream compresses what is read from r and writes it to w, without
+// holding the whole input in memory.
+func CompressStream(w io.Writer, r io.Reader, compression archives.Compression) error {
 	logging("Compressing data using %s", compression.Extension())
+	if err := compiledIn(compression); err != nil {
+		return fmt.Errorf("Compress: %w", err)
+	}
 
-	// Wrap the buffer with a compressor
-	compressor, err := compression.OpenWriter(&compressedBuf)
+	// Wrap the writer with a compressor
+	compressor, err := compression.OpenWriter(w)
 	if err != nil {
-		return nil, fmt.Errorf("Compress: Failed to create compressor: %w", err)
+		return fmt.Errorf("Compress: Failed to create compressor: %w", err)
 	}
-	defer compressor.Close()
 
 	// Writes to compressor will be compressed
-	_, err = compressor.Write(data)
-	if err != nil {
-		return nil, fmt.Errorf("Compress: Write to compressor failed: %w", err)
+	if _, err := io.Copy(compressor, r); err != nil {
+		compressor.Close()
+		return fmt.Errorf("Compress: Write to compressor failed: %w", err)
 	}
 
-	// without this line, the compressed data will be incomplete
-	compressor.Close()
-
-	return compressedBuf.Bytes(), nil
+	// without this line, the compressed data will be incomplete;
+	// some compressors (zlib) panic when closed twice, so no defer
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("Compress: Failed to close compressor: %w", err)
+	}
+	return nil
 }
 
 // Decompress decompresses input compressed data.
 func Decompress(data []byte, compression archives.Compression) ([]byte, error) {
-	stream := bytes.NewReader(data)
+	var decompressedBuf bytes.Buffer
+	if err := DecompressStream(&decompressedBuf, bytes.NewReader(data), compression); err != nil {
+		return nil, err
+	}
+	return decompressedBuf.Bytes(), nil
+}
+
+// DecompressStream decompresses what is read from r and writes it to w,
+// without holding the whole input in memory.
+func DecompressStream(w io.Writer, r io.Reader, compression archives.Compression) error {
+	if err := compiledIn(compression); err != nil {
+		return fmt.Errorf("Decompress: %w", err)
+	}
 
 	// Open a reader for decompression using the provided decompressor
-	rc, err := compression.OpenReader(stream)
+	rc, err := compression.OpenReader(r)
 	if err != nil {
-		return nil, fmt.Errorf("Decompress: Failed to open decompression reader: %w", err)
+		return fmt.Errorf("Decompress: Failed to open decompression reader: %w", err)
 	}
 	defer rc.Close()
 
-	// Read decompressed data into a buffer
-	var decompressedBuf bytes.Buffer
-	_, err = io.Copy(&decompressedBuf, rc)
-	if err != nil {
-		return nil, fmt.Errorf("Decompress: Failed to read from decompressor: %w", err)
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("Decompress: Failed to read from decompressor: %w", err)
 	}
+	return nil
+}
 
-	return decompressedBuf.Bytes(), nil
+// IdentifyCompression returns the compression of stream, from its content
+// or else from the extension of name, which may be empty. Read from the
+// returned reader instead of stream, it replays the bytes that were peeked.
+func IdentifyCompression(name string, stream io.Reader) (archives.Compression, io.Reader, error) {
+	format, input, err := identify(context.Background(), name, stream)
+	if err != nil {
+		return nil, input, fmt.Errorf("identify compression: %w", err)
+	}
+	if err := compiledIn(format); err != nil {
+		return nil, input, fmt.Errorf("identify compression: %w", err)
+	}
+	switch f := format.(type) {
+	case archives.Compression:
+		return f, input, nil
+	case archives.CompressedArchive:
+		if f.Compression != nil {
+			return f.Compression, input, nil
+		}
+	}
+	return nil, input, fmt.Errorf("identify compression: %s is not compressed", format.Extension())
 }
 
 // CompressBz2 compresses input data using BZ2 compressor.
@@ -0,0 +1,386 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Cpio is the cpio format of initramfs images and RPM payloads. It reads the
+// portable ASCII variants, newc, its crc twin and odc, and writes newc, the
+// one the Linux kernel unpacks. Entries are written owned by root, devices
+// and hardlinks are left out.
+type Cpio struct{}
+
+// Magic numbers of the cpio variants
+const (
+	cpioNewc = "070701"
+	cpioCRC  = "070702"
+	cpioODC  = "070707"
+)
+
+// cpioTrailer is the name of the entry ending a cpio archive.
+const cpioTrailer = "TRAILER!!!"
+
+// cpioMaxSize is the largest entry newc can hold, its sizes are 32 bits.
+const cpioMaxSize = 1<<32 - 1
+
+func (Cpio) Extension() string { return ".cpio" }
+func (Cpio) MediaType() string { return "application/x-cpio" }
+
+func (c Cpio) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.EqualFold(path.Ext(filename), c.Extension())
+	if stream == nil {
+		return mr, nil
+	}
+	magic := make([]byte, len(cpioNewc))
+	if _, err := io.ReadFull(stream, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	switch string(magic) {
+	case cpioNewc, cpioCRC, cpioODC:
+		mr.ByStream = true
+	}
+	return mr, nil
+}
+
+// Archive writes the files to output as a newc cpio archive.
+func (Cpio) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	w := bufio.NewWriter(output)
+	var ino uint32
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := strings.Trim(f.NameInArchive, "/")
+		mode := f.Mode()
+		switch {
+		case name == "":
+			continue
+		case mode&(fs.ModeDevice|fs.ModeCharDevice) != 0, mode&fs.ModeSymlink == 0 && f.LinkTarget != "":
+			logging("Skipping %s, arc doesn't write devices and hardlinks to cpio archives", f.NameInArchive)
+			continue
+		}
+		ino++
+		if err := writeCpioEntry(ctx, w, f, name, ino); err != nil {
+			return fmt.Errorf("%s: %w", f.NameInArchive, err)
+		}
+	}
+	if err := writeCpioHeader(w, cpioTrailer, 0, 0, 0, time.Unix(0, 0)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeCpioEntry writes f as the entry name with inode number ino.
+func writeCpioEntry(ctx context.Context, w io.Writer, f archives.FileInfo, name string, ino uint32) error {
+	var content io.Reader
+	var size int64
+	switch {
+	case f.Mode()&fs.ModeSymlink != 0:
+		content, size = strings.NewReader(f.LinkTarget), int64(len(f.LinkTarget))
+	case f.Mode().IsRegular():
+		if size = f.Size(); size > cpioMaxSize {
+			return fmt.Errorf("%d bytes is above the 4 GiB cpio entries can hold", size)
+		}
+		file, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		content = ctxReader{Reader: file, ctx: ctx}
+	}
+	if err := writeCpioHeader(w, name, ino, unixMode(f.Mode()), size, f.ModTime()); err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+	n, err := io.CopyN(w, content, size)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("file shrank to %d bytes while being archived", n)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(make([]byte, cpioPadding(size)))
+	return err
+}
+
+// writeCpioHeader writes the newc header of an entry, and its name.
+func writeCpioHeader(w io.Writer, name string, ino, mode uint32, size int64, modTime time.Time) error {
+	nlink := 1
+	if mode&0o170000 == 0o040000 {
+		nlink = 2
+	}
+	mtime := max(modTime.Unix(), 0)
+	header := fmt.Sprintf("%s%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%s\x00",
+		cpioNewc, ino, mode, 0, 0, nlink, mtime, size, 0, 0, 0, 0, len(name)+1, 0, name)
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, cpioPadding(int64(len(header)))))
+	return err
+}
+
+// cpioPadding returns how many bytes align n to the 4 bytes of newc.
+func cpioPadding(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+// cpioHeader is what Extract reads of the header of an entry.
+type cpioHeader struct {
+	magic    string
+	ino      uint64
+	mode     uint32
+	nlink    uint64
+	mtime    int64
+	size     int64
+	devMajor uint64
+	devMinor uint64
+	name     string
+}
+
+// Extract calls handleFile for each entry of the cpio archive. In newc
+// archives the content of hardlinked files comes with their last link, the
+// other links are handed over as hardlinks to it once it is read.
+func (Cpio) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	r := bufio.NewReader(archive)
+	// links of newc files whose content hasn't come yet, and the entry
+	// that had it, by device and inode
+	type inode struct{ major, minor, ino uint64 }
+	pending := make(map[inode][]EntryInfo)
+	linked := make(map[inode]string)
+
+	handle := func(entry EntryInfo, content io.Reader) error {
+		err := handleFile(ctx, sourceFileInfo(entry, io.NopCloser(content)))
+		if err != nil && !errors.Is(err, fs.SkipAll) {
+			return fmt.Errorf("handling file: %s: %w", entry.Name, err)
+		}
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := readCpioHeader(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.name == cpioTrailer {
+			break
+		}
+
+		body := io.LimitReader(r, hdr.size)
+		entry := EntryInfo{
+			Name:    hdr.name,
+			Size:    hdr.size,
+			Mode:    fileMode(hdr.mode),
+			ModTime: time.Unix(hdr.mtime, 0),
+		}
+		key := inode{hdr.devMajor, hdr.devMinor, hdr.ino}
+		hardlinked := hdr.magic != cpioODC && hdr.nlink > 1 && entry.Mode.IsRegular()
+		switch {
+		case entry.Mode&fs.ModeSymlink != 0:
+			target, err := io.ReadAll(body)
+			if err != nil {
+				return fmt.Errorf("read symlink %s: %w", hdr.name, err)
+			}
+			entry.Size, entry.LinkTarget = 0, string(target)
+		case hardlinked && linked[key] != "":
+			entry.Size, entry.LinkTarget = 0, linked[key]
+		case hardlinked && hdr.size == 0:
+			pending[key] = append(pending[key], entry)
+			continue
+		}
+
+		err = handle(entry, body)
+		if err == nil && hardlinked && entry.LinkTarget == "" {
+			linked[key] = entry.Name
+			for _, link := range pending[key] {
+				link.Size, link.LinkTarget = 0, entry.Name
+				if err = handle(link, bytes.NewReader(nil)); err != nil {
+					break
+				}
+			}
+			delete(pending, key)
+		}
+		if errors.Is(err, fs.SkipAll) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return fmt.Errorf("read %s: %w", hdr.name, err)
+		}
+		if hdr.magic != cpioODC {
+			if _, err := r.Discard(int(cpioPadding(hdr.size))); err != nil {
+				return fmt.Errorf("read %s: %w", hdr.name, err)
+			}
+		}
+	}
+
+	// links to files that turned out empty
+	for _, links := range pending {
+		for _, link := range links {
+			if err := handle(link, bytes.NewReader(nil)); err != nil {
+				if errors.Is(err, fs.SkipAll) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readCpioHeader reads the header of the next entry, and its name. It
+// returns io.EOF at the end of an archive without trailer.
+func readCpioHeader(r *bufio.Reader) (cpioHeader, error) {
+	var hdr cpioHeader
+	magic := make([]byte, len(cpioNewc))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return hdr, errors.New("truncated cpio header")
+		}
+		return hdr, err
+	}
+	hdr.magic = string(magic)
+
+	var fields []uint64
+	var nameSize uint64
+	switch hdr.magic {
+	case cpioNewc, cpioCRC:
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor,
+		// rdevmajor, rdevminor, namesize and check, in hex
+		raw := make([]byte, 13*8)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return hdr, fmt.Errorf("truncated cpio header: %w", err)
+		}
+		for i := 0; i < len(raw); i += 8 {
+			n, err := strconv.ParseUint(string(raw[i:i+8]), 16, 32)
+			if err != nil {
+				return hdr, fmt.Errorf("malformed cpio header: %w", err)
+			}
+			fields = append(fields, n)
+		}
+		hdr.ino, hdr.mode, hdr.nlink, hdr.mtime, hdr.size = fields[0], uint32(fields[1]), fields[4], int64(fields[5]), int64(fields[6])
+		hdr.devMajor, hdr.devMinor, nameSize = fields[7], fields[8], fields[11]
+	case cpioODC:
+		// dev, ino, mode, uid, gid, nlink, rdev, mtime, namesize and
+		// filesize, in octal
+		widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+		for _, width := range widths {
+			raw := make([]byte, width)
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return hdr, fmt.Errorf("truncated cpio header: %w", err)
+			}
+			n, err := strconv.ParseUint(string(raw), 8, 64)
+			if err != nil {
+				return hdr, fmt.Errorf("malformed cpio header: %w", err)
+			}
+			fields = append(fields, n)
+		}
+		hdr.devMajor, hdr.ino, hdr.mode, hdr.nlink, hdr.mtime = fields[0], fields[1], uint32(fields[2]), fields[5], int64(fields[7])
+		nameSize, hdr.size = fields[8], int64(fields[9])
+	default:
+		return hdr, fmt.Errorf("unsupported cpio header %q, only newc, crc and odc are read", magic)
+	}
+	if nameSize == 0 || nameSize > 4096 {
+		return hdr, fmt.Errorf("malformed cpio header: name of %d bytes", nameSize)
+	}
+
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return hdr, fmt.Errorf("truncated cpio header: %w", err)
+	}
+	hdr.name = strings.TrimPrefix(string(bytes.TrimRight(name, "\x00")), "./")
+	if hdr.magic != cpioODC {
+		if _, err := r.Discard(int(cpioPadding(110 + int64(nameSize)))); err != nil {
+			return hdr, fmt.Errorf("truncated cpio header: %w", err)
+		}
+	}
+	return hdr, nil
+}
+
+// unixMode returns mode as the st_mode of Unix, its type, permissions and
+// setuid, setgid and sticky bits.
+func unixMode(mode fs.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 0o1000
+	}
+	switch {
+	case mode.IsDir():
+		unix |= 0o040000
+	case mode&fs.ModeSymlink != 0:
+		unix |= 0o120000
+	case mode&fs.ModeNamedPipe != 0:
+		unix |= 0o010000
+	case mode&fs.ModeSocket != 0:
+		unix |= 0o140000
+	case mode&fs.ModeCharDevice != 0:
+		unix |= 0o020000
+	case mode&fs.ModeDevice != 0:
+		unix |= 0o060000
+	default:
+		unix |= 0o100000
+	}
+	return unix
+}
+
+// fileMode returns the st_mode unix as a fs.FileMode, see unixMode.
+func fileMode(unix uint32) fs.FileMode {
+	mode := fs.FileMode(unix & 0o777)
+	if unix&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if unix&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if unix&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch unix & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	case 0o010000:
+		mode |= fs.ModeNamedPipe
+	case 0o140000:
+		mode |= fs.ModeSocket
+	case 0o020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0o060000:
+		mode |= fs.ModeDevice
+	}
+	return mode
+}
+
+// Interface guard
+var _ archives.Archival = Cpio{}
@@ -0,0 +1,461 @@
+package arc
+
+import (
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Deflate blocks as written by the zopfli encoder: the Huffman codes of a
+// block, its size in bits, and the bit writer. See RFC 1951.
+
+const (
+	deflateMinMatch = 3
+	deflateMaxMatch = 258
+	deflateWindow   = 1 << 15
+	// content of a stored block, they are split beyond
+	storedMaxSize = 0xffff
+	// symbols of the literal/length and distance alphabets
+	litLenSymbols = 286
+	distSymbols   = 30
+	endOfBlock    = 256
+)
+
+// Base values and extra bits of the length codes, from 257, and of the
+// distance codes.
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]int{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// lengthCodes maps match lengths to their code, minus 257.
+var lengthCodes = func() (codes [deflateMaxMatch + 1]int) {
+	// 258 has a code of its own, after the range of code 284 that covers it
+	for code, base := range lengthBase {
+		for length := base; length < base+1<<lengthExtra[code] && length <= deflateMaxMatch; length++ {
+			codes[length] = code
+		}
+	}
+	return codes
+}()
+
+// distCode returns the code of a match distance.
+func distCode(dist int) int {
+	if dist <= 4 {
+		return dist - 1
+	}
+	// two codes per power of two, told apart by the bit below the top one
+	d := dist - 1
+	top := bits.Len(uint(d)) - 1
+	return 2*top + (d>>(top-1))&1
+}
+
+// codeLengthOrder is the order the lengths of the code length code are
+// stored in.
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// lz77Sym is a literal byte, when dist is 0, or a match of length litLen
+// at dist; pos is where it starts in the input.
+type lz77Sym struct {
+	litLen uint16
+	dist   uint16
+	pos    int32
+}
+
+// symbolCounts returns how often each symbol of lz is used, counting the
+// end of block code, and the extra bits of its matches.
+func symbolCounts(lz []lz77Sym) (lit [litLenSymbols]int, dist [distSymbols]int, extra int) {
+	lit[endOfBlock] = 1
+	for _, s := range lz {
+		if s.dist == 0 {
+			lit[s.litLen]++
+			continue
+		}
+		lc := lengthCodes[s.litLen]
+		dc := distCode(int(s.dist))
+		lit[257+lc]++
+		dist[dc]++
+		extra += lengthExtra[lc] + distExtra[dc]
+	}
+	return lit, dist, extra
+}
+
+// pmNode is a leaf or a package of the package-merge algorithm.
+type pmNode struct {
+	weight      int
+	symbol      int
+	left, right *pmNode
+}
+
+// huffmanLengths returns the lengths of an optimal prefix code for freqs of
+// at most maxBits bits, by package-merge. Unused symbols get no code, a
+// single used one gets a 1 bit code.
+func huffmanLengths(freqs []int, maxBits int) []uint8 {
+	lengths := make([]uint8, len(freqs))
+	var leaves []*pmNode
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			leaves = append(leaves, &pmNode{weight: freq, symbol: symbol})
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[leaves[0].symbol] = 1
+		return lengths
+	}
+	slices.SortStableFunc(leaves, func(a, b *pmNode) int { return a.weight - b.weight })
+
+	list := leaves
+	for range maxBits - 1 {
+		merged := make([]*pmNode, 0, len(leaves)+len(list)/2)
+		i := 0
+		for j := 0; j+1 < len(list); j += 2 {
+			pkg := &pmNode{weight: list[j].weight + list[j+1].weight, symbol: -1, left: list[j], right: list[j+1]}
+			for i < len(leaves) && leaves[i].weight <= pkg.weight {
+				merged = append(merged, leaves[i])
+				i++
+			}
+			merged = append(merged, pkg)
+		}
+		list = append(merged, leaves[i:]...)
+	}
+
+	// each time a symbol is among the first 2n-2 items, its code gets
+	// a bit longer
+	var count func(n *pmNode)
+	count = func(n *pmNode) {
+		if n.symbol >= 0 {
+			lengths[n.symbol]++
+			return
+		}
+		count(n.left)
+		count(n.right)
+	}
+	for _, n := range list[:2*len(leaves)-2] {
+		count(n)
+	}
+	return lengths
+}
+
+// completeDistLengths gives a distance code at least two symbols, as some
+// decoders reject codes of fewer.
+func completeDistLengths(lengths []uint8) {
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	switch used {
+	case 0:
+		lengths[0], lengths[1] = 1, 1
+	case 1:
+		if lengths[0] > 0 {
+			lengths[1] = 1
+		} else {
+			lengths[0] = 1
+		}
+	}
+}
+
+// canonicalCodes returns the codes of lengths, bit reversed since deflate
+// writes them from their most significant bit into an LSB first stream.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[symbol] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+		next[l]++
+	}
+	return codes
+}
+
+// clSym is a symbol of the code length code, with the value of its extra
+// bits for repeats.
+type clSym struct {
+	symbol, extra int
+}
+
+// dynamicTrees are the Huffman codes of a dynamic block and its header.
+type dynamicTrees struct {
+	litLens, distLens []uint8
+	hlit, hdist       int
+	clLens            []uint8
+	hclen             int
+	rle               []clSym
+}
+
+// newDynamicTrees computes the codes of a dynamic block with the given
+// symbol counts.
+func newDynamicTrees(lit [litLenSymbols]int, dist [distSymbols]int) *dynamicTrees {
+	t := &dynamicTrees{
+		litLens:  huffmanLengths(lit[:], 15),
+		distLens: huffmanLengths(dist[:], 15),
+		hlit:     257,
+		hdist:    1,
+		hclen:    4,
+	}
+	completeDistLengths(t.distLens)
+	for i, l := range t.litLens {
+		if l > 0 && i >= t.hlit {
+			t.hlit = i + 1
+		}
+	}
+	for i, l := range t.distLens {
+		if l > 0 && i >= t.hdist {
+			t.hdist = i + 1
+		}
+	}
+
+	// run length encode the lengths of both codes as one sequence
+	lengths := append(slices.Clone(t.litLens[:t.hlit]), t.distLens[:t.hdist]...)
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				t.rle = append(t.rle, clSym{18, n - 11})
+				run -= n
+			}
+			if run >= 3 {
+				t.rle = append(t.rle, clSym{17, run - 3})
+				run = 0
+			}
+		} else {
+			t.rle = append(t.rle, clSym{int(l), 0})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				t.rle = append(t.rle, clSym{16, n - 3})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			t.rle = append(t.rle, clSym{int(l), 0})
+		}
+	}
+
+	var clCounts [19]int
+	for _, s := range t.rle {
+		clCounts[s.symbol]++
+	}
+	t.clLens = huffmanLengths(clCounts[:], 7)
+	// decoders reject a code length code with a single symbol
+	completeDistLengths(t.clLens)
+	for i, symbol := range codeLengthOrder {
+		if t.clLens[symbol] > 0 && i >= t.hclen {
+			t.hclen = i + 1
+		}
+	}
+	return t
+}
+
+// clExtraBits are the extra bits of the repeat symbols of the code length
+// code.
+var clExtraBits = [19]int{16: 2, 17: 3, 18: 7}
+
+// bits returns the size of a dynamic block with these codes.
+func (t *dynamicTrees) bits(lit [litLenSymbols]int, dist [distSymbols]int, extra int) int {
+	size := 3 + 5 + 5 + 4 + 3*t.hclen + extra
+	for _, s := range t.rle {
+		size += int(t.clLens[s.symbol]) + clExtraBits[s.symbol]
+	}
+	for i, n := range lit {
+		size += n * int(t.litLens[i])
+	}
+	for i, n := range dist {
+		size += n * int(t.distLens[i])
+	}
+	return size
+}
+
+// fixedLitLens and fixedDistLens are the codes of fixed blocks.
+var (
+	fixedLitLens = func() []uint8 {
+		lengths := make([]uint8, 288)
+		for i := range lengths {
+			switch {
+			case i < 144:
+				lengths[i] = 8
+			case i < 256:
+				lengths[i] = 9
+			case i < 280:
+				lengths[i] = 7
+			default:
+				lengths[i] = 8
+			}
+		}
+		return lengths
+	}()
+	fixedDistLens = slices.Repeat([]uint8{5}, 32)
+)
+
+// fixedBits returns the size of a fixed block with these symbol counts.
+func fixedBits(lit [litLenSymbols]int, dist [distSymbols]int, extra int) int {
+	size := 3 + extra
+	for i, n := range lit {
+		size += n * int(fixedLitLens[i])
+	}
+	for _, n := range dist {
+		size += n * 5
+	}
+	return size
+}
+
+// storedBits returns the largest size of stored blocks holding n bytes.
+func storedBits(n int) int {
+	blocks := max(1, (n+storedMaxSize-1)/storedMaxSize)
+	return blocks*(3+7+32) + 8*n
+}
+
+// Kinds of deflate blocks.
+const (
+	blockStored = iota
+	blockFixed
+	blockDynamic
+)
+
+// blockCost returns the smallest kind of block for lz, covering size bytes
+// of input, and its size in bits.
+func blockCost(lz []lz77Sym, size int) (kind, cost int) {
+	lit, dist, extra := symbolCounts(lz)
+	kind, cost = blockStored, storedBits(size)
+	if fixed := fixedBits(lit, dist, extra); fixed < cost {
+		kind, cost = blockFixed, fixed
+	}
+	if dynamic := newDynamicTrees(lit, dist).bits(lit, dist, extra); dynamic < cost {
+		kind, cost = blockDynamic, dynamic
+	}
+	return kind, cost
+}
+
+// bitWriter writes the LSB first bit stream of deflate.
+type bitWriter struct {
+	w     io.Writer
+	bits  uint64
+	nbits uint
+	buf   []byte
+	err   error
+}
+
+func (b *bitWriter) writeBits(value, n int) {
+	b.bits |= uint64(value) << b.nbits
+	b.nbits += uint(n)
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+	if len(b.buf) >= 64<<10 {
+		b.flush()
+	}
+}
+
+// align pads the stream to a byte boundary.
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.writeBits(0, int(8-b.nbits))
+	}
+}
+
+func (b *bitWriter) flush() error {
+	if b.err == nil && len(b.buf) > 0 {
+		_, b.err = b.w.Write(b.buf)
+	}
+	b.buf = b.buf[:0]
+	return b.err
+}
+
+// writeStored writes data as stored blocks.
+func (b *bitWriter) writeStored(data []byte, final bool) {
+	for {
+		n := min(len(data), storedMaxSize)
+		last := final && n == len(data)
+		b.writeBits(boolBit(last), 1)
+		b.writeBits(blockStored, 2)
+		b.align()
+		b.writeBits(n, 16)
+		b.writeBits(^n&0xffff, 16)
+		b.buf = append(b.buf, data[:n]...)
+		data = data[n:]
+		if len(data) == 0 {
+			return
+		}
+	}
+}
+
+// writeSymbols writes the symbols of lz and the end of block code with the
+// given codes.
+func (b *bitWriter) writeSymbols(lz []lz77Sym, litLens, distLens []uint8) {
+	litCodes, distCodes := canonicalCodes(litLens), canonicalCodes(distLens)
+	for _, s := range lz {
+		if s.dist == 0 {
+			b.writeBits(int(litCodes[s.litLen]), int(litLens[s.litLen]))
+			continue
+		}
+		lc, dc := lengthCodes[s.litLen], distCode(int(s.dist))
+		b.writeBits(int(litCodes[257+lc]), int(litLens[257+lc]))
+		b.writeBits(int(s.litLen)-lengthBase[lc], lengthExtra[lc])
+		b.writeBits(int(distCodes[dc]), int(distLens[dc]))
+		b.writeBits(int(s.dist)-distBase[dc], distExtra[dc])
+	}
+	b.writeBits(int(litCodes[endOfBlock]), int(litLens[endOfBlock]))
+}
+
+// writeBlock writes lz, the symbols of data, as a block of the given kind.
+func (b *bitWriter) writeBlock(kind int, lz []lz77Sym, data []byte, final bool) {
+	switch kind {
+	case blockStored:
+		b.writeStored(data, final)
+	case blockFixed:
+		b.writeBits(boolBit(final), 1)
+		b.writeBits(blockFixed, 2)
+		b.writeSymbols(lz, fixedLitLens, fixedDistLens)
+	case blockDynamic:
+		lit, dist, _ := symbolCounts(lz)
+		t := newDynamicTrees(lit, dist)
+		b.writeBits(boolBit(final), 1)
+		b.writeBits(blockDynamic, 2)
+		b.writeBits(t.hlit-257, 5)
+		b.writeBits(t.hdist-1, 5)
+		b.writeBits(t.hclen-4, 4)
+		for _, symbol := range codeLengthOrder[:t.hclen] {
+			b.writeBits(int(t.clLens[symbol]), 3)
+		}
+		clCodes := canonicalCodes(t.clLens)
+		for _, s := range t.rle {
+			b.writeBits(int(clCodes[s.symbol]), int(t.clLens[s.symbol]))
+			b.writeBits(s.extra, clExtraBits[s.symbol])
+		}
+		b.writeSymbols(lz, t.litLens, t.distLens)
+	}
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package arc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// deterministicTime is the mtime of every entry in deterministic mode when
+// SOURCE_DATE_EPOCH is unset, the earliest time zip can store.
+var deterministicTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WithDeterministic makes identical inputs produce byte-identical archives:
+// entries are sorted by name, owners and groups are dropped, and mtimes are
+// set to 1980-01-01 UTC or, when SOURCE_DATE_EPOCH is set, clamped to it.
+// Encryption uses random keys and defeats the purpose. ArchiveFromSource
+// keeps the order of the source.
+func WithDeterministic() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
+
+// sourceDateEpoch returns the time all mtimes are set or clamped to, and
+// whether they are clamped, see https://reproducible-builds.org/specs/source-date-epoch/
+func sourceDateEpoch() (time.Time, bool, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return deterministicTime, false, nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+	}
+	return time.Unix(seconds, 0).UTC(), true, nil
+}
+
+// sortFiles orders files by their name in the archive.
+func sortFiles(files []archives.FileInfo) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].NameInArchive < files[j].NameInArchive
+	})
+}
+
+// normalizeFile strips the metadata of f that depends on the machine and the
+// time the archive is created.
+func normalizeFile(f archives.FileInfo, epoch time.Time, clamp bool) archives.FileInfo {
+	modTime := epoch
+	if clamp && f.ModTime().Before(epoch) {
+		modTime = f.ModTime().UTC().Truncate(time.Second)
+	}
+	f.FileInfo = normalizedInfo{FileInfo: f.FileInfo, modTime: modTime}
+	return f
+}
+
+// normalizedInfo overrides the mtime, and hides the system specific data
+// archivers take uid, gid, user and group names from.
+type normalizedInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (n normalizedInfo) ModTime() time.Time { return n.modTime }
+func (normalizedInfo) Sys() any             { return nil }
+
+// utcFile makes the mtime of f UTC. Zip stores mtimes in the local time of
+// the machine creating the archive, besides the extended timestamp that not
+// every reader knows, so archives created in different time zones would
+// otherwise disagree on the mtimes of the same files.
+func utcFile(f archives.FileInfo) archives.FileInfo {
+	if f.FileInfo == nil {
+		return f
+	}
+	f.FileInfo = utcInfo{f.FileInfo}
+	return f
+}
+
+// utcInfo reports the mtime of its FileInfo in UTC.
+type utcInfo struct {
+	fs.FileInfo
+}
+
+func (u utcInfo) ModTime() time.Time { return u.FileInfo.ModTime().UTC() }
@@ -0,0 +1,189 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// diagnoseHeadSize is how much of the start of an archive is kept to
+// explain why reading it failed.
+const diagnoseHeadSize = 4 << 10
+
+// HintError is an error reading an archive, with its likely causes found
+// by looking at the start of the archive, like an HTML error page saved in
+// place of the archive, a truncated download or a wrong extension.
+type HintError struct {
+	Err   error
+	Hints []string
+}
+
+func (e *HintError) Error() string {
+	return fmt.Sprintf("%v; likely cause: %s", e.Err, strings.Join(e.Hints, "; or "))
+}
+
+func (e *HintError) Unwrap() error {
+	return e.Err
+}
+
+// diagnoser keeps the start of an archive being read, to add hints to the
+// errors reading it.
+type diagnoser struct {
+	name string
+	head []byte
+	// at reads the head of archives with random access, which are left
+	// unwrapped
+	at io.ReaderAt
+}
+
+// reader records the start of what is read from r.
+func (d *diagnoser) reader(r io.Reader) io.Reader {
+	if at, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		d.at = at
+		return r
+	}
+	return &headRecorder{Reader: r, d: d}
+}
+
+// wrap returns err as a HintError if the start of the archive explains it.
+// The content of the archive is only looked into when it failed early,
+// before the second entry, later failures come from the entries.
+func (d *diagnoser) wrap(err error, early bool) error {
+	switch {
+	case err == nil, errors.Is(err, fs.SkipAll), errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrLimitExceeded),
+		errors.Is(err, ErrPassword), errors.Is(err, ErrNotAllowed):
+		return err
+	}
+	var hints []string
+	if early {
+		if d.at != nil {
+			head := make([]byte, diagnoseHeadSize)
+			n, _ := d.at.ReadAt(head, 0)
+			d.head = head[:n]
+		}
+		hints = diagnoseHead(d.name, d.head)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || (early && errors.Is(err, io.EOF)) {
+		hints = append(hints, "the archive is truncated, like by an interrupted download or copy")
+	}
+	if len(hints) == 0 {
+		return err
+	}
+	return &HintError{Err: err, Hints: hints}
+}
+
+// diagnoseHead returns what is wrong with an archive called name starting
+// with head, if it shows.
+func diagnoseHead(name string, head []byte) []string {
+	text := bytes.ToLower(bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))))
+	switch {
+	case len(head) == 0:
+		return []string{"the archive is empty, like when a download failed"}
+	case bytes.HasPrefix(text, []byte("<!doctype html")) || bytes.HasPrefix(text, []byte("<html")) ||
+		bytes.HasPrefix(text, []byte("<head")):
+		return []string{"it is an HTML page, not an archive, like the error or login page of a web server answering in place of the file"}
+	case bytes.HasPrefix(text, []byte("<?xml")):
+		return []string{"it is an XML document, not an archive, like the error response of object storage"}
+	case bytes.HasPrefix(text, []byte("{")) && isText(head):
+		return []string{"it is a JSON document, not an archive, like the error response of an API"}
+	case isText(head):
+		return []string{fmt.Sprintf("it is a text file, not an archive, starting with %q", firstLine(head))}
+	}
+
+	detected := detectFormat(head)
+	if detected == nil {
+		return nil
+	}
+	compression, archival, err := FormatFromName(name)
+	if err != nil {
+		return nil
+	}
+	mismatch := false
+	switch f := detected.(type) {
+	case archives.Compression:
+		mismatch = compression == nil || compression.Extension() != f.Extension()
+	default:
+		mismatch = compression != nil || archival == nil || archival.Extension() != f.Extension()
+	}
+	if !mismatch {
+		return nil
+	}
+	return []string{fmt.Sprintf("its content is %s but its name %s says otherwise, it may have the wrong extension", detected.Extension(), name)}
+}
+
+// detectFormat returns the format head starts with, by its magic bytes
+// alone, nil if none. Brotli has none.
+func detectFormat(head []byte) archives.Format {
+	formats := []archives.Format{archives.SevenZip{}}
+	for _, c := range compressions() {
+		if _, ok := c.(archives.Brotli); !ok {
+			formats = append(formats, c)
+		}
+	}
+	for _, a := range archivals() {
+		formats = append(formats, a)
+	}
+	for _, e := range extractions() {
+		if f, ok := e.(archives.Format); ok {
+			formats = append(formats, f)
+		}
+	}
+	for _, f := range formats {
+		m, err := f.Match(context.Background(), "", bytes.NewReader(head))
+		if err == nil && m.ByStream {
+			return f
+		}
+	}
+	return nil
+}
+
+// isText reports whether head is printable UTF-8 text, archives have binary
+// headers. The last character may be cut.
+func isText(head []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if len(head) == 0 || !utf8.Valid(head) {
+		return false
+	}
+	for _, r := range string(head) {
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// firstLine returns the first line of head, shortened.
+func firstLine(head []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(head)), "\n")
+	if len(line) > 60 {
+		line = line[:60] + "..."
+	}
+	return line
+}
+
+// headRecorder keeps the first diagnoseHeadSize bytes read.
+type headRecorder struct {
+	io.Reader
+	d *diagnoser
+}
+
+func (r *headRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if room := diagnoseHeadSize - len(r.d.head); room > 0 && n > 0 {
+		r.d.head = append(r.d.head, p[:min(n, room)]...)
+	}
+	return n, err
+}
@@ -0,0 +1,210 @@
+package arc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Statuses of the entries compared by Diff, besides DiffChanged and
+// DiffIdentical.
+const (
+	// DiffAdded is an entry only the new tree has
+	DiffAdded DiffStatus = "added"
+	// DiffRemoved is an entry only the old tree has
+	DiffRemoved DiffStatus = "removed"
+)
+
+// EntryDiff is an entry of the trees compared by Diff. The sizes and
+// SHA-256 are those of regular files, they are zero and empty in a tree
+// that doesn't have the entry.
+type EntryDiff struct {
+	// Name is the path of the entry from the root of its tree
+	Name   string
+	Status DiffStatus
+	// Type is file, dir, symlink or other, in the new tree if it has the
+	// entry
+	Type      string
+	OldSize   int64
+	NewSize   int64
+	OldSHA256 string
+	NewSHA256 string
+}
+
+// treeEntry is an entry of an archive or a directory compared by Diff.
+type treeEntry struct {
+	typ    string
+	size   int64
+	sha256 string
+	link   string
+}
+
+// Diff compares two trees, each an archive or a directory, like two
+// releases of a bundle or an archive and where it was deployed, and returns
+// the entries of both sorted by name. Regular files are compared by size and
+// SHA-256, symlinks by target and other entries by type. Names of archives
+// are taken without a leading "./", after WithStripComponents and
+// WithRename, and their hardlinks aren't listed.
+// oldTree: the archive or directory compared against
+// newTree: the archive or directory to compare
+// opts: optional settings, like WithPassword and WithDecryption for
+// encrypted archives
+func Diff(oldTree, newTree string, opts ...Option) ([]EntryDiff, error) {
+	o := newOptions(opts)
+	logging("Comparing %s with %s", newTree, oldTree)
+
+	oldEntries, err := readTree(oldTree, o)
+	if err != nil {
+		return nil, err
+	}
+	newEntries, err := readTree(newTree, o)
+	if err != nil {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(oldEntries))
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	diff := make([]EntryDiff, 0, len(names))
+	for _, name := range names {
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+		entry := EntryDiff{
+			Name:      name,
+			Type:      newEntry.typ,
+			OldSize:   oldEntry.size,
+			NewSize:   newEntry.size,
+			OldSHA256: oldEntry.sha256,
+			NewSHA256: newEntry.sha256,
+		}
+		switch {
+		case !inNew:
+			entry.Status, entry.Type = DiffRemoved, oldEntry.typ
+		case !inOld:
+			entry.Status = DiffAdded
+		case oldEntry != newEntry:
+			entry.Status = DiffChanged
+		default:
+			entry.Status = DiffIdentical
+		}
+		diff = append(diff, entry)
+	}
+	return diff, nil
+}
+
+// readTree returns the entries of the archive or directory tree by name.
+func readTree(tree string, o *options) (map[string]treeEntry, error) {
+	info, err := os.Stat(tree)
+	if err != nil {
+		return nil, fmt.Errorf("comparing %s: %w", tree, err)
+	}
+	if info.IsDir() {
+		return readDirTree(tree)
+	}
+
+	entries := make(map[string]treeEntry)
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		f, ok := rewriteEntry(f, o)
+		if !ok || (f.Mode()&fs.ModeSymlink == 0 && f.LinkTarget != "") {
+			return nil
+		}
+		name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+		if name == "" {
+			return nil
+		}
+		entry := treeEntry{typ: entryType(f.Mode()), link: f.LinkTarget}
+		if f.Mode().IsRegular() {
+			sum, err := hashEntry(f)
+			if err != nil {
+				return err
+			}
+			entry.size, entry.sha256 = f.Size(), hex.EncodeToString(sum)
+		}
+		entries[name] = entry
+		return nil
+	}
+	if err := extractArchive(tree, handler, o); err != nil {
+		return nil, fmt.Errorf("comparing %s: %w", tree, err)
+	}
+	return entries, nil
+}
+
+// readDirTree returns the entries below the directory root by name.
+func readDirTree(root string) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := treeEntry{typ: entryType(info.Mode())}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if entry.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			sum, err := sha256File(p)
+			if err != nil {
+				return err
+			}
+			entry.size, entry.sha256 = info.Size(), sum
+		}
+		entries[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("comparing %s: %w", root, err)
+	}
+	return entries, nil
+}
+
+// sha256File returns the hex SHA-256 of the file at name.
+func sha256File(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read %s: %w", name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// entryType names the type of mode: file, dir, symlink or other.
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsRegular():
+		return "file"
+	}
+	return "other"
+}
@@ -0,0 +1,234 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// ageMagic starts every binary age file
+const ageMagic = "age-encryption.org/v1\n"
+
+// encryptionExts are the file extensions stripped from encrypted archives
+// before their inner format is identified
+var encryptionExts = []string{".age", ".gpg", ".pgp"}
+
+// WithEncryption encrypts the whole output stream of the archive, whatever
+// its format, to produce tar.zst.age style files. Each recipient is an age
+// public key ("age1..."), an SSH public key, or the path to a file holding
+// age recipients or an OpenPGP public key (armored or binary). age and
+// OpenPGP recipients can't be mixed.
+func WithEncryption(recipients ...string) Option {
+	return func(o *options) {
+		o.recipients = append(o.recipients, recipients...)
+	}
+}
+
+// WithDecryption makes extraction decrypt age and OpenPGP encrypted archives,
+// detected by their magic bytes. Each identity is an age secret key
+// ("AGE-SECRET-KEY-1..."), or the path to an age identity file, an SSH
+// private key or an unencrypted OpenPGP secret key.
+func WithDecryption(identities ...string) Option {
+	return func(o *options) {
+		o.identities = append(o.identities, identities...)
+	}
+}
+
+// encryptWriter wraps w so that everything written is encrypted for the
+// recipients of o. The returned writer must be closed to flush the stream,
+// it doesn't close w.
+func encryptWriter(w io.Writer, o *options) (io.WriteCloser, error) {
+	ageRecipients, pgpKeys, err := parseRecipients(o.recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(pgpKeys) > 0 {
+		logging("Encrypting for %d OpenPGP keys", len(pgpKeys))
+		return openpgp.Encrypt(w, pgpKeys, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	}
+	logging("Encrypting for %d age recipients", len(ageRecipients))
+	return age.Encrypt(w, ageRecipients...)
+}
+
+// parseRecipients resolves the recipients given to WithEncryption.
+func parseRecipients(recipients []string) ([]age.Recipient, openpgp.EntityList, error) {
+	var ageRecipients []age.Recipient
+	var pgpKeys openpgp.EntityList
+	for _, r := range recipients {
+		switch {
+		case strings.HasPrefix(r, "age1"):
+			recipient, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse recipient %s: %w", r, err)
+			}
+			ageRecipients = append(ageRecipients, recipient)
+		case strings.HasPrefix(r, "ssh-") || strings.HasPrefix(r, "ecdsa-"):
+			recipient, err := agessh.ParseRecipient(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse recipient %s: %w", r, err)
+			}
+			ageRecipients = append(ageRecipients, recipient)
+		default:
+			data, err := os.ReadFile(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("read recipient file: %w", err)
+			}
+			if isPGPKey(data) {
+				keys, err := readPGPKeys(data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("parse OpenPGP key %s: %w", r, err)
+				}
+				pgpKeys = append(pgpKeys, keys...)
+				continue
+			}
+			parsed, err := age.ParseRecipients(bytes.NewReader(data))
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse recipient file %s: %w", r, err)
+			}
+			ageRecipients = append(ageRecipients, parsed...)
+		}
+	}
+	if len(ageRecipients) > 0 && len(pgpKeys) > 0 {
+		return nil, nil, errors.New("age and OpenPGP recipients can't be mixed")
+	}
+	return ageRecipients, pgpKeys, nil
+}
+
+// parseIdentities resolves the identities given to WithDecryption.
+func parseIdentities(identities []string) ([]age.Identity, openpgp.EntityList, error) {
+	var ageIdentities []age.Identity
+	var pgpKeys openpgp.EntityList
+	for _, id := range identities {
+		if strings.HasPrefix(id, "AGE-SECRET-KEY-1") {
+			identity, err := age.ParseX25519Identity(id)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse identity: %w", err)
+			}
+			ageIdentities = append(ageIdentities, identity)
+			continue
+		}
+
+		data, err := os.ReadFile(id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read identity file: %w", err)
+		}
+		switch {
+		case isPGPKey(data):
+			keys, err := readPGPKeys(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse OpenPGP key %s: %w", id, err)
+			}
+			pgpKeys = append(pgpKeys, keys...)
+		case bytes.HasPrefix(data, []byte("-----BEGIN")):
+			identity, err := agessh.ParseIdentity(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse SSH key %s: %w", id, err)
+			}
+			ageIdentities = append(ageIdentities, identity)
+		default:
+			parsed, err := age.ParseIdentities(bytes.NewReader(data))
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse identity file %s: %w", id, err)
+			}
+			ageIdentities = append(ageIdentities, parsed...)
+		}
+	}
+	return ageIdentities, pgpKeys, nil
+}
+
+// isPGPKey reports whether data looks like an armored or binary OpenPGP key.
+func isPGPKey(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("-----BEGIN PGP ")) {
+		return true
+	}
+	return len(data) > 0 && isPGPPacket(data[0], 5, 6)
+}
+
+func readPGPKeys(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(data, []byte("-----BEGIN PGP ")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// isPGPPacket reports whether b is the header byte of an OpenPGP packet with
+// one of the given tags, in either the old or the new packet format.
+func isPGPPacket(b byte, tags ...byte) bool {
+	if b&0x80 == 0 {
+		return false
+	}
+	tag := (b >> 2) & 0x0f
+	if b&0x40 != 0 {
+		tag = b & 0x3f
+	}
+	for _, t := range tags {
+		if tag == t {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptInput detects an encrypted archive by its magic bytes and returns
+// the decrypted content, and whether it was encrypted. Unencrypted input is
+// returned as is when it can be peeked with ReadAt, and buffered otherwise.
+// OpenPGP messages have no magic of their own, so they are only looked for
+// when identities were given.
+func decryptInput(r io.Reader, o *options) (io.Reader, bool, error) {
+	header := make([]byte, len(ageMagic))
+	if ra, ok := r.(io.ReaderAt); ok {
+		n, _ := ra.ReadAt(header, 0)
+		header = header[:n]
+	} else {
+		br := bufio.NewReader(r)
+		header, _ = br.Peek(len(ageMagic))
+		r = br
+	}
+
+	isAge := string(header) == ageMagic
+	// public key or symmetric key encrypted session key packets
+	isPGP := len(o.identities) > 0 && len(header) > 0 && isPGPPacket(header[0], 1, 3)
+	if !isAge && !isPGP {
+		return r, false, nil
+	}
+	if len(o.identities) == 0 {
+		return nil, true, errors.New("archive is encrypted with age, no identity to decrypt it")
+	}
+
+	ageIdentities, pgpKeys, err := parseIdentities(o.identities)
+	if err != nil {
+		return nil, true, err
+	}
+	if isAge {
+		logging("Decrypting age encrypted archive")
+		decrypted, err := age.Decrypt(r, ageIdentities...)
+		return decrypted, true, err
+	}
+	logging("Decrypting OpenPGP encrypted archive")
+	md, err := openpgp.ReadMessage(r, pgpKeys, nil, nil)
+	if err != nil {
+		return nil, true, fmt.Errorf("decrypt OpenPGP message: %w", err)
+	}
+	return md.UnverifiedBody, true, nil
+}
+
+// trimEncryptionExt removes the extension of the encryption layer from
+// name, so the inner format can be recognized by its own extension.
+func trimEncryptionExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range encryptionExts {
+		if ext == e {
+			return strings.TrimSuffix(name, filepath.Ext(name))
+		}
+	}
+	return name
+}
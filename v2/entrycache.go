@@ -0,0 +1,326 @@
+package arc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// EntryCache keeps the content of archive entries read with ExtractEntry or
+// through OpenArchiveFS in a directory, so reading them again, like a server
+// sending the same files out of archives, skips decompressing them. Entries
+// are keyed by the hash of the path, size and mtime of their archive and of
+// their name, so an archive that is replaced gets cached anew. Once the
+// cache grows past its size, the least recently used entries are evicted.
+// It is safe for concurrent use, and the directory keeps the cache between
+// runs.
+type EntryCache struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	items map[string]*list.Element
+	// most recently used first
+	lru list.List
+}
+
+// cacheItem is an entry stored in the cache.
+type cacheItem struct {
+	key  string
+	size int64
+}
+
+// NewEntryCache opens the cache in dir, created if needed, holding up to
+// maxSize bytes of entries. Entries left by earlier runs are kept, in the
+// order of their last use.
+func NewEntryCache(dir string, maxSize int64) (*EntryCache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("entry cache size must be positive, not %d", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating entry cache: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening entry cache: %w", err)
+	}
+	c := &EntryCache{dir: dir, maxSize: maxSize, items: make(map[string]*list.Element)}
+
+	// the mtime of an entry is the time it was last used
+	type stored struct {
+		item    cacheItem
+		modTime time.Time
+	}
+	var found []stored
+	for _, entry := range entries {
+		if len(entry.Name()) != sha256.Size*2 || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, stored{cacheItem{entry.Name(), info.Size()}, info.ModTime()})
+	}
+	slices.SortFunc(found, func(a, b stored) int { return b.modTime.Compare(a.modTime) })
+	for _, s := range found {
+		c.items[s.item.key] = c.lru.PushBack(s.item)
+		c.size += s.item.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// WithEntryCache makes ExtractEntry and OpenArchiveFS read entries through
+// cache, see EntryCache.
+func WithEntryCache(cache *EntryCache) Option {
+	return func(o *options) {
+		o.entryCache = cache
+	}
+}
+
+// archiveKey identifies the current content of archive, for entryKey.
+func archiveKey(archive string) (string, error) {
+	abs, err := filepath.Abs(archive)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d", abs, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// entryKey returns the key of the entry called name of the archive with
+// the key archive.
+func entryKey(archive, name string) string {
+	sum := sha256.Sum256([]byte(archive + "\x00" + cleanEntryName(name)))
+	return hex.EncodeToString(sum[:])
+}
+
+// open returns the cached content of key, and marks it used.
+func (c *EntryCache) open(key string) (*os.File, bool) {
+	path := filepath.Join(c.dir, key)
+	f, err := os.Open(path)
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	switch {
+	case err != nil && ok:
+		// evicted by another process sharing the directory
+		c.remove(elem)
+	case err == nil && ok:
+		c.lru.MoveToFront(elem)
+	case err == nil:
+		// stored by another process
+		if info, statErr := f.Stat(); statErr == nil {
+			c.items[key] = c.lru.PushFront(cacheItem{key, info.Size()})
+			c.size += info.Size()
+			c.evict()
+		}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	logging("Entry cache hit: %s", key)
+	return f, true
+}
+
+// create returns a writer storing the content of key once committed.
+func (c *EntryCache) create(key string) (*cacheWriter, error) {
+	f, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return nil, fmt.Errorf("entry cache: %w", err)
+	}
+	return &cacheWriter{File: f, cache: c, key: key}, nil
+}
+
+// evict removes the least recently used entries until the cache fits in
+// its size, c.mu must be held.
+func (c *EntryCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		logging("Evicting entry %s from the cache", elem.Value.(cacheItem).key)
+		os.Remove(filepath.Join(c.dir, elem.Value.(cacheItem).key))
+		c.remove(elem)
+	}
+}
+
+// remove forgets elem, c.mu must be held.
+func (c *EntryCache) remove(elem *list.Element) {
+	item := elem.Value.(cacheItem)
+	if c.items[item.key] != elem {
+		return
+	}
+	delete(c.items, item.key)
+	c.lru.Remove(elem)
+	c.size -= item.size
+}
+
+// cacheWriter writes the content of an entry to a temporary file of the
+// cache, until it is committed or discarded.
+type cacheWriter struct {
+	*os.File
+	cache *EntryCache
+	key   string
+	size  int64
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// commit stores the content written, entries larger than the whole cache
+// are discarded.
+func (w *cacheWriter) commit() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("entry cache: %w", err)
+	}
+	c := w.cache
+	if w.size > c.maxSize {
+		return os.Remove(w.Name())
+	}
+	if err := os.Rename(w.Name(), filepath.Join(c.dir, w.key)); err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("entry cache: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[w.key]; ok {
+		c.remove(elem)
+	}
+	c.items[w.key] = c.lru.PushFront(cacheItem{w.key, w.size})
+	c.size += w.size
+	c.evict()
+	return nil
+}
+
+// discard drops the content written.
+func (w *cacheWriter) discard() {
+	w.File.Close()
+	os.Remove(w.Name())
+}
+
+// extract copies the entry called name of archive to w from the cache, or
+// with read while storing it in the cache.
+func (c *EntryCache) extract(archive, name string, w io.Writer, read func(w io.Writer) error) error {
+	archiveID, err := archiveKey(archive)
+	if err != nil {
+		return read(w)
+	}
+	key := entryKey(archiveID, name)
+	if f, ok := c.open(key); ok {
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("copy %s: %w", name, err)
+		}
+		return nil
+	}
+
+	cw, err := c.create(key)
+	if err != nil {
+		return err
+	}
+	if err := read(io.MultiWriter(w, cw)); err != nil {
+		cw.discard()
+		return err
+	}
+	if err := cw.commit(); err != nil {
+		logging("Not caching %s: %v", name, err)
+	}
+	return nil
+}
+
+// cachedFS reads the regular files of an archive file system through an
+// EntryCache.
+type cachedFS struct {
+	fsys      fs.FS
+	cache     *EntryCache
+	archiveID string
+}
+
+func (c cachedFS) Stat(name string) (fs.FileInfo, error) { return fs.Stat(c.fsys, name) }
+
+func (c cachedFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(c.fsys, name) }
+
+func (c cachedFS) Open(name string) (fs.File, error) {
+	key := entryKey(c.archiveID, name)
+	if f, ok := c.cache.open(key); ok {
+		return &cachedEntry{File: f, stat: func() (fs.FileInfo, error) { return fs.Stat(c.fsys, name) }}, nil
+	}
+	f, err := c.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return f, nil
+	}
+	cw, err := c.cache.create(key)
+	if err != nil {
+		return f, nil
+	}
+	return &fillingFile{File: f, cw: cw}, nil
+}
+
+// cachedEntry is a file of a cachedFS read from the cache, its info comes
+// from the archive when asked for.
+type cachedEntry struct {
+	*os.File
+	stat func() (fs.FileInfo, error)
+}
+
+func (f *cachedEntry) Stat() (fs.FileInfo, error) { return f.stat() }
+
+// fillingFile is a file of a cachedFS read from the archive, and stored in
+// the cache when it was read to the end.
+type fillingFile struct {
+	fs.File
+	cw  *cacheWriter
+	eof bool
+	err error
+}
+
+func (f *fillingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if n > 0 && f.err == nil {
+		_, f.err = f.cw.Write(p[:n])
+	}
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+func (f *fillingFile) Close() error {
+	err := f.File.Close()
+	if f.cw == nil {
+		return err
+	}
+	if f.eof && f.err == nil {
+		if commitErr := f.cw.commit(); commitErr != nil {
+			logging("Not caching %s: %v", f.cw.key, commitErr)
+		}
+	} else {
+		f.cw.discard()
+	}
+	f.cw = nil
+	return err
+}
@@ -0,0 +1,195 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// EntryError is returned when extracting or creating an archive fails at
+// one of its entries. It tells where in the archive the failure is, so a
+// corrupt multi-GB archive can be looked into around Offset instead of
+// bisected.
+type EntryError struct {
+	// Archive is the name of the archive read or written
+	Archive string
+	// Entry is the name of the entry in the archive, "" when reading failed
+	// between two entries, like on a corrupt header
+	Entry string
+	// Index is the position of the entry in the archive, from 0. When Entry
+	// is "", it is the position of the entry that couldn't be read
+	Index int
+	// Offset is how many bytes of the archive were read or written when it
+	// failed. It is approximate: compressed archives are read ahead and
+	// written in blocks. For zip archives read in random order, it is the end
+	// of the last read
+	Offset int64
+	Err    error
+}
+
+func (e *EntryError) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("%s: entry %d, near byte %d: %v", e.Archive, e.Index, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s: entry %d %q, near byte %d: %v", e.Archive, e.Index, e.Entry, e.Offset, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// entryTracker follows the entry being read or written and the offset in
+// the archive, to turn the errors of an operation into EntryErrors.
+type entryTracker struct {
+	archive string
+	// index is the position of the current entry, -1 before the first
+	index atomic.Int64
+	// entry is the name of the current entry, "" once it is done with
+	entry  atomic.Pointer[string]
+	offset atomic.Int64
+}
+
+// newEntryTracker returns a tracker for archive.
+func newEntryTracker(archive string) *entryTracker {
+	t := &entryTracker{archive: archive}
+	t.index.Store(-1)
+	return t
+}
+
+// start makes the entry at index called name the current one.
+func (t *entryTracker) start(index int, name string) {
+	t.index.Store(int64(index))
+	t.entry.Store(&name)
+}
+
+// handler makes the entries passed to handler the current ones.
+func (t *entryTracker) handler(handler archives.FileHandler) archives.FileHandler {
+	return func(ctx context.Context, f archives.FileInfo) error {
+		t.start(int(t.index.Load())+1, f.NameInArchive)
+		if err := handler(ctx, f); err != nil {
+			return err
+		}
+		t.entry.Store(nil)
+		return nil
+	}
+}
+
+// files makes files the current entry as they are written. Archivers call
+// Name for the header of every entry, and Open for the content of regular
+// files.
+func (t *entryTracker) files(files []archives.FileInfo) []archives.FileInfo {
+	for i, f := range files {
+		files[i].FileInfo = trackedInfo{FileInfo: f.FileInfo, start: func() { t.start(i, f.NameInArchive) }}
+		if f.Open == nil {
+			continue
+		}
+		open := f.Open
+		files[i].Open = func() (fs.File, error) {
+			t.start(i, f.NameInArchive)
+			return open()
+		}
+	}
+	return files
+}
+
+// reader counts the bytes read from input, which keeps the random access of
+// zip archives.
+func (t *entryTracker) reader(input io.Reader) io.Reader {
+	r := &offsetReader{Reader: input, offset: &t.offset}
+	if seeker, ok := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		return &offsetReadSeeker{offsetReader: r, seeker: seeker}
+	}
+	return r
+}
+
+// writer counts the bytes written to output.
+func (t *entryTracker) writer(output io.Writer) io.Writer {
+	return &offsetWriter{Writer: output, offset: &t.offset}
+}
+
+// wrap returns err as an EntryError at the current entry. Errors ending an
+// operation on purpose, like fs.SkipAll and cancellation, and those already
+// located, are returned as they are.
+func (t *entryTracker) wrap(err error) error {
+	var entryErr *EntryError
+	switch {
+	case err == nil, errors.Is(err, fs.SkipAll), errors.Is(err, fs.SkipDir),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &entryErr):
+		return err
+	}
+	located := &EntryError{Archive: t.archive, Index: int(t.index.Load()), Offset: t.offset.Load(), Err: err}
+	if name := t.entry.Load(); name != nil {
+		located.Entry = *name
+	} else {
+		located.Index++
+	}
+	return located
+}
+
+// trackedInfo calls start whenever its name is asked for.
+type trackedInfo struct {
+	fs.FileInfo
+	start func()
+}
+
+func (i trackedInfo) Name() string {
+	i.start()
+	return i.FileInfo.Name()
+}
+
+// offsetReader counts the bytes read, decompressors may read from another
+// goroutine.
+type offsetReader struct {
+	io.Reader
+	offset *atomic.Int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.offset.Add(int64(n))
+	return n, err
+}
+
+// offsetReadSeeker is an offsetReader keeping the position of random reads.
+type offsetReadSeeker struct {
+	*offsetReader
+	seeker interface {
+		io.ReaderAt
+		io.Seeker
+	}
+}
+
+func (r *offsetReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.seeker.ReadAt(p, off)
+	r.offset.Store(off + int64(n))
+	return n, err
+}
+
+func (r *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.seeker.Seek(offset, whence)
+	if err == nil {
+		r.offset.Store(pos)
+	}
+	return pos, err
+}
+
+// offsetWriter counts the bytes written.
+type offsetWriter struct {
+	io.Writer
+	offset *atomic.Int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.offset.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,171 @@
+package arc
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+const (
+	// bytes read from the start of each file to measure its compressibility
+	estimateSampleSize = 64 << 10
+	// bytes sampled at most per file extension
+	estimateSampleBudget = 8 << 20
+	// compressed size of archive headers relative to their raw size, they
+	// are mostly zeros and repeated fields
+	estimateHeaderRatio = 0.1
+)
+
+// SizeEstimate predicts the size of an archive before it is created.
+type SizeEstimate struct {
+	// Entries is the number of files, directories and links to archive
+	Entries int
+	// Names are the paths of the entries in the archive, after filtering
+	Names []string
+	// Bytes is the total size of the regular files
+	Bytes int64
+	// SampledBytes is how much of Bytes was compressed to measure ratios
+	SampledBytes int64
+	// Size is the estimated size of the archive, including headers
+	Size int64
+	// Ratios is the measured compressed size divided by the original size
+	// per lower case file extension, "" for files without one
+	Ratios map[string]float64
+}
+
+// EstimateArchive walks dir like ArchiveWithFileFilter and estimates the size
+// of the archive without writing it. The start of a share of the files of
+// each extension is compressed to measure its ratio, which is applied to all
+// files with that extension.
+// dir: the directory to estimate the archive of
+// compression: the compression to measure, nil for none
+// archival: the archival, for the size of its headers
+// filter: a function that returns true for files to be excluded, may be nil
+// opts: optional settings, see Option and WithEstimateModel
+func EstimateArchive(dir string, compression archives.Compression, archival archives.Archival, filter FileFilter, opts ...Option) (SizeEstimate, error) {
+	o := newOptions(opts)
+	format := estimateFormatKey(compression, archival)
+	logging("Estimating the archive size of directory: %s", dir)
+	var estimate SizeEstimate
+	if !isExist(diskPath(dir, o)) {
+		return estimate, fmt.Errorf("directory '%s' does not exist, cannot estimate archive size", dir)
+	}
+
+	// map files on disk to their paths in the archive
+	files, err := filesFromDisk(dir, o)
+	if err != nil {
+		return estimate, fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
+	}
+
+	// group the content of regular files by extension
+	bytesByExt := make(map[string]int64)
+	samples := make(map[string][]archives.FileInfo)
+	sampled := make(map[string]int64)
+	var headers int64
+	for _, f := range files {
+		if filter != nil && filter(f) {
+			continue
+		}
+		estimate.Entries++
+		estimate.Names = append(estimate.Names, f.NameInArchive)
+		headers += headerSize(archival, f)
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		estimate.Bytes += f.Size()
+		bytesByExt[ext] += f.Size()
+		if sampled[ext] < estimateSampleBudget && f.Size() > 0 {
+			samples[ext] = append(samples[ext], f)
+			sampled[ext] += min(f.Size(), estimateSampleSize)
+		}
+	}
+
+	estimate.Ratios = make(map[string]float64, len(bytesByExt))
+	size := float64(headers)
+	if compression != nil {
+		size *= estimateHeaderRatio
+	}
+	for ext, extBytes := range bytesByExt {
+		ratio := 1.0
+		if compression != nil && sampled[ext] > 0 {
+			raw, compressed, sampleErr := sampleRatio(samples[ext], compression)
+			if sampleErr != nil {
+				return estimate, fmt.Errorf("sampling %s files: %w", ext, sampleErr)
+			}
+			estimate.SampledBytes += raw
+			if raw > 0 {
+				ratio = float64(compressed) / float64(raw)
+			}
+			if o.estimateModel != nil {
+				if modelRatio, ok := o.estimateModel.ratio(format, ext, raw, compressed); ok {
+					ratio = modelRatio
+				}
+			}
+		}
+		estimate.Ratios[ext] = ratio
+		size += ratio * float64(extBytes)
+	}
+	if o.estimateModel != nil {
+		size = o.estimateModel.correct(format, size)
+	}
+	estimate.Size = int64(size)
+	logging("Estimated %d entries, %d bytes: %d bytes archived", estimate.Entries, estimate.Bytes, estimate.Size)
+	return estimate, nil
+}
+
+// sampleRatio compresses the start of each file as one stream, and returns
+// the raw and compressed sizes.
+func sampleRatio(files []archives.FileInfo, compression archives.Compression) (int64, int64, error) {
+	var compressed countingWriter
+	cw, err := compression.OpenWriter(&compressed)
+	if err != nil {
+		return 0, 0, err
+	}
+	var raw int64
+	for _, f := range files {
+		r, openErr := f.Open()
+		if openErr != nil {
+			logging("Not sampling %s: %v", f.NameInArchive, openErr)
+			continue
+		}
+		n, copyErr := io.Copy(cw, io.LimitReader(r, estimateSampleSize))
+		r.Close()
+		raw += n
+		if copyErr != nil {
+			cw.Close()
+			return 0, 0, copyErr
+		}
+	}
+	if err := cw.Close(); err != nil {
+		return 0, 0, err
+	}
+	return raw, compressed.n, nil
+}
+
+// headerSize returns the bytes archival adds for f besides its content,
+// including the padding of tar.
+func headerSize(archival archives.Archival, f archives.FileInfo) int64 {
+	if _, isZip := archival.(archives.Zip); isZip {
+		// local header, central directory record, the name in both
+		return 30 + 46 + 2*int64(len(f.NameInArchive))
+	}
+	size := int64(512)
+	if f.Mode().IsRegular() {
+		size += (512 - f.Size()%512) % 512
+	}
+	return size
+}
+
+// countingWriter discards what is written, counting the bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
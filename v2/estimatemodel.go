@@ -0,0 +1,164 @@
+package arc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// estimateModelCap bounds the sampled bytes an EstimateModel keeps per
+// extension, older samples are scaled down to make room for new ones.
+const estimateModelCap = 64 << 20
+
+// EstimateModel remembers the compression ratios observed by
+// EstimateArchive, and how far its estimates were from the archives that
+// were then created, so estimates of the same tree improve over time.
+type EstimateModel struct {
+	// Ratios are the sampled bytes per format and file extension
+	Ratios map[string]ObservedRatio `json:"ratios"`
+	// Corrections are the actual archive sizes divided by the estimated
+	// ones per format, applied to later estimates
+	Corrections map[string]float64 `json:"corrections"`
+	// Last is the last uncorrected estimate per format, for Observe
+	Last map[string]int64 `json:"last"`
+}
+
+// ObservedRatio is the raw and compressed size of the samples of an
+// extension.
+type ObservedRatio struct {
+	Raw        int64 `json:"raw"`
+	Compressed int64 `json:"compressed"`
+}
+
+// WithEstimateModel makes EstimateArchive combine its samples with the ones
+// of model, and apply its corrections. model is updated with the new samples
+// and estimate, save it with EstimateModel.Save.
+func WithEstimateModel(model *EstimateModel) Option {
+	return func(o *options) {
+		o.estimateModel = model
+	}
+}
+
+// DefaultEstimateModelPath returns where the estimate model of the tree dir
+// is kept, a file in the user cache directory named after its absolute path.
+func DefaultEstimateModelPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cacheDir, "arc", "estimates", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// LoadEstimateModel reads the model saved at path, a missing file is an
+// empty model.
+func LoadEstimateModel(path string) (*EstimateModel, error) {
+	model := &EstimateModel{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return model, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read estimate model: %w", err)
+	}
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, fmt.Errorf("parse estimate model %s: %w", path, err)
+	}
+	return model, nil
+}
+
+// Save writes the model to path, creating its directory.
+func (m *EstimateModel) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return fmt.Errorf("save estimate model: %w", err)
+	}
+	if err := os.WriteFile(path, data, filePermissions); err != nil {
+		return fmt.Errorf("save estimate model: %w", err)
+	}
+	return nil
+}
+
+// Observe records the size of an archive created with compression and
+// archival after its size was estimated, to correct later estimates.
+func (m *EstimateModel) Observe(compression archives.Compression, archival archives.Archival, actual int64) {
+	key := estimateFormatKey(compression, archival)
+	last, ok := m.Last[key]
+	if !ok || last <= 0 {
+		return
+	}
+	if m.Corrections == nil {
+		m.Corrections = make(map[string]float64)
+	}
+	observed := float64(actual) / float64(last)
+	if correction, ok := m.Corrections[key]; ok {
+		// average with the previous observations, halving their weight
+		observed = (correction + observed) / 2
+	}
+	logging("Estimate correction for %s: %.3f", key, observed)
+	m.Corrections[key] = observed
+	delete(m.Last, key)
+}
+
+// ratio combines the samples of ext with the model, records them, and
+// returns the resulting ratio.
+func (m *EstimateModel) ratio(format, ext string, raw, compressed int64) (float64, bool) {
+	if m.Ratios == nil {
+		m.Ratios = make(map[string]ObservedRatio)
+	}
+	key := format + " " + ext
+	observed := m.Ratios[key]
+	observed.Raw += raw
+	observed.Compressed += compressed
+	if observed.Raw > estimateModelCap {
+		scale := float64(estimateModelCap) / float64(observed.Raw)
+		observed.Raw = estimateModelCap
+		observed.Compressed = int64(float64(observed.Compressed) * scale)
+	}
+	m.Ratios[key] = observed
+	if observed.Raw == 0 {
+		return 0, false
+	}
+	return float64(observed.Compressed) / float64(observed.Raw), true
+}
+
+// correct records size as the last estimate of format, then applies the
+// correction of format to it. Corrections are relative to uncorrected
+// estimates.
+func (m *EstimateModel) correct(format string, size float64) float64 {
+	if m.Last == nil {
+		m.Last = make(map[string]int64)
+	}
+	m.Last[format] = int64(size)
+	if correction, ok := m.Corrections[format]; ok {
+		size *= correction
+	}
+	return size
+}
+
+// estimateFormatKey names the format of an archive in an EstimateModel, like
+// ".tar.zst".
+func estimateFormatKey(compression archives.Compression, archival archives.Archival) string {
+	var key string
+	if archival != nil {
+		key = archival.Extension()
+	}
+	if compression != nil {
+		key += compression.Extension()
+	}
+	return key
+}
@@ -0,0 +1,93 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// WithFileList archives exactly the given paths, like tar -T, instead of
+// walking dir and the sources of WithSources: directories are stored without
+// their content, which is only archived if listed too. Relative paths are
+// stored as they are, cleaned and with leading slashes removed, and resolved
+// against WithDirectory. Paths leaving the root with ".." are an error.
+func WithFileList(paths ...string) Option {
+	return func(o *options) {
+		o.fileList = append(o.fileList, paths...)
+	}
+}
+
+// ReadFileList reads a list of paths for WithFileList from r, separated by
+// NUL bytes like the output of find -print0, or else by newlines. Empty
+// entries are skipped.
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read file list: %w", err)
+	}
+
+	var paths []string
+	if bytes.IndexByte(data, 0) >= 0 {
+		for _, p := range bytes.Split(data, []byte{0}) {
+			if len(p) > 0 {
+				paths = append(paths, string(p))
+			}
+		}
+		return paths, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// filesFromList returns the files of WithFileList, in the order listed.
+func filesFromList(o *options) ([]archives.FileInfo, error) {
+	files := make([]archives.FileInfo, 0, len(o.fileList))
+	seen := make(map[string]bool)
+	for _, listed := range o.fileList {
+		name := strings.TrimLeft(path.Clean(filepath.ToSlash(listed)), "/")
+		if name == "" || name == "." || seen[name] {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("listed path '%s' is outside of the archive root", listed)
+		}
+		seen[name] = true
+
+		onDisk := diskPath(listed, o)
+		info, err := os.Lstat(onDisk)
+		if err != nil {
+			return nil, fmt.Errorf("listed path: %w", err)
+		}
+		var linkTarget string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(onDisk); err != nil {
+				return nil, fmt.Errorf("listed path: %w", err)
+			}
+		}
+		files = append(files, archives.FileInfo{
+			FileInfo:      info,
+			NameInArchive: name,
+			LinkTarget:    linkTarget,
+			Open: func() (fs.File, error) {
+				return os.Open(onDisk)
+			},
+		})
+	}
+	if !o.ignoreFiles {
+		return files, nil
+	}
+	return applyIgnoreFiles(files)
+}
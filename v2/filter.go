@@ -0,0 +1,116 @@
+package arc
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// FileFilter decides whether a file is left out of an archive, it returns
+// true for files to be excluded. Unlike the name filters, it can look at the
+// size, mode and mtime of the file, or whether it is a symlink, socket or
+// device.
+type FileFilter func(fi archives.FileInfo) bool
+
+// NameFilter turns a filter on file names, like the ones returned by
+// ExcludeFilesFilter and IncludeFilesFilter, into a FileFilter. It is given
+// the base name of each file.
+func NameFilter(filter func(string) bool) FileFilter {
+	return func(fi archives.FileInfo) bool {
+		return filter(fi.Name())
+	}
+}
+
+// GlobFilter excludes files matching any of the exclude patterns and, when
+// include patterns are given, files matching none of them. Patterns use the
+// doublestar syntax, where ** matches any number of directories, and are
+// matched against the path of each file in the archive and every trailing
+// part of it, so '*.so' and 'node_modules/**' match at any depth; a leading /
+// anchors a pattern to the root of the archive. A pattern matching a
+// directory also matches everything below it.
+func GlobFilter(include, exclude []string) (FileFilter, error) {
+	for _, pattern := range append(include, exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, fmt.Errorf("invalid glob pattern %q", pattern)
+		}
+	}
+	return func(fi archives.FileInfo) bool {
+		name := fi.NameInArchive
+		if name == "" {
+			name = fi.Name()
+		}
+		for _, pattern := range exclude {
+			if matchGlob(pattern, name) {
+				return true
+			}
+		}
+		if len(include) == 0 {
+			return false
+		}
+		for _, pattern := range include {
+			if matchGlob(pattern, name) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// matchGlob reports whether pattern matches name, a trailing part of it or
+// one of their parent directories, see GlobFilter.
+func matchGlob(pattern, name string) bool {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	for start := range parts {
+		if anchored && start > 0 {
+			break
+		}
+		for end := start + 1; end <= len(parts); end++ {
+			if doublestar.MatchUnvalidated(pattern, strings.Join(parts[start:end], "/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MaxSizeFilter excludes regular files larger than size bytes.
+func MaxSizeFilter(size int64) FileFilter {
+	return func(fi archives.FileInfo) bool {
+		return fi.Mode().IsRegular() && fi.Size() > size
+	}
+}
+
+// MinSizeFilter excludes regular files smaller than size bytes.
+func MinSizeFilter(size int64) FileFilter {
+	return func(fi archives.FileInfo) bool {
+		return fi.Mode().IsRegular() && fi.Size() < size
+	}
+}
+
+// ModifiedSinceFilter excludes regular files last modified before t, for
+// incremental archives of what changed since a previous run. Directories are
+// kept so the changed files keep their place in the tree.
+func ModifiedSinceFilter(t time.Time) FileFilter {
+	return func(fi archives.FileInfo) bool {
+		return fi.Mode().IsRegular() && fi.ModTime().Before(t)
+	}
+}
+
+// CombineFilters returns a filter excluding the files excluded by any of
+// filters, nil filters are ignored.
+func CombineFilters(filters ...FileFilter) FileFilter {
+	return func(fi archives.FileInfo) bool {
+		for _, filter := range filters {
+			if filter != nil && filter(fi) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// tarShorthands are the single extensions of compressed tar archives.
+var tarShorthands = map[string]string{
+	".tgz":  ".tar.gz",
+	".taz":  ".tar.gz",
+	".tbz":  ".tar.bz2",
+	".tbz2": ".tar.bz2",
+	".txz":  ".tar.xz",
+	".tzst": ".tar.zst",
+	".tlz":  ".tar.lz",
+}
+
+// archivalAliases are other extensions of archivals, by the extension of
+// the archival they stand for.
+var archivalAliases = map[string]string{
+	".ar":  ".a",
+	".deb": ".a",
+}
+
+// ErrReadOnly is returned by FormatFromName for the formats registered with
+// RegisterExtraction, like .iso images, which are read but not written.
+var ErrReadOnly = errors.New("format can be read but not written")
+
+// FormatFromName returns the compression and archival the extension of name
+// stands for, like gzip and tar for "out.tar.gz" or "out.tgz", or ar for
+// "pkg.deb". Either is nil when name doesn't use it: zip archives have no
+// compression, and files like "app.wasm.br" have no archival, they are a
+// single compressed file.
+func FormatFromName(name string) (archives.Compression, archives.Archival, error) {
+	lower := strings.ToLower(filepath.Base(name))
+	for short, long := range tarShorthands {
+		if strings.HasSuffix(lower, short) {
+			lower = strings.TrimSuffix(lower, short) + long
+			break
+		}
+	}
+
+	var compression archives.Compression
+	ext := filepath.Ext(lower)
+	for _, c := range compressions() {
+		// like .Z, extensions aren't all lowercase
+		if strings.ToLower(c.Extension()) == ext {
+			compression = c
+			lower = strings.TrimSuffix(lower, ext)
+			ext = filepath.Ext(lower)
+			break
+		}
+	}
+	if alias, ok := archivalAliases[ext]; ok {
+		ext = alias
+	}
+	for _, a := range archivals() {
+		if a.Extension() == ext {
+			return compression, a, nil
+		}
+	}
+	for _, e := range extractions() {
+		if f, ok := e.(archives.Format); ok && compression == nil && f.Extension() == ext {
+			return nil, nil, fmt.Errorf("%s: %w", name, ErrReadOnly)
+		}
+	}
+	if compression == nil {
+		return nil, nil, fmt.Errorf("unknown archive extension: %s", name)
+	}
+	return compression, nil, nil
+}
@@ -0,0 +1,42 @@
+package arc
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// OpenArchiveFS returns a read-only file system view of an archive, entries
+// are decompressed on the fly and nothing is extracted to disk. Directories
+// and plain compressed files are supported too, see archives.FileSystem.
+// With WithEntryCache, files read to the end are cached and read from the
+// cache next time. Seekable tar.zst archives, see WithSeekable, only have
+// the frames holding the entries read decompressed. With WithLimits, an
+// archive going beyond them is refused with a LimitError, its headers are
+// all read to count the entries and the sizes they declare.
+//
+// The returned file system is not safe for concurrent use.
+func OpenArchiveFS(archive string, opts ...Option) (fs.FS, error) {
+	logging("Opening file system view of %s", archive)
+	fsys, err := openSeekableFS(archive)
+	if err == nil && fsys == nil {
+		fsys, err = archives.FileSystem(context.Background(), archive, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open archive fs %s: %w", archive, err)
+	}
+	o := newOptions(opts)
+	if err := limitFS(archive, fsys, o); err != nil {
+		return nil, fmt.Errorf("open archive fs %s: %w", archive, err)
+	}
+	if cache := o.entryCache; cache != nil {
+		archiveID, err := archiveKey(archive)
+		if err != nil {
+			return nil, fmt.Errorf("open archive fs %s: %w", archive, err)
+		}
+		return cachedFS{fsys: fsys, cache: cache, archiveID: archiveID}, nil
+	}
+	return fsys, nil
+}
@@ -0,0 +1,211 @@
+package arc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FsckStatus is the outcome of the check of an archive by Fsck.
+type FsckStatus string
+
+const (
+	// FsckOK is an archive that verified, with the content recorded
+	FsckOK FsckStatus = "ok"
+	// FsckCorrupt is an archive that failed verification, see Verify
+	FsckCorrupt FsckStatus = "corrupt"
+	// FsckBitRot is an archive whose content changed while its size and
+	// mtime didn't, which files rewritten on purpose don't do
+	FsckBitRot FsckStatus = "bitrot"
+	// FsckMissing is an archive of the catalog that is gone
+	FsckMissing FsckStatus = "missing"
+)
+
+// FsckResult is the state of an archive checked by Fsck, as recorded in
+// its catalog.
+type FsckResult struct {
+	// Name is the path of the archive from the directory checked
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// SHA256 is the last content that verified, kept while the archive is
+	// corrupted so the damage is reported until it is repaired
+	SHA256  string     `json:"sha256,omitempty"`
+	Checked time.Time  `json:"checked"`
+	Status  FsckStatus `json:"status"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// Failed reports whether the archive needs attention.
+func (r FsckResult) Failed() bool {
+	return r.Status != FsckOK
+}
+
+// fsckCatalog is the catalog file of Fsck.
+type fsckCatalog struct {
+	Archives []FsckResult `json:"archives"`
+}
+
+// Fsck verifies every archive below dir, by its format checksums, trailer
+// and manifest like Verify, and by the SHA-256 of the whole file recorded in
+// catalog by earlier runs. Archives that pass Verify but no longer match
+// their recorded content while their size and mtime are the same suffered
+// silent corruption of the storage, like bit rot of uncompressed entries.
+// Archives rewritten since, with a new size or mtime, are recorded again.
+// Results are sorted by name, the catalog is updated with them.
+// dir: the directory of archives to check
+// catalog: JSON file recording the checks between runs, created if needed,
+// or "" to only verify the archives
+// opts: optional settings, like WithPassword and WithDecryption for
+// encrypted archives
+func Fsck(dir, catalog string, opts ...Option) ([]FsckResult, error) {
+	logging("Checking the archives of %s", dir)
+	if err := newOptions(opts).checkDestination(catalog); err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]FsckResult)
+	if catalog != "" {
+		previous, err := readFsckCatalog(catalog)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range previous {
+			recorded[r.Name] = r
+		}
+	}
+
+	var results []FsckResult
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !isArchiveFile(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		prev, seen := recorded[name]
+		delete(recorded, name)
+		result, err := fsckArchive(p, name, prev, seen, opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", dir, err)
+	}
+
+	// archives of the catalog not found are reported once, and forgotten
+	byName := func(a, b FsckResult) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(results, byName)
+	kept := slices.Clone(results)
+	for _, prev := range recorded {
+		prev.Status, prev.Error, prev.Checked = FsckMissing, "", time.Now().UTC()
+		results = append(results, prev)
+	}
+	slices.SortFunc(results, byName)
+
+	if catalog != "" {
+		if err := writeFsckCatalog(catalog, kept); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// isArchiveFile reports whether name is named like an archive or a
+// compressed file, possibly encrypted.
+func isArchiveFile(name string) bool {
+	_, _, err := FormatFromName(trimEncryptionExt(name))
+	return err == nil
+}
+
+// fsckArchive checks the archive at path against prev, its previous result
+// if seen.
+func fsckArchive(path, name string, prev FsckResult, seen bool, opts []Option) (FsckResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FsckResult{}, err
+	}
+	result := FsckResult{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+		Checked: time.Now().UTC(),
+		Status:  FsckOK,
+	}
+	sum, err := sha256File(path)
+	if err != nil {
+		return FsckResult{}, err
+	}
+	unchanged := seen && prev.Size == result.Size && prev.ModTime.Equal(result.ModTime)
+
+	if err := Verify(path, opts...); err != nil {
+		logging("Archive %s is corrupted: %v", path, err)
+		result.Status, result.Error = FsckCorrupt, err.Error()
+	} else if unchanged && prev.SHA256 != "" && prev.SHA256 != sum {
+		logging("Archive %s changed without a new size or mtime", path)
+		result.Status = FsckBitRot
+		result.Error = fmt.Sprintf("SHA-256 is %s, recorded %s", sum, prev.SHA256)
+	}
+	switch {
+	case result.Status == FsckOK:
+		result.SHA256 = sum
+	case unchanged:
+		result.SHA256 = prev.SHA256
+	}
+	return result, nil
+}
+
+// readFsckCatalog returns the results recorded in catalog, none if it
+// doesn't exist yet.
+func readFsckCatalog(catalog string) ([]FsckResult, error) {
+	data, err := os.ReadFile(catalog)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading catalog: %w", err)
+	}
+	var c fsckCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("reading catalog %s: %w", catalog, err)
+	}
+	return c.Archives, nil
+}
+
+// writeFsckCatalog replaces catalog with results, through a temporary file
+// so an interrupted run doesn't lose the recorded content.
+func writeFsckCatalog(catalog string, results []FsckResult) error {
+	data, err := json.MarshalIndent(fsckCatalog{Archives: results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(catalog), ".arc-catalog-*")
+	if err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), catalog); err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	return nil
+}
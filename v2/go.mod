@@ -4,25 +4,38 @@ go 1.24.0
 
 toolchain go1.24.13
 
-require github.com/mholt/archives v0.1.5
+require (
+	filippo.io/age v1.2.1
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/STARRY-S/zip v0.2.3
+	github.com/alexmullins/zip v0.0.0-20180717182244-4affb64b04d0
+	github.com/andybalholm/brotli v1.2.0
+	github.com/bmatcuk/doublestar/v4 v4.10.0
+	github.com/bodgit/sevenzip v1.6.1
+	github.com/dsnet/compress v0.0.2-0.20230904184137-39efe44ab707
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/klauspost/compress v1.18.4
+	github.com/klauspost/pgzip v1.2.6
+	github.com/mholt/archives v0.1.5
+	github.com/mikelolasagasti/xz v1.0.1
+	github.com/minio/minlz v1.0.1
+	github.com/nwaples/rardecode/v2 v2.2.2
+	github.com/pierrec/lz4/v4 v4.1.25
+	github.com/sorairolake/lzip-go v0.3.8
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.48.0
+	golang.org/x/sys v0.41.0
+	golang.org/x/term v0.40.0
+	golang.org/x/text v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/STARRY-S/zip v0.2.3 // indirect
-	github.com/andybalholm/brotli v1.2.0 // indirect
+	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
-	github.com/bodgit/sevenzip v1.6.1 // indirect
 	github.com/bodgit/windows v1.0.1 // indirect
-	github.com/dsnet/compress v0.0.2-0.20230904184137-39efe44ab707 // indirect
+	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	github.com/klauspost/compress v1.18.4 // indirect
-	github.com/klauspost/pgzip v1.2.6 // indirect
-	github.com/mikelolasagasti/xz v1.0.1 // indirect
-	github.com/minio/minlz v1.0.1 // indirect
-	github.com/nwaples/rardecode/v2 v2.2.2 // indirect
-	github.com/pierrec/lz4/v4 v4.1.25 // indirect
-	github.com/sorairolake/lzip-go v0.3.8 // indirect
 	github.com/spf13/afero v1.15.0 // indirect
-	github.com/ulikunitz/xz v0.5.15 // indirect
 	go4.org v0.0.0-20260112195520-a5071408f32f // indirect
-	golang.org/x/text v0.34.0 // indirect
 )
@@ -0,0 +1,167 @@
+package arc
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ignoreFileNames are the files WithIgnoreFiles reads patterns from.
+var ignoreFileNames = []string{".gitignore", ".arcignore"}
+
+// WithIgnoreFiles leaves out the files matched by the .gitignore and
+// .arcignore files found in the archived tree, using the gitignore syntax: a
+// pattern applies to the directory of its file and below, ! re-includes, and
+// a trailing / only matches directories. Like git archive, .git directories
+// are left out too, the ignore files themselves are kept.
+func WithIgnoreFiles() Option {
+	return func(o *options) {
+		o.ignoreFiles = true
+	}
+}
+
+// ignoreRule is a pattern of an ignore file.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreRules are the patterns of the ignore files of the tree, by the
+// directory of the file holding them.
+type ignoreRules map[string][]ignoreRule
+
+// applyIgnoreFiles returns files without the ones ignored by the ignore files
+// among them.
+func applyIgnoreFiles(files []archives.FileInfo) ([]archives.FileInfo, error) {
+	rules := make(ignoreRules)
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !isIgnoreFile(path.Base(f.NameInArchive)) {
+			continue
+		}
+		fileRules, err := readIgnoreFile(f)
+		if err != nil {
+			return nil, err
+		}
+		dir := path.Dir(f.NameInArchive)
+		rules[dir] = append(rules[dir], fileRules...)
+	}
+
+	kept := make([]archives.FileInfo, 0, len(files))
+	for _, f := range files {
+		if rules.ignored(f.NameInArchive, f.IsDir()) {
+			logging("Ignoring %s", f.NameInArchive)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept, nil
+}
+
+func isIgnoreFile(name string) bool {
+	for _, ignoreName := range ignoreFileNames {
+		if name == ignoreName {
+			return true
+		}
+	}
+	return false
+}
+
+// readIgnoreFile parses the patterns of the ignore file f.
+func readIgnoreFile(f archives.FileInfo) ([]ignoreRule, error) {
+	file, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", f.NameInArchive, err)
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// a slash anywhere but at the end ties the pattern to the directory
+		// of the ignore file
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern == "" || !doublestar.ValidatePattern(rule.pattern) {
+			logging("Skipping invalid pattern %q in %s", line, f.NameInArchive)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.NameInArchive, err)
+	}
+	return rules, nil
+}
+
+// ignored reports whether the entry name is ignored, either itself or
+// because one of its parent directories is, as git doesn't look into
+// ignored directories.
+func (r ignoreRules) ignored(name string, isDir bool) bool {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		last := i == len(parts)-1
+		if parts[i] == ".git" && (!last || isDir) {
+			return true
+		}
+		if r.match(strings.Join(parts[:i+1], "/"), !last || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules of the ignore files above name, the last matching
+// rule decides.
+func (r ignoreRules) match(name string, isDir bool) bool {
+	ignored := false
+	dirs := []string{"."}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	// from the root down, deeper ignore files override
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		rel := name
+		if dir != "." {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+		for _, rule := range r[dir] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			target := rel
+			if !rule.anchored {
+				target = path.Base(rel)
+			}
+			if doublestar.MatchUnvalidated(rule.pattern, target) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
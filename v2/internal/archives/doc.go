@@ -0,0 +1,12 @@
+// Package archives gives arc the formats of github.com/mholt/archives, the
+// types of its API. When a build tag leaves codecs out, arc_no_brotli,
+// arc_no_bzip2, arc_no_xz, arc_no_lz4, arc_no_lzip, arc_no_minlz,
+// arc_no_snappy, arc_no_7z, arc_no_rar or arc_minimal, they come from the
+// fork of internal/fork instead, as mholt/archives links all of its codecs
+// into every program importing it. The API of arc then takes the types of the
+// fork, which programs outside arc can't name: slimmed builds are meant for
+// the arc command, programs using arc as a library pass the types of
+// mholt/archives.
+//
+// Each name is an alias, the types are those of the package aliased.
+package archives
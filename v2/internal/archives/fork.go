@@ -0,0 +1,83 @@
+//go:build arc_minimal || arc_no_brotli || arc_no_bzip2 || arc_no_xz || arc_no_lz4 || arc_no_lzip || arc_no_minlz || arc_no_snappy || arc_no_7z || arc_no_rar
+
+package archives
+
+import (
+	"github.com/jm33-m0/arc/v2/internal/fork"
+	"github.com/klauspost/compress/zip"
+)
+
+type (
+	Archival          = fork.Archival
+	ArchiveAsyncJob   = fork.ArchiveAsyncJob
+	ArchiveFS         = fork.ArchiveFS
+	Archiver          = fork.Archiver
+	ArchiverAsync     = fork.ArchiverAsync
+	Brotli            = fork.Brotli
+	Bz2               = fork.Bz2
+	CompressedArchive = fork.CompressedArchive
+	Compression       = fork.Compression
+	Compressor        = fork.Compressor
+	Decompressor      = fork.Decompressor
+	DeepFS            = fork.DeepFS
+	DirFS             = fork.DirFS
+	Extraction        = fork.Extraction
+	Extractor         = fork.Extractor
+	FileFS            = fork.FileFS
+	FileHandler       = fork.FileHandler
+	FileInfo          = fork.FileInfo
+	Format            = fork.Format
+	FromDiskOptions   = fork.FromDiskOptions
+	Gz                = fork.Gz
+	Inserter          = fork.Inserter
+	Lz4               = fork.Lz4
+	Lzip              = fork.Lzip
+	MatchResult       = fork.MatchResult
+	MinLZ             = fork.MinLZ
+	Rar               = fork.Rar
+	ReaderAtSeeker    = fork.ReaderAtSeeker
+	S2                = fork.S2
+	S2Level           = fork.S2Level
+	SevenZip          = fork.SevenZip
+	Sz                = fork.Sz
+	Tar               = fork.Tar
+	Xz                = fork.Xz
+	Zip               = fork.Zip
+	Zlib              = fork.Zlib
+	Zstd              = fork.Zstd
+)
+
+const (
+	ZipMethodBzip2 = fork.ZipMethodBzip2
+	ZipMethodZstd  = fork.ZipMethodZstd
+	ZipMethodXz    = fork.ZipMethodXz
+	S2LevelNone    = fork.S2LevelNone
+	S2LevelFast    = fork.S2LevelFast
+	S2LevelBetter  = fork.S2LevelBetter
+	S2LevelBest    = fork.S2LevelBest
+)
+
+var (
+	NoMatch = fork.NoMatch
+
+	FileSystem          = fork.FileSystem
+	FilesFromDisk       = fork.FilesFromDisk
+	Identify            = fork.Identify
+	PathContainsArchive = fork.PathContainsArchive
+	PathIsArchive       = fork.PathIsArchive
+	TopDirOpen          = fork.TopDirOpen
+	TopDirReadDir       = fork.TopDirReadDir
+	TopDirStat          = fork.TopDirStat
+)
+
+// RegisterFormat registers format for Identify. The fork registers none of
+// its formats, arc registers those compiled in.
+func RegisterFormat(format Format) {
+	fork.RegisterFormat(format)
+}
+
+// RegisterZipMethod registers the codec of a zip compression method.
+func RegisterZipMethod(method uint16, compressor zip.Compressor, decompressor zip.Decompressor) {
+	zip.RegisterCompressor(method, compressor)
+	zip.RegisterDecompressor(method, decompressor)
+}
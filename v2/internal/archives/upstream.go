@@ -0,0 +1,94 @@
+//go:build !arc_minimal && !arc_no_brotli && !arc_no_bzip2 && !arc_no_xz && !arc_no_lz4 && !arc_no_lzip && !arc_no_minlz && !arc_no_snappy && !arc_no_7z && !arc_no_rar
+
+package archives
+
+import (
+	"reflect"
+
+	"github.com/klauspost/compress/zip"
+	upstream "github.com/mholt/archives"
+)
+
+type (
+	Archival          = upstream.Archival
+	ArchiveAsyncJob   = upstream.ArchiveAsyncJob
+	ArchiveFS         = upstream.ArchiveFS
+	Archiver          = upstream.Archiver
+	ArchiverAsync     = upstream.ArchiverAsync
+	Brotli            = upstream.Brotli
+	Bz2               = upstream.Bz2
+	CompressedArchive = upstream.CompressedArchive
+	Compression       = upstream.Compression
+	Compressor        = upstream.Compressor
+	Decompressor      = upstream.Decompressor
+	DeepFS            = upstream.DeepFS
+	DirFS             = upstream.DirFS
+	Extraction        = upstream.Extraction
+	Extractor         = upstream.Extractor
+	FileFS            = upstream.FileFS
+	FileHandler       = upstream.FileHandler
+	FileInfo          = upstream.FileInfo
+	Format            = upstream.Format
+	FromDiskOptions   = upstream.FromDiskOptions
+	Gz                = upstream.Gz
+	Inserter          = upstream.Inserter
+	Lz4               = upstream.Lz4
+	Lzip              = upstream.Lzip
+	MatchResult       = upstream.MatchResult
+	MinLZ             = upstream.MinLZ
+	Rar               = upstream.Rar
+	ReaderAtSeeker    = upstream.ReaderAtSeeker
+	S2                = upstream.S2
+	S2Level           = upstream.S2Level
+	SevenZip          = upstream.SevenZip
+	Sz                = upstream.Sz
+	Tar               = upstream.Tar
+	Xz                = upstream.Xz
+	Zip               = upstream.Zip
+	Zlib              = upstream.Zlib
+	Zstd              = upstream.Zstd
+)
+
+const (
+	ZipMethodBzip2 = upstream.ZipMethodBzip2
+	ZipMethodZstd  = upstream.ZipMethodZstd
+	ZipMethodXz    = upstream.ZipMethodXz
+	S2LevelNone    = upstream.S2LevelNone
+	S2LevelFast    = upstream.S2LevelFast
+	S2LevelBetter  = upstream.S2LevelBetter
+	S2LevelBest    = upstream.S2LevelBest
+)
+
+var (
+	NoMatch = upstream.NoMatch
+
+	FileSystem          = upstream.FileSystem
+	FilesFromDisk       = upstream.FilesFromDisk
+	Identify            = upstream.Identify
+	PathContainsArchive = upstream.PathContainsArchive
+	PathIsArchive       = upstream.PathIsArchive
+	TopDirOpen          = upstream.TopDirOpen
+	TopDirReadDir       = upstream.TopDirReadDir
+	TopDirStat          = upstream.TopDirStat
+)
+
+// RegisterFormat registers format for Identify. mholt/archives registers its
+// own formats when it is imported, only those of arc are left to register.
+func RegisterFormat(format Format) {
+	if reflect.TypeOf(format).PkgPath() == reflect.TypeOf(upstream.Zip{}).PkgPath() {
+		return
+	}
+	upstream.RegisterFormat(format)
+}
+
+// RegisterZipMethod registers the codec of a zip compression method. Those of
+// mholt/archives, ZipMethodBzip2, ZipMethodZstd and ZipMethodXz, are
+// registered when it is imported.
+func RegisterZipMethod(method uint16, compressor zip.Compressor, decompressor zip.Decompressor) {
+	switch method {
+	case ZipMethodBzip2, ZipMethodZstd, ZipMethodXz:
+		return
+	}
+	zip.RegisterCompressor(method, compressor)
+	zip.RegisterDecompressor(method, decompressor)
+}
@@ -0,0 +1,26 @@
+//go:build arc_no_7z || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// SevenZip stands in for the 7z format left out of this build: it matches
+// nothing, and extracting fails with errors.ErrUnsupported.
+type SevenZip struct {
+	ContinueOnError bool
+	Password        string
+}
+
+func (SevenZip) Extension() string { return ".7z" }
+func (SevenZip) MediaType() string { return "application/x-7z-compressed" }
+
+func (SevenZip) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (SevenZip) Extract(context.Context, io.Reader, FileHandler) error {
+	return errLeftOut("7z", "arc_no_7z")
+}
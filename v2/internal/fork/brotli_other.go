@@ -0,0 +1,30 @@
+//go:build arc_no_brotli || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Brotli stands in for the brotli compression left out of this build: it
+// matches nothing, and its readers and writers fail with
+// errors.ErrUnsupported.
+type Brotli struct {
+	Quality int
+}
+
+func (Brotli) Extension() string { return ".br" }
+func (Brotli) MediaType() string { return "application/x-br" }
+
+func (Brotli) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Brotli) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("brotli", "arc_no_brotli")
+}
+
+func (Brotli) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("brotli", "arc_no_brotli")
+}
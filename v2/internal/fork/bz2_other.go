@@ -0,0 +1,29 @@
+//go:build arc_no_bzip2 || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Bz2 stands in for the bzip2 compression left out of this build: it matches
+// nothing, and its readers and writers fail with errors.ErrUnsupported.
+type Bz2 struct {
+	CompressionLevel int
+}
+
+func (Bz2) Extension() string { return ".bz2" }
+func (Bz2) MediaType() string { return "application/x-bzip2" }
+
+func (Bz2) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Bz2) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("bzip2", "arc_no_bzip2")
+}
+
+func (Bz2) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("bzip2", "arc_no_bzip2")
+}
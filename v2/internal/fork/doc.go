@@ -0,0 +1,15 @@
+// Package fork is github.com/mholt/archives v0.1.5, forked into arc so that
+// small builds can leave codecs out, arc uses it in place of mholt/archives
+// in those builds only, see internal/archives. Upstream, each format registers
+// itself in an init, which links all of their codecs into every program
+// importing the package. Here no format registers itself: arc registers the
+// ones compiled in, and the bzip2, zstd and xz methods of zip entries. Each
+// format depending on a third party codec is in a file of its own, left out
+// with the build tag of arc: arc_no_brotli, arc_no_bzip2, arc_no_xz,
+// arc_no_lz4, arc_no_lzip, arc_no_minlz, arc_no_snappy, arc_no_7z,
+// arc_no_rar or arc_minimal. In those builds, the type of the format stays,
+// with the same fields, so code naming it still compiles, but it matches
+// nothing and fails with errors.ErrUnsupported.
+//
+// Apart from that, the code and its tests are the upstream ones, see LICENSE.
+package fork
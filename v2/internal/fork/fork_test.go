@@ -0,0 +1,45 @@
+package fork
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Upstream, each format registers itself, the tests expect them all.
+func init() {
+	for _, format := range []Format{
+		Brotli{}, Bz2{}, Gz{}, Lz4{}, Lzip{}, MinLZ{}, Rar{}, SevenZip{},
+		Sz{}, Tar{}, Xz{}, Zip{}, Zlib{}, Zstd{},
+	} {
+		RegisterFormat(format)
+	}
+}
+
+// compress is the helper of the tests of formats.go, shared with those of
+// brotli.go, which may be built without them.
+func compress(
+	t *testing.T, compName string, content []byte,
+	openwriter func(w io.Writer) (io.WriteCloser, error),
+) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 128))
+	cwriter, err := openwriter(buf)
+	if err != nil {
+		t.Errorf("fail to open compression writer: compression-name=%s, err=%#v", compName, err)
+		return nil
+	}
+	_, err = cwriter.Write(content)
+	if err != nil {
+		cerr := cwriter.Close()
+		t.Errorf(
+			"fail to write using compression writer: compression-name=%s, err=%#v, close-err=%#v",
+			compName, err, cerr)
+		return nil
+	}
+	err = cwriter.Close()
+	if err != nil {
+		t.Errorf("fail to close compression writer: compression-name=%s, err=%#v", compName, err)
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,12 @@
+package fork
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errLeftOut is the error of the formats left out of the build with their
+// tag, like arc_no_brotli, or arc_minimal.
+func errLeftOut(name, tag string) error {
+	return fmt.Errorf("%s (built with %s or arc_minimal): %w", name, tag, errors.ErrUnsupported)
+}
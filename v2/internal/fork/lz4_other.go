@@ -0,0 +1,29 @@
+//go:build arc_no_lz4 || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Lz4 stands in for the lz4 compression left out of this build: it matches
+// nothing, and its readers and writers fail with errors.ErrUnsupported.
+type Lz4 struct {
+	CompressionLevel int
+}
+
+func (Lz4) Extension() string { return ".lz4" }
+func (Lz4) MediaType() string { return "application/x-lz4" }
+
+func (Lz4) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Lz4) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("lz4", "arc_no_lz4")
+}
+
+func (Lz4) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("lz4", "arc_no_lz4")
+}
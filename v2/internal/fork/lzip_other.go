@@ -0,0 +1,27 @@
+//go:build arc_no_lzip || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Lzip stands in for the lzip compression left out of this build: it matches
+// nothing, and its readers and writers fail with errors.ErrUnsupported.
+type Lzip struct{}
+
+func (Lzip) Extension() string { return ".lz" }
+func (Lzip) MediaType() string { return "application/x-lzip" }
+
+func (Lzip) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Lzip) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("lzip", "arc_no_lzip")
+}
+
+func (Lzip) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("lzip", "arc_no_lzip")
+}
@@ -0,0 +1,28 @@
+//go:build arc_no_minlz || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// MinLZ stands in for the MinLZ compression left out of this build: it
+// matches nothing, and its readers and writers fail with
+// errors.ErrUnsupported.
+type MinLZ struct{}
+
+func (MinLZ) Extension() string { return ".mz" }
+func (MinLZ) MediaType() string { return "application/x-minlz-compressed" }
+
+func (MinLZ) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (MinLZ) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("minlz", "arc_no_minlz")
+}
+
+func (MinLZ) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("minlz", "arc_no_minlz")
+}
@@ -0,0 +1,29 @@
+//go:build arc_no_rar || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+	"io/fs"
+)
+
+// Rar stands in for the rar format left out of this build: it matches
+// nothing, and extracting fails with errors.ErrUnsupported.
+type Rar struct {
+	ContinueOnError bool
+	Password        string
+	Name            string
+	FS              fs.FS
+}
+
+func (Rar) Extension() string { return ".rar" }
+func (Rar) MediaType() string { return "application/vnd.rar" }
+
+func (Rar) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Rar) Extract(context.Context, io.Reader, FileHandler) error {
+	return errLeftOut("rar", "arc_no_rar")
+}
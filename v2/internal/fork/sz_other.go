@@ -0,0 +1,29 @@
+//go:build arc_no_snappy || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Sz stands in for the snappy compression left out of this build: it matches
+// nothing, and its readers and writers fail with errors.ErrUnsupported.
+type Sz struct {
+	S2 S2
+}
+
+func (Sz) Extension() string { return ".sz" }
+func (Sz) MediaType() string { return "application/x-snappy-framed" }
+
+func (Sz) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Sz) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("snappy", "arc_no_snappy")
+}
+
+func (Sz) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("snappy", "arc_no_snappy")
+}
@@ -0,0 +1,27 @@
+//go:build arc_no_xz || arc_minimal
+
+package fork
+
+import (
+	"context"
+	"io"
+)
+
+// Xz stands in for the xz compression left out of this build: it matches
+// nothing, and its readers and writers fail with errors.ErrUnsupported.
+type Xz struct{}
+
+func (Xz) Extension() string { return ".xz" }
+func (Xz) MediaType() string { return "application/x-xz" }
+
+func (Xz) Match(context.Context, string, io.Reader) (MatchResult, error) {
+	return MatchResult{}, nil
+}
+
+func (Xz) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errLeftOut("xz", "arc_no_xz")
+}
+
+func (Xz) OpenReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errLeftOut("xz", "arc_no_xz")
+}
@@ -0,0 +1,488 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ISO9660 reads ISO 9660 images, like installer and live CD images, with
+// the names, modes, times and symlinks of the Rock Ridge extensions, else
+// the long names of Joliet. Images can't be written. They are read in any
+// order, streams are buffered in a temp file first.
+type ISO9660 struct{}
+
+const (
+	isoSectorSize = 2048
+	// the volume descriptors start at sector 16, after the system area
+	isoDescriptorStart = 16 * isoSectorSize
+	// how deep directories may nest, Rock Ridge images relocate deeper ones
+	isoMaxDepth = 64
+)
+
+var isoIdentifier = []byte("CD001")
+
+func (ISO9660) Extension() string { return ".iso" }
+func (ISO9660) MediaType() string { return "application/x-iso9660-image" }
+
+func (i ISO9660) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.EqualFold(path.Ext(filename), i.Extension())
+	if stream == nil {
+		return mr, nil
+	}
+	head := make([]byte, isoDescriptorStart+1+len(isoIdentifier))
+	if _, err := io.ReadFull(stream, head); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(head[isoDescriptorStart+1:], isoIdentifier)
+	return mr, nil
+}
+
+// isoImage is an ISO 9660 image being read.
+type isoImage struct {
+	r io.ReaderAt
+	// which names the image is read with
+	rockRidge bool
+	joliet    bool
+	// bytes at the start of each system use area to skip, from the SP entry
+	suspSkip int
+}
+
+// isoRecord is a directory record, an entry of a directory.
+type isoRecord struct {
+	extent uint32
+	size   uint32
+	dir    bool
+	// more sections of the file follow, in the next records
+	multi   bool
+	name    string
+	modTime time.Time
+	// the system use area, where Rock Ridge keeps its entries
+	system []byte
+}
+
+// Extract calls handleFile for each file and directory of the image, in
+// depth first order.
+func (i ISO9660) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	r, cleanup, err := readerAt(ctx, archive, i.Extension())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	img := &isoImage{r: r}
+	root, err := img.root()
+	if err != nil {
+		return err
+	}
+	err = img.walk(ctx, root, "", handleFile, make(map[uint32]bool), 0)
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// root reads the volume descriptors and returns the root directory, of the
+// primary volume if it has Rock Ridge entries, else of the Joliet one if
+// there is one.
+func (img *isoImage) root() (isoRecord, error) {
+	var primary, joliet []byte
+	for sector := int64(16); sector < 16+64; sector++ {
+		vd := make([]byte, isoSectorSize)
+		if _, err := img.r.ReadAt(vd, sector*isoSectorSize); err != nil {
+			return isoRecord{}, fmt.Errorf("read volume descriptor: %w", err)
+		}
+		if !bytes.Equal(vd[1:6], isoIdentifier) {
+			return isoRecord{}, errors.New("malformed volume descriptor")
+		}
+		if vd[0] == 255 {
+			break
+		}
+		switch {
+		case vd[0] == 1 && primary == nil:
+			primary = vd
+		case vd[0] == 2 && vd[88] == '%' && vd[89] == '/' && bytes.IndexByte([]byte("@CE"), vd[90]) >= 0:
+			joliet = vd
+		}
+	}
+	if primary == nil {
+		return isoRecord{}, errors.New("no primary volume descriptor")
+	}
+
+	root, err := parseISORecord(primary[156:190])
+	if err != nil {
+		return root, err
+	}
+	// Rock Ridge announces itself with the SP entry of the root's "."
+	self, err := img.firstRecord(root.extent)
+	if err != nil {
+		return root, err
+	}
+	if len(self.system) >= 7 && string(self.system[:2]) == "SP" && self.system[4] == 0xbe && self.system[5] == 0xef {
+		img.rockRidge, img.suspSkip = true, int(self.system[6])
+		return root, nil
+	}
+	if joliet != nil {
+		img.joliet = true
+		return parseISORecord(joliet[156:190])
+	}
+	return root, nil
+}
+
+// firstRecord returns the first record of the directory at extent, its ".".
+func (img *isoImage) firstRecord(extent uint32) (isoRecord, error) {
+	sector := make([]byte, isoSectorSize)
+	if _, err := img.r.ReadAt(sector, int64(extent)*isoSectorSize); err != nil {
+		return isoRecord{}, fmt.Errorf("read directory: %w", err)
+	}
+	return parseISORecord(sector)
+}
+
+// parseISORecord parses the directory record b starts with.
+func parseISORecord(b []byte) (isoRecord, error) {
+	if len(b) < 34 || int(b[0]) < 34 || int(b[0]) > len(b) || 33+int(b[32]) > int(b[0]) {
+		return isoRecord{}, errors.New("malformed directory record")
+	}
+	n, nameLen := int(b[0]), int(b[32])
+	rec := isoRecord{
+		extent:  binary.LittleEndian.Uint32(b[2:]),
+		size:    binary.LittleEndian.Uint32(b[10:]),
+		dir:     b[25]&0x02 != 0,
+		multi:   b[25]&0x80 != 0,
+		name:    string(b[33 : 33+nameLen]),
+		modTime: isoTime(b[18:25]),
+	}
+	// names of even length are padded to keep the system use area aligned
+	system := 33 + nameLen + 1 - nameLen%2
+	if system < n {
+		rec.system = b[system:n]
+	}
+	return rec, nil
+}
+
+// readDir returns the records of the directory dir, "." and ".." included.
+func (img *isoImage) readDir(dir isoRecord) ([]isoRecord, error) {
+	if dir.size > 64<<20 {
+		return nil, fmt.Errorf("malformed directory of %d bytes", dir.size)
+	}
+	data := make([]byte, dir.size)
+	if _, err := img.r.ReadAt(data, int64(dir.extent)*isoSectorSize); err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+	var records []isoRecord
+	for off := 0; off < len(data); {
+		// records don't cross sectors, the rest of a sector is zeros
+		if data[off] == 0 {
+			off = (off/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		rec, err := parseISORecord(data[off:])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+		off += int(data[off])
+	}
+	return records, nil
+}
+
+// walk calls handleFile for the entries below dir, stored under prefix.
+func (img *isoImage) walk(ctx context.Context, dir isoRecord, prefix string, handleFile archives.FileHandler, visited map[uint32]bool, depth int) error {
+	if visited[dir.extent] {
+		return nil
+	}
+	visited[dir.extent] = true
+	if depth > isoMaxDepth {
+		return fmt.Errorf("%s: directories nested deeper than %d", prefix, isoMaxDepth)
+	}
+	records, err := img.readDir(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", prefix, err)
+	}
+
+	for i := 0; i < len(records); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec := records[i]
+		if rec.name == "\x00" || rec.name == "\x01" {
+			continue
+		}
+		// the sections of large files are records of their own
+		sections := []isoRecord{rec}
+		for sections[len(sections)-1].multi && i+1 < len(records) {
+			i++
+			sections = append(sections, records[i])
+		}
+
+		entry := EntryInfo{Name: path.Join(prefix, img.name(rec)), ModTime: rec.modTime}
+		var rr rockRidge
+		if img.rockRidge {
+			rr = img.readRockRidge(rec.system)
+			if rr.relocated || (prefix == "" && img.isRelocationDir(rec, rr)) {
+				continue
+			}
+			if rr.name != "" {
+				entry.Name = path.Join(prefix, rr.name)
+			}
+			if rr.child != 0 {
+				// a directory relocated to keep the tree shallow
+				moved, err := img.firstRecord(rr.child)
+				if err != nil {
+					return err
+				}
+				rec.dir, rec.extent, rec.size = true, moved.extent, moved.size
+			}
+		}
+
+		var content io.Reader = bytes.NewReader(nil)
+		switch {
+		case rec.dir:
+			entry.Mode = fs.ModeDir | 0o755
+		case rr.symlink:
+			entry.Mode, entry.LinkTarget = fs.ModeSymlink|0o777, rr.target
+		default:
+			entry.Mode = 0o644
+			readers := make([]io.Reader, 0, len(sections))
+			for _, s := range sections {
+				entry.Size += int64(s.size)
+				readers = append(readers, io.NewSectionReader(img.r, int64(s.extent)*isoSectorSize, int64(s.size)))
+			}
+			content = io.MultiReader(readers...)
+		}
+		if rr.hasMode && (rr.mode.IsDir() == rec.dir) {
+			entry.Mode = rr.mode
+		}
+		if !rr.modTime.IsZero() {
+			entry.ModTime = rr.modTime
+		}
+
+		err := handleFile(ctx, sourceFileInfo(entry, io.NopCloser(content)))
+		switch {
+		case errors.Is(err, fs.SkipAll):
+			return err
+		case errors.Is(err, fs.SkipDir) && rec.dir:
+			continue
+		case err != nil:
+			return fmt.Errorf("handling file: %s: %w", entry.Name, err)
+		}
+		if rec.dir {
+			if err := img.walk(ctx, rec, entry.Name, handleFile, visited, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// name returns the name of rec, without the version of ISO 9660 names.
+func (img *isoImage) name(rec isoRecord) string {
+	name := rec.name
+	if img.joliet {
+		units := make([]uint16, len(name)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16([]byte(name[2*i:]))
+		}
+		name = string(utf16.Decode(units))
+	}
+	if !rec.dir {
+		if base, version, ok := strings.Cut(name, ";"); ok && version != "" {
+			name = base
+		}
+		if !img.joliet {
+			// plain names without extension keep the dot
+			name = strings.TrimSuffix(name, ".")
+		}
+	}
+	return name
+}
+
+// isRelocationDir reports whether the directory rec at the root is where
+// Rock Ridge moved deep directories to, rr_moved: all it has are
+// relocated directories, which are listed where they belong.
+func (img *isoImage) isRelocationDir(rec isoRecord, rr rockRidge) bool {
+	name := rr.name
+	if name == "" {
+		name = img.name(rec)
+	}
+	if !rec.dir || (name != "rr_moved" && name != ".rr_moved") {
+		return false
+	}
+	records, err := img.readDir(rec)
+	if err != nil {
+		return false
+	}
+	for _, child := range records {
+		if child.name != "\x00" && child.name != "\x01" && !img.readRockRidge(child.system).relocated {
+			return false
+		}
+	}
+	return true
+}
+
+// rockRidge is what the Rock Ridge entries of a record say about it.
+type rockRidge struct {
+	name    string
+	mode    fs.FileMode
+	hasMode bool
+	symlink bool
+	target  string
+	// the last component of target continues in the next one
+	join    bool
+	modTime time.Time
+	// the extent of a relocated directory, listed here
+	child uint32
+	// a relocated directory, listed elsewhere
+	relocated bool
+}
+
+// readRockRidge reads the entries of the system use area of a record,
+// following its continuation areas.
+func (img *isoImage) readRockRidge(system []byte) rockRidge {
+	var rr rockRidge
+	area := system[min(img.suspSkip, len(system)):]
+	// continuation areas can't chain forever
+	for hops := 0; len(area) > 0 && hops < 16; hops++ {
+		var next []byte
+	entries:
+		for len(area) >= 4 {
+			n := int(area[2])
+			if n < 4 || n > len(area) {
+				break
+			}
+			data := area[4:n]
+			switch string(area[:2]) {
+			case "PX":
+				if len(data) >= 4 {
+					rr.mode, rr.hasMode = fileMode(binary.LittleEndian.Uint32(data)), true
+				}
+			case "NM":
+				if len(data) >= 1 && data[0]&0x06 == 0 {
+					rr.name += string(data[1:])
+				}
+			case "SL":
+				if len(data) >= 1 {
+					rr.addSymlink(data[1:])
+				}
+			case "TF":
+				rr.modTime = rockRidgeModTime(data)
+			case "CL":
+				if len(data) >= 4 {
+					rr.child = binary.LittleEndian.Uint32(data)
+				}
+			case "RE":
+				rr.relocated = true
+			case "CE":
+				if len(data) >= 24 {
+					block := int64(binary.LittleEndian.Uint32(data))
+					offset := int64(binary.LittleEndian.Uint32(data[8:]))
+					length := binary.LittleEndian.Uint32(data[16:])
+					if length <= isoSectorSize {
+						next = make([]byte, length)
+						if _, err := img.r.ReadAt(next, block*isoSectorSize+offset); err != nil {
+							next = nil
+						}
+					}
+				}
+			case "ST":
+				break entries
+			}
+			area = area[n:]
+		}
+		area = next
+	}
+	return rr
+}
+
+// addSymlink adds the components of an SL entry to the target.
+func (rr *rockRidge) addSymlink(components []byte) {
+	rr.symlink = true
+	for len(components) >= 2 {
+		flags, n := components[0], int(components[1])
+		if 2+n > len(components) {
+			return
+		}
+		part := string(components[2 : 2+n])
+		components = components[2+n:]
+		switch {
+		case flags&0x02 != 0:
+			part = "."
+		case flags&0x04 != 0:
+			part = ".."
+		case flags&0x08 != 0:
+			rr.target, rr.join = "/", true
+			continue
+		}
+		if rr.target != "" && !rr.join {
+			rr.target += "/"
+		}
+		rr.target += part
+		rr.join = flags&0x01 != 0
+	}
+}
+
+// rockRidgeModTime returns the modification time of a TF entry, zero if it
+// has none.
+func rockRidgeModTime(data []byte) time.Time {
+	if len(data) < 1 || data[0]&0x02 == 0 {
+		return time.Time{}
+	}
+	size := 7
+	if data[0]&0x80 != 0 {
+		size = 17
+	}
+	// the creation time comes first
+	off := 1
+	if data[0]&0x01 != 0 {
+		off += size
+	}
+	if off+size > len(data) {
+		return time.Time{}
+	}
+	if size == 7 {
+		return isoTime(data[off:])
+	}
+	return isoLongTime(data[off:])
+}
+
+// isoTime returns the 7 byte time of directory records.
+func isoTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// isoLongTime returns the 17 byte time of volume descriptors, digits and a
+// zone.
+func isoLongTime(b []byte) time.Time {
+	digits := string(b[:16])
+	field := func(from, to int) int {
+		n, _ := strconv.Atoi(digits[from:to])
+		return n
+	}
+	if field(0, 4) == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[16]))*15*60)
+	return time.Date(field(0, 4), time.Month(field(4, 6)), field(6, 8), field(8, 10), field(10, 12), field(12, 14), field(14, 16)*10_000_000, zone)
+}
+
+// Interface guard
+var _ archives.Extraction = ISO9660{}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package arc
+
+import "errors"
+
+// createJunction always fails, junctions only exist on Windows.
+func createJunction(link, target string) error {
+	return errors.ErrUnsupported
+}
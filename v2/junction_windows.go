@@ -0,0 +1,16 @@
+package arc
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// createJunction creates link as an NTFS junction to the directory target,
+// which unlike a symlink needs no privilege.
+func createJunction(link, target string) error {
+	out, err := exec.Command("cmd", "/C", "mklink", "/J", link, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mklink /J: %w: %s", err, out)
+	}
+	return nil
+}
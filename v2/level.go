@@ -0,0 +1,89 @@
+package arc
+
+import (
+	"fmt"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxLevel stands for the highest level of a compression in
+// CompressionLevel.
+const MaxLevel = -1
+
+// CompressionLevel returns compression set to the given level, in the scale of
+// the usual command line tool of the format: 1-9 for gzip, zlib, deflate,
+// bzip2 and lz4, 1-22 for zstd, 0-11 for brotli and 1-3 for snappy and s2,
+// from fast to best, or MaxLevel. Xz and lzip have no levels.
+func CompressionLevel(compression archives.Compression, level int) (archives.Compression, error) {
+	checkRange := func(min, max int) error {
+		if level == MaxLevel {
+			level = max
+			return nil
+		}
+		if level < min || level > max {
+			return fmt.Errorf("%T compression level %d is out of range %d-%d", compression, level, min, max)
+		}
+		return nil
+	}
+	switch c := compression.(type) {
+	case archives.Gz:
+		if err := checkRange(1, 9); err != nil {
+			return nil, err
+		}
+		c.CompressionLevel = level
+		return c, nil
+	case archives.Zlib:
+		if err := checkRange(1, 9); err != nil {
+			return nil, err
+		}
+		c.CompressionLevel = level
+		return c, nil
+	case Deflate:
+		if err := checkRange(1, 9); err != nil {
+			return nil, err
+		}
+		c.CompressionLevel = level
+		return c, nil
+	case archives.Sz:
+		if err := checkRange(1, 3); err != nil {
+			return nil, err
+		}
+		c.S2.Compression = archives.S2Level(level)
+		return c, nil
+	case S2:
+		if err := checkRange(1, 3); err != nil {
+			return nil, err
+		}
+		c.Sz.S2.Compression = archives.S2Level(level)
+		return c, nil
+	case archives.Bz2:
+		if err := checkRange(1, 9); err != nil {
+			return nil, err
+		}
+		c.CompressionLevel = level
+		return c, nil
+	case archives.Lz4:
+		if err := checkRange(1, 9); err != nil {
+			return nil, err
+		}
+		// the lz4 package numbers its levels as bits, starting at 1<<9
+		c.CompressionLevel = 1 << (8 + level)
+		return c, nil
+	case archives.Zstd:
+		if err := checkRange(1, 22); err != nil {
+			return nil, err
+		}
+		// the encoder maps the 22 zstd levels onto its own 4
+		c.EncoderOptions = append(c.EncoderOptions[:len(c.EncoderOptions):len(c.EncoderOptions)],
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		return c, nil
+	case archives.Brotli:
+		if err := checkRange(0, 11); err != nil {
+			return nil, err
+		}
+		c.Quality = level
+		return c, nil
+	}
+	return nil, fmt.Errorf("compression %T has no levels", compression)
+}
@@ -0,0 +1,184 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ErrLimitExceeded is wrapped by the errors of extractions going beyond
+// their Limits, see LimitError.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limits bounds what extraction accepts from an archive, for archives of
+// untrusted origin like uploads to a service. Zero values don't limit.
+type Limits struct {
+	// MaxArchiveSize is the size of the archive, after joining volumes
+	MaxArchiveSize int64
+	// MaxSize is the total size of the extracted files, the archive may
+	// claim less than it holds, the content is counted as it is read
+	MaxSize int64
+	// MaxEntries is the number of entries, directories included
+	MaxEntries int64
+	// Formats are the formats allowed, named after their extension without
+	// the leading dot like "zip", "tar" and "tar.gz"
+	Formats []string
+}
+
+// WithLimits makes extraction fail with a LimitError as soon as the archive
+// goes beyond limits. Files extracted before are kept.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// LimitError reports which of the Limits an archive went beyond.
+type LimitError struct {
+	// Limit is "archive size", "size", "entries" or "format"
+	Limit string
+	// Max is the value of the limit, unused for formats
+	Max int64
+	// Format is the format refused, for "format"
+	Format string
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == "format" {
+		return fmt.Sprintf("%s: format %s isn't allowed", ErrLimitExceeded, e.Format)
+	}
+	return fmt.Sprintf("%s: %s above %d", ErrLimitExceeded, e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// StatusCode is the HTTP status to answer a request whose archive went
+// beyond the limit with: 415 for a format, 413 otherwise.
+func (e *LimitError) StatusCode() int {
+	if e.Limit == "format" {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+// limitArchive checks the size of input against MaxArchiveSize, seekable
+// input up front and streams as they are read.
+func limitArchive(input io.Reader, limits Limits) (io.Reader, error) {
+	if limits.MaxArchiveSize <= 0 {
+		return input, nil
+	}
+	limitErr := &LimitError{Limit: "archive size", Max: limits.MaxArchiveSize}
+	if seeker, ok := input.(io.Seeker); ok {
+		offset, seekErr := seeker.Seek(0, io.SeekCurrent)
+		if seekErr != nil {
+			return nil, seekErr
+		}
+		size, seekErr := seeker.Seek(0, io.SeekEnd)
+		if seekErr != nil {
+			return nil, seekErr
+		}
+		if _, seekErr := seeker.Seek(offset, io.SeekStart); seekErr != nil {
+			return nil, seekErr
+		}
+		if size-offset > limits.MaxArchiveSize {
+			return nil, limitErr
+		}
+		return input, nil
+	}
+	return &limitReader{Reader: input, left: limits.MaxArchiveSize, err: limitErr}, nil
+}
+
+// checkFormat fails if format isn't one of the Formats allowed.
+func checkFormat(format archives.Format, limits Limits) error {
+	name := strings.TrimPrefix(format.Extension(), ".")
+	if len(limits.Formats) == 0 || slices.Contains(limits.Formats, name) {
+		return nil
+	}
+	return &LimitError{Limit: "format", Format: name}
+}
+
+// limitFS checks the archive of fsys against the limits of o, like an
+// extraction that doesn't read the content of the entries would. Directories
+// and plain files aren't archives and aren't checked.
+func limitFS(archive string, fsys fs.FS, o *options) error {
+	limits := o.limits
+	if limits.MaxArchiveSize <= 0 && limits.MaxSize <= 0 && limits.MaxEntries <= 0 && len(limits.Formats) == 0 {
+		return nil
+	}
+	if _, isArchive := fsys.(*archives.ArchiveFS); !isArchive {
+		return nil
+	}
+	return extractArchive(archive, func(context.Context, archives.FileInfo) error { return nil }, o)
+}
+
+// limitHandler fails once the entries or their content go beyond limits.
+// Declared sizes are checked before the content is read.
+func limitHandler(handler archives.FileHandler, limits Limits) archives.FileHandler {
+	if limits.MaxEntries <= 0 && limits.MaxSize <= 0 {
+		return handler
+	}
+	// entries seen, the sizes they declared and the content read so far
+	var entries, declared, read int64
+	return func(ctx context.Context, f archives.FileInfo) error {
+		entries++
+		if limits.MaxEntries > 0 && entries > limits.MaxEntries {
+			return &LimitError{Limit: "entries", Max: limits.MaxEntries}
+		}
+		if limits.MaxSize > 0 && !f.IsDir() {
+			declared += f.Size()
+			if declared > limits.MaxSize {
+				return &LimitError{Limit: "size", Max: limits.MaxSize}
+			}
+			open := f.Open
+			f.Open = func() (fs.File, error) {
+				file, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return &limitFile{File: file, read: &read, max: limits.MaxSize}, nil
+			}
+		}
+		return handler(ctx, f)
+	}
+}
+
+// limitReader fails with err once more than left bytes are read.
+type limitReader struct {
+	io.Reader
+	left int64
+	err  error
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.left -= int64(n)
+	if r.left < 0 {
+		return n, r.err
+	}
+	return n, err
+}
+
+// limitFile fails once the content read from all files exceeds max.
+type limitFile struct {
+	fs.File
+	read *int64
+	max  int64
+}
+
+func (f *limitFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	*f.read += int64(n)
+	if *f.read > f.max {
+		return n, &LimitError{Limit: "size", Max: f.max}
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+package arc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+// LinkMode selects how files are materialized from the content cache.
+type LinkMode int
+
+const (
+	// LinkSymlink creates symbolic links pointing into the cache.
+	LinkSymlink LinkMode = iota
+	// LinkHardlink creates hard links to the cached objects, the cache
+	// must be on the same file system as the destination.
+	LinkHardlink
+)
+
+// WithLinkFarm makes Unarchive store file contents once in a content-addressed
+// cache under cacheDir and create links to the cached objects instead of
+// copying file data into the destination. Repeated extraction of the same
+// archive only creates links.
+func WithLinkFarm(cacheDir string, mode LinkMode) Option {
+	return func(o *options) {
+		o.cacheDir = cacheDir
+		o.linkMode = mode
+	}
+}
+
+// cachedFile collects the content of an extracted file in a temp file of
+// the cache. Closing it moves the content into the cache and links dstPath
+// to the cached object.
+type cachedFile struct {
+	tmpFile *os.File
+	hash    hash.Hash
+	perm    os.FileMode
+	dstPath string
+	o       *options
+}
+
+func createCachedFile(dstPath string, mode os.FileMode, o *options) (*cachedFile, error) {
+	if dirErr := createDirWithPermissions(o.cacheDir, dirPermissions); dirErr != nil {
+		return nil, dirErr
+	}
+	tmpFile, tmpErr := os.CreateTemp(o.cacheDir, ".tmp-")
+	if tmpErr != nil {
+		return nil, fmt.Errorf("create temp file: %w", tmpErr)
+	}
+	return &cachedFile{
+		tmpFile: tmpFile,
+		hash:    sha256.New(),
+		perm:    mode.Perm() &^ 0o222, // cached objects are never written to
+		dstPath: dstPath,
+		o:       o,
+	}, nil
+}
+
+func (c *cachedFile) Write(p []byte) (int, error) {
+	c.hash.Write(p)
+	return c.tmpFile.Write(p)
+}
+
+// Discard removes the temp file without caching it, see discarder.
+func (c *cachedFile) Discard() error {
+	c.tmpFile.Close()
+	return os.Remove(c.tmpFile.Name())
+}
+
+func (c *cachedFile) Close() error {
+	defer os.Remove(c.tmpFile.Name())
+	if closeErr := c.tmpFile.Close(); closeErr != nil {
+		return fmt.Errorf("close temp file: %w", closeErr)
+	}
+	objPath, cacheErr := c.store()
+	if cacheErr != nil {
+		return fmt.Errorf("caching file: %w", cacheErr)
+	}
+	return linkFromCache(objPath, c.dstPath, c.o.linkMode)
+}
+
+// store moves the temp file into the cache and returns the path of the cached
+// object. Objects are keyed by content hash and permissions, since hard links
+// share their mode with the cached object.
+func (c *cachedFile) store() (string, error) {
+	sum := hex.EncodeToString(c.hash.Sum(nil))
+	objPath := filepath.Join(c.o.cacheDir, sum[:2], fmt.Sprintf("%s-%04o", sum, c.perm))
+	if isExist(objPath) {
+		logging("Cache hit: %s", objPath)
+		return objPath, nil
+	}
+
+	if dirErr := createDirWithPermissions(filepath.Dir(objPath), dirPermissions); dirErr != nil {
+		return "", dirErr
+	}
+	if chmodErr := setPermissions(c.tmpFile.Name(), c.perm); chmodErr != nil {
+		return "", chmodErr
+	}
+	if renameErr := os.Rename(c.tmpFile.Name(), objPath); renameErr != nil {
+		return "", fmt.Errorf("rename into cache: %w", renameErr)
+	}
+	logging("Cached %s as %s", c.dstPath, objPath)
+	return objPath, nil
+}
+
+// linkFromCache materializes the cached object at dstPath as a link.
+func linkFromCache(objPath, dstPath string, mode LinkMode) error {
+	absObjPath, absErr := filepath.Abs(objPath)
+	if absErr != nil {
+		return fmt.Errorf("resolving cache path: %w", absErr)
+	}
+
+	if removeErr := os.Remove(dstPath); removeErr != nil && !os.IsNotExist(removeErr) {
+		return fmt.Errorf("remove existing file: %w", removeErr)
+	}
+
+	switch mode {
+	case LinkHardlink:
+		if linkErr := os.Link(absObjPath, dstPath); linkErr != nil {
+			return fmt.Errorf("hardlink: %w", linkErr)
+		}
+	default:
+		if linkErr := os.Symlink(absObjPath, dstPath); linkErr != nil {
+			return fmt.Errorf("symlink: %w", linkErr)
+		}
+	}
+	logging("Successfully linked file: %s -> %s", dstPath, absObjPath)
+	return nil
+}
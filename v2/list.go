@@ -0,0 +1,234 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// WalkFunc is called by Walk for each entry of an archive. The entry can
+// only be opened until WalkFunc returns. Returning fs.SkipAll stops the walk
+// without error, any other error aborts it.
+type WalkFunc func(f archives.FileInfo) error
+
+// Walk calls fn for each entry of an archive in archive order, without
+// extracting it or holding more than one entry in memory, so archives with
+// millions of entries can be listed.
+// archive: the archive to walk
+// fn: called for each entry, see WalkFunc
+// opts: optional settings, see Option
+func Walk(archive string, fn WalkFunc, opts ...Option) error {
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		return fn(f)
+	}
+	if err := extractArchive(archive, handler, newOptions(opts)); err != nil && !errors.Is(err, fs.SkipAll) {
+		return fmt.Errorf("walk %s: %w", archive, err)
+	}
+	return nil
+}
+
+// WalkReader is Walk for the archive read from r, like os.Stdin, the
+// counterpart of UnarchiveReader. Zip and 7z archives need random access and
+// are buffered in a temp file first.
+// r: the archive stream
+// fn: called for each entry, see WalkFunc
+// opts: optional settings, see Option
+func WalkReader(r io.Reader, fn WalkFunc, opts ...Option) error {
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		return fn(f)
+	}
+	if err := extractStream("", newTrailerReader(r), handler, newOptions(opts)); err != nil && !errors.Is(err, fs.SkipAll) {
+		return fmt.Errorf("walk stream: %w", err)
+	}
+	return nil
+}
+
+// ExtractEntry copies the content of the entry called name to w, like
+// unzip -p, reading the archive only up to that entry. Names are compared
+// without leading "./" and "/"; if an archive has several entries of that
+// name, the first one is copied. Missing entries are an error wrapping
+// fs.ErrNotExist, directories and links an error too.
+// archive: the archive to read
+// name: the path of the entry in the archive
+// w: where the content is copied to
+// opts: optional settings, like WithEntryCache
+func ExtractEntry(archive, name string, w io.Writer, opts ...Option) error {
+	walk := func(fn WalkFunc) error {
+		return Walk(archive, fn, opts...)
+	}
+	if cache := newOptions(opts).entryCache; cache != nil {
+		return cache.extract(archive, name, w, func(w io.Writer) error {
+			return extractEntry(archive, name, w, walk)
+		})
+	}
+	return extractEntry(archive, name, w, walk)
+}
+
+// ExtractEntryReader is ExtractEntry for the archive read from r, like
+// os.Stdin. The stream is read up to the entry, so only one entry can be
+// copied from it. Zip and 7z archives need random access and are buffered
+// in a temp file first.
+// r: the archive stream
+// name: the path of the entry in the archive
+// w: where the content is copied to
+// opts: optional settings, see Option
+func ExtractEntryReader(r io.Reader, name string, w io.Writer, opts ...Option) error {
+	return extractEntry("stream", name, w, func(fn WalkFunc) error {
+		return WalkReader(r, fn, opts...)
+	})
+}
+
+// extractEntry copies the content of the entry called name to w, walk
+// reads the archive.
+func extractEntry(archive, name string, w io.Writer, walk func(WalkFunc) error) error {
+	want := cleanEntryName(name)
+	found := false
+	err := walk(func(f archives.FileInfo) error {
+		if cleanEntryName(f.NameInArchive) != want {
+			return nil
+		}
+		found = true
+		switch {
+		case f.IsDir():
+			return fmt.Errorf("%s is a directory", name)
+		case f.LinkTarget != "":
+			return fmt.Errorf("%s is a link to %s", name, f.LinkTarget)
+		case !f.Mode().IsRegular():
+			return fmt.Errorf("%s is not a regular file", name)
+		}
+		reader, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", name, err)
+		}
+		defer reader.Close()
+		if _, err := io.Copy(w, reader); err != nil {
+			return fmt.Errorf("copy %s: %w", name, err)
+		}
+		return fs.SkipAll
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s in %s: %w", name, archive, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// cleanEntryName returns the path of an entry without leading "./" and "/"
+// and trailing slashes, to compare names given by users with those in
+// archives.
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Entry is an entry of an archive yielded by Entries. It can only be opened
+// until the loop moves on to the next entry.
+type Entry struct {
+	archives.FileInfo
+}
+
+// Info returns the description of the entry used by Source, so entries can
+// be copied into another archive.
+func (e Entry) Info() EntryInfo {
+	return EntryInfo{
+		Name:       e.NameInArchive,
+		Size:       e.Size(),
+		Mode:       e.Mode(),
+		ModTime:    e.ModTime(),
+		LinkTarget: e.LinkTarget,
+	}
+}
+
+// Entries returns an iterator over the entries of an archive in archive
+// order, for use with range like Walk:
+//
+//	for entry, err := range arc.Entries(ctx, "app.tar.zst") {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(entry.NameInArchive)
+//	}
+//
+// A failure is yielded once, as the last pair. Breaking out of the loop
+// stops reading the archive, and so does canceling ctx, which yields its
+// error.
+// ctx: stops the iteration when canceled
+// archive: the archive to read
+// opts: optional settings, see Option
+func Entries(ctx context.Context, archive string, opts ...Option) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		stopped := false
+		handler := func(_ context.Context, f archives.FileInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !yield(Entry{FileInfo: f}, nil) {
+				stopped = true
+				return fs.SkipAll
+			}
+			return nil
+		}
+		err := extractArchive(archive, handler, newOptions(opts))
+		if err != nil && !stopped {
+			yield(Entry{}, fmt.Errorf("entries of %s: %w", archive, err))
+		}
+	}
+}
+
+// List returns the entries of an archive in archive order, without
+// extracting them. The entries can't be opened once List returns. Use Walk
+// or ListPage for very large archives.
+// archive: the archive to list
+// opts: optional settings, see Option
+func List(archive string, opts ...Option) ([]archives.FileInfo, error) {
+	var entries []archives.FileInfo
+	err := Walk(archive, func(f archives.FileInfo) error {
+		entries = append(entries, f)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ListPage returns at most limit entries of an archive, starting at the
+// entry with index offset, and whether more entries follow. Reading stops
+// after the page, but the entries before offset are read again for every
+// page of compressed archives.
+// archive: the archive to list
+// offset: the index of the first entry to return
+// limit: the maximum number of entries to return
+// opts: optional settings, see Option
+func ListPage(archive string, offset, limit int, opts ...Option) ([]archives.FileInfo, bool, error) {
+	if offset < 0 || limit < 1 {
+		return nil, false, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+	var entries []archives.FileInfo
+	more := false
+	index := 0
+	err := Walk(archive, func(f archives.FileInfo) error {
+		defer func() { index++ }()
+		if index < offset {
+			return nil
+		}
+		if len(entries) == limit {
+			more = true
+			return fs.SkipAll
+		}
+		entries = append(entries, f)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, more, nil
+}
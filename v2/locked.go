@@ -0,0 +1,167 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when a file of the destination is in use by another
+// process and can't be replaced, like a running executable on Windows.
+var ErrLocked = errors.New("file is locked by another process")
+
+// LockedPolicy decides what Unarchive does with files of the destination that
+// are locked by another process.
+type LockedPolicy int
+
+const (
+	// LockedFail aborts the extraction with an error wrapping ErrLocked,
+	// the default.
+	LockedFail LockedPolicy = iota
+	// LockedRetry tries again a few times with a growing delay, for files
+	// that are only briefly held open, e.g. by a virus scanner.
+	LockedRetry
+	// LockedRename moves the locked file aside to <name>.old and extracts
+	// the new one in its place. Windows allows renaming running
+	// executables, the old file is deleted at the next reboot if it can't
+	// be deleted right away.
+	LockedRename
+	// LockedReplaceOnReboot extracts to <name>.arc-pending and has Windows
+	// replace the locked file with it at the next reboot, which requires
+	// administrator rights. Other systems fail.
+	LockedReplaceOnReboot
+)
+
+var lockedPolicyNames = map[LockedPolicy]string{
+	LockedFail:            "fail",
+	LockedRetry:           "retry",
+	LockedRename:          "rename",
+	LockedReplaceOnReboot: "schedule-reboot-replace",
+}
+
+// lockedRetries is how often LockedRetry tries again, starting after
+// lockedRetryDelay and doubling it every time.
+const (
+	lockedRetries    = 5
+	lockedRetryDelay = 200 * time.Millisecond
+)
+
+func (p LockedPolicy) String() string {
+	if name, ok := lockedPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("LockedPolicy(%d)", int(p))
+}
+
+// ParseLockedPolicy returns the policy named name: fail, retry, rename or
+// schedule-reboot-replace.
+func ParseLockedPolicy(name string) (LockedPolicy, error) {
+	for policy, policyName := range lockedPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return LockedFail, fmt.Errorf("unknown locked file policy %q", name)
+}
+
+// WithOnLocked sets how Unarchive replaces files of the destination that are
+// in use by another process, see LockedPolicy. Files are locked by running
+// programs on Windows, and running executables can't be written on Linux
+// either.
+func WithOnLocked(policy LockedPolicy) Option {
+	return func(o *options) {
+		o.onLocked = policy
+	}
+}
+
+// openLocked creates dstPath again after opening it failed with openErr
+// because it is locked, according to the locked file policy.
+func (d *dirSink) openLocked(dstPath string, mode fs.FileMode, openErr error) (io.WriteCloser, error) {
+	const flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch d.o.onLocked {
+	case LockedFail:
+	case LockedRetry:
+		delay := lockedRetryDelay
+		for attempt := 1; attempt <= lockedRetries; attempt++ {
+			logging("%s is locked, retrying in %s (%d/%d)", dstPath, delay, attempt, lockedRetries)
+			time.Sleep(delay)
+			delay *= 2
+			file, err := os.OpenFile(dstPath, flags, mode)
+			if err == nil || !isLocked(err) {
+				return file, err
+			}
+			openErr = err
+		}
+	case LockedRename:
+		old, err := d.moveAside(dstPath)
+		if err != nil {
+			return nil, err
+		}
+		logging("Moved locked file %s aside to %s", dstPath, old)
+		return os.OpenFile(dstPath, flags, mode)
+	case LockedReplaceOnReboot:
+		pending := dstPath + ".arc-pending"
+		file, err := os.OpenFile(pending, flags, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &rebootReplace{File: file, dstPath: dstPath, o: d.o}, nil
+	default:
+		return nil, fmt.Errorf("unknown locked file policy %v", d.o.onLocked)
+	}
+	return nil, fmt.Errorf("%s: %w: %w", dstPath, ErrLocked, openErr)
+}
+
+// moveAside renames the locked file dstPath to the first free name of
+// <name>.old, <name>.old2 and so on, and deletes it if it can.
+func (d *dirSink) moveAside(dstPath string) (string, error) {
+	old := dstPath + ".old"
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(old); os.IsNotExist(err) {
+			break
+		}
+		old = fmt.Sprintf("%s.old%d", dstPath, i)
+	}
+	if err := os.Rename(dstPath, old); err != nil {
+		return "", fmt.Errorf("move locked file aside: %w", err)
+	}
+	if err := os.Remove(old); err != nil {
+		if err := deleteOnReboot(old); err != nil {
+			logging("Keeping %s, it can't be deleted: %v", old, err)
+		}
+	}
+	return old, nil
+}
+
+// rebootReplace is a file extracted next to a locked one, which replaces it
+// at the next reboot once written.
+type rebootReplace struct {
+	*os.File
+	dstPath string
+	o       *options
+}
+
+// Discard removes the pending file without scheduling it, see discarder.
+func (r *rebootReplace) Discard() error {
+	r.File.Close()
+	return os.Remove(r.File.Name())
+}
+
+func (r *rebootReplace) Close() error {
+	if err := r.File.Close(); err != nil {
+		return err
+	}
+	if err := replaceOnReboot(r.File.Name(), r.dstPath); err != nil {
+		os.Remove(r.File.Name())
+		return fmt.Errorf("schedule replacing %s at reboot: %w", r.dstPath, err)
+	}
+	warning := fmt.Errorf("%s is locked, it is replaced at the next reboot", r.dstPath)
+	logging("Warning: %v", warning)
+	if r.o.warn != nil {
+		r.o.warn(warning)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !unix
+
+package arc
+
+import "errors"
+
+// isLocked reports false, files are never locked on other systems.
+func isLocked(err error) bool {
+	return false
+}
+
+// replaceOnReboot is only supported on Windows.
+func replaceOnReboot(src, dst string) error {
+	return errors.ErrUnsupported
+}
+
+// deleteOnReboot is only supported on Windows.
+func deleteOnReboot(name string) error {
+	return errors.ErrUnsupported
+}
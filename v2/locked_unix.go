@@ -0,0 +1,25 @@
+//go:build unix
+
+package arc
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLocked reports whether err is the kernel refusing to write a running
+// executable.
+func isLocked(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY)
+}
+
+// replaceOnReboot is only supported on Windows.
+func replaceOnReboot(src, dst string) error {
+	return errors.ErrUnsupported
+}
+
+// deleteOnReboot is only supported on Windows, a renamed file can be
+// deleted right away elsewhere.
+func deleteOnReboot(name string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,37 @@
+package arc
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// isLocked reports whether err is Windows refusing to open a file another
+// process has open, or has mapped like a running executable.
+func isLocked(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) ||
+		errors.Is(err, windows.ERROR_LOCK_VIOLATION) ||
+		errors.Is(err, windows.ERROR_USER_MAPPED_FILE)
+}
+
+// replaceOnReboot has Windows move src over dst at the next reboot.
+func replaceOnReboot(src, dst string) error {
+	from, err := windows.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	return windows.MoveFileEx(from, to, windows.MOVEFILE_REPLACE_EXISTING|windows.MOVEFILE_DELAY_UNTIL_REBOOT)
+}
+
+// deleteOnReboot has Windows delete name at the next reboot.
+func deleteOnReboot(name string) error {
+	from, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	return windows.MoveFileEx(from, nil, windows.MOVEFILE_DELAY_UNTIL_REBOOT)
+}
@@ -7,8 +7,12 @@ import (
 
 var DEBUG = os.Getenv("ARC_DEBUG") == "true"
 
+// Logger prints the debug messages enabled by DEBUG, the standard logger by
+// default.
+var Logger = log.Default()
+
 func logging(fmt_str string, a ...interface{}) {
 	if DEBUG {
-		log.Printf(fmt_str, a...)
+		Logger.Printf(fmt_str, a...)
 	}
 }
@@ -0,0 +1,82 @@
+//go:build !arc_no_lzma && !arc_minimal
+
+package arc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// lzmaHeaderSize is the size of the header of .lzma files: the properties,
+// the dictionary size and the uncompressed size.
+const lzmaHeaderSize = 13
+
+// Lzma is the legacy .lzma container of LZMA Utils and the LZMA SDK, the
+// format xz replaced but firmware toolchains still emit. It is written with
+// an end marker rather than the size, which isn't known up front.
+type Lzma struct{}
+
+func (Lzma) Extension() string { return ".lzma" }
+func (Lzma) MediaType() string { return "application/x-lzma" }
+
+// Match matches by name, and by a header with valid properties, a
+// dictionary size the LZMA SDK would write and a plausible size, as the
+// format has no magic number.
+func (l Lzma) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.EqualFold(path.Ext(filename), l.Extension())
+	if stream == nil {
+		return mr, nil
+	}
+	header := make([]byte, lzmaHeaderSize)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = isLzmaHeader(header)
+	return mr, nil
+}
+
+// isLzmaHeader reports whether header is a plausible .lzma header.
+func isLzmaHeader(header []byte) bool {
+	// lc + lp*9 + pb*45, with pb at most 4
+	if header[0] >= 9*5*5 {
+		return false
+	}
+	// 2^n or 2^n + 2^(n-1), from 4 KiB
+	dict := binary.LittleEndian.Uint32(header[1:])
+	if dict < 1<<12 {
+		return false
+	}
+	top := uint32(1) << (bits.Len32(dict) - 1)
+	if dict != top && dict != top|top>>1 {
+		return false
+	}
+	size := binary.LittleEndian.Uint64(header[5:])
+	return size == 1<<64-1 || size < 1<<40
+}
+
+func (Lzma) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	return lzma.NewWriter(w)
+}
+
+func (Lzma) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	lr, err := lzma.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(lr), nil
+}
+
+// Interface guard
+var _ archives.Compression = Lzma{}
@@ -0,0 +1,283 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ManifestName is the name of the checksum manifest embedded in archives.
+// The manifest uses the format of sha256sum(1), one line per regular file.
+const ManifestName = "SHA256SUMS"
+
+// WithManifest makes Archive compute the SHA-256 of every regular file while
+// it is written, and embed the resulting manifest as ManifestName at the root
+// of the archive.
+func WithManifest() Option {
+	return func(o *options) {
+		o.manifest = true
+	}
+}
+
+// WithManifestFile makes Archive write the SHA-256 manifest to path, next to
+// the archive (typically a sidecar SHA256SUMS file). When passed to Unarchive,
+// the archive is verified against the manifest at path before extraction.
+func WithManifestFile(path string) Option {
+	return func(o *options) {
+		o.manifestFile = path
+	}
+}
+
+// WithVerifyManifest makes Unarchive verify the archive against its manifest
+// before anything is extracted, see VerifyManifest. The embedded manifest is
+// used unless WithManifestFile is given as well.
+func WithVerifyManifest() Option {
+	return func(o *options) {
+		o.verifyManifest = true
+	}
+}
+
+// manifest collects entry checksums in archive order.
+type manifest struct {
+	mu    sync.Mutex
+	names []string
+	sums  map[string]string
+}
+
+// set records the checksum of an entry.
+func (m *manifest) set(name, sum string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sums[name] = sum
+}
+
+// bytes renders the manifest in sha256sum(1) format.
+func (m *manifest) bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var buf bytes.Buffer
+	for _, name := range m.names {
+		fmt.Fprintf(&buf, "%s  %s\n", m.sums[name], name)
+	}
+	return buf.Bytes()
+}
+
+// hashingFile computes the SHA-256 of an entry while the archiver reads it.
+type hashingFile struct {
+	fs.File
+	hash hash.Hash
+	done func(sum string)
+}
+
+func (h *hashingFile) Read(p []byte) (int, error) {
+	n, err := h.File.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+func (h *hashingFile) Close() error {
+	h.done(hex.EncodeToString(h.hash.Sum(nil)))
+	return h.File.Close()
+}
+
+// manifestInfo describes the embedded manifest entry. Its size is computed
+// when the archiver writes the entry header, after all files were hashed.
+type manifestInfo struct {
+	m       *manifest
+	modTime time.Time
+}
+
+func (manifestInfo) Name() string          { return ManifestName }
+func (mi manifestInfo) Size() int64        { return int64(len(mi.m.bytes())) }
+func (manifestInfo) Mode() fs.FileMode     { return 0o644 }
+func (mi manifestInfo) ModTime() time.Time { return mi.modTime }
+func (manifestInfo) IsDir() bool           { return false }
+func (manifestInfo) Sys() any              { return nil }
+
+// newManifest creates an empty manifest.
+func newManifest() *manifest {
+	return &manifest{sums: make(map[string]string)}
+}
+
+// wrap makes a regular file hashed while the archiver reads it.
+func (m *manifest) wrap(fi archives.FileInfo) archives.FileInfo {
+	if !fi.Mode().IsRegular() || fi.Open == nil {
+		return fi
+	}
+	name := fi.NameInArchive
+	open := fi.Open
+	fi.Open = func() (fs.File, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &hashingFile{File: f, hash: sha256.New(), done: func(sum string) { m.set(name, sum) }}, nil
+	}
+	m.mu.Lock()
+	m.names = append(m.names, name)
+	m.mu.Unlock()
+	return fi
+}
+
+// fileInfo returns the entry of the embedded manifest. It must be archived
+// last; it is rendered when opened, after every other entry has been read.
+func (m *manifest) fileInfo() archives.FileInfo {
+	info := manifestInfo{m: m, modTime: time.Now()}
+	return archives.FileInfo{
+		FileInfo:      info,
+		NameInArchive: ManifestName,
+		Open: func() (fs.File, error) {
+			return manifestFile{Reader: bytes.NewReader(m.bytes()), info: info}, nil
+		},
+	}
+}
+
+// addManifest wraps the regular files so they are hashed while being archived,
+// and appends the manifest itself as the last entry if embed is true.
+func addManifest(files []archives.FileInfo, embed bool) ([]archives.FileInfo, *manifest) {
+	m := newManifest()
+	wrapped := make([]archives.FileInfo, 0, len(files)+1)
+	for _, fi := range files {
+		wrapped = append(wrapped, m.wrap(fi))
+	}
+	if embed {
+		wrapped = append(wrapped, m.fileInfo())
+	}
+	return wrapped, m
+}
+
+// manifestFile is the fs.File served for the embedded manifest.
+type manifestFile struct {
+	*bytes.Reader
+	info manifestInfo
+}
+
+func (mf manifestFile) Stat() (fs.FileInfo, error) { return mf.info, nil }
+func (manifestFile) Close() error                  { return nil }
+
+// parseManifest reads a manifest in sha256sum(1) format.
+func parseManifest(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("malformed manifest line: %q", line)
+		}
+		// the second separator character is ' ' for text and '*' for binary mode
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		sums[name] = strings.ToLower(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// VerifyManifest hashes every regular file in archive and compares the result
+// with the manifest, failing on the first mismatch, on missing entries and on
+// entries the manifest doesn't list. If manifestFile is empty, the manifest
+// embedded in the archive (see WithManifest) is used.
+func VerifyManifest(archive, manifestFile string) error {
+	return verifyManifest(archive, manifestFile, newOptions(nil))
+}
+
+// verifyManifest is VerifyManifest with the options needed to read archive.
+func verifyManifest(archive, manifestFile string, o *options) error {
+	logging("Verifying manifest of %s", archive)
+	var expected map[string]string
+	if manifestFile != "" {
+		mf, err := os.Open(manifestFile)
+		if err != nil {
+			return fmt.Errorf("open manifest %s: %w", manifestFile, err)
+		}
+		defer mf.Close()
+		if expected, err = parseManifest(mf); err != nil {
+			return fmt.Errorf("parse manifest %s: %w", manifestFile, err)
+		}
+	}
+
+	actual := make(map[string]string)
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		if !f.Mode().IsRegular() || f.LinkTarget != "" {
+			return nil
+		}
+		reader, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", f.NameInArchive, err)
+		}
+		defer reader.Close()
+
+		// the embedded manifest itself is not part of the checksums
+		if f.NameInArchive == ManifestName {
+			if manifestFile != "" {
+				return nil
+			}
+			if expected, err = parseManifest(reader); err != nil {
+				return fmt.Errorf("parse embedded manifest: %w", err)
+			}
+			return nil
+		}
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, reader); err != nil {
+			return fmt.Errorf("read %s: %w", f.NameInArchive, err)
+		}
+		actual[f.NameInArchive] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	}
+	if err := extractArchive(archive, handler, o); err != nil {
+		return fmt.Errorf("hashing entries: %w", err)
+	}
+	if expected == nil {
+		return fmt.Errorf("no %s manifest found in %s", ManifestName, archive)
+	}
+	if err := compareManifest(expected, actual); err != nil {
+		return err
+	}
+
+	logging("Manifest of %s verified successfully.", archive)
+	return nil
+}
+
+// compareManifest compares the checksums of the manifest with the actual
+// ones of the entries, by name.
+func compareManifest(expected, actual map[string]string) error {
+	names := make([]string, 0, len(actual))
+	for name := range actual {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("entry %s is not listed in the manifest", name)
+		}
+		if sum != actual[name] {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, sum, actual[name])
+		}
+	}
+	for name := range expected {
+		if _, ok := actual[name]; !ok {
+			return fmt.Errorf("entry %s listed in the manifest is missing", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// metadataMagic starts the footer of a metadata block, followed by the
+// length of the whole block as 8 hex digits.
+const metadataMagic = "\narc-metadata:"
+
+// metadataFooterSize is the length of the footer of a metadata block.
+const metadataFooterSize = len(metadataMagic) + 8
+
+// skippableMagic starts a skippable frame, which zstd and lz4 decoders
+// ignore. The low 4 bits are free, arc uses 0xA.
+const skippableMagic = 0x184D2A5A
+
+// gzipMetadataHeader starts an empty gzip member with a comment, FCOMMENT
+// set, no mtime and an unknown OS; gzipMetadataTail ends it after the
+// comment: its terminating NUL, an empty deflate block, CRC-32 and size.
+var (
+	gzipMetadataHeader = []byte{0x1f, 0x8b, 8, 0x10, 0, 0, 0, 0, 0, 0xff}
+	gzipMetadataTail   = []byte{0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+)
+
+// metadataFormat tells where the metadata of an archive lives: the comment
+// of zip archives, or a block appended to zstd, lz4 and gzip streams that
+// their decoders skip, with a header and tail around the key=value lines.
+type metadataFormat struct {
+	zip    bool
+	header func(payloadSize int) []byte
+	tail   []byte
+}
+
+// ReadMetadata returns the key=value pairs embedded in archive with
+// SetMetadata, empty if there are none.
+// archive: a zip archive or a zstd, lz4 or gzip compressed file
+func ReadMetadata(archive string) (map[string]string, error) {
+	f, _, err := openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	format, err := identifyMetadataFormat(f)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata of %s: %w", archive, err)
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seek archive: %w", err)
+	}
+
+	var payload []byte
+	if format.zip {
+		_, payload, err = findZipComment(f, size)
+	} else {
+		_, payload, err = findMetadataBlock(f, size, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read metadata of %s: %w", archive, err)
+	}
+	return parseMetadata(payload)
+}
+
+// SetMetadata merges set into the key=value pairs embedded in archive,
+// removing the keys set to "", for late steps of a pipeline to stamp
+// artifacts with a build id or commit. Only the comment of zip archives and
+// the metadata block at the end of zstd, lz4 and gzip streams are
+// rewritten, never the entries; extraction and other tools ignore them. A
+// checksum trailer is recomputed, signatures become invalid. Keys can't
+// contain "=", and neither keys nor values newlines or NUL.
+// archive: a zip archive or a zstd, lz4 or gzip compressed file, not split
+// set: the keys to set, or to remove with an empty value
+func SetMetadata(archive string, set map[string]string) error {
+	logging("Setting metadata of %s", archive)
+	for key, value := range set {
+		if err := validateMetadata(key, value); err != nil {
+			return err
+		}
+	}
+	if SplitVolumes(archive) != nil {
+		return fmt.Errorf("set metadata of %s: split archives aren't supported", archive)
+	}
+	meta, err := ReadMetadata(archive)
+	if err != nil {
+		return err
+	}
+	for key, value := range set {
+		if value == "" {
+			delete(meta, key)
+		} else {
+			meta[key] = value
+		}
+	}
+
+	// the trailer covers the metadata, it is written again once updated
+	trailed, err := StripTrailer(archive)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(archive, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open archive %s: %w", archive, err)
+	}
+	defer f.Close()
+	if err := writeMetadata(f, meta); err != nil {
+		return fmt.Errorf("set metadata of %s: %w", archive, err)
+	}
+	if trailed {
+		if err := appendTrailer(f); err != nil {
+			return fmt.Errorf("set metadata of %s: %w", archive, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("set metadata of %s: %w", archive, err)
+	}
+	logging("Metadata of %s has %d keys", archive, len(meta))
+	return nil
+}
+
+// writeMetadata replaces the metadata of f by meta, in place.
+func writeMetadata(f *os.File, meta map[string]string) error {
+	format, err := identifyMetadataFormat(f)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	payload := encodeMetadata(meta)
+
+	if format.zip {
+		eocd, _, err := findZipComment(f, size)
+		if err != nil {
+			return err
+		}
+		if len(payload) > 0xffff {
+			return fmt.Errorf("metadata of %d bytes doesn't fit in a zip comment", len(payload))
+		}
+		// the comment length is the last field of the end of central
+		// directory record, the comment follows it
+		record := binary.LittleEndian.AppendUint16(nil, uint16(len(payload)))
+		record = append(record, payload...)
+		if err := f.Truncate(eocd + 20); err != nil {
+			return err
+		}
+		_, err = f.WriteAt(record, eocd+20)
+		return err
+	}
+
+	start, _, err := findMetadataBlock(f, size, format)
+	if err != nil {
+		return err
+	}
+	if start < 0 {
+		start = size
+	}
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	header := format.header(len(payload))
+	blockSize := len(header) + len(payload) + metadataFooterSize + len(format.tail)
+	block := append(header, payload...)
+	block = append(block, fmt.Sprintf("%s%08x", metadataMagic, blockSize)...)
+	block = append(block, format.tail...)
+	_, err = f.WriteAt(block, start)
+	return err
+}
+
+// identifyMetadataFormat tells where the metadata of the archive read from
+// f is kept.
+func identifyMetadataFormat(f io.ReadSeeker) (metadataFormat, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return metadataFormat{}, err
+	}
+	format, _, err := archives.Identify(context.Background(), "", f)
+	if err != nil {
+		return metadataFormat{}, fmt.Errorf("identify format: %w", err)
+	}
+	if compressed, ok := format.(archives.CompressedArchive); ok && compressed.Compression != nil {
+		format = compressed.Compression
+	} else if ok {
+		format = compressed.Archival
+	}
+
+	skippable := func(payloadSize int) []byte {
+		header := binary.LittleEndian.AppendUint32(nil, skippableMagic)
+		return binary.LittleEndian.AppendUint32(header, uint32(payloadSize+metadataFooterSize))
+	}
+	switch format.(type) {
+	case archives.Zip:
+		return metadataFormat{zip: true}, nil
+	case archives.Zstd:
+		return metadataFormat{header: skippable}, nil
+	case archives.Lz4:
+		return metadataFormat{header: skippable}, nil
+	case archives.Gz:
+		return metadataFormat{
+			header: func(int) []byte { return slices.Clone(gzipMetadataHeader) },
+			tail:   gzipMetadataTail,
+		}, nil
+	}
+	return metadataFormat{}, fmt.Errorf("%s can't hold metadata, only zip, zst, lz4 and gz can", strings.TrimPrefix(format.Extension(), "."))
+}
+
+// findMetadataBlock returns the offset and the key=value lines of the
+// metadata block ending the stream of size bytes read from r, -1 and nil if
+// there is none.
+func findMetadataBlock(r io.ReaderAt, size int64, format metadataFormat) (int64, []byte, error) {
+	footerEnd := size - int64(len(format.tail))
+	if footerEnd < int64(metadataFooterSize) {
+		return -1, nil, nil
+	}
+	footer := make([]byte, metadataFooterSize)
+	if _, err := r.ReadAt(footer, footerEnd-int64(metadataFooterSize)); err != nil {
+		return -1, nil, err
+	}
+	if !bytes.HasPrefix(footer, []byte(metadataMagic)) {
+		return -1, nil, nil
+	}
+	blockSize, err := strconv.ParseInt(string(footer[len(metadataMagic):]), 16, 64)
+	if err != nil || blockSize > size {
+		return -1, nil, errors.New("corrupt metadata block")
+	}
+	start := size - blockSize
+	block := make([]byte, blockSize)
+	if _, err := r.ReadAt(block, start); err != nil {
+		return -1, nil, err
+	}
+	// the header and the lines before the footer
+	content := int(blockSize) - metadataFooterSize - len(format.tail)
+	header := format.header(content - len(format.header(0)))
+	if content < len(header) || !bytes.HasPrefix(block, header) || !bytes.HasSuffix(block, format.tail) {
+		return -1, nil, errors.New("corrupt metadata block")
+	}
+	return start, block[len(header):content], nil
+}
+
+// findZipComment returns the offset of the end of central directory record
+// of the zip archive of size bytes read from r, and the comment following it.
+func findZipComment(r io.ReaderAt, size int64) (int64, []byte, error) {
+	// the record is 22 bytes, followed by a comment of up to 64 KiB
+	tailSize := min(size, 22+0xffff)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil {
+		return -1, nil, err
+	}
+	for i := len(tail) - 22; i >= 0; i-- {
+		if !bytes.Equal(tail[i:i+4], []byte("PK\x05\x06")) {
+			continue
+		}
+		commentSize := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+22+commentSize == len(tail) {
+			return size - tailSize + int64(i), tail[i+22:], nil
+		}
+	}
+	return -1, nil, errors.New("zip end of central directory not found")
+}
+
+// encodeMetadata returns the key=value lines of meta, sorted by key.
+func encodeMetadata(meta map[string]string) []byte {
+	var b bytes.Buffer
+	for _, key := range slices.Sorted(maps.Keys(meta)) {
+		fmt.Fprintf(&b, "%s=%s\n", key, meta[key])
+	}
+	return b.Bytes()
+}
+
+// parseMetadata parses the key=value lines of encodeMetadata.
+func parseMetadata(payload []byte) (map[string]string, error) {
+	meta := map[string]string{}
+	for line := range strings.Lines(string(payload)) {
+		key, value, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("not arc metadata: %q", line)
+		}
+		meta[key] = value
+	}
+	return meta, nil
+}
+
+// validateMetadata checks that key and value can be encoded as a line.
+func validateMetadata(key, value string) error {
+	if key == "" || strings.ContainsAny(key, "=\n\x00") {
+		return fmt.Errorf("invalid metadata key %q", key)
+	}
+	if strings.ContainsAny(value, "\n\x00") {
+		return fmt.Errorf("invalid metadata value %q of %s", value, key)
+	}
+	return nil
+}
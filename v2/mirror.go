@@ -0,0 +1,119 @@
+package arc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// WithChecksum pins the SHA-256 of downloaded archives, as hex optionally
+// prefixed with "sha256:". A download that doesn't match fails, and mirrors,
+// which require it, are guaranteed to serve the same archive.
+func WithChecksum(sum string) Option {
+	return func(o *options) {
+		o.checksum = strings.ToLower(strings.TrimPrefix(sum, "sha256:"))
+	}
+}
+
+// pinnedChecksum decodes the checksum pinned with WithChecksum, nil if none.
+func pinnedChecksum(o *options) ([]byte, error) {
+	if o.checksum == "" {
+		return nil, nil
+	}
+	want, err := hex.DecodeString(o.checksum)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 checksum %q", o.checksum)
+	}
+	return want, nil
+}
+
+// checkChecksum compares sum to the checksum pinned with WithChecksum.
+func checkChecksum(sum []byte, o *options) error {
+	want, err := pinnedChecksum(o)
+	if err != nil || want == nil {
+		return err
+	}
+	if !bytes.Equal(sum, want) {
+		return fmt.Errorf("checksum mismatch: got sha256:%x, want sha256:%x", sum, want)
+	}
+	return nil
+}
+
+// DownloadMirrors saves the file served by urls to outfile, trying the
+// mirrors in order until one succeeds. A mirror that fails or serves
+// different content than the checksum pinned with WithChecksum is skipped.
+// Several mirrors require the checksum, without it one serving a stale or
+// tampered copy would be as good as the others.
+func DownloadMirrors(urls []string, outfile string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := checkMirrors(urls, o); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, rawURL := range urls {
+		err := download(rawURL, outfile, o)
+		if err == nil {
+			return nil
+		}
+		// the other mirrors wouldn't fare better
+		if o.context().Err() != nil {
+			return err
+		}
+		logging("Mirror %s failed: %v", rawURL, err)
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("all %d mirrors failed: %w", len(urls), errors.Join(errs...))
+}
+
+// checkMirrors checks urls and the checksum pinned for them, before anything
+// is downloaded.
+func checkMirrors(urls []string, o *options) error {
+	if len(urls) == 0 {
+		return errors.New("no mirror to download from")
+	}
+	if len(urls) > 1 && o.checksum == "" {
+		return fmt.Errorf("%d mirrors require the checksum of the archive, see WithChecksum", len(urls))
+	}
+	// a bad checksum would fail every mirror
+	_, err := pinnedChecksum(o)
+	return err
+}
+
+// UnarchiveMirrors downloads the archive from the first working mirror of
+// urls to a temp file, see DownloadMirrors, and extracts it to dst. Nothing
+// is extracted unless a complete, and with WithChecksum verified, copy of
+// the archive was downloaded.
+func UnarchiveMirrors(urls []string, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := checkMirrors(urls, o); err != nil {
+		return err
+	}
+	if err := o.checkDestination(dst, o.cacheDir); err != nil {
+		return err
+	}
+	// mirrors serve the same file, the first one names it
+	name, err := urlFileName(urls[0])
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "arc-")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the temp copy is arc's own, whatever the allowed roots
+	archive := filepath.Join(tmpDir, name)
+	if err := DownloadMirrors(urls, archive, append(slices.Clip(opts), WithAllowedRoots(tmpDir))...); err != nil {
+		return err
+	}
+	return Unarchive(archive, dst, opts...)
+}
@@ -0,0 +1,60 @@
+package arc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadMirrors(t *testing.T) {
+	good := testContent("v1", 4096)
+	mirrors := map[string][]byte{"/stale": testContent("v0", 4096), "/good": good}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := mirrors[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	urls := []string{ts.URL + "/missing", ts.URL + "/stale", ts.URL + "/good"}
+	sum := fmt.Sprintf("%x", sha256.Sum256(good))
+
+	t.Run("without checksum", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		err := DownloadMirrors(urls, out)
+		if err == nil || !strings.Contains(err.Error(), "WithChecksum") {
+			t.Fatalf("mirrors without a checksum accepted: %v", err)
+		}
+		if _, err := os.Lstat(out); !os.IsNotExist(err) {
+			t.Fatalf("downloaded without a checksum: %v", err)
+		}
+	})
+	t.Run("single URL without checksum", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		if err := DownloadMirrors(urls[2:], out); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("stale mirror skipped", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "file.bin")
+		if err := DownloadMirrors(urls, out, WithChecksum(sum), WithRetries(0)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, good) {
+			t.Fatal("downloaded the stale copy")
+		}
+	})
+}
@@ -0,0 +1,529 @@
+package arc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// OCILayerMediaType is the media type of the layers written by
+// ArchiveOCILayer.
+const OCILayerMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// OCILayer describes a layer written by ArchiveOCILayer, as an image
+// manifest and configuration refer to it.
+type OCILayer struct {
+	MediaType string
+	// Digest is the digest of the compressed layer, which names its blob,
+	// like sha256:...
+	Digest string
+	// DiffID is the digest of the uncompressed tar, listed in the rootfs of
+	// the image configuration
+	DiffID string
+	// Size of the compressed layer in bytes
+	Size int64
+}
+
+// WithLayerBase makes ArchiveOCILayer write the changes from base only:
+// files that are new or differ in type, mode, size or content, and a
+// whiteout for each path removed since. base is the directory the layer was
+// built from before, or an archive of it, like the previous layer when it
+// holds the whole tree. Directories are always written.
+func WithLayerBase(base string) Option {
+	return func(o *options) {
+		o.layerBase = base
+	}
+}
+
+// ArchiveOCILayer writes the content of dir as a layer of an OCI or Docker
+// image: a gzip compressed tar without a top-level directory, sorted, with
+// mtimes set to SOURCE_DATE_EPOCH or 1980-01-01 and owners set to root, so
+// the same tree always gives the same digest, see WithDeterministic.
+// dir: the root file system of the layer
+// outfile: the layer written
+// opts: optional settings, like WithLayerBase
+func ArchiveOCILayer(dir, outfile string, opts ...Option) (OCILayer, error) {
+	o := newOptions(opts)
+	o.directory = dir
+	o.deterministic = true
+	logging("Creating OCI layer of %s: %s", dir, outfile)
+
+	files, err := filesFromDisk(".", o)
+	if err != nil {
+		errMsg := fmt.Errorf("error creating layer '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return OCILayer{}, errMsg
+	}
+	if o.layerBase != "" {
+		if files, err = layerChanges(files, o.layerBase); err != nil {
+			errMsg := fmt.Errorf("error comparing '%s' to base '%s': %w", dir, o.layerBase, err)
+			logging("%s", errMsg.Error())
+			return OCILayer{}, errMsg
+		}
+	}
+
+	format := &layerFormat{}
+	if err := writeArchive(outfile, format, files, o); err != nil {
+		return OCILayer{}, err
+	}
+	return OCILayer{
+		MediaType: OCILayerMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(format.digest.Sum(nil)),
+		DiffID:    "sha256:" + hex.EncodeToString(format.diffID.Sum(nil)),
+		Size:      format.size,
+	}, nil
+}
+
+// layerFormat writes a gzip compressed tar, hashing both the compressed and
+// the uncompressed stream.
+type layerFormat struct {
+	digest, diffID hash.Hash
+	size           int64
+}
+
+func (l *layerFormat) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	l.digest, l.diffID = sha256.New(), sha256.New()
+	counted := &countWriter{w: io.MultiWriter(output, l.digest)}
+	gz, err := archives.Gz{}.OpenWriter(counted)
+	if err != nil {
+		return err
+	}
+	if err := (archives.Tar{}).Archive(ctx, io.MultiWriter(gz, l.diffID), files); err != nil {
+		gz.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	l.size = counted.n
+	return nil
+}
+
+// baseEntry is a path of the base of a layer, see WithLayerBase.
+type baseEntry struct {
+	mode fs.FileMode
+	size int64
+	link string
+	// sum is the SHA-256 of a regular file, read from disk when empty
+	sum    string
+	onDisk string
+}
+
+// layerChanges keeps the files that differ from base, and adds a whiteout
+// for each path of base that is gone. Only the topmost removed path gets
+// one, which hides what is below it.
+func layerChanges(files []archives.FileInfo, base string) ([]archives.FileInfo, error) {
+	entries, err := readLayerBase(base)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]fs.FileMode)
+	var changed []archives.FileInfo
+	for _, f := range files {
+		name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+		if name == "" {
+			continue
+		}
+		present[name] = f.Mode()
+		same, err := sameAsBase(f, entries[name])
+		if err != nil {
+			return nil, err
+		}
+		if !same || f.IsDir() {
+			changed = append(changed, f)
+		}
+	}
+	for name := range entries {
+		if _, ok := present[name]; ok {
+			continue
+		}
+		parent := path.Dir(name)
+		if mode, ok := present[parent]; parent != "." && (!ok || !mode.IsDir()) {
+			continue
+		}
+		whiteout := path.Join(parent, whiteoutPrefix+path.Base(name))
+		logging("Whiteout for removed %s: %s", name, whiteout)
+		changed = append(changed, sourceFileInfo(EntryInfo{Name: whiteout, Mode: 0o644, ModTime: time.Now()}, io.NopCloser(strings.NewReader(""))))
+	}
+	return changed, nil
+}
+
+// sameAsBase tells whether f has the type, mode, link target, size and
+// content of the entry of the base.
+func sameAsBase(f archives.FileInfo, entry *baseEntry) (bool, error) {
+	if entry == nil || f.Mode() != entry.mode || f.LinkTarget != entry.link {
+		return false, nil
+	}
+	if !f.Mode().IsRegular() {
+		return true, nil
+	}
+	if f.Size() != entry.size {
+		return false, nil
+	}
+	if entry.sum == "" {
+		sum, err := sha256File(entry.onDisk)
+		if err != nil {
+			return false, err
+		}
+		entry.sum = sum
+	}
+	sum, err := sha256Entry(f)
+	if err != nil {
+		return false, err
+	}
+	return sum == entry.sum, nil
+}
+
+// readLayerBase returns the entries of the base directory or archive by
+// their path. Whiteouts of an archive are left out.
+func readLayerBase(base string) (map[string]*baseEntry, error) {
+	entries := make(map[string]*baseEntry)
+	info, err := os.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err = filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || p == base {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				return err
+			}
+			entry := &baseEntry{mode: info.Mode(), size: info.Size(), onDisk: p}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if entry.link, err = os.Readlink(p); err != nil {
+					return err
+				}
+			}
+			entries[filepath.ToSlash(rel)] = entry
+			return nil
+		})
+		return entries, err
+	}
+	err = walkArchive(base, func(ctx context.Context, f archives.FileInfo) error {
+		name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+		if name == "" || strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+			return nil
+		}
+		entry := &baseEntry{mode: f.Mode(), size: f.Size(), link: f.LinkTarget}
+		if f.Mode().IsRegular() && f.LinkTarget == "" {
+			sum, err := sha256Entry(f)
+			if err != nil {
+				return err
+			}
+			entry.sum = sum
+		}
+		entries[name] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// sha256Entry returns the hex SHA-256 of the content of f.
+func sha256Entry(f archives.FileInfo) (string, error) {
+	file, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read %s: %w", f.NameInArchive, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ociDescriptor points to a blob of an OCI layout.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// ociManifest is an image manifest, or an index of manifests.
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// imageLayer is a layer of an image: its path in the image and, for OCI
+// layouts, its digest.
+type imageLayer struct {
+	path   string
+	digest string
+}
+
+// ExtractImage extracts the root file system of an image to dst, applying
+// its layers in order: whiteouts remove what lower layers wrote, opaque
+// directories hide their lower content, and hardlinks are kept. The image
+// is an OCI image layout, as a directory or an archive like skopeo's
+// oci-archive, or the archive docker save writes. Of an index of several
+// images, the one for linux and the architecture of the machine is
+// extracted, or else the first. Absolute symlink targets are made relative
+// so they point into dst, and blob digests of OCI layouts are verified.
+// image: the image layout directory or archive
+// dst: the root file system written
+// opts: optional settings, like WithContext
+func ExtractImage(image, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	o.preserve = true
+	logging("Extracting image %s to %s", image, dst)
+	if rootErr := o.checkDestination(dst); rootErr != nil {
+		return rootErr
+	}
+
+	fsys, err := OpenArchiveFS(image)
+	if err != nil {
+		return err
+	}
+	layers, err := imageLayers(fsys)
+	if err != nil {
+		return fmt.Errorf("read image %s: %w", image, err)
+	}
+	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
+		return fmt.Errorf("creating destination directory: %w", dirErr)
+	}
+	sink := &dirSink{dst: dst, o: o}
+	if capErr := sink.detectCapabilities(); capErr != nil {
+		return capErr
+	}
+	for i, layer := range layers {
+		logging("Applying layer %d of %d: %s", i+1, len(layers), layer.path)
+		if err := applyLayer(fsys, layer, sink); err != nil {
+			return fmt.Errorf("apply layer %s: %w", layer.path, err)
+		}
+	}
+	logging("Image extracted successfully.")
+	return nil
+}
+
+// imageLayers returns the layers of the image in fsys, the lowest first.
+func imageLayers(fsys fs.FS) ([]imageLayer, error) {
+	if data, err := fs.ReadFile(fsys, "manifest.json"); err == nil {
+		var images []struct{ Layers []string }
+		if err := json.Unmarshal(data, &images); err != nil {
+			return nil, fmt.Errorf("manifest.json: %w", err)
+		}
+		if len(images) == 0 {
+			return nil, fmt.Errorf("manifest.json lists no image")
+		}
+		if len(images) > 1 {
+			logging("manifest.json lists %d images, extracting the first", len(images))
+		}
+		var layers []imageLayer
+		for _, p := range images[0].Layers {
+			layers = append(layers, imageLayer{path: p})
+		}
+		return layers, nil
+	}
+
+	data, err := fs.ReadFile(fsys, "index.json")
+	if err != nil {
+		return nil, fmt.Errorf("neither an OCI layout nor a docker save archive: %w", err)
+	}
+	for depth := 0; ; depth++ {
+		var manifest ociManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+		if len(manifest.Manifests) == 0 {
+			var layers []imageLayer
+			for _, layer := range manifest.Layers {
+				p, err := blobPath(layer.Digest)
+				if err != nil {
+					return nil, err
+				}
+				layers = append(layers, imageLayer{path: p, digest: layer.Digest})
+			}
+			return layers, nil
+		}
+		if depth == 4 {
+			return nil, fmt.Errorf("indexes nested too deep")
+		}
+		p, err := blobPath(platformManifest(manifest.Manifests).Digest)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = fs.ReadFile(fsys, p); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// platformManifest returns the manifest for linux and the architecture of
+// the machine, or else the first.
+func platformManifest(manifests []ociDescriptor) ociDescriptor {
+	for _, m := range manifests {
+		if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+			return m
+		}
+	}
+	return manifests[0]
+}
+
+// blobPath returns the path of the blob with digest in an OCI layout.
+func blobPath(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || encoded == "" || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return path.Join("blobs", algorithm, encoded), nil
+}
+
+// applyLayer extracts a layer over what the lower layers wrote.
+func applyLayer(fsys fs.FS, layer imageLayer, sink *dirSink) (err error) {
+	file, err := fsys.Open(layer.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var input io.Reader = file
+	var hash hash.Hash
+	if algorithm, encoded, _ := strings.Cut(layer.digest, ":"); algorithm == "sha256" {
+		hash = sha256.New()
+		input = io.TeeReader(file, hash)
+		defer func() {
+			if err == nil && hex.EncodeToString(hash.Sum(nil)) != encoded {
+				err = fmt.Errorf("digest mismatch, expected %s", layer.digest)
+			}
+		}()
+	}
+
+	applier := &layerApplier{sink: sink, written: make(map[string]bool)}
+	if err = extractStream(layer.path, input, applier.apply, sink.o); err != nil {
+		return err
+	}
+	// hash what the extractor left, like the padding after the tar trailer
+	if _, err = io.Copy(io.Discard, input); err != nil {
+		return err
+	}
+	return sink.finish()
+}
+
+// layerApplier extracts the entries of a layer, see ExtractImage.
+type layerApplier struct {
+	sink *dirSink
+	// written holds the paths of the layer and their parents, which an
+	// opaque directory keeps
+	written map[string]bool
+}
+
+func (a *layerApplier) apply(ctx context.Context, f archives.FileInfo) error {
+	name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+	if name == "" {
+		return nil
+	}
+	dir, base := path.Split(name)
+	switch {
+	case base == whiteoutOpaque:
+		return a.opaque(path.Clean("/" + dir)[1:])
+	case strings.HasPrefix(base, whiteoutPrefix):
+		return a.remove(dir + strings.TrimPrefix(base, whiteoutPrefix))
+	}
+	for p := name; p != "."; p = path.Dir(p) {
+		a.written[p] = true
+	}
+
+	dstPath, err := securePath(a.sink.dst, name)
+	if err != nil {
+		return err
+	}
+	// what a lower layer wrote is replaced, not written through: a file
+	// may be a hardlink, and only a directory stays one
+	if existing, statErr := os.Lstat(dstPath); statErr == nil && !(existing.IsDir() && f.IsDir()) {
+		if err := os.RemoveAll(dstPath); err != nil {
+			return fmt.Errorf("replace %s: %w", name, err)
+		}
+	}
+
+	switch {
+	case f.Mode()&fs.ModeSymlink != 0:
+		if path.IsAbs(f.LinkTarget) {
+			// absolute targets are relative to the root of the image
+			rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash("/"+name)), filepath.FromSlash(path.Clean(f.LinkTarget)))
+			if err != nil {
+				return err
+			}
+			f.LinkTarget = filepath.ToSlash(rel)
+		}
+	case f.LinkTarget != "" && !f.IsDir():
+		target, err := securePath(a.sink.dst, f.LinkTarget)
+		if err != nil {
+			return err
+		}
+		if err := createDirWithPermissions(filepath.Dir(dstPath), dirPermissions); err != nil {
+			return err
+		}
+		logging("Creating hardlink: %s -> %s", dstPath, target)
+		if err := os.Link(target, dstPath); err != nil {
+			return fmt.Errorf("hardlink: %w", err)
+		}
+		return nil
+	}
+	return a.sink.extract(ctx, f)
+}
+
+// remove applies the whiteout of name.
+func (a *layerApplier) remove(name string) error {
+	dstPath, err := securePath(a.sink.dst, name)
+	if err != nil {
+		return err
+	}
+	logging("Removing whiteout path: %s", dstPath)
+	return os.RemoveAll(dstPath)
+}
+
+// opaque removes the content of dir that lower layers wrote.
+func (a *layerApplier) opaque(dir string) error {
+	// the path of the marker, so that dir itself can't be a symlink either
+	markerPath, err := securePath(a.sink.dst, path.Join(dir, whiteoutOpaque))
+	if err != nil {
+		return err
+	}
+	dstPath := filepath.Dir(markerPath)
+	children, err := os.ReadDir(dstPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, child := range children {
+		if a.written[path.Join(dir, child.Name())] {
+			continue
+		}
+		logging("Removing lower content of opaque directory: %s", filepath.Join(dstPath, child.Name()))
+		if err := os.RemoveAll(filepath.Join(dstPath, child.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package arc
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Option configures optional behavior of the archive operations.
+type Option func(*options)
+
+// options holds the settings collected from the Option values passed in.
+type options struct {
+	// when set, extracted files are materialized as links into this cache
+	cacheDir string
+	linkMode LinkMode
+
+	// handling of files already present in the destination of Unarchive
+	overwrite       OverwritePolicy
+	overwritePrompt func(path string) (bool, error)
+	// replacing files in use by other processes, see WithOnLocked
+	onLocked LockedPolicy
+
+	// restoring symlinks, mtimes and xattrs, see WithPreserve
+	preserve        bool
+	strict          bool
+	warn            func(err error)
+	symlinkFallback SymlinkFallback
+
+	// leading path elements removed from extracted entries, then renaming
+	stripComponents int
+	rename          RenameFunc
+	// levels of archives within archives to extract, see WithRecursive
+	recursive int
+	// remove what the archive doesn't have from the destination, see WithSync
+	sync bool
+
+	// random subset of the files to extract, see WithSample
+	sample        bool
+	samplePercent float64
+	sampleSeed    uint64
+
+	// SHA-256 manifest generation and verification
+	manifest       bool
+	manifestFile   string
+	verifyManifest bool
+
+	// encrypts zip archives on creation and decrypts them on extraction
+	password string
+
+	// encryption layer around the whole archive
+	recipients []string
+	identities []string
+
+	// chunked downloads of remote archives
+	connections int
+	chunkSize   int64
+	attempts    int
+
+	// network settings of remote operations
+	proxy    string
+	caFile   string
+	certFile string
+	keyFile  string
+	headers  http.Header
+	timeout  time.Duration
+	checksum string
+
+	// what extraction accepts, see WithLimits
+	limits Limits
+
+	// maximum size of each volume of a split archive
+	splitSize int64
+
+	// stages the archive stream goes through, see WithPipeline
+	pipeline *Pipeline
+
+	// stream the archive is written to instead of a file, see WithOutputWriter
+	output io.Writer
+
+	// ratios observed by earlier estimates, see WithEstimateModel
+	estimateModel *EstimateModel
+
+	// cancellation of the operation, see WithContext and WithCancelGrace
+	ctx         context.Context
+	cancelGrace time.Duration
+
+	// reports progress of archiving and extraction, see WithProgress
+	progress func(ProgressEvent)
+
+	// more files and directories to archive, see WithSources, and the
+	// directory relative ones are in, see WithDirectory
+	sources   []string
+	directory string
+	// exact paths to archive instead, see WithFileList
+	fileList []string
+	// skip what .gitignore and .arcignore files match, see WithIgnoreFiles
+	ignoreFiles bool
+
+	// reproducible output, see WithDeterministic
+	deterministic bool
+
+	// compressor reset points for rsync, see WithRsyncable
+	rsyncable bool
+
+	// zstd frames with a seek table, see WithSeekable
+	seekable bool
+
+	// deflate with the zopfli encoder, see WithZopfli
+	zopfli bool
+
+	// codecs of the files by extension, see WithRoutes
+	routes []Route
+
+	// content of entries read before, see WithEntryCache
+	entryCache *EntryCache
+
+	// directories written to, see WithAllowedRoots
+	allowedRoots []string
+
+	// SHA-256 of the archive appended to it, see WithChecksumTrailer
+	trailer bool
+
+	// comments and extra fields of zip entries, see WithZipMeta
+	zipMeta func(f archives.FileInfo) (ZipEntryMeta, bool)
+
+	// deflate level and stored extensions of zip entries, see WithZipLevel
+	// and WithZipStore
+	zipLevel    int
+	zipLevelSet bool
+	zipStore    []string
+	// use of the Zip64 extensions, see WithZip64
+	zip64 Zip64Mode
+	// directory or archive the layer of ArchiveOCILayer is compared to
+	layerBase string
+	// header format and extended attributes of tar entries, see
+	// WithTarFormat and WithXattrs
+	tarFormat tar.Format
+	xattrs    bool
+}
+
+// newOptions applies opts on top of the default settings.
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
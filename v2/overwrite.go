@@ -0,0 +1,155 @@
+package arc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// OverwritePolicy decides what Unarchive does with files that already exist
+// in the destination.
+type OverwritePolicy int
+
+const (
+	// Overwrite replaces existing files, the default.
+	Overwrite OverwritePolicy = iota
+	// SkipExisting keeps existing files and doesn't extract their entries.
+	SkipExisting
+	// ErrorIfExists aborts the extraction at the first existing file, with
+	// an error wrapping fs.ErrExist. Files extracted before are kept.
+	ErrorIfExists
+	// KeepBoth extracts to a new name with a numbered suffix, like
+	// "app (1).conf", leaving the existing file alone.
+	KeepBoth
+	// NewerOnly replaces existing files only with entries whose mtime is
+	// more recent than theirs.
+	NewerOnly
+	// Prompt asks the function of WithOverwritePrompt whether to replace
+	// each existing file.
+	Prompt
+)
+
+var overwritePolicyNames = map[OverwritePolicy]string{
+	Overwrite:     "overwrite",
+	SkipExisting:  "skip-existing",
+	ErrorIfExists: "error-if-exists",
+	KeepBoth:      "keep-both",
+	NewerOnly:     "newer-only",
+	Prompt:        "prompt",
+}
+
+func (p OverwritePolicy) String() string {
+	if name, ok := overwritePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ParseOverwritePolicy returns the policy named name: overwrite,
+// skip-existing, error-if-exists, keep-both, newer-only or prompt.
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	for policy, policyName := range overwritePolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return Overwrite, fmt.Errorf("unknown overwrite policy %q", name)
+}
+
+// WithOverwrite sets how Unarchive handles files that already exist in the
+// destination, see OverwritePolicy. It applies to regular files only,
+// existing directories are always merged into.
+func WithOverwrite(policy OverwritePolicy) Option {
+	return func(o *options) {
+		o.overwrite = policy
+	}
+}
+
+// WithOverwritePrompt sets the Prompt policy, prompt is called with the
+// path of each existing file and returns whether to replace it. Answers
+// like "all" and "none" are for prompt to remember; an error aborts the
+// extraction.
+func WithOverwritePrompt(prompt func(path string) (bool, error)) Option {
+	return func(o *options) {
+		o.overwrite = Prompt
+		o.overwritePrompt = prompt
+	}
+}
+
+// applyOverwrite checks the entry f against the file it would be extracted
+// to, and returns the entry to extract, renamed with KeepBoth, or false to
+// skip it.
+func (d *dirSink) applyOverwrite(f archives.FileInfo) (archives.FileInfo, bool, error) {
+	if d.o.overwrite == Overwrite || !f.Mode().IsRegular() || f.LinkTarget != "" {
+		return f, true, nil
+	}
+	dstPath, pathErr := securePath(d.dst, f.NameInArchive)
+	if pathErr != nil {
+		return f, false, pathErr
+	}
+	existing, statErr := os.Lstat(dstPath)
+	if os.IsNotExist(statErr) {
+		return f, true, nil
+	}
+	if statErr != nil {
+		return f, false, fmt.Errorf("stat existing file: %w", statErr)
+	}
+
+	switch d.o.overwrite {
+	case SkipExisting:
+		logging("Skipping existing file: %s", dstPath)
+		return f, false, nil
+	case ErrorIfExists:
+		return f, false, fmt.Errorf("%s: %w", dstPath, fs.ErrExist)
+	case NewerOnly:
+		if !f.ModTime().After(existing.ModTime()) {
+			logging("Skipping file not newer than existing one: %s", dstPath)
+			return f, false, nil
+		}
+		return f, true, nil
+	case KeepBoth:
+		name, nameErr := d.freeName(f.NameInArchive)
+		if nameErr != nil {
+			return f, false, nameErr
+		}
+		logging("Keeping existing file %s, extracting to %s", dstPath, name)
+		f.NameInArchive = name
+		return f, true, nil
+	case Prompt:
+		if d.o.overwritePrompt == nil {
+			return f, false, fmt.Errorf("%s: %w, and no prompt to ask whether to replace it", dstPath, fs.ErrExist)
+		}
+		replace, promptErr := d.o.overwritePrompt(dstPath)
+		if promptErr != nil {
+			return f, false, fmt.Errorf("overwrite prompt: %w", promptErr)
+		}
+		if !replace {
+			logging("Keeping existing file: %s", dstPath)
+		}
+		return f, replace, nil
+	}
+	return f, false, fmt.Errorf("unknown overwrite policy %v", d.o.overwrite)
+}
+
+// freeName returns name with the first numbered suffix that doesn't exist in
+// the destination, e.g. "etc/app (2).conf" for "etc/app.conf".
+func (d *dirSink) freeName(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s%s (%d)%s", dir, stem, i, ext)
+		dstPath, pathErr := securePath(d.dst, candidate)
+		if pathErr != nil {
+			return "", pathErr
+		}
+		if _, statErr := os.Lstat(dstPath); os.IsNotExist(statErr) {
+			return candidate, nil
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ErrNotPackage is returned by ExtractPackage for files that are neither a
+// .deb nor an .rpm package.
+var ErrNotPackage = errors.New("not a .deb or .rpm package")
+
+// Magic numbers of rpm packages: of the lead, the obsolete header they
+// start with, and of the headers that follow it.
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// rpmLeadSize is the size of the lead of rpm packages.
+const rpmLeadSize = 96
+
+// debFirstMember is the name of the first member of a .deb, which tells it
+// from other ar archives.
+const debFirstMember = "debian-binary"
+
+// IsPackage reports whether the file pkg is a .deb or .rpm package, by its
+// content, see ExtractPackage.
+// pkg: the file to check
+func IsPackage(pkg string) bool {
+	file, _, err := openArchive(pkg)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return packageKind(bufio.NewReader(file)) != ""
+}
+
+// ExtractPackage extracts the files the .deb or .rpm package pkg installs to
+// dst, rather than the members of the package: the data tarball of a .deb,
+// an ar archive next to its control tarball, and the cpio payload of an
+// .rpm, after its lead and headers. Options apply to the payload as with
+// Unarchive, except WithVerifyManifest. Files that are no package are
+// ErrNotPackage.
+// pkg: the package to extract
+// dst: the destination directory
+// opts: optional settings, see Option
+func ExtractPackage(pkg, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	file, _, err := openArchive(pkg)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	switch packageKind(r) {
+	case "deb":
+		logging("Extracting the data tarball of %s to %s", pkg, dst)
+		return extractDeb(r, dst, o)
+	case "rpm":
+		logging("Extracting the payload of %s to %s", pkg, dst)
+		return extractRPM(r, dst, o)
+	}
+	return fmt.Errorf("%s: %w", pkg, ErrNotPackage)
+}
+
+// packageKind returns "deb" or "rpm" for the package r starts with, empty
+// for other files. Nothing is consumed.
+func packageKind(r *bufio.Reader) string {
+	head, _ := r.Peek(len(arMagic) + len(debFirstMember))
+	switch {
+	case bytes.HasPrefix(head, rpmLeadMagic):
+		return "rpm"
+	case bytes.HasPrefix(head, arMagic) && bytes.HasSuffix(head, []byte(debFirstMember)):
+		return "deb"
+	}
+	return ""
+}
+
+// extractDeb extracts the data tarball of the .deb read from r to dst.
+func extractDeb(r io.Reader, dst string, o *options) error {
+	found := false
+	err := Ar{}.Extract(o.context(), r, func(ctx context.Context, f archives.FileInfo) error {
+		if !strings.HasPrefix(f.NameInArchive, "data.tar") {
+			return nil
+		}
+		found = true
+		member, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer member.Close()
+		if err := unarchiveStream(f.NameInArchive, member, dst, o); err != nil {
+			return err
+		}
+		return fs.SkipAll
+	})
+	if err == nil && !found {
+		return errors.New("package has no data tarball")
+	}
+	return err
+}
+
+// extractRPM extracts the cpio payload of the rpm read from r to dst.
+func extractRPM(r *bufio.Reader, dst string, o *options) error {
+	if _, err := r.Discard(rpmLeadSize); err != nil {
+		return fmt.Errorf("truncated rpm lead: %w", err)
+	}
+	// the signature header is padded to 8 bytes, the header after it isn't
+	if err := skipRPMHeader(r, true); err != nil {
+		return fmt.Errorf("rpm signature: %w", err)
+	}
+	if err := skipRPMHeader(r, false); err != nil {
+		return fmt.Errorf("rpm header: %w", err)
+	}
+	return unarchiveStream("", r, dst, o)
+}
+
+// skipRPMHeader reads past the header structure at the start of r: its
+// magic and counts, its index entries and their data.
+func skipRPMHeader(r io.Reader, padded bool) error {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return fmt.Errorf("truncated header: %w", err)
+	}
+	if !bytes.HasPrefix(intro, rpmHeaderMagic) {
+		return errors.New("malformed header")
+	}
+	size := 16*int64(binary.BigEndian.Uint32(intro[8:])) + int64(binary.BigEndian.Uint32(intro[12:]))
+	if padded {
+		size += (8 - (int64(len(intro))+size)%8) % 8
+	}
+	if _, err := io.CopyN(io.Discard, r, size); err != nil {
+		return fmt.Errorf("truncated header: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Stage is a step of a Pipeline, see CompressStage, EncryptStage and
+// SplitStage.
+type Stage struct {
+	name string
+	ext  string
+	// filter returns a writer processing what it is given into w, closing it
+	// finishes the processing without closing w
+	filter func(w io.Writer) (io.WriteCloser, error)
+	// splitSize makes the stage the sink writing volumes, see SplitStage
+	splitSize int64
+	// error of the arguments, reported by NewPipeline
+	err error
+}
+
+// CompressStage is a Stage compressing the stream with the compression name,
+// a registered name or a fallback list like "zst,gz", see
+// SelectCompression.
+func CompressStage(name string) Stage {
+	choice, err := SelectCompression(name)
+	if err != nil {
+		return Stage{name: "compress", err: err}
+	}
+	return Stage{
+		name:   "compress " + choice.Name,
+		ext:    choice.Compression.Extension(),
+		filter: choice.Compression.OpenWriter,
+	}
+}
+
+// EncryptStage is a Stage encrypting the stream for the recipients, like
+// WithEncryption.
+func EncryptStage(recipients ...string) Stage {
+	_, pgpKeys, err := parseRecipients(recipients)
+	if err != nil {
+		return Stage{name: "encrypt", err: err}
+	}
+	ext := ".age"
+	if len(pgpKeys) > 0 {
+		ext = ".gpg"
+	}
+	o := &options{recipients: recipients}
+	return Stage{
+		name: "encrypt",
+		ext:  ext,
+		filter: func(w io.Writer) (io.WriteCloser, error) {
+			return encryptWriter(w, o)
+		},
+	}
+}
+
+// SplitStage is a Stage writing volumes of at most size bytes, like
+// WithSplitSize. It has to be the last stage.
+func SplitStage(size int64) Stage {
+	if size < 1 {
+		return Stage{name: "split", err: fmt.Errorf("invalid split size %d", size)}
+	}
+	return Stage{name: "split", splitSize: size}
+}
+
+// Pipeline is a chain of processors the archive stream goes through on its
+// way to the output file, in order, like
+//
+//	NewPipeline(CompressStage("zst"), EncryptStage(recipient), SplitStage(100<<20))
+//
+// to express combinations of compression, encryption and splitting
+// uniformly. Pass it to the archive functions with WithPipeline, or write
+// through it directly with Create.
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline chains stages, reporting the errors of their arguments.
+func NewPipeline(stages ...Stage) (*Pipeline, error) {
+	var errs []error
+	for i, stage := range stages {
+		if stage.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", stage.name, stage.err))
+		}
+		if stage.splitSize > 0 && i != len(stages)-1 {
+			errs = append(errs, errors.New("split has to be the last stage of a pipeline"))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	return &Pipeline{stages: stages}, nil
+}
+
+// WithPipeline writes the archive through the stages of p, after the
+// compression given to the archive function, if any. It replaces
+// WithEncryption and WithSplitSize, which can't be combined with it; the
+// trailer of WithChecksumTrailer covers the output of the last stage.
+func WithPipeline(p *Pipeline) Option {
+	return func(o *options) {
+		o.pipeline = p
+	}
+}
+
+// String describes the stages of p, like "compress zst | encrypt | split".
+func (p *Pipeline) String() string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.name
+	}
+	return strings.Join(names, " | ")
+}
+
+// Extension returns the file extensions the stages add, like ".zst.age",
+// to name the output after.
+func (p *Pipeline) Extension() string {
+	var ext strings.Builder
+	for _, stage := range p.stages {
+		ext.WriteString(stage.ext)
+	}
+	return ext.String()
+}
+
+// splitSize returns the volume size of the SplitStage, or 0.
+func (p *Pipeline) splitSize() int64 {
+	if p == nil || len(p.stages) == 0 {
+		return 0
+	}
+	return p.stages[len(p.stages)-1].splitSize
+}
+
+// Create creates outfile, or its volumes, and returns a writer passing what
+// is written to it through the stages. Closing it finishes every stage and
+// closes the file.
+// outfile: the output file
+func (p *Pipeline) Create(outfile string) (io.WriteCloser, error) {
+	var sink io.WriteCloser
+	var err error
+	if size := p.splitSize(); size > 0 {
+		sink, err = createSplitWriter(outfile, size)
+	} else {
+		sink, err = os.Create(outfile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pw, err := p.chain(sink)
+	if err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Writer returns a writer passing what is written to it through the stages
+// into w. Closing it finishes every stage, w isn't closed. Pipelines ending
+// with SplitStage can't write to a stream.
+// w: the output stream
+func (p *Pipeline) Writer(w io.Writer) (io.WriteCloser, error) {
+	if p.splitSize() > 0 {
+		return nil, errors.New("split archives can't be written to a stream")
+	}
+	pw, err := p.chain(nopWriteCloser{w})
+	if err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// chain wraps sink in the filters of the stages, the first stage outermost.
+func (p *Pipeline) chain(sink io.WriteCloser) (*pipelineWriter, error) {
+	pw := &pipelineWriter{Writer: sink, sink: sink}
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		if p.stages[i].filter == nil {
+			continue
+		}
+		filter, err := p.stages[i].filter(pw.Writer)
+		if err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("%s: %w", p.stages[i].name, err)
+		}
+		pw.Writer = filter
+		pw.filters = append([]io.WriteCloser{filter}, pw.filters...)
+	}
+	return pw, nil
+}
+
+// pipelineWriter writes into the first filter of a Pipeline.
+type pipelineWriter struct {
+	io.Writer
+	// filters from the first stage to the last, and the output they end in
+	filters  []io.WriteCloser
+	sink     io.WriteCloser
+	finished bool
+}
+
+// finish flushes the filters in order, so that their errors are reported
+// before the output is closed, and writes the checksum trailer of the sink.
+func (pw *pipelineWriter) finish() error {
+	if pw.finished {
+		return nil
+	}
+	pw.finished = true
+	for _, filter := range pw.filters {
+		if err := filter.Close(); err != nil {
+			return err
+		}
+	}
+	return finishTrailer(pw.sink)
+}
+
+func (pw *pipelineWriter) Close() error {
+	return errors.Join(pw.finish(), pw.sink.Close())
+}
+
+// finishPipeline finishes output if it was created with WithPipeline, see
+// pipelineWriter.finish.
+func finishPipeline(output io.Writer) error {
+	if pw, ok := output.(*pipelineWriter); ok {
+		return pw.finish()
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// PlanAction is what Unarchive would do with an entry, see PlanUnarchive.
+type PlanAction string
+
+const (
+	// PlanCreate writes a file or directory that doesn't exist yet
+	PlanCreate PlanAction = "create"
+	// PlanOverwrite replaces an existing file
+	PlanOverwrite PlanAction = "overwrite"
+	// PlanExists merges into an existing directory
+	PlanExists PlanAction = "exists"
+	// PlanRename writes next to an existing file, with KeepBoth
+	PlanRename PlanAction = "rename"
+	// PlanSkip leaves the entry out: by the overwrite policy, or because it
+	// is a hardlink, or a symlink without WithPreserve
+	PlanSkip PlanAction = "skip"
+	// PlanFail aborts the extraction, with ErrorIfExists
+	PlanFail PlanAction = "fail"
+	// PlanAsk replaces an existing file if the prompt says so, with Prompt
+	PlanAsk PlanAction = "ask"
+	// PlanDelete removes a path the archive has no entry for, with WithSync
+	PlanDelete PlanAction = "delete"
+)
+
+// PlannedEntry is an entry of the archive and what extracting it would do.
+type PlannedEntry struct {
+	// Name is the path of the entry in the archive, empty for PlanDelete
+	Name string
+	// Path is where it would be extracted to, after WithStripComponents,
+	// WithRename and KeepBoth
+	Path   string
+	Action PlanAction
+}
+
+// PlanUnarchive reports what Unarchive would do with each entry of archive
+// given the same dst and options, without writing anything, to preview
+// destructive extractions. Entries WithStripComponents, WithRename or
+// WithSample leave out aren't listed. Planning stops at the first PlanFail
+// entry, where the extraction would. With WithSync, the paths to remove
+// follow the entries.
+// archive: the archive to extract
+// dst: the destination directory
+// opts: optional settings, see Option
+func PlanUnarchive(archive, dst string, opts ...Option) ([]PlannedEntry, error) {
+	o := newOptions(opts)
+	logging("Planning the extraction of %s to %s", archive, dst)
+	if err := o.checkDestination(dst, o.cacheDir); err != nil {
+		return nil, err
+	}
+	sink := &dirSink{dst: dst, o: o}
+
+	var plan []PlannedEntry
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		name := f.NameInArchive
+		f, ok := rewriteEntry(f, o)
+		if !ok {
+			return nil
+		}
+		dstPath, pathErr := securePath(dst, f.NameInArchive)
+		if pathErr != nil {
+			return pathErr
+		}
+		entry := PlannedEntry{Name: name, Path: dstPath}
+		sink.keep(f.NameInArchive)
+		if m := sink.mirrored(); m != nil && o.recursive > 0 && isNestedArchive(dstPath) {
+			m.keepTree(nestedDir(dstPath))
+		}
+		_, statErr := os.Lstat(dstPath)
+		exists := statErr == nil
+
+		switch {
+		case f.Mode()&fs.ModeSymlink != 0 && !o.preserve, f.Mode()&fs.ModeSymlink == 0 && f.LinkTarget != "":
+			entry.Action = PlanSkip
+		case f.IsDir() && exists:
+			entry.Action = PlanExists
+		case f.IsDir():
+			entry.Action = PlanCreate
+		case exists && o.overwrite == Prompt && f.Mode().IsRegular():
+			entry.Action = PlanAsk
+		default:
+			renamed, extract, policyErr := sink.applyOverwrite(f)
+			switch {
+			case errors.Is(policyErr, fs.ErrExist):
+				entry.Action = PlanFail
+			case policyErr != nil:
+				return policyErr
+			case !extract:
+				entry.Action = PlanSkip
+			case renamed.NameInArchive != f.NameInArchive:
+				entry.Action = PlanRename
+				sink.keep(renamed.NameInArchive)
+				if entry.Path, pathErr = securePath(dst, renamed.NameInArchive); pathErr != nil {
+					return pathErr
+				}
+			case exists:
+				entry.Action = PlanOverwrite
+			default:
+				entry.Action = PlanCreate
+			}
+		}
+		plan = append(plan, entry)
+		if entry.Action == PlanFail {
+			return fs.SkipAll
+		}
+		return nil
+	}
+	if err := extractArchive(archive, handler, o); err != nil && !errors.Is(err, fs.SkipAll) {
+		return plan, fmt.Errorf("planning extraction: %w", err)
+	}
+	if m := sink.mirrored(); m != nil && (len(plan) == 0 || plan[len(plan)-1].Action != PlanFail) {
+		stale, err := m.stale()
+		if err != nil {
+			return plan, fmt.Errorf("planning extraction: %w", err)
+		}
+		for _, p := range stale {
+			plan = append(plan, PlannedEntry{Path: p, Action: PlanDelete})
+		}
+	}
+	return plan, nil
+}
+
+// DiffStatus is how an entry of an archive compares to the destination, see
+// DiffDestination.
+type DiffStatus string
+
+const (
+	// DiffNew is an entry that doesn't exist in the destination yet
+	DiffNew DiffStatus = "new"
+	// DiffChanged is an entry whose content, link target or type differs
+	// from the destination
+	DiffChanged DiffStatus = "changed"
+	// DiffIdentical is an entry the destination already has
+	DiffIdentical DiffStatus = "identical"
+)
+
+// DestinationDiff is an entry of the archive compared to the destination.
+type DestinationDiff struct {
+	// Name is the path of the entry in the archive
+	Name string
+	// Path is where it would be extracted to, after WithStripComponents and
+	// WithRename
+	Path   string
+	Status DiffStatus
+}
+
+// DiffDestination compares the entries of archive with what is at dst, to
+// preview which files an extraction would change, whatever the overwrite
+// policy. Regular files are compared by SHA-256 when their sizes match,
+// symlinks by target. Hardlinks aren't listed, nor entries
+// WithStripComponents, WithRename or WithSample leave out. Nothing is
+// written.
+// archive: the archive to compare
+// dst: the destination directory
+// opts: optional settings, see Option
+func DiffDestination(archive, dst string, opts ...Option) ([]DestinationDiff, error) {
+	o := newOptions(opts)
+	logging("Comparing %s with %s", archive, dst)
+
+	var diff []DestinationDiff
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		name := f.NameInArchive
+		f, ok := rewriteEntry(f, o)
+		if !ok || (f.Mode()&fs.ModeSymlink == 0 && f.LinkTarget != "") {
+			return nil
+		}
+		dstPath, pathErr := securePath(dst, f.NameInArchive)
+		if pathErr != nil {
+			return pathErr
+		}
+		status, err := compareEntry(f, dstPath)
+		if err != nil {
+			return err
+		}
+		diff = append(diff, DestinationDiff{Name: name, Path: dstPath, Status: status})
+		return nil
+	}
+	if err := extractArchive(archive, handler, o); err != nil {
+		return diff, fmt.Errorf("comparing with destination: %w", err)
+	}
+	return diff, nil
+}
+
+// compareEntry compares the entry f with dstPath.
+func compareEntry(f archives.FileInfo, dstPath string) (DiffStatus, error) {
+	info, statErr := os.Lstat(dstPath)
+	if errors.Is(statErr, fs.ErrNotExist) {
+		return DiffNew, nil
+	}
+	if statErr != nil {
+		return "", statErr
+	}
+	if f.Mode().Type() != info.Mode().Type() {
+		return DiffChanged, nil
+	}
+
+	switch {
+	case f.IsDir():
+		return DiffIdentical, nil
+	case f.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(dstPath)
+		if err != nil {
+			return "", err
+		}
+		if target != f.LinkTarget {
+			return DiffChanged, nil
+		}
+		return DiffIdentical, nil
+	case !f.Mode().IsRegular() || f.Size() != info.Size():
+		return DiffChanged, nil
+	}
+
+	entrySum, err := hashEntry(f)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(dstPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read %s: %w", dstPath, err)
+	}
+	if !bytes.Equal(entrySum, hash.Sum(nil)) {
+		return DiffChanged, nil
+	}
+	return DiffIdentical, nil
+}
+
+// hashEntry returns the SHA-256 of the content of the entry f.
+func hashEntry(f archives.FileInfo) ([]byte, error) {
+	reader, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", f.NameInArchive, err)
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.NameInArchive, err)
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,244 @@
+package arc
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// paxXattrPrefix starts the PAX records holding extended attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// Capabilities are the optional features of the file system entries are
+// extracted to. Entries needing a missing feature are extracted without it.
+type Capabilities struct {
+	// Symlinks can be created, Windows requires a privilege or developer mode
+	Symlinks bool
+	// Xattrs, extended attributes, can be set on files
+	Xattrs bool
+	// SubsecondTimes are kept, FAT and some network file systems round
+	// modification times to seconds
+	SubsecondTimes bool
+}
+
+// DetectCapabilities probes which optional features the file system of dir
+// supports, by trying them on files in a temporary directory under dir.
+func DetectCapabilities(dir string) (Capabilities, error) {
+	var caps Capabilities
+	probeDir, err := os.MkdirTemp(dir, ".arc-probe-")
+	if err != nil {
+		return caps, fmt.Errorf("create probe directory: %w", err)
+	}
+	defer os.RemoveAll(probeDir)
+
+	probe := filepath.Join(probeDir, "file")
+	if err := os.WriteFile(probe, nil, filePermissions); err != nil {
+		return caps, fmt.Errorf("create probe file: %w", err)
+	}
+	caps.Symlinks = os.Symlink("file", filepath.Join(probeDir, "link")) == nil
+	caps.Xattrs = setXattr(probe, "user.arc.probe", []byte("1")) == nil
+
+	mtime := time.Unix(1e9, 123456789)
+	if err := os.Chtimes(probe, mtime, mtime); err == nil {
+		if info, statErr := os.Stat(probe); statErr == nil {
+			caps.SubsecondTimes = info.ModTime().Nanosecond() != 0
+		}
+	}
+	logging("Capabilities of %s: %+v", dir, caps)
+	return caps, nil
+}
+
+// WithPreserve makes Unarchive restore symlinks, modification times and the
+// extended attributes of tar archives. Features the destination doesn't
+// support are skipped with a warning, see WithStrict and WithWarnings, or
+// WithSymlinkFallback for symlinks.
+// Symlinks pointing outside of the destination are never created.
+func WithPreserve() Option {
+	return func(o *options) {
+		o.preserve = true
+	}
+}
+
+// WithStrict makes Unarchive fail instead of warning when metadata can't be
+// restored, see WithPreserve.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithWarnings calls warn with the problems Unarchive works around, like
+// metadata the destination doesn't support. They wrap errors.ErrUnsupported
+// when a feature is missing. Warnings are only logged by default.
+func WithWarnings(warn func(err error)) Option {
+	return func(o *options) {
+		o.warn = warn
+	}
+}
+
+// warnf reports a problem the extraction works around, or returns it as an
+// error in strict mode. Each kind of problem is reported once.
+func (d *dirSink) warnf(kind, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	if d.o.strict {
+		return err
+	}
+	if d.warned[kind] {
+		return nil
+	}
+	if d.warned == nil {
+		d.warned = make(map[string]bool)
+	}
+	d.warned[kind] = true
+	logging("Warning: %v", err)
+	if d.o.warn != nil {
+		d.o.warn(err)
+	}
+	return nil
+}
+
+// detectCapabilities probes the destination if metadata is to be restored.
+func (d *dirSink) detectCapabilities() error {
+	if !d.o.preserve {
+		return nil
+	}
+	caps, err := DetectCapabilities(d.dst)
+	if err != nil {
+		return err
+	}
+	d.caps = caps
+	return nil
+}
+
+// symlink creates the symlink name if WithPreserve is set and the target
+// stays inside the destination.
+func (d *dirSink) symlink(name, target string) error {
+	if !d.o.preserve {
+		logging("Skipping symlink: %s -> %s", name, target)
+		return nil
+	}
+	dstPath, pathErr := securePath(d.dst, name)
+	if pathErr != nil {
+		return pathErr
+	}
+	// the target is resolved against the links already extracted, which a
+	// textual check would miss, like a/b/c -> .. where a/b -> ..
+	resolved, resolveErr := resolveLink(d.dst, filepath.Dir(dstPath), target)
+	if filepath.IsAbs(filepath.FromSlash(target)) || resolveErr != nil {
+		return d.warnf("symlink target", "skipping symlink %s: target %s is outside of the destination", name, target)
+	}
+	if !d.caps.Symlinks {
+		if d.o.symlinkFallback == SymlinkSkip {
+			return d.warnf("symlink", "skipping symlink %s: symlinks are not supported in %s: %w", name, d.dst, errors.ErrUnsupported)
+		}
+		d.pendingLinks = append(d.pendingLinks, pendingLink{name: name, dstPath: dstPath, target: resolved})
+		return nil
+	}
+
+	if dirErr := createDirWithPermissions(filepath.Dir(dstPath), dirPermissions); dirErr != nil {
+		return dirErr
+	}
+	if existing, statErr := os.Lstat(dstPath); statErr == nil && !existing.IsDir() {
+		if removeErr := os.Remove(dstPath); removeErr != nil {
+			return fmt.Errorf("replace %s: %w", dstPath, removeErr)
+		}
+	}
+	logging("Creating symlink: %s -> %s", dstPath, target)
+	if err := os.Symlink(target, dstPath); err != nil {
+		return fmt.Errorf("symlink: %w", err)
+	}
+	return nil
+}
+
+// restoreMetadata sets the modification time and extended attributes of
+// the extracted entry f. Times of directories are set by finish, once their
+// content is written.
+func (d *dirSink) restoreMetadata(f archives.FileInfo) error {
+	if !d.o.preserve || f.Mode()&os.ModeSymlink != 0 || f.LinkTarget != "" {
+		return nil
+	}
+	dstPath, pathErr := securePath(d.dst, f.NameInArchive)
+	if pathErr != nil {
+		return pathErr
+	}
+	// files of a link farm are shared, their metadata belongs to the cache
+	if d.o.cacheDir != "" && !f.IsDir() {
+		return nil
+	}
+
+	if hdr, ok := f.Header.(*tar.Header); ok {
+		for key, value := range hdr.PAXRecords {
+			attr, isXattr := strings.CutPrefix(key, paxXattrPrefix)
+			if !isXattr {
+				continue
+			}
+			if !d.caps.Xattrs {
+				if err := d.warnf("xattr", "skipping extended attributes of %s: not supported in %s: %w", f.NameInArchive, d.dst, errors.ErrUnsupported); err != nil {
+					return err
+				}
+				break
+			}
+			if err := setXattr(dstPath, attr, []byte(value)); err != nil {
+				if err := d.warnf("xattr "+attr, "setting extended attribute %s of %s: %v", attr, f.NameInArchive, err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	mtime := f.ModTime()
+	if mtime.Nanosecond() != 0 && !d.caps.SubsecondTimes {
+		if err := d.warnf("subsecond", "rounding modification times to seconds: not supported in %s: %w", d.dst, errors.ErrUnsupported); err != nil {
+			return err
+		}
+	}
+	if f.IsDir() {
+		d.dirTimes = append(d.dirTimes, dirTime{path: dstPath, mtime: mtime})
+		return nil
+	}
+	if err := os.Chtimes(dstPath, time.Time{}, mtime); err != nil {
+		return d.warnf("mtime", "setting modification time of %s: %v", f.NameInArchive, err)
+	}
+	return nil
+}
+
+// dirTime is the modification time of an extracted directory.
+type dirTime struct {
+	path  string
+	mtime time.Time
+}
+
+// finish materializes the symlinks left to a fallback, extracts the nested
+// archives of WithRecursive and removes what WithSync doesn't keep, then sets
+// the modification times of the extracted directories, the deepest first,
+// once nothing is written into them anymore.
+func (d *dirSink) finish() error {
+	if err := d.linkFallbacks(); err != nil {
+		return err
+	}
+	if err := d.extractNested(); err != nil {
+		return err
+	}
+	if err := d.prune(); err != nil {
+		return err
+	}
+	sort.SliceStable(d.dirTimes, func(i, j int) bool {
+		return strings.Count(d.dirTimes[i].path, string(os.PathSeparator)) > strings.Count(d.dirTimes[j].path, string(os.PathSeparator))
+	})
+	for _, dt := range d.dirTimes {
+		if err := os.Chtimes(dt.path, time.Time{}, dt.mtime); err != nil {
+			if err := d.warnf("mtime", "setting modification time of %s: %v", dt.path, err); err != nil {
+				return err
+			}
+		}
+	}
+	d.dirTimes = nil
+	return nil
+}
@@ -0,0 +1,224 @@
+package arc
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ProgressKind is what a ProgressEvent reports.
+type ProgressKind string
+
+const (
+	// ProgressEntryStarted is sent before the content of an entry is read
+	ProgressEntryStarted ProgressKind = "entry_started"
+	// ProgressBytes is sent while large entries are read, at most every
+	// progressInterval
+	ProgressBytes ProgressKind = "bytes"
+	// ProgressEntryFinished is sent once an entry has been written
+	ProgressEntryFinished ProgressKind = "entry_finished"
+	// ProgressEntryFailed is sent when an entry can't be read or written,
+	// with the error in Err
+	ProgressEntryFailed ProgressKind = "entry_failed"
+	// ProgressDone is sent once the whole operation succeeded
+	ProgressDone ProgressKind = "done"
+)
+
+// progressInterval is the minimum time between two ProgressBytes events.
+const progressInterval = 250 * time.Millisecond
+
+// ProgressEvent is the state of an archive being created or extracted.
+// Totals are only known when creating archives, where just regular files are
+// counted as entries.
+type ProgressEvent struct {
+	Kind ProgressKind
+	// Name and Size of the entry the event is about, empty for ProgressDone
+	Name string
+	Size int64
+	// content bytes and entries processed so far, and their totals if known
+	Bytes        int64
+	TotalBytes   int64
+	Entries      int
+	TotalEntries int
+	// time since the operation started
+	Elapsed time.Duration
+	// Err is why the entry failed, for ProgressEntryFailed
+	Err error
+}
+
+// ETA estimates the time until the operation is finished from the rate so
+// far, it is 0 when the total isn't known.
+func (e ProgressEvent) ETA() time.Duration {
+	if e.TotalBytes <= 0 || e.Bytes <= 0 || e.Bytes >= e.TotalBytes {
+		return 0
+	}
+	return time.Duration(float64(e.Elapsed) * float64(e.TotalBytes-e.Bytes) / float64(e.Bytes))
+}
+
+// WithProgress calls report as entries are archived or extracted, for
+// progress bars and tools wrapping arc. It is called from the goroutine
+// doing the work, and should return quickly.
+func WithProgress(report func(ProgressEvent)) Option {
+	return func(o *options) {
+		o.progress = report
+	}
+}
+
+// progressTracker counts what an operation has processed and reports it.
+// Its methods do nothing on a nil tracker, which is used without
+// WithProgress.
+type progressTracker struct {
+	report func(ProgressEvent)
+	start  time.Time
+
+	mu           sync.Mutex
+	bytes        int64
+	totalBytes   int64
+	entries      int
+	totalEntries int
+	lastBytes    time.Time
+}
+
+// newProgress returns a tracker for a new operation, or nil without
+// WithProgress.
+func newProgress(o *options) *progressTracker {
+	if o.progress == nil {
+		return nil
+	}
+	return &progressTracker{report: o.progress, start: time.Now()}
+}
+
+// emit reports an event about the entry name, p.mu must be held.
+func (p *progressTracker) emit(kind ProgressKind, name string, size int64, err error) {
+	p.report(ProgressEvent{
+		Kind:         kind,
+		Name:         name,
+		Size:         size,
+		Bytes:        p.bytes,
+		TotalBytes:   p.totalBytes,
+		Entries:      p.entries,
+		TotalEntries: p.totalEntries,
+		Elapsed:      time.Since(p.start),
+		Err:          err,
+	})
+}
+
+func (p *progressTracker) started(name string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastBytes = time.Now()
+	p.emit(ProgressEntryStarted, name, size, nil)
+}
+
+func (p *progressTracker) read(name string, size int64, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += int64(n)
+	if time.Since(p.lastBytes) >= progressInterval {
+		p.lastBytes = time.Now()
+		p.emit(ProgressBytes, name, size, nil)
+	}
+}
+
+func (p *progressTracker) finished(name string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries++
+	p.emit(ProgressEntryFinished, name, size, nil)
+}
+
+func (p *progressTracker) failed(name string, size int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(ProgressEntryFailed, name, size, err)
+}
+
+// done reports the end of a successful operation.
+func (p *progressTracker) done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(ProgressDone, "", 0, nil)
+}
+
+// files sets the totals to the regular files among files, and returns them
+// reporting their progress as the archiver reads them.
+func (p *progressTracker) files(files []archives.FileInfo) []archives.FileInfo {
+	if p == nil {
+		return files
+	}
+	for i, f := range files {
+		if !f.Mode().IsRegular() || f.Open == nil {
+			continue
+		}
+		p.totalBytes += f.Size()
+		p.totalEntries++
+		open, name, size := f.Open, f.NameInArchive, f.Size()
+		files[i].Open = func() (fs.File, error) {
+			file, err := open()
+			if err != nil {
+				p.failed(name, size, err)
+				return nil, err
+			}
+			p.started(name, size)
+			return &progressFile{File: file, p: p, name: name, size: size, finish: true}, nil
+		}
+	}
+	return files
+}
+
+// handler wraps the handler extracting entries to report their progress.
+func (p *progressTracker) handler(handler archives.FileHandler) archives.FileHandler {
+	if p == nil {
+		return handler
+	}
+	return func(ctx context.Context, f archives.FileInfo) error {
+		name, size := f.NameInArchive, f.Size()
+		if open := f.Open; open != nil {
+			f.Open = func() (fs.File, error) {
+				file, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return &progressFile{File: file, p: p, name: name, size: size}, nil
+			}
+		}
+		p.started(name, size)
+		if err := handler(ctx, f); err != nil {
+			p.failed(name, size, err)
+			return err
+		}
+		p.finished(name, size)
+		return nil
+	}
+}
+
+// progressFile counts the bytes read from an entry.
+type progressFile struct {
+	fs.File
+	p    *progressTracker
+	name string
+	size int64
+	// report the entry as finished once closed
+	finish bool
+}
+
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.p.read(f.name, f.size, n)
+	return n, err
+}
+
+func (f *progressFile) Close() error {
+	err := f.File.Close()
+	if f.finish && err == nil {
+		f.finish = false
+		f.p.finished(f.name, f.size)
+	}
+	return err
+}
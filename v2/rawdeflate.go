@@ -0,0 +1,42 @@
+package arc
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/flate"
+)
+
+// Deflate is a raw deflate stream, RFC 1951, without the header and
+// checksum gzip and zlib wrap it in, as sent for the deflate content
+// encoding by some HTTP servers and stored by other formats. It has no
+// magic number, so it is only recognized by its .deflate extension.
+type Deflate struct {
+	// CompressionLevel is 1-9, 0 for the default
+	CompressionLevel int
+}
+
+func (Deflate) Extension() string { return ".deflate" }
+func (Deflate) MediaType() string { return "application/x-deflate" }
+
+func (d Deflate) Match(_ context.Context, filename string, _ io.Reader) (archives.MatchResult, error) {
+	return archives.MatchResult{ByName: strings.EqualFold(path.Ext(filename), d.Extension())}, nil
+}
+
+func (d Deflate) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	level := d.CompressionLevel
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return flate.NewWriter(w, level)
+}
+
+func (Deflate) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// Interface guard
+var _ archives.Compression = Deflate{}
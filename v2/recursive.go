@@ -0,0 +1,97 @@
+package arc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithRecursive extracts the archives found among the extracted files too,
+// like a .tar.gz inside the .zip of a vendor release, down to depth levels
+// of nesting. Each is extracted in place, into a directory named after it
+// without its extensions, like app-1.0 for app-1.0.tar.gz, and removed once
+// extracted. Archives are recognized by their extension. Options selecting
+// or renaming entries, like WithStripComponents, only apply to the outer
+// archive.
+func WithRecursive(depth int) Option {
+	return func(o *options) {
+		o.recursive = depth
+	}
+}
+
+// UnarchiveRecursive is Unarchive with WithRecursive(depth).
+// tarball: the archive to extract
+// dst: the destination directory
+// depth: how many levels of archives within archives to extract
+// opts: optional settings, see Option
+func UnarchiveRecursive(tarball, dst string, depth int, opts ...Option) error {
+	return Unarchive(tarball, dst, append(opts, WithRecursive(depth))...)
+}
+
+// isNestedArchive reports whether the extracted file path is an archive to
+// extract with WithRecursive.
+func isNestedArchive(path string) bool {
+	if strings.EqualFold(filepath.Ext(path), ".7z") {
+		return true
+	}
+	_, archival, err := FormatFromName(path)
+	return err == nil && archival != nil
+}
+
+// nestedDir returns the directory the nested archive path is extracted to,
+// path without its archive and compression extensions.
+func nestedDir(path string) string {
+	for {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == "" || len(ext) == len(filepath.Base(path)) {
+			return path
+		}
+		_, shorthand := tarShorthands[ext]
+		if !shorthand && ext != ".7z" {
+			if _, _, err := FormatFromName(ext); err != nil {
+				return path
+			}
+		}
+		path = path[:len(path)-len(ext)]
+	}
+}
+
+// extractNested extracts the archives d has written, see WithRecursive.
+func (d *dirSink) extractNested() error {
+	if d.o.recursive <= 0 {
+		return nil
+	}
+	// the nested archives have none of the options about the outer one
+	nested := *d.o
+	nested.recursive--
+	nested.stripComponents, nested.rename, nested.sample = 0, nil, false
+	nested.verifyManifest, nested.manifestFile = false, ""
+	nested.progress, nested.sync = nil, false
+
+	for _, archive := range d.nested {
+		dir := nestedDir(archive)
+		logging("Extracting nested archive %s to %s", archive, dir)
+		if m := d.mirrored(); m != nil {
+			m.keepTree(dir)
+		}
+		if err := unarchiveNested(archive, dir, &nested); err != nil {
+			return fmt.Errorf("nested archive %s: %w", archive, err)
+		}
+		if err := os.Remove(archive); err != nil {
+			return fmt.Errorf("removing nested archive: %w", err)
+		}
+	}
+	d.nested = nil
+	return nil
+}
+
+// unarchiveNested extracts the nested archive to dir.
+func unarchiveNested(archive, dir string, o *options) error {
+	archiveFile, name, openErr := openArchive(archive)
+	if openErr != nil {
+		return openErr
+	}
+	defer archiveFile.Close()
+	return unarchiveStream(name, archiveFile, dir, o)
+}
@@ -0,0 +1,133 @@
+package arc
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// registry holds the formats arc creates and extracts by name: those
+// compiled in, each can be left out with a build tag like arc_no_brotli, see
+// codec.go, and those registered by the application.
+var registry = struct {
+	sync.RWMutex
+	compressions map[string]archives.Compression
+	archivals    map[string]archives.Archival
+	// formats that are read but can't be written, like squashfs images
+	extractions map[string]archives.Extraction
+}{
+	compressions: map[string]archives.Compression{},
+	archivals:    map[string]archives.Archival{},
+	extractions:  map[string]archives.Extraction{},
+}
+
+// RegisterCompression adds the compression c under name, so it can be
+// selected like the compressions of arc, with -c name or by its extension.
+// Names are case insensitive, and one that is already registered, as any
+// kind of format, panics. To detect c from the content of its files too, it
+// must also be registered with archives.RegisterFormat.
+// name: the key of c, like "zst"
+// c: the compression
+func RegisterCompression(name string, c archives.Compression) {
+	if c == nil {
+		panic("arc: RegisterCompression of nil compression " + name)
+	}
+	register(name, func() { registry.compressions[strings.ToLower(name)] = c })
+}
+
+// RegisterArchival adds the archival a under name, like RegisterCompression.
+// name: the key of a, like "tar"
+// a: the archival
+func RegisterArchival(name string, a archives.Archival) {
+	if a == nil {
+		panic("arc: RegisterArchival of nil archival " + name)
+	}
+	register(name, func() { registry.archivals[strings.ToLower(name)] = a })
+}
+
+// RegisterExtraction adds the format e, which is read but can't be written,
+// under name, like RegisterCompression. Creating archives of its extension
+// fails with ErrReadOnly.
+// name: the key of e, like "iso"
+// e: the extraction, which should also be an archives.Format
+func RegisterExtraction(name string, e archives.Extraction) {
+	if e == nil {
+		panic("arc: RegisterExtraction of nil extraction " + name)
+	}
+	register(name, func() { registry.extractions[strings.ToLower(name)] = e })
+}
+
+// register runs add with the registry locked, unless name is empty or taken.
+func register(name string, add func()) {
+	registry.Lock()
+	defer registry.Unlock()
+	key := strings.ToLower(name)
+	if key == "" {
+		panic("arc: format registered without a name")
+	}
+	_, compression := registry.compressions[key]
+	_, archival := registry.archivals[key]
+	_, extraction := registry.extractions[key]
+	if compression || archival || extraction {
+		panic(fmt.Sprintf("arc: format %s is already registered", key))
+	}
+	add()
+}
+
+// LookupCompression returns the compression registered under name or one of
+// its aliases, like gzip for gz, and whether there is one.
+// name: the key of the compression, case insensitive
+func LookupCompression(name string) (archives.Compression, bool) {
+	key := strings.ToLower(name)
+	if alias, ok := compressionAliases[key]; ok {
+		key = alias
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	c, ok := registry.compressions[key]
+	return c, ok
+}
+
+// LookupArchival returns the archival registered under name and whether
+// there is one.
+// name: the key of the archival, case insensitive
+func LookupArchival(name string) (archives.Archival, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	a, ok := registry.archivals[strings.ToLower(name)]
+	return a, ok
+}
+
+// LookupExtraction returns the read-only format registered under name and
+// whether there is one.
+// name: the key of the format, case insensitive
+func LookupExtraction(name string) (archives.Extraction, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	e, ok := registry.extractions[strings.ToLower(name)]
+	return e, ok
+}
+
+// compressions returns a copy of the registered compressions by name.
+func compressions() map[string]archives.Compression {
+	registry.RLock()
+	defer registry.RUnlock()
+	return maps.Clone(registry.compressions)
+}
+
+// archivals returns a copy of the registered archivals by name.
+func archivals() map[string]archives.Archival {
+	registry.RLock()
+	defer registry.RUnlock()
+	return maps.Clone(registry.archivals)
+}
+
+// extractions returns a copy of the registered read-only formats by name.
+func extractions() map[string]archives.Extraction {
+	registry.RLock()
+	defer registry.RUnlock()
+	return maps.Clone(registry.extractions)
+}
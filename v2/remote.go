@@ -0,0 +1,488 @@
+package arc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConnections = 4
+	defaultChunkSize   = 8 << 20
+	// attempts per request before a download fails, see WithRetries
+	defaultAttempts = 3
+	// wait before the second attempt, growing with each attempt
+	retryDelay = 500 * time.Millisecond
+)
+
+// errRemoteChanged is returned when a file changes on the server while it
+// is being downloaded in chunks.
+var errRemoteChanged = errors.New("the file changed on the server during the download")
+
+// WithConnections sets how many range requests download a remote archive in
+// parallel, 1 disables chunked downloads. The default is 4.
+func WithConnections(n int) Option {
+	return func(o *options) {
+		o.connections = n
+	}
+}
+
+// WithChunkSize sets the size of the ranges of a chunked download, at most
+// connections chunks are held in memory. The default is 8 MiB.
+func WithChunkSize(size int64) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
+}
+
+// WithRetries sets how often a failed request of a download is retried: the
+// first request, each chunk and, when the connection breaks, the request
+// resuming the download where it stopped. The default is 2, 0 disables
+// retries.
+func WithRetries(n int) Option {
+	return func(o *options) {
+		o.attempts = max(n, 0) + 1
+	}
+}
+
+// IsURL reports whether name is an http or https URL rather than a file path.
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// OpenURL returns the content of the file at rawURL. When the server supports
+// range requests, the file is downloaded in parallel chunks that are
+// returned in order, so it can be consumed while downloading, and a broken
+// connection is resumed where it stopped.
+func OpenURL(rawURL string, opts ...Option) (io.ReadCloser, error) {
+	o := newOptions(opts)
+	return openURL(o.context(), rawURL, o)
+}
+
+// Download saves the file at rawURL to outfile, see OpenURL. With
+// WithChecksum, outfile is removed again if it doesn't match.
+func Download(rawURL, outfile string, opts ...Option) error {
+	return download(rawURL, outfile, newOptions(opts))
+}
+
+func download(rawURL, outfile string, o *options) error {
+	logging("Downloading %s to %s", rawURL, outfile)
+	if err := o.checkDestination(outfile); err != nil {
+		return err
+	}
+	_, within, stop := operationContext(o)
+	defer stop()
+	body, err := openURL(within, rawURL, o)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	outf, err := os.Create(outfile)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outfile, err)
+	}
+	defer outf.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(outf, hash), ctxReader{Reader: body, ctx: within}); err != nil {
+		outf.Close()
+		os.Remove(outfile)
+		return fmt.Errorf("download %s: %w", rawURL, err)
+	}
+	if err := outf.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", outfile, err)
+	}
+	if err := checkChecksum(hash.Sum(nil), o); err != nil {
+		os.Remove(outfile)
+		return fmt.Errorf("download %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// UnarchiveURL extracts the remote archive at rawURL to dst, feeding the
+// extractor while the download is in progress. Zip archives need random
+// access and are buffered in a temp file first, as are archives verified
+// against a manifest or a checksum.
+func UnarchiveURL(rawURL, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Unarchiving %s to %s", rawURL, dst)
+	name, err := urlFileName(rawURL)
+	if err != nil {
+		return err
+	}
+
+	// checksums are only known once the download completes, and the
+	// manifest is checked in a pass of its own, before extraction
+	if o.verifyManifest || o.checksum != "" {
+		return UnarchiveMirrors([]string{rawURL}, dst, opts...)
+	}
+
+	if rootErr := o.checkDestination(dst, o.cacheDir); rootErr != nil {
+		return rootErr
+	}
+
+	// the download feeds the entry in progress, it has the same grace period
+	_, within, stop := operationContext(o)
+	defer stop()
+	body, err := openURL(within, rawURL, o)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
+		return fmt.Errorf("creating destination directory: %w", dirErr)
+	}
+
+	// a checksum trailer is only verified at the end of the stream, files
+	// extracted before a mismatch is detected are kept
+	input := newTrailerReader(body)
+	sink := &dirSink{dst: dst, o: o}
+	if capErr := sink.detectCapabilities(); capErr != nil {
+		return capErr
+	}
+	progress := newProgress(o)
+	if extractErr := extractStream(name, input, progress.handler(sink.extract), o); extractErr != nil {
+		return fmt.Errorf("extracting files: %w", extractErr)
+	}
+	if _, drainErr := io.Copy(io.Discard, input); drainErr != nil {
+		return fmt.Errorf("extracting files: %w", drainErr)
+	}
+	if finishErr := sink.finish(); finishErr != nil {
+		return fmt.Errorf("extracting files: %w", finishErr)
+	}
+	progress.done()
+
+	logging("Unarchiving completed successfully.")
+	return nil
+}
+
+// urlFileName returns the last element of the path of rawURL, which helps
+// identifying the archive format.
+func urlFileName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse URL %s: %w", rawURL, err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "download"
+	}
+	return name, nil
+}
+
+func openURL(ctx context.Context, rawURL string, o *options) (io.ReadCloser, error) {
+	client, err := newRemoteClient(o)
+	if err != nil {
+		return nil, err
+	}
+	connections := o.connections
+	if connections < 1 {
+		connections = defaultConnections
+	}
+	chunkSize := o.chunkSize
+	if chunkSize < 1 {
+		chunkSize = defaultChunkSize
+	}
+	attempts := o.attempts
+	if attempts < 1 {
+		attempts = defaultAttempts
+	}
+
+	// the first chunk doubles as a probe for range support
+	var byteRange string
+	if connections > 1 {
+		byteRange = fmt.Sprintf("bytes=0-%d", chunkSize-1)
+	}
+	resp, err := getRetrying(ctx, client, rawURL, byteRange, "", attempts)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", rawURL, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		logging("Downloading %s over a single connection", rawURL)
+		return newResumeReader(ctx, client, rawURL, resp, attempts), nil
+	case http.StatusPartialContent:
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %s: %s", rawURL, resp.Status)
+	}
+
+	size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	first, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", rawURL, err)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("get %s: %w", rawURL, readErr)
+	}
+	// every chunk must come from the file the probe saw
+	validator := rangeValidator(resp)
+	if validator == "" {
+		logging("%s has neither ETag nor Last-Modified, changes during the download can only be seen from its size", rawURL)
+	}
+	logging("Downloading %s (%d bytes) in chunks of %d over %d connections", rawURL, size, chunkSize, connections)
+	return newChunkReader(ctx, client, rawURL, validator, first, size, chunkSize, connections, attempts), nil
+}
+
+// rangeValidator returns what identifies the file of resp in If-Range: its
+// ETag, or its Last-Modified date where the ETag is missing or weak, which
+// If-Range doesn't accept.
+func rangeValidator(resp *http.Response) string {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	return validator
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// like "bytes 0-1023/4096".
+func contentRangeSize(contentRange string) (int64, error) {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("unexpected Content-Range %q", contentRange)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// chunkResult is the outcome of downloading one chunk.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// chunkReader downloads a file in parallel range requests and returns the
+// chunks in order. pending holds the chunks being downloaded, in file order,
+// and its capacity bounds the number of connections.
+type chunkReader struct {
+	cancel  context.CancelFunc
+	pending chan chan chunkResult
+	cur     []byte
+	err     error
+}
+
+func newChunkReader(ctx context.Context, client *remoteClient, rawURL, validator string, first []byte, size, chunkSize int64, connections, attempts int) *chunkReader {
+	ctx, cancel := context.WithCancel(ctx)
+	cr := &chunkReader{
+		cancel:  cancel,
+		pending: make(chan chan chunkResult, connections-1),
+	}
+
+	go func() {
+		defer close(cr.pending)
+		done := make(chan chunkResult, 1)
+		done <- chunkResult{data: first}
+		cr.pending <- done
+
+		for start := int64(len(first)); start < size; start += chunkSize {
+			end := min(start+chunkSize, size) - 1
+			result := make(chan chunkResult, 1)
+			select {
+			case cr.pending <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := fetchChunk(ctx, client, rawURL, validator, start, end, size, attempts)
+				result <- chunkResult{data: data, err: err}
+			}()
+		}
+	}()
+	return cr
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.cur) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		result, ok := <-cr.pending
+		if !ok {
+			cr.err = io.EOF
+			continue
+		}
+		chunk := <-result
+		cr.cur, cr.err = chunk.data, chunk.err
+	}
+	n := copy(p, cr.cur)
+	cr.cur = cr.cur[n:]
+	return n, nil
+}
+
+// Close stops the download, chunks still in flight are discarded.
+func (cr *chunkReader) Close() error {
+	cr.cancel()
+	return nil
+}
+
+// fetchChunk downloads the bytes from start to end inclusive of the file of
+// size bytes identified by validator, with retries. A changed file isn't
+// retried.
+func fetchChunk(ctx context.Context, client *remoteClient, rawURL, validator string, start, end, size int64, attempts int) ([]byte, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err := waitRetry(ctx, attempt); err != nil {
+			return nil, err
+		}
+		data, err := fetchRange(ctx, client, rawURL, validator, start, end, size)
+		if err == nil {
+			return data, nil
+		}
+		if errors.Is(err, errRemoteChanged) {
+			return nil, fmt.Errorf("chunk %d-%d: %w", start, end, err)
+		}
+		logging("Chunk %d-%d of %s failed (attempt %d): %v", start, end, rawURL, attempt, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("chunk %d-%d: %w", start, end, lastErr)
+}
+
+func fetchRange(ctx context.Context, client *remoteClient, rawURL, validator string, start, end, size int64) ([]byte, error) {
+	resp, err := client.get(ctx, rawURL, fmt.Sprintf("bytes=%d-%d", start, end), validator)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// If-Range gets the whole file instead of the range once it changed
+	if resp.StatusCode == http.StatusOK && validator != "" {
+		return nil, errRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	expected := fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != expected {
+		if strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-%d/", start, end)) {
+			return nil, fmt.Errorf("%w: Content-Range %q, expected %q", errRemoteChanged, contentRange, expected)
+		}
+		return nil, fmt.Errorf("unexpected Content-Range %q, expected %q", contentRange, expected)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// waitRetry waits before attempt, longer with each attempt, unless ctx is
+// canceled first.
+func waitRetry(ctx context.Context, attempt int) error {
+	if attempt == 1 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(time.Duration(attempt-1) * retryDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getRetrying is remoteClient.get, retried when the request fails or the
+// server answers with an error of its own, 5xx.
+func getRetrying(ctx context.Context, client *remoteClient, rawURL, byteRange, ifRange string, attempts int) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err := waitRetry(ctx, attempt); err != nil {
+			return nil, err
+		}
+		resp, err := client.get(ctx, rawURL, byteRange, ifRange)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		logging("Request for %s failed (attempt %d): %v", rawURL, attempt, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// resumeReader reads a download over a single connection. When the
+// connection breaks and the server supports range requests, the rest of the
+// file is requested from where it stopped, provided the file is unchanged.
+type resumeReader struct {
+	ctx      context.Context
+	client   *remoteClient
+	rawURL   string
+	attempts int
+
+	body io.ReadCloser
+	// bytes read so far, and whether and how the rest can be requested
+	offset    int64
+	resumable bool
+	validator string
+	// resumes since bytes were last read, bounded by attempts
+	stalled int
+}
+
+func newResumeReader(ctx context.Context, client *remoteClient, rawURL string, resp *http.Response, attempts int) *resumeReader {
+	return &resumeReader{
+		ctx:       ctx,
+		client:    client,
+		rawURL:    rawURL,
+		attempts:  attempts,
+		body:      resp.Body,
+		resumable: resp.Header.Get("Accept-Ranges") == "bytes",
+		validator: rangeValidator(resp),
+	}
+}
+
+func (r *resumeReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if n > 0 {
+		r.stalled = 0
+	}
+	if err == nil || err == io.EOF || !r.resumable || r.ctx.Err() != nil {
+		return n, err
+	}
+	if r.stalled >= r.attempts {
+		return n, fmt.Errorf("download broke after %d bytes, resuming failed %d times: %w", r.offset, r.stalled, err)
+	}
+	if resumeErr := r.resume(err); resumeErr != nil {
+		return n, resumeErr
+	}
+	return n, nil
+}
+
+// resume replaces the broken body by the rest of the file, cause is why the
+// body broke.
+func (r *resumeReader) resume(cause error) error {
+	r.body.Close()
+	r.stalled++
+	logging("Download of %s broke after %d bytes, resuming: %v", r.rawURL, r.offset, cause)
+	resp, err := getRetrying(r.ctx, r.client, r.rawURL, fmt.Sprintf("bytes=%d-", r.offset), r.validator, r.attempts)
+	if err != nil {
+		return fmt.Errorf("resume after %d bytes: %w", r.offset, err)
+	}
+	// a file changed since, or a server ignoring the range, sends it whole
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.offset)) {
+		resp.Body.Close()
+		return fmt.Errorf("resume after %d bytes: %w, the server can't resume: %s", r.offset, cause, resp.Status)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *resumeReader) Close() error {
+	return r.body.Close()
+}
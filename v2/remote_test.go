@@ -0,0 +1,131 @@
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testContent returns size bytes that differ with version.
+func testContent(version string, size int) []byte {
+	return bytes.Repeat([]byte(version), size/len(version))
+}
+
+// serveVersions serves the file of version v1 to the first request and that
+// of version v2 to the ones after it, each with its ETag, or Last-Modified
+// date where etags is false, so that range requests honor If-Range.
+func serveVersions(t *testing.T, etags bool, size int) *httptest.Server {
+	t.Helper()
+	var requests atomic.Int32
+	modified := map[string]time.Time{
+		"v1": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"v2": time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := "v1"
+		if requests.Add(1) > 1 {
+			version = "v2"
+		}
+		if etags {
+			w.Header().Set("ETag", `"`+version+`"`)
+		}
+		http.ServeContent(w, r, "file.bin", modified[version], bytes.NewReader(testContent(version, size)))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestOpenURLChunks(t *testing.T) {
+	const size = 1 << 16
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(testContent("v1", size)))
+	}))
+	defer ts.Close()
+
+	rc, err := OpenURL(ts.URL, WithConnections(4), WithChunkSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testContent("v1", size)) {
+		t.Fatalf("downloaded %d bytes that differ from the file", len(got))
+	}
+}
+
+func TestOpenURLChanged(t *testing.T) {
+	for _, etags := range []bool{true, false} {
+		t.Run(fmt.Sprintf("etags=%v", etags), func(t *testing.T) {
+			ts := serveVersions(t, etags, 1<<16)
+			rc, err := OpenURL(ts.URL, WithConnections(4), WithChunkSize(1000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if !errors.Is(err, errRemoteChanged) {
+				t.Fatalf("expected errRemoteChanged, got %v", err)
+			}
+			if bytes.Contains(got, []byte("v2")) {
+				t.Fatal("chunks of the changed file returned")
+			}
+		})
+	}
+}
+
+func TestOpenURLContentRange(t *testing.T) {
+	const size = 1 << 16
+	content := testContent("v1", size)
+	tests := []struct {
+		name         string
+		contentRange func(start, end int) string
+		changed      bool
+	}{
+		{"other range", func(start, end int) string { return fmt.Sprintf("bytes %d-%d/%d", start+1, end+1, size) }, false},
+		{"missing", func(int, int) string { return "" }, false},
+		{"other size", func(start, end int) string { return fmt.Sprintf("bytes %d-%d/%d", start, end, size*2) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var start, end int
+				if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+					t.Errorf("unexpected Range %q", r.Header.Get("Range"))
+					return
+				}
+				contentRange := fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+				if start > 0 {
+					contentRange = tt.contentRange(start, end)
+				}
+				w.Header().Set("Content-Range", contentRange)
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[start : end+1])
+			}))
+			defer ts.Close()
+
+			rc, err := OpenURL(ts.URL, WithConnections(2), WithChunkSize(1000), WithRetries(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			_, err = io.ReadAll(rc)
+			if err == nil || !strings.Contains(err.Error(), "Content-Range") {
+				t.Fatalf("expected a Content-Range error, got %v", err)
+			}
+			if errors.Is(err, errRemoteChanged) != tt.changed {
+				t.Fatalf("changed file %v expected: %v", tt.changed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package arc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// maxRestoreLayers bounds the compressions Restore removes, so a crafted
+// input can't keep it busy forever.
+const maxRestoreLayers = 8
+
+// Restored describes what Restore did.
+type Restored struct {
+	// Steps are what was undone, in order, like "join 3 volumes",
+	// "verify checksum trailer", "decrypt", "decompress zst" and
+	// "extract tar"
+	Steps []string
+	// File is the file written when the content isn't an archive, empty if
+	// it was extracted
+	File string
+}
+
+// Restore is the counterpart of a Pipeline and the options of Archive: it
+// undoes whatever arc did to produce input, detecting each step from the
+// file name and magic bytes. Volumes are joined, see SplitVolumes, the
+// checksum trailer verified, age and OpenPGP encryption removed with
+// WithDecryption, compressions removed layer by layer, and the archive
+// within extracted to dst. Content that isn't an archive, like the output of
+// CompressStream, is written to dst under the name of input without the
+// extensions of the undone steps.
+// input: the file produced by arc, or any of its volumes
+// dst: the destination directory
+// opts: optional settings, see Option
+func Restore(input, dst string, opts ...Option) (Restored, error) {
+	o := newOptions(opts)
+	logging("Restoring %s to %s", input, dst)
+	var restored Restored
+	if err := o.checkDestination(dst); err != nil {
+		return restored, err
+	}
+
+	file, name, err := openArchive(input)
+	if err != nil {
+		return restored, err
+	}
+	defer file.Close()
+	if volumes := SplitVolumes(input); volumes != nil {
+		restored.Steps = append(restored.Steps, fmt.Sprintf("join %d volumes", len(volumes)))
+	}
+	if _, trailed := file.(*trailedFile); trailed {
+		restored.Steps = append(restored.Steps, "verify checksum trailer")
+	}
+
+	stream, encrypted, err := decryptInput(file, o)
+	if err != nil {
+		return restored, fmt.Errorf("decrypt %s: %w", input, err)
+	}
+	if encrypted {
+		name = trimEncryptionExt(name)
+		restored.Steps = append(restored.Steps, "decrypt")
+	}
+
+	ctx := o.context()
+	for layer := 0; ; layer++ {
+		// stacked extensions like .tar.gz.zst would mislead identification
+		// by name, the magic bytes are trusted first and the name only
+		// helps with formats that have none, like brotli
+		format, identified, idName, idErr := identifyLayer(ctx, name, stream)
+		stream = identified
+		if errors.Is(idErr, archives.NoMatch) {
+			break
+		}
+		if idErr != nil {
+			return restored, fmt.Errorf("identify format: %w", idErr)
+		}
+		if availErr := compiledIn(format); availErr != nil {
+			return restored, availErr
+		}
+
+		if _, ok := format.(archives.Extractor); ok {
+			restored.Steps = append(restored.Steps, "extract "+strings.TrimPrefix(format.Extension(), "."))
+			// the file itself can be read at random, zip needs that
+			if layer == 0 && !encrypted {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					return restored, fmt.Errorf("rewind %s: %w", input, err)
+				}
+				stream = file
+			}
+			if err := unarchiveStream(idName, stream, dst, o); err != nil {
+				return restored, err
+			}
+			logging("Restored %s: %s", input, strings.Join(restored.Steps, ", "))
+			return restored, nil
+		}
+		if layer == maxRestoreLayers {
+			return restored, fmt.Errorf("more than %d layers of compression in %s", maxRestoreLayers, input)
+		}
+		decompressor, ok := format.(archives.Decompressor)
+		if !ok {
+			return restored, fmt.Errorf("unsupported format %s", format.Extension())
+		}
+		reader, err := decompressor.OpenReader(stream)
+		if err != nil {
+			return restored, fmt.Errorf("decompress %s: %w", format.Extension(), err)
+		}
+		defer reader.Close()
+		stream = ctxReader{Reader: reader, ctx: ctx}
+		name = trimExt(name, format.Extension())
+		restored.Steps = append(restored.Steps, "decompress "+strings.TrimPrefix(format.Extension(), "."))
+	}
+
+	// what remains isn't an archive, it is only written if arc had done
+	// something to it
+	if len(restored.Steps) == 0 {
+		return restored, fmt.Errorf("%s: %w", input, archives.NoMatch)
+	}
+	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
+		return restored, fmt.Errorf("creating destination directory: %w", dirErr)
+	}
+	restored.File = filepath.Join(dst, filepath.Base(name))
+	out, err := os.Create(restored.File)
+	if err != nil {
+		return restored, fmt.Errorf("create %s: %w", restored.File, err)
+	}
+	if _, err := io.Copy(out, stream); err != nil {
+		out.Close()
+		os.Remove(restored.File)
+		return restored, fmt.Errorf("write %s: %w", restored.File, err)
+	}
+	if err := out.Close(); err != nil {
+		return restored, fmt.Errorf("write %s: %w", restored.File, err)
+	}
+	restored.Steps = append(restored.Steps, "write "+filepath.Base(name))
+	logging("Restored %s: %s", input, strings.Join(restored.Steps, ", "))
+	return restored, nil
+}
+
+// identifyLayer identifies the outermost format of stream by its magic
+// bytes, or else by the last extension of name. It returns the stream to
+// read from and the name to identify the format with again.
+func identifyLayer(ctx context.Context, name string, stream io.Reader) (archives.Format, io.Reader, string, error) {
+	format, stream, err := archives.Identify(ctx, "", stream)
+	if !errors.Is(err, archives.NoMatch) || filepath.Ext(name) == "" {
+		return format, stream, "", err
+	}
+	byExt := "layer" + filepath.Ext(name)
+	format, stream, err = identify(ctx, byExt, stream)
+	return format, stream, byExt, err
+}
+
+// trimExt removes ext from the end of name, whatever its case.
+func trimExt(name, ext string) string {
+	if strings.HasSuffix(strings.ToLower(name), strings.ToLower(ext)) {
+		return name[:len(name)-len(ext)]
+	}
+	return name
+}
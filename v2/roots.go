@@ -0,0 +1,92 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotAllowed is wrapped by the errors of writes outside of the roots set
+// with WithAllowedRoots.
+var ErrNotAllowed = errors.New("destination not allowed")
+
+// WithAllowedRoots restricts where arc writes to the directories roots and
+// below: extraction directories, archives and other output files, link farm
+// caches, fsck catalogs and precompressed copies. A destination anywhere
+// else, like / or C:\Windows given by a configuration mistake, fails with
+// an error wrapping ErrNotAllowed before anything is written. Paths are made
+// absolute and their symbolic links resolved as far as they exist, so a
+// link can't lead outside of the roots. Temp files arc creates for itself
+// aren't restricted.
+func WithAllowedRoots(roots ...string) Option {
+	return func(o *options) {
+		o.allowedRoots = append(o.allowedRoots, roots...)
+	}
+}
+
+// checkDestination returns an error wrapping ErrNotAllowed if one of paths
+// is outside of the allowed roots, empty paths are skipped.
+func (o *options) checkDestination(paths ...string) error {
+	if len(o.allowedRoots) == 0 {
+		return nil
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := checkAllowed(path, o.allowedRoots); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOutput is checkDestination for the archive outfile and the file of
+// WithManifestFile, streams set with WithOutputWriter are up to the caller.
+func (o *options) checkOutput(outfile string) error {
+	if o.output != nil {
+		return o.checkDestination(o.manifestFile)
+	}
+	return o.checkDestination(outfile, o.manifestFile)
+}
+
+// checkAllowed returns an error wrapping ErrNotAllowed if path isn't below
+// one of roots.
+func checkAllowed(path string, roots []string) error {
+	target, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", path, err)
+	}
+	for _, root := range roots {
+		resolved, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolved, target)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	logging("Refusing to write to %s, outside of %s", target, strings.Join(roots, ", "))
+	return fmt.Errorf("%w: %s is outside of %s", ErrNotAllowed, path, strings.Join(roots, ", "))
+}
+
+// resolvePath returns path made absolute, with the symbolic links of the
+// part that exists resolved.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return abs, nil
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+	}
+}
@@ -0,0 +1,158 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputPlaceholder is a placeholder of archive name templates, with the
+// pattern of what it expands to. Placeholders without a pattern, like
+// {host}, expand to the same text whenever archives are created, which
+// rotation matches literally.
+type outputPlaceholder struct {
+	name    string
+	pattern string
+	expand  func(t time.Time) string
+}
+
+var outputPlaceholders = []outputPlaceholder{
+	{"{date}", `\d{4}-\d{2}-\d{2}`, func(t time.Time) string { return t.Format("2006-01-02") }},
+	{"{time}", `\d{2}-\d{2}-\d{2}`, func(t time.Time) string { return t.Format("15-04-05") }},
+	{"{datetime}", `\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}`, func(t time.Time) string { return t.Format("2006-01-02T15-04-05") }},
+	{"{unix}", `\d{10}`, func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }},
+	{"{host}", "", func(time.Time) string {
+		host, err := os.Hostname()
+		if err != nil {
+			return "localhost"
+		}
+		return host
+	}},
+}
+
+// ExpandOutputName returns the archive name template with its placeholders
+// replaced for the time t, in the time zone of t: {date} is 2006-01-02,
+// {time} 15-04-05, {datetime} 2006-01-02T15-04-05, {unix} the 10 digits of
+// the seconds since the Unix epoch and {host} the host name, like backup-{date}.tar.zst for
+// nightly backups. Other text is kept as it is.
+func ExpandOutputName(template string, t time.Time) string {
+	for _, p := range outputPlaceholders {
+		if strings.Contains(template, p.name) {
+			template = strings.ReplaceAll(template, p.name, p.expand(t))
+		}
+	}
+	return template
+}
+
+// Rotate removes the archives named after template, see ExpandOutputName,
+// but the keep most recently modified ones, along with their split volumes
+// and signatures, and returns the paths removed. Only the file name of
+// template can have placeholders.
+// template: the name template the archives were created with
+// keep: how many archives to keep, at least 1
+func Rotate(template string, keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("rotation must keep at least 1 archive, not %d", keep)
+	}
+	dir, base := filepath.Split(template)
+	if ExpandOutputName(dir, time.Time{}) != dir {
+		return nil, errors.New("rotation: placeholders are only supported in the file name, not in directories")
+	}
+	pattern, ok := outputNamePattern(base)
+	if !ok {
+		return nil, fmt.Errorf("rotation: %s has no placeholder like {date}, there is nothing to rotate", template)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	logging("Rotating archives named like %s, keeping %d", template, keep)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("rotation: %w", err)
+	}
+	// the volumes, signature and checksum file of an archive go with it
+	type archive struct {
+		files   []string
+		modTime time.Time
+	}
+	groups := make(map[string]*archive)
+	for _, entry := range entries {
+		name := entry.Name()
+		key := strings.TrimSuffix(strings.TrimSuffix(volumeSuffix.ReplaceAllString(name, ""), SignatureExt), ChecksumExt)
+		if entry.IsDir() || !pattern.MatchString(key) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("rotation: %w", err)
+		}
+		a := groups[key]
+		if a == nil {
+			a = &archive{}
+			groups[key] = a
+		}
+		a.files = append(a.files, filepath.Join(dir, name))
+		if info.ModTime().After(a.modTime) {
+			a.modTime = info.ModTime()
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	// newest first, names break ties
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := groups[b].modTime.Compare(groups[a].modTime); c != 0 {
+			return c
+		}
+		return strings.Compare(b, a)
+	})
+
+	var removed []string
+	for _, key := range keys[min(keep, len(keys)):] {
+		for _, file := range groups[key].files {
+			logging("Removing old archive file: %s", file)
+			if err := os.Remove(file); err != nil {
+				return removed, fmt.Errorf("rotation: %w", err)
+			}
+			removed = append(removed, file)
+		}
+	}
+	return removed, nil
+}
+
+// outputNamePattern returns a regexp matching the names template expands
+// to, and whether it has placeholders that change over time at all. The
+// host name is matched as it is, so archives of other hosts and other files
+// sharing the directory are never rotated.
+func outputNamePattern(template string) (*regexp.Regexp, bool) {
+	var expr strings.Builder
+	found := false
+	for rest := template; rest != ""; {
+		i := slices.IndexFunc(outputPlaceholders, func(p outputPlaceholder) bool {
+			return strings.HasPrefix(rest, p.name)
+		})
+		if i >= 0 {
+			p := outputPlaceholders[i]
+			if p.pattern == "" {
+				expr.WriteString(regexp.QuoteMeta(p.expand(time.Time{})))
+			} else {
+				expr.WriteString(p.pattern)
+				found = true
+			}
+			rest = rest[len(p.name):]
+			continue
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:1]))
+		rest = rest[1:]
+	}
+	return regexp.MustCompile("^" + expr.String() + "$"), found
+}
@@ -0,0 +1,356 @@
+package arc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Route compresses the files with the extension Ext with Compression, see
+// WithRoutes and Precompress.
+type Route struct {
+	// Ext is the extension of the routed files, like ".wasm", matched case
+	// insensitively
+	Ext         string
+	Compression archives.Compression
+}
+
+// ParseRoutes parses a comma separated list of routes, like
+// ".wasm=br:11,.js=gz:9,.bin=zst:19": extensions mapped to the name of a
+// registered compression, with an optional level on the scale of
+// CompressionLevel or max. Extensions can share a codec with ".html,.css=br".
+func ParseRoutes(spec string) ([]Route, error) {
+	var routes []Route
+	var pending []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ext, codec, ok := strings.Cut(item, "=")
+		ext = strings.TrimSpace(ext)
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return nil, fmt.Errorf("route %q: extension must start with a dot, like .js", item)
+		}
+		pending = append(pending, ext)
+		if !ok {
+			continue
+		}
+		compression, err := parseRouteCodec(strings.TrimSpace(codec))
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", item, err)
+		}
+		for _, ext := range pending {
+			routes = append(routes, Route{Ext: ext, Compression: compression})
+		}
+		pending = nil
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("routes %s have no codec, like %s=gz", strings.Join(pending, ","), pending[0])
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no routes in %q", spec)
+	}
+	return routes, nil
+}
+
+// parseRouteCodec returns the compression of a codec like br:11 or gz.
+func parseRouteCodec(codec string) (archives.Compression, error) {
+	name, levelText, hasLevel := strings.Cut(codec, ":")
+	choice, err := SelectCompression(name)
+	if err != nil {
+		return nil, err
+	}
+	if DecompressOnly(choice.Name) {
+		return nil, fmt.Errorf("codec %s can only be decompressed", choice.Name)
+	}
+	compression := choice.Compression
+	if !hasLevel {
+		return compression, nil
+	}
+	level := MaxLevel
+	if levelText != "max" {
+		n, err := strconv.Atoi(levelText)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid level %q, expected a number or max", levelText)
+		}
+		level = n
+	}
+	return CompressionLevel(compression, level)
+}
+
+// routeFor returns the first of routes matching the name of a file.
+func routeFor(routes []Route, name string) (Route, bool) {
+	lower := strings.ToLower(name)
+	for _, route := range routes {
+		if strings.HasSuffix(lower, strings.ToLower(route.Ext)) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// WithRoutes stores the regular files that have a route compressed with its
+// codec, under their name with the extension of the codec appended, like
+// app.wasm.br, so each file type gets the codec that suits it best and the
+// archive holds files ready for web servers that send precompressed files.
+// The first matching route applies, other files are stored as they are,
+// and the compression of the archive still applies on top. Zip archives
+// store the routed files without compressing them again.
+func WithRoutes(routes ...Route) Option {
+	return func(o *options) {
+		o.routes = routes
+	}
+}
+
+// routeFiles replaces the regular files that have a route with their
+// compressed version, spooled to a temporary file when the archiver first
+// asks for its size or content, which WithProgress does for all of them
+// before archiving starts. It also returns the names of the routed files in
+// the archive, and a function removing the temporary files left.
+func routeFiles(files []archives.FileInfo, routes []Route) ([]archives.FileInfo, map[string]bool, func()) {
+	routed := make(map[string]bool)
+	var spools []*routeSpool
+	for i, f := range files {
+		if !f.Mode().IsRegular() || f.LinkTarget != "" || f.Open == nil {
+			continue
+		}
+		route, ok := routeFor(routes, f.NameInArchive)
+		if !ok {
+			continue
+		}
+		spool := &routeSpool{open: f.Open, compression: route.Compression}
+		spools = append(spools, spool)
+		ext := route.Compression.Extension()
+		f.NameInArchive += ext
+		routed[f.NameInArchive] = true
+		f.FileInfo = routedInfo{FileInfo: f.FileInfo, name: f.Name() + ext, spool: spool}
+		f.Open = spool.openCompressed
+		files[i] = f
+	}
+	return files, routed, func() {
+		for _, spool := range spools {
+			spool.remove()
+		}
+	}
+}
+
+// storeRouted makes zip archives store the routed files rather than
+// compress them again. Other formats are returned as they are.
+func storeRouted(format archives.Archiver, routed map[string]bool) archives.Archiver {
+	switch f := format.(type) {
+	case archives.Zip:
+		return metaZip{Zip: f, stored: routed}
+	case metaZip:
+		f.stored = routed
+		return f
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return metaZip{Zip: z, stored: routed}
+		}
+	}
+	return format
+}
+
+// routeSpool holds the compressed content of a routed file.
+type routeSpool struct {
+	open        func() (fs.File, error)
+	compression archives.Compression
+
+	once sync.Once
+	mu   sync.Mutex
+	path string
+	size int64
+	err  error
+}
+
+// compress writes the compressed content to a temporary file, once.
+func (s *routeSpool) compress() error {
+	s.once.Do(func() {
+		in, err := s.open()
+		if err != nil {
+			s.err = err
+			return
+		}
+		defer in.Close()
+		out, err := os.CreateTemp("", "arc-route-*")
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.mu.Lock()
+		s.path = out.Name()
+		s.mu.Unlock()
+		if err := CompressStream(out, in, s.compression); err != nil {
+			out.Close()
+			s.err = err
+			return
+		}
+		info, err := out.Stat()
+		if err == nil {
+			s.size = info.Size()
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		s.err = err
+	})
+	return s.err
+}
+
+// openCompressed opens the compressed content, which is removed once read.
+func (s *routeSpool) openCompressed() (fs.File, error) {
+	if err := s.compress(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return spooledFile{File: f, spool: s}, nil
+}
+
+// remove deletes the temporary file, if any.
+func (s *routeSpool) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path != "" {
+		os.Remove(s.path)
+		s.path = ""
+	}
+}
+
+// spooledFile removes its spool when closed.
+type spooledFile struct {
+	*os.File
+	spool *routeSpool
+}
+
+func (f spooledFile) Close() error {
+	err := f.File.Close()
+	f.spool.remove()
+	return err
+}
+
+// routedInfo describes a routed file with its compressed name and size. The
+// size of a file that failed to compress is its own, opening it returns the
+// error.
+type routedInfo struct {
+	fs.FileInfo
+	name  string
+	spool *routeSpool
+}
+
+func (r routedInfo) Name() string { return r.name }
+
+func (r routedInfo) Size() int64 {
+	if r.spool.compress() != nil {
+		return r.FileInfo.Size()
+	}
+	return r.spool.size
+}
+
+// PrecompressedFile is a compressed copy written by Precompress.
+type PrecompressedFile struct {
+	// Path is the file compressed, Output its compressed copy
+	Path   string
+	Output string
+	Size   int64
+	// CompressedSize is the size of Output
+	CompressedSize int64
+}
+
+// Precompress writes a compressed copy next to each regular file below dir
+// that has a route, like app.wasm.br next to app.wasm, for web servers that
+// send precompressed files. Existing copies are replaced, the files
+// themselves are left alone.
+// dir: the directory to walk, or a single file
+// routes: the codec of each extension, see ParseRoutes
+// opts: optional settings, like WithContext
+func Precompress(dir string, routes []Route, opts ...Option) ([]PrecompressedFile, error) {
+	o := newOptions(opts)
+	ctx := o.context()
+	logging("Precompressing the files of %s", dir)
+	if err := o.checkDestination(dir); err != nil {
+		return nil, err
+	}
+
+	var written []PrecompressedFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		route, ok := routeFor(routes, d.Name())
+		if !ok {
+			return nil
+		}
+		file, err := precompressFile(p, route.Compression)
+		if err != nil {
+			return err
+		}
+		logging("Precompressed %s: %d -> %d bytes", p, file.Size, file.CompressedSize)
+		written = append(written, file)
+		return nil
+	})
+	if err != nil {
+		errMsg := fmt.Errorf("error precompressing '%s': %w", dir, err)
+		logging("%s", errMsg.Error())
+		return written, errMsg
+	}
+	return written, nil
+}
+
+// precompressFile writes the compressed copy of the file at name, through a
+// temporary file so an interrupted run leaves no truncated copy.
+func precompressFile(name string, compression archives.Compression) (PrecompressedFile, error) {
+	file := PrecompressedFile{Path: name, Output: name + compression.Extension()}
+	in, err := os.Open(name)
+	if err != nil {
+		return file, err
+	}
+	defer in.Close()
+	source, err := in.Stat()
+	if err != nil {
+		return file, err
+	}
+	file.Size = source.Size()
+
+	out, err := os.CreateTemp(filepath.Dir(name), ".arc-precompress-*")
+	if err != nil {
+		return file, err
+	}
+	defer os.Remove(out.Name())
+	if err := CompressStream(out, in, compression); err != nil {
+		out.Close()
+		return file, fmt.Errorf("%s: %w", name, err)
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return file, err
+	}
+	file.CompressedSize = info.Size()
+	if err := out.Close(); err != nil {
+		return file, err
+	}
+	// copies keep the mode and mtime of their file, servers compare mtimes
+	if err := os.Chmod(out.Name(), source.Mode().Perm()); err != nil {
+		return file, err
+	}
+	if err := os.Chtimes(out.Name(), source.ModTime(), source.ModTime()); err != nil {
+		return file, err
+	}
+	return file, os.Rename(out.Name(), file.Output)
+}
@@ -0,0 +1,146 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+const (
+	// bytes covered by the rolling hash that finds reset points
+	rsyncWindow = 64
+	// a reset point is where the hash has these bits clear, one every
+	// 64 KiB of input on average
+	rsyncMask = 1<<16 - 1
+	// minimum input between reset points, each one costs some ratio
+	rsyncMinSize = 16 << 10
+)
+
+// rsyncTable maps bytes to the random values of the buzhash rolling hash.
+// It is derived from a fixed seed, as changing it moves all reset points.
+var rsyncTable = func() (table [256]uint32) {
+	state := uint64(0x61726373796e63) // splitmix64
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = uint32(z ^ z>>31)
+	}
+	return table
+}()
+
+// WithRsyncable makes gzip and zstd compressed archives rsync friendly, see
+// Rsyncable. Other compressions fail.
+func WithRsyncable() Option {
+	return func(o *options) {
+		o.rsyncable = true
+	}
+}
+
+// Rsyncable wraps a gzip or zstd compression so its output is reset at
+// points chosen by the content, like gzip --rsyncable. A change of the input
+// only changes the compressed output up to the next reset point, so rsync and
+// deduplicating storage transfer just the changed regions. Each reset starts
+// a new gzip member or zstd frame, which every decompressor reads as a
+// single stream, at the cost of a slightly worse ratio.
+func Rsyncable(compression archives.Compression) (archives.Compression, error) {
+	switch compression.(type) {
+	case archives.Gz, archives.Zstd:
+		return rsyncableCompression{Compression: compression}, nil
+	case rsyncableCompression:
+		return compression, nil
+	case seekableCompression:
+		return nil, errors.New("rsyncable output can't be seekable, the seek table needs frames of a fixed size")
+	}
+	return nil, fmt.Errorf("rsyncable output requires gzip or zstd compression, not %T", compression)
+}
+
+// rsyncableFormat applies Rsyncable to the compression of format.
+func rsyncableFormat(format archives.Archiver) (archives.Archiver, error) {
+	compressed, ok := format.(archives.CompressedArchive)
+	if !ok || compressed.Compression == nil {
+		return nil, fmt.Errorf("rsyncable output requires gzip or zstd compression")
+	}
+	compression, err := Rsyncable(compressed.Compression)
+	if err != nil {
+		return nil, err
+	}
+	compressed.Compression = compression
+	return compressed, nil
+}
+
+// rsyncableCompression restarts the compressor at every reset point.
+type rsyncableCompression struct {
+	archives.Compression
+}
+
+func (c rsyncableCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	cw, err := c.Compression.OpenWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &rsyncWriter{compression: c.Compression, w: w, cw: cw}, nil
+}
+
+// rsyncWriter keeps a rolling hash of the last rsyncWindow bytes written,
+// and starts a new compressed stream after each byte where it matches
+// rsyncMask.
+type rsyncWriter struct {
+	compression archives.Compression
+	w           io.Writer
+	cw          io.WriteCloser
+
+	window [rsyncWindow]byte
+	pos    int // slot of the oldest byte in window
+	hash   uint32
+	n      int64 // bytes since the last reset point
+}
+
+func (r *rsyncWriter) Write(p []byte) (int, error) {
+	var written int
+	start := 0
+	for i, b := range p {
+		// rotating by the window size is a no-op for 32 bits
+		r.hash = bits.RotateLeft32(r.hash, 1) ^ rsyncTable[r.window[r.pos]] ^ rsyncTable[b]
+		r.window[r.pos] = b
+		r.pos = (r.pos + 1) % rsyncWindow
+		r.n++
+		if r.n < rsyncMinSize || r.hash&rsyncMask != 0 {
+			continue
+		}
+
+		n, err := r.cw.Write(p[start : i+1])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		start = i + 1
+		if err := r.reset(); err != nil {
+			return written, err
+		}
+	}
+	n, err := r.cw.Write(p[start:])
+	return written + n, err
+}
+
+// reset ends the current compressed stream and starts a new one.
+func (r *rsyncWriter) reset() error {
+	if err := r.cw.Close(); err != nil {
+		return err
+	}
+	cw, err := r.compression.OpenWriter(r.w)
+	if err != nil {
+		return err
+	}
+	r.cw = cw
+	r.n = 0
+	return nil
+}
+
+func (r *rsyncWriter) Close() error {
+	return r.cw.Close()
+}
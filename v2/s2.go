@@ -0,0 +1,51 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// s2Header is the stream identifier of S2 streams, snappy ones have
+// "sNaPpY" in its place.
+var s2Header = []byte{0xff, 0x06, 0x00, 0x00, 'S', '2', 's', 'T', 'w', 'O'}
+
+// S2 is the S2 extension of snappy, smaller and faster to write than the
+// snappy streams of archives.Sz but not readable by snappy decoders. It
+// reads snappy streams too.
+type S2 struct {
+	archives.Sz
+}
+
+func (S2) Extension() string { return ".s2" }
+func (S2) MediaType() string { return "application/x-s2-framed" }
+
+func (s S2) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.EqualFold(path.Ext(filename), s.Extension())
+	if stream == nil {
+		return mr, nil
+	}
+	header := make([]byte, len(s2Header))
+	if _, err := io.ReadFull(stream, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(header, s2Header)
+	return mr, nil
+}
+
+func (s S2) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	s.Sz.S2.SnappyIncompatible = true
+	return s.Sz.OpenWriter(w)
+}
+
+// Interface guard
+var _ archives.Compression = S2{}
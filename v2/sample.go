@@ -0,0 +1,43 @@
+package arc
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// WithSample extracts only a random sample of about percent of the regular
+// files of an archive, for spot checks of datasets too large to unpack.
+// The choice depends on seed and the name of each file only, so the same
+// seed selects the same files of an archive, whatever their order.
+// Directories are only created for the sampled files.
+func WithSample(percent float64, seed uint64) Option {
+	return func(o *options) {
+		o.sample = true
+		o.samplePercent = percent
+		o.sampleSeed = seed
+	}
+}
+
+// sampled reports whether f is part of the sample set with WithSample.
+func sampled(f archives.FileInfo, o *options) bool {
+	if !o.sample {
+		return true
+	}
+	if f.IsDir() {
+		return false
+	}
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], o.sampleSeed)
+	h.Write(seed[:])
+	h.Write([]byte(f.NameInArchive))
+	// FNV mixes the last bytes poorly, names often differ only there
+	x := h.Sum64()
+	x = (x ^ x>>33) * 0xff51afd7ed558ccd
+	x = (x ^ x>>33) * 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	// spread over [0, 100)
+	return float64(x>>11)/(1<<53)*100 < o.samplePercent
+}
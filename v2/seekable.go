@@ -0,0 +1,357 @@
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// seekTableMagic starts the skippable frame holding the seek table
+	seekTableMagic = 0x184D2A5E
+	// seekableMagic ends the seek table
+	seekableMagic = 0x8F92EAB1
+	// seekableFooterSize is the size of the footer of the seek table: the
+	// number of frames, the descriptor and seekableMagic
+	seekableFooterSize = 9
+	// seekableFrameSize is the uncompressed size of each frame, reading at
+	// any offset decompresses at most this much more than needed
+	seekableFrameSize = 2 << 20
+)
+
+// WithSeekable writes zstd compressed archives in the seekable format, see
+// Seekable. Other compressions fail.
+func WithSeekable() Option {
+	return func(o *options) {
+		o.seekable = true
+	}
+}
+
+// Seekable wraps a zstd compression so its output is the seekable zstd
+// format: independent frames of 2 MiB of input each, followed by a seek
+// table in a skippable frame that gives the offsets of the frames. Every
+// zstd decompressor reads it as a single stream, while ExtractEntry and
+// OpenArchiveFS use the table to decompress only the frames holding the
+// tar headers and the content they need, instead of the whole archive
+// before it.
+func Seekable(compression archives.Compression) (archives.Compression, error) {
+	switch compression.(type) {
+	case archives.Zstd:
+		return seekableCompression{Compression: compression}, nil
+	case seekableCompression:
+		return compression, nil
+	}
+	return nil, fmt.Errorf("seekable output requires zstd compression, not %T", compression)
+}
+
+// seekableFormat applies Seekable to the compression of format.
+func seekableFormat(format archives.Archiver) (archives.Archiver, error) {
+	compressed, ok := format.(archives.CompressedArchive)
+	if !ok || compressed.Compression == nil {
+		return nil, fmt.Errorf("seekable output requires zstd compression")
+	}
+	compression, err := Seekable(compressed.Compression)
+	if err != nil {
+		return nil, err
+	}
+	compressed.Compression = compression
+	return compressed, nil
+}
+
+// seekableCompression compresses each frame on its own.
+type seekableCompression struct {
+	archives.Compression
+}
+
+func (c seekableCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	return &seekableWriter{compression: c.Compression, w: w}, nil
+}
+
+// seekableWriter buffers the input of a frame, and writes the frame once it
+// has seekableFrameSize bytes. The seek table is written on Close.
+type seekableWriter struct {
+	compression archives.Compression
+	w           io.Writer
+	buf         []byte
+	frame       bytes.Buffer
+	// entries of the seek table: compressed and uncompressed size of each
+	// frame
+	table  []byte
+	frames uint32
+}
+
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), seekableFrameSize-len(s.buf))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(s.buf) == seekableFrameSize {
+			if err := s.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffered input as a frame.
+func (s *seekableWriter) flush() error {
+	s.frame.Reset()
+	cw, err := s.compression.OpenWriter(&s.frame)
+	if err != nil {
+		return err
+	}
+	if _, err := cw.Write(s.buf); err != nil {
+		cw.Close()
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(s.frame.Bytes()); err != nil {
+		return err
+	}
+	s.table = binary.LittleEndian.AppendUint32(s.table, uint32(s.frame.Len()))
+	s.table = binary.LittleEndian.AppendUint32(s.table, uint32(len(s.buf)))
+	s.frames++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *seekableWriter) Close() error {
+	if len(s.buf) > 0 || s.frames == 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(s.table)+seekableFooterSize))
+	frame = append(frame, s.table...)
+	frame = binary.LittleEndian.AppendUint32(frame, s.frames)
+	// no checksums in the table, each frame has its own
+	frame = append(frame, 0)
+	frame = binary.LittleEndian.AppendUint32(frame, seekableMagic)
+	_, err := s.w.Write(frame)
+	return err
+}
+
+// seekableFrame is an entry of the seek table, with the offsets of the
+// frame in the compressed and the decompressed stream.
+type seekableFrame struct {
+	offset, start int64
+	size, length  uint32
+}
+
+// seekableReader reads the decompressed stream of a seekable zstd file at
+// any offset, decompressing only the frames that hold it. It keeps the last
+// frame it decompressed.
+type seekableReader struct {
+	r       io.ReaderAt
+	frames  []seekableFrame
+	size    int64
+	decoder *zstd.Decoder
+
+	mu     sync.Mutex
+	cached int
+	frame  []byte
+	// position of Read and Seek
+	pos int64
+}
+
+// openSeekable returns a reader of the decompressed stream of the seekable
+// zstd file r of size bytes, nil if r has no seek table. Close releases the
+// decoder.
+func openSeekable(r io.ReaderAt, size int64) (*seekableReader, error) {
+	footer := make([]byte, seekableFooterSize)
+	if size < 8+seekableFooterSize {
+		return nil, nil
+	}
+	if _, err := r.ReadAt(footer, size-seekableFooterSize); err != nil {
+		return nil, fmt.Errorf("read seek table: %w", err)
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, nil
+	}
+	entrySize := int64(8)
+	if footer[4]&0x80 != 0 {
+		// with the checksums of the frames, zstd checks those it has
+		entrySize = 12
+	}
+	count := int64(binary.LittleEndian.Uint32(footer))
+	tableSize := count*entrySize + seekableFooterSize
+	if 8+tableSize > size {
+		return nil, errors.New("malformed seek table: too many frames")
+	}
+	table := make([]byte, 8+tableSize)
+	if _, err := r.ReadAt(table, size-int64(len(table))); err != nil {
+		return nil, fmt.Errorf("read seek table: %w", err)
+	}
+	if binary.LittleEndian.Uint32(table) != seekTableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, errors.New("malformed seek table")
+	}
+
+	s := &seekableReader{r: r, frames: make([]seekableFrame, count), cached: -1}
+	var offset int64
+	for i := range s.frames {
+		entry := table[8+int64(i)*entrySize:]
+		frame := seekableFrame{
+			offset: offset,
+			start:  s.size,
+			size:   binary.LittleEndian.Uint32(entry),
+			length: binary.LittleEndian.Uint32(entry[4:]),
+		}
+		s.frames[i] = frame
+		offset += int64(frame.size)
+		s.size += int64(frame.length)
+	}
+	if offset != size-int64(len(table)) {
+		return nil, fmt.Errorf("malformed seek table: frames of %d bytes before a table at %d", offset, size-int64(len(table)))
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	s.decoder = decoder
+	return s, nil
+}
+
+// Size returns the size of the decompressed stream.
+func (s *seekableReader) Size() int64 {
+	return s.size
+}
+
+func (s *seekableReader) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= s.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(s.frames), func(i int) bool {
+			return s.frames[i].start+int64(s.frames[i].length) > pos
+		})
+		if err := s.load(i); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], s.frame[pos-s.frames[i].start:])
+	}
+	return n, nil
+}
+
+// load decompresses frame i, unless it is the cached one.
+func (s *seekableReader) load(i int) error {
+	if s.cached == i {
+		return nil
+	}
+	frame := s.frames[i]
+	compressed := make([]byte, frame.size)
+	if _, err := s.r.ReadAt(compressed, frame.offset); err != nil {
+		return fmt.Errorf("read frame %d: %w", i, err)
+	}
+	data, err := s.decoder.DecodeAll(compressed, s.frame[:0])
+	if err != nil {
+		return fmt.Errorf("decompress frame %d: %w", i, err)
+	}
+	if len(data) != int(frame.length) {
+		return fmt.Errorf("frame %d has %d bytes, the seek table says %d", i, len(data), frame.length)
+	}
+	s.frame, s.cached = data, i
+	return nil
+}
+
+func (s *seekableReader) Read(p []byte) (int, error) {
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *seekableReader) Close() error {
+	s.decoder.Close()
+	return nil
+}
+
+// seekableTar returns the extractor and decompressed stream of a tar.zst in
+// the seekable format, whose tar headers are read by seeking over the
+// content of the entries in between. Other archives are returned as they
+// are.
+func seekableTar(format archives.Format, extractor archives.Extractor, input io.Reader) (archives.Extractor, io.Reader, func(), error) {
+	compressed, ok := format.(archives.CompressedArchive)
+	if !ok {
+		return extractor, input, func() {}, nil
+	}
+	_, isZstd := compressed.Compression.(archives.Zstd)
+	tar, isTar := compressed.Archival.(archives.Tar)
+	file, seekable := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !isZstd || !isTar || !seekable {
+		return extractor, input, func() {}, nil
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, nil, err
+	}
+	r, err := openSeekable(file, size)
+	if err != nil || r == nil {
+		return extractor, input, func() {}, err
+	}
+	return tar, r, func() { r.Close() }, nil
+}
+
+// openSeekableFS returns the file system of the seekable tar.zst archive,
+// nil for other archives.
+func openSeekableFS(archive string) (fs.FS, error) {
+	format, _, err := archives.Identify(context.Background(), archive, nil)
+	if err != nil {
+		return nil, nil
+	}
+	file, _, err := openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	_, r, _, err := seekableTar(format, nil, file)
+	seekable, ok := r.(*seekableReader)
+	if err != nil || !ok {
+		file.Close()
+		return nil, err
+	}
+	logging("Reading %s by its seek table", archive)
+	return &archives.ArchiveFS{Stream: io.NewSectionReader(seekable, 0, seekable.Size()), Format: archives.Tar{}}, nil
+}
@@ -0,0 +1,364 @@
+//go:build !arc_no_7z && !arc_minimal
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// SevenZip is the 7z format. mholt/archives only reads 7z archives, arc
+// writes them too, as a single solid LZMA2 stream like 7-Zip does by default.
+// Symlinks are stored with their target as content and the Unix mode in the
+// high bits of the attributes, like p7zip, and read back as symlinks.
+type SevenZip struct {
+	archives.SevenZip
+	// DictCap is the size of the LZMA2 dictionary, 8 MiB if 0. Larger
+	// dictionaries compress large trees better, readers need as much memory
+	// to extract them.
+	DictCap int
+}
+
+// 7z property IDs, see 7zFormat.txt of the 7-Zip sources
+const (
+	sevenZipEnd             = 0x00
+	sevenZipHeader          = 0x01
+	sevenZipMainStreamsInfo = 0x04
+	sevenZipFilesInfo       = 0x05
+	sevenZipPackInfo        = 0x06
+	sevenZipUnpackInfo      = 0x07
+	sevenZipSubStreamsInfo  = 0x08
+	sevenZipSize            = 0x09
+	sevenZipCRC             = 0x0a
+	sevenZipFolder          = 0x0b
+	sevenZipCodersUnpack    = 0x0c
+	sevenZipNumUnpackStream = 0x0d
+	sevenZipEmptyStream     = 0x0e
+	sevenZipEmptyFile       = 0x0f
+	sevenZipName            = 0x11
+	sevenZipMTime           = 0x14
+	sevenZipWinAttributes   = 0x15
+
+	// the method ID of LZMA2
+	sevenZipLZMA2 = 0x21
+	// FILE_ATTRIBUTE_UNIX_EXTENSION, the Unix mode is in the high 16 bits
+	sevenZipUnixExtension = 0x8000
+	sevenZipDirectory     = 0x10
+	sevenZipReadOnly      = 0x01
+	// 100ns intervals between 1601-01-01, the epoch of FILETIME, and 1970-01-01
+	fileTimeEpoch = 116444736000000000
+)
+
+var sevenZipSignature = []byte("7z\xbc\xaf\x27\x1c")
+
+// sevenZipEntry is what the header of a 7z archive records of a file.
+type sevenZipEntry struct {
+	name       string
+	size       int64
+	crc        uint32
+	dir        bool
+	modTime    time.Time
+	attributes uint32
+}
+
+// Archive writes the files to output as a 7z archive. The end of the archive
+// has to be known before its start is written, the compressed content is
+// buffered in a temp file until then.
+func (z SevenZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	spool, err := os.CreateTemp("", "arc-*.7z")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	dictCap := z.DictCap
+	if dictCap == 0 {
+		dictCap = 8 << 20
+	}
+	packed := &countWriter{w: spool}
+	compressor, err := lzma.Writer2Config{DictCap: dictCap}.NewWriter2(packed)
+	if err != nil {
+		return fmt.Errorf("lzma2: %w", err)
+	}
+	entries := make([]sevenZipEntry, 0, len(files))
+	var unpacked int64
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := writeSevenZipEntry(ctx, compressor, f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.NameInArchive, err)
+		}
+		if entry.name == "" {
+			continue
+		}
+		unpacked += entry.size
+		entries = append(entries, entry)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("lzma2: %w", err)
+	}
+
+	// without content, the stream is left out, and without entries the
+	// header too, like 7-Zip does
+	var header bytes.Buffer
+	if unpacked == 0 {
+		packed.n = 0
+	}
+	if len(entries) > 0 {
+		writeSevenZipHeader(&header, entries, packed.n, unpacked, lzma.EncodeDictCap(int64(dictCap)))
+	}
+	start := make([]byte, 32)
+	copy(start, sevenZipSignature)
+	start[7] = 4
+	binary.LittleEndian.PutUint64(start[12:], uint64(packed.n))
+	binary.LittleEndian.PutUint64(start[20:], uint64(header.Len()))
+	if header.Len() > 0 {
+		binary.LittleEndian.PutUint32(start[28:], crc32.ChecksumIEEE(header.Bytes()))
+	}
+	binary.LittleEndian.PutUint32(start[8:], crc32.ChecksumIEEE(start[12:]))
+
+	if _, err := output.Write(start); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, io.LimitReader(ctxReader{Reader: spool, ctx: ctx}, packed.n)); err != nil {
+		return err
+	}
+	_, err = output.Write(header.Bytes())
+	return err
+}
+
+// writeSevenZipEntry writes the content of f to w, and returns its entry.
+// Entries without a name, like the root of the archive, are left out.
+func writeSevenZipEntry(ctx context.Context, w io.Writer, f archives.FileInfo) (sevenZipEntry, error) {
+	entry := sevenZipEntry{
+		name:       strings.Trim(f.NameInArchive, "/"),
+		dir:        f.IsDir(),
+		modTime:    f.ModTime(),
+		attributes: sevenZipAttributes(f.Mode()),
+	}
+	if entry.name == "" {
+		return entry, nil
+	}
+	hash := crc32.NewIEEE()
+	switch {
+	case f.IsDir():
+		return entry, nil
+	case f.Mode()&fs.ModeSymlink != 0:
+		entry.size = int64(len(f.LinkTarget))
+		if _, err := io.WriteString(io.MultiWriter(w, hash), f.LinkTarget); err != nil {
+			return entry, err
+		}
+	case f.Mode().IsRegular():
+		file, err := f.Open()
+		if err != nil {
+			return entry, err
+		}
+		defer file.Close()
+		if entry.size, err = io.Copy(io.MultiWriter(w, hash), ctxReader{Reader: file, ctx: ctx}); err != nil {
+			return entry, err
+		}
+	default:
+		logging("Skipping %s, 7z archives only hold files, directories and symlinks", f.NameInArchive)
+		entry.name = ""
+		return entry, nil
+	}
+	entry.crc = hash.Sum32()
+	return entry, nil
+}
+
+// sevenZipAttributes returns the attributes of a file of mode, with the
+// Unix mode in the high bits.
+func sevenZipAttributes(mode fs.FileMode) uint32 {
+	attributes := uint32(sevenZipUnixExtension)
+	if mode.IsDir() {
+		attributes |= sevenZipDirectory
+	}
+	if mode.Perm()&0o222 == 0 {
+		attributes |= sevenZipReadOnly
+	}
+	return attributes | unixMode(mode)<<16
+}
+
+// writeSevenZipHeader writes the header of a 7z archive of entries, whose
+// content is a single LZMA2 stream of packSize bytes, unpackSize
+// uncompressed.
+func writeSevenZipHeader(b *bytes.Buffer, entries []sevenZipEntry, packSize, unpackSize int64, dictCap byte) {
+	b.WriteByte(sevenZipHeader)
+	var streams []sevenZipEntry
+	emptyStream := make([]bool, len(entries))
+	var emptyFile []bool
+	for i, e := range entries {
+		if e.size == 0 {
+			emptyStream[i] = true
+			emptyFile = append(emptyFile, !e.dir)
+		} else {
+			streams = append(streams, e)
+		}
+	}
+
+	if len(streams) > 0 {
+		b.WriteByte(sevenZipMainStreamsInfo)
+
+		b.WriteByte(sevenZipPackInfo)
+		writeSevenZipNumber(b, 0)
+		writeSevenZipNumber(b, 1)
+		b.WriteByte(sevenZipSize)
+		writeSevenZipNumber(b, uint64(packSize))
+		b.WriteByte(sevenZipEnd)
+
+		b.WriteByte(sevenZipUnpackInfo)
+		b.WriteByte(sevenZipFolder)
+		writeSevenZipNumber(b, 1)
+		b.WriteByte(0) // not external
+		// one coder, one byte of method ID with properties
+		writeSevenZipNumber(b, 1)
+		b.Write([]byte{0x20 | 1, sevenZipLZMA2})
+		writeSevenZipNumber(b, 1)
+		b.WriteByte(dictCap)
+		b.WriteByte(sevenZipCodersUnpack)
+		writeSevenZipNumber(b, uint64(unpackSize))
+		b.WriteByte(sevenZipEnd)
+
+		b.WriteByte(sevenZipSubStreamsInfo)
+		b.WriteByte(sevenZipNumUnpackStream)
+		writeSevenZipNumber(b, uint64(len(streams)))
+		b.WriteByte(sevenZipSize)
+		for _, e := range streams[:len(streams)-1] {
+			writeSevenZipNumber(b, uint64(e.size))
+		}
+		b.WriteByte(sevenZipCRC)
+		b.WriteByte(1) // all defined
+		for _, e := range streams {
+			binary.Write(b, binary.LittleEndian, e.crc)
+		}
+		b.WriteByte(sevenZipEnd)
+
+		b.WriteByte(sevenZipEnd)
+	}
+
+	if len(entries) > 0 {
+		b.WriteByte(sevenZipFilesInfo)
+		writeSevenZipNumber(b, uint64(len(entries)))
+		if len(streams) < len(entries) {
+			writeSevenZipProperty(b, sevenZipEmptyStream, sevenZipBits(emptyStream))
+			writeSevenZipProperty(b, sevenZipEmptyFile, sevenZipBits(emptyFile))
+		}
+
+		var names bytes.Buffer
+		names.WriteByte(0) // not external
+		for _, e := range entries {
+			for _, c := range utf16.Encode([]rune(e.name)) {
+				binary.Write(&names, binary.LittleEndian, c)
+			}
+			names.Write([]byte{0, 0})
+		}
+		writeSevenZipProperty(b, sevenZipName, names.Bytes())
+
+		times := bytes.NewBuffer([]byte{1, 0}) // all defined, not external
+		attributes := bytes.NewBuffer([]byte{1, 0})
+		for _, e := range entries {
+			binary.Write(times, binary.LittleEndian, uint64(e.modTime.UnixNano()/100+fileTimeEpoch))
+			binary.Write(attributes, binary.LittleEndian, e.attributes)
+		}
+		writeSevenZipProperty(b, sevenZipMTime, times.Bytes())
+		writeSevenZipProperty(b, sevenZipWinAttributes, attributes.Bytes())
+		b.WriteByte(sevenZipEnd)
+	}
+	b.WriteByte(sevenZipEnd)
+}
+
+// writeSevenZipProperty writes the property id with its data.
+func writeSevenZipProperty(b *bytes.Buffer, id byte, data []byte) {
+	b.WriteByte(id)
+	writeSevenZipNumber(b, uint64(len(data)))
+	b.Write(data)
+}
+
+// writeSevenZipNumber writes n in the variable length encoding of 7z: the
+// leading one bits of the first byte count the bytes that follow, little
+// endian, the rest of the first byte holds the highest bits.
+func writeSevenZipNumber(b *bytes.Buffer, n uint64) {
+	extra := 0
+	for extra < 8 && n >= 1<<(7*(extra+1)) {
+		extra++
+	}
+	first := byte(0xff << (8 - extra))
+	if extra < 8 {
+		first |= byte(n >> (8 * extra))
+	}
+	b.WriteByte(first)
+	for i := 0; i < extra; i++ {
+		b.WriteByte(byte(n >> (8 * i)))
+	}
+}
+
+// sevenZipBits packs bits, the first in the highest bit of the first byte.
+func sevenZipBits(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return packed
+}
+
+// Extract calls handleFile for each entry of the 7z archive, with the
+// target of symlinks, which mholt/archives leaves as their content. Empty
+// archives, which have no header at all, have no entries.
+func (z SevenZip) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	if at, ok := archive.(io.ReaderAt); ok {
+		start := make([]byte, 32)
+		if n, _ := at.ReadAt(start, 0); n == len(start) && bytes.HasPrefix(start, sevenZipSignature) &&
+			binary.LittleEndian.Uint64(start[20:]) == 0 {
+			return nil
+		}
+	}
+	return z.SevenZip.Extract(ctx, archive, func(ctx context.Context, f archives.FileInfo) error {
+		if f.Mode()&fs.ModeSymlink != 0 && f.LinkTarget == "" {
+			target, err := readLinkTarget(f)
+			if err != nil {
+				return err
+			}
+			f.LinkTarget = target
+		}
+		return handleFile(ctx, f)
+	})
+}
+
+// readLinkTarget returns the content of the symlink f, its target.
+func readLinkTarget(f archives.FileInfo) (string, error) {
+	file, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	target, err := io.ReadAll(io.LimitReader(file, 4096))
+	if err != nil {
+		return "", fmt.Errorf("read symlink %s: %w", f.NameInArchive, err)
+	}
+	return string(target), nil
+}
+
+// Interface guard
+var _ archives.Archival = SevenZip{}
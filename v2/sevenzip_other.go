@@ -0,0 +1,22 @@
+//go:build arc_no_7z || arc_minimal
+
+package arc
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// SevenZip stands in for the 7z format left out of this build, see
+// knownFormats: it is neither written nor read.
+type SevenZip struct {
+	archives.SevenZip
+	DictCap int
+}
+
+func (SevenZip) Archive(context.Context, io.Writer, []archives.FileInfo) error {
+	return fmt.Errorf("7z (built with arc_no_7z or arc_minimal): %w", ErrCompressionUnavailable)
+}
@@ -0,0 +1,181 @@
+package arc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sfxMagic ends the footer of a self-extracting executable, its last byte
+// is the version.
+const sfxMagic = "ARCSFX\x00\x01"
+
+// sfxFooterSize is the length of the footer of a self-extracting
+// executable: the length of its config and of its archive, followed by the
+// 8 bytes of sfxMagic.
+const sfxFooterSize = 4 + 8 + 8
+
+// ErrNotSelfExtracting is returned by OpenSelfExtractor for executables
+// without an embedded archive.
+var ErrNotSelfExtracting = errors.New("no embedded archive")
+
+// SelfExtractConfig is what a self-extracting executable does when run
+// without flags.
+type SelfExtractConfig struct {
+	// Name is the file name of the embedded archive, its extension tells
+	// the format
+	Name string `json:"name"`
+	// Destination is the directory to extract to, relative to the working
+	// directory, "" for the working directory
+	Destination string `json:"destination,omitempty"`
+	// PostCommand is a shell command run in Destination once extracted,
+	// like ./install.sh
+	PostCommand string `json:"post_command,omitempty"`
+}
+
+// CreateSelfExtractor writes to outfile an executable that extracts archive
+// when run, made of stub, an extractor built for the target GOOS and
+// GOARCH like cmd/arc-sfx, followed by archive and config. Split volumes
+// are joined and a checksum trailer verified and left out, the embedded
+// archive is a single stream.
+// stub: the extractor executable
+// archive: the archive to embed
+// outfile: the executable to create
+// config: what the executable does by default, its Name is set from archive
+// if empty
+func CreateSelfExtractor(stub, archive, outfile string, config SelfExtractConfig) error {
+	logging("Creating self-extracting %s from %s and %s", outfile, stub, archive)
+	if config.Name == "" {
+		config.Name = filepath.Base(archive)
+	}
+	if _, _, err := FormatFromName(config.Name); err != nil {
+		return fmt.Errorf("self-extracting archive: %w", err)
+	}
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	stubFile, err := os.Open(stub)
+	if err != nil {
+		return fmt.Errorf("open stub: %w", err)
+	}
+	defer stubFile.Close()
+	if s, err := readSelfExtractor(stubFile); err == nil {
+		return fmt.Errorf("stub %s already embeds %s", stub, s.Config.Name)
+	}
+	archiveFile, _, err := openArchive(archive)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+
+	out, err := os.OpenFile(outfile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outfile, err)
+	}
+	if _, err := io.Copy(out, stubFile); err != nil {
+		out.Close()
+		return fmt.Errorf("write stub: %w", err)
+	}
+	size, err := io.Copy(out, archiveFile)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("write archive: %w", err)
+	}
+	footer := binary.BigEndian.AppendUint32(nil, uint32(len(configData)))
+	footer = binary.BigEndian.AppendUint64(footer, uint64(size))
+	footer = append(footer, sfxMagic...)
+	if _, err := out.Write(append(configData, footer...)); err != nil {
+		out.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", outfile, err)
+	}
+	logging("Embedded %d bytes of %s in %s", size, config.Name, outfile)
+	return nil
+}
+
+// SelfExtractor is the archive embedded in a self-extracting executable,
+// see CreateSelfExtractor.
+type SelfExtractor struct {
+	Config SelfExtractConfig
+
+	file    *os.File
+	archive *io.SectionReader
+}
+
+// OpenSelfExtractor opens the archive embedded in the executable exe, like
+// the one returned by os.Executable. Executables without one return an
+// error wrapping ErrNotSelfExtracting.
+func OpenSelfExtractor(exe string) (*SelfExtractor, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readSelfExtractor(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", exe, err)
+	}
+	return s, nil
+}
+
+// readSelfExtractor reads the footer and config at the end of f.
+func readSelfExtractor(f *os.File) (*SelfExtractor, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < sfxFooterSize {
+		return nil, ErrNotSelfExtracting
+	}
+	footer := make([]byte, sfxFooterSize)
+	if _, err := f.ReadAt(footer, info.Size()-sfxFooterSize); err != nil {
+		return nil, err
+	}
+	if string(footer[12:]) != sfxMagic {
+		return nil, ErrNotSelfExtracting
+	}
+	configSize := int64(binary.BigEndian.Uint32(footer))
+	archiveSize := int64(binary.BigEndian.Uint64(footer[4:]))
+	configOffset := info.Size() - sfxFooterSize - configSize
+	archiveOffset := configOffset - archiveSize
+	if configSize < 0 || archiveSize < 0 || archiveOffset < 0 {
+		return nil, errors.New("corrupt self-extractor footer")
+	}
+
+	configData := make([]byte, configSize)
+	if _, err := f.ReadAt(configData, configOffset); err != nil {
+		return nil, err
+	}
+	s := &SelfExtractor{file: f, archive: io.NewSectionReader(f, archiveOffset, archiveSize)}
+	if err := json.Unmarshal(configData, &s.Config); err != nil {
+		return nil, fmt.Errorf("corrupt self-extractor config: %w", err)
+	}
+	return s, nil
+}
+
+// Archive returns the embedded archive.
+func (s *SelfExtractor) Archive() *io.SectionReader {
+	return io.NewSectionReader(s.archive, 0, s.archive.Size())
+}
+
+// Extract extracts the embedded archive to dst, like Unarchive.
+// dst: the destination directory, usually Config.Destination
+// opts: optional settings, see Option
+func (s *SelfExtractor) Extract(dst string, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Extracting embedded %s to %s", s.Config.Name, dst)
+	return unarchiveStream(s.Config.Name, s.Archive(), dst, o)
+}
+
+// Close closes the executable.
+func (s *SelfExtractor) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,369 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Signatures are compatible with minisign (https://jedisct1.github.io/minisign/):
+// keys created by minisign -G can be used with Sign, and signatures created by
+// Sign can be checked with minisign -V, and vice versa.
+const (
+	// SignatureExt is appended to the archive name to get the signature file.
+	SignatureExt = ".minisig"
+
+	sigAlgPure   = "Ed" // signature over the file contents
+	sigAlgHashed = "ED" // signature over the BLAKE2b-512 of the file
+	kdfAlgScrypt = "Sc"
+	chkAlgBlake2 = "B2"
+
+	// minisign defaults for encrypted secret keys
+	kdfOpsLimit = 1 << 25
+	kdfMemLimit = 1 << 30
+
+	keyIDSize  = 8
+	kdfSaltLen = 32
+	// key id + secret key + checksum, encrypted as a whole
+	secretKeyDataLen = keyIDSize + ed25519.PrivateKeySize + blake2b.Size256
+)
+
+// minisignKey is a parsed minisign public or secret key.
+type minisignKey struct {
+	id   [keyIDSize]byte
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+// idString formats the key id the way minisign prints it.
+func (k *minisignKey) idString() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id[:]))
+}
+
+// GenerateSigningKey creates a minisign-compatible key pair. The secret key is
+// encrypted with password unless password is empty.
+func GenerateSigningKey(secKeyFile, pubKeyFile, password string) error {
+	logging("Generating signing key pair: %s, %s", secKeyFile, pubKeyFile)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	key := &minisignKey{pub: pub, priv: priv}
+	if _, err := rand.Read(key.id[:]); err != nil {
+		return fmt.Errorf("generate key id: %w", err)
+	}
+
+	var secret bytes.Buffer
+	secret.WriteString(sigAlgPure)
+	kdfAlg := []byte(kdfAlgScrypt)
+	if password == "" {
+		kdfAlg = []byte{0, 0}
+	}
+	secret.Write(kdfAlg)
+	secret.WriteString(chkAlgBlake2)
+	salt := make([]byte, kdfSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generate salt: %w", err)
+	}
+	secret.Write(salt)
+	binary.Write(&secret, binary.LittleEndian, uint64(kdfOpsLimit))
+	binary.Write(&secret, binary.LittleEndian, uint64(kdfMemLimit))
+
+	data := make([]byte, 0, secretKeyDataLen)
+	data = append(data, key.id[:]...)
+	data = append(data, priv...)
+	data = append(data, secretKeyChecksum(key)...)
+	if password != "" {
+		if err := xorScrypt(data, password, salt, kdfOpsLimit, kdfMemLimit); err != nil {
+			return err
+		}
+	}
+	secret.Write(data)
+
+	secComment := "minisign encrypted secret key"
+	if password == "" {
+		secComment = "minisign secret key"
+	}
+	secContent := fmt.Sprintf("untrusted comment: %s\n%s\n", secComment, base64.StdEncoding.EncodeToString(secret.Bytes()))
+	if err := os.WriteFile(secKeyFile, []byte(secContent), 0o600); err != nil {
+		return fmt.Errorf("write secret key: %w", err)
+	}
+
+	public := append([]byte(sigAlgPure), key.id[:]...)
+	public = append(public, pub...)
+	pubContent := fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", key.idString(), base64.StdEncoding.EncodeToString(public))
+	if err := os.WriteFile(pubKeyFile, []byte(pubContent), 0o644); err != nil {
+		return fmt.Errorf("write public key: %w", err)
+	}
+	return nil
+}
+
+// Sign creates a detached signature of archive at archive + SignatureExt with
+// the unencrypted minisign secret key in keyFile.
+func Sign(archive, keyFile string) error {
+	return SignWithPassword(archive, keyFile, "")
+}
+
+// SignWithPassword creates a detached signature of archive at
+// archive + SignatureExt with the minisign secret key in keyFile, which is
+// decrypted with password.
+func SignWithPassword(archive, keyFile, password string) error {
+	logging("Signing %s with key %s", archive, keyFile)
+	key, err := readSecretKey(keyFile, password)
+	if err != nil {
+		return err
+	}
+
+	digest, err := hashFile(archive)
+	if err != nil {
+		return err
+	}
+	signature := ed25519.Sign(key.priv, digest)
+
+	trustedComment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(archive))
+	globalSignature := ed25519.Sign(key.priv, append(append([]byte{}, signature...), trustedComment...))
+
+	sigData := append([]byte(sigAlgHashed), key.id[:]...)
+	sigData = append(sigData, signature...)
+	content := fmt.Sprintf("untrusted comment: signature from arc secret key %s\n%s\ntrusted comment: %s\n%s\n",
+		key.idString(),
+		base64.StdEncoding.EncodeToString(sigData),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature))
+
+	sigFile := archive + SignatureExt
+	if err := os.WriteFile(sigFile, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("write signature %s: %w", sigFile, err)
+	}
+	logging("Signature written to %s", sigFile)
+	return nil
+}
+
+// VerifySignature checks the detached signature in sigFile against archive.
+// If sigFile is empty, archive + SignatureExt is used. pubKey is either the
+// path to a minisign public key file or the base64 encoded key itself.
+func VerifySignature(archive, sigFile, pubKey string) error {
+	if sigFile == "" {
+		sigFile = archive + SignatureExt
+	}
+	logging("Verifying signature %s of %s", sigFile, archive)
+	key, err := readPublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(sigFile)
+	if err != nil {
+		return fmt.Errorf("read signature %s: %w", sigFile, err)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("malformed signature file %s", sigFile)
+	}
+	sigData, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sigData) != 2+keyIDSize+ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature in %s", sigFile)
+	}
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed global signature in %s", sigFile)
+	}
+
+	alg := string(sigData[:2])
+	if subtle.ConstantTimeCompare(sigData[2:2+keyIDSize], key.id[:]) != 1 {
+		return fmt.Errorf("signature was created with a different key than %s", key.idString())
+	}
+	signature := sigData[2+keyIDSize:]
+
+	var message []byte
+	switch alg {
+	case sigAlgHashed:
+		message, err = hashFile(archive)
+	case sigAlgPure:
+		message, err = os.ReadFile(archive)
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key.pub, message, signature) {
+		return fmt.Errorf("signature verification failed for %s", archive)
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key.pub, append(append([]byte{}, signature...), trustedComment...), globalSignature) {
+		return fmt.Errorf("trusted comment verification failed for %s", archive)
+	}
+	logging("Signature of %s verified, trusted comment: %s", archive, trustedComment)
+	return nil
+}
+
+// hashFile returns the BLAKE2b-512 of a file, as signed by minisign.
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("hash %s: %w", name, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// readKeyData decodes the base64 line following the untrusted comment.
+func readKeyData(r io.Reader) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no key found")
+}
+
+// readPublicKey parses a minisign public key file, or a base64 encoded key.
+func readPublicKey(pubKey string) (*minisignKey, error) {
+	var data []byte
+	var err error
+	if isExist(pubKey) {
+		f, openErr := os.Open(pubKey)
+		if openErr != nil {
+			return nil, fmt.Errorf("open public key %s: %w", pubKey, openErr)
+		}
+		defer f.Close()
+		data, err = readKeyData(f)
+	} else {
+		data, err = base64.StdEncoding.DecodeString(pubKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	if len(data) != 2+keyIDSize+ed25519.PublicKeySize || string(data[:2]) != sigAlgPure {
+		return nil, errors.New("parse public key: not a minisign ed25519 public key")
+	}
+
+	key := &minisignKey{pub: ed25519.PublicKey(data[2+keyIDSize:])}
+	copy(key.id[:], data[2:2+keyIDSize])
+	return key, nil
+}
+
+// readSecretKey parses and, if needed, decrypts a minisign secret key file.
+func readSecretKey(keyFile, password string) (*minisignKey, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("open secret key %s: %w", keyFile, err)
+	}
+	defer f.Close()
+
+	data, err := readKeyData(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse secret key: %w", err)
+	}
+	const headerLen = 2 + 2 + 2 + kdfSaltLen + 8 + 8
+	if len(data) != headerLen+secretKeyDataLen || string(data[:2]) != sigAlgPure || string(data[4:6]) != chkAlgBlake2 {
+		return nil, errors.New("parse secret key: not a minisign ed25519 secret key")
+	}
+
+	kdfAlg := data[2:4]
+	salt := data[6 : 6+kdfSaltLen]
+	opsLimit := binary.LittleEndian.Uint64(data[6+kdfSaltLen:])
+	memLimit := binary.LittleEndian.Uint64(data[6+kdfSaltLen+8:])
+	secret := append([]byte{}, data[headerLen:]...)
+
+	switch {
+	case string(kdfAlg) == kdfAlgScrypt:
+		if password == "" {
+			return nil, errors.New("secret key is encrypted, a password is required")
+		}
+		if err := xorScrypt(secret, password, salt, opsLimit, memLimit); err != nil {
+			return nil, err
+		}
+	case kdfAlg[0] != 0 || kdfAlg[1] != 0:
+		return nil, fmt.Errorf("unsupported key derivation algorithm %q", kdfAlg)
+	}
+
+	key := &minisignKey{priv: ed25519.PrivateKey(secret[keyIDSize : keyIDSize+ed25519.PrivateKeySize])}
+	copy(key.id[:], secret[:keyIDSize])
+	key.pub = key.priv.Public().(ed25519.PublicKey)
+	if subtle.ConstantTimeCompare(secretKeyChecksum(key), secret[keyIDSize+ed25519.PrivateKeySize:]) != 1 {
+		return nil, errors.New("wrong password for secret key")
+	}
+	return key, nil
+}
+
+// secretKeyChecksum computes the checksum minisign stores with secret keys.
+func secretKeyChecksum(key *minisignKey) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(sigAlgPure))
+	h.Write(key.id[:])
+	h.Write(key.priv)
+	return h.Sum(nil)
+}
+
+// xorScrypt encrypts or decrypts data in place with a scrypt key stream,
+// using the parameters libsodium derives from opsLimit and memLimit.
+func xorScrypt(data []byte, password string, salt []byte, opsLimit, memLimit uint64) error {
+	nLog2, r, p := scryptParams(opsLimit, memLimit)
+	stream, err := scrypt.Key([]byte(password), salt, 1<<nLog2, r, p, len(data))
+	if err != nil {
+		return fmt.Errorf("derive key: %w", err)
+	}
+	for i := range data {
+		data[i] ^= stream[i]
+	}
+	return nil
+}
+
+// scryptParams mirrors pickparams() of libsodium's crypto_pwhash_scryptsalsa208sha256.
+func scryptParams(opsLimit, memLimit uint64) (nLog2 uint, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	r = 8
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / uint64(r*4)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+		return nLog2, r, p
+	}
+
+	maxN := memLimit / uint64(r*128)
+	for nLog2 = 1; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+	maxRP := (opsLimit / 4) / (uint64(1) << nLog2)
+	if maxRP > 0x3fffffff {
+		maxRP = 0x3fffffff
+	}
+	p = int(maxRP) / r
+	return nLog2, r, p
+}
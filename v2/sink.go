@@ -0,0 +1,166 @@
+package arc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Sink receives the entries of an archive being extracted, so archives can be
+// unpacked into object storage, a database or an in-memory file system
+// instead of the local disk.
+//
+// Names are slash-separated and relative to the root of the archive, with
+// "." and ".." elements already resolved; they never point outside of it.
+// Parent directories are not guaranteed to be created before their entries.
+type Sink interface {
+	// CreateDir creates the directory name and any missing parents
+	CreateDir(name string, mode fs.FileMode) error
+	// CreateFile returns a writer for the content of the regular file name,
+	// it is closed once the whole content has been written
+	CreateFile(name string, mode fs.FileMode) (io.WriteCloser, error)
+	// Symlink creates name as a symbolic link to target
+	Symlink(name, target string) error
+}
+
+// UnarchiveToSink extracts every entry of archive into sink, hardlinks are
+// ignored. Options about the destination, like WithLinkFarm, only apply to
+// Unarchive.
+// archive: the archive to extract
+// sink: where the entries are written to
+// opts: optional settings, see Option
+func UnarchiveToSink(archive string, sink Sink, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Unarchiving %s to %T", archive, sink)
+	if o.verifyManifest {
+		if verifyErr := verifyManifest(archive, o.manifestFile, o); verifyErr != nil {
+			return fmt.Errorf("verify manifest: %w", verifyErr)
+		}
+	}
+
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		f, ok := rewriteEntry(f, o)
+		if !ok {
+			return nil
+		}
+		return sinkEntry(f, sink)
+	}
+	progress := newProgress(o)
+	if extractErr := extractArchive(archive, progress.handler(handler), o); extractErr != nil {
+		return fmt.Errorf("extracting files: %w", extractErr)
+	}
+	progress.done()
+
+	logging("Unarchiving completed successfully.")
+	return nil
+}
+
+// WithStripComponents removes the first n elements from the names of
+// extracted entries, like tar --strip-components, so the content of a single
+// top level directory lands directly in the destination. Entries with n or
+// fewer elements are skipped.
+func WithStripComponents(n int) Option {
+	return func(o *options) {
+		o.stripComponents = n
+	}
+}
+
+// RenameFunc returns the path an entry is extracted to, given its path in
+// the archive, or skip to leave the entry out. Paths are slash-separated and
+// relative, as for Sink.
+type RenameFunc func(entryPath string) (newPath string, skip bool)
+
+// WithRename calls rename for every extracted entry, after
+// WithStripComponents, to rewrite prefixes, flatten directories or fix
+// names for the local OS. The returned path is cleaned again, and can't
+// point outside of the destination.
+func WithRename(rename RenameFunc) Option {
+	return func(o *options) {
+		o.rename = rename
+	}
+}
+
+// rewriteEntry applies the options selecting and naming entries to f, and
+// returns false if the entry isn't to be extracted.
+func rewriteEntry(f archives.FileInfo, o *options) (archives.FileInfo, bool) {
+	if !sampled(f, o) {
+		return f, false
+	}
+	if o.stripComponents <= 0 && o.rename == nil {
+		return f, true
+	}
+	name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+	if name == "" {
+		return f, false
+	}
+
+	if o.stripComponents > 0 {
+		parts := strings.Split(name, "/")
+		if len(parts) <= o.stripComponents {
+			logging("Skipping stripped entry: %s", f.NameInArchive)
+			return f, false
+		}
+		name = strings.Join(parts[o.stripComponents:], "/")
+	}
+
+	if o.rename != nil {
+		newName, skip := o.rename(name)
+		if skip {
+			logging("Skipping renamed entry: %s", f.NameInArchive)
+			return f, false
+		}
+		if newName != name {
+			logging("Renaming entry: %s -> %s", name, newName)
+		}
+		name = strings.TrimPrefix(path.Clean("/"+newName), "/")
+		if name == "" {
+			return f, false
+		}
+	}
+
+	f.NameInArchive = name
+	return f, true
+}
+
+// sinkEntry writes a single archive entry to sink.
+func sinkEntry(f archives.FileInfo, sink Sink) error {
+	logging("Handling file: %s", f.NameInArchive)
+	name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+	if name == "" {
+		return nil
+	}
+
+	switch {
+	case f.IsDir():
+		return sink.CreateDir(name, f.Mode())
+	case f.Mode()&fs.ModeSymlink != 0:
+		return sink.Symlink(name, f.LinkTarget)
+	case f.LinkTarget != "":
+		logging("Skipping hardlink: %s -> %s", name, f.LinkTarget)
+		return nil
+	}
+
+	reader, openErr := f.Open()
+	if openErr != nil {
+		return fmt.Errorf("open file: %w", openErr)
+	}
+	defer reader.Close()
+
+	writer, createErr := sink.CreateFile(name, f.Mode())
+	if createErr != nil {
+		return fmt.Errorf("create file: %w", createErr)
+	}
+	if _, copyErr := io.Copy(writer, reader); copyErr != nil {
+		discardWriter(writer)
+		return fmt.Errorf("copy: %w", copyErr)
+	}
+	if closeErr := writer.Close(); closeErr != nil {
+		return fmt.Errorf("close file: %w", closeErr)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package arc
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// EntryInfo describes an entry produced by a Source.
+type EntryInfo struct {
+	// Path of the entry in the archive, using forward slashes
+	Name string
+	// Size of the content in bytes, must match what the reader returns
+	Size int64
+	// Permissions and type; directories need fs.ModeDir, symlinks fs.ModeSymlink
+	Mode    fs.FileMode
+	ModTime time.Time
+	// Target of a symlink
+	LinkTarget string
+}
+
+// Source produces archive entries one at a time, so archives can be built
+// from anything: database rows, API responses or generated content.
+//
+// Next returns io.EOF when there are no more entries. The returned reader may
+// be nil for directories and symlinks; otherwise it is read to the end and
+// closed before Next is called again.
+type Source interface {
+	Next() (EntryInfo, io.ReadCloser, error)
+}
+
+// SourceFunc adapts an ordinary function to the Source interface.
+type SourceFunc func() (EntryInfo, io.ReadCloser, error)
+
+// Next calls f.
+func (f SourceFunc) Next() (EntryInfo, io.ReadCloser, error) { return f() }
+
+// ArchiveFromSource archives the entries produced by src
+// src: the source of the entries
+// outfile: the output file
+// compression: the compression to use (gzip, bzip2, etc.), nil for none
+// archival: the archival to use (tar, zip, etc.)
+// opts: optional settings, see Option
+func ArchiveFromSource(src Source, outfile string, compression archives.Compression, archival archives.Archival, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Starting the archival process from source: %T", src)
+
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(outfile); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
+	// remove outfile
+	logging("Removing any existing output file: %s", outfile)
+	if err := removeOutput(outfile, o); err != nil {
+		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
+	// entries are pumped into the archiver as they are produced
+	var format archives.Archiver = archives.CompressedArchive{
+		Compression: compression,
+		Archival:    archival,
+	}
+	if _, ok := archival.(archives.ArchiverAsync); !ok {
+		return fmt.Errorf("archival %T does not support streaming from a source", archival)
+	}
+	if err := compiledIn(format.(archives.Format)); err != nil {
+		return err
+	}
+	if o.tarFormat != tar.FormatUnknown || o.xattrs {
+		withHeaders, err := tarHeaderFormat(format, o.tarFormat, nil)
+		if err != nil {
+			return err
+		}
+		format = withHeaders
+	}
+	if o.seekable {
+		seekable, err := seekableFormat(format)
+		if err != nil {
+			return err
+		}
+		format = seekable
+	}
+	if o.rsyncable {
+		rsyncable, err := rsyncableFormat(format)
+		if err != nil {
+			return err
+		}
+		format = rsyncable
+	}
+	if o.password != "" {
+		encrypted, err := encryptFormat(format, o.password)
+		if err != nil {
+			return err
+		}
+		format = encrypted
+	}
+	if o.zipMeta != nil {
+		withMeta, err := zipMetaFormat(format, o)
+		if err != nil {
+			return err
+		}
+		format = withMeta
+	}
+	if o.zipLevelSet || len(o.zipStore) > 0 {
+		zipCompression, err := zipCompressionFormat(format, o)
+		if err != nil {
+			return err
+		}
+		format = zipCompression
+	}
+	if o.zip64 != Zip64Auto {
+		zip64, err := zip64Format(format, o.zip64)
+		if err != nil {
+			return err
+		}
+		format = zip64
+	}
+	asyncFormat := format.(archives.ArchiverAsync)
+
+	var m *manifest
+	if o.manifest || o.manifestFile != "" {
+		m = newManifest()
+	}
+	epoch, clamp, err := sourceDateEpoch()
+	if o.deterministic && err != nil {
+		return err
+	}
+
+	between, within, stop := operationContext(o)
+	defer stop()
+
+	logging("Creating output file: %s", outfile)
+	outf, err := createOutput(outfile, o)
+	if err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	// an archive that wasn't written to the end is of no use
+	complete := false
+	defer func() {
+		logging("Closing output file: %s", outfile)
+		outf.Close()
+		if !complete {
+			discardOutput(outfile, o)
+		}
+	}()
+
+	// encrypt the whole stream when recipients were given
+	var output io.Writer = outf
+	var encrypter io.WriteCloser
+	if len(o.recipients) > 0 {
+		if encrypter, err = encryptWriter(outf, o); err != nil {
+			errMsg := fmt.Errorf("error setting up encryption for '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		output = encrypter
+	}
+
+	jobs := make(chan archives.ArchiveAsyncJob)
+	done := make(chan error, 1)
+	go func() {
+		done <- asyncFormat.ArchiveAsync(within, output, jobs)
+	}()
+
+	// send hands one entry to the archiver and waits until it has been written
+	send := func(fi archives.FileInfo) error {
+		result := make(chan error, 1)
+		select {
+		case jobs <- archives.ArchiveAsyncJob{File: fi, Result: result}:
+		case err := <-done:
+			done <- err
+			return fmt.Errorf("archiver stopped: %w", err)
+		}
+		return <-result
+	}
+
+	feedErr := func() error {
+		for {
+			if between.Err() != nil {
+				return canceled(between)
+			}
+			entry, reader, err := src.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading source: %w", err)
+			}
+
+			if reader != nil {
+				reader = ctxReadCloser{ReadCloser: reader, ctx: within}
+			}
+			fi := sourceFileInfo(entry, reader)
+			if m != nil {
+				fi = m.wrap(fi)
+			}
+			if o.deterministic {
+				fi = normalizeFile(fi, epoch, clamp)
+			} else {
+				fi = utcFile(fi)
+			}
+			logging("Adding entry: %s", fi.NameInArchive)
+			sendErr := send(fi)
+			// the archiver only reads (and closes) the content of regular files
+			if reader != nil && (sendErr != nil || !entry.Mode.IsRegular()) {
+				reader.Close()
+			}
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if o.manifest {
+			fi := m.fileInfo()
+			if o.deterministic {
+				fi = normalizeFile(fi, epoch, clamp)
+			} else {
+				fi = utcFile(fi)
+			}
+			return send(fi)
+		}
+		return nil
+	}()
+	close(jobs)
+	archiveErr := <-done
+
+	if err := errors.Join(feedErr, archiveErr); err != nil {
+		errMsg := fmt.Errorf("error during archive creation for output file '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			errMsg := fmt.Errorf("error finishing encryption of '%s': %w", outfile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	if err := finishPipeline(outf); err != nil {
+		errMsg := fmt.Errorf("error finishing the pipeline of '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if err := finishTrailer(outf); err != nil {
+		errMsg := fmt.Errorf("error writing checksum trailer of '%s': %w", outfile, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	complete = true
+
+	if o.manifestFile != "" {
+		logging("Writing manifest file: %s", o.manifestFile)
+		if err := os.WriteFile(o.manifestFile, m.bytes(), 0o644); err != nil {
+			errMsg := fmt.Errorf("error writing manifest file '%s': %w", o.manifestFile, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	logging("Archive created successfully: %s", outfile)
+	return nil
+}
+
+// sourceFileInfo turns an entry of a Source into a file the archiver accepts.
+func sourceFileInfo(entry EntryInfo, reader io.ReadCloser) archives.FileInfo {
+	info := entryFileInfo{entry: entry}
+	return archives.FileInfo{
+		FileInfo:      info,
+		NameInArchive: entry.Name,
+		LinkTarget:    entry.LinkTarget,
+		Open: func() (fs.File, error) {
+			if reader == nil {
+				return nil, fmt.Errorf("entry %s has no content", entry.Name)
+			}
+			return sourceFile{ReadCloser: reader, info: info}, nil
+		},
+	}
+}
+
+// entryFileInfo implements fs.FileInfo for an EntryInfo.
+type entryFileInfo struct{ entry EntryInfo }
+
+func (e entryFileInfo) Name() string       { return path.Base(e.entry.Name) }
+func (e entryFileInfo) Size() int64        { return e.entry.Size }
+func (e entryFileInfo) Mode() fs.FileMode  { return e.entry.Mode }
+func (e entryFileInfo) ModTime() time.Time { return e.entry.ModTime }
+func (e entryFileInfo) IsDir() bool        { return e.entry.Mode.IsDir() }
+func (entryFileInfo) Sys() any             { return nil }
+
+// sourceFile is the fs.File handed to the archiver for a Source entry.
+type sourceFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (sf sourceFile) Stat() (fs.FileInfo, error) { return sf.info, nil }
@@ -0,0 +1,295 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// volumeFormat names the volumes of a split archive: outfile.part001, ...
+const volumeFormat = "%s.part%03d"
+
+// volumeSuffix matches the extension of a volume
+var volumeSuffix = regexp.MustCompile(`\.part\d{3,}$`)
+
+// WithSplitSize splits the created archive into volumes of at most size
+// bytes, named outfile.part001, outfile.part002 and so on. outfile itself is
+// not created. Unarchive joins the volumes again when given either outfile
+// or the name of its first volume.
+func WithSplitSize(size int64) Option {
+	return func(o *options) {
+		o.splitSize = size
+	}
+}
+
+// volumeSize returns the size of the volumes of WithSplitSize or of the
+// SplitStage of WithPipeline, or 0 if the archive isn't split.
+func (o *options) volumeSize() int64 {
+	if o.pipeline != nil {
+		return o.pipeline.splitSize()
+	}
+	return o.splitSize
+}
+
+// SplitVolumes returns the volumes of the split archive, in order, or nil if
+// archive isn't split. archive is the name of the archive or of any of its
+// volumes; a plain file of that name takes precedence.
+func SplitVolumes(archive string) []string {
+	base := archive
+	if volumeSuffix.MatchString(archive) {
+		base = volumeSuffix.ReplaceAllString(archive, "")
+	} else if isExist(archive) {
+		return nil
+	}
+
+	var volumes []string
+	for i := 1; ; i++ {
+		volume := fmt.Sprintf(volumeFormat, base, i)
+		if !isExist(volume) {
+			break
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes
+}
+
+// createOutput creates outfile, or a writer of its volumes when splitting,
+// or returns the stream set with WithOutputWriter. With WithChecksumTrailer,
+// the trailer is written by finishTrailer, with WithPipeline the stages are
+// finished by finishPipeline.
+func createOutput(outfile string, o *options) (io.WriteCloser, error) {
+	if o.pipeline != nil && (len(o.recipients) > 0 || o.splitSize > 0) {
+		return nil, errors.New("encryption and splitting can't be combined with a pipeline, add them as its stages")
+	}
+	if err := o.checkOutput(outfile); err != nil {
+		return nil, err
+	}
+	var output io.WriteCloser
+	var err error
+	if o.output != nil {
+		output, err = streamOutput(o)
+	} else if size := o.volumeSize(); size > 0 {
+		output, err = createSplitWriter(outfile, size)
+	} else {
+		output, err = os.Create(outfile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if o.trailer {
+		output = newTrailerWriter(output)
+	}
+	if o.pipeline == nil {
+		return output, nil
+	}
+	chained, err := o.pipeline.chain(output)
+	if err != nil {
+		return nil, err
+	}
+	return chained, nil
+}
+
+// archiveFile is an opened archive, either a single file or joined volumes.
+type archiveFile interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// openArchive opens archive, joining its volumes if it was split, and
+// verifies and hides its checksum trailer. The returned name is the one to
+// identify the format with.
+func openArchive(archive string) (archiveFile, string, error) {
+	var f archiveFile
+	name := archive
+	if volumes := SplitVolumes(archive); volumes == nil {
+		file, err := os.Open(archive)
+		if err != nil {
+			return nil, "", fmt.Errorf("open archive %s: %w", archive, err)
+		}
+		f = file
+	} else {
+		logging("Joining %d volumes of %s", len(volumes), archive)
+		vr, err := openVolumes(volumes)
+		if err != nil {
+			return nil, "", err
+		}
+		f, name = vr, volumeSuffix.ReplaceAllString(volumes[0], "")
+	}
+
+	stripped, err := stripTrailerOf(f)
+	if err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("open archive %s: %w", archive, err)
+	}
+	return stripped, name, nil
+}
+
+// splitWriter writes to a sequence of volumes of a fixed maximum size.
+type splitWriter struct {
+	base    string
+	size    int64
+	n       int
+	cur     *os.File
+	written int64
+}
+
+// createSplitWriter removes stale volumes of outfile, which would be joined
+// with the new ones, and returns a writer creating volumes on demand.
+func createSplitWriter(outfile string, size int64) (*splitWriter, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid split size %d", size)
+	}
+	for _, volume := range SplitVolumes(outfile + ".part001") {
+		logging("Removing stale volume: %s", volume)
+		if err := os.Remove(volume); err != nil {
+			return nil, fmt.Errorf("remove stale volume: %w", err)
+		}
+	}
+	return &splitWriter{base: outfile, size: size}, nil
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if w.cur == nil || w.written == w.size {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p[:min(int64(len(p)), w.size-w.written)]
+		n, err := w.cur.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// next closes the current volume and starts the following one.
+func (w *splitWriter) next() error {
+	if w.cur != nil {
+		if err := w.cur.Close(); err != nil {
+			return fmt.Errorf("close volume: %w", err)
+		}
+	}
+	w.n++
+	name := fmt.Sprintf(volumeFormat, w.base, w.n)
+	logging("Creating volume: %s", name)
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("create volume: %w", err)
+	}
+	w.cur, w.written = f, 0
+	return nil
+}
+
+func (w *splitWriter) Close() error {
+	if w.cur == nil {
+		// an empty archive still gets its first volume
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	return w.cur.Close()
+}
+
+// volumeReader presents the volumes of a split archive as a single file.
+type volumeReader struct {
+	files  []*os.File
+	starts []int64 // offset of each volume in the joined file
+	size   int64
+	pos    int64
+}
+
+func openVolumes(volumes []string) (*volumeReader, error) {
+	vr := &volumeReader{}
+	for _, volume := range volumes {
+		f, err := os.Open(volume)
+		if err != nil {
+			vr.Close()
+			return nil, fmt.Errorf("open volume %s: %w", volume, err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			vr.Close()
+			return nil, fmt.Errorf("stat volume %s: %w", volume, err)
+		}
+		vr.files = append(vr.files, f)
+		vr.starts = append(vr.starts, vr.size)
+		vr.size += info.Size()
+	}
+	return vr, nil
+}
+
+func (vr *volumeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var total int
+	for len(p) > 0 {
+		if off >= vr.size {
+			return total, io.EOF
+		}
+		// the last volume starting at or before off
+		i := len(vr.starts) - 1
+		for vr.starts[i] > off {
+			i--
+		}
+		n, err := vr.files[i].ReadAt(p, off-vr.starts[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		if n == 0 && err == io.EOF {
+			// volume shorter than at open time
+			return total, io.ErrUnexpectedEOF
+		}
+	}
+	return total, nil
+}
+
+func (vr *volumeReader) Read(p []byte) (int, error) {
+	if vr.pos >= vr.size {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), vr.size-vr.pos)]
+	n, err := vr.ReadAt(p, vr.pos)
+	vr.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (vr *volumeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += vr.pos
+	case io.SeekEnd:
+		offset += vr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	vr.pos = offset
+	return offset, nil
+}
+
+func (vr *volumeReader) Close() error {
+	var errs []error
+	for _, f := range vr.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
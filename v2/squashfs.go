@@ -0,0 +1,579 @@
+//go:build !arc_no_squashfs && !arc_minimal
+
+package arc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// SquashFS reads squashfs 4.0 images, the read-only file systems of
+// firmware, live systems and snaps, compressed with gzip, lzma, xz, lz4 or
+// zstd. Images can't be written. They are read in any order, streams are
+// buffered in a temp file first.
+type SquashFS struct{}
+
+// squashfsMagic starts squashfs images, "hsqs" in little endian.
+var squashfsMagic = []byte("hsqs")
+
+const (
+	// metadata blocks hold inodes and directories, 8 KiB uncompressed
+	squashfsMetadataSize = 8192
+	// set in the size of a metadata block stored uncompressed
+	squashfsMetadataUncompressed = 0x8000
+	// set in the size of a data block or fragment stored uncompressed
+	squashfsDataUncompressed = 1 << 24
+	// the fragment of files without one
+	squashfsNoFragment = 0xffffffff
+	// how deep directories may nest
+	squashfsMaxDepth = 256
+)
+
+// squashfsSuperblock starts a squashfs image.
+type squashfsSuperblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModTime             uint32
+	BlockSize           uint32
+	FragmentCount       uint32
+	Compression         uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrTableStart     uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+func (SquashFS) Extension() string { return ".squashfs" }
+func (SquashFS) MediaType() string { return "application/vnd.squashfs" }
+
+func (s SquashFS) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	ext := strings.ToLower(path.Ext(filename))
+	mr.ByName = ext == s.Extension() || ext == ".sqfs"
+	if stream == nil {
+		return mr, nil
+	}
+	magic := make([]byte, len(squashfsMagic))
+	if _, err := io.ReadFull(stream, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(magic, squashfsMagic)
+	return mr, nil
+}
+
+// squashfsImage is a squashfs image being read.
+type squashfsImage struct {
+	r  io.ReaderAt
+	sb squashfsSuperblock
+	// metadata blocks decompressed so far, by position
+	metadata map[int64]squashfsMetadataBlock
+	// the fragment block read last, and its position
+	fragment   []byte
+	fragmentAt uint64
+	zstd       *zstd.Decoder
+}
+
+// squashfsMetadataBlock is a decompressed metadata block, and the position
+// of the block after it.
+type squashfsMetadataBlock struct {
+	data []byte
+	next int64
+}
+
+// squashfsInode is what Extract needs of an inode.
+type squashfsInode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	// regular files
+	size       int64
+	blocks     int64
+	blockSizes []uint32
+	fragment   uint32
+	fragOffset uint32
+	// directories
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+	// symlinks
+	target string
+}
+
+// Extract calls handleFile for each file and directory of the image, in
+// depth first order.
+func (s SquashFS) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	r, cleanup, err := readerAt(ctx, archive, s.Extension())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	img := &squashfsImage{r: r, metadata: make(map[int64]squashfsMetadataBlock)}
+	if err := img.readSuperblock(); err != nil {
+		return err
+	}
+	defer func() {
+		if img.zstd != nil {
+			img.zstd.Close()
+		}
+	}()
+	root, err := img.inode(img.sb.RootInode)
+	if err != nil {
+		return fmt.Errorf("root directory: %w", err)
+	}
+	err = img.walk(ctx, root, "", handleFile, 0)
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// readSuperblock reads and checks the superblock.
+func (img *squashfsImage) readSuperblock() error {
+	sb := &img.sb
+	if err := binary.Read(io.NewSectionReader(img.r, 0, 96), binary.LittleEndian, sb); err != nil {
+		return fmt.Errorf("read superblock: %w", err)
+	}
+	switch {
+	case sb.Magic != binary.LittleEndian.Uint32(squashfsMagic):
+		return errors.New("not a squashfs image")
+	case sb.VersionMajor != 4:
+		return fmt.Errorf("squashfs %d.%d isn't supported, only 4.0 is", sb.VersionMajor, sb.VersionMinor)
+	case sb.BlockSize < 4096 || sb.BlockSize > 1<<20 || sb.BlockSize != 1<<sb.BlockLog:
+		return fmt.Errorf("malformed superblock: block size %d", sb.BlockSize)
+	}
+	switch sb.Compression {
+	case 1, 2, 4, 5:
+	case 6:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+		img.zstd = decoder
+	case 3:
+		return fmt.Errorf("lzo compressed squashfs images: %w", errors.ErrUnsupported)
+	default:
+		return fmt.Errorf("malformed superblock: compression %d", sb.Compression)
+	}
+	return nil
+}
+
+// decompress returns the block data decompressed, at most limit bytes.
+func (img *squashfsImage) decompress(data []byte, limit int) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch img.sb.Compression {
+	case 1:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	case 2:
+		r, err = lzma.NewReader(bytes.NewReader(data))
+	case 4:
+		r, err = xz.NewReader(bytes.NewReader(data))
+	case 5:
+		out := make([]byte, limit)
+		n, err := lz4.UncompressBlock(data, out)
+		if err != nil {
+			return nil, fmt.Errorf("decompress block: %w", err)
+		}
+		return out[:n], nil
+	case 6:
+		out, err := img.zstd.DecodeAll(data, make([]byte, 0, limit))
+		if err != nil {
+			return nil, fmt.Errorf("decompress block: %w", err)
+		}
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompress block: %w", err)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("decompress block: %w", err)
+	}
+	return out, nil
+}
+
+// readMetadataBlock returns the metadata block at pos, decompressed.
+func (img *squashfsImage) readMetadataBlock(pos int64) (squashfsMetadataBlock, error) {
+	if block, ok := img.metadata[pos]; ok {
+		return block, nil
+	}
+	header := make([]byte, 2)
+	if _, err := img.r.ReadAt(header, pos); err != nil {
+		return squashfsMetadataBlock{}, fmt.Errorf("read metadata: %w", err)
+	}
+	size := int(binary.LittleEndian.Uint16(header) &^ squashfsMetadataUncompressed)
+	if size == 0 || size > squashfsMetadataSize {
+		return squashfsMetadataBlock{}, fmt.Errorf("malformed metadata block of %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := img.r.ReadAt(data, pos+2); err != nil {
+		return squashfsMetadataBlock{}, fmt.Errorf("read metadata: %w", err)
+	}
+	if binary.LittleEndian.Uint16(header)&squashfsMetadataUncompressed == 0 {
+		var err error
+		if data, err = img.decompress(data, squashfsMetadataSize); err != nil {
+			return squashfsMetadataBlock{}, err
+		}
+	}
+	block := squashfsMetadataBlock{data: data, next: pos + 2 + int64(size)}
+	img.metadata[pos] = block
+	return block, nil
+}
+
+// squashfsMetadataReader reads the metadata blocks that follow each other,
+// starting at a position within a block.
+type squashfsMetadataReader struct {
+	img  *squashfsImage
+	next int64
+	buf  []byte
+}
+
+// metadataReader returns a reader of the metadata at offset within the
+// block at pos.
+func (img *squashfsImage) metadataReader(pos int64, offset int) (*squashfsMetadataReader, error) {
+	block, err := img.readMetadataBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, fmt.Errorf("malformed metadata reference: offset %d", offset)
+	}
+	return &squashfsMetadataReader{img: img, next: block.next, buf: block.data[offset:]}, nil
+}
+
+func (m *squashfsMetadataReader) Read(p []byte) (int, error) {
+	if len(m.buf) == 0 {
+		block, err := m.img.readMetadataBlock(m.next)
+		if err != nil {
+			return 0, err
+		}
+		m.buf, m.next = block.data, block.next
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+// squashfsTypes are the Unix file types of the basic inode types, the
+// extended types follow at 8.
+var squashfsTypes = [...]uint32{1: 0o040000, 0o100000, 0o120000, 0o060000, 0o020000, 0o010000, 0o140000}
+
+// inode reads the inode at ref, the position of its metadata block within
+// the inode table and its offset in the block.
+func (img *squashfsImage) inode(ref uint64) (squashfsInode, error) {
+	var in squashfsInode
+	m, err := img.metadataReader(int64(img.sb.InodeTableStart+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return in, err
+	}
+	var header struct {
+		Type, Mode, UID, GID uint16
+		ModTime, Number      uint32
+	}
+	if err := binary.Read(m, binary.LittleEndian, &header); err != nil {
+		return in, fmt.Errorf("read inode: %w", err)
+	}
+	basic := header.Type
+	if basic > 7 {
+		basic -= 7
+	}
+	if basic < 1 || basic > 7 {
+		return in, fmt.Errorf("malformed inode of type %d", header.Type)
+	}
+	in.mode = fileMode(squashfsTypes[basic] | uint32(header.Mode)&0o7777)
+	in.modTime = time.Unix(int64(header.ModTime), 0)
+
+	switch header.Type {
+	case 1:
+		var dir struct {
+			Block, Links uint32
+			Size, Offset uint16
+			Parent       uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &dir)
+		in.dirBlock, in.dirOffset, in.dirSize = dir.Block, dir.Offset, uint32(dir.Size)
+	case 8:
+		var dir struct {
+			Links, Size, Block, Parent uint32
+			Indexes, Offset            uint16
+			Xattr                      uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &dir)
+		in.dirBlock, in.dirOffset, in.dirSize = dir.Block, dir.Offset, dir.Size
+	case 2:
+		var file struct{ Blocks, Fragment, Offset, Size uint32 }
+		err = binary.Read(m, binary.LittleEndian, &file)
+		in.blocks, in.fragment, in.fragOffset, in.size = int64(file.Blocks), file.Fragment, file.Offset, int64(file.Size)
+	case 9:
+		var file struct {
+			Blocks, Size, Sparse           uint64
+			Links, Fragment, Offset, Xattr uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &file)
+		in.blocks, in.fragment, in.fragOffset, in.size = int64(file.Blocks), file.Fragment, file.Offset, int64(file.Size)
+	case 3, 10:
+		var link struct{ Links, Size uint32 }
+		if err = binary.Read(m, binary.LittleEndian, &link); err == nil {
+			if link.Size > 4096 {
+				return in, fmt.Errorf("malformed symlink of %d bytes", link.Size)
+			}
+			target := make([]byte, link.Size)
+			_, err = io.ReadFull(m, target)
+			in.target = string(target)
+		}
+	}
+	if err != nil {
+		return in, fmt.Errorf("read inode: %w", err)
+	}
+
+	if in.mode.IsRegular() {
+		// the blocks of the file, but the tail in a fragment
+		blockSize := int64(img.sb.BlockSize)
+		count := in.size / blockSize
+		if in.fragment == squashfsNoFragment && in.size%blockSize != 0 {
+			count++
+		}
+		if in.size < 0 || count > 1<<24 {
+			return in, fmt.Errorf("malformed inode: file of %d bytes", in.size)
+		}
+		in.blockSizes = make([]uint32, count)
+		if err := binary.Read(m, binary.LittleEndian, in.blockSizes); err != nil {
+			return in, fmt.Errorf("read inode: %w", err)
+		}
+	}
+	return in, nil
+}
+
+// squashfsDirEntry is an entry of a directory, its name and inode.
+type squashfsDirEntry struct {
+	name string
+	ref  uint64
+}
+
+// readDir returns the entries of the directory dir, sorted by name.
+func (img *squashfsImage) readDir(dir squashfsInode) ([]squashfsDirEntry, error) {
+	// the size counts the "." and ".." squashfs doesn't store
+	if dir.dirSize <= 3 {
+		return nil, nil
+	}
+	m, err := img.metadataReader(int64(img.sb.DirectoryTableStart)+int64(dir.dirBlock), int(dir.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+	var entries []squashfsDirEntry
+	for left := int64(dir.dirSize) - 3; left > 0; {
+		var header struct{ Count, Start, Inode uint32 }
+		if err := binary.Read(m, binary.LittleEndian, &header); err != nil {
+			return nil, fmt.Errorf("read directory: %w", err)
+		}
+		left -= 12
+		if header.Count >= 256 {
+			return nil, fmt.Errorf("malformed directory header of %d entries", header.Count+1)
+		}
+		for range header.Count + 1 {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Type, Size  uint16
+			}
+			if err := binary.Read(m, binary.LittleEndian, &entry); err != nil {
+				return nil, fmt.Errorf("read directory: %w", err)
+			}
+			name := make([]byte, int(entry.Size)+1)
+			if _, err := io.ReadFull(m, name); err != nil {
+				return nil, fmt.Errorf("read directory: %w", err)
+			}
+			left -= 8 + int64(len(name))
+			entries = append(entries, squashfsDirEntry{name: string(name), ref: uint64(header.Start)<<16 | uint64(entry.Offset)})
+		}
+	}
+	return entries, nil
+}
+
+// walk calls handleFile for the entries below dir, stored under prefix.
+func (img *squashfsImage) walk(ctx context.Context, dir squashfsInode, prefix string, handleFile archives.FileHandler, depth int) error {
+	if depth > squashfsMaxDepth {
+		return fmt.Errorf("%s: directories nested deeper than %d", prefix, squashfsMaxDepth)
+	}
+	entries, err := img.readDir(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", prefix, err)
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.name == "." || e.name == ".." || strings.Contains(e.name, "/") {
+			return fmt.Errorf("%s: malformed directory entry %q", prefix, e.name)
+		}
+		in, err := img.inode(e.ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.Join(prefix, e.name), err)
+		}
+
+		entry := EntryInfo{Name: path.Join(prefix, e.name), Mode: in.mode, ModTime: in.modTime, LinkTarget: in.target}
+		var content io.Reader = bytes.NewReader(nil)
+		if in.mode.IsRegular() {
+			entry.Size = in.size
+			content = &squashfsFile{img: img, in: in, pos: in.blocks, left: in.size}
+		}
+		err = handleFile(ctx, sourceFileInfo(entry, io.NopCloser(content)))
+		switch {
+		case errors.Is(err, fs.SkipAll):
+			return err
+		case errors.Is(err, fs.SkipDir) && in.mode.IsDir():
+			continue
+		case err != nil:
+			return fmt.Errorf("handling file: %s: %w", entry.Name, err)
+		}
+		if in.mode.IsDir() {
+			if err := img.walk(ctx, in, entry.Name, handleFile, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fragmentBlock returns the fragment block with the given index, where the
+// tails of files are stored together.
+func (img *squashfsImage) fragmentBlock(index uint32) ([]byte, error) {
+	if index >= img.sb.FragmentCount {
+		return nil, fmt.Errorf("malformed inode: fragment %d of %d", index, img.sb.FragmentCount)
+	}
+	// the fragment table is a list of metadata blocks of 512 entries
+	pointer := make([]byte, 8)
+	if _, err := img.r.ReadAt(pointer, int64(img.sb.FragmentTableStart)+8*int64(index/512)); err != nil {
+		return nil, fmt.Errorf("read fragment table: %w", err)
+	}
+	m, err := img.metadataReader(int64(binary.LittleEndian.Uint64(pointer)), int(index%512)*16)
+	if err != nil {
+		return nil, err
+	}
+	var entry struct {
+		Start        uint64
+		Size, Unused uint32
+	}
+	if err := binary.Read(m, binary.LittleEndian, &entry); err != nil {
+		return nil, fmt.Errorf("read fragment table: %w", err)
+	}
+	if img.fragment != nil && img.fragmentAt == entry.Start {
+		return img.fragment, nil
+	}
+	data, err := img.readDataBlock(int64(entry.Start), entry.Size)
+	if err != nil {
+		return nil, err
+	}
+	img.fragment, img.fragmentAt = data, entry.Start
+	return data, nil
+}
+
+// readDataBlock returns the data block or fragment at pos, whose size is as
+// stored in inodes and the fragment table, decompressed.
+func (img *squashfsImage) readDataBlock(pos int64, size uint32) ([]byte, error) {
+	onDisk := size &^ squashfsDataUncompressed
+	if onDisk > img.sb.BlockSize+img.sb.BlockSize/2 {
+		return nil, fmt.Errorf("malformed block of %d bytes", onDisk)
+	}
+	data := make([]byte, onDisk)
+	if _, err := img.r.ReadAt(data, pos); err != nil {
+		return nil, fmt.Errorf("read block: %w", err)
+	}
+	if size&squashfsDataUncompressed != 0 {
+		return data, nil
+	}
+	return img.decompress(data, int(img.sb.BlockSize))
+}
+
+// squashfsFile reads the content of a regular file, block by block, then
+// its tail from its fragment.
+type squashfsFile struct {
+	img   *squashfsImage
+	in    squashfsInode
+	block int
+	// position of the next block
+	pos int64
+	buf []byte
+	// bytes of the file not in buf yet
+	left int64
+}
+
+func (f *squashfsFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.left == 0 {
+			return 0, io.EOF
+		}
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// next reads the next block of the file into buf.
+func (f *squashfsFile) next() error {
+	want := min(int64(f.img.sb.BlockSize), f.left)
+	var data []byte
+	switch {
+	case f.block < len(f.in.blockSizes):
+		size := f.in.blockSizes[f.block]
+		f.block++
+		if size&^squashfsDataUncompressed == 0 {
+			// a sparse block, of zeros
+			data = make([]byte, want)
+			break
+		}
+		var err error
+		if data, err = f.img.readDataBlock(f.pos, size); err != nil {
+			return err
+		}
+		f.pos += int64(size &^ squashfsDataUncompressed)
+	case f.in.fragment != squashfsNoFragment:
+		fragment, err := f.img.fragmentBlock(f.in.fragment)
+		if err != nil {
+			return err
+		}
+		if int64(f.in.fragOffset) > int64(len(fragment)) {
+			return fmt.Errorf("malformed inode: fragment offset %d", f.in.fragOffset)
+		}
+		data = fragment[f.in.fragOffset:]
+	}
+	if int64(len(data)) < want {
+		return fmt.Errorf("malformed file: block of %d bytes, want %d", len(data), want)
+	}
+	f.buf = data[:want]
+	f.left -= want
+	return nil
+}
+
+// Interface guard
+var _ archives.Extraction = SquashFS{}
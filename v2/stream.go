@@ -0,0 +1,105 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// WithOutputWriter makes Archive, Zip and their variants write the archive
+// to w, like os.Stdout, instead of creating outfile. outfile only names the
+// archive in messages and may be "-". Split archives can't be streamed.
+func WithOutputWriter(w io.Writer) Option {
+	return func(o *options) {
+		o.output = w
+	}
+}
+
+// removeOutput removes an existing outfile before it is created again, a
+// streamed archive has nothing to remove.
+func removeOutput(outfile string, o *options) error {
+	if o.output != nil {
+		return nil
+	}
+	return os.RemoveAll(outfile)
+}
+
+// streamOutput returns the writer set with WithOutputWriter, it isn't
+// closed by the archive functions.
+func streamOutput(o *options) (io.WriteCloser, error) {
+	if o.volumeSize() > 0 {
+		return nil, errors.New("split archives can't be written to a stream")
+	}
+	return nopWriteCloser{o.output}, nil
+}
+
+// nopWriteCloser is a writer with a Close method that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// UnarchiveReader extracts the archive read from r, like os.Stdin, to dst
+// while it is read, the counterpart of UnarchiveURL for streams. Zip archives
+// need random access and are buffered in a temp file first. Manifests can't
+// be verified, that takes a pass of its own before extraction.
+// r: the archive stream
+// dst: the destination directory
+// opts: optional settings, see Option
+func UnarchiveReader(r io.Reader, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Unarchiving stream to %s", dst)
+	if o.verifyManifest {
+		return errors.New("verify manifest: a streamed archive can't be read twice")
+	}
+
+	// a checksum trailer is only verified at the end of the stream, files
+	// extracted before a mismatch is detected are kept
+	return unarchiveStream("", newTrailerReader(r), dst, o)
+}
+
+// unarchiveStream extracts the archive read from input to dst, name helps
+// identifying its format. input is read to the end.
+func unarchiveStream(name string, input io.Reader, dst string, o *options) error {
+	if rootErr := o.checkDestination(dst, o.cacheDir); rootErr != nil {
+		return rootErr
+	}
+	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
+		return fmt.Errorf("creating destination directory: %w", dirErr)
+	}
+
+	sink := &dirSink{dst: dst, o: o}
+	if capErr := sink.detectCapabilities(); capErr != nil {
+		return capErr
+	}
+	progress := newProgress(o)
+	if extractErr := extractStream(name, input, progress.handler(sink.extract), o); extractErr != nil {
+		return fmt.Errorf("extracting files: %w", extractErr)
+	}
+	if _, drainErr := io.Copy(io.Discard, input); drainErr != nil {
+		return fmt.Errorf("extracting files: %w", drainErr)
+	}
+	if finishErr := sink.finish(); finishErr != nil {
+		return fmt.Errorf("extracting files: %w", finishErr)
+	}
+	progress.done()
+
+	logging("Unarchiving completed successfully.")
+	return nil
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
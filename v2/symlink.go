@@ -0,0 +1,161 @@
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// SymlinkFallback decides what Unarchive does with symlinks when the
+// destination can't have them, like Windows without the symlink privilege
+// or developer mode. It only applies with WithPreserve.
+type SymlinkFallback int
+
+const (
+	// SymlinkSkip leaves symlinks out with a warning, the default.
+	SymlinkSkip SymlinkFallback = iota
+	// SymlinkCopy replaces symlinks with a copy of their target, once the
+	// whole archive is extracted.
+	SymlinkCopy
+	// SymlinkJunction creates a junction for symlinks to directories on
+	// Windows, which needs no privilege, and copies the target of other
+	// symlinks, and of all symlinks elsewhere, like SymlinkCopy.
+	SymlinkJunction
+)
+
+var symlinkFallbackNames = map[SymlinkFallback]string{
+	SymlinkSkip:     "skip",
+	SymlinkCopy:     "copy",
+	SymlinkJunction: "junction",
+}
+
+func (f SymlinkFallback) String() string {
+	if name, ok := symlinkFallbackNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("SymlinkFallback(%d)", int(f))
+}
+
+// ParseSymlinkFallback returns the fallback named name: skip, copy or
+// junction.
+func ParseSymlinkFallback(name string) (SymlinkFallback, error) {
+	for fallback, fallbackName := range symlinkFallbackNames {
+		if fallbackName == name {
+			return fallback, nil
+		}
+	}
+	return SymlinkSkip, fmt.Errorf("unknown symlink fallback %q", name)
+}
+
+// WithSymlinkFallback sets how Unarchive materializes symlinks the
+// destination doesn't support, see SymlinkFallback. Real symlinks are
+// always created when possible.
+func WithSymlinkFallback(fallback SymlinkFallback) Option {
+	return func(o *options) {
+		o.symlinkFallback = fallback
+	}
+}
+
+// pendingLink is a symlink to materialize with a fallback, once its target
+// has been extracted.
+type pendingLink struct {
+	name    string
+	dstPath string
+	target  string // absolute path of the target in the destination
+}
+
+// linkFallbacks materializes the symlinks the destination couldn't create.
+func (d *dirSink) linkFallbacks() error {
+	for _, link := range d.pendingLinks {
+		info, statErr := os.Stat(link.target)
+		if statErr != nil {
+			if err := d.warnf("symlink "+link.name, "skipping symlink %s: %v", link.name, statErr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sep := string(os.PathSeparator)
+		if info.IsDir() && strings.HasPrefix(link.dstPath+sep, filepath.Clean(link.target)+sep) {
+			if err := d.warnf("symlink "+link.name, "skipping symlink %s: it points to one of its parents", link.name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var linkErr error
+		switch {
+		case info.IsDir() && d.o.symlinkFallback == SymlinkJunction && runtime.GOOS == "windows":
+			logging("Creating junction: %s -> %s", link.dstPath, link.target)
+			linkErr = createJunction(link.dstPath, link.target)
+		case info.IsDir():
+			logging("Copying symlinked directory: %s -> %s", link.dstPath, link.target)
+			linkErr = copyDir(link.target, link.dstPath)
+		default:
+			logging("Copying symlinked file: %s -> %s", link.dstPath, link.target)
+			linkErr = copyFile(link.target, link.dstPath, info.Mode())
+		}
+		if linkErr != nil {
+			if err := d.warnf("symlink "+link.name, "materializing symlink %s: %v", link.name, linkErr); err != nil {
+				return err
+			}
+		}
+	}
+	d.pendingLinks = nil
+	return nil
+}
+
+// copyFile copies the regular file src to dst, replacing it.
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyDir copies the tree under src to dst, symlinks inside it are copied
+// as symlinks.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode())
+		}
+		return errors.ErrUnsupported
+	})
+}
@@ -0,0 +1,131 @@
+package arc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithSync makes the destination a mirror of the archive: once everything
+// is extracted, the files and directories of dst the archive has no entry
+// for are removed, like rsync --delete, to deploy a static site without
+// leaving the files of the previous release behind. Entries the overwrite
+// policy keeps, the directories of WithRecursive archives and the cache of
+// WithLinkFarm when it is within dst are kept too. Nothing is removed when
+// the extraction fails.
+func WithSync() Option {
+	return func(o *options) {
+		o.sync = true
+	}
+}
+
+// mirror records the paths of the destination an extraction writes or
+// keeps, to remove the others with WithSync.
+type mirror struct {
+	dst string
+	// paths and their parent directories
+	paths map[string]bool
+	// directories kept with all they contain
+	trees map[string]bool
+}
+
+func newMirror(dst string, o *options) *mirror {
+	m := &mirror{dst: filepath.Clean(dst), paths: make(map[string]bool), trees: make(map[string]bool)}
+	if o.cacheDir != "" {
+		m.keepTree(o.cacheDir)
+	}
+	return m
+}
+
+// keep records the entry name, relative to the destination.
+func (m *mirror) keep(name string) {
+	dstPath, err := securePath(m.dst, name)
+	if err != nil {
+		return
+	}
+	for p := dstPath; p != m.dst && p != filepath.Dir(p) && !m.paths[p]; p = filepath.Dir(p) {
+		m.paths[p] = true
+	}
+}
+
+// keepTree records the directory dir with all it contains, if it is within
+// the destination.
+func (m *mirror) keepTree(dir string) {
+	absDst, dstErr := filepath.Abs(m.dst)
+	absDir, dirErr := filepath.Abs(dir)
+	if dstErr != nil || dirErr != nil {
+		return
+	}
+	rel, err := filepath.Rel(absDst, absDir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+	m.trees[filepath.Join(m.dst, rel)] = true
+	m.keep(filepath.ToSlash(rel))
+}
+
+// stale returns the paths of the destination to remove, directories without
+// their content, in lexical order.
+func (m *mirror) stale() ([]string, error) {
+	var paths []string
+	walkErr := filepath.WalkDir(m.dst, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case p == m.dst:
+			return nil
+		case m.trees[p]:
+			return filepath.SkipDir
+		case m.paths[p]:
+			return nil
+		}
+		paths = append(paths, p)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if walkErr != nil && !os.IsNotExist(walkErr) {
+		return nil, fmt.Errorf("listing destination: %w", walkErr)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// mirrored returns the mirror of WithSync, nil without it.
+func (d *dirSink) mirrored() *mirror {
+	if d.o.sync && d.mirror == nil {
+		d.mirror = newMirror(d.dst, d.o)
+	}
+	return d.mirror
+}
+
+// keep records that the entry name is in the archive, see WithSync.
+func (d *dirSink) keep(name string) {
+	if m := d.mirrored(); m != nil {
+		m.keep(name)
+	}
+}
+
+// prune removes what the archive has no entry for from the destination, see
+// WithSync.
+func (d *dirSink) prune() error {
+	m := d.mirrored()
+	if m == nil {
+		return nil
+	}
+	stale, err := m.stale()
+	if err != nil {
+		return err
+	}
+	for _, p := range stale {
+		logging("Removing %s, it is not in the archive", p)
+		if err := os.RemoveAll(p); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	return nil
+}
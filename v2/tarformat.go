@@ -0,0 +1,195 @@
+package arc
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// ErrTarFormat is returned, wrapped in a TarFormatError, when an entry
+// can't be written in the tar format set with WithTarFormat.
+var ErrTarFormat = errors.New("entry can't be encoded in the tar format")
+
+// TarFormatError reports the entry the tar format can't hold, and why.
+type TarFormatError struct {
+	Entry  string
+	Format tar.Format
+	// Reason is what the format can't encode, like a name of more than
+	// 256 bytes in USTAR
+	Reason string
+}
+
+func (e *TarFormatError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", ErrTarFormat, e.Format, e.Entry, e.Reason)
+}
+
+func (e *TarFormatError) Unwrap() error {
+	return ErrTarFormat
+}
+
+// WithTarFormat sets the header format of tar archives: tar.FormatUSTAR for
+// picky extractors, like those of embedded systems, which fails with a
+// TarFormatError on names of more than 256 bytes, files of 8 GiB or more or
+// extended attributes; tar.FormatPAX, which holds them in extended headers;
+// or tar.FormatGNU, which holds long names and large files but no extended
+// attributes. Access and change times are left out in all of them. By
+// default, tar.FormatUnknown, each header is written in the first of USTAR,
+// PAX and GNU that can hold it.
+func WithTarFormat(format tar.Format) Option {
+	return func(o *options) {
+		o.tarFormat = format
+	}
+}
+
+// WithXattrs records the extended attributes of files in tar archives, in
+// the SCHILY.xattr PAX records GNU tar and bsdtar read, which Unarchive
+// restores with WithPreserve. They need the PAX format, see WithTarFormat.
+// On systems without extended attributes, none are recorded.
+func WithXattrs() Option {
+	return func(o *options) {
+		o.xattrs = true
+	}
+}
+
+// tarHeaderFormat swaps the tar archival of format for one writing headers
+// in the tar format, with the extended attributes of the entries named in
+// xattrs, see readXattrs.
+func tarHeaderFormat(format archives.Archiver, tarFormat tar.Format, xattrs map[string]map[string]string) (archives.Archiver, error) {
+	switch f := format.(type) {
+	case archives.Tar:
+		f.Format = tarFormat
+		return tarHeaders{Tar: f, xattrs: xattrs}, nil
+	case archives.CompressedArchive:
+		if t, ok := f.Archival.(archives.Tar); ok {
+			t.Format = tarFormat
+			f.Archival = tarHeaders{Tar: t, xattrs: xattrs}
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("tar header formats and extended attributes only apply to tar archives, not %T", format)
+}
+
+// tarHeaders checks each entry fits in the tar format before it is written,
+// and adds the extended attributes of files read from disk.
+type tarHeaders struct {
+	archives.Tar
+	// PAX records of the extended attributes by entry name
+	xattrs map[string]map[string]string
+}
+
+func (t tarHeaders) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	checked := make([]archives.FileInfo, len(files))
+	for i, f := range files {
+		var err error
+		if checked[i], err = t.entry(f); err != nil {
+			return err
+		}
+	}
+	return t.Tar.Archive(ctx, output, checked)
+}
+
+func (t tarHeaders) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	checked := make(chan archives.ArchiveAsyncJob)
+	go func() {
+		defer close(checked)
+		for job := range jobs {
+			f, err := t.entry(job.File)
+			if err != nil {
+				job.Result <- err
+				continue
+			}
+			job.File = f
+			checked <- job
+		}
+	}()
+	return t.Tar.ArchiveAsync(ctx, output, checked)
+}
+
+// entry returns f with the header it is written with, or a TarFormatError
+// if the format can't hold it.
+func (t tarHeaders) entry(f archives.FileInfo) (archives.FileInfo, error) {
+	hdr, err := tar.FileInfoHeader(f, f.LinkTarget)
+	if err != nil {
+		// the archiver reports it
+		return f, nil
+	}
+	hdr.Name = f.NameInArchive
+	if hdr.Name == "" {
+		hdr.Name = f.Name()
+	}
+	// only mtimes are archived, as when no format is set
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	for key, value := range t.xattrs[f.NameInArchive] {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[key] = value
+	}
+	hdr.Format = t.Format
+	if err := tar.NewWriter(io.Discard).WriteHeader(hdr); err != nil {
+		format := t.Format
+		if format == tar.FormatUnknown {
+			format = tar.FormatPAX | tar.FormatGNU
+		}
+		reason := strings.TrimPrefix(err.Error(), "archive/tar: cannot encode header: ")
+		if _, cause, ok := strings.Cut(reason, "; and "); ok && strings.HasPrefix(reason, "Format specifies") {
+			reason = cause
+		}
+		if reason == "only PAX supports PAXRecords" {
+			reason = "extended attributes need PAX"
+		}
+		return f, &TarFormatError{Entry: f.NameInArchive, Format: format, Reason: reason}
+	}
+	f.FileInfo = headerInfo{FileInfo: f.FileInfo, hdr: hdr}
+	return f, nil
+}
+
+// readXattrs returns the PAX records of the extended attributes of the
+// files read from disk, by entry name. Other files, like the entries of a
+// Source, and symlinks have none. It must run before the files are wrapped
+// for progress or hashing, which hides the files opened from disk.
+func readXattrs(files []archives.FileInfo) (map[string]map[string]string, error) {
+	xattrs := make(map[string]map[string]string)
+	for _, f := range files {
+		if f.Mode()&fs.ModeSymlink != 0 || f.Open == nil {
+			continue
+		}
+		file, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		osFile, fromDisk := file.(*os.File)
+		file.Close()
+		if !fromDisk {
+			continue
+		}
+		attrs, err := getXattrs(osFile.Name())
+		if err != nil {
+			return nil, fmt.Errorf("extended attributes of %s: %w", f.NameInArchive, err)
+		}
+		for attr, value := range attrs {
+			if xattrs[f.NameInArchive] == nil {
+				xattrs[f.NameInArchive] = make(map[string]string)
+			}
+			xattrs[f.NameInArchive][paxXattrPrefix+attr] = string(value)
+		}
+	}
+	return xattrs, nil
+}
+
+// headerInfo hands the tar archiver the header to write, which
+// tar.FileInfoHeader takes from Sys.
+type headerInfo struct {
+	fs.FileInfo
+	hdr *tar.Header
+}
+
+func (h headerInfo) Sys() any { return h.hdr }
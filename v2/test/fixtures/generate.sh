@@ -0,0 +1,109 @@
+#!/bin/bash
+# Regenerates the golden archives of golden/ from tree/, with the reference
+# tool of each format where it is installed, and with arc for formats no
+# common tool writes. squashfs.py stands in for mksquashfs, its images mount
+# on Linux. The archives are checked in: run this only to add a format, and
+# check that arc still extracts the old ones.
+#
+# Usage: test/fixtures/generate.sh [path/to/arc]
+
+set -e
+
+FIXTURES="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+ARC_BIN="${1:-$(pwd)/arc}"
+GOLDEN="${FIXTURES}/golden"
+# fixed mtimes and owners keep the archives the same across runs
+MTIME="2020-01-01 00:00:00"
+
+have() {
+  command -v "$1" >/dev/null
+}
+
+# gnu_tar prints the GNU tar command, gtar on BSDs and macOS
+gnu_tar() {
+  for t in gtar tar; do
+    if have "$t" && "$t" --version 2>/dev/null | grep -q "GNU tar"; then
+      echo "$t"
+      return
+    fi
+  done
+}
+
+generate() {
+  local src="$1" tar
+  tar=$(gnu_tar)
+
+  if [ -n "${tar}" ]; then
+    local gnu=("${tar}" --sort=name --owner=0 --group=0 --numeric-owner --mtime="${MTIME}" -C "${src}")
+    "${gnu[@]}" --format=gnu -cf "${GOLDEN}/gnutar.tar" .
+    "${gnu[@]}" --format=posix --pax-option=delete=atime,delete=ctime -cf "${GOLDEN}/gnutar-pax.tar" .
+    "${gnu[@]}" --format=ustar -cf - . | gzip -n -9 > "${GOLDEN}/gnutar.tar.gz"
+    have bzip2 && "${gnu[@]}" -cf - . | bzip2 -9 > "${GOLDEN}/gnutar.tar.bz2"
+    have xz && "${gnu[@]}" -cf - . | xz -9 > "${GOLDEN}/gnutar.tar.xz"
+    have zstd && "${gnu[@]}" -cf - . | zstd -q -19 > "${GOLDEN}/gnutar.tar.zst"
+    have lz4 && "${gnu[@]}" -cf - . | lz4 -q -9 > "${GOLDEN}/gnutar.tar.lz4"
+  else
+    echo "GNU tar not found, skipping its fixtures"
+  fi
+
+  if have bsdtar; then
+    (cd "${src}" && bsdtar --format pax -cf "${GOLDEN}/bsdtar.tar" .)
+    (cd "${src}" && bsdtar --format zip -cf "${GOLDEN}/bsdtar.zip" .)
+    (cd "${src}" && bsdtar --format 7zip -cf "${GOLDEN}/bsdtar.7z" .)
+  else
+    echo "bsdtar not found, skipping its fixtures"
+  fi
+
+  if have zip; then
+    (cd "${src}" && zip -q -X -r "${GOLDEN}/infozip.zip" .)
+    (cd "${src}" && zip -q -X -0 -r "${GOLDEN}/infozip-stored.zip" .)
+  else
+    echo "Info-ZIP not found, skipping its fixtures"
+  fi
+
+  for sevenzip in 7z 7za 7zz; do
+    if have "${sevenzip}"; then
+      (cd "${src}" && "${sevenzip}" a -bd -y "${GOLDEN}/7zip.7z" . >/dev/null)
+      break
+    fi
+  done
+
+  # squashfs images also hold a symlink, which arc restores with -preserve
+  # only; without mksquashfs, squashfs.py writes them the way it does
+  local image
+  image=$(mktemp -d)
+  cp -a "${src}/." "${image}"
+  ln -s hello.txt "${image}/hello.link"
+  touch -h -d "${MTIME}" "${image}/hello.link" "${image}"
+  for comp in gzip xz zstd; do
+    if have mksquashfs; then
+      mksquashfs "${image}" "${GOLDEN}/squashfs-${comp}.squashfs" -comp "${comp}" -b 4096 -all-root -mkfs-time "$(date -d "${MTIME}" +%s)" -no-xattrs -noappend -no-progress >/dev/null
+    else
+      "${FIXTURES}/squashfs.py" "${image}" "${GOLDEN}/squashfs-${comp}.squashfs" "${comp}" "$(date -d "${MTIME}" +%s)"
+    fi
+  done
+  rm -rf "${image}"
+
+  # formats without a common tool, and single compressed files
+  for ext in tar.br tar.lz tar.sz tar.zz; do
+    "${ARC_BIN}" -q create -deterministic -f "${GOLDEN}/arc.${ext}" -C "${src}" .
+  done
+  "${ARC_BIN}" -q create -deterministic -f "${GOLDEN}/arc.zip" -C "${src}" .
+  gzip -n -9 < "${src}/hello.txt" > "${GOLDEN}/hello.txt.gz"
+  have zstd && zstd -q -19 < "${src}/hello.txt" > "${GOLDEN}/hello.txt.zst"
+  have xz && xz -9 < "${src}/hello.txt" > "${GOLDEN}/hello.txt.xz"
+  return 0
+}
+
+[ -x "${ARC_BIN}" ] || { echo "arc binary not found at ${ARC_BIN}"; exit 1; }
+rm -rf "${GOLDEN}"
+mkdir -p "${GOLDEN}"
+
+# the checked out tree has the mtimes of the checkout, a copy gets fixed ones
+work=$(mktemp -d)
+trap 'rm -rf "${work}"' EXIT
+cp -r "${FIXTURES}/tree" "${work}/tree"
+find "${work}/tree" -exec touch -d "${MTIME}" {} +
+generate "${work}/tree"
+
+ls -l "${GOLDEN}"
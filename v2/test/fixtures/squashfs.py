@@ -0,0 +1,219 @@
+#!/usr/bin/env python3
+# Writes a squashfs 4.0 image of a directory the way mksquashfs -all-root
+# does, for generate.sh where mksquashfs isn't installed: regular files,
+# directories and symlinks, data blocks of 4 KiB, file tails packed in
+# fragments, no xattrs nor export table. gzip and xz use the modules of
+# Python, zstd the zstd command.
+#
+# Usage: test/fixtures/squashfs.py <directory> <image> <gzip|xz|zstd> <mkfs-time>
+
+import lzma
+import os
+import stat
+import struct
+import subprocess
+import sys
+import zlib
+
+BLOCK_LOG = 12
+BLOCK_SIZE = 1 << BLOCK_LOG
+METADATA_SIZE = 8192
+COMPRESSION_IDS = {"gzip": 1, "xz": 4, "zstd": 6}
+NO_FRAGMENT = 0xFFFFFFFF
+NO_TABLE = 0xFFFFFFFFFFFFFFFF
+
+
+# The kernel decompresses with a window of the size of the largest block,
+# data or metadata, and only checks CRC32 in xz blocks.
+WINDOW_SIZE = max(BLOCK_SIZE, METADATA_SIZE)
+
+
+def compressor(name):
+    if name == "gzip":
+        return lambda data: zlib.compress(data, 9)
+    if name == "xz":
+        filters = [{"id": lzma.FILTER_LZMA2, "preset": 9, "dict_size": WINDOW_SIZE}]
+        return lambda data: lzma.compress(data, format=lzma.FORMAT_XZ, check=lzma.CHECK_CRC32, filters=filters)
+    if name == "zstd":
+        # the size of the block bounds the window of the frame
+        return lambda data: subprocess.run(["zstd", "-q", "-19", "--no-check", f"--stream-size={len(data)}", "-c"],
+                                           input=data, stdout=subprocess.PIPE, check=True).stdout
+    sys.exit(f"unsupported compression {name}")
+
+
+class Metadata:
+    """A table of metadata blocks, and the reference of the next byte."""
+
+    def __init__(self, compress):
+        self.compress = compress
+        self.out = bytearray()
+        self.buf = bytearray()
+
+    def ref(self):
+        return len(self.out), len(self.buf)
+
+    def write(self, data):
+        self.buf += data
+        while len(self.buf) >= METADATA_SIZE:
+            self.flush(self.buf[:METADATA_SIZE])
+            self.buf = self.buf[METADATA_SIZE:]
+
+    def flush(self, block):
+        packed = self.compress(bytes(block))
+        if len(packed) < len(block):
+            self.out += struct.pack("<H", len(packed)) + packed
+        else:
+            self.out += struct.pack("<H", len(block) | 0x8000) + block
+
+    def finish(self):
+        if self.buf:
+            self.flush(self.buf)
+            self.buf = bytearray()
+        return bytes(self.out)
+
+
+class Image:
+    def __init__(self, compression, mkfs_time):
+        self.compression = compression
+        self.compress = compressor(compression)
+        self.mkfs_time = mkfs_time
+        self.data = bytearray(96)
+        self.fragment = bytearray()
+        self.fragments = []
+        self.inodes = Metadata(self.compress)
+        self.dirs = Metadata(self.compress)
+        self.numbers = {}
+
+    def block(self, data, uncompressed_flag):
+        """Appends a data or fragment block, returns its size entry."""
+        packed = self.compress(data)
+        if len(packed) < len(data):
+            self.data += packed
+            return len(packed)
+        self.data += data
+        return len(data) | uncompressed_flag
+
+    def flush_fragment(self):
+        if self.fragment:
+            start = len(self.data)
+            size = self.block(bytes(self.fragment), 1 << 24)
+            self.fragments.append(struct.pack("<QII", start, size, 0))
+            self.fragment = bytearray()
+
+    def number(self, path):
+        """Numbers the inodes below path then path, in the order they are written."""
+        if os.path.isdir(path) and not os.path.islink(path):
+            for name in sorted(os.listdir(path), key=os.fsencode):
+                self.number(os.path.join(path, name))
+        self.numbers[path] = len(self.numbers) + 1
+
+    def header(self, kind, path, st):
+        return struct.pack("<HHHHII", kind, stat.S_IMODE(st.st_mode), 0, 0, int(st.st_mtime), self.numbers[path])
+
+    def add_file(self, path, st):
+        with open(path, "rb") as f:
+            content = f.read()
+        whole = len(content) - len(content) % BLOCK_SIZE
+        start, sizes = len(self.data), []
+        for i in range(0, whole, BLOCK_SIZE):
+            sizes.append(self.block(content[i:i + BLOCK_SIZE], 1 << 24))
+        fragment, offset = NO_FRAGMENT, 0
+        tail = content[whole:]
+        if tail:
+            if len(self.fragment) + len(tail) > BLOCK_SIZE:
+                self.flush_fragment()
+            fragment, offset = len(self.fragments), len(self.fragment)
+            self.fragment += tail
+        if not sizes:
+            start = 0
+        ref = self.inodes.ref()
+        self.inodes.write(self.header(2, path, st) + struct.pack("<IIII", start, fragment, offset, len(content)) +
+                          b"".join(struct.pack("<I", s) for s in sizes))
+        return ref, 2, self.numbers[path]
+
+    def add_symlink(self, path, st):
+        target = os.readlink(path).encode()
+        ref = self.inodes.ref()
+        self.inodes.write(self.header(3, path, st) + struct.pack("<II", 1, len(target)) + target)
+        return ref, 3, self.numbers[path]
+
+    def add_dir(self, path, parent):
+        """Adds the children of path then path, in the order of mksquashfs."""
+        entries, subdirs = [], 0
+        for name in sorted(os.listdir(path), key=os.fsencode):
+            child = os.path.join(path, name)
+            st = os.lstat(child)
+            if stat.S_ISDIR(st.st_mode):
+                entries.append((name, self.add_dir(child, self.numbers[path])))
+                subdirs += 1
+            elif stat.S_ISLNK(st.st_mode):
+                entries.append((name, self.add_symlink(child, st)))
+            elif stat.S_ISREG(st.st_mode):
+                entries.append((name, self.add_file(child, st)))
+            else:
+                sys.exit(f"unsupported file {child}")
+
+        # one header per run of entries in the same inode metadata block
+        listing_ref = self.dirs.ref()
+        listing = bytearray()
+        runs = []
+        for name, ((block, offset), kind, number) in entries:
+            if not runs or runs[-1][0] != block or len(runs[-1][1]) == 256:
+                runs.append((block, []))
+            runs[-1][1].append((name.encode(), offset, kind, number))
+        for block, run in runs:
+            base = run[0][3]
+            listing += struct.pack("<III", len(run) - 1, block, base)
+            for name, offset, kind, number in run:
+                listing += struct.pack("<HhHH", offset, number - base, kind, len(name) - 1) + name
+        self.dirs.write(listing)
+
+        ref = self.inodes.ref()
+        self.inodes.write(self.header(1, path, os.lstat(path)) +
+                          struct.pack("<IIHHI", listing_ref[0], 2 + subdirs, len(listing) + 3, listing_ref[1], parent))
+        return ref, 1, self.numbers[path]
+
+    def write(self, directory, image):
+        self.number(directory)
+        # the parent of the root is the inode after the last one
+        root_ref, _, _ = self.add_dir(directory, len(self.numbers) + 1)
+        self.flush_fragment()
+        inodes = self.inodes.finish()
+        dirs = self.dirs.finish()
+
+        out = self.data
+        inode_table = len(out)
+        out += inodes
+        directory_table = len(out)
+        out += dirs
+        fragments = Metadata(self.compress)
+        fragments.write(b"".join(self.fragments))
+        fragment_blocks = len(out)
+        out += fragments.finish()
+        fragment_table = len(out)
+        out += struct.pack("<Q", fragment_blocks)
+        ids = Metadata(self.compress)
+        ids.write(struct.pack("<I", 0))
+        id_blocks = len(out)
+        out += ids.finish()
+        id_table = len(out)
+        out += struct.pack("<Q", id_blocks)
+
+        out[:96] = struct.pack("<IIIIIHHHHHHQQQQQQQQ", 0x73717368, len(self.numbers), self.mkfs_time, BLOCK_SIZE,
+                               len(self.fragments), COMPRESSION_IDS[self.compression], BLOCK_LOG, 0, 1, 4, 0,
+                               root_ref[0] << 16 | root_ref[1], len(out), id_table, NO_TABLE, inode_table,
+                               directory_table, fragment_table, NO_TABLE)
+        out += b"\0" * (-len(out) % 4096)
+        with open(image, "wb") as f:
+            f.write(out)
+
+
+def main():
+    if len(sys.argv) != 5:
+        sys.exit("Usage: squashfs.py <directory> <image> <gzip|xz|zstd> <mkfs-time>")
+    directory, image, compression, mkfs_time = sys.argv[1:]
+    Image(compression, int(mkfs_time)).write(directory, image)
+
+
+if __name__ == "__main__":
+    main()
@@ -93,6 +93,57 @@ test_compress() {
     
     echo "Compressed with ${algo}: ${ORIGINAL_SIZE} -> ${COMPRESSED_SIZE} bytes"
   done
+
+  echo "Testing compression type inferred from the output name..."
+  ${ARC_BIN} compress -o "${COMPRESS_DIR}/inferred.txt.gz" "${INPUT_FILE}" || error "Failed to compress with an inferred type"
+  gunzip -c "${COMPRESS_DIR}/inferred.txt.gz" | cmp - "${INPUT_FILE}" || error "Inferred compression isn't gzip"
+
+  echo "Testing compression levels..."
+  ${ARC_BIN} compress -level 1 -o "${COMPRESS_DIR}/fast.txt.gz" "${INPUT_FILE}" || error "Failed to compress with -level 1"
+  ${ARC_BIN} compress -level 9 -o "${COMPRESS_DIR}/best.txt.gz" "${INPUT_FILE}" || error "Failed to compress with -level 9"
+  [ $(stat -c%s "${COMPRESS_DIR}/best.txt.gz") -le $(stat -c%s "${COMPRESS_DIR}/fast.txt.gz") ] || error "gzip -level 9 is larger than -level 1"
+  ${ARC_BIN} compress -brotli-quality 11 -o "${COMPRESS_DIR}/best.txt.br" "${INPUT_FILE}" || error "Failed to compress with -brotli-quality"
+  ${ARC_BIN} decompress -i "${COMPRESS_DIR}/best.txt.br" | cmp - "${INPUT_FILE}" || error "Brotli quality 11 round trip failed"
+  if ${ARC_BIN} compress -zstd-level 30 -o "${COMPRESS_DIR}/bad.txt.zst" "${INPUT_FILE}" 2>/dev/null; then
+    error "Out of range zstd level was accepted"
+  fi
+
+  echo "Testing zlib, raw deflate, snappy and s2 streams..."
+  for algo in zz deflate sz s2; do
+    ${ARC_BIN} compress -t "${algo}" -o "${COMPRESS_DIR}/framed.txt.${algo}" "${INPUT_FILE}" || error "Failed to compress with ${algo}"
+    [ $(stat -c%s "${COMPRESS_DIR}/framed.txt.${algo}") -lt $(stat -c%s "${INPUT_FILE}") ] || error "${algo} output isn't compressed"
+    ${ARC_BIN} decompress -i "${COMPRESS_DIR}/framed.txt.${algo}" | cmp - "${INPUT_FILE}" || error "${algo} round trip failed"
+  done
+  if command -v python3 >/dev/null 2>&1; then
+    python3 -c 'import sys, zlib; sys.stdout.buffer.write(zlib.decompress(open(sys.argv[1], "rb").read(), -15))' "${COMPRESS_DIR}/framed.txt.deflate" | cmp - "${INPUT_FILE}" || error "Raw deflate output isn't readable by zlib"
+  fi
+
+  echo "Testing the zopfli engine..."
+  head -c 200000 "${INPUT_FILE}" > "${COMPRESS_DIR}/asset.txt"
+  ${ARC_BIN} compress -level max -o "${COMPRESS_DIR}/asset.txt.gz" "${COMPRESS_DIR}/asset.txt" || error "Failed to compress with -level max"
+  ${ARC_BIN} compress -level max -engine zopfli -o "${COMPRESS_DIR}/zopfli.txt.gz" "${COMPRESS_DIR}/asset.txt" || error "Failed to compress with zopfli"
+  gzip -dc "${COMPRESS_DIR}/zopfli.txt.gz" | cmp - "${COMPRESS_DIR}/asset.txt" || error "gzip can't read zopfli output"
+  [ $(stat -c%s "${COMPRESS_DIR}/zopfli.txt.gz") -le $(stat -c%s "${COMPRESS_DIR}/asset.txt.gz") ] || error "zopfli output is larger than gzip -level max"
+  ${ARC_BIN} create -engine zopfli -t zip -f "${COMPRESS_DIR}/zopfli.zip" "${COMPRESS_DIR}/asset.txt" || error "Failed to create zip with zopfli"
+  ${ARC_BIN} cat -f "${COMPRESS_DIR}/zopfli.zip" asset.txt | cmp - "${COMPRESS_DIR}/asset.txt" || error "zopfli zip entry differs"
+  if ${ARC_BIN} compress -engine zopfli -t zst -o "${COMPRESS_DIR}/bad.zst" "${COMPRESS_DIR}/asset.txt" 2>/dev/null; then
+    error "zopfli was accepted for zstd"
+  fi
+
+  echo "Testing the list of compiled-in formats..."
+  ${ARC_BIN} --version | grep -q "^compressions:.* zst" || error "--version doesn't list zst"
+
+  echo "Testing shell completion..."
+  ${ARC_BIN} completion bash > "${TEST_DIR}/arc.bash" || error "Failed to generate bash completion"
+  bash -n "${TEST_DIR}/arc.bash" || error "Bash completion isn't valid"
+  (source "${TEST_DIR}/arc.bash"; COMP_WORDS=(arc create -c z); COMP_CWORD=3; _arc; [ "${COMPREPLY[*]}" = "zst zlib" ]) || error "Bash completion doesn't complete -c values"
+
+  echo "Testing compression fallback lists..."
+  ${ARC_BIN} compress -c zst,gz -o "${COMPRESS_DIR}/fallback.txt.zst" "${INPUT_FILE}" || error "Failed to compress with a fallback list"
+  ${ARC_BIN} decompress -t zst -i "${COMPRESS_DIR}/fallback.txt.zst" | cmp - "${INPUT_FILE}" || error "First available compression wasn't used"
+  if ${ARC_BIN} compress -c nope,gz -o "${COMPRESS_DIR}/bad.txt.gz" "${INPUT_FILE}" 2>/dev/null; then
+    error "Unknown compression in a fallback list was accepted"
+  fi
   
   echo "Compression tests completed successfully"
 }
@@ -129,10 +180,389 @@ test_decompress() {
     
     echo "Successfully decompressed ${algo} file with content intact"
   done
+
+  echo "Testing streaming through stdin and stdout with a detected compression..."
+  ${ARC_BIN} compress -t gz < "${INPUT_FILE}" | ${ARC_BIN} decompress | cmp - "${INPUT_FILE}" || error "Streaming round trip failed"
   
   echo "Decompression tests completed successfully"
 }
 
+# Test comparing archives and directories
+test_diff() {
+  step "Testing archive diffs"
+
+  local old_dir="${TEST_DIR}/diff_old" new_dir="${TEST_DIR}/diff_new"
+  mkdir -p "${old_dir}/sub" "${new_dir}/sub"
+  echo "kept" > "${old_dir}/kept.txt"
+  cp "${old_dir}/kept.txt" "${new_dir}/kept.txt"
+  echo "removed" > "${old_dir}/removed.txt"
+  echo "v1" > "${old_dir}/sub/changed.txt"
+  echo "v2" > "${new_dir}/sub/changed.txt"
+  echo "added" > "${new_dir}/added.txt"
+  ${ARC_BIN} create -f "${TEST_DIR}/diff_old.tar.zst" -C "${old_dir}" . || error "Failed to create old archive"
+  ${ARC_BIN} create -t zip -f "${TEST_DIR}/diff_new.zip" -C "${new_dir}" . || error "Failed to create new archive"
+
+  echo "Testing a diff of two archives..."
+  if ${ARC_BIN} diff "${TEST_DIR}/diff_old.tar.zst" "${TEST_DIR}/diff_new.zip" > "${TEST_DIR}/diff.txt"; then
+    error "diff of different archives exited with 0"
+  fi
+  grep -qx "added     added.txt" "${TEST_DIR}/diff.txt" || error "diff misses the added entry"
+  grep -qx "removed   removed.txt" "${TEST_DIR}/diff.txt" || error "diff misses the removed entry"
+  grep -qx "changed   sub/changed.txt" "${TEST_DIR}/diff.txt" || error "diff misses the changed entry"
+  grep -q "kept.txt" "${TEST_DIR}/diff.txt" && error "diff lists an identical entry"
+
+  echo "Testing a diff of an archive against a directory..."
+  ${ARC_BIN} diff "${TEST_DIR}/diff_new.zip" "${new_dir}/" || error "Archive and its source directory differ"
+  ${ARC_BIN} diff -json "${TEST_DIR}/diff_old.tar.zst" "${new_dir}" | grep -q '"name":"sub/changed.txt","status":"changed","type":"file","old_size":3,"new_size":3' || error "JSON diff lacks the sizes"
+
+  echo "Diff tests completed successfully"
+}
+
+# Test checking a store of archives
+test_fsck() {
+  step "Testing archive store checks"
+
+  local store="${TEST_DIR}/store"
+  mkdir -p "${store}/old"
+  ${ARC_BIN} create -f "${store}/plain.tar" -C "${ARCHIVE_DIR}" test1.txt || error "Failed to create tar archive"
+  ${ARC_BIN} create -f "${store}/old/data.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create tar.gz archive"
+
+  echo "Testing a healthy store..."
+  ${ARC_BIN} fsck "${store}" || error "fsck failed on healthy archives"
+  grep -q '"name": "old/data.tar.gz"' "${store}/.arc-catalog.json" || error "Catalog misses an archive"
+
+  echo "Testing silent corruption..."
+  # flip a byte of the stored file without changing the size or mtime
+  touch -r "${store}/plain.tar" "${TEST_DIR}/fsck_mtime"
+  printf 'X' | dd of="${store}/plain.tar" bs=1 seek=512 conv=notrunc 2>/dev/null
+  touch -r "${TEST_DIR}/fsck_mtime" "${store}/plain.tar"
+  if ${ARC_BIN} fsck "${store}" 2> "${TEST_DIR}/fsck.txt"; then
+    error "fsck missed silent corruption"
+  fi
+  grep -q "bitrot: plain.tar" "${TEST_DIR}/fsck.txt" || error "fsck didn't report bit rot"
+
+  echo "Testing corrupted and missing archives..."
+  rm "${store}/plain.tar"
+  printf 'X' | dd of="${store}/old/data.tar.gz" bs=1 seek=40 conv=notrunc 2>/dev/null
+  ${ARC_BIN} fsck "${store}" 2> "${TEST_DIR}/fsck.txt" && error "fsck missed corruption"
+  grep -q "missing: plain.tar" "${TEST_DIR}/fsck.txt" || error "fsck didn't report the missing archive"
+  grep -q "corrupt: old/data.tar.gz" "${TEST_DIR}/fsck.txt" || error "fsck didn't report the corrupted archive"
+
+  echo "Store check tests completed successfully"
+}
+
+# Test backup rotation
+test_rotate() {
+  step "Testing backup rotation"
+
+  local backups="${TEST_DIR}/backups"
+  mkdir -p "${backups}"
+  for day in 01 02 03; do
+    touch -d "2020-01-${day}" "${backups}/config-2020-01-${day}.tar.zst"
+  done
+  touch -d "2020-01-01" "${backups}/config-2020-01-01.tar.zst.minisig" "${backups}/notes.txt"
+
+  ${ARC_BIN} create -f "${backups}/config-{date}.tar.zst" -rotate 2 "${ARCHIVE_DIR}" || error "Failed to create rotated backup"
+  local today
+  today=$(date +%Y-%m-%d)
+  [ -f "${backups}/config-${today}.tar.zst" ] || error "Backup not named after the template"
+  [ -f "${backups}/config-2020-01-03.tar.zst" ] || error "Rotation removed a recent backup"
+  [ ! -e "${backups}/config-2020-01-02.tar.zst" ] || error "Rotation kept an old backup"
+  [ ! -e "${backups}/config-2020-01-01.tar.zst.minisig" ] || error "Rotation kept the signature of an old backup"
+  [ -f "${backups}/notes.txt" ] || error "Rotation removed an unrelated file"
+  ${ARC_BIN} create -f "${backups}/plain.tar" -rotate 1 "${ARCHIVE_DIR}" 2>/dev/null && error "Rotation without a placeholder was accepted"
+  ${ARC_BIN} create -f "${backups}/{host}.tar.zst" -rotate 1 "${ARCHIVE_DIR}" 2>/dev/null && error "Rotation of a template that never changes was accepted"
+
+  echo "Testing that rotation leaves other hosts and unrelated files alone..."
+  local host
+  host=$(hostname)
+  touch -d "2020-01-01" "${backups}/${host}-2020-01-01.tar.zst" "${backups}/otherhost-2020-01-01.tar.zst" "${backups}/unrelated.tar.zst" "${backups}/${host}-1.tar.zst" "${backups}/${host}-12345678901.tar.zst"
+  ${ARC_BIN} create -f "${backups}/{host}-{date}.tar.zst" -rotate 1 "${ARCHIVE_DIR}" || error "Failed to create rotated host backup"
+  [ -f "${backups}/${host}-${today}.tar.zst" ] || error "Host backup not named after the template"
+  [ ! -e "${backups}/${host}-2020-01-01.tar.zst" ] || error "Rotation kept an old host backup"
+  for kept in "otherhost-2020-01-01.tar.zst" "unrelated.tar.zst" "config-2020-01-03.tar.zst"; do
+    [ -f "${backups}/${kept}" ] || error "Rotation of {host}-{date} removed ${kept}"
+  done
+  ${ARC_BIN} create -f "${backups}/${host}-{unix}.tar.zst" -rotate 1 "${ARCHIVE_DIR}" || error "Failed to create rotated unix time backup"
+  for kept in "${host}-1.tar.zst" "${host}-12345678901.tar.zst"; do
+    [ -f "${backups}/${kept}" ] || error "Rotation of {unix} removed ${kept}, which isn't 10 digits"
+  done
+
+  echo "Rotation tests completed successfully"
+}
+
+# Test create profiles
+test_profile() {
+  step "Testing create profiles"
+
+  local project="${TEST_DIR}/project"
+  mkdir -p "${project}/build/bin" "${project}/sub"
+  echo "binary" > "${project}/build/bin/app"
+  echo "map" > "${project}/build/bin/app.map"
+  echo "readme" > "${project}/build/README.md"
+  cat > "${project}/arc.yaml" <<'EOF'
+profiles:
+  release:
+    directory: build
+    sources: [bin, README.md]
+    exclude: ["*.map"]
+    compression: gz
+    level: 9
+    output: release.tar.gz
+  debug:
+    directory: build
+    sources: [bin]
+    output: debug.zip
+EOF
+  (cd "${project}/sub" && ${ARC_BIN} create -profile release) || error "Failed to create an archive from a profile"
+  ${ARC_BIN} ls -f "${project}/release.tar.gz" > "${TEST_DIR}/profile_list.txt" || error "Failed to list the archive of a profile"
+  grep -qx "bin/app" "${TEST_DIR}/profile_list.txt" || error "Profile sources not archived"
+  grep -qx "README.md" "${TEST_DIR}/profile_list.txt" || error "Profile sources not archived"
+  grep -q "app.map" "${TEST_DIR}/profile_list.txt" && error "Profile excludes not applied"
+
+  echo "Testing overrides..."
+  (cd "${project}" && ${ARC_BIN} create -profile debug -f "${TEST_DIR}/override.tar.zst") || error "Failed to override the output of a profile"
+  ${ARC_BIN} ls -f "${TEST_DIR}/override.tar.zst" | grep -qx "bin/app.map" || error "Profile output not overridden"
+  (cd "${project}" && ${ARC_BIN} create -profile missing 2>/dev/null) && error "A missing profile was accepted"
+
+  echo "Profile tests completed successfully"
+}
+
+# Test the read-through entry cache
+test_entry_cache() {
+  step "Testing the entry cache"
+
+  local cache="${TEST_DIR}/entry_cache"
+  ${ARC_BIN} cat -f "${TEST_DIR}/archive.tar.gz" -cache-dir "${cache}" to_archive/test1.txt | cmp - "${ARCHIVE_DIR}/test1.txt" || error "Entry read through the cache differs"
+  [ "$(find "${cache}" -type f | wc -l)" -eq 1 ] || error "Entry not cached"
+  find "${cache}" -type f -exec sh -c 'echo cached > "$1"' _ {} \;
+  ${ARC_BIN} cat -f "${TEST_DIR}/archive.tar.gz" -cache-dir "${cache}" to_archive/test1.txt | grep -qx "cached" || error "Entry not read from the cache"
+
+  echo "Testing eviction..."
+  rm -rf "${cache}"
+  local size
+  size=$(wc -c < "${ARCHIVE_DIR}/test1.txt")
+  ${ARC_BIN} cat -f "${TEST_DIR}/archive.tar.gz" -cache-dir "${cache}" -cache-size "${size}" to_archive/test1.txt to_archive/test2.txt >/dev/null || error "Failed to read entries through the cache"
+  [ "$(find "${cache}" -type f | wc -l)" -eq 1 ] || error "Cache grew past its size"
+  cmp -s "$(find "${cache}" -type f)" "${ARCHIVE_DIR}/test2.txt" || error "Cache evicted the most recent entry"
+
+  echo "Entry cache tests completed successfully"
+}
+
+# Test rebuilding an archive on change
+test_watch() {
+  step "Testing archive rebuilds on change"
+
+  local dist="${TEST_DIR}/dist" archive="${TEST_DIR}/dist.tar.zst"
+  mkdir -p "${dist}"
+  echo "v1" > "${dist}/index.html"
+  ${ARC_BIN} watch -f "${archive}" -debounce 200ms -exclude '*.log' -post-cmd "echo \${ARC_STATUS} >> '${TEST_DIR}/watch_hook.txt'" -C "${dist}" . 2>/dev/null &
+  local watcher=$!
+  for _ in $(seq 50); do
+    [ -f "${archive}" ] && break
+    sleep 0.1
+  done
+  ${ARC_BIN} cat -f "${archive}" index.html | grep -qx "v1" || { kill ${watcher}; error "Initial archive misses the file"; }
+
+  echo "Testing a rebuild..."
+  mkdir -p "${dist}/assets"
+  echo "body {}" > "${dist}/assets/style.css"
+  echo "v2" > "${dist}/index.html"
+  echo "noise" > "${dist}/build.log"
+  for _ in $(seq 50); do
+    [ "$(cat "${TEST_DIR}/watch_hook.txt" 2>/dev/null | wc -l)" -ge 2 ] && break
+    sleep 0.1
+  done
+  kill ${watcher}
+  ${ARC_BIN} cat -f "${archive}" index.html | grep -qx "v2" || error "Archive wasn't rebuilt"
+  ${ARC_BIN} list -f "${archive}" | grep -qx "assets/style.css" || error "Rebuilt archive misses a new directory"
+  ${ARC_BIN} list -f "${archive}" | grep -q "build.log" && error "Rebuilt archive ignores -exclude"
+  grep -qx "success" "${TEST_DIR}/watch_hook.txt" || error "Post command didn't run"
+
+  echo "Watch tests completed successfully"
+}
+
+# Test codec benchmarks
+test_bench() {
+  step "Testing codec benchmarks"
+
+  ${ARC_BIN} bench -sample 64K -codecs gz,zst,lz4 -recommend size "${ARCHIVE_DIR}" > "${TEST_DIR}/bench.txt" || error "Failed to benchmark codecs"
+  for codec in gz:1 gz:9 zst:19 lz4:9; do
+    grep -q " ${codec} " "${TEST_DIR}/bench.txt" || error "Benchmark misses ${codec}"
+  done
+  grep -q " xz " "${TEST_DIR}/bench.txt" && error "Benchmark ran a codec not asked for"
+  grep -q "^Recommended for size: .* (-c " "${TEST_DIR}/bench.txt" || error "Benchmark didn't recommend a codec"
+
+  echo "Testing a decompress-only codec..."
+  ${ARC_BIN} bench -codecs z "${ARCHIVE_DIR}" && error "Benchmark ran without a codec"
+
+  echo "Codec benchmark tests completed successfully"
+}
+
+# Test automatic compression choice
+test_auto_compression() {
+  step "Testing automatic compression choice"
+
+  local media="${TEST_DIR}/auto_media"
+  mkdir -p "${media}"
+  for i in 1 2 3; do
+    head -c 100000 /dev/urandom > "${media}/photo${i}.jpg"
+  done
+
+  echo "Testing compressible files..."
+  ${ARC_BIN} create -c auto -f "${TEST_DIR}/auto_text.tar.gz" -C "${ARCHIVE_DIR}" test1.txt test2.txt || error "Failed to create archive with -c auto"
+  [ -f "${TEST_DIR}/auto_text.tar.zst" ] || error "-c auto didn't pick zstd for text files"
+
+  echo "Testing already compressed files..."
+  ${ARC_BIN} create -c auto -f "${TEST_DIR}/auto_media.tar.zst" "${media}" || error "Failed to create archive with -c auto"
+  [ -f "${TEST_DIR}/auto_media.tar" ] || error "-c auto didn't store already compressed files"
+  ${ARC_BIN} extract -f "${TEST_DIR}/auto_media.tar" "${EXTRACT_DIR}/auto_media" || error "Failed to extract stored archive"
+  cmp "${media}/photo1.jpg" "${EXTRACT_DIR}/auto_media/auto_media/photo1.jpg" || error "Stored file differs"
+  ${ARC_BIN} create -c auto -f "${TEST_DIR}/auto_media.zip" "${media}" || error "Failed to create ZIP archive with -c auto"
+  if command -v unzip >/dev/null; then
+    unzip -v "${TEST_DIR}/auto_media.zip" | grep -q "Stored.*photo1.jpg" || error "-c auto didn't store already compressed files in ZIP"
+  fi
+
+  echo "Automatic compression tests completed successfully"
+}
+
+test_update() {
+  step "Testing archive update"
+
+  local src="${TEST_DIR}/update_src"
+  cp -r "${ARCHIVE_DIR}" "${src}"
+
+  for archive in "${TEST_DIR}/update.tar.zst" "${TEST_DIR}/update.zip"; do
+    echo "Testing ${archive##*/}..."
+    ${ARC_BIN} create -update -f "${archive}" "${src}" || error "Failed to create archive with -update"
+    [ -f "${archive}" ] || error "-update didn't create the missing archive"
+    touch -d "2020-01-01" "${archive}"
+
+    ${ARC_BIN} create -update -f "${archive}" "${src}" 2>&1 | grep -q "up to date" || error "-update didn't report the unchanged archive"
+    [ "$(stat -c%Y "${archive}")" = "$(date -d 2020-01-01 +%s)" ] || error "-update rewrote the unchanged archive"
+
+    touch -d "@$(( $(stat -c%Y "${src}/test1.txt") + 10 ))" "${src}/test1.txt"
+    ${ARC_BIN} create -update -f "${archive}" "${src}" || error "Failed to update archive"
+    [ "$(stat -c%Y "${archive}")" != "$(date -d 2020-01-01 +%s)" ] || error "-update didn't rewrite the archive for a changed mtime"
+
+    touch -d "2020-01-01" "${archive}"
+    echo "new" > "${src}/added.txt"
+    ${ARC_BIN} create -update -f "${archive}" "${src}" || error "Failed to update archive"
+    [ "$(stat -c%Y "${archive}")" != "$(date -d 2020-01-01 +%s)" ] || error "-update didn't rewrite the archive for an added file"
+    rm "${src}/added.txt"
+    ${ARC_BIN} create -f "${archive}" "${src}" || error "Failed to recreate archive"
+  done
+
+  ${ARC_BIN} create -update -exclude "*.txt" -f "${TEST_DIR}/update.tar.zst" "${src}" 2>&1 | grep -q "up to date" && error "-update ignored the filters"
+  ${ARC_BIN} create -update -f - "${src}" > /dev/null 2>&1 && error "-update to stdout was accepted"
+
+  echo "Archive update tests completed successfully"
+}
+
+test_checksum_file() {
+  step "Testing checksum files"
+
+  local archive="${TEST_DIR}/checksum.tar.zst"
+  ${ARC_BIN} create -checksum sha256 -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create archive with -checksum"
+  [ -f "${archive}.sha256" ] || error "Checksum file wasn't written"
+  (cd "${TEST_DIR}" && sha256sum -c --quiet checksum.tar.zst.sha256) || error "sha256sum doesn't accept the checksum file"
+
+  ${ARC_BIN} extract -verify-checksum -f "${archive}" "${EXTRACT_DIR}/checksum" || error "Failed to extract with -verify-checksum"
+  verify_extraction "${EXTRACT_DIR}/checksum" || error "Extraction after checksum verification failed"
+
+  cp "${archive}" "${TEST_DIR}/checksum_bad.tar.zst"
+  echo "$(printf '0%.0s' $(seq 64))  checksum_bad.tar.zst" > "${TEST_DIR}/checksum_bad.tar.zst.sha256"
+  ${ARC_BIN} extract -verify-checksum -f "${TEST_DIR}/checksum_bad.tar.zst" "${EXTRACT_DIR}/checksum_bad" 2>&1 | grep -q "checksum mismatch" || error "Mismatching checksum file wasn't reported"
+  [ ! -e "${EXTRACT_DIR}/checksum_bad" ] || error "Archive with a mismatching checksum was extracted"
+  ${ARC_BIN} extract -verify-checksum -f "${TEST_DIR}/archive.tar.gz" "${EXTRACT_DIR}/checksum_none" 2>/dev/null && error "Archive without a checksum file was accepted"
+  ${ARC_BIN} create -checksum md5 -f "${TEST_DIR}/checksum.tar.gz" "${ARCHIVE_DIR}" 2>/dev/null && error "Unsupported checksum was accepted"
+
+  echo "Checksum file tests completed successfully"
+}
+
+# Test per-extension codec routing
+test_routes() {
+  step "Testing per-extension codecs"
+
+  local web_dir="${TEST_DIR}/web"
+  mkdir -p "${web_dir}/js"
+  seq 1 5000 > "${web_dir}/js/app.js"
+  seq 1 8000 > "${web_dir}/app.wasm"
+  echo "<html></html>" > "${web_dir}/index.html"
+
+  echo "Testing routes of archived files..."
+  ${ARC_BIN} create -f "${TEST_DIR}/web.tar.zst" -route '.wasm=br:11,.js=gz:9' -C "${web_dir}" . || error "Failed to create routed archive"
+  ${ARC_BIN} list -f "${TEST_DIR}/web.tar.zst" > "${TEST_DIR}/web.txt"
+  grep -qx "app.wasm.br" "${TEST_DIR}/web.txt" || error "wasm file not stored with brotli"
+  grep -qx "index.html" "${TEST_DIR}/web.txt" || error "Unrouted file not stored as it is"
+  ${ARC_BIN} cat -f "${TEST_DIR}/web.tar.zst" js/app.js.gz | gunzip | cmp - "${web_dir}/js/app.js" || error "Routed gzip entry differs"
+  ${ARC_BIN} create -f "${TEST_DIR}/web.zip" -route '.wasm=zst:max' -C "${web_dir}" . || error "Failed to create routed ZIP archive"
+  ${ARC_BIN} cat -f "${TEST_DIR}/web.zip" app.wasm.zst | ${ARC_BIN} decompress | cmp - "${web_dir}/app.wasm" || error "Routed zstd entry differs"
+
+  echo "Testing precompression..."
+  ${ARC_BIN} precompress -route '.html,.js=gz,.wasm=br' "${web_dir}" || error "Failed to precompress"
+  for file in index.html js/app.js; do
+    gunzip -c "${web_dir}/${file}.gz" | cmp - "${web_dir}/${file}" || error "Precompressed ${file} differs"
+  done
+  ${ARC_BIN} decompress -t br -i "${web_dir}/app.wasm.br" | cmp - "${web_dir}/app.wasm" || error "Precompressed app.wasm differs"
+  ${ARC_BIN} precompress -route '.js=z' "${web_dir}" 2>/dev/null && error "Routing to a decompress-only codec was accepted"
+
+  echo "Routing tests completed successfully"
+}
+
+# Test decompression of legacy compress (.Z) and pack (.z) files
+test_legacy_compress() {
+  step "Testing legacy compress and pack decompression"
+
+  LEGACY_DIR="${TEST_DIR}/legacy"
+  mkdir -p "${LEGACY_DIR}"
+  printf 'arc reads compress and pack files\narc reads compress and pack files\n' > "${LEGACY_DIR}/expected.txt"
+  # produced by compress -b 16 and pack, which may not be installed
+  echo "H52QYeSMASGnTBgyc0CMedMGTsE5CcO4IQMCTpgxa0CYScOmzBwFAQcWPJhwYcOHESdWvJhxY8eP" | base64 -d > "${LEGACY_DIR}/vendor.txt.Z"
+  echo "Hx4AAABEBgAABAQHACBhZXNjZHByCmZpa2xtbm+ukfVfEAMz7+U6xqhIIZdw10j6r4gBmffynWNUJBDLuEE=" | base64 -d > "${LEGACY_DIR}/packed.z"
+
+  echo "Testing .Z decompression detected from the file name..."
+  ${ARC_BIN} decompress -i "${LEGACY_DIR}/vendor.txt.Z" -o "${LEGACY_DIR}/vendor.txt" || error "Failed to decompress .Z file"
+  cmp "${LEGACY_DIR}/vendor.txt" "${LEGACY_DIR}/expected.txt" || error "Content of .Z file differs"
+
+  echo "Testing pack decompression detected from the content..."
+  ${ARC_BIN} decompress < "${LEGACY_DIR}/packed.z" | cmp - "${LEGACY_DIR}/expected.txt" || error "Content of pack file differs"
+
+  if command -v bsdtar >/dev/null 2>&1; then
+    echo "Testing extraction of a .tar.Z..."
+    bsdtar -cZf "${LEGACY_DIR}/vendor.tar.Z" -C "${TEST_DIR}" to_archive || error "bsdtar failed to create a .tar.Z"
+    ${ARC_BIN} extract -f "${LEGACY_DIR}/vendor.tar.Z" "${LEGACY_DIR}/tar_z" || error "Failed to extract .tar.Z"
+    verify_extraction "${LEGACY_DIR}/tar_z" || error ".tar.Z extraction verification failed"
+  fi
+
+  echo "Testing lzip archives..."
+  ${ARC_BIN} create -c lz -f "${LEGACY_DIR}/release.tar.lz" -C "${TEST_DIR}" to_archive || error "Failed to create .tar.lz"
+  ${ARC_BIN} extract -f "${LEGACY_DIR}/release.tar.lz" "${LEGACY_DIR}/tar_lz" || error "Failed to extract .tar.lz"
+  verify_extraction "${LEGACY_DIR}/tar_lz" || error ".tar.lz extraction verification failed"
+  if command -v lzip >/dev/null 2>&1; then
+    lzip -t "${LEGACY_DIR}/release.tar.lz" || error "lzip can't read the .tar.lz of arc"
+  fi
+
+  echo "Testing lzma-alone files..."
+  ${ARC_BIN} compress -o "${LEGACY_DIR}/payload.txt.lzma" "${LEGACY_DIR}/expected.txt" || error "Failed to compress to .lzma"
+  ${ARC_BIN} decompress < "${LEGACY_DIR}/payload.txt.lzma" | cmp - "${LEGACY_DIR}/expected.txt" || error ".lzma round trip failed"
+  if command -v python3 >/dev/null 2>&1; then
+    python3 -c 'import lzma, sys; sys.stdout.buffer.write(lzma.decompress(open(sys.argv[1], "rb").read(), format=lzma.FORMAT_ALONE))' "${LEGACY_DIR}/payload.txt.lzma" | cmp - "${LEGACY_DIR}/expected.txt" || error "liblzma can't read the .lzma of arc"
+    python3 -c 'import lzma, sys; sys.stdout.buffer.write(lzma.compress(sys.stdin.buffer.read(), format=lzma.FORMAT_ALONE))' < "${LEGACY_DIR}/expected.txt" > "${LEGACY_DIR}/firmware.lzma"
+    ${ARC_BIN} decompress -i "${LEGACY_DIR}/firmware.lzma" | cmp - "${LEGACY_DIR}/expected.txt" || error "Failed to decompress the .lzma of liblzma"
+  fi
+  ${ARC_BIN} create -f "${LEGACY_DIR}/firmware.tar.lzma" -C "${TEST_DIR}" to_archive || error "Failed to create .tar.lzma"
+  ${ARC_BIN} extract -f "${LEGACY_DIR}/firmware.tar.lzma" "${LEGACY_DIR}/tar_lzma" || error "Failed to extract .tar.lzma"
+  verify_extraction "${LEGACY_DIR}/tar_lzma" || error ".tar.lzma extraction verification failed"
+
+  echo "Testing that legacy formats can't be written..."
+  if ${ARC_BIN} compress -t z < "${LEGACY_DIR}/expected.txt" > /dev/null 2>&1; then
+    error "Compressing to .Z should fail"
+  fi
+
+  echo "Legacy compress tests completed successfully"
+}
+
 # Test archiving with various format and compression combinations
 test_archive() {
   step "Testing archive functionality with various formats and compression methods"
@@ -141,6 +571,26 @@ test_archive() {
   echo "Testing ZIP format archival..."
   ${ARC_BIN} archive -t zip -f "${TEST_DIR}/archive.zip" "${ARCHIVE_DIR}"
   [ -f "${TEST_DIR}/archive.zip" ] || error "Failed to create ZIP archive"
+
+  echo "Testing ZIP compression methods and stored extensions..."
+  cp "${ARCHIVE_DIR}/binary_file.bin" "${TEST_DIR}/photo.png"
+  ${ARC_BIN} create -method store -f "${TEST_DIR}/stored.zip" -C "${TEST_DIR}" to_archive "${COMPRESS_DIR}/large_text.txt" || error "Failed to create ZIP with -method store"
+  ${ARC_BIN} create -level 1 -f "${TEST_DIR}/fast.zip" -C "${TEST_DIR}" to_archive "${COMPRESS_DIR}/large_text.txt" || error "Failed to create ZIP with -level 1"
+  ${ARC_BIN} create -level 9 -store compressed,.bin -f "${TEST_DIR}/best.zip" -C "${TEST_DIR}" to_archive photo.png || error "Failed to create ZIP with -store"
+  [ $(stat -c%s "${TEST_DIR}/fast.zip") -lt $(stat -c%s "${TEST_DIR}/stored.zip") ] || error "Deflated ZIP isn't smaller than the stored one"
+  ${ARC_BIN} extract -f "${TEST_DIR}/best.zip" "${EXTRACT_DIR}/zip_store" || error "Failed to extract ZIP with stored extensions"
+  verify_extraction "${EXTRACT_DIR}/zip_store" || error "ZIP with stored extensions verification failed"
+  if command -v python3 >/dev/null 2>&1; then
+    methods() {
+      python3 -c 'import sys, zipfile; print(" ".join("%s=%d" % (i.filename, i.compress_type) for i in sorted(zipfile.ZipFile(sys.argv[1]).infolist(), key=lambda i: i.filename) if not i.is_dir()))' "$1"
+    }
+    methods "${TEST_DIR}/stored.zip" | grep -q "test1.txt=0" || error "-method store deflated the entries"
+    methods "${TEST_DIR}/fast.zip" | grep -q "test1.txt=8" || error "-level 1 didn't deflate the entries"
+    [ "$(methods "${TEST_DIR}/best.zip")" = "photo.png=0 to_archive/binary_file.bin=0 to_archive/subdir/subfile.txt=8 to_archive/test1.txt=8 to_archive/test2.txt=8" ] || error "-store didn't store the listed extensions only"
+  fi
+  if ${ARC_BIN} create -method lzw -f "${TEST_DIR}/bad_method.zip" -C "${TEST_DIR}" to_archive 2>/dev/null; then
+    error "Unknown ZIP method was accepted"
+  fi
   
   # Test tar with various compression algorithms
   for algo in "${COMPRESSION_TYPES[@]}"; do
@@ -158,14 +608,90 @@ test_archive() {
   done
   
   # Test with include filter
+  echo "Testing format inference from the archive name..."
+  ${ARC_BIN} create -f "${TEST_DIR}/inferred.tgz" "${ARCHIVE_DIR}" || error "Failed to create archive with inferred format"
+  tar tzf "${TEST_DIR}/inferred.tgz" | grep -q "to_archive/test1.txt" || error "Inferred format isn't tar.gz"
+  ${ARC_BIN} create -f "${TEST_DIR}/test1.txt.gz" "${ARCHIVE_DIR}/test1.txt" || error "Failed to compress a single file"
+  gunzip -c "${TEST_DIR}/test1.txt.gz" | cmp - "${ARCHIVE_DIR}/test1.txt" || error "Single compressed file differs"
+
+  echo "Testing dry run with size estimate..."
+  ${ARC_BIN} create -dry-run -estimate-model "${TEST_DIR}/model.json" -f "${TEST_DIR}/dry_run.tar.zst" "${ARCHIVE_DIR}" > "${TEST_DIR}/dry_run.txt" || error "Dry run failed"
+  grep -q "^Estimated:" "${TEST_DIR}/dry_run.txt" || error "Dry run didn't report an estimate"
+  grep -qx "to_archive/subdir/subfile.txt" "${TEST_DIR}/dry_run.txt" || error "Dry run didn't list the files"
+  [ ! -e "${TEST_DIR}/dry_run.tar.zst" ] || error "Dry run created the archive"
+  ${ARC_BIN} create -estimate-model "${TEST_DIR}/model.json" -f "${TEST_DIR}/dry_run.tar.zst" "${ARCHIVE_DIR}" || error "Failed to create the estimated archive"
+  grep -q '"\.tar\.zst": [0-9]' "${TEST_DIR}/model.json" || error "Estimate model wasn't corrected"
+
   echo "Testing archive with include filter..."
-  ${ARC_BIN} archive -include ".*\.txt$" -c zst -t tar -f "${TEST_DIR}/archive_txt_only.tar.zst" "${ARCHIVE_DIR}"
+  ${ARC_BIN} archive -include-regex ".*\.txt$" -c zst -t tar -f "${TEST_DIR}/archive_txt_only.tar.zst" "${ARCHIVE_DIR}"
   [ -f "${TEST_DIR}/archive_txt_only.tar.zst" ] || error "Failed to create filtered archive with include filter"
   
   # Test with exclude filter
   echo "Testing archive with exclude filter..."
-  ${ARC_BIN} archive -exclude ".*\.bin$" -c zst -t tar -f "${TEST_DIR}/archive_no_bin.tar.zst" "${ARCHIVE_DIR}"
+  ${ARC_BIN} archive -exclude-regex ".*\.bin$" -c zst -t tar -f "${TEST_DIR}/archive_no_bin.tar.zst" "${ARCHIVE_DIR}"
   [ -f "${TEST_DIR}/archive_no_bin.tar.zst" ] || error "Failed to create filtered archive with exclude filter"
+
+  echo "Testing archive with glob filters..."
+  ${ARC_BIN} create -include '*.txt' -exclude 'subdir/**' -f "${TEST_DIR}/glob.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create archive with glob filters"
+  [ "$(${ARC_BIN} list -f "${TEST_DIR}/glob.tar.gz" | sort | tr '\n' ' ')" = "to_archive/test1.txt to_archive/test2.txt " ] || error "Glob filters selected the wrong entries"
+  printf '# generated files\n*.bin\n' > "${TEST_DIR}/excludes"
+  ${ARC_BIN} create -exclude-from "${TEST_DIR}/excludes" -f "${TEST_DIR}/excluded.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create archive with -exclude-from"
+  if ${ARC_BIN} list -f "${TEST_DIR}/excluded.tar.gz" | grep -q '\.bin$'; then
+    error "Pattern of -exclude-from wasn't applied"
+  fi
+
+  echo "Testing that regular expressions given as globs are refused..."
+  for pattern in '.*\.bin$' '^to_archive/' '(test1|test2)*'; do
+    ${ARC_BIN} create -exclude "${pattern}" -f "${TEST_DIR}/regex.tar.gz" "${ARCHIVE_DIR}" 2>&1 | grep -q "use -exclude-regex" || error "Regular expression ${pattern} given to -exclude wasn't refused"
+  done
+  ${ARC_BIN} create -include '.*\.txt$' -f "${TEST_DIR}/regex.tar.gz" "${ARCHIVE_DIR}" 2>&1 | grep -q "use -include-regex" || error "Regular expression given to -include wasn't refused"
+  [ ! -e "${TEST_DIR}/regex.tar.gz" ] || error "Archive created with a regular expression as glob"
+  ${ARC_BIN} create -exclude '*$*.class' -exclude '.*' -f "${TEST_DIR}/dollar.tar.gz" "${ARCHIVE_DIR}" || error "Globs with \$ or a leading dot were refused"
+
+  echo "Testing archive respecting .gitignore..."
+  local repo="${TEST_DIR}/repo"
+  mkdir -p "${repo}/build" "${repo}/.git" "${repo}/src"
+  echo "output" > "${repo}/build/app"
+  echo "ref" > "${repo}/.git/HEAD"
+  echo "code" > "${repo}/src/main.go"
+  echo "log" > "${repo}/src/debug.log"
+  printf 'build/\n*.log\n' > "${repo}/.gitignore"
+  ${ARC_BIN} create -respect-gitignore -f "${TEST_DIR}/repo.tar.gz" "${repo}" || error "Failed to create archive respecting .gitignore"
+  [ "$(${ARC_BIN} list -f "${TEST_DIR}/repo.tar.gz" | sort | tr '\n' ' ')" = "repo repo/.gitignore repo/src repo/src/main.go " ] || error "Ignored files were archived"
+
+  echo "Testing -C for archive creation and extraction..."
+  ${ARC_BIN} create -C "${ARCHIVE_DIR}" -f "${TEST_DIR}/chdir.tar.gz" . || error "Failed to create archive with -C"
+  ${ARC_BIN} list -f "${TEST_DIR}/chdir.tar.gz" | grep -qx "subdir/subfile.txt" || error "Archive created with -C has the wrong paths"
+  mkdir -p "${TEST_DIR}/chdir"
+  ${ARC_BIN} extract -f "${TEST_DIR}/chdir.tar.gz" -C "${TEST_DIR}/chdir" || error "Failed to extract with -C"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/chdir" || error "Archive extracted with -C differs"
+
+  echo "Testing JSON progress events..."
+  ${ARC_BIN} create -progress-json 3 -f "${TEST_DIR}/progress.tar.gz" "${ARCHIVE_DIR}" 3> "${TEST_DIR}/progress.ndjson" || error "Failed to create archive with -progress-json"
+  grep -q '"event":"entry_finished","name":"to_archive/binary_file.bin","size":102400' "${TEST_DIR}/progress.ndjson" || error "Progress lacks a finished entry"
+  tail -1 "${TEST_DIR}/progress.ndjson" | grep -q '"event":"done".*"entries":4,"total_entries":4' || error "Progress lacks the done event"
+  ${ARC_BIN} extract -progress-json 3 -f "${TEST_DIR}/progress.tar.gz" "${TEST_DIR}/progress" 3> "${TEST_DIR}/progress.ndjson" || error "Failed to extract with -progress-json"
+  tail -1 "${TEST_DIR}/progress.ndjson" | grep -q '"event":"done"' || error "Extraction progress lacks the done event"
+  ${ARC_BIN} create -progress -f "${TEST_DIR}/progress_bar.tar.gz" "${ARCHIVE_DIR}" 2> "${TEST_DIR}/progress.txt" || error "Failed to create archive with -progress"
+  tr '\r' '\n' < "${TEST_DIR}/progress.txt" | grep -q '^\[====================\]  100%.*4/4 files' || error "Progress bar doesn't reach 100%"
+
+  echo "Testing output levels..."
+  [ -z "$(${ARC_BIN} -q create -f "${TEST_DIR}/quiet.tar.gz" "${ARCHIVE_DIR}" 2>&1)" ] || error "-q printed messages"
+  ${ARC_BIN} -v extract -f "${TEST_DIR}/quiet.tar.gz" "${TEST_DIR}/verbose" 2>&1 | grep -qx "to_archive/test1.txt" || error "-v didn't list the extracted files"
+  ${ARC_BIN} -log-json extract -f "${TEST_DIR}/quiet.tar.gz" "${TEST_DIR}/verbose" 2> "${TEST_DIR}/log.ndjson" || error "Failed to extract with -log-json"
+  grep -q '"event":"file","path":"to_archive/test1.txt","size":20,"action":"extract"' "${TEST_DIR}/log.ndjson" || error "-log-json lacks a file event"
+  grep -v -q '^{' "${TEST_DIR}/log.ndjson" && error "-log-json printed a line that isn't JSON"
+
+  echo "Testing archive of a NUL separated file list..."
+  (cd "${ARCHIVE_DIR}" && find . -name "*.txt" -print0) | ${ARC_BIN} create -C "${ARCHIVE_DIR}" -files-from - -f "${TEST_DIR}/files_from.tar.gz" || error "Failed to archive a file list"
+  [ "$(${ARC_BIN} list -f "${TEST_DIR}/files_from.tar.gz" | sort | tr '\n' ' ')" = "subdir/subfile.txt test1.txt test2.txt " ] || error "Archive of a file list has the wrong entries"
+
+  echo "Testing archive of several sources and a glob pattern..."
+  ${ARC_BIN} create -f "${TEST_DIR}/sources.tar.gz" "${ARCHIVE_DIR}/subdir" "${ARCHIVE_DIR}/*.txt" || error "Failed to archive several sources"
+  [ "$(${ARC_BIN} list -f "${TEST_DIR}/sources.tar.gz" | sort | tr '\n' ' ')" = "subdir subdir/subfile.txt test1.txt test2.txt " ] || error "Archive of several sources has the wrong entries"
+  if ${ARC_BIN} create -f "${TEST_DIR}/nomatch.tar.gz" "${ARCHIVE_DIR}/*.none" 2>/dev/null; then
+    error "A pattern matching nothing was accepted"
+  fi
   
   echo "Archive creation tests completed successfully"
 }
@@ -208,10 +734,1199 @@ test_extract() {
     
     echo "Successfully extracted tar.${algo} archive with content intact"
   done
+
+  echo "Testing extraction limits..."
+  ${ARC_BIN} extract -max-archive-size 1M -max-size 1M -max-entries 10 -formats zip,tar.gz -f "${TEST_DIR}/archive.zip" "${EXTRACT_DIR}/limits" || error "Extraction within limits failed"
+  verify_extraction "${EXTRACT_DIR}/limits" || error "Extraction within limits verification failed"
+  ${ARC_BIN} extract -max-size 50K -f "${TEST_DIR}/archive.zip" "${EXTRACT_DIR}/limits_size" 2>&1 | grep -q "limit exceeded: size above 51200" || error "Extraction above the size limit didn't fail"
+  ${ARC_BIN} extract -max-entries 2 -f "${TEST_DIR}/archive.tar.gz" "${EXTRACT_DIR}/limits_entries" 2>&1 | grep -q "limit exceeded: entries above 2" || error "Extraction above the entry limit didn't fail"
+  ${ARC_BIN} extract -formats zip -f "${TEST_DIR}/archive.tar.gz" "${EXTRACT_DIR}/limits_format" 2>&1 | grep -q "format tar.gz isn't allowed" || error "Extraction of a refused format didn't fail"
+  ${ARC_BIN} extract -max-archive-size 1K -f - "${EXTRACT_DIR}/limits_stdin" < "${TEST_DIR}/archive.tar.gz" 2>&1 | grep -q "archive size above 1024" || error "Streamed archive above the size limit didn't fail"
   
   echo "Archive extraction tests completed successfully"
 }
 
+# Test 7z archives
+test_sevenzip() {
+  step "Testing 7z archives"
+
+  local archive="${TEST_DIR}/archive.7z"
+  ${ARC_BIN} create -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create 7z archive"
+  ${ARC_BIN} list -f "${archive}" | grep -qx "to_archive/subdir/subfile.txt" || error "7z archive lacks an entry"
+  ${ARC_BIN} extract -f "${archive}" "${EXTRACT_DIR}/7z" || error "Failed to extract 7z archive"
+  verify_extraction "${EXTRACT_DIR}/7z" || error "7z extraction verification failed"
+  ${ARC_BIN} extract -f - "${EXTRACT_DIR}/7z_stdin" < "${archive}" || error "Failed to extract 7z archive from stdin"
+  verify_extraction "${EXTRACT_DIR}/7z_stdin" || error "7z extraction from stdin verification failed"
+  ${ARC_BIN} create -t 7z -c auto -f "${TEST_DIR}/auto.7z" "${ARCHIVE_DIR}" 2>/dev/null && error "-c auto was accepted for 7z"
+
+  if command -v bsdtar >/dev/null 2>&1; then
+    mkdir -p "${EXTRACT_DIR}/7z_bsdtar"
+    bsdtar -xf "${archive}" -C "${EXTRACT_DIR}/7z_bsdtar" || error "bsdtar can't read the 7z archive"
+    verify_extraction "${EXTRACT_DIR}/7z_bsdtar" || error "7z archive extracted by bsdtar differs"
+  fi
+
+  echo "7z archive tests completed successfully"
+}
+
+# Test cpio and ar archives
+test_cpio_ar() {
+  step "Testing cpio and ar archives"
+
+  local archive="${TEST_DIR}/archive.cpio.gz"
+  ${ARC_BIN} create -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create cpio archive"
+  ${ARC_BIN} extract -f "${archive}" "${EXTRACT_DIR}/cpio" || error "Failed to extract cpio archive"
+  verify_extraction "${EXTRACT_DIR}/cpio" || error "cpio extraction verification failed"
+  if command -v bsdtar >/dev/null 2>&1; then
+    mkdir -p "${EXTRACT_DIR}/cpio_bsdtar"
+    bsdtar -xf "${archive}" -C "${EXTRACT_DIR}/cpio_bsdtar" || error "bsdtar can't read the cpio archive"
+    verify_extraction "${EXTRACT_DIR}/cpio_bsdtar" || error "cpio archive extracted by bsdtar differs"
+  fi
+
+  archive="${TEST_DIR}/lib.a"
+  ${ARC_BIN} create -C "${ARCHIVE_DIR}" -f "${archive}" test1.txt subdir/subfile.txt || error "Failed to create ar archive"
+  [ "$(${ARC_BIN} list -f "${archive}" | sort | tr '\n' ' ')" = "subfile.txt test1.txt " ] || error "ar archive has the wrong members"
+  ${ARC_BIN} extract -f "${archive}" "${EXTRACT_DIR}/ar" || error "Failed to extract ar archive"
+  diff "${ARCHIVE_DIR}/subdir/subfile.txt" "${EXTRACT_DIR}/ar/subfile.txt" || error "ar member differs"
+  if command -v ar >/dev/null 2>&1; then
+    ar p "${archive}" test1.txt | cmp - "${ARCHIVE_DIR}/test1.txt" || error "ar can't read the ar archive"
+  fi
+
+  if command -v dpkg-deb >/dev/null 2>&1; then
+    local pkg="${TEST_DIR}/pkg"
+    mkdir -p "${pkg}/DEBIAN" "${pkg}/usr/bin"
+    printf 'Package: hello\nVersion: 1.0\nArchitecture: all\nMaintainer: arc <arc@example.com>\nDescription: test\n' > "${pkg}/DEBIAN/control"
+    cp "${ARCHIVE_DIR}/test1.txt" "${pkg}/usr/bin/hello"
+    dpkg-deb -Zgzip --build "${pkg}" "${TEST_DIR}/hello.deb" >/dev/null || error "dpkg-deb failed to build a package"
+    ${ARC_BIN} extract -package-members -recursive -f "${TEST_DIR}/hello.deb" "${EXTRACT_DIR}/deb" || error "Failed to extract a .deb recursively"
+    diff "${ARCHIVE_DIR}/test1.txt" "${EXTRACT_DIR}/deb/data/usr/bin/hello" || error "File of the .deb differs"
+
+    ${ARC_BIN} extract -package-members -f "${TEST_DIR}/hello.deb" "${EXTRACT_DIR}/deb_parts" || error "Failed to extract the members of a .deb"
+    printf 'debian-binary\ncontrol.tar.gz\ndata.tar.gz\n' | ${ARC_BIN} create -C "${EXTRACT_DIR}/deb_parts" -files-from - -f "${TEST_DIR}/rebuilt.deb" || error "Failed to create a .deb"
+    [ "$(dpkg-deb -f "${TEST_DIR}/rebuilt.deb" Package)" = "hello" ] || error "dpkg-deb can't read the .deb of arc"
+  fi
+
+  echo "cpio and ar archive tests completed successfully"
+}
+
+# Test extraction of the files .deb and .rpm packages install
+test_package() {
+  step "Testing package extraction"
+
+  if [ -f "${TEST_DIR}/hello.deb" ]; then
+    ${ARC_BIN} extract -f "${TEST_DIR}/hello.deb" "${EXTRACT_DIR}/package_deb" || error "Failed to extract the files of a .deb"
+    diff "${ARCHIVE_DIR}/test1.txt" "${EXTRACT_DIR}/package_deb/usr/bin/hello" || error "File of the .deb payload differs"
+    [ ! -e "${EXTRACT_DIR}/package_deb/debian-binary" ] || error "Members of the .deb were extracted with its payload"
+  else
+    warn "dpkg-deb not installed, skipping .deb payload extraction"
+  fi
+
+  # an rpm of a lead and two empty headers before its payload
+  local rpm_header='\216\255\350\001\0\0\0\0\0\0\0\0\0\0\0\0'
+  ${ARC_BIN} create -C "${ARCHIVE_DIR}" -f "${TEST_DIR}/payload.cpio.xz" . || error "Failed to create an rpm payload"
+  {
+    printf '\355\253\356\333'
+    head -c 92 /dev/zero
+    printf "${rpm_header}"
+    printf "${rpm_header}"
+    cat "${TEST_DIR}/payload.cpio.xz"
+  } > "${TEST_DIR}/hello.rpm"
+  ${ARC_BIN} extract -f "${TEST_DIR}/hello.rpm" "${EXTRACT_DIR}/package_rpm" || error "Failed to extract the files of an .rpm"
+  diff -r "${ARCHIVE_DIR}" "${EXTRACT_DIR}/package_rpm" || error "Files of the .rpm payload differ"
+
+  echo "Package extraction tests completed successfully"
+}
+
+# Test reading the ISO 9660 images of bsdtar, which arc can't create
+test_disk_image() {
+  step "Testing disk images"
+
+  if ! command -v bsdtar >/dev/null 2>&1; then
+    warn "bsdtar not installed, skipping disk image tests"
+    return
+  fi
+  # Rock Ridge images keep long names and modes, Joliet ones only long names
+  bsdtar -cf "${TEST_DIR}/rockridge.iso" --format iso9660 -C "${TEST_DIR}" to_archive || error "bsdtar failed to create an ISO image"
+  bsdtar -cf "${TEST_DIR}/joliet.iso" --format iso9660 --options '!rockridge' -C "${TEST_DIR}" to_archive || error "bsdtar failed to create a Joliet image"
+  for image in rockridge joliet; do
+    ${ARC_BIN} list -f "${TEST_DIR}/${image}.iso" | grep -qx "to_archive/subdir/subfile.txt" || error "Listing of the ${image} image is wrong"
+    ${ARC_BIN} extract -f "${TEST_DIR}/${image}.iso" "${EXTRACT_DIR}/${image}" || error "Failed to extract the ${image} image"
+    verify_extraction "${EXTRACT_DIR}/${image}" || error "${image} image extraction verification failed"
+  done
+  ${ARC_BIN} extract -f - "${EXTRACT_DIR}/iso_stdin" < "${TEST_DIR}/rockridge.iso" || error "Failed to extract an ISO image from stdin"
+  verify_extraction "${EXTRACT_DIR}/iso_stdin" || error "ISO image extracted from stdin differs"
+  if ${ARC_BIN} create -f "${TEST_DIR}/created.iso" "${ARCHIVE_DIR}" 2>/dev/null; then
+    error "Created an ISO image, which arc only reads"
+  fi
+
+  echo "Disk image tests completed successfully"
+}
+
+# Test squashfs images, the golden ones of the interoperability tests
+test_squashfs() {
+  step "Testing squashfs images"
+
+  local fixtures="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)/fixtures"
+  local image out comp
+  for comp in gzip xz zstd; do
+    image="${fixtures}/golden/squashfs-${comp}.squashfs"
+    out="${EXTRACT_DIR}/squashfs_${comp}"
+    echo "Testing the ${comp} image..."
+    ${ARC_BIN} list -f "${image}" | grep -qx "docs/deep/notes.txt" || error "Listing of the ${comp} image misses an entry"
+    ${ARC_BIN} list -l -f "${image}" | grep -q "^L.* hello.link -> hello.txt$" || error "Listing of the ${comp} image misses the symlink"
+    ${ARC_BIN} test -f "${image}" || error "Failed to verify the ${comp} image"
+    ${ARC_BIN} extract -preserve -f "${image}" "${out}" || error "Failed to extract the ${comp} image"
+    [ "$(readlink "${out}/hello.link")" = "hello.txt" ] || error "Symlink of the ${comp} image wasn't restored"
+    rm "${out}/hello.link"
+    diff -r "${fixtures}/tree" "${out}" || error "Extracted ${comp} image differs from the fixture tree"
+    ${ARC_BIN} cat -f "${image}" "space name.txt" | cmp - "${fixtures}/tree/space name.txt" || error "cat of an entry of the ${comp} image failed"
+  done
+  ${ARC_BIN} extract -f - "${EXTRACT_DIR}/squashfs_stdin" < "${image}" || error "Failed to extract a squashfs image from stdin"
+  [ ! -e "${EXTRACT_DIR}/squashfs_stdin/hello.link" ] || error "Symlink extracted without -preserve"
+  diff -r "${fixtures}/tree" "${EXTRACT_DIR}/squashfs_stdin" || error "squashfs image extracted from stdin differs"
+
+  echo "Testing truncated and corrupted images..."
+  head -c 600 "${fixtures}/golden/squashfs-gzip.squashfs" > "${TEST_DIR}/truncated.squashfs"
+  # garbage over the inode table, which starts at the offset stored at byte 64
+  cp "${fixtures}/golden/squashfs-xz.squashfs" "${TEST_DIR}/corrupt.squashfs"
+  printf 'XXXXXXXXXXXXXXXX' | dd of="${TEST_DIR}/corrupt.squashfs" bs=1 seek=$(($(od -An -tu8 -j 64 -N 8 "${TEST_DIR}/corrupt.squashfs") + 2)) conv=notrunc 2>/dev/null
+  for image in truncated corrupt; do
+    if ${ARC_BIN} list -f "${TEST_DIR}/${image}.squashfs" > /dev/null 2> "${TEST_DIR}/${image}_squashfs.log"; then
+      error "Listing of a ${image} squashfs image should fail"
+    fi
+    if ${ARC_BIN} extract -f "${TEST_DIR}/${image}.squashfs" "${EXTRACT_DIR}/squashfs_${image}" 2>> "${TEST_DIR}/${image}_squashfs.log"; then
+      error "Extraction of a ${image} squashfs image should fail"
+    fi
+    if ${ARC_BIN} test -f "${TEST_DIR}/${image}.squashfs" 2>> "${TEST_DIR}/${image}_squashfs.log"; then
+      error "Verification of a ${image} squashfs image should fail"
+    fi
+    ! grep -q "panic" "${TEST_DIR}/${image}_squashfs.log" || error "arc panicked on a ${image} squashfs image"
+  done
+
+  echo "squashfs tests completed successfully"
+}
+
+# Test integrity verification of the created archives
+test_verify() {
+  step "Testing archive verification"
+
+  echo "Testing ZIP archive verification..."
+  ${ARC_BIN} test -f "${TEST_DIR}/archive.zip" || error "Failed to verify ZIP archive"
+
+  for algo in "${COMPRESSION_TYPES[@]}"; do
+    ARCHIVE_FILE="${TEST_DIR}/archive.tar.${algo}"
+
+    # Skip if the archive file wasn't created
+    if [ ! -f "${ARCHIVE_FILE}" ]; then
+      warn "Archive file for tar.${algo} not found, skipping verification test"
+      continue
+    fi
+
+    echo "Testing verification of tar.${algo} archive..."
+    ${ARC_BIN} test -f "${ARCHIVE_FILE}" || error "Failed to verify tar.${algo} archive"
+  done
+
+  # A truncated archive must fail verification
+  echo "Testing verification of a truncated archive..."
+  head -c 1000 "${TEST_DIR}/archive.tar.gz" > "${TEST_DIR}/truncated.tar.gz"
+  if ${ARC_BIN} test -f "${TEST_DIR}/truncated.tar.gz"; then
+    error "Verification of a truncated archive should fail"
+  fi
+
+  echo "Testing the location of corruption..."
+  ${ARC_BIN} create -f "${TEST_DIR}/whole.tar" "${ARCHIVE_DIR}" || error "Failed to create tar archive"
+  head -c 3000 "${TEST_DIR}/whole.tar" > "${TEST_DIR}/truncated.tar"
+  ${ARC_BIN} extract -f "${TEST_DIR}/truncated.tar" -C "${TEST_DIR}/truncated" 2> "${TEST_DIR}/truncated.log" && error "Extraction of a truncated archive should fail"
+  grep -q 'truncated.tar: entry [0-9]*.*, near byte [0-9]*: ' "${TEST_DIR}/truncated.log" || error "Extraction error doesn't locate the corruption"
+  grep -q "likely cause: the archive is truncated" "${TEST_DIR}/truncated.log" || error "Extraction error doesn't hint at truncation"
+
+  echo "Testing hints for files that aren't what they seem..."
+  printf '<!DOCTYPE html>\n<html><body>Not Found</body></html>\n' > "${TEST_DIR}/page.tar.gz"
+  ${ARC_BIN} extract -f "${TEST_DIR}/page.tar.gz" -C "${TEST_DIR}/page" 2>&1 | grep -q "likely cause: it is an HTML page" || error "Extraction error doesn't hint at an HTML page"
+  cp "${TEST_DIR}/whole.tar" "${TEST_DIR}/whole.tar.xz"
+  ${ARC_BIN} test -f "${TEST_DIR}/whole.tar.xz" 2>&1 | grep -q "its content is .tar but its name .* says otherwise" || error "Verification error doesn't hint at the wrong extension"
+
+  echo "Testing verification of several archives..."
+  ${ARC_BIN} test "${TEST_DIR}/archive.zip" "${TEST_DIR}/archive.tar.gz" || error "Failed to verify several archives"
+  if ${ARC_BIN} test "${TEST_DIR}/truncated.tar.gz" "${TEST_DIR}/archive.zip" 2> "${TEST_DIR}/test_several.log"; then
+    error "Verification of several archives with a truncated one should fail"
+  fi
+  grep -q "Archive OK: ${TEST_DIR}/archive.zip" "${TEST_DIR}/test_several.log" || error "Archives after a corrupted one weren't verified"
+
+  echo "Testing verification of archives read from stdin..."
+  ${ARC_BIN} test -f - < "${TEST_DIR}/archive.tar.gz" || error "Failed to verify a tar.gz archive from stdin"
+  ${ARC_BIN} test - < "${TEST_DIR}/archive.zip" || error "Failed to verify a zip archive from stdin"
+  if ${ARC_BIN} test -f - < "${TEST_DIR}/truncated.tar.gz" 2>/dev/null; then
+    error "Verification of a truncated archive from stdin should fail"
+  fi
+
+  echo "Testing verification against an embedded manifest..."
+  mkdir -p "${TEST_DIR}/bad_manifest"
+  echo "content" > "${TEST_DIR}/bad_manifest/file.txt"
+  echo "$(printf '0%.0s' $(seq 64))  file.txt" > "${TEST_DIR}/bad_manifest/SHA256SUMS"
+  tar -czf "${TEST_DIR}/bad_manifest.tar.gz" -C "${TEST_DIR}/bad_manifest" file.txt SHA256SUMS
+  if ${ARC_BIN} test -f "${TEST_DIR}/bad_manifest.tar.gz" 2>/dev/null; then
+    error "Verification of an archive not matching its manifest should fail"
+  fi
+  ${ARC_BIN} create -manifest -f "${TEST_DIR}/manifest_ok.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create archive with a manifest"
+  ${ARC_BIN} test -f "${TEST_DIR}/manifest_ok.tar.gz" || error "Failed to verify archive with a manifest"
+
+  echo "Archive verification tests completed successfully"
+}
+
+# Test SHA-256 manifest generation and verification
+test_manifest() {
+  step "Testing checksum manifests"
+
+  echo "Testing archive with embedded and sidecar manifest..."
+  ${ARC_BIN} archive -manifest -manifest-file "${TEST_DIR}/SHA256SUMS" -c zst -t tar -f "${TEST_DIR}/manifest.tar.zst" "${ARCHIVE_DIR}"
+  [ -f "${TEST_DIR}/SHA256SUMS" ] || error "Failed to write sidecar manifest"
+
+  echo "Testing extraction with embedded manifest verification..."
+  ${ARC_BIN} extract -verify-manifest -f "${TEST_DIR}/manifest.tar.zst" "${EXTRACT_DIR}/manifest" || error "Failed to verify embedded manifest"
+  (cd "${EXTRACT_DIR}/manifest" && sha256sum -c --quiet SHA256SUMS) || error "Embedded manifest does not match sha256sum"
+
+  echo "Testing extraction with a tampered sidecar manifest..."
+  sed 's/^[0-9a-f]/x/' "${TEST_DIR}/SHA256SUMS" > "${TEST_DIR}/SHA256SUMS.bad"
+  if ${ARC_BIN} extract -manifest-file "${TEST_DIR}/SHA256SUMS.bad" -f "${TEST_DIR}/manifest.tar.zst" "${EXTRACT_DIR}/manifest_bad"; then
+    error "Extraction with a tampered manifest should fail"
+  fi
+
+  echo "Manifest tests completed successfully"
+}
+
+# Test detached signatures
+test_signature() {
+  step "Testing detached signatures"
+
+  echo "Testing key generation and signing..."
+  ${ARC_BIN} keygen -s "${TEST_DIR}/arc.key" -p "${TEST_DIR}/arc.pub"
+  ${ARC_BIN} archive -sign "${TEST_DIR}/arc.key" -c zst -t tar -f "${TEST_DIR}/signed.tar.zst" "${ARCHIVE_DIR}"
+  [ -f "${TEST_DIR}/signed.tar.zst.minisig" ] || error "Failed to create signature"
+
+  echo "Testing signature verification..."
+  ${ARC_BIN} extract -pubkey "${TEST_DIR}/arc.pub" -f "${TEST_DIR}/signed.tar.zst" "${EXTRACT_DIR}/signed" || error "Failed to verify signature"
+
+  echo "Testing verification of a tampered archive..."
+  echo "tampered" >> "${TEST_DIR}/signed.tar.zst"
+  if ${ARC_BIN} test -pubkey "${TEST_DIR}/arc.pub" -f "${TEST_DIR}/signed.tar.zst"; then
+    error "Verification of a tampered archive should fail"
+  fi
+
+  echo "Signature tests completed successfully"
+}
+
+test_password() {
+  step "Testing password-protected ZIP archives"
+
+  echo "Testing encrypted archive creation and extraction..."
+  ${ARC_BIN} archive -t zip -p "s3cret" -f "${TEST_DIR}/encrypted.zip" "${ARCHIVE_DIR}" || error "Failed to create encrypted ZIP"
+  ${ARC_BIN} extract -p "s3cret" -f "${TEST_DIR}/encrypted.zip" "${EXTRACT_DIR}/encrypted" || error "Failed to extract encrypted ZIP"
+  verify_extraction "${EXTRACT_DIR}/encrypted" || error "Encrypted ZIP extraction verification failed"
+  ${ARC_BIN} test -p "s3cret" -f "${TEST_DIR}/encrypted.zip" || error "Failed to verify encrypted ZIP"
+  if ${ARC_BIN} test -p "wrong" -f "${TEST_DIR}/encrypted.zip" 2>/dev/null; then
+    error "Verification with a wrong password should fail"
+  fi
+
+  echo "Testing password from the environment..."
+  ARC_PASSWORD="s3cret" ${ARC_BIN} extract -f "${TEST_DIR}/encrypted.zip" "${EXTRACT_DIR}/encrypted_env" || error "Failed to extract with ARC_PASSWORD"
+  verify_extraction "${EXTRACT_DIR}/encrypted_env" || error "Encrypted ZIP extraction verification failed"
+
+  echo "Testing extraction with a wrong password..."
+  if ${ARC_BIN} extract -p "wrong" -f "${TEST_DIR}/encrypted.zip" "${EXTRACT_DIR}/encrypted_wrong"; then
+    error "Extraction with a wrong password should fail"
+  fi
+
+  echo "Password tests completed successfully"
+}
+
+test_encryption() {
+  step "Testing encrypted archives"
+
+  if ! command -v ssh-keygen >/dev/null; then
+    echo "ssh-keygen not found, skipping encryption tests"
+    return
+  fi
+  ssh-keygen -q -t ed25519 -N "" -f "${TEST_DIR}/id_ed25519" || error "Failed to generate SSH key"
+
+  echo "Testing encryption to an SSH public key..."
+  ${ARC_BIN} archive -encrypt "$(cat "${TEST_DIR}/id_ed25519.pub")" -c zst -t tar -f "${TEST_DIR}/encrypted.tar.zst.age" "${ARCHIVE_DIR}" || error "Failed to create encrypted archive"
+  ${ARC_BIN} extract -identity "${TEST_DIR}/id_ed25519" -f "${TEST_DIR}/encrypted.tar.zst.age" "${EXTRACT_DIR}/age" || error "Failed to extract encrypted archive"
+  verify_extraction "${EXTRACT_DIR}/age" || error "Encrypted archive extraction verification failed"
+  ${ARC_BIN} test -identity "${TEST_DIR}/id_ed25519" -f "${TEST_DIR}/encrypted.tar.zst.age" || error "Failed to verify encrypted archive"
+
+  echo "Testing extraction without an identity..."
+  if ${ARC_BIN} extract -f "${TEST_DIR}/encrypted.tar.zst.age" "${EXTRACT_DIR}/age_missing"; then
+    error "Extraction of an encrypted archive without identity should fail"
+  fi
+
+  echo "Encryption tests completed successfully"
+}
+
+test_split() {
+  step "Testing split archives"
+
+  echo "Testing creation of volumes..."
+  ${ARC_BIN} archive -split 32K -c gz -t tar -f "${TEST_DIR}/split.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create split archive"
+  [ -f "${TEST_DIR}/split.tar.gz.part001" ] || error "First volume not found"
+  [ -f "${TEST_DIR}/split.tar.gz.part002" ] || error "Second volume not found"
+  [ ! -f "${TEST_DIR}/split.tar.gz" ] || error "Split archive should not create the archive itself"
+
+  echo "Testing extraction of joined volumes..."
+  ${ARC_BIN} extract -f "${TEST_DIR}/split.tar.gz" "${EXTRACT_DIR}/split" || error "Failed to extract split archive"
+  verify_extraction "${EXTRACT_DIR}/split" || error "Split archive extraction verification failed"
+  ${ARC_BIN} extract -f "${TEST_DIR}/split.tar.gz.part001" "${EXTRACT_DIR}/split_part" || error "Failed to extract split archive from its first volume"
+  verify_extraction "${EXTRACT_DIR}/split_part" || error "Split archive extraction verification failed"
+
+  echo "Split tests completed successfully"
+}
+
+test_deterministic() {
+  step "Testing deterministic archives"
+
+  echo "Testing that identical inputs give identical archives..."
+  ${ARC_BIN} archive -deterministic -c gz -t tar -f "${TEST_DIR}/repro1.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create deterministic archive"
+  sleep 1
+  touch "${ARCHIVE_DIR}/test1.txt"
+  ${ARC_BIN} archive -deterministic -c gz -t tar -f "${TEST_DIR}/repro2.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create deterministic archive"
+  cmp "${TEST_DIR}/repro1.tar.gz" "${TEST_DIR}/repro2.tar.gz" || error "Deterministic archives differ"
+
+  echo "Deterministic tests completed successfully"
+}
+
+test_overwrite() {
+  step "Testing overwrite policies"
+
+  local dest="${TEST_DIR}/overwrite"
+  ${ARC_BIN} archive -c gz -t tar -f "${TEST_DIR}/overwrite.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create archive"
+  ${ARC_BIN} extract -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract archive"
+  echo "edited" > "${dest}/to_archive/test1.txt"
+
+  echo "Testing dry runs..."
+  ${ARC_BIN} extract -dry-run -overwrite keep-both -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" > "${TEST_DIR}/plan.txt" || error "Failed to plan the extraction"
+  grep -qx "rename    ${dest}/to_archive/test1 (1).txt" "${TEST_DIR}/plan.txt" || error "Dry run doesn't report the renamed file"
+  [ -e "${dest}/to_archive/test1 (1).txt" ] && error "Dry run extracted a file"
+  ${ARC_BIN} extract -dry-run -overwrite skip-existing -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" | grep -qx "skip      ${dest}/to_archive/test1.txt" || error "Dry run doesn't report the skipped file"
+  if ${ARC_BIN} extract -dry-run -overwrite error-if-exists -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" >/dev/null 2>&1; then
+    error "Dry run of a failing extraction succeeded"
+  fi
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "Dry run overwrote an existing file"
+
+  echo "Testing destination diffs..."
+  rm "${dest}/to_archive/test2.txt"
+  ${ARC_BIN} extract -diff-dest -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" > "${TEST_DIR}/diff.txt" || error "Failed to diff the destination"
+  grep -qx "changed   ${dest}/to_archive/test1.txt" "${TEST_DIR}/diff.txt" || error "Diff doesn't report the changed file"
+  grep -qx "new       ${dest}/to_archive/test2.txt" "${TEST_DIR}/diff.txt" || error "Diff doesn't report the missing file"
+  grep -qx "identical ${dest}/to_archive/subdir/subfile.txt" "${TEST_DIR}/diff.txt" || error "Diff doesn't report the identical file"
+  [ -e "${dest}/to_archive/test2.txt" ] && error "Diff extracted a file"
+
+  echo "Testing overwrite prompts..."
+  printf 'x\nN\n' | ${ARC_BIN} extract -overwrite prompt -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" 2> "${TEST_DIR}/prompt.txt" || error "Failed to extract with prompts"
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "Answering none overwrote an existing file"
+  grep -q "replace .*? \[y\]es, \[n\]o, \[A\]ll, \[N\]one" "${TEST_DIR}/prompt.txt" || error "No overwrite prompt"
+  [ $(grep -o "replace " "${TEST_DIR}/prompt.txt" | wc -l) -eq 2 ] || error "Prompted again after answering none"
+  if ${ARC_BIN} extract -overwrite prompt -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" < /dev/null 2>/dev/null; then
+    error "Prompt without an answer succeeded"
+  fi
+  ${ARC_BIN} extract -keep -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract with -keep"
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "-keep overwrote an existing file"
+  ARC_OVERWRITE=skip-existing ${ARC_BIN} extract -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract with ARC_OVERWRITE"
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "ARC_OVERWRITE=skip-existing overwrote an existing file"
+  if ${ARC_BIN} extract -force -keep -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" 2>/dev/null; then
+    error "-force and -keep were accepted together"
+  fi
+
+  echo "Testing skip-existing..."
+  ${ARC_BIN} extract -overwrite skip-existing -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract with skip-existing"
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "skip-existing overwrote an existing file"
+
+  echo "Testing error-if-exists..."
+  if ${ARC_BIN} extract -overwrite error-if-exists -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" 2>/dev/null; then
+    error "error-if-exists succeeded with existing files"
+  fi
+
+  echo "Testing keep-both..."
+  ${ARC_BIN} extract -overwrite keep-both -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract with keep-both"
+  [ "$(cat "${dest}/to_archive/test1.txt")" = "edited" ] || error "keep-both overwrote an existing file"
+  diff "${ARCHIVE_DIR}/test1.txt" "${dest}/to_archive/test1 (1).txt" || error "keep-both didn't extract to a new name"
+
+  echo "Testing overwrite..."
+  ${ARC_BIN} extract -f "${TEST_DIR}/overwrite.tar.gz" "${dest}" || error "Failed to extract with overwrite"
+  diff "${ARCHIVE_DIR}/test1.txt" "${dest}/to_archive/test1.txt" || error "overwrite didn't replace an existing file"
+
+  echo "Overwrite tests completed successfully"
+}
+
+test_rsyncable() {
+  step "Testing rsyncable output"
+
+  for algo in gz zst; do
+    echo "Testing rsyncable ${algo} archive..."
+    local archive="${TEST_DIR}/rsyncable.tar.${algo}"
+    ${ARC_BIN} archive -rsyncable -c "${algo}" -t tar -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create rsyncable ${algo} archive"
+    ${ARC_BIN} extract -f "${archive}" "${TEST_DIR}/rsyncable_${algo}" || error "Failed to extract rsyncable ${algo} archive"
+    verify_extraction "${TEST_DIR}/rsyncable_${algo}" || error "Rsyncable ${algo} extraction verification failed"
+  done
+
+  echo "Testing that rsyncable requires gzip or zstd..."
+  if ${ARC_BIN} archive -rsyncable -c xz -t tar -f "${TEST_DIR}/rsyncable.tar.xz" "${ARCHIVE_DIR}" 2>/dev/null; then
+    error "Rsyncable xz archive was created"
+  fi
+
+  echo "Rsyncable tests completed successfully"
+}
+
+test_seekable() {
+  step "Testing seekable zstd output"
+
+  local archive="${TEST_DIR}/seekable.tar.zst"
+  ${ARC_BIN} create -seekable -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create seekable archive"
+  # the seek table ends the archive, with its magic number
+  [ "$(tail -c 4 "${archive}" | od -An -tx1 | tr -d ' ')" = "b1ea928f" ] || error "Seekable archive has no seek table"
+  ${ARC_BIN} cat -f "${archive}" to_archive/subdir/subfile.txt | cmp - "${ARCHIVE_DIR}/subdir/subfile.txt" || error "Entry of the seekable archive differs"
+  ${ARC_BIN} extract -f "${archive}" "${EXTRACT_DIR}/seekable" || error "Failed to extract seekable archive"
+  verify_extraction "${EXTRACT_DIR}/seekable" || error "Seekable extraction verification failed"
+  ${ARC_BIN} extract -f - "${EXTRACT_DIR}/seekable_stdin" < "${archive}" || error "Failed to extract seekable archive from stdin"
+  verify_extraction "${EXTRACT_DIR}/seekable_stdin" || error "Seekable extraction from stdin verification failed"
+  if command -v zstd >/dev/null 2>&1; then
+    zstd -q -t "${archive}" || error "zstd can't read the seekable archive"
+  fi
+
+  echo "Testing that seekable requires zstd..."
+  if ${ARC_BIN} create -seekable -f "${TEST_DIR}/seekable.tar.gz" "${ARCHIVE_DIR}" 2>/dev/null; then
+    error "Seekable gzip archive was created"
+  fi
+
+  echo "Seekable tests completed successfully"
+}
+
+test_strip_components() {
+  step "Testing strip components"
+
+  local dest="${TEST_DIR}/stripped"
+  ${ARC_BIN} archive -c gz -t tar -f "${TEST_DIR}/strip.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create archive"
+  ${ARC_BIN} extract -strip-components 1 -f "${TEST_DIR}/strip.tar.gz" "${dest}" || error "Failed to extract with -strip-components"
+  diff "${ARCHIVE_DIR}/test1.txt" "${dest}/test1.txt" || error "Top level directory wasn't stripped"
+  [ -f "${dest}/subdir/subfile.txt" ] || error "Subdirectory missing after stripping"
+  [ ! -e "${dest}/to_archive" ] || error "Stripped directory was extracted"
+
+  echo "Strip components tests completed successfully"
+}
+
+test_recursive() {
+  step "Testing recursive extraction"
+
+  local release="${TEST_DIR}/release"
+  mkdir -p "${release}"
+  ${ARC_BIN} create -f "${release}/app-1.0.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create inner archive"
+  ${ARC_BIN} create -f "${TEST_DIR}/release.zip" "${release}" || error "Failed to create outer archive"
+
+  ${ARC_BIN} extract -recursive -f "${TEST_DIR}/release.zip" "${EXTRACT_DIR}/recursive" || error "Failed to extract recursively"
+  diff "${ARCHIVE_DIR}/test1.txt" "${EXTRACT_DIR}/recursive/release/app-1.0/to_archive/test1.txt" || error "Nested archive wasn't extracted in place"
+  [ ! -e "${EXTRACT_DIR}/recursive/release/app-1.0.tar.gz" ] || error "Nested archive wasn't removed"
+
+  ${ARC_BIN} extract -recursive -recursive-depth 0 -f "${TEST_DIR}/release.zip" "${EXTRACT_DIR}/not_recursive" || error "Failed to extract"
+  [ -f "${EXTRACT_DIR}/not_recursive/release/app-1.0.tar.gz" ] || error "Nested archive was extracted beyond the depth"
+
+  echo "Recursive extraction tests completed successfully"
+}
+
+test_sync() {
+  step "Testing sync extraction"
+
+  local site="${EXTRACT_DIR}/site"
+  ${ARC_BIN} create -f "${TEST_DIR}/site.tar.gz" -C "${ARCHIVE_DIR}" . || error "Failed to create site archive"
+  mkdir -p "${site}/old/assets"
+  echo "stale" > "${site}/stale.html"
+  echo "stale" > "${site}/old/assets/app.js"
+  echo "previous" > "${site}/test1.txt"
+
+  ${ARC_BIN} extract -sync -dry-run -f "${TEST_DIR}/site.tar.gz" "${site}" | grep -q "^delete.*stale.html" || error "Dry run didn't list the files -sync removes"
+  [ -f "${site}/stale.html" ] || error "Dry run removed a file"
+
+  ${ARC_BIN} extract -sync -force -f "${TEST_DIR}/site.tar.gz" "${site}" || error "Failed to extract with -sync"
+  diff -r "${ARCHIVE_DIR}" "${site}" || error "Destination isn't a mirror of the archive"
+
+  ${ARC_BIN} extract -sync -f "${TEST_DIR}/site.tar.gz" > /dev/null 2>&1 && error "-sync without an explicit destination was accepted"
+
+  echo "Sync extraction tests completed successfully"
+}
+
+test_allowed_roots() {
+  step "Testing allowed roots"
+
+  local roots="${TEST_DIR}/roots"
+  mkdir -p "${roots}/apps"
+  ${ARC_BIN} extract -allowed-roots "${roots}/apps" -f "${TEST_DIR}/strip.tar.gz" "${roots}/apps/current" || error "Failed to extract within the allowed roots"
+  verify_extraction "${roots}/apps/current" || error "Extraction within the allowed roots failed"
+  ${ARC_BIN} extract -allowed-roots "${roots}/apps" -f "${TEST_DIR}/strip.tar.gz" "${roots}/other" 2>/dev/null && error "Extraction outside of the allowed roots was accepted"
+  [ ! -e "${roots}/other" ] || error "Destination outside of the allowed roots was created"
+  ln -s "${roots}" "${roots}/apps/escape"
+  ${ARC_BIN} extract -allowed-roots "${roots}/apps" -f "${TEST_DIR}/strip.tar.gz" "${roots}/apps/escape/other" 2>/dev/null && error "Extraction through a symlink out of the allowed roots was accepted"
+
+  echo "Testing archives outside of the allowed roots..."
+  ${ARC_BIN} create -allowed-roots "${roots}/apps" -f "${roots}/apps/backup.tar.gz" "${ARCHIVE_DIR}" || error "Failed to create an archive within the allowed roots"
+  mkdir -p "${roots}/victim"
+  echo "precious" > "${roots}/victim/data"
+  for format in tar zip; do
+    ${ARC_BIN} create -allowed-roots "${roots}/apps" -t "${format}" -c gz -f "${roots}/victim" "${ARCHIVE_DIR}" 2>/dev/null && error "${format} archive outside of the allowed roots was accepted"
+    [ -f "${roots}/victim/data" ] || error "Creating a ${format} archive outside of the allowed roots removed what was there"
+  done
+  ${ARC_BIN} create -allowed-roots "${roots}/apps" -f "${roots}/apps/backup.tar.gz" -manifest-file "${roots}/SHA256SUMS" "${ARCHIVE_DIR}" 2>/dev/null && error "Manifest file outside of the allowed roots was accepted"
+  [ -f "${roots}/apps/backup.tar.gz" ] || error "Refused manifest file removed the archive"
+
+  echo "Allowed roots tests completed successfully"
+}
+
+test_sfx() {
+  step "Testing self-extracting executables"
+
+  local stub
+  stub="$(dirname "${ARC_BIN}")/arc-sfx"
+  if [ ! -x "${stub}" ]; then
+    if ! command -v go >/dev/null; then
+      echo "arc-sfx not found, skipping self-extracting tests"
+      return
+    fi
+    stub="${TEST_DIR}/arc-sfx"
+    (cd "$(dirname "${BASH_SOURCE[0]}")/.." && go build -o "${stub}" ./cmd/arc-sfx) || error "Failed to build the arc-sfx stub"
+  fi
+
+  local sfx="${TEST_DIR}/install.run"
+  ${ARC_BIN} sfx -stub "${stub}" -f "${TEST_DIR}/strip.tar.gz" -o "${sfx}" -dest app -post-cmd 'echo "installed in ${ARC_DESTINATION}" > marker.txt' || error "Failed to create self-extracting executable"
+  (cd "${TEST_DIR}" && "${sfx}" 2>/dev/null) || error "Self-extracting executable failed"
+  verify_extraction "${TEST_DIR}/app" || error "Self-extracting executable extracted the wrong files"
+  grep -qx "installed in app" "${TEST_DIR}/app/marker.txt" || error "Post command didn't run in the destination"
+  "${sfx}" -C "${TEST_DIR}/sfx_elsewhere" -no-post-cmd 2>/dev/null || error "Self-extracting executable failed with -C"
+  verify_extraction "${TEST_DIR}/sfx_elsewhere" || error "Self-extracting executable ignored -C"
+  [ ! -e "${TEST_DIR}/sfx_elsewhere/marker.txt" ] || error "Post command ran with -no-post-cmd"
+  ${ARC_BIN} sfx -stub "${sfx}" -f "${TEST_DIR}/strip.tar.gz" -o "${TEST_DIR}/nested.run" 2>/dev/null && error "A self-extracting executable was accepted as stub"
+
+  echo "Self-extracting tests completed successfully"
+}
+
+test_checksum_trailer() {
+  step "Testing checksum trailers"
+
+  local archive="${TEST_DIR}/trailer.tar.gz"
+  ${ARC_BIN} archive -checksum-trailer -c gz -t tar -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create archive with checksum trailer"
+  ${ARC_BIN} test -f "${archive}" || error "Failed to verify archive with checksum trailer"
+  ${ARC_BIN} extract -f "${archive}" "${TEST_DIR}/trailer_extract" || error "Failed to extract archive with checksum trailer"
+  verify_extraction "${TEST_DIR}/trailer_extract" || error "Extraction with checksum trailer verification failed"
+
+  echo "Testing that corruption is detected..."
+  cp "${archive}" "${TEST_DIR}/trailer_bad.tar.gz"
+  printf 'X' | dd of="${TEST_DIR}/trailer_bad.tar.gz" bs=1 seek=100 conv=notrunc 2>/dev/null
+  if ${ARC_BIN} test -f "${TEST_DIR}/trailer_bad.tar.gz" 2>/dev/null; then
+    error "Corrupted archive passed the checksum trailer"
+  fi
+  ${ARC_BIN} test -f - < "${archive}" || error "Failed to verify archive with checksum trailer from stdin"
+  # the archive is intact, the checksum in its trailer isn't
+  cp "${archive}" "${TEST_DIR}/trailer_sum.tar.gz"
+  printf 'X' | dd of="${TEST_DIR}/trailer_sum.tar.gz" bs=1 seek=$(($(stat -c%s "${archive}") - 20)) conv=notrunc 2>/dev/null
+  ${ARC_BIN} test -f - < "${TEST_DIR}/trailer_sum.tar.gz" 2>&1 | grep -q "checksum trailer mismatch" || error "Checksum trailer mismatch from stdin wasn't detected"
+
+  echo "Testing trailer removal..."
+  ${ARC_BIN} test -strip-trailer -f "${archive}" || error "Failed to strip checksum trailer"
+  if command -v gzip &> /dev/null; then
+    gzip -t "${archive}" 2>&1 | grep -q "trailing garbage" && error "Checksum trailer wasn't removed"
+  fi
+  ${ARC_BIN} test -f "${archive}" || error "Failed to verify archive without checksum trailer"
+
+  echo "Checksum trailer tests completed successfully"
+}
+
+test_to_command() {
+  step "Testing piping entries to a command"
+
+  local archive="${TEST_DIR}/to_command.tar.gz"
+  ${ARC_BIN} archive -c gz -t tar -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create archive"
+  ${ARC_BIN} extract -to-command 'cat > "'"${TEST_DIR}"'/piped_$(basename "$ARC_FILENAME")"' -f "${archive}" || error "Failed to pipe entries to a command"
+  diff "${ARCHIVE_DIR}/test1.txt" "${TEST_DIR}/piped_test1.txt" || error "Piped content differs"
+  diff "${ARCHIVE_DIR}/subdir/subfile.txt" "${TEST_DIR}/piped_subfile.txt" || error "Piped content differs"
+
+  echo "Testing that failing commands are reported..."
+  if ${ARC_BIN} extract -to-command 'exit 1' -f "${archive}" 2>/dev/null; then
+    error "Failing command wasn't reported"
+  fi
+
+  echo "Testing pre and post extraction hooks..."
+  ${ARC_BIN} extract -f "${archive}" -pre-cmd 'echo stopped > "$ARC_DESTINATION.pre"' -post-cmd 'echo "$ARC_STATUS" > "$ARC_DESTINATION.post"' "${TEST_DIR}/hooked" || error "Failed to extract with hooks"
+  grep -qx "stopped" "${TEST_DIR}/hooked.pre" || error "Pre command didn't run"
+  grep -qx "success" "${TEST_DIR}/hooked.post" || error "Post command didn't get the status"
+  if ${ARC_BIN} extract -f "${TEST_DIR}/missing.tar.gz" -post-cmd 'echo "$ARC_STATUS" > "$ARC_DESTINATION.post"' "${TEST_DIR}/hooked" 2>/dev/null; then
+    error "Missing archive wasn't reported"
+  fi
+  grep -qx "failure" "${TEST_DIR}/hooked.post" || error "Post command didn't get the failure"
+  if ${ARC_BIN} extract -f "${archive}" -pre-cmd 'exit 1' "${TEST_DIR}/not_hooked" 2>/dev/null || [ -e "${TEST_DIR}/not_hooked" ]; then
+    error "Extraction ran after a failing pre command"
+  fi
+
+  echo "Command piping tests completed successfully"
+}
+
+# Test restoring symlinks and modification times
+test_preserve() {
+  step "Testing metadata preservation"
+
+  local src="${TEST_DIR}/preserve_src"
+  mkdir -p "${src}"
+  echo "target" > "${src}/target.txt"
+  touch -d "2020-01-02 03:04:05" "${src}/target.txt"
+  ln -s target.txt "${src}/inside"
+  ln -s /etc/passwd "${src}/outside"
+  ${ARC_BIN} create -c gz -t tar -f "${TEST_DIR}/preserve.tar.gz" "${src}" || error "Failed to create archive"
+
+  ${ARC_BIN} extract -preserve -f "${TEST_DIR}/preserve.tar.gz" "${TEST_DIR}/preserved" || error "Failed to extract with -preserve"
+  [ "$(readlink "${TEST_DIR}/preserved/preserve_src/inside")" == "target.txt" ] || error "Symlink wasn't restored"
+  [ ! -e "${TEST_DIR}/preserved/preserve_src/outside" ] || error "Symlink outside of the destination was created"
+  [ "${TEST_DIR}/preserved/preserve_src/target.txt" -ot "${TEST_DIR}/preserve.tar.gz" ] || error "Modification time wasn't restored"
+
+  echo "Testing that -strict fails on skipped symlinks..."
+  if ${ARC_BIN} extract -preserve -strict -f "${TEST_DIR}/preserve.tar.gz" "${TEST_DIR}/strict" 2>/dev/null; then
+    error "Strict extraction should fail"
+  fi
+
+  if command -v python3 >/dev/null 2>&1; then
+    echo "Testing symlink chains out of the destination..."
+    local escape="${TEST_DIR}/escape"
+    mkdir -p "${escape}"
+    python3 - "${escape}/chain.tar" <<'EOF'
+import io, sys, tarfile
+
+with tarfile.open(sys.argv[1], "w") as tar:
+    def add(name, kind, target="", data=b""):
+        info = tarfile.TarInfo(name)
+        info.type, info.linkname, info.size = kind, target, len(data)
+        tar.addfile(info, io.BytesIO(data))
+    add("a", tarfile.DIRTYPE)
+    add("a/b", tarfile.SYMTYPE, "..")
+    add("a/up", tarfile.SYMTYPE, "b/../..")
+    add("a/b/c", tarfile.SYMTYPE, "..")
+    add("a/b/c/escaped.txt", tarfile.REGTYPE, data=b"escaped\n")
+EOF
+    ${ARC_BIN} extract -preserve -f "${escape}/chain.tar" "${escape}/out" 2>/dev/null && error "Extraction through a chain of symlinks was accepted"
+    [ ! -e "${escape}/escaped.txt" ] || error "A chain of symlinks led a file out of the destination"
+    [ ! -e "${escape}/out/c" ] || error "A symlink under a symlinked parent was created"
+    [ ! -L "${escape}/out/a/up" ] || error "A symlink resolving out of the destination through another one was created"
+    [ "$(readlink "${escape}/out/a/b")" == ".." ] || error "Symlink inside of the destination wasn't restored"
+  fi
+
+  echo "Preservation tests completed successfully"
+}
+
+# Test streaming archives through stdout and stdin
+test_stdio() {
+  step "Testing archives streamed through pipes"
+
+  ${ARC_BIN} create -c zst -t tar -f - "${ARCHIVE_DIR}" | ${ARC_BIN} extract -f - "${TEST_DIR}/piped_tar" || error "Failed to pipe a tar archive"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/piped_tar/to_archive" || error "Piped tar archive differs"
+
+  echo "Testing zip archives through a pipe..."
+  ${ARC_BIN} create -t zip -f - "${ARCHIVE_DIR}" | ${ARC_BIN} extract -f - "${TEST_DIR}/piped_zip" || error "Failed to pipe a zip archive"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/piped_zip/to_archive" || error "Piped zip archive differs"
+
+  echo "Stream tests completed successfully"
+}
+
+# Test replacing a running binary, which Linux refuses to write
+test_locked() {
+  step "Testing extraction over files in use"
+
+  local src="${TEST_DIR}/locked_src" dst="${TEST_DIR}/locked_dst"
+  mkdir -p "${src}" "${dst}"
+  cp "$(command -v sleep)" "${dst}/app"
+  cp "$(command -v sleep)" "${src}/app"
+  echo "new version" >> "${src}/app"
+  ${ARC_BIN} create -c gz -f "${TEST_DIR}/locked.tar.gz" "${src}" || error "Failed to create archive"
+
+  "${dst}/app" 10 &
+  local pid=$!
+  sleep 0.5
+  if ${ARC_BIN} extract -f "${TEST_DIR}/locked.tar.gz" -strip-components 1 "${dst}" 2>/dev/null; then
+    kill ${pid}
+    error "Replacing a running binary didn't fail"
+  fi
+  ${ARC_BIN} extract -f "${TEST_DIR}/locked.tar.gz" -strip-components 1 -on-locked rename "${dst}" || error "Failed to move a running binary aside"
+  kill ${pid}
+  cmp "${src}/app" "${dst}/app" || error "Running binary wasn't replaced"
+
+  echo "Locked file tests completed successfully"
+}
+
+# Test interrupting create and extract
+# wait_for runs a command until it succeeds, for up to 30 seconds
+wait_for() {
+  local what="$1" i
+  shift
+  for i in $(seq 300); do
+    "$@" && return 0
+    sleep 0.1
+  done
+  error "Timed out waiting for ${what}"
+}
+
+test_cancel() {
+  step "Testing interrupted archiving and extraction"
+
+  local src="${TEST_DIR}/cancel_src" archive="${TEST_DIR}/cancel.tar.xz"
+  mkdir -p "${src}"
+  head -c 64000000 /dev/urandom > "${src}/big.bin"
+
+  ${ARC_BIN} create -c xz -f "${archive}" "${src}" 2>/dev/null &
+  local pid=$!
+  # interrupt once the archive is being written
+  wait_for "archiving to start" test -s "${archive}"
+  kill -INT ${pid}
+  wait ${pid} && error "Interrupted archiving succeeded"
+  [ -e "${archive}" ] && error "Interrupted archiving left a partial archive"
+
+  echo "Testing interrupted extraction..."
+  ${ARC_BIN} create -c gz -level 1 -f "${TEST_DIR}/cancel.tar.gz" "${src}" || error "Failed to create archive"
+  local fifo="${TEST_DIR}/cancel.fifo" gate="${TEST_DIR}/cancel.gate" dst="${TEST_DIR}/cancel_dst"
+  mkfifo "${fifo}" "${gate}"
+  # stall the stream in the middle of the file until the signal was sent,
+  # head fails if arc was done reading before
+  { head -c 32000000 "${TEST_DIR}/cancel.tar.gz" || true; read -r < "${gate}"; tail -c +32000001 "${TEST_DIR}/cancel.tar.gz"; } > "${fifo}" 2>/dev/null &
+  ${ARC_BIN} extract -f - "${dst}" < "${fifo}" 2>/dev/null &
+  pid=$!
+  wait_for "extraction to start" test -s "${dst}/cancel_src/big.bin"
+  kill -INT ${pid}
+  echo > "${gate}"
+  wait ${pid} && error "Interrupted extraction succeeded"
+  [ -e "${dst}/cancel_src/big.bin" ] && error "Interrupted extraction left a partial file"
+  wait
+
+  echo "Cancellation tests completed successfully"
+}
+
+# Test the list and convert subcommands
+test_list_convert() {
+  step "Testing listing and converting archives"
+
+  local archive="${TEST_DIR}/list.zip"
+  ${ARC_BIN} create -t zip -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create archive"
+  ${ARC_BIN} list -f "${archive}" | grep -qx "to_archive/subdir/subfile.txt" || error "Listing misses an entry"
+  ${ARC_BIN} list -l -f "${archive}" | grep -q " 102400 .* to_archive/binary_file.bin$" || error "Long listing lacks the size"
+  ${ARC_BIN} list -json -f "${archive}" | grep -q '"name":"to_archive/test1.txt","type":"file","size":20' || error "JSON listing misses an entry"
+  ${ARC_BIN} list -f - < "${archive}" | grep -qx "to_archive/subdir/subfile.txt" || error "Listing of a zip archive from stdin misses an entry"
+  ${ARC_BIN} list -l -f - < "${TEST_DIR}/overwrite.tar.gz" | grep -q " 102400 .* to_archive/binary_file.bin$" || error "Listing of a tar.gz archive from stdin misses an entry"
+  [ "$(${ARC_BIN} create -t tar -c zst -f - "${ARCHIVE_DIR}" | ${ARC_BIN} list -limit 2 -f - | wc -l)" -eq 2 ] || error "Listing of a piped archive ignores -limit"
+
+  echo "Testing printing entries..."
+  ${ARC_BIN} cat -f "${archive}" to_archive/subdir/subfile.txt | cmp - "${ARCHIVE_DIR}/subdir/subfile.txt" || error "cat printed the wrong content"
+  ${ARC_BIN} cat -f "${TEST_DIR}/overwrite.tar.gz" ./to_archive/binary_file.bin | cmp - "${ARCHIVE_DIR}/binary_file.bin" || error "cat of a tar.gz entry failed"
+  if ${ARC_BIN} cat -f "${archive}" to_archive/missing.txt 2>/dev/null; then
+    error "cat of a missing entry succeeded"
+  fi
+  if ${ARC_BIN} cat -f "${archive}" to_archive/subdir >/dev/null 2>&1; then
+    error "cat of a directory succeeded"
+  fi
+  ${ARC_BIN} cat -f - to_archive/subdir/subfile.txt < "${archive}" | cmp - "${ARCHIVE_DIR}/subdir/subfile.txt" || error "cat of a zip entry from stdin failed"
+  ${ARC_BIN} cat -f - to_archive/binary_file.bin < "${TEST_DIR}/overwrite.tar.gz" | cmp - "${ARCHIVE_DIR}/binary_file.bin" || error "cat of a tar.gz entry from stdin failed"
+  if ${ARC_BIN} cat -f - to_archive/missing.txt < "${archive}" 2>/dev/null; then
+    error "cat of a missing entry from stdin succeeded"
+  fi
+
+  echo "Testing time zone independent mtimes..."
+  mkdir -p "${TEST_DIR}/tz"
+  echo "noon" > "${TEST_DIR}/tz/noon.txt"
+  touch -d "2024-05-01 12:00:00 UTC" "${TEST_DIR}/tz/noon.txt"
+  TZ=Asia/Tokyo ${ARC_BIN} create -t zip -f "${TEST_DIR}/tz.zip" "${TEST_DIR}/tz/noon.txt" || error "Failed to create archive in another time zone"
+  TZ=America/New_York ${ARC_BIN} list -l -tz UTC -f "${TEST_DIR}/tz.zip" | grep -q " 2024-05-01 12:00:00 noon.txt$" || error "Listing in UTC shows the wrong mtime"
+  ${ARC_BIN} list -json -tz Asia/Tokyo -f "${TEST_DIR}/tz.zip" | grep -q '"mtime":"2024-05-01T21:00:00+09:00"' || error "Listing doesn't use the time zone of -tz"
+
+  echo "Testing conversion from zip to tar.gz..."
+  ${ARC_BIN} convert -c gz -t tar "${archive}" "${TEST_DIR}/converted.tar.gz" || error "Failed to convert archive"
+  ${ARC_BIN} extract -f "${TEST_DIR}/converted.tar.gz" "${TEST_DIR}/converted" || error "Failed to extract converted archive"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/converted/to_archive" || error "Converted archive differs"
+
+  echo "Testing conversion with the format inferred from the output name..."
+  ${ARC_BIN} convert -zstd-level 19 "${archive}" "${TEST_DIR}/converted.tar.zst" || error "Failed to convert archive to tar.zst"
+  ${ARC_BIN} extract -f "${TEST_DIR}/converted.tar.zst" "${TEST_DIR}/converted_zst" || error "Failed to extract converted tar.zst"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/converted_zst/to_archive" || error "Converted tar.zst differs"
+  ${ARC_BIN} convert "${TEST_DIR}/converted.tar.zst" "${TEST_DIR}/back.zip" || error "Failed to convert tar.zst back to zip"
+  ${ARC_BIN} list -f "${TEST_DIR}/back.zip" | grep -qx "to_archive/subdir/subfile.txt" || error "Converted zip misses an entry"
+  if ${ARC_BIN} convert "${archive}" "${TEST_DIR}/single.gz" 2>/dev/null; then
+    error "Converting to a single compressed file should fail"
+  fi
+
+  echo "Testing conversion of archives read from stdin..."
+  ${ARC_BIN} convert - "${TEST_DIR}/stdin.tar.xz" < "${archive}" || error "Failed to convert a zip archive from stdin"
+  ${ARC_BIN} extract -f "${TEST_DIR}/stdin.tar.xz" "${TEST_DIR}/converted_stdin" || error "Failed to extract archive converted from stdin"
+  diff -r "${ARCHIVE_DIR}" "${TEST_DIR}/converted_stdin/to_archive" || error "Archive converted from stdin differs"
+  ${ARC_BIN} create -t tar -c zst -f - "${ARCHIVE_DIR}" | ${ARC_BIN} convert - "${TEST_DIR}/piped.zip" || error "Failed to convert a piped archive"
+  ${ARC_BIN} list -f "${TEST_DIR}/piped.zip" | grep -qx "to_archive/subdir/subfile.txt" || error "Zip converted from a pipe misses an entry"
+  if head -c 1000 "${TEST_DIR}/converted.tar.zst" | ${ARC_BIN} convert - "${TEST_DIR}/truncated.zip" 2>/dev/null; then
+    error "Converting a truncated archive from stdin should fail"
+  fi
+  [ ! -e "${TEST_DIR}/truncated.zip" ] || error "Failed conversion from stdin left its output"
+
+  echo "List and convert tests completed successfully"
+}
+
+# Test stamping archives with metadata
+test_touch() {
+  step "Testing archive metadata"
+
+  for archive in "${TEST_DIR}/touch.tar.zst" "${TEST_DIR}/touch.tar.gz" "${TEST_DIR}/touch.zip"; do
+    echo "Testing metadata of $(basename "${archive}")..."
+    ${ARC_BIN} create -checksum-trailer -f "${archive}" "${ARCHIVE_DIR}" || error "Failed to create archive"
+    ${ARC_BIN} touch -f "${archive}" -set build_id=123 -set git_sha=abc > /dev/null || error "Failed to set metadata"
+    ${ARC_BIN} touch -f "${archive}" -set build_id=124 -set git_sha= | grep -qx "build_id=124" || error "Metadata wasn't updated"
+    ${ARC_BIN} touch -f "${archive}" | grep -q "git_sha" && error "Metadata key wasn't removed"
+    ${ARC_BIN} test -f "${archive}" || error "Archive with metadata failed verification"
+    ${ARC_BIN} extract -f "${archive}" "${TEST_DIR}/touch_$(basename "${archive}")" || error "Failed to extract archive with metadata"
+    verify_extraction "${TEST_DIR}/touch_$(basename "${archive}")" || error "Archive with metadata verification failed"
+  done
+  ${ARC_BIN} create -f "${TEST_DIR}/touch.tar" "${ARCHIVE_DIR}" || error "Failed to create tar"
+  if ${ARC_BIN} touch -f "${TEST_DIR}/touch.tar" -set a=b 2>/dev/null; then
+    error "Metadata set on a plain tar"
+  fi
+
+  echo "Metadata tests completed successfully"
+}
+
+# Test undoing every step of an archive with restore
+test_restore() {
+  step "Testing restore"
+
+  echo "Testing restoring a split archive with a checksum trailer..."
+  ${ARC_BIN} create -split 32K -checksum-trailer -c zst -t tar -f "${TEST_DIR}/restore.tar.zst" "${ARCHIVE_DIR}" || error "Failed to create split archive"
+  ${ARC_BIN} restore -f "${TEST_DIR}/restore.tar.zst.part002" "${TEST_DIR}/restore_split" || error "Failed to restore split archive"
+  verify_extraction "${TEST_DIR}/restore_split" || error "Restored split archive verification failed"
+
+  if [ -f "${TEST_DIR}/id_ed25519" ]; then
+    echo "Testing restoring an encrypted archive..."
+    ${ARC_BIN} restore -identity "${TEST_DIR}/id_ed25519" -f "${TEST_DIR}/encrypted.tar.zst.age" "${TEST_DIR}/restore_age" 2>&1 | grep -q "decrypt" || error "Restore of an encrypted archive didn't report decryption"
+    verify_extraction "${TEST_DIR}/restore_age" || error "Restored encrypted archive verification failed"
+  fi
+
+  echo "Testing restoring a compressed file..."
+  ${ARC_BIN} restore -f "${COMPRESS_DIR}/large_text.xz" "${TEST_DIR}/restore_file" || error "Failed to restore compressed file"
+  cmp "${TEST_DIR}/restore_file/large_text" "${COMPRESS_DIR}/large_text.txt" || error "Restored file differs"
+  if ${ARC_BIN} restore -f "${COMPRESS_DIR}/large_text.txt" "${TEST_DIR}/restore_plain" 2>/dev/null; then
+    error "Restore of a plain file succeeded"
+  fi
+
+  echo "Restore tests completed successfully"
+}
+
+# Test extracting archives from a URL
+test_url() {
+  step "Testing extraction from a URL"
+
+  if ! command -v python3 >/dev/null || ! command -v curl >/dev/null; then
+    echo "python3 or curl not found, skipping URL tests"
+    return
+  fi
+  local port=8765
+  (cd "${TEST_DIR}" && exec python3 -m http.server -b 127.0.0.1 ${port}) >/dev/null 2>&1 &
+  local server=$!
+  for _ in $(seq 50); do
+    curl -s -o /dev/null "http://127.0.0.1:${port}/" 2>/dev/null && break
+    sleep 0.1
+  done
+
+  local sum
+  sum=$(sha256sum "${TEST_DIR}/archive.zip" | cut -d' ' -f1)
+  ${ARC_BIN} extract -retries 1 -sha256 "${sum}" -f "http://127.0.0.1:${port}/archive.zip" "${TEST_DIR}/url_zip" || { kill ${server}; error "Failed to extract a zip archive from a URL"; }
+  verify_extraction "${TEST_DIR}/url_zip" || { kill ${server}; error "URL extraction verification failed"; }
+  ${ARC_BIN} extract -f "http://127.0.0.1:${port}/archive.tar.gz" "${TEST_DIR}/url_tar" || { kill ${server}; error "Failed to stream a tar.gz archive from a URL"; }
+  verify_extraction "${TEST_DIR}/url_tar" || { kill ${server}; error "URL extraction verification failed"; }
+  if ${ARC_BIN} extract -sha256 "$(printf '0%.0s' $(seq 64))" -f "http://127.0.0.1:${port}/archive.zip" "${TEST_DIR}/url_bad" 2>/dev/null; then
+    kill ${server}
+    error "Extraction with a wrong checksum succeeded"
+  fi
+  [ ! -e "${TEST_DIR}/url_bad/to_archive" ] || { kill ${server}; error "Archive with a wrong checksum was extracted"; }
+  (cd "${TEST_DIR}" && sha256sum archive.tar.gz > archive.tar.gz.sha256)
+  ${ARC_BIN} extract -verify-checksum -f "http://127.0.0.1:${port}/archive.tar.gz" "${TEST_DIR}/url_sum" || { kill ${server}; error "Failed to extract a URL with its checksum file"; }
+  verify_extraction "${TEST_DIR}/url_sum" || { kill ${server}; error "URL extraction verification failed"; }
+  if ${ARC_BIN} extract -verify-checksum -f "http://127.0.0.1:${port}/archive.zip" "${TEST_DIR}/url_nosum" 2>/dev/null; then
+    kill ${server}
+    error "Extraction of a URL without a checksum file succeeded"
+  fi
+
+  echo "Testing mirrors..."
+  ${ARC_BIN} extract -retries 0 -sha256 "${sum}" -f "http://127.0.0.1:${port}/missing.zip" -mirror "http://127.0.0.1:${port}/archive.tar.gz" -mirror "http://127.0.0.1:${port}/archive.zip" "${TEST_DIR}/url_mirror" || { kill ${server}; error "Failed to extract from the mirror with the pinned checksum"; }
+  verify_extraction "${TEST_DIR}/url_mirror" || { kill ${server}; error "Mirror extraction verification failed"; }
+  if ${ARC_BIN} extract -f "http://127.0.0.1:${port}/missing.zip" -mirror "http://127.0.0.1:${port}/archive.zip" "${TEST_DIR}/url_mirror_nosum" 2> "${TEST_DIR}/mirror.log"; then
+    kill ${server}
+    error "Mirrors without a checksum were accepted"
+  fi
+  grep -q "require the checksum" "${TEST_DIR}/mirror.log" || { kill ${server}; error "Mirrors without a checksum failed for another reason"; }
+  kill ${server}
+
+  echo "URL tests completed successfully"
+}
+
+# Test serving archives over HTTP
+test_serve() {
+  step "Testing the archive server"
+
+  if ! command -v curl >/dev/null; then
+    echo "curl not found, skipping server tests"
+    return
+  fi
+  local serve_dir="${TEST_DIR}/served" port=8766
+  mkdir -p "${serve_dir}/releases"
+  cp "${TEST_DIR}/archive.tar.gz" "${serve_dir}/releases/"
+  cp -r "${ARCHIVE_DIR}" "${serve_dir}/to_archive"
+  ${ARC_BIN} serve -dir "${serve_dir}" -listen 127.0.0.1:${port} -cache-dir "${TEST_DIR}/serve_cache" >/dev/null 2>&1 &
+  local server=$!
+  for _ in $(seq 50); do
+    curl -s -o /dev/null "http://127.0.0.1:${port}/" 2>/dev/null && break
+    sleep 0.1
+  done
+  local base="http://127.0.0.1:${port}"
+
+  echo "Testing the index..."
+  curl -sf "${base}/?format=json" | grep -q '"name":"releases/archive.tar.gz","type":"archive"' || { kill ${server}; error "Index misses the archive"; }
+
+  echo "Testing downloads..."
+  curl -sf "${base}/files/releases/archive.tar.gz" | cmp - "${TEST_DIR}/archive.tar.gz" || { kill ${server}; error "Downloaded archive differs"; }
+  curl -sf "${base}/browse/releases/archive.tar.gz/to_archive/test1.txt" | cmp - "${ARCHIVE_DIR}/test1.txt" || { kill ${server}; error "Downloaded entry differs"; }
+  [ "$(find "${TEST_DIR}/serve_cache" -type f | wc -l)" -eq 1 ] || { kill ${server}; error "Downloaded entry not cached"; }
+  curl -sf "${base}/browse/releases/archive.tar.gz/to_archive/test1.txt" | cmp - "${ARCHIVE_DIR}/test1.txt" || { kill ${server}; error "Cached entry differs"; }
+  curl -sf "${base}/browse/releases/archive.tar.gz/to_archive/" | grep -q 'test1.txt' || { kill ${server}; error "Directory listing misses an entry"; }
+
+  echo "Testing archives created on the fly..."
+  curl -sf -o "${TEST_DIR}/served.tar.zst" "${base}/archive/to_archive.tar.zst" || { kill ${server}; error "Failed to download a directory as tar.zst"; }
+  ${ARC_BIN} extract -f "${TEST_DIR}/served.tar.zst" "${TEST_DIR}/served_tar" || { kill ${server}; error "Failed to extract the served tar.zst"; }
+  verify_extraction "${TEST_DIR}/served_tar" || { kill ${server}; error "Served tar.zst verification failed"; }
+  curl -sf -o "${TEST_DIR}/served.zip" "${base}/archive/to_archive.zip" || { kill ${server}; error "Failed to download a directory as zip"; }
+  ${ARC_BIN} extract -f "${TEST_DIR}/served.zip" "${TEST_DIR}/served_zip" || { kill ${server}; error "Failed to extract the served zip"; }
+  verify_extraction "${TEST_DIR}/served_zip" || { kill ${server}; error "Served zip verification failed"; }
+  if curl -sf -o /dev/null "${base}/archive/../../etc.tar"; then
+    kill ${server}
+    error "Server archived a directory outside of -dir"
+  fi
+  kill ${server}
+
+  echo "Server tests completed successfully"
+}
+
+# Test Zip64 archives: forced, and needed for 65535 entries or more
+test_zip64() {
+  step "Testing Zip64 archives"
+
+  echo "Testing forced Zip64 records..."
+  ${ARC_BIN} create -zip64 force -f "${TEST_DIR}/forced64.zip" -C "${TEST_DIR}" to_archive || error "Failed to create ZIP with -zip64 force"
+  grep -q $'PK\x06\x06' "${TEST_DIR}/forced64.zip" || error "-zip64 force didn't write a Zip64 end of central directory"
+  ${ARC_BIN} extract -f "${TEST_DIR}/forced64.zip" "${EXTRACT_DIR}/forced64" || error "Failed to extract forced Zip64 archive"
+  verify_extraction "${EXTRACT_DIR}/forced64" || error "Forced Zip64 extraction verification failed"
+  ${ARC_BIN} create -zip64 force -p secret -f "${TEST_DIR}/forced64_aes.zip" -C "${TEST_DIR}" to_archive || error "Failed to create encrypted ZIP with -zip64 force"
+  ${ARC_BIN} extract -p secret -f "${TEST_DIR}/forced64_aes.zip" "${EXTRACT_DIR}/forced64_aes" || error "Failed to extract encrypted forced Zip64 archive"
+  verify_extraction "${EXTRACT_DIR}/forced64_aes" || error "Encrypted forced Zip64 extraction verification failed"
+  if command -v unzip >/dev/null; then
+    unzip -tq "${TEST_DIR}/forced64.zip" >/dev/null || error "unzip can't read forced Zip64 archive"
+  fi
+
+  echo "Testing 65535 entries..."
+  local many="${TEST_DIR}/many_entries"
+  mkdir -p "${many}"
+  (cd "${many}" && seq 1 65535 | xargs touch)
+  ${ARC_BIN} create -f "${TEST_DIR}/many.zip" "${many}" || error "Failed to create ZIP with 65536 entries"
+  [ "$(${ARC_BIN} list -f "${TEST_DIR}/many.zip" | wc -l)" -eq 65536 ] || error "ZIP with 65536 entries lost some"
+  if command -v unzip >/dev/null; then
+    unzip -tq "${TEST_DIR}/many.zip" >/dev/null || error "unzip can't read ZIP with 65536 entries"
+  fi
+  if ${ARC_BIN} create -zip64 forbid -f "${TEST_DIR}/many_forbidden.zip" "${many}" 2>"${TEST_DIR}/zip64_err.txt"; then
+    error "-zip64 forbid wrote a ZIP with 65536 entries"
+  fi
+  grep -q "needs Zip64" "${TEST_DIR}/zip64_err.txt" || error "-zip64 forbid didn't say Zip64 is needed"
+  [ ! -e "${TEST_DIR}/many_forbidden.zip" ] || error "-zip64 forbid left a broken ZIP behind"
+  rm -rf "${many}"
+
+  echo "Zip64 tests completed successfully"
+}
+
+# Test OCI image layers and the extraction of images, docker save archives
+# and OCI layouts
+test_oci() {
+  step "Testing OCI image layers"
+
+  local v1="${TEST_DIR}/rootfs_v1" v2="${TEST_DIR}/rootfs_v2"
+  mkdir -p "${v1}/etc" "${v1}/usr/bin" "${v1}/gone/sub"
+  echo "old" > "${v1}/etc/config"
+  echo "keep" > "${v1}/etc/keep"
+  echo "tool" > "${v1}/usr/bin/tool"
+  echo "removed" > "${v1}/gone/sub/file"
+  ln -s /usr/bin/tool "${v1}/tool"
+
+  echo "Testing deterministic layers..."
+  ${ARC_BIN} layer -o "${TEST_DIR}/layer1.tar.gz" "${v1}" > "${TEST_DIR}/layer1.txt" || error "Failed to create layer"
+  touch "${v1}/etc/keep"
+  ${ARC_BIN} layer -o "${TEST_DIR}/layer1_again.tar.gz" "${v1}" > /dev/null || error "Failed to create layer again"
+  cmp -s "${TEST_DIR}/layer1.tar.gz" "${TEST_DIR}/layer1_again.tar.gz" || error "Layers of the same tree differ"
+  grep -q "digest: sha256:$(sha256sum "${TEST_DIR}/layer1.tar.gz" | cut -d' ' -f1)" "${TEST_DIR}/layer1.txt" || error "Layer digest is not the SHA-256 of the layer"
+  grep -q "diff_id: sha256:$(gzip -dc "${TEST_DIR}/layer1.tar.gz" | sha256sum | cut -d' ' -f1)" "${TEST_DIR}/layer1.txt" || error "Layer diff ID is not the SHA-256 of its tar"
+  tar tzvf "${TEST_DIR}/layer1.tar.gz" | grep -q " 0/0 .* etc/config$" || error "Layer entries aren't owned by root"
+
+  echo "Testing whiteouts against a base..."
+  cp -a "${v1}" "${v2}"
+  rm -rf "${v2}/gone"
+  echo "new" > "${v2}/etc/config"
+  ${ARC_BIN} layer -base "${v1}" -o "${TEST_DIR}/layer2.tar.gz" "${v2}" > /dev/null || error "Failed to create layer against a base"
+  tar tzf "${TEST_DIR}/layer2.tar.gz" > "${TEST_DIR}/layer2.txt"
+  grep -qx ".wh.gone" "${TEST_DIR}/layer2.txt" || error "Layer has no whiteout of the removed directory"
+  grep -q "gone/" "${TEST_DIR}/layer2.txt" && error "Layer has whiteouts below the removed directory"
+  grep -qx "etc/config" "${TEST_DIR}/layer2.txt" || error "Layer lacks the changed file"
+  grep -qx "etc/keep" "${TEST_DIR}/layer2.txt" && error "Layer has the unchanged file"
+  ${ARC_BIN} layer -base "${TEST_DIR}/layer1.tar.gz" -o "${TEST_DIR}/layer2_archive.tar.gz" "${v2}" > /dev/null || error "Failed to create layer against a base layer"
+  cmp -s "${TEST_DIR}/layer2.tar.gz" "${TEST_DIR}/layer2_archive.tar.gz" || error "Layers against a directory and its layer differ"
+
+  echo "Testing docker save archives..."
+  local save="${TEST_DIR}/docker_save"
+  mkdir -p "${save}/layer1" "${save}/layer2"
+  cp "${TEST_DIR}/layer1.tar.gz" "${save}/layer1/layer.tar"
+  cp "${TEST_DIR}/layer2.tar.gz" "${save}/layer2/layer.tar"
+  echo '[{"Config":"config.json","RepoTags":["test:1"],"Layers":["layer1/layer.tar","layer2/layer.tar"]}]' > "${save}/manifest.json"
+  tar cf "${TEST_DIR}/image.tar" -C "${save}" .
+  ${ARC_BIN} image -o "${EXTRACT_DIR}/image" "${TEST_DIR}/image.tar" || error "Failed to extract docker save archive"
+  [ "$(cat "${EXTRACT_DIR}/image/etc/config")" = "new" ] || error "Upper layer didn't replace the file"
+  [ -f "${EXTRACT_DIR}/image/etc/keep" ] || error "File of the lower layer is missing"
+  [ ! -e "${EXTRACT_DIR}/image/gone" ] || error "Whiteout didn't remove the directory"
+  [ "$(readlink "${EXTRACT_DIR}/image/tool")" = "usr/bin/tool" ] || error "Absolute symlink wasn't made relative to the image root"
+
+  echo "Testing OCI layouts..."
+  local layout="${TEST_DIR}/oci_layout" blobs="${TEST_DIR}/oci_layout/blobs/sha256"
+  mkdir -p "${blobs}"
+  local digest1 digest2 config manifest
+  digest1=$(sha256sum "${TEST_DIR}/layer1.tar.gz" | cut -d' ' -f1)
+  digest2=$(sha256sum "${TEST_DIR}/layer2.tar.gz" | cut -d' ' -f1)
+  cp "${TEST_DIR}/layer1.tar.gz" "${blobs}/${digest1}"
+  cp "${TEST_DIR}/layer2.tar.gz" "${blobs}/${digest2}"
+  echo '{}' > "${TEST_DIR}/oci_config.json"
+  config=$(sha256sum "${TEST_DIR}/oci_config.json" | cut -d' ' -f1)
+  cp "${TEST_DIR}/oci_config.json" "${blobs}/${config}"
+  printf '{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:%s","size":3},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:%s"},{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:%s"}]}' "${config}" "${digest1}" "${digest2}" > "${TEST_DIR}/oci_manifest.json"
+  manifest=$(sha256sum "${TEST_DIR}/oci_manifest.json" | cut -d' ' -f1)
+  cp "${TEST_DIR}/oci_manifest.json" "${blobs}/${manifest}"
+  printf '{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s"}]}' "${manifest}" > "${layout}/index.json"
+  ${ARC_BIN} image -o "${EXTRACT_DIR}/oci_image" "${layout}" || error "Failed to extract OCI layout"
+  diff -r "${EXTRACT_DIR}/image" "${EXTRACT_DIR}/oci_image" > /dev/null || error "OCI layout and docker save extraction differ"
+  cp "${TEST_DIR}/layer1.tar.gz" "${blobs}/${digest2}"
+  if ${ARC_BIN} image -o "${EXTRACT_DIR}/oci_corrupt" "${layout}" 2>"${TEST_DIR}/oci_err.txt"; then
+    error "Extracted an OCI layout with a corrupt layer"
+  fi
+  grep -q "digest mismatch" "${TEST_DIR}/oci_err.txt" || error "Corrupt layer wasn't reported as a digest mismatch"
+
+  if command -v python3 >/dev/null 2>&1; then
+    echo "Testing whiteouts through symlinks..."
+    local escape="${TEST_DIR}/oci_escape"
+    mkdir -p "${escape}/save/layer1" "${escape}/save/layer2" "${escape}/save/layer3"
+    echo "victim" > "${escape}/victim"
+    python3 - "${escape}/save" <<'EOF'
+import io, sys, tarfile
+
+def layer(path, entries):
+    with tarfile.open(path, "w") as tar:
+        for name, kind, target in entries:
+            info = tarfile.TarInfo(name)
+            info.type, info.linkname = kind, target
+            tar.addfile(info, io.BytesIO(b""))
+
+layer(sys.argv[1] + "/layer1/layer.tar", [("keep", tarfile.REGTYPE, ""), ("a", tarfile.DIRTYPE, ""), ("a/b", tarfile.SYMTYPE, "..")])
+layer(sys.argv[1] + "/layer2/layer.tar", [("a/b/c", tarfile.SYMTYPE, ".."), ("a/b/c/.wh.victim", tarfile.REGTYPE, "")])
+layer(sys.argv[1] + "/layer3/layer.tar", [("a/b/.wh.keep", tarfile.REGTYPE, ""), ("a/b/.wh..wh..opq", tarfile.REGTYPE, "")])
+EOF
+    echo '[{"Config":"config.json","Layers":["layer1/layer.tar","layer2/layer.tar"]}]' > "${escape}/save/manifest.json"
+    tar cf "${escape}/chain.tar" -C "${escape}/save" .
+    ${ARC_BIN} image -o "${escape}/out" "${escape}/chain.tar" 2>/dev/null && error "Image with a whiteout through a chain of symlinks was accepted"
+    [ -f "${escape}/victim" ] || error "Whiteout through a chain of symlinks removed a file out of the image"
+    echo '[{"Config":"config.json","Layers":["layer1/layer.tar","layer3/layer.tar"]}]' > "${escape}/save/manifest.json"
+    tar cf "${escape}/whiteout.tar" -C "${escape}/save" .
+    ${ARC_BIN} image -o "${escape}/whiteout" "${escape}/whiteout.tar" 2>/dev/null && error "Image with a whiteout through a symlink was accepted"
+    [ -f "${escape}/whiteout/keep" ] || error "Whiteout through a symlink removed a file"
+  fi
+
+  echo "OCI tests completed successfully"
+}
+
+# Test the USTAR, PAX and GNU tar header formats, and extended attributes
+test_tar_format() {
+  step "Testing tar header formats"
+
+  local src="${TEST_DIR}/tar_format"
+  local long
+  long=$(printf 'd%.0s' $(seq 1 120))
+  mkdir -p "${src}"
+  echo "short" > "${src}/short.txt"
+
+  echo "Testing USTAR..."
+  ${ARC_BIN} create -tar-format ustar -f "${TEST_DIR}/ustar.tar" -C "${TEST_DIR}" tar_format || error "Failed to create USTAR archive"
+  [ "$(dd if="${TEST_DIR}/ustar.tar" bs=1 skip=257 count=5 2>/dev/null)" = "ustar" ] || error "-tar-format ustar didn't write USTAR headers"
+  mkdir -p "${src}/${long}"
+  echo "long" > "${src}/${long}/$(printf 'f%.0s' $(seq 1 150))"
+  if ${ARC_BIN} create -tar-format ustar -f "${TEST_DIR}/ustar_long.tar" -C "${TEST_DIR}" tar_format 2>"${TEST_DIR}/ustar_err.txt"; then
+    error "-tar-format ustar wrote a name USTAR can't hold"
+  fi
+  grep -q "can't be encoded in the tar format USTAR" "${TEST_DIR}/ustar_err.txt" || error "-tar-format ustar didn't name the format it can't use"
+  [ ! -e "${TEST_DIR}/ustar_long.tar" ] || error "-tar-format ustar left a broken archive behind"
+
+  echo "Testing GNU and PAX..."
+  ${ARC_BIN} create -tar-format gnu -f "${TEST_DIR}/gnu.tar" -C "${TEST_DIR}" tar_format || error "Failed to create GNU tar archive"
+  grep -q "././@LongLink" "${TEST_DIR}/gnu.tar" || error "-tar-format gnu didn't write a long name record"
+  ${ARC_BIN} create -tar-format pax -f "${TEST_DIR}/pax.tar.gz" -C "${TEST_DIR}" tar_format || error "Failed to create PAX archive"
+  for archive in gnu.tar pax.tar.gz; do
+    rm -rf "${EXTRACT_DIR}/tar_format"
+    tar xf "${TEST_DIR}/${archive}" -C "${EXTRACT_DIR}" || error "tar can't read ${archive}"
+    diff -r "${src}" "${EXTRACT_DIR}/tar_format" || error "${archive} differs from its source"
+  done
+  if ${ARC_BIN} convert -tar-format ustar "${TEST_DIR}/gnu.tar" "${TEST_DIR}/converted_ustar.tar" 2>/dev/null; then
+    error "convert -tar-format ustar wrote a name USTAR can't hold"
+  fi
+
+  echo "Testing extended attributes..."
+  if python3 -c "import os, sys; os.setxattr(sys.argv[1], 'user.arc_test', b'value')" "${src}/short.txt" 2>/dev/null; then
+    ${ARC_BIN} create -xattrs -f "${TEST_DIR}/xattrs.tar" -C "${TEST_DIR}" tar_format || error "Failed to create archive with extended attributes"
+    grep -q "SCHILY.xattr.user.arc_test=value" "${TEST_DIR}/xattrs.tar" || error "-xattrs didn't record the extended attribute"
+    ${ARC_BIN} extract -preserve -f "${TEST_DIR}/xattrs.tar" "${EXTRACT_DIR}/xattrs" || error "Failed to extract archive with extended attributes"
+    [ "$(python3 -c "import os, sys; print(os.getxattr(sys.argv[1], 'user.arc_test').decode())" "${EXTRACT_DIR}/xattrs/tar_format/short.txt")" = "value" ] || error "Extended attribute wasn't restored"
+    if ${ARC_BIN} create -xattrs -tar-format gnu -f "${TEST_DIR}/xattrs_gnu.tar" -C "${TEST_DIR}" tar_format 2>/dev/null; then
+      error "-tar-format gnu wrote extended attributes"
+    fi
+  else
+    echo "Extended attributes not supported here, skipping"
+  fi
+
+  echo "Tar header format tests completed successfully"
+}
+
+# Test a build with the arc_minimal tag, which keeps only tar, gzip and zip
+test_minimal_build() {
+  step "Testing the arc_minimal build"
+
+  if ! command -v go >/dev/null; then
+    echo "go not found, skipping the minimal build tests"
+    return
+  fi
+  local minimal="${TEST_DIR}/arc-minimal"
+  (cd "$(dirname "${BASH_SOURCE[0]}")/.." && go build -tags arc_minimal -o "${minimal}" ./cmd/arc) || error "Failed to build with arc_minimal"
+
+  "${minimal}" -version > "${TEST_DIR}/minimal_version.txt" || error "Minimal build failed to print its version"
+  grep -qx "tags: *arc_minimal" "${TEST_DIR}/minimal_version.txt" || error "-version doesn't print the build tags"
+  grep -qx "compressions: gz" "${TEST_DIR}/minimal_version.txt" || error "Minimal build has other compressions than gzip"
+  grep -qx "archivals: *tar zip" "${TEST_DIR}/minimal_version.txt" || error "Minimal build has other archivals than tar and zip"
+
+  "${minimal}" create -c zst,gz -f "${TEST_DIR}/minimal.tar.gz" -C "${TEST_DIR}" to_archive 2>/dev/null || error "Minimal build didn't fall back to gzip"
+  ${ARC_BIN} extract -f "${TEST_DIR}/minimal.tar.gz" "${TEST_DIR}/minimal_extract" || error "Failed to extract the archive of the minimal build"
+  verify_extraction "${TEST_DIR}/minimal_extract" || error "Minimal build archive verification failed"
+  ${ARC_BIN} create -f "${TEST_DIR}/minimal.tar.xz" -C "${TEST_DIR}" to_archive || error "Failed to create .tar.xz"
+  if "${minimal}" extract -f "${TEST_DIR}/minimal.tar.xz" "${TEST_DIR}/minimal_xz" 2>"${TEST_DIR}/minimal_err.txt"; then
+    error "Minimal build extracted a .tar.xz"
+  fi
+  grep -q "arc_minimal" "${TEST_DIR}/minimal_err.txt" || error "Minimal build doesn't name the tag leaving xz out"
+
+  echo "Testing that the minimal build leaves the codecs out..."
+  go version -m "${minimal}" > "${TEST_DIR}/minimal_deps.txt" || error "Failed to read the modules of the minimal build"
+  for module in andybalholm/brotli dsnet/compress ulikunitz/xz mikelolasagasti/xz pierrec/lz4 sorairolake/lzip-go minio/minlz bodgit/sevenzip nwaples/rardecode; do
+    grep -q "github.com/${module}" "${TEST_DIR}/minimal_deps.txt" && error "Minimal build links ${module}"
+  done
+
+  echo "Minimal build tests completed successfully"
+}
+
 # Check that all files are present for a specified extract directory
 verify_extraction() {
   local extract_dir=$1
@@ -245,8 +1960,55 @@ run_tests() {
   setup
   test_compress
   test_decompress
+  test_legacy_compress
   test_archive
   test_extract
+  test_sevenzip
+  test_cpio_ar
+  test_package
+  test_disk_image
+  test_squashfs
+  test_verify
+  test_manifest
+  test_signature
+  test_password
+  test_encryption
+  test_split
+  test_deterministic
+  test_overwrite
+  test_rsyncable
+  test_seekable
+  test_strip_components
+  test_recursive
+  test_sync
+  test_allowed_roots
+  test_sfx
+  test_checksum_trailer
+  test_to_command
+  test_list_convert
+  test_diff
+  test_fsck
+  test_rotate
+  test_profile
+  test_entry_cache
+  test_watch
+  test_bench
+  test_auto_compression
+  test_update
+  test_checksum_file
+  test_routes
+  test_touch
+  test_restore
+  test_url
+  test_serve
+  test_preserve
+  test_stdio
+  test_locked
+  test_cancel
+  test_zip64
+  test_oci
+  test_tar_format
+  test_minimal_build
   
   # Comment out cleanup during development if you want to inspect the files
   cleanup
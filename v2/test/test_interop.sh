@@ -0,0 +1,329 @@
+#!/bin/bash
+# Interoperability tests of arc: extracts the golden archives of
+# fixtures/golden, and round-trips archives between arc and the external
+# tools installed (GNU tar, bsdtar, Info-ZIP, 7-Zip), in both directions.
+# Tools and codecs that aren't installed are skipped and listed at the end.
+#
+# Usage: run from the directory of the arc binary, like test_arc.sh
+
+set -e  # Exit on error
+
+# Colors for better output
+GREEN='\033[0;32m'
+RED='\033[0;31m'
+YELLOW='\033[0;33m'
+NC='\033[0m' # No Color
+
+ARC_BIN="$(pwd)/arc"
+FIXTURES="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)/fixtures"
+TREE="${FIXTURES}/tree"
+TEST_DIR="/tmp/arc_interop"
+
+# Archive formats arc writes, and tar compressions external tools know
+ARC_FORMATS=("tar" "tar.gz" "tar.bz2" "tar.xz" "tar.zst" "tar.lz4" "tar.lz" "tar.lzma" "zip" "7z" "cpio")
+
+PASSED=0
+SKIPPED=()
+
+# Print a formatted test step
+step() {
+  echo -e "${GREEN}==> $1${NC}"
+}
+
+# Print a warning message
+warn() {
+  echo -e "${YELLOW}WARNING: $1${NC}"
+}
+
+# Print a formatted error message
+error() {
+  echo -e "${RED}ERROR: $1${NC}"
+  exit 1
+}
+
+have() {
+  command -v "$1" >/dev/null
+}
+
+# Record a combination that can't run here
+skip() {
+  SKIPPED+=("$1")
+}
+
+# gnu_tar prints the GNU tar command, gtar on BSDs and macOS
+gnu_tar() {
+  for t in gtar tar; do
+    if have "$t" && "$t" --version 2>/dev/null | grep -q "GNU tar"; then
+      echo "$t"
+      return
+    fi
+  done
+}
+
+# sevenzip prints the 7-Zip command, whichever of its names is installed
+sevenzip() {
+  for t in 7zz 7z 7za; do
+    if have "$t"; then
+      echo "$t"
+      return
+    fi
+  done
+}
+
+# The command compressing the tar streams of ext, if installed
+codec_tool() {
+  case "$1" in
+    tar.gz) echo gzip ;;
+    tar.bz2) echo bzip2 ;;
+    tar.xz) echo xz ;;
+    tar.zst) echo zstd ;;
+    tar.lz4) echo lz4 ;;
+    tar.lz) echo lzip ;;
+    tar.lzma) echo lzma ;;
+  esac
+}
+
+# Check that dir holds the files of the fixture tree, with their modes
+check_tree() {
+  local dir=$1 what=$2
+  diff -r "${TREE}" "${dir}" >/dev/null || error "${what}: extracted files differ from the fixture tree"
+  [ -x "${dir}/bin/tool.sh" ] || error "${what}: executable bit lost"
+  PASSED=$((PASSED + 1))
+}
+
+# A fresh directory for one combination
+workdir() {
+  local dir="${TEST_DIR}/$1"
+  rm -rf "${dir}"
+  mkdir -p "${dir}"
+  echo "${dir}"
+}
+
+setup() {
+  step "Setting up test environment"
+  rm -rf "${TEST_DIR}"
+  mkdir -p "${TEST_DIR}"
+  # git doesn't keep the modes of every checkout, the tree's are the reference
+  chmod 755 "${TREE}/bin/tool.sh"
+}
+
+# Extract every golden archive, they were written by the reference tools
+test_golden() {
+  step "Testing golden fixtures"
+
+  local archive name out
+  for archive in "${FIXTURES}"/golden/*; do
+    name=$(basename "${archive}")
+    out=$(workdir "golden/${name}")
+    case "${name}" in
+      hello.txt.*)
+        ${ARC_BIN} decompress -i "${archive}" -o "${out}/hello.txt" || error "Failed to decompress ${name}"
+        cmp -s "${out}/hello.txt" "${TREE}/hello.txt" || error "${name}: decompressed file differs"
+        PASSED=$((PASSED + 1))
+        ;;
+      *)
+        ${ARC_BIN} -q test -f "${archive}" || error "Failed to verify ${name}"
+        ${ARC_BIN} -q extract -f "${archive}" "${out}" || error "Failed to extract ${name}"
+        check_tree "${out}" "${name}"
+        ;;
+    esac
+    echo "${name}: OK"
+  done
+}
+
+# Archives created by external tools, extracted by arc
+test_from_tools() {
+  step "Testing archives of external tools extracted by arc"
+
+  local tar out ext tool
+  tar=$(gnu_tar)
+  if [ -n "${tar}" ]; then
+    for ext in "${ARC_FORMATS[@]}"; do
+      case "${ext}" in zip | 7z | cpio) continue ;; esac
+      tool=$(codec_tool "${ext}")
+      if [ -n "${tool}" ] && ! have "${tool}"; then
+        skip "GNU tar -> arc: ${ext} (${tool} not installed)"
+        continue
+      fi
+      out=$(workdir "gnutar-${ext}")
+      # the compressors are piped, GNU tar doesn't know every suffix
+      if [ -n "${tool}" ]; then
+        "${tar}" -cf - -C "${TREE}" . | "${tool}" -q -c > "${out}/archive.${ext}" || error "GNU tar and ${tool} failed to create ${ext}"
+      else
+        "${tar}" -cf "${out}/archive.${ext}" -C "${TREE}" . || error "GNU tar failed to create ${ext}"
+      fi
+      ${ARC_BIN} -q extract -f "${out}/archive.${ext}" "${out}/x" || error "Failed to extract ${ext} of GNU tar"
+      check_tree "${out}/x" "GNU tar -> arc: ${ext}"
+    done
+    for format in gnu oldgnu ustar posix v7; do
+      out=$(workdir "gnutar-${format}")
+      "${tar}" --format="${format}" -cf "${out}/archive.tar" -C "${TREE}" . 2>/dev/null || error "GNU tar failed to create the ${format} format"
+      ${ARC_BIN} -q extract -f "${out}/archive.tar" "${out}/x" || error "Failed to extract the ${format} tar of GNU tar"
+      check_tree "${out}/x" "GNU tar -> arc: ${format} format"
+    done
+  else
+    skip "GNU tar -> arc (GNU tar not installed)"
+  fi
+
+  if have bsdtar; then
+    for format in pax ustar gnutar zip 7zip newc odc iso9660; do
+      out=$(workdir "bsdtar-${format}")
+      (cd "${TREE}" && bsdtar --format "${format}" -cf "${out}/archive" .) || error "bsdtar failed to create the ${format} format"
+      # the extension tells arc the format, the content has to match
+      case "${format}" in
+        zip) mv "${out}/archive" "${out}/archive.zip" ;;
+        7zip) mv "${out}/archive" "${out}/archive.7z" ;;
+        newc | odc) mv "${out}/archive" "${out}/archive.cpio" ;;
+        iso9660) mv "${out}/archive" "${out}/archive.iso" ;;
+        *) mv "${out}/archive" "${out}/archive.tar" ;;
+      esac
+      ${ARC_BIN} -q extract -f "${out}"/archive.* "${out}/x" || error "Failed to extract the ${format} archive of bsdtar"
+      check_tree "${out}/x" "bsdtar -> arc: ${format}"
+    done
+  else
+    skip "bsdtar -> arc (bsdtar not installed)"
+  fi
+
+  if have mksquashfs; then
+    for comp in gzip xz lz4 zstd; do
+      out=$(workdir "mksquashfs-${comp}")
+      mksquashfs "${TREE}" "${out}/image.squashfs" -comp "${comp}" -no-progress >/dev/null || error "mksquashfs failed to create a ${comp} image"
+      ${ARC_BIN} -q extract -f "${out}/image.squashfs" "${out}/x" || error "Failed to extract the ${comp} image of mksquashfs"
+      check_tree "${out}/x" "mksquashfs -> arc: ${comp}"
+    done
+  else
+    skip "mksquashfs -> arc (mksquashfs not installed)"
+  fi
+
+  if have zip; then
+    for level in 0 6 9; do
+      out=$(workdir "infozip-${level}")
+      (cd "${TREE}" && zip -q -r "-${level}" "${out}/archive.zip" .) || error "zip failed to create an archive"
+      ${ARC_BIN} -q extract -f "${out}/archive.zip" "${out}/x" || error "Failed to extract the zip -${level} archive"
+      check_tree "${out}/x" "Info-ZIP -> arc: -${level}"
+    done
+    out=$(workdir "infozip-password")
+    (cd "${TREE}" && zip -q -r -P secret "${out}/archive.zip" .) || error "zip failed to create an encrypted archive"
+    ${ARC_BIN} -q extract -p secret -f "${out}/archive.zip" "${out}/x" || error "Failed to extract the ZipCrypto archive of zip"
+    check_tree "${out}/x" "Info-ZIP -> arc: ZipCrypto"
+  else
+    skip "Info-ZIP -> arc (zip not installed)"
+  fi
+
+  local seven
+  seven=$(sevenzip)
+  if [ -n "${seven}" ]; then
+    for type in 7z zip tar; do
+      out=$(workdir "7zip-${type}")
+      (cd "${TREE}" && "${seven}" a -bd -y "-t${type}" "${out}/archive.${type}" . >/dev/null) || error "7-Zip failed to create a ${type} archive"
+      ${ARC_BIN} -q extract -f "${out}/archive.${type}" "${out}/x" || error "Failed to extract the ${type} archive of 7-Zip"
+      check_tree "${out}/x" "7-Zip -> arc: ${type}"
+    done
+  else
+    skip "7-Zip -> arc (7z not installed)"
+  fi
+}
+
+# Archives created by arc, extracted by external tools
+test_to_tools() {
+  step "Testing archives of arc extracted by external tools"
+
+  local tar seven out ext tool
+  tar=$(gnu_tar)
+  seven=$(sevenzip)
+  for ext in "${ARC_FORMATS[@]}"; do
+    out=$(workdir "arc-${ext}")
+    ${ARC_BIN} -q create -f "${out}/archive.${ext}" -C "${TREE}" . || error "Failed to create ${ext}"
+    tool=$(codec_tool "${ext}")
+
+    if [ "${ext}" = "zip" ]; then
+      if have unzip; then
+        (cd "${out}" && unzip -q archive.zip -d unzip) || error "unzip failed to extract the zip of arc"
+        check_tree "${out}/unzip" "arc -> Info-ZIP: zip"
+      else
+        skip "arc -> Info-ZIP (unzip not installed)"
+      fi
+    elif [ "${ext}" = "7z" ] || [ "${ext}" = "cpio" ]; then
+      : # GNU tar reads neither 7z nor cpio
+    elif [ -n "${tar}" ]; then
+      if [ -n "${tool}" ] && ! have "${tool}"; then
+        skip "arc -> GNU tar: ${ext} (${tool} not installed)"
+      else
+        mkdir -p "${out}/gnutar"
+        if [ -n "${tool}" ]; then
+          "${tool}" -q -d -c < "${out}/archive.${ext}" | "${tar}" -xf - -C "${out}/gnutar" || error "GNU tar and ${tool} failed to extract the ${ext} of arc"
+        else
+          "${tar}" -xf "${out}/archive.${ext}" -C "${out}/gnutar" || error "GNU tar failed to extract the ${ext} of arc"
+        fi
+        check_tree "${out}/gnutar" "arc -> GNU tar: ${ext}"
+      fi
+    else
+      skip "arc -> GNU tar: ${ext} (GNU tar not installed)"
+    fi
+
+    if have bsdtar; then
+      mkdir -p "${out}/bsdtar"
+      if bsdtar -xf "${out}/archive.${ext}" -C "${out}/bsdtar" 2>"${out}/bsdtar.log"; then
+        check_tree "${out}/bsdtar" "arc -> bsdtar: ${ext}"
+      elif grep -qi "not supported\|unsupported\|can't\|not compiled" "${out}/bsdtar.log"; then
+        skip "arc -> bsdtar: ${ext} ($(head -1 "${out}/bsdtar.log"))"
+      else
+        cat "${out}/bsdtar.log"
+        error "bsdtar failed to extract the ${ext} of arc"
+      fi
+    else
+      skip "arc -> bsdtar: ${ext} (bsdtar not installed)"
+    fi
+
+    # 7-Zip reads tar only uncompressed or with one of its own codecs
+    if [ -n "${seven}" ]; then
+      case "${ext}" in
+        tar | zip | 7z | cpio)
+          "${seven}" x -bd -y "-o${out}/7zip" "${out}/archive.${ext}" >/dev/null || error "7-Zip failed to extract the ${ext} of arc"
+          check_tree "${out}/7zip" "arc -> 7-Zip: ${ext}"
+          ;;
+        *) skip "arc -> 7-Zip: ${ext} (compressed tar)" ;;
+      esac
+    else
+      skip "arc -> 7-Zip: ${ext} (7z not installed)"
+    fi
+  done
+
+  echo "Testing encrypted zip archives..."
+  out=$(workdir "arc-zip-password")
+  ${ARC_BIN} -q create -p secret -f "${out}/archive.zip" -C "${TREE}" . || error "Failed to create an encrypted zip"
+  if [ -n "${seven}" ]; then
+    "${seven}" x -bd -y -psecret "-o${out}/7zip" "${out}/archive.zip" >/dev/null || error "7-Zip failed to extract the AES zip of arc"
+    check_tree "${out}/7zip" "arc -> 7-Zip: AES zip"
+  else
+    skip "arc -> 7-Zip: AES zip (7z not installed, unzip can't read AES)"
+  fi
+}
+
+cleanup() {
+  step "Cleaning up test environment"
+  rm -rf "${TEST_DIR}"
+  echo "Cleanup completed"
+}
+
+run_tests() {
+  step "Starting arc interoperability tests"
+
+  # Ensure arc binary exists
+  [ -x "${ARC_BIN}" ] || error "arc binary not found or not executable at ${ARC_BIN}"
+
+  setup
+  test_golden
+  test_from_tools
+  test_to_tools
+  cleanup
+
+  if [ ${#SKIPPED[@]} -gt 0 ]; then
+    warn "${#SKIPPED[@]} combinations skipped:"
+    printf '  %s\n' "${SKIPPED[@]}"
+  fi
+  echo -e "${GREEN}All ${PASSED} interoperability checks passed!${NC}"
+}
+
+# Run all tests
+run_tests
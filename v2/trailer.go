@@ -0,0 +1,229 @@
+package arc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// trailerMagic ends a checksum trailer, its last byte is the version.
+const trailerMagic = "ARCSUM\x00\x01"
+
+// trailerSize is the length of a checksum trailer: the SHA-256 of everything
+// before it, followed by the 8 bytes of trailerMagic.
+const trailerSize = sha256.Size + 8
+
+// ErrTrailerMismatch is returned when an archive doesn't match the SHA-256
+// recorded in its checksum trailer.
+var ErrTrailerMismatch = errors.New("checksum trailer mismatch")
+
+// WithChecksumTrailer appends a trailer with the SHA-256 of the archive to
+// the archive itself, for pipelines that can't keep sidecar files around.
+// Unarchive, UnarchiveURL, Verify and VerifyManifest check and skip the
+// trailer transparently; other tools see trailing garbage, which
+// StripTrailer removes.
+func WithChecksumTrailer() Option {
+	return func(o *options) {
+		o.trailer = true
+	}
+}
+
+// StripTrailer checks the checksum trailer of archive and removes it, so the
+// archive can be read by other tools. It reports whether there was one.
+func StripTrailer(archive string) (bool, error) {
+	logging("Stripping checksum trailer of %s", archive)
+	archiveFile, _, err := openArchive(archive)
+	if err != nil {
+		return false, err
+	}
+	trailed, ok := archiveFile.(*trailedFile)
+	archiveFile.Close()
+	if !ok {
+		return false, nil
+	}
+
+	// the trailer is at the end of the last volume of split archives
+	last := archive
+	if volumes := SplitVolumes(archive); volumes != nil {
+		last = volumes[len(volumes)-1]
+	}
+	info, err := os.Stat(last)
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", last, err)
+	}
+	if info.Size() < trailerSize {
+		return false, fmt.Errorf("checksum trailer of %s spans several volumes", archive)
+	}
+	if err := os.Truncate(last, info.Size()-trailerSize); err != nil {
+		return false, fmt.Errorf("remove checksum trailer: %w", err)
+	}
+	logging("Removed checksum trailer of %s, %d bytes remain", archive, trailed.Size())
+	return true, nil
+}
+
+// appendTrailer appends a checksum trailer of its content to f.
+func appendTrailer(f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("hash archive: %w", err)
+	}
+	_, err = f.WriteAt(append(hash.Sum(nil), trailerMagic...), size)
+	return err
+}
+
+// trailerWriter hashes what is written through it, and appends the trailer
+// when finished.
+type trailerWriter struct {
+	io.WriteCloser
+	hash     hash.Hash
+	finished bool
+}
+
+func newTrailerWriter(w io.WriteCloser) *trailerWriter {
+	return &trailerWriter{WriteCloser: w, hash: sha256.New()}
+}
+
+func (t *trailerWriter) Write(p []byte) (int, error) {
+	n, err := t.WriteCloser.Write(p)
+	t.hash.Write(p[:n])
+	return n, err
+}
+
+// finish writes the trailer, later writes would invalidate it.
+func (t *trailerWriter) finish() error {
+	if t.finished {
+		return nil
+	}
+	t.finished = true
+	trailer := append(t.hash.Sum(nil), trailerMagic...)
+	_, err := t.WriteCloser.Write(trailer)
+	return err
+}
+
+func (t *trailerWriter) Close() error {
+	return errors.Join(t.finish(), t.WriteCloser.Close())
+}
+
+// finishTrailer writes the checksum trailer if output was created with
+// WithChecksumTrailer, so that errors are reported before it is closed.
+func finishTrailer(output io.Writer) error {
+	if t, ok := output.(*trailerWriter); ok {
+		return t.finish()
+	}
+	return nil
+}
+
+// trailedFile is an archive without its checksum trailer.
+type trailedFile struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// stripTrailerOf checks whether f ends with a checksum trailer, and if so
+// verifies it and returns f without the trailer.
+func stripTrailerOf(f archiveFile) (archiveFile, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seek archive: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek archive: %w", err)
+	}
+	if size < trailerSize {
+		return f, nil
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := f.ReadAt(trailer, size-trailerSize); err != nil {
+		return nil, fmt.Errorf("read trailer: %w", err)
+	}
+	if !bytes.HasSuffix(trailer, []byte(trailerMagic)) {
+		return f, nil
+	}
+
+	logging("Verifying checksum trailer")
+	content := io.NewSectionReader(f, 0, size-trailerSize)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return nil, fmt.Errorf("hash archive: %w", err)
+	}
+	if sum := hash.Sum(nil); !bytes.Equal(sum, trailer[:sha256.Size]) {
+		return nil, fmt.Errorf("%w: got sha256:%x, want sha256:%x", ErrTrailerMismatch, sum, trailer[:sha256.Size])
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek archive: %w", err)
+	}
+	return &trailedFile{SectionReader: content, Closer: f}, nil
+}
+
+// trailerReader passes a stream through, withholding the last trailerSize
+// bytes until the end, where a checksum trailer is verified and dropped.
+type trailerReader struct {
+	r    io.Reader
+	hash hash.Hash
+	buf  []byte // read but not returned yet
+	eof  bool   // r is exhausted and buf holds the rest of the content
+	err  error
+}
+
+func newTrailerReader(r io.Reader) *trailerReader {
+	return &trailerReader{r: r, hash: sha256.New()}
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	for !t.eof && len(t.buf) <= trailerSize {
+		if t.err != nil {
+			return 0, t.err
+		}
+		chunk := make([]byte, 32<<10)
+		n, err := t.r.Read(chunk)
+		t.buf = append(t.buf, chunk[:n]...)
+		if errors.Is(err, io.EOF) {
+			t.err = t.checkTrailer()
+			t.eof = true
+		} else if err != nil {
+			t.err = err
+		}
+	}
+	if t.eof && t.err != nil {
+		return 0, t.err
+	}
+	if t.eof && len(t.buf) == 0 {
+		return 0, io.EOF
+	}
+
+	// the last trailerSize bytes may be the trailer until the end is seen
+	available := t.buf
+	if !t.eof {
+		available = t.buf[:len(t.buf)-trailerSize]
+	}
+	n := copy(p, available)
+	if !t.eof {
+		t.hash.Write(p[:n])
+	}
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+// checkTrailer is called once the whole stream is in buf or hashed, and
+// verifies and removes a trailing checksum trailer.
+func (t *trailerReader) checkTrailer() error {
+	if len(t.buf) < trailerSize || !bytes.HasSuffix(t.buf, []byte(trailerMagic)) {
+		return nil
+	}
+	trailer := t.buf[len(t.buf)-trailerSize:]
+	t.buf = t.buf[:len(t.buf)-trailerSize]
+	logging("Verifying checksum trailer")
+	t.hash.Write(t.buf)
+	if sum := t.hash.Sum(nil); !bytes.Equal(sum, trailer[:sha256.Size]) {
+		return fmt.Errorf("%w: got sha256:%x, want sha256:%x", ErrTrailerMismatch, sum, trailer[:sha256.Size])
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package arc
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Transcode converts the archive input to another format, like a zip to a
+// tar.zst, without extracting it: each entry is read from input and written
+// to output right away, so it needs no scratch space. Names, modes, mtimes
+// and symlinks of the entries are kept.
+// input: the archive to convert, its format is identified like for Unarchive
+// output: the archive to create
+// compression: the compression of output, nil for none (e.g. zip)
+// archival: the archival of output, which has to support writing entries one
+// at a time like tar and zip do
+// opts: optional settings, like WithPassword and WithDecryption for an
+// encrypted input, WithZopfli, WithZipLevel, WithProgress and WithContext
+func Transcode(input, output string, compression archives.Compression, archival archives.Archival, opts ...Option) error {
+	o := newOptions(opts)
+	return transcode(input, output, compression, archival, o, func(handler archives.FileHandler) error {
+		return extractArchive(input, handler, o)
+	})
+}
+
+// TranscodeReader is Transcode for the archive read from r, like os.Stdin,
+// the counterpart of UnarchiveReader. Zip and 7z archives need random access
+// and are buffered in a temp file first. A checksum trailer is verified at
+// the end of the stream, output is removed if it doesn't match.
+// r: the archive stream
+// output: the archive to create
+// compression: the compression of output, nil for none (e.g. zip)
+// archival: the archival of output, see Transcode
+// opts: optional settings, see Transcode
+func TranscodeReader(r io.Reader, output string, compression archives.Compression, archival archives.Archival, opts ...Option) error {
+	o := newOptions(opts)
+	input := newTrailerReader(r)
+	return transcode("stream", output, compression, archival, o, func(handler archives.FileHandler) error {
+		if err := extractStream("", input, handler, o); err != nil {
+			return err
+		}
+		_, err := io.Copy(io.Discard, input)
+		return err
+	})
+}
+
+// transcode writes the entries extract passes to its handler to output,
+// input names the archive they are read from in messages.
+func transcode(input, output string, compression archives.Compression, archival archives.Archival, o *options, extract func(archives.FileHandler) error) error {
+	logging("Transcoding %s to %s", input, output)
+
+	format := archives.CompressedArchive{Compression: compression, Archival: archival}
+	if err := compiledIn(format); err != nil {
+		errMsg := fmt.Errorf("error creating archive '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if o.tarFormat != tar.FormatUnknown || o.xattrs {
+		withHeaders, err := tarHeaderFormat(archival, o.tarFormat, nil)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		archival = withHeaders.(archives.Archival)
+		format.Archival = archival
+	}
+	if o.zopfli {
+		zopfli, err := zopfliFormat(format)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating zopfli compressed archive '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		switch f := zopfli.(type) {
+		case archives.CompressedArchive:
+			compression = f.Compression
+		case metaZip:
+			archival = f
+		}
+	}
+	if o.zipLevelSet || len(o.zipStore) > 0 {
+		zipCompression, err := zipCompressionFormat(archival, o)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		archival = zipCompression.(archives.Archival)
+	}
+	if o.zip64 != Zip64Auto {
+		zip64, err := zip64Format(archival, o.zip64)
+		if err != nil {
+			errMsg := fmt.Errorf("error creating archive '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		archival = zip64.(archives.Archival)
+	}
+	async, ok := archival.(archives.ArchiverAsync)
+	if !ok {
+		errMsg := fmt.Errorf("error creating archive '%s': %T archives can't be written entry by entry", output, archival)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+
+	// refuse a destination outside of the allowed roots before removing it
+	if err := o.checkOutput(output); err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	logging("Removing any existing output file: %s", output)
+	if err := removeOutput(output, o); err != nil {
+		errMsg := fmt.Errorf("failed to remove existing output file '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	outf, err := createOutput(output, o)
+	if err != nil {
+		errMsg := fmt.Errorf("error creating output file '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	complete := false
+	defer func() {
+		outf.Close()
+		if !complete {
+			discardOutput(output, o)
+		}
+	}()
+
+	// the compressor is closed here rather than by CompressedArchive, so
+	// errors flushing it aren't lost
+	var w io.Writer = outf
+	var compressor io.WriteCloser
+	if compression != nil {
+		if compressor, err = compression.OpenWriter(outf); err != nil {
+			errMsg := fmt.Errorf("error opening compressor for '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+		w = compressor
+	}
+
+	jobs := make(chan archives.ArchiveAsyncJob)
+	archived := make(chan error, 1)
+	go func() {
+		archived <- async.ArchiveAsync(o.context(), w, jobs)
+	}()
+
+	// the content of an entry can only be read until its handler returns,
+	// so each one waits for the archiver to have written the entry
+	progress := newProgress(o)
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		result := make(chan error, 1)
+		jobs <- archives.ArchiveAsyncJob{File: utcFile(f), Result: result}
+		if err := <-result; err != nil {
+			return fmt.Errorf("writing %s: %w", f.NameInArchive, err)
+		}
+		return nil
+	}
+	extractErr := extract(progress.handler(handler))
+	close(jobs)
+	archiveErr := <-archived
+	if extractErr != nil {
+		errMsg := fmt.Errorf("error transcoding '%s': %w", input, extractErr)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if archiveErr != nil {
+		errMsg := fmt.Errorf("error during archive creation for output file '%s': %w", output, archiveErr)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			errMsg := fmt.Errorf("error finishing compression of '%s': %w", output, err)
+			logging("%s", errMsg.Error())
+			return errMsg
+		}
+	}
+	if err := finishPipeline(outf); err != nil {
+		errMsg := fmt.Errorf("error finishing the pipeline of '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	if err := finishTrailer(outf); err != nil {
+		errMsg := fmt.Errorf("error writing checksum trailer of '%s': %w", output, err)
+		logging("%s", errMsg.Error())
+		return errMsg
+	}
+	complete = true
+	progress.done()
+	logging("Archive transcoded successfully: %s", output)
+	return nil
+}
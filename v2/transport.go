@@ -0,0 +1,144 @@
+package arc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// WithProxy sends remote requests through the proxy at proxyURL, instead of
+// the one from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxy(proxyURL string) Option {
+	return func(o *options) {
+		o.proxy = proxyURL
+	}
+}
+
+// WithCACert trusts the certificates of the PEM bundle caFile in addition
+// to the system roots, for servers behind a private CA.
+func WithCACert(caFile string) Option {
+	return func(o *options) {
+		o.caFile = caFile
+	}
+}
+
+// WithClientCert authenticates to servers requiring mutual TLS with the PEM
+// encoded certificate and key.
+func WithClientCert(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithHeader adds a header to every remote request, it can be given several
+// times.
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Add(key, value)
+	}
+}
+
+// WithBearerToken authorizes remote requests with token.
+func WithBearerToken(token string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithTimeout limits the time to connect and to receive the response headers
+// of each remote request. Transfers themselves are not limited, so large
+// downloads aren't cut short.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// remoteClient performs the requests of remote operations.
+type remoteClient struct {
+	client *http.Client
+	header http.Header
+}
+
+// newRemoteClient builds a client from the network options of o.
+func newRemoteClient(o *options) (*remoteClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if o.caFile != "" || o.certFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if o.caFile != "" {
+			pem, err := os.ReadFile(o.caFile)
+			if err != nil {
+				return nil, fmt.Errorf("read CA bundle: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", o.caFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if o.certFile != "" {
+			cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if o.timeout > 0 {
+		dialer := &net.Dialer{Timeout: o.timeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = o.timeout
+		transport.ResponseHeaderTimeout = o.timeout
+	}
+
+	return &remoteClient{
+		client: &http.Client{Transport: transport},
+		header: o.headers,
+	}, nil
+}
+
+// get requests rawURL, limited to byteRange (e.g. "bytes=0-1023") if set,
+// and only while the file matches the ETag or date ifRange if set.
+func (c *remoteClient) get(ctx context.Context, rawURL, byteRange, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	return c.client.Do(req)
+}
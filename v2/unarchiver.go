@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/mholt/archives"
+	"github.com/jm33-m0/arc/v2/internal/archives"
 )
 
 const (
@@ -16,7 +17,9 @@ const (
 	filePermissions = 0o600 // Default file permissions
 )
 
-// securePath ensures the path is safely relative to the target directory.
+// securePath ensures the path is safely relative to the target directory,
+// and that none of its existing parents below it is a symlink, which could
+// lead a write out of it.
 func securePath(basePath, relativePath string) (string, error) {
 	relativePath = filepath.Clean("/" + relativePath)                         // Normalize path with a leading slash
 	relativePath = strings.TrimPrefix(relativePath, string(os.PathSeparator)) // Remove leading separator
@@ -26,9 +29,86 @@ func securePath(basePath, relativePath string) (string, error) {
 	if !strings.HasPrefix(filepath.Clean(dstPath)+string(os.PathSeparator), filepath.Clean(basePath)+string(os.PathSeparator)) {
 		return "", fmt.Errorf("illegal file path: %s", dstPath)
 	}
+	if relativePath == "" {
+		return dstPath, nil
+	}
+	dir := filepath.Clean(basePath)
+	parents := strings.Split(relativePath, string(os.PathSeparator))
+	for _, name := range parents[:len(parents)-1] {
+		dir = filepath.Join(dir, name)
+		info, statErr := os.Lstat(dir)
+		if statErr != nil {
+			// nothing below a missing parent exists either
+			break
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("illegal file path: %s: %s is a symlink", dstPath, dir)
+		}
+	}
 	return dstPath, nil
 }
 
+// resolveLink returns the path the symlink target leads to from the
+// directory dir, below basePath, following the symlinks already on disk, or
+// an error if it leads out of basePath.
+func resolveLink(basePath, dir, target string) (string, error) {
+	basePath = filepath.Clean(basePath)
+	rel, relErr := filepath.Rel(basePath, dir)
+	if relErr != nil {
+		return "", relErr
+	}
+	var parts []string
+	if rel != "." {
+		parts = strings.Split(rel, string(os.PathSeparator))
+	}
+	pending := strings.Split(filepath.ToSlash(target), "/")
+	for hops := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) == 0 {
+				return "", fmt.Errorf("%s is outside of %s", target, basePath)
+			}
+			parts = parts[:len(parts)-1]
+			continue
+		}
+		parts = append(parts, name)
+		linkPath := filepath.Join(append([]string{basePath}, parts...)...)
+		info, statErr := os.Lstat(linkPath)
+		if statErr != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if hops++; hops > 255 {
+			return "", fmt.Errorf("%s: too many levels of symlinks", target)
+		}
+		link, readErr := os.Readlink(linkPath)
+		if readErr != nil {
+			return "", readErr
+		}
+		if filepath.IsAbs(link) {
+			return "", fmt.Errorf("%s leads to %s, outside of %s", target, link, basePath)
+		}
+		parts = parts[:len(parts)-1]
+		pending = append(strings.Split(filepath.ToSlash(link), "/"), pending...)
+	}
+	return filepath.Join(append([]string{basePath}, parts...)...), nil
+}
+
+// removeSymlink removes the symlink at path, if there is one, so the entry
+// extracted there replaces it instead of being written where it points.
+func removeSymlink(path string) error {
+	if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&os.ModeSymlink != 0 {
+		logging("Replacing symlink: %s", path)
+		if removeErr := os.Remove(path); removeErr != nil {
+			return fmt.Errorf("replace symlink %s: %w", path, removeErr)
+		}
+	}
+	return nil
+}
+
 // createDirWithPermissions creates a directory with specified permissions.
 func createDirWithPermissions(path string, mode os.FileMode) error {
 	logging("Creating directory: %s", path)
@@ -46,109 +126,328 @@ func setPermissions(path string, mode os.FileMode) error {
 	return nil
 }
 
-// handleFile handles the extraction of a file from the archive.
-func handleFile(f archives.FileInfo, dst string) error {
-	logging("Handling file: %s", f.NameInArchive)
+// dirSink extracts entries into the directory dst on the local file system.
+type dirSink struct {
+	dst string
+	o   *options
+
+	// metadata restoration, see WithPreserve
+	caps         Capabilities
+	warned       map[string]bool
+	dirTimes     []dirTime
+	pendingLinks []pendingLink
 
+	// archives written, to extract with WithRecursive
+	nested []string
+	// paths extracted or kept, see WithSync
+	mirror *mirror
+}
+
+// CreateDir creates a directory with the permissions from the archive.
+func (d *dirSink) CreateDir(name string, mode fs.FileMode) error {
 	// Validate and construct the destination path
-	dstPath, pathErr := securePath(dst, f.NameInArchive)
+	dstPath, pathErr := securePath(d.dst, name)
 	if pathErr != nil {
 		return pathErr
 	}
 
 	// Ensure the parent directory exists
-	parentDir := filepath.Dir(dstPath)
-	if dirErr := createDirWithPermissions(parentDir, dirPermissions); dirErr != nil {
+	if dirErr := createDirWithPermissions(filepath.Dir(dstPath), dirPermissions); dirErr != nil {
 		return dirErr
 	}
+	if linkErr := removeSymlink(dstPath); linkErr != nil {
+		return linkErr
+	}
+	if dirErr := createDirWithPermissions(dstPath, mode); dirErr != nil {
+		return fmt.Errorf("creating directory: %w", dirErr)
+	}
+	logging("Successfully created directory: %s", dstPath)
+	return nil
+}
 
-	// Handle directories
-	if f.IsDir() {
-		// Create the directory with permissions from the archive
-		if dirErr := createDirWithPermissions(dstPath, f.Mode()); dirErr != nil {
-			return fmt.Errorf("creating directory: %w", dirErr)
-		}
-		logging("Successfully created directory: %s", dstPath)
-		return nil
+// CreateFile creates a regular file, or a link into the content cache when
+// a link farm is used.
+func (d *dirSink) CreateFile(name string, mode fs.FileMode) (io.WriteCloser, error) {
+	// Validate and construct the destination path
+	dstPath, pathErr := securePath(d.dst, name)
+	if pathErr != nil {
+		return nil, pathErr
 	}
 
-	// Ignore symlinks (or hardlinks)
-	if f.LinkTarget != "" {
-		logging("Skipping symlink: %s -> %s", dstPath, f.LinkTarget)
-		return nil
+	// Ensure the parent directory exists
+	parentDir := filepath.Dir(dstPath)
+	if dirErr := createDirWithPermissions(parentDir, dirPermissions); dirErr != nil {
+		return nil, dirErr
+	}
+	if linkErr := removeSymlink(dstPath); linkErr != nil {
+		return nil, linkErr
 	}
 
 	// Check and handle parent directory permissions
 	originalMode, statErr := os.Stat(parentDir)
 	if statErr != nil {
-		return fmt.Errorf("stat parent directory: %w", statErr)
+		return nil, fmt.Errorf("stat parent directory: %w", statErr)
 	}
 
 	// If parent directory is read-only, temporarily make it writable
+	restore := func() {}
 	if originalMode.Mode().Perm()&0o200 == 0 {
 		logging("Parent directory is read-only, temporarily making it writable: %s", parentDir)
 		if chmodErr := os.Chmod(parentDir, originalMode.Mode()|0o200); chmodErr != nil {
-			return fmt.Errorf("chmod parent directory: %w", chmodErr)
+			return nil, fmt.Errorf("chmod parent directory: %w", chmodErr)
 		}
-		defer func() {
+		restore = func() {
 			// Restore the original permissions after writing
 			if chmodErr := os.Chmod(parentDir, originalMode.Mode()); chmodErr != nil {
 				logging("Failed to restore original permissions for %s: %v", parentDir, chmodErr)
 			}
-		}()
+		}
 	}
 
-	// Handle regular files
-	reader, openErr := f.Open()
-	if openErr != nil {
-		return fmt.Errorf("open file: %w", openErr)
+	// Link files into the content cache instead of copying them
+	var file io.WriteCloser
+	var createErr error
+	if d.o.cacheDir != "" {
+		file, createErr = createCachedFile(dstPath, mode, d.o)
+	} else {
+		file, createErr = os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if createErr != nil && isLocked(createErr) {
+			file, createErr = d.openLocked(dstPath, mode, createErr)
+		}
 	}
-	defer reader.Close()
-
-	dstFile, createErr := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY, f.Mode())
 	if createErr != nil {
-		return fmt.Errorf("create file: %w", createErr)
+		restore()
+		return nil, createErr
+	}
+	if d.o.recursive > 0 && isNestedArchive(dstPath) {
+		d.nested = append(d.nested, dstPath)
+	}
+	return &sinkFile{WriteCloser: file, dstPath: dstPath, restore: restore}, nil
+}
+
+// extract writes the entry f to the destination, subject to the naming
+// options and the overwrite policy.
+func (d *dirSink) extract(ctx context.Context, f archives.FileInfo) error {
+	f, ok := rewriteEntry(f, d.o)
+	if !ok {
+		return nil
+	}
+	d.keep(f.NameInArchive)
+	f, ok, policyErr := d.applyOverwrite(f)
+	if policyErr != nil || !ok {
+		return policyErr
+	}
+	d.keep(f.NameInArchive)
+	if sinkErr := sinkEntry(f, d); sinkErr != nil {
+		return sinkErr
+	}
+	return d.restoreMetadata(f)
+}
+
+// Symlink creates symlinks with WithPreserve, and ignores them otherwise.
+func (d *dirSink) Symlink(name, target string) error {
+	return d.symlink(name, target)
+}
+
+// sinkFile restores the permissions of the parent directory once the file
+// has been written.
+type sinkFile struct {
+	io.WriteCloser
+	dstPath string
+	restore func()
+}
+
+// Discard removes the incomplete file, see discarder. Files of the cache
+// and pending replacements leave dstPath alone and clean up after themselves.
+func (f *sinkFile) Discard() error {
+	defer f.restore()
+	if d, ok := f.WriteCloser.(discarder); ok {
+		return d.Discard()
 	}
-	defer dstFile.Close()
+	f.WriteCloser.Close()
+	if removeErr := os.Remove(f.dstPath); removeErr != nil && !os.IsNotExist(removeErr) {
+		return removeErr
+	}
+	logging("Removed incomplete file: %s", f.dstPath)
+	return nil
+}
 
-	if _, copyErr := io.Copy(dstFile, reader); copyErr != nil {
-		return fmt.Errorf("copy: %w", copyErr)
+func (f *sinkFile) Close() error {
+	defer f.restore()
+	if closeErr := f.WriteCloser.Close(); closeErr != nil {
+		return closeErr
 	}
-	logging("Successfully extracted file: %s", dstPath)
+	logging("Successfully extracted file: %s", f.dstPath)
 	return nil
 }
 
-// Unarchive unarchives a tarball to a directory, symlinks and hardlinks are ignored.
-func Unarchive(tarball, dst string) error {
+// Unarchive unarchives a tarball to a directory, hardlinks are ignored and
+// symlinks too unless WithPreserve is given. Failures at an entry, like a
+// corrupt header or content, are returned as an EntryError.
+// opts can be used to customize how entries are written to dst, existing files
+// are overwritten unless a policy is set with WithOverwrite.
+func Unarchive(tarball, dst string, opts ...Option) error {
+	o := newOptions(opts)
 	logging("Unarchiving %s to %s", tarball, dst)
-	archiveFile, openErr := os.Open(tarball)
+	if o.verifyManifest {
+		if verifyErr := verifyManifest(tarball, o.manifestFile, o); verifyErr != nil {
+			return fmt.Errorf("verify manifest: %w", verifyErr)
+		}
+	}
+	if rootErr := o.checkDestination(dst, o.cacheDir); rootErr != nil {
+		return rootErr
+	}
+
+	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
+		return fmt.Errorf("creating destination directory: %w", dirErr)
+	}
+
+	sink := &dirSink{dst: dst, o: o}
+	if capErr := sink.detectCapabilities(); capErr != nil {
+		return capErr
+	}
+	progress := newProgress(o)
+	if extractErr := extractArchive(tarball, progress.handler(sink.extract), o); extractErr != nil {
+		return fmt.Errorf("extracting files: %w", extractErr)
+	}
+	if finishErr := sink.finish(); finishErr != nil {
+		return fmt.Errorf("extracting files: %w", finishErr)
+	}
+	progress.done()
+
+	logging("Unarchiving completed successfully.")
+	return nil
+}
+
+// walkArchive identifies the format of archive and calls handler for each of
+// its entries, without extracting anything.
+func walkArchive(archive string, handler archives.FileHandler) error {
+	return extractArchive(archive, handler, newOptions(nil))
+}
+
+// extractArchive is walkArchive with the options that affect reading, like
+// the password of encrypted zip archives.
+func extractArchive(archive string, handler archives.FileHandler, o *options) error {
+	archiveFile, name, openErr := openArchive(archive)
 	if openErr != nil {
-		return fmt.Errorf("open tarball %s: %w", tarball, openErr)
+		return openErr
 	}
 	defer archiveFile.Close()
 
-	format, input, identifyErr := archives.Identify(context.Background(), tarball, archiveFile)
+	return extractStream(name, archiveFile, handler, o)
+}
+
+// extractStream calls handler for each entry of the archive read from input,
+// name helps identifying its format. Zip archives need random access, they
+// are buffered in a temp file unless input is an io.ReaderAt and io.Seeker.
+// Failures at an entry are returned as an EntryError, and those the start of
+// the archive explains, like an HTML page in place of the archive, as a
+// HintError.
+func extractStream(name string, input io.Reader, handler archives.FileHandler, o *options) error {
+	input, limitErr := limitArchive(input, o.limits)
+	if limitErr != nil {
+		return limitErr
+	}
+	decrypted, encrypted, decryptErr := decryptInput(input, o)
+	if decryptErr != nil {
+		return fmt.Errorf("decrypt archive: %w", decryptErr)
+	}
+	if encrypted {
+		name = trimEncryptionExt(name)
+	}
+	diag := &diagnoser{name: name}
+	decrypted = diag.reader(decrypted)
+
+	between, within, stop := operationContext(o)
+	defer stop()
+	handler = cancelHandler(between, within, limitHandler(handler, o.limits))
+
+	format, input, identifyErr := identify(within, name, decrypted)
 	if identifyErr != nil {
-		return fmt.Errorf("identify format: %w", identifyErr)
+		return diag.wrap(fmt.Errorf("identify format: %w", identifyErr), true)
+	}
+	if availErr := compiledIn(format); availErr != nil {
+		return availErr
+	}
+	if formatErr := checkFormat(format, o.limits); formatErr != nil {
+		return formatErr
 	}
 
 	extractor, ok := format.(archives.Extractor)
 	if !ok {
 		return fmt.Errorf("unsupported format for extraction")
 	}
-
-	if dirErr := createDirWithPermissions(dst, dirPermissions); dirErr != nil {
-		return fmt.Errorf("creating destination directory: %w", dirErr)
+	extractor, input, cleanup, seekErr := prepareSeekable(within, format, extractor, input, o)
+	if seekErr != nil {
+		return seekErr
 	}
+	defer cleanup()
 
-	handler := func(ctx context.Context, f archives.FileInfo) error {
-		return handleFile(f, dst)
-	}
+	entries := newEntryTracker(name)
+	extractErr := entries.wrap(extractor.Extract(within, entries.reader(input), entries.handler(handler)))
+	return diag.wrap(extractErr, entries.index.Load() <= 0)
+}
 
-	if extractErr := extractor.Extract(context.Background(), input, handler); extractErr != nil {
-		return fmt.Errorf("extracting files: %w", extractErr)
+// prepareSeekable gives zip and 7z archives the random access they need,
+// buffering input in a temp file unless it is an io.ReaderAt and io.Seeker,
+// and the password of WithPassword. Seekable tar.zst files are read by their
+// seek table, see seekableTar. Other formats are returned as they are.
+// cleanup removes the temp file.
+func prepareSeekable(ctx context.Context, format archives.Format, extractor archives.Extractor, input io.Reader, o *options) (archives.Extractor, io.Reader, func(), error) {
+	switch f := format.(type) {
+	case archives.Zip:
+		if o.password != "" {
+			extractor = encryptedZip{Zip: f, password: o.password}
+		}
+	case archives.SevenZip:
+		f.Password = o.password
+		extractor = SevenZip{SevenZip: f}
+	case archives.CompressedArchive:
+		return seekableTar(f, extractor, input)
+	default:
+		return extractor, input, func() {}, nil
 	}
+	cleanup := func() {}
+	if _, seekable := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); !seekable {
+		spool, spoolErr := os.CreateTemp("", "arc-*"+format.Extension())
+		if spoolErr != nil {
+			return nil, nil, nil, fmt.Errorf("create temp file: %w", spoolErr)
+		}
+		cleanup = func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		if _, copyErr := io.Copy(spool, ctxReader{Reader: input, ctx: ctx}); copyErr != nil {
+			cleanup()
+			return nil, nil, nil, fmt.Errorf("buffer %s archive: %w", strings.TrimPrefix(format.Extension(), "."), copyErr)
+		}
+		input = spool
+	}
+	return extractor, input, cleanup, nil
+}
 
-	logging("Unarchiving completed successfully.")
-	return nil
+// readerAt returns input as an io.ReaderAt, for the images read in any
+// order, like squashfs: buffered in a temp file, unless it is one already,
+// as it is when extracted from a file rather than decompressed. cleanup
+// removes the temp file.
+func readerAt(ctx context.Context, input io.Reader, ext string) (io.ReaderAt, func(), error) {
+	if r, ok := input.(io.ReaderAt); ok {
+		return r, func() {}, nil
+	}
+	spool, err := os.CreateTemp("", "arc-*"+ext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create temp file: %w", err)
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	if _, err := io.Copy(spool, ctxReader{Reader: input, ctx: ctx}); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("buffer %s image: %w", strings.TrimPrefix(ext, "."), err)
+	}
+	return spool, cleanup, nil
 }
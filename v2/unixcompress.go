@@ -0,0 +1,378 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Magic numbers of compress(1) and pack(1) output.
+var (
+	compressMagic = []byte{0x1f, 0x9d}
+	packMagic     = []byte{0x1f, 0x1e}
+)
+
+// UnixCompress decompresses the legacy Unix formats of compress(1), .Z
+// files using LZW, and of pack(1), .z files using Huffman coding, as found
+// in old vendor drops. They can't be written.
+type UnixCompress struct{}
+
+func (UnixCompress) Extension() string { return ".Z" }
+func (UnixCompress) MediaType() string { return "application/x-compress" }
+
+// Match matches .Z files by name, pack files are only recognized by their
+// content since .z was also used by early gzip.
+func (uc UnixCompress) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.HasSuffix(filename, uc.Extension()) || strings.HasSuffix(filename, ".taZ")
+	if stream == nil {
+		return mr, nil
+	}
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(stream, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return mr, nil
+		}
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(magic, compressMagic) || bytes.Equal(magic, packMagic)
+	return mr, nil
+}
+
+func (UnixCompress) OpenWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errors.New("compress (.Z) and pack (.z) can only be decompressed")
+}
+
+func (UnixCompress) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	switch {
+	case bytes.Equal(magic, compressMagic):
+		return newLZWReader(br)
+	case bytes.Equal(magic, packMagic):
+		return newPackReader(br)
+	}
+	return nil, errors.New("not compress or pack data")
+}
+
+// lzwReader decodes the LZW codes of compress(1): codes of 9 up to maxBits
+// bits, least significant bit first, where in block mode code 256 clears
+// the table. compress writes codes in groups of 8, so whenever the code
+// size changes the rest of the current group is padding.
+type lzwReader struct {
+	r     *bufio.Reader
+	block bool
+	// current and largest code size, and the code that makes the next one
+	// larger
+	bits, maxBits uint
+	maxCode       int
+	// bits read but not decoded yet
+	buf   uint64
+	nbuf  uint
+	group int
+
+	// the string table, and its next free code
+	prefix []uint16
+	suffix []byte
+	free   int
+	// previous code, -1 before the first one, and the first byte of its
+	// string
+	prev  int
+	first byte
+	// the string of a code, reversed, and the bytes decoded but not read
+	stack  []byte
+	output []byte
+	err    error
+}
+
+const (
+	lzwInitBits = 9
+	lzwClear    = 256
+)
+
+func newLZWReader(r *bufio.Reader) (*lzwReader, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	maxBits := uint(header[2] & 0x1f)
+	if maxBits < lzwInitBits || maxBits > 16 {
+		return nil, fmt.Errorf("unsupported code size of %d bits", maxBits)
+	}
+	lr := &lzwReader{
+		r:       r,
+		block:   header[2]&0x80 != 0,
+		bits:    lzwInitBits,
+		maxBits: maxBits,
+		maxCode: 1<<lzwInitBits - 1,
+		prefix:  make([]uint16, 1<<maxBits),
+		suffix:  make([]byte, 1<<maxBits),
+		prev:    -1,
+	}
+	for i := range 256 {
+		lr.suffix[i] = byte(i)
+	}
+	lr.free = 256
+	if lr.block {
+		lr.free = 257
+	}
+	return lr, nil
+}
+
+// code returns the next code, io.EOF at the end of the input.
+func (lr *lzwReader) code() (int, error) {
+	for lr.nbuf < lr.bits {
+		b, err := lr.r.ReadByte()
+		if err != nil {
+			// the last byte may hold an incomplete code as padding
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		lr.buf |= uint64(b) << lr.nbuf
+		lr.nbuf += 8
+	}
+	code := int(lr.buf & (1<<lr.bits - 1))
+	lr.buf >>= lr.bits
+	lr.nbuf -= lr.bits
+	lr.group = (lr.group + 1) % 8
+	return code, nil
+}
+
+// skipGroup skips the padding up to the end of the group of 8 codes.
+func (lr *lzwReader) skipGroup() error {
+	for lr.group != 0 {
+		if _, err := lr.code(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode decodes the next code into output.
+func (lr *lzwReader) decode() error {
+	if lr.free > lr.maxCode {
+		if err := lr.skipGroup(); err != nil {
+			return err
+		}
+		// like compress and gzip, tables of 9 bit codes still switch to
+		// 10 bits once full
+		lr.bits++
+		lr.maxCode = 1<<lr.bits - 1
+		if lr.bits == lr.maxBits {
+			lr.maxCode = 1 << lr.maxBits
+		}
+	}
+	code, err := lr.code()
+	if err != nil {
+		return err
+	}
+	if lr.prev < 0 {
+		if code > 255 {
+			return errors.New("corrupt compress data: invalid first code")
+		}
+		lr.prev, lr.first = code, byte(code)
+		lr.output = append(lr.output, lr.first)
+		return nil
+	}
+	if code == lzwClear && lr.block {
+		if err := lr.skipGroup(); err != nil {
+			return err
+		}
+		// the next code takes the entry of the clear code, which
+		// can't be referred to
+		lr.bits, lr.maxCode, lr.free = lzwInitBits, 1<<lzwInitBits-1, lzwClear
+		return nil
+	}
+
+	in := code
+	lr.stack = lr.stack[:0]
+	if code >= lr.free {
+		// the code being defined: the previous string and its first byte
+		if code > lr.free {
+			return errors.New("corrupt compress data: invalid code")
+		}
+		lr.stack = append(lr.stack, lr.first)
+		code = lr.prev
+	}
+	for code >= 256 {
+		lr.stack = append(lr.stack, lr.suffix[code])
+		code = int(lr.prefix[code])
+	}
+	lr.first = byte(code)
+	lr.stack = append(lr.stack, lr.first)
+	for i := len(lr.stack) - 1; i >= 0; i-- {
+		lr.output = append(lr.output, lr.stack[i])
+	}
+
+	if lr.free < 1<<lr.maxBits {
+		lr.prefix[lr.free] = uint16(lr.prev)
+		lr.suffix[lr.free] = lr.first
+		lr.free++
+	}
+	lr.prev = in
+	return nil
+}
+
+func (lr *lzwReader) Read(p []byte) (int, error) {
+	for len(lr.output) == 0 {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		lr.err = lr.decode()
+	}
+	n := copy(p, lr.output)
+	lr.output = lr.output[n:]
+	return n, nil
+}
+
+func (lr *lzwReader) Close() error {
+	return nil
+}
+
+// packReader decodes the Huffman codes of pack(1). The header holds the
+// length of the content and the number of leaves at each depth of the
+// tree, the code of a depth numbers its inner nodes before its leaves, and
+// the last leaf of the deepest level ends the data.
+type packReader struct {
+	r *bufio.Reader
+	// bytes left to decode
+	left uint32
+	// per depth: inner nodes, leaves and index of the first leaf's byte
+	inner, leaves, base []int
+	literals            []byte
+	// bits read but not decoded yet, most significant bit first
+	buf  uint64
+	nbuf uint
+	err  error
+}
+
+func newPackReader(r *bufio.Reader) (*packReader, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	depth := int(header[6])
+	if depth < 1 || depth > 24 {
+		return nil, errors.New("corrupt pack data: invalid tree depth")
+	}
+	pr := &packReader{
+		r:      r,
+		left:   binary.BigEndian.Uint32(header[2:6]),
+		inner:  make([]int, depth+1),
+		leaves: make([]int, depth+1),
+		base:   make([]int, depth+1),
+	}
+	counts := make([]byte, depth)
+	if _, err := io.ReadFull(r, counts); err != nil {
+		return nil, fmt.Errorf("read tree: %w", err)
+	}
+	// the count of the deepest level is offset by 2 to fit in a byte, it
+	// includes the end of data code, whose byte isn't stored
+	total := 0
+	for level := 1; level <= depth; level++ {
+		pr.leaves[level] = int(counts[level-1])
+		if level == depth {
+			pr.leaves[level]++
+		}
+		pr.base[level] = total
+		total += pr.leaves[level]
+	}
+	if total > 256 {
+		return nil, errors.New("corrupt pack data: too many leaves")
+	}
+	pr.literals = make([]byte, total)
+	if _, err := io.ReadFull(r, pr.literals); err != nil {
+		return nil, fmt.Errorf("read tree: %w", err)
+	}
+	pr.leaves[depth]++
+	nodes := 0
+	for level := depth; level >= 1; level-- {
+		nodes >>= 1
+		pr.inner[level] = nodes
+		nodes += pr.leaves[level]
+	}
+	if nodes != 2 {
+		return nil, errors.New("corrupt pack data: invalid tree")
+	}
+	return pr, nil
+}
+
+// bit returns the next bit of the input.
+func (pr *packReader) bit() (int, error) {
+	if pr.nbuf == 0 {
+		b, err := pr.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		pr.buf, pr.nbuf = uint64(b), 8
+	}
+	pr.nbuf--
+	return int(pr.buf>>pr.nbuf) & 1, nil
+}
+
+// literal decodes the next byte, io.EOF at the end of data code.
+func (pr *packReader) literal() (byte, error) {
+	code := 0
+	depth := len(pr.inner) - 1
+	for level := 1; level <= depth; level++ {
+		bit, err := pr.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | bit
+		if code < pr.inner[level] {
+			continue
+		}
+		leaf := code - pr.inner[level]
+		if level == depth && leaf == pr.leaves[depth]-1 {
+			return 0, io.EOF
+		}
+		return pr.literals[pr.base[level]+leaf], nil
+	}
+	return 0, errors.New("corrupt pack data: invalid code")
+}
+
+func (pr *packReader) Read(p []byte) (int, error) {
+	if pr.err != nil {
+		return 0, pr.err
+	}
+	n := 0
+	for n < len(p) && pr.left > 0 {
+		b, err := pr.literal()
+		if errors.Is(err, io.EOF) {
+			pr.err = errors.New("corrupt pack data: shorter than its header says")
+			return n, pr.err
+		}
+		if err != nil {
+			pr.err = err
+			return n, err
+		}
+		p[n] = b
+		n++
+		pr.left--
+	}
+	if pr.left == 0 && n == 0 {
+		pr.err = io.EOF
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (pr *packReader) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package arc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// archivedEntry is what ArchiveUpToDate compares of an entry.
+type archivedEntry struct {
+	typ  string
+	link string
+	size int64
+	// in seconds, tar rounds mtimes to the second and zip truncates them
+	mtime int64
+}
+
+// ArchiveUpToDate reports whether the archive outfile already holds the files
+// of dir as ArchiveWithFileFilter would archive them with the same options:
+// the same entries, and regular files and symlinks of the same size and
+// mtime, within a second. Like make, it trusts mtimes and sizes and reads none
+// of the content, so CI can skip recompressing unchanged trees. Routed files,
+// see WithRoutes, are compared by mtime alone. A missing archive isn't up to
+// date.
+// dir: the directory that would be archived
+// outfile: the existing archive
+// filter: a function that returns true for files to be excluded, may be nil
+// opts: optional settings, like WithDirectory, WithSources and WithDeterministic
+func ArchiveUpToDate(dir, outfile string, filter FileFilter, opts ...Option) (bool, error) {
+	o := newOptions(opts)
+	if !isExist(outfile) && SplitVolumes(outfile) == nil {
+		logging("%s does not exist", outfile)
+		return false, nil
+	}
+	want, err := sourceEntries(dir, filter, o)
+	if err != nil {
+		return false, err
+	}
+
+	have := make(map[string]archivedEntry)
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		name := strings.TrimPrefix(path.Clean("/"+f.NameInArchive), "/")
+		if name == "" || (o.manifest && name == ManifestName) {
+			return nil
+		}
+		have[name] = newArchivedEntry(f)
+		return nil
+	}
+	if err := extractArchive(outfile, handler, o); err != nil {
+		return false, fmt.Errorf("reading %s: %w", outfile, err)
+	}
+
+	if len(have) != len(want) {
+		logging("%s has %d entries, the sources %d", outfile, len(have), len(want))
+		return false, nil
+	}
+	for name, w := range want {
+		h, ok := have[name]
+		switch {
+		case !ok:
+			logging("%s is not in %s", name, outfile)
+			return false, nil
+		case h.typ != w.typ || h.link != w.link:
+			logging("%s changed type or target", name)
+			return false, nil
+		case w.typ == "dir":
+			// directory mtimes change with every file added and removed
+			// again, the entries below tell what changed
+		case h.mtime-w.mtime > 1 || w.mtime-h.mtime > 1 || (w.size >= 0 && h.size != w.size):
+			logging("%s changed", name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sourceEntries returns the entries archiving dir with o would write, by
+// their name in the archive.
+func sourceEntries(dir string, filter FileFilter, o *options) (map[string]archivedEntry, error) {
+	if !isExist(diskPath(dir, o)) {
+		return nil, fmt.Errorf("directory '%s' does not exist", dir)
+	}
+	files, err := filesFromDisk(dir, o)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping files from directory '%s': %w", dir, err)
+	}
+	epoch, clamp := deterministicTime, false
+	if o.deterministic {
+		if epoch, clamp, err = sourceDateEpoch(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make(map[string]archivedEntry, len(files))
+	for _, f := range files {
+		if filter != nil && filter(f) {
+			continue
+		}
+		if o.deterministic {
+			f = normalizeFile(f, epoch, clamp)
+		}
+		entry := newArchivedEntry(f)
+		name := f.NameInArchive
+		if route, ok := routeFor(o.routes, name); ok && entry.typ == "file" && f.LinkTarget == "" {
+			// the compressed size is only known once compressed
+			name += route.Compression.Extension()
+			entry.size = -1
+		}
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		if name != "" {
+			entries[name] = entry
+		}
+	}
+	return entries, nil
+}
+
+// newArchivedEntry returns what ArchiveUpToDate compares of f.
+func newArchivedEntry(f archives.FileInfo) archivedEntry {
+	entry := archivedEntry{
+		typ:   entryType(f.Mode()),
+		mtime: f.ModTime().Unix(),
+	}
+	switch entry.typ {
+	case "file":
+		entry.size = f.Size()
+	case "symlink":
+		entry.link = f.LinkTarget
+	}
+	return entry
+}
@@ -0,0 +1,182 @@
+package arc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Verify reads every entry of an archive end-to-end, without writing anything
+// to disk, so that CRCs and frame checksums of the underlying formats are
+// validated, as well as the SHA-256 of the entries if the archive embeds a
+// manifest, see WithManifest. The first corrupted member is reported in the
+// returned error, an EntryError. Plain compressed files (e.g. file.txt.gz) are verified by
+// decompressing them.
+// archive: the archive to verify
+// opts: optional settings, like WithPassword and WithDecryption for
+// encrypted archives
+func Verify(archive string, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Verifying %s", archive)
+	archiveFile, name, openErr := openArchive(archive)
+	if openErr != nil {
+		return openErr
+	}
+	defer archiveFile.Close()
+	if err := verifyStream(archive, name, archiveFile, o); err != nil {
+		return err
+	}
+
+	logging("Verification of %s completed successfully.", archive)
+	return nil
+}
+
+// VerifyReader is Verify for the archive read from r, like os.Stdin, the
+// counterpart of UnarchiveReader. Zip and 7z archives need random access
+// and are buffered in a temp file first. A checksum trailer is verified at
+// the end of the stream.
+// r: the archive stream
+// opts: optional settings, like WithPassword and WithDecryption for
+// encrypted archives
+func VerifyReader(r io.Reader, opts ...Option) error {
+	o := newOptions(opts)
+	logging("Verifying stream")
+	input := newTrailerReader(r)
+	if err := verifyStream("stream", "", input, o); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, input); err != nil {
+		return fmt.Errorf("verifying stream: %w", err)
+	}
+
+	logging("Verification of stream completed successfully.")
+	return nil
+}
+
+// verifyStream verifies the archive read from input, archive names it in
+// messages and name helps identifying its format.
+func verifyStream(archive, name string, input io.Reader, o *options) error {
+	decrypted, encrypted, decryptErr := decryptInput(input, o)
+	if decryptErr != nil {
+		return fmt.Errorf("decrypt archive: %w", decryptErr)
+	}
+	if encrypted {
+		name = trimEncryptionExt(name)
+	}
+	diag := &diagnoser{name: name}
+	ctx := o.context()
+	format, input, identifyErr := identify(ctx, name, diag.reader(decrypted))
+	if identifyErr != nil {
+		return diag.wrap(fmt.Errorf("identify format: %w", identifyErr), true)
+	}
+	if availErr := compiledIn(format); availErr != nil {
+		return availErr
+	}
+	tracker := newEntryTracker(archive)
+	input = tracker.reader(input)
+
+	// compressed archives are decompressed here rather than by the
+	// extractor, so the trailing checksum of the compressed stream
+	// gets validated after the last entry has been read
+	var compression archives.Compression
+	var extractor archives.Extractor
+	switch f := format.(type) {
+	case archives.CompressedArchive:
+		compression = f.Compression
+		extractor = f.Extraction
+	case archives.Extractor:
+		extractor = f
+	case archives.Compression:
+		compression = f
+	default:
+		return fmt.Errorf("unsupported format for verification")
+	}
+
+	if compression != nil {
+		rc, err := compression.OpenReader(input)
+		if err != nil {
+			return fmt.Errorf("open decompressor: %w", diag.wrap(err, true))
+		}
+		defer rc.Close()
+		input = ctxReader{Reader: rc, ctx: ctx}
+	}
+
+	if extractor != nil {
+		extractor, entries, cleanup, err := prepareSeekable(ctx, format, extractor, input, o)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		// checksums of the entries, and of the embedded manifest
+		var expected map[string]string
+		actual := make(map[string]string)
+		handler := func(ctx context.Context, f archives.FileInfo) error {
+			if f.NameInArchive == ManifestName {
+				return readEmbeddedManifest(f, &expected)
+			}
+			return verifyFile(f, actual)
+		}
+		if err := tracker.wrap(extractor.Extract(ctx, entries, tracker.handler(handler))); err != nil {
+			return fmt.Errorf("verifying entries: %w", diag.wrap(err, tracker.index.Load() <= 0))
+		}
+		if expected != nil {
+			if err := compareManifest(expected, actual); err != nil {
+				return fmt.Errorf("verifying manifest: %w", err)
+			}
+			logging("Entries of %s match the embedded manifest", archive)
+		}
+	}
+
+	// read whatever follows the last entry (padding, trailers)
+	// so that stream checksums are checked as well
+	if compression != nil {
+		if _, err := io.Copy(io.Discard, input); err != nil {
+			return fmt.Errorf("verifying compressed stream: %w", diag.wrap(err, false))
+		}
+	}
+	return nil
+}
+
+// verifyFile reads a single archive entry to the end, recording its
+// SHA-256 in sums.
+func verifyFile(f archives.FileInfo, sums map[string]string) error {
+	if f.IsDir() || f.LinkTarget != "" || !f.Mode().IsRegular() {
+		return nil
+	}
+	logging("Verifying entry: %s", f.NameInArchive)
+
+	reader, openErr := f.Open()
+	if openErr != nil {
+		return fmt.Errorf("corrupted entry %s: open: %w", f.NameInArchive, openErr)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	n, copyErr := io.Copy(hash, reader)
+	if copyErr != nil {
+		return fmt.Errorf("corrupted entry %s: %w", f.NameInArchive, copyErr)
+	}
+	if n != f.Size() {
+		return fmt.Errorf("corrupted entry %s: read %d bytes, expected %d", f.NameInArchive, n, f.Size())
+	}
+	sums[f.NameInArchive] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// readEmbeddedManifest parses the manifest entry f into manifest.
+func readEmbeddedManifest(f archives.FileInfo, manifest *map[string]string) error {
+	reader, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("open %s: %w", f.NameInArchive, err)
+	}
+	defer reader.Close()
+	if *manifest, err = parseManifest(reader); err != nil {
+		return fmt.Errorf("parse embedded manifest: %w", err)
+	}
+	return nil
+}
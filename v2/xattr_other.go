@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package arc
+
+import "errors"
+
+// setXattr always fails, extended attributes aren't supported on this
+// platform.
+func setXattr(path, attr string, value []byte) error {
+	return errors.ErrUnsupported
+}
+
+// getXattrs returns no extended attributes, they aren't supported on this
+// platform.
+func getXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
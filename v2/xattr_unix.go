@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package arc
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattr sets the extended attribute attr of path, without following
+// symlinks.
+func setXattr(path, attr string, value []byte) error {
+	return unix.Lsetxattr(path, attr, value, 0)
+}
+
+// getXattrs returns the extended attributes of path, without following
+// symlinks. File systems without extended attributes have none.
+func getXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(path, list); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]byte)
+	for _, attr := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		size, err := unix.Lgetxattr(path, attr, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, attr, value); err != nil {
+			return nil, err
+		}
+		attrs[attr] = value[:size]
+	}
+	return attrs, nil
+}
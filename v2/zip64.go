@@ -0,0 +1,319 @@
+package arc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// Zip64Mode tells when zip archives use the Zip64 extensions, see WithZip64.
+type Zip64Mode int
+
+const (
+	// Zip64Auto uses Zip64 for the entries and archives that need it: of
+	// 4 GiB or more, or with 65535 entries or more
+	Zip64Auto Zip64Mode = iota
+	// Zip64Force writes the Zip64 records of every entry and of the
+	// archive, even if they fit in the classic ones
+	Zip64Force
+	// Zip64Forbid fails with a Zip64Error rather than write an archive that
+	// needs Zip64, for readers that don't support it
+	Zip64Forbid
+)
+
+// Limits of the classic zip records, from which Zip64 is needed.
+const (
+	zip64MaxEntries = 0xffff
+	zip64MaxSize    = 0xffffffff
+)
+
+// Signatures and sizes of the zip records rewritten for Zip64.
+const (
+	zipDirectorySignature      = 0x02014b50
+	zipDirectoryEndSignature   = 0x06054b50
+	zip64DirectoryEndSignature = 0x06064b50
+	zip64LocatorSignature      = 0x07064b50
+	zipDirectoryHeaderLen      = 46
+	zipDirectoryEndLen         = 22
+	zip64DirectoryEndLen       = 56
+	zip64LocatorLen            = 20
+	zip64ExtraID               = 0x0001
+	// version needed to extract Zip64 records, 4.5
+	zip64Version = 45
+)
+
+// ErrZip64Required is returned, wrapped in a Zip64Error, when a zip archive
+// needs Zip64 and WithZip64 forbids it.
+var ErrZip64Required = errors.New("zip archive needs Zip64, which is forbidden")
+
+// Zip64Error reports what made a zip archive need Zip64.
+type Zip64Error struct {
+	// Entry is the entry that needs Zip64, "" for the archive
+	Entry string
+	// Reason is what needs Zip64, like "65535 entries or more"
+	Reason string
+}
+
+func (e *Zip64Error) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("%s: %s", ErrZip64Required, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrZip64Required, e.Entry, e.Reason)
+}
+
+func (e *Zip64Error) Unwrap() error {
+	return ErrZip64Required
+}
+
+// WithZip64 sets when zip archives use the Zip64 extensions. By default,
+// Zip64Auto, archives of 4 GiB or more and with 65535 entries or more are
+// written as valid Zip64 archives. Zip64Forbid fails as soon as an archive
+// needs Zip64, with a Zip64Error.
+func WithZip64(mode Zip64Mode) Option {
+	return func(o *options) {
+		o.zip64 = mode
+	}
+}
+
+// zip64Format swaps format for a zip writer using Zip64 as WithZip64 says.
+func zip64Format(format archives.Archiver, mode Zip64Mode) (archives.Archiver, error) {
+	switch f := format.(type) {
+	case encryptedZip:
+		f.zip64 = mode
+		return f, nil
+	case archives.Zip:
+		return metaZip{Zip: f, zip64: mode}, nil
+	case metaZip:
+		f.zip64 = mode
+		return f, nil
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return metaZip{Zip: z, zip64: mode}, nil
+		}
+	}
+	return nil, fmt.Errorf("zip64 only applies to zip archives, not %T", format)
+}
+
+// zip64Writer is the output of a zip writer. It counts the entries and the
+// bytes written, failing early for Zip64Forbid, and holds back what the zip
+// writer writes on Close to rewrite its central directory: Zip64 records
+// are added where needed, or everywhere for Zip64Force, whatever the zip
+// writer did.
+type zip64Writer struct {
+	w       io.Writer
+	mode    Zip64Mode
+	written int64
+	entries int
+	// set by close, from the offset start
+	closing bool
+	start   int64
+	tail    bytes.Buffer
+}
+
+func newZip64Writer(w io.Writer, mode Zip64Mode) *zip64Writer {
+	return &zip64Writer{w: w, mode: mode}
+}
+
+func (z *zip64Writer) Write(p []byte) (int, error) {
+	if z.closing {
+		return z.tail.Write(p)
+	}
+	if z.mode == Zip64Forbid && z.written+int64(len(p)) >= zip64MaxSize {
+		return 0, &Zip64Error{Reason: "4 GiB or more"}
+	}
+	n, err := z.w.Write(p)
+	z.written += int64(n)
+	return n, err
+}
+
+// entry counts the entry file, which is about to be written, failing for
+// Zip64Forbid when it needs Zip64.
+func (z *zip64Writer) entry(file archives.FileInfo) error {
+	z.entries++
+	if z.mode != Zip64Forbid {
+		return nil
+	}
+	if z.entries >= zip64MaxEntries {
+		return &Zip64Error{Reason: fmt.Sprintf("%d entries or more", zip64MaxEntries)}
+	}
+	if file.Mode().IsRegular() && file.Size() >= zip64MaxSize {
+		return &Zip64Error{Entry: file.NameInArchive, Reason: fmt.Sprintf("%d bytes", file.Size())}
+	}
+	return nil
+}
+
+// close closes zw, and writes its central directory with the Zip64 records
+// of the mode.
+func (z *zip64Writer) close(zw io.Closer) error {
+	z.closing, z.start = true, z.written
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	tail, err := z.rewrite(z.tail.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = z.w.Write(tail)
+	return err
+}
+
+// rewrite returns tail, the end of an entry and the central directory
+// written on Close, with the central directory rewritten.
+func (z *zip64Writer) rewrite(tail []byte) ([]byte, error) {
+	// the zip writers write no archive comment, but look for it anyway
+	end := -1
+	for i := len(tail) - zipDirectoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipDirectoryEndSignature &&
+			i+zipDirectoryEndLen+int(binary.LittleEndian.Uint16(tail[i+20:])) == len(tail) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("zip64: no end of central directory")
+	}
+	offset := int64(binary.LittleEndian.Uint32(tail[end+16:]))
+	if offset == zip64MaxSize {
+		locator := end - zip64LocatorLen
+		if locator < 0 || binary.LittleEndian.Uint32(tail[locator:]) != zip64LocatorSignature {
+			return nil, errors.New("zip64: no zip64 end of central directory locator")
+		}
+		record := int64(binary.LittleEndian.Uint64(tail[locator+8:])) - z.start
+		if record < 0 || record+zip64DirectoryEndLen > int64(locator) {
+			return nil, errors.New("zip64: zip64 end of central directory out of range")
+		}
+		offset = int64(binary.LittleEndian.Uint64(tail[record+48:]))
+	}
+	dir := offset - z.start
+	if dir < 0 || dir > int64(end) {
+		return nil, errors.New("zip64: central directory out of range")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(tail)+z.entries*28+zip64DirectoryEndLen+zip64LocatorLen))
+	out.Write(tail[:dir])
+	var records int64
+	for p := dir; p+zipDirectoryHeaderLen <= int64(end) && binary.LittleEndian.Uint32(tail[p:]) == zipDirectorySignature; records++ {
+		header := tail[p : p+zipDirectoryHeaderLen]
+		nameLen := int64(binary.LittleEndian.Uint16(header[28:]))
+		extraLen := int64(binary.LittleEndian.Uint16(header[30:]))
+		commentLen := int64(binary.LittleEndian.Uint16(header[32:]))
+		next := p + zipDirectoryHeaderLen + nameLen + extraLen + commentLen
+		if next > int64(end) {
+			return nil, errors.New("zip64: truncated central directory")
+		}
+		name := tail[p+zipDirectoryHeaderLen : p+zipDirectoryHeaderLen+nameLen]
+		extra := tail[p+zipDirectoryHeaderLen+nameLen : p+zipDirectoryHeaderLen+nameLen+extraLen]
+		if err := z.writeDirectoryHeader(out, header, name, extra, tail[next-commentLen:next]); err != nil {
+			return nil, err
+		}
+		p = next
+	}
+	if records != int64(z.entries) {
+		return nil, fmt.Errorf("zip64: %d entries in the central directory, %d written", records, z.entries)
+	}
+
+	directoryEnd := z.start + int64(out.Len())
+	size := directoryEnd - offset
+	if z.mode == Zip64Force || records >= zip64MaxEntries || size >= zip64MaxSize || offset >= zip64MaxSize {
+		if z.mode == Zip64Forbid {
+			return nil, &Zip64Error{Reason: "central directory beyond the classic limits"}
+		}
+		record := make([]byte, 0, zip64DirectoryEndLen+zip64LocatorLen)
+		record = binary.LittleEndian.AppendUint32(record, zip64DirectoryEndSignature)
+		record = binary.LittleEndian.AppendUint64(record, zip64DirectoryEndLen-12)
+		record = binary.LittleEndian.AppendUint16(record, zip64Version)
+		record = binary.LittleEndian.AppendUint16(record, zip64Version)
+		// number of this disk, and of the disk with the central directory
+		record = binary.LittleEndian.AppendUint32(record, 0)
+		record = binary.LittleEndian.AppendUint32(record, 0)
+		record = binary.LittleEndian.AppendUint64(record, uint64(records))
+		record = binary.LittleEndian.AppendUint64(record, uint64(records))
+		record = binary.LittleEndian.AppendUint64(record, uint64(size))
+		record = binary.LittleEndian.AppendUint64(record, uint64(offset))
+		record = binary.LittleEndian.AppendUint32(record, zip64LocatorSignature)
+		record = binary.LittleEndian.AppendUint32(record, 0)
+		record = binary.LittleEndian.AppendUint64(record, uint64(directoryEnd))
+		// total number of disks
+		record = binary.LittleEndian.AppendUint32(record, 1)
+		out.Write(record)
+		records, size, offset = zip64MaxEntries, zip64MaxSize, zip64MaxSize
+	}
+	record := make([]byte, 0, zipDirectoryEndLen)
+	record = binary.LittleEndian.AppendUint32(record, zipDirectoryEndSignature)
+	// number of this disk, and of the disk with the central directory
+	record = binary.LittleEndian.AppendUint16(record, 0)
+	record = binary.LittleEndian.AppendUint16(record, 0)
+	record = binary.LittleEndian.AppendUint16(record, uint16(min(records, zip64MaxEntries)))
+	record = binary.LittleEndian.AppendUint16(record, uint16(min(records, zip64MaxEntries)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(min(size, zip64MaxSize)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(min(offset, zip64MaxSize)))
+	out.Write(record)
+	// the length of the comment and the comment
+	out.Write(tail[end+20:])
+	return out.Bytes(), nil
+}
+
+// writeDirectoryHeader writes the central directory header of an entry to
+// out, with a Zip64 extra field if it needs one or the mode forces it.
+func (z *zip64Writer) writeDirectoryHeader(out *bytes.Buffer, header, name, extra, comment []byte) error {
+	compressed := uint64(binary.LittleEndian.Uint32(header[20:]))
+	uncompressed := uint64(binary.LittleEndian.Uint32(header[24:]))
+	offset := uint64(binary.LittleEndian.Uint32(header[42:]))
+
+	// the values the zip writer moved to its Zip64 extra field, which is
+	// dropped in favor of the one written here
+	var kept []byte
+	for rest := extra; len(rest) >= 4; {
+		id, n := binary.LittleEndian.Uint16(rest), int(binary.LittleEndian.Uint16(rest[2:]))
+		if 4+n > len(rest) {
+			kept = append(kept, rest...)
+			break
+		}
+		if id != zip64ExtraID {
+			kept = append(kept, rest[:4+n]...)
+			rest = rest[4+n:]
+			continue
+		}
+		field := rest[4 : 4+n]
+		for _, v := range []*uint64{&uncompressed, &compressed, &offset} {
+			if *v == zip64MaxSize && len(field) >= 8 {
+				*v, field = binary.LittleEndian.Uint64(field), field[8:]
+			}
+		}
+		rest = rest[4+n:]
+	}
+
+	header = bytes.Clone(header)
+	needed := uncompressed >= zip64MaxSize || compressed >= zip64MaxSize || offset >= zip64MaxSize
+	if needed && z.mode == Zip64Forbid {
+		return &Zip64Error{Entry: string(name), Reason: "4 GiB or more, or beyond 4 GiB of the archive"}
+	}
+	if needed || z.mode == Zip64Force {
+		binary.LittleEndian.PutUint16(header[6:], max(binary.LittleEndian.Uint16(header[6:]), zip64Version))
+		binary.LittleEndian.PutUint32(header[20:], zip64MaxSize)
+		binary.LittleEndian.PutUint32(header[24:], zip64MaxSize)
+		binary.LittleEndian.PutUint32(header[42:], zip64MaxSize)
+		kept = binary.LittleEndian.AppendUint16(kept, zip64ExtraID)
+		kept = binary.LittleEndian.AppendUint16(kept, 24)
+		kept = binary.LittleEndian.AppendUint64(kept, uncompressed)
+		kept = binary.LittleEndian.AppendUint64(kept, compressed)
+		kept = binary.LittleEndian.AppendUint64(kept, offset)
+	} else {
+		binary.LittleEndian.PutUint32(header[20:], uint32(compressed))
+		binary.LittleEndian.PutUint32(header[24:], uint32(uncompressed))
+		binary.LittleEndian.PutUint32(header[42:], uint32(offset))
+	}
+	if len(kept) > 0xffff {
+		return fmt.Errorf("zip64: extra fields of %s too long", name)
+	}
+	binary.LittleEndian.PutUint16(header[30:], uint16(len(kept)))
+	out.Write(header)
+	out.Write(name)
+	out.Write(kept)
+	out.Write(comment)
+	return nil
+}
@@ -0,0 +1,361 @@
+package arc
+
+import (
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/alexmullins/zip"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+// winzipAESExtraID tags the extra field of AES encrypted zip entries
+const winzipAESExtraID = 0x9901
+
+// ErrPassword is returned when an encrypted zip entry can't be decrypted with
+// the given password.
+var ErrPassword = errors.New("wrong password for encrypted zip entry")
+
+// WithPassword protects zip archives with a password. New archives are
+// encrypted with AES-256 (WinZip AE-2), extraction decrypts both AES and
+// legacy ZipCrypto entries. Other archive formats are rejected when creating
+// and unaffected when extracting.
+func WithPassword(password string) Option {
+	return func(o *options) {
+		o.password = password
+	}
+}
+
+// encryptedZip reads and writes password protected zip archives. Only the
+// store and deflate methods are supported.
+type encryptedZip struct {
+	archives.Zip
+	password string
+	// entry metadata, see WithZipMeta
+	meta func(f archives.FileInfo) (ZipEntryMeta, bool)
+	// extensions of the entries stored without compression, see WithZipStore
+	storeExts map[string]bool
+	zip64     Zip64Mode
+}
+
+// encryptFormat swaps format for its password protected counterpart.
+func encryptFormat(format archives.Archiver, password string) (archives.Archiver, error) {
+	switch f := format.(type) {
+	case archives.Zip:
+		return encryptedZip{Zip: f, password: password}, nil
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return encryptedZip{Zip: z, password: password}, nil
+		}
+	}
+	return nil, fmt.Errorf("password protection is only supported for zip archives, not %T", format)
+}
+
+// Archive writes files to output, encrypting the content of every entry.
+func (z encryptedZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	method := z.Compression
+	if method != zip.Store && method != zip.Deflate {
+		return fmt.Errorf("encrypted zip: unsupported compression method %d, use store or deflate", method)
+	}
+
+	out := newZip64Writer(output, z.zip64)
+	zw := zip.NewWriter(out)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := out.entry(file); err != nil {
+			return err
+		}
+		if err := z.archiveFile(zw, file, method); err != nil {
+			return fmt.Errorf("adding %s: %w", file.NameInArchive, err)
+		}
+	}
+	return out.close(zw)
+}
+
+// ArchiveAsync is like Archive, with files arriving over the jobs channel.
+func (z encryptedZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	method := z.Compression
+	if method != zip.Store && method != zip.Deflate {
+		return fmt.Errorf("encrypted zip: unsupported compression method %d, use store or deflate", method)
+	}
+
+	out := newZip64Writer(output, z.zip64)
+	zw := zip.NewWriter(out)
+	for job := range jobs {
+		err := out.entry(job.File)
+		if err == nil {
+			if err = z.archiveFile(zw, job.File, method); err != nil {
+				err = fmt.Errorf("adding %s: %w", job.File.NameInArchive, err)
+			}
+		}
+		job.Result <- err
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return out.close(zw)
+}
+
+func (z encryptedZip) archiveFile(zw *zip.Writer, file archives.FileInfo, method uint16) error {
+	hdr, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("creating header: %w", err)
+	}
+	hdr.Name = file.NameInArchive
+	if z.meta != nil {
+		if meta, ok := z.meta(file); ok {
+			hdr.Comment = meta.Comment
+			hdr.Extra = encodeZipExtra(meta.Extra)
+		}
+	}
+	if file.IsDir() {
+		if !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		hdr.Method = zip.Store
+		_, err := zw.CreateHeader(hdr)
+		return err
+	}
+	hdr.Method = method
+	if storedByExtension(z.storeExts, file.NameInArchive) {
+		hdr.Method = zip.Store
+	}
+	hdr.SetPassword(z.password)
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("creating entry: %w", err)
+	}
+	// symlinks store their target as content
+	if file.LinkTarget != "" {
+		_, err := io.WriteString(w, file.LinkTarget)
+		return err
+	}
+	if !file.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Extract decrypts the entries of sourceArchive and hands them to handleFile.
+// sourceArchive must be an io.ReaderAt and io.Seeker, like an *os.File.
+func (z encryptedZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile archives.FileHandler) error {
+	sra, ok := sourceArchive.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return fmt.Errorf("encrypted zip: input type must be an io.ReaderAt and io.Seeker because of zip format constraints")
+	}
+	size, err := sra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("determining stream size: %w", err)
+	}
+	zr, err := zip.NewReader(sra, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fi, err := z.fileInfo(sra, f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := handleFile(ctx, fi); errors.Is(err, fs.SkipAll) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("handling file: %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// fileInfo describes f the way archives.Zip does, with Open decrypting.
+func (z encryptedZip) fileInfo(ra io.ReaderAt, f *zip.File) (archives.FileInfo, error) {
+	info := f.FileInfo()
+	open := func() (fs.File, error) {
+		rc, err := z.open(ra, f)
+		if err != nil {
+			return nil, err
+		}
+		return sourceFile{ReadCloser: rc, info: info}, nil
+	}
+
+	var linkTarget string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		rc, err := z.open(ra, f)
+		if err != nil {
+			return archives.FileInfo{}, err
+		}
+		target, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return archives.FileInfo{}, fmt.Errorf("reading symlink target: %w", err)
+		}
+		linkTarget = string(target)
+	}
+
+	return archives.FileInfo{
+		FileInfo:      info,
+		Header:        f.FileHeader,
+		NameInArchive: path.Clean(f.Name),
+		LinkTarget:    linkTarget,
+		Open:          open,
+	}, nil
+}
+
+// open returns the decrypted and decompressed content of f.
+func (z encryptedZip) open(ra io.ReaderAt, f *zip.File) (io.ReadCloser, error) {
+	if !f.IsEncrypted() {
+		return f.Open()
+	}
+	if z.password == "" {
+		return nil, ErrPassword
+	}
+	if isAESEntry(f) {
+		f.SetPassword(z.password)
+		rc, err := f.Open()
+		if errors.Is(err, zip.ErrPassword) {
+			return nil, ErrPassword
+		}
+		return rc, err
+	}
+	return openZipCrypto(ra, f, z.password)
+}
+
+// isAESEntry reports whether f carries the WinZip AES extra field.
+func isAESEntry(f *zip.File) bool {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if tag == winzipAESExtraID {
+			return true
+		}
+		if len(extra) < 4+size {
+			break
+		}
+		extra = extra[4+size:]
+	}
+	return false
+}
+
+// openZipCrypto decrypts an entry protected with the traditional PKWARE
+// cipher. It is weak and only supported for reading old archives.
+func openZipCrypto(ra io.ReaderAt, f *zip.File, password string) (io.ReadCloser, error) {
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	raw := io.NewSectionReader(ra, offset, int64(f.CompressedSize64))
+
+	keys := newZipCryptoKeys(password)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	keys.decrypt(header)
+	// the last header byte checks the password against the CRC, or the
+	// modification time when the CRC follows the data
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, ErrPassword
+	}
+
+	var r io.Reader = &zipCryptoReader{r: raw, keys: keys}
+	switch f.Method {
+	case zip.Store:
+	case zip.Deflate:
+		r = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression method %d", f.Method)
+	}
+	return &crcReader{r: r, hash: crc32.NewIEEE(), want: f.CRC32}, nil
+}
+
+// zipCryptoKeys is the state of the traditional PKWARE stream cipher.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(buf []byte) {
+	for i, c := range buf {
+		t := uint16(k[2] | 2)
+		buf[i] = c ^ byte((uint32(t)*uint32(t^1))>>8)
+		k.update(buf[i])
+	}
+}
+
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.keys.decrypt(p[:n])
+	return n, err
+}
+
+// crcReader fails at the end of the stream if the content doesn't match
+// the CRC-32 recorded in the archive, which catches wrong passwords that
+// slipped through the one byte header check.
+type crcReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	want uint32
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		return n, ErrPassword
+	}
+	return n, err
+}
+
+func (c *crcReader) Close() error {
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
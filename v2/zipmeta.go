@@ -0,0 +1,354 @@
+package arc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cryptozip "github.com/alexmullins/zip"
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/zip"
+)
+
+// IDs of common zip extra fields, see APPNOTE.TXT 4.5 and 4.6.
+const (
+	ZipExtraZip64     = 0x0001 // sizes and offsets of large entries
+	ZipExtraNTFS      = 0x000a // NTFS mtime, atime and ctime
+	ZipExtraTimestamp = 0x5455 // Info-ZIP extended timestamp
+	ZipExtraUnix      = 0x7875 // Info-ZIP Unix UID and GID
+)
+
+// errNotZip stops reading the metadata of other archive formats.
+var errNotZip = errors.New("not a zip archive")
+
+// ZipExtra is a single extra field of a zip entry.
+type ZipExtra struct {
+	ID   uint16
+	Data []byte
+}
+
+// ZipEntryMeta is the zip specific metadata of an entry that fs.FileInfo
+// doesn't cover.
+type ZipEntryMeta struct {
+	// Name is the path of the entry in the archive, set when reading
+	Name    string
+	Comment string
+	Extra   []ZipExtra
+}
+
+// WithZipMeta sets the comment and extra fields of the entries of created zip
+// archives to what meta returns for them; entries it returns false for get
+// none. Passing ZipMetaOf copies the metadata of entries read from another
+// zip archive. The zip64, AES and extended timestamp fields are managed by
+// the writer and dropped from Extra.
+func WithZipMeta(meta func(f archives.FileInfo) (ZipEntryMeta, bool)) Option {
+	return func(o *options) {
+		o.zipMeta = meta
+	}
+}
+
+// ZipMetaOf returns the comment and extra fields of f, if it was read from a
+// zip archive.
+func ZipMetaOf(f archives.FileInfo) (ZipEntryMeta, bool) {
+	var comment string
+	var extra []byte
+	switch hdr := f.Header.(type) {
+	case zip.FileHeader:
+		comment, extra = hdr.Comment, hdr.Extra
+	case *zip.FileHeader:
+		comment, extra = hdr.Comment, hdr.Extra
+	case cryptozip.FileHeader:
+		comment, extra = hdr.Comment, hdr.Extra
+	default:
+		return ZipEntryMeta{}, false
+	}
+	fields, err := ParseZipExtra(extra)
+	if err != nil {
+		logging("Ignoring malformed extra fields of %s: %v", f.NameInArchive, err)
+	}
+	return ZipEntryMeta{Name: f.NameInArchive, Comment: comment, Extra: fields}, true
+}
+
+// ReadZipMeta returns the comment and extra fields of every entry of the zip
+// archive, in archive order.
+// archive: the zip archive to read
+// opts: optional settings, see Option
+func ReadZipMeta(archive string, opts ...Option) ([]ZipEntryMeta, error) {
+	var entries []ZipEntryMeta
+	handler := func(ctx context.Context, f archives.FileInfo) error {
+		meta, ok := ZipMetaOf(f)
+		if !ok {
+			return errNotZip
+		}
+		entries = append(entries, meta)
+		return nil
+	}
+	if err := extractArchive(archive, handler, newOptions(opts)); err != nil {
+		return nil, fmt.Errorf("reading zip metadata of %s: %w", archive, err)
+	}
+	return entries, nil
+}
+
+// ParseZipExtra splits the raw extra data of a zip entry into its fields.
+func ParseZipExtra(extra []byte) ([]ZipExtra, error) {
+	var fields []ZipExtra
+	for len(extra) > 0 {
+		if len(extra) < 4 {
+			return fields, errors.New("truncated extra field header")
+		}
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return fields, fmt.Errorf("truncated extra field 0x%04x", id)
+		}
+		fields = append(fields, ZipExtra{ID: id, Data: extra[4 : 4+size]})
+		extra = extra[4+size:]
+	}
+	return fields, nil
+}
+
+// encodeZipExtra joins fields into raw extra data, leaving out the ones the
+// zip writers generate themselves.
+func encodeZipExtra(fields []ZipExtra) []byte {
+	var extra []byte
+	for _, field := range fields {
+		switch field.ID {
+		case ZipExtraZip64, ZipExtraTimestamp, winzipAESExtraID:
+			continue
+		}
+		extra = binary.LittleEndian.AppendUint16(extra, field.ID)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(field.Data)))
+		extra = append(extra, field.Data...)
+	}
+	return extra
+}
+
+// Field returns the data of the first extra field with the given id.
+func (m ZipEntryMeta) Field(id uint16) ([]byte, bool) {
+	for _, field := range m.Extra {
+		if field.ID == id {
+			return field.Data, true
+		}
+	}
+	return nil, false
+}
+
+// ntfsUnixOffset is the Unix epoch in 100ns intervals since 1601, the
+// epoch of NTFS timestamps.
+const ntfsUnixOffset = 116444736000000000
+
+// NTFSTimes returns the timestamps of the NTFS extra field, written by
+// Windows tools with 100ns precision.
+func (m ZipEntryMeta) NTFSTimes() (mtime, atime, ctime time.Time, ok bool) {
+	data, found := m.Field(ZipExtraNTFS)
+	// 4 reserved bytes, then tagged attributes; tag 1 holds the times
+	if !found || len(data) < 4 {
+		return
+	}
+	data = data[4:]
+	for len(data) >= 4 {
+		tag := binary.LittleEndian.Uint16(data)
+		size := int(binary.LittleEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < size {
+			return
+		}
+		if tag == 1 && size >= 24 {
+			toTime := func(b []byte) time.Time {
+				ticks := int64(binary.LittleEndian.Uint64(b))
+				return time.Unix(0, (ticks-ntfsUnixOffset)*100).UTC()
+			}
+			return toTime(data), toTime(data[8:]), toTime(data[16:]), true
+		}
+		data = data[size:]
+	}
+	return
+}
+
+// NTFSTimesExtra returns an NTFS extra field holding the given times.
+func NTFSTimesExtra(mtime, atime, ctime time.Time) ZipExtra {
+	data := make([]byte, 4, 32)
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint16(data, 24)
+	for _, t := range []time.Time{mtime, atime, ctime} {
+		var ticks int64
+		if !t.IsZero() {
+			ticks = t.UnixNano()/100 + ntfsUnixOffset
+		}
+		data = binary.LittleEndian.AppendUint64(data, uint64(ticks))
+	}
+	return ZipExtra{ID: ZipExtraNTFS, Data: data}
+}
+
+// UnixOwner returns the UID and GID of the Info-ZIP Unix extra field.
+func (m ZipEntryMeta) UnixOwner() (uid, gid int, ok bool) {
+	data, found := m.Field(ZipExtraUnix)
+	// version 1, then the size and value of the UID and of the GID
+	if !found || len(data) < 2 || data[0] != 1 {
+		return 0, 0, false
+	}
+	data = data[1:]
+	var ids [2]int
+	for i := range ids {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return 0, 0, false
+		}
+		size := int(data[0])
+		var id uint64
+		for j := size; j > 0; j-- {
+			id = id<<8 | uint64(data[j])
+		}
+		ids[i] = int(id)
+		data = data[1+size:]
+	}
+	return ids[0], ids[1], true
+}
+
+// UnixOwnerExtra returns an Info-ZIP Unix extra field holding uid and gid.
+func UnixOwnerExtra(uid, gid int) ZipExtra {
+	data := []byte{1, 4}
+	data = binary.LittleEndian.AppendUint32(data, uint32(uid))
+	data = append(data, 4)
+	data = binary.LittleEndian.AppendUint32(data, uint32(gid))
+	return ZipExtra{ID: ZipExtraUnix, Data: data}
+}
+
+// metaZip writes zip archives with the entry metadata from WithZipMeta,
+// deflates entries with zopfli for WithZopfli or at the level of
+// WithZipLevel, and stores the entries compressed by WithRoutes and those
+// with the extensions of WithZipStore.
+type metaZip struct {
+	archives.Zip
+	meta   func(f archives.FileInfo) (ZipEntryMeta, bool)
+	zopfli bool
+	// deflate level, 0 for the default
+	level int
+	// names of the entries stored without compression
+	stored map[string]bool
+	// extensions of the entries stored without compression
+	storeExts map[string]bool
+	zip64     Zip64Mode
+}
+
+// zipMetaFormat swaps format for a zip writer adding entry metadata. The
+// password protected writer handles the metadata itself.
+func zipMetaFormat(format archives.Archiver, o *options) (archives.Archiver, error) {
+	switch f := format.(type) {
+	case encryptedZip:
+		f.meta = o.zipMeta
+		return f, nil
+	case archives.Zip:
+		return metaZip{Zip: f, meta: o.zipMeta}, nil
+	case metaZip:
+		f.meta = o.zipMeta
+		return f, nil
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return metaZip{Zip: z, meta: o.zipMeta}, nil
+		}
+	}
+	return nil, fmt.Errorf("entry metadata is only supported for zip archives, not %T", format)
+}
+
+// Archive writes files to output like archives.Zip, with their metadata.
+func (z metaZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	out := newZip64Writer(output, z.zip64)
+	zw := z.writer(out)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := out.entry(file); err != nil {
+			return err
+		}
+		if err := z.archiveFile(zw, file); err != nil {
+			return fmt.Errorf("adding %s: %w", file.NameInArchive, err)
+		}
+	}
+	return out.close(zw)
+}
+
+// ArchiveAsync is like Archive, with files arriving over the jobs channel.
+func (z metaZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	out := newZip64Writer(output, z.zip64)
+	zw := z.writer(out)
+	for job := range jobs {
+		err := out.entry(job.File)
+		if err == nil {
+			if err = z.archiveFile(zw, job.File); err != nil {
+				err = fmt.Errorf("adding %s: %w", job.File.NameInArchive, err)
+			}
+		}
+		job.Result <- err
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return out.close(zw)
+}
+
+// writer returns a zip writer for output, deflating with zopfli or at the
+// level if asked to.
+func (z metaZip) writer(output io.Writer) *zip.Writer {
+	zw := zip.NewWriter(output)
+	if z.zopfli {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return newZopfliWriter(w, zopfliRaw), nil
+		})
+	} else if z.level != 0 {
+		zw.RegisterCompressor(zip.Deflate, newZipDeflater(z.level))
+	}
+	return zw
+}
+
+func (z metaZip) archiveFile(zw *zip.Writer, file archives.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("creating header: %w", err)
+	}
+	hdr.Name = file.NameInArchive
+	hdr.Method = z.Compression
+	if z.stored[file.NameInArchive] || storedByExtension(z.storeExts, file.NameInArchive) {
+		hdr.Method = zip.Store
+	}
+	if file.IsDir() {
+		if !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		hdr.Method = zip.Store
+	}
+	if z.meta != nil {
+		if meta, ok := z.meta(file); ok {
+			hdr.Comment = meta.Comment
+			hdr.Extra = encodeZipExtra(meta.Extra)
+		}
+	}
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("creating entry: %w", err)
+	}
+	// symlinks store their target as content
+	if file.LinkTarget != "" {
+		_, err := io.WriteString(w, file.LinkTarget)
+		return err
+	}
+	if !file.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
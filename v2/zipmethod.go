@@ -0,0 +1,127 @@
+package arc
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+)
+
+// CompressedExtensions are the extensions of formats that are compressed
+// already, like images, videos and archives, for WithZipStore.
+var CompressedExtensions = []string{
+	".7z", ".aac", ".apk", ".avi", ".avif", ".br", ".bz2", ".deb", ".docx",
+	".flac", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz", ".lz4",
+	".lzma", ".m4a", ".m4v", ".mkv", ".mov", ".mp3", ".mp4", ".odt", ".ogg",
+	".opus", ".png", ".rar", ".rpm", ".sz", ".tgz", ".txz", ".webm", ".webp",
+	".woff", ".woff2", ".xlsx", ".xz", ".zip", ".zst",
+}
+
+// zipMethods are the names of the zip compression methods ParseZipMethod
+// accepts.
+var zipMethods = map[string]uint16{
+	"store":   zip.Store,
+	"deflate": zip.Deflate,
+	"bzip2":   ZipMethodBzip2,
+	"zstd":    ZipMethodZstd,
+	"xz":      ZipMethodXz,
+}
+
+// ParseZipMethod returns the zip compression method named name, store,
+// deflate, bzip2, zstd or xz, or given as its number, like 8 for deflate.
+// name: the method
+func ParseZipMethod(name string) (uint16, error) {
+	if method, ok := zipMethods[strings.ToLower(name)]; ok {
+		return method, nil
+	}
+	method, err := strconv.ParseUint(name, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown zip compression method %q, expected store, deflate, bzip2, zstd, xz or a number", name)
+	}
+	return uint16(method), nil
+}
+
+// WithZipLevel deflates the entries of zip archives at level, 1-9 or
+// MaxLevel, while 0 stores them. Password protected archives are deflated
+// at the default level.
+func WithZipLevel(level int) Option {
+	return func(o *options) {
+		o.zipLevel, o.zipLevelSet = level, true
+	}
+}
+
+// WithZipStore stores the entries of zip archives with one of the
+// extensions exts, like ".png", rather than compress them, typically
+// CompressedExtensions: compressing them again wastes time and can make
+// them larger. Extensions are case insensitive.
+func WithZipStore(exts ...string) Option {
+	return func(o *options) {
+		o.zipStore = append(o.zipStore, exts...)
+	}
+}
+
+// zipCompressionFormat swaps format for a zip writer deflating at the level
+// of WithZipLevel and storing the extensions of WithZipStore.
+func zipCompressionFormat(format archives.Archiver, o *options) (archives.Archiver, error) {
+	level := 0
+	if o.zipLevelSet {
+		switch {
+		case o.zipLevel == MaxLevel:
+			level = flate.BestCompression
+		case o.zipLevel < 0 || o.zipLevel > flate.BestCompression:
+			return nil, fmt.Errorf("zip compression level %d is out of range 0-9", o.zipLevel)
+		default:
+			level = o.zipLevel
+		}
+	}
+	var storeExts map[string]bool
+	if len(o.zipStore) > 0 {
+		storeExts = make(map[string]bool, len(o.zipStore))
+		for _, ext := range o.zipStore {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			storeExts[strings.ToLower(ext)] = true
+		}
+	}
+
+	switch f := format.(type) {
+	case encryptedZip:
+		if o.zipLevelSet && level == 0 {
+			f.Compression = zip.Store
+		}
+		f.storeExts = storeExts
+		return f, nil
+	case archives.Zip:
+		return zipCompressionFormat(metaZip{Zip: f}, o)
+	case metaZip:
+		if o.zipLevelSet && level == 0 {
+			f.Compression = zip.Store
+		}
+		f.level, f.storeExts = level, storeExts
+		return f, nil
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return zipCompressionFormat(metaZip{Zip: z}, o)
+		}
+	}
+	return nil, fmt.Errorf("zip compression levels and stored extensions only apply to zip archives, not %T", format)
+}
+
+// storedByExtension reports whether the entry name is stored for its
+// extension, see WithZipStore.
+func storedByExtension(storeExts map[string]bool, name string) bool {
+	return storeExts[strings.ToLower(path.Ext(name))]
+}
+
+// newZipDeflater returns the deflate compressor of zip entries at level.
+func newZipDeflater(level int) func(w io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	}
+}
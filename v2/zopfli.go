@@ -0,0 +1,439 @@
+package arc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/jm33-m0/arc/v2/internal/archives"
+)
+
+const (
+	// times the cost model is refined from the previous parse, like zopfli
+	zopfliIterations = 15
+	// input compressed at once, each block can refer to the 32 KiB before
+	zopfliBlockSize = 1 << 20
+	// candidates of the hash chain tried at each position
+	zopfliMaxChain = 4096
+	// most deflate blocks a block of input is split into
+	zopfliMaxSplits = 15
+)
+
+// WithZopfli deflates gzip and zlib compressed archives, and the deflated
+// entries of zip archives, with zopfli, see Zopfli. Other compressions fail.
+func WithZopfli() Option {
+	return func(o *options) {
+		o.zopfli = true
+	}
+}
+
+// Zopfli wraps a gzip, zlib or raw deflate compression so it is written
+// with a zopfli style encoder, which searches the smallest deflate encoding
+// with an iterated optimal parse instead of the greedy heuristics of the
+// usual encoders. Its output is typically 3-8% smaller than gzip -9, and any
+// decoder reads it, but compressing is around 100 times slower: it is meant
+// for assets compressed once and served many times. Levels don't apply.
+func Zopfli(compression archives.Compression) (archives.Compression, error) {
+	switch compression.(type) {
+	case archives.Gz, archives.Zlib, Deflate:
+		return zopfliCompression{Compression: compression}, nil
+	case zopfliCompression:
+		return compression, nil
+	}
+	return nil, fmt.Errorf("zopfli requires gzip, zlib or deflate compression, not %T", compression)
+}
+
+// zopfliFormat applies Zopfli to the compression of format, or to the
+// deflated entries of zip archives.
+func zopfliFormat(format archives.Archiver) (archives.Archiver, error) {
+	switch f := format.(type) {
+	case archives.Zip:
+		return metaZip{Zip: f, zopfli: true}, nil
+	case metaZip:
+		f.zopfli = true
+		return f, nil
+	case archives.CompressedArchive:
+		if z, ok := f.Archival.(archives.Zip); ok && f.Compression == nil {
+			return metaZip{Zip: z, zopfli: true}, nil
+		}
+		compression, err := Zopfli(f.Compression)
+		if err != nil {
+			return nil, err
+		}
+		f.Compression = compression
+		return f, nil
+	}
+	return nil, fmt.Errorf("zopfli requires gzip, zlib or deflate compression, or a zip archive, not %T", format)
+}
+
+// zopfliCompression writes gzip, zlib or raw deflate streams with the zopfli
+// encoder.
+type zopfliCompression struct {
+	archives.Compression
+}
+
+func (c zopfliCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Compression.(type) {
+	case archives.Zlib:
+		return newZopfliWriter(w, zopfliZlib), nil
+	case Deflate:
+		return newZopfliWriter(w, zopfliRaw), nil
+	}
+	return newZopfliWriter(w, zopfliGzip), nil
+}
+
+// Containers of the deflate stream of zopfliWriter.
+const (
+	zopfliRaw = iota
+	zopfliGzip
+	zopfliZlib
+)
+
+// zopfliWriter compresses its input block by block, wrapped in a gzip or
+// zlib header and checksum.
+type zopfliWriter struct {
+	bw        *bitWriter
+	container int
+	checksum  hash.Hash32
+	size      uint32
+	// the end of the last block, that the next one can refer to
+	history []byte
+	pending []byte
+	closed  bool
+}
+
+func newZopfliWriter(w io.Writer, container int) *zopfliWriter {
+	z := &zopfliWriter{bw: &bitWriter{w: w}, container: container}
+	switch container {
+	case zopfliGzip:
+		// no name nor mtime, maximum compression, unknown OS
+		z.bw.buf = append(z.bw.buf, 0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 2, 0xff)
+		z.checksum = crc32.NewIEEE()
+	case zopfliZlib:
+		// 32 KiB window, maximum compression
+		z.bw.buf = append(z.bw.buf, 0x78, 0xda)
+		z.checksum = adler32.New()
+	}
+	return z
+}
+
+func (z *zopfliWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if z.checksum != nil {
+		z.checksum.Write(p)
+	}
+	z.size += uint32(len(p))
+	z.pending = append(z.pending, p...)
+	for len(z.pending) >= zopfliBlockSize {
+		z.compress(z.pending[:zopfliBlockSize], false)
+		z.pending = z.pending[:copy(z.pending, z.pending[zopfliBlockSize:])]
+		if err := z.bw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (z *zopfliWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	z.compress(z.pending, true)
+	z.bw.align()
+	switch z.container {
+	case zopfliGzip:
+		z.bw.buf = binary.LittleEndian.AppendUint32(z.bw.buf, z.checksum.Sum32())
+		z.bw.buf = binary.LittleEndian.AppendUint32(z.bw.buf, z.size)
+	case zopfliZlib:
+		z.bw.buf = binary.BigEndian.AppendUint32(z.bw.buf, z.checksum.Sum32())
+	}
+	return z.bw.flush()
+}
+
+// compress writes block as deflate blocks.
+func (z *zopfliWriter) compress(block []byte, final bool) {
+	data := append(z.history, block...)
+	start := len(z.history)
+	lz := zopfliParse(data, start)
+
+	// split where separate Huffman codes make up for their headers
+	splits := []int{0}
+	splitBlock(lz, data, 0, len(lz), &splits)
+	splits = append(splits, len(lz))
+	for i := 1; i < len(splits); i++ {
+		lo, hi := splits[i-1], splits[i]
+		from, to := symbolRange(lz, lo, hi, len(data))
+		kind, _ := blockCost(lz[lo:hi], to-from)
+		z.bw.writeBlock(kind, lz[lo:hi], data[from:to], final && i == len(splits)-1)
+	}
+
+	z.history = append(z.history[:0], data[max(0, len(data)-deflateWindow):]...)
+}
+
+// symbolRange returns the input covered by lz[lo:hi], end being the end of
+// the input.
+func symbolRange(lz []lz77Sym, lo, hi, end int) (int, int) {
+	if lo == hi {
+		return end, end
+	}
+	if hi < len(lz) {
+		end = int(lz[hi].pos)
+	}
+	return int(lz[lo].pos), end
+}
+
+// splitBlock adds to splits the indexes of lz[lo:hi] where splitting the
+// block makes the output smaller, searching them like zopfli does.
+func splitBlock(lz []lz77Sym, data []byte, lo, hi int, splits *[]int) {
+	if hi-lo < 10 || len(*splits) > zopfliMaxSplits {
+		return
+	}
+	cost := func(a, b int) int {
+		from, to := symbolRange(lz, a, b, len(data))
+		_, c := blockCost(lz[a:b], to-from)
+		return c
+	}
+	splitCost := func(k int) int {
+		return cost(lo, k) + cost(k, hi)
+	}
+
+	// narrow down around the best of a few evenly spaced points
+	const points = 9
+	start, end := lo+1, hi
+	best, bestCost := -1, math.MaxInt
+	for end-start > points {
+		var p [points]int
+		besti := 0
+		pointCost := math.MaxInt
+		for i := range p {
+			p[i] = start + (i+1)*(end-start)/(points+1)
+			if c := splitCost(p[i]); c < pointCost {
+				besti, pointCost = i, c
+			}
+		}
+		if pointCost >= bestCost {
+			break
+		}
+		best, bestCost = p[besti], pointCost
+		if besti > 0 {
+			start = p[besti-1]
+		}
+		if besti < points-1 {
+			end = p[besti+1]
+		}
+	}
+	for k := start; k < end && end-start <= points; k++ {
+		if c := splitCost(k); c < bestCost {
+			best, bestCost = k, c
+		}
+	}
+	if best < 0 || bestCost >= cost(lo, hi) {
+		return
+	}
+	splitBlock(lz, data, lo, best, splits)
+	*splits = append(*splits, best)
+	splitBlock(lz, data, best, hi, splits)
+}
+
+// zopfliParse returns the LZ77 symbols of data[start:], matches can refer
+// to the bytes before start. The parse is the cheapest under a cost model
+// taken from the previous parse, starting from a greedy one, and the
+// smallest parse of all iterations is kept.
+func zopfliParse(data []byte, start int) []lz77Sym {
+	matches := findMatches(data, start)
+	size := len(data) - start
+
+	lz := make([]lz77Sym, 0, size)
+	for i := 0; i < size; {
+		if m := matches.at(i); len(m) > 0 {
+			length, dist := unpackMatch(m[len(m)-1])
+			lz = append(lz, lz77Sym{litLen: uint16(length), dist: uint16(dist), pos: int32(start + i)})
+			i += length
+			continue
+		}
+		lz = append(lz, lz77Sym{litLen: uint16(data[start+i]), pos: int32(start + i)})
+		i++
+	}
+
+	best := lz
+	_, bestCost := blockCost(lz, size)
+	for range zopfliIterations {
+		lz = optimalParse(data, start, matches, lz)
+		if _, c := blockCost(lz, size); c < bestCost {
+			best, bestCost = lz, c
+		}
+	}
+	return best
+}
+
+// optimalParse returns the cheapest parse of data[start:] with symbol costs
+// given by their frequency in prev.
+func optimalParse(data []byte, start int, matches *matchTable, prev []lz77Sym) []lz77Sym {
+	lit, dist, _ := symbolCounts(prev)
+	litTotal, distTotal := 0, 0
+	for _, n := range lit {
+		litTotal += n
+	}
+	for _, n := range dist {
+		distTotal += n
+	}
+	entropy := func(n, total int) float64 {
+		if total == 0 {
+			return 0
+		}
+		if n == 0 {
+			return math.Log2(float64(total))
+		}
+		return math.Log2(float64(total)) - math.Log2(float64(n))
+	}
+	var litCost [256]float64
+	for i := range litCost {
+		litCost[i] = entropy(lit[i], litTotal)
+	}
+	var lengthCost [deflateMaxMatch + 1]float64
+	for l := deflateMinMatch; l <= deflateMaxMatch; l++ {
+		code := lengthCodes[l]
+		lengthCost[l] = entropy(lit[257+code], litTotal) + float64(lengthExtra[code])
+	}
+	var distCost [distSymbols]float64
+	for code := range distCost {
+		distCost[code] = entropy(dist[code], distTotal) + float64(distExtra[code])
+	}
+
+	size := len(data) - start
+	costs := make([]float64, size+1)
+	for i := 1; i <= size; i++ {
+		costs[i] = math.Inf(1)
+	}
+	// the match ending at each position, 0 for a literal
+	steps := make([]uint32, size+1)
+	// runs of the same byte from each position, long ones are parsed as
+	// matches of the maximum length at distance 1 like zopfli does, rather
+	// than trying every length at every position
+	same := make([]int, size)
+	for i := size - 2; i >= 0; i-- {
+		if data[start+i] == data[start+i+1] {
+			same[i] = same[i+1] + 1
+		}
+	}
+	runCost := distCost[0] + lengthCost[deflateMaxMatch]
+	for i := 0; i < size; i++ {
+		if same[i] > 2*deflateMaxMatch && i > deflateMaxMatch+1 && i+2*deflateMaxMatch+1 < size && same[i-deflateMaxMatch] > deflateMaxMatch {
+			for range deflateMaxMatch {
+				costs[i+deflateMaxMatch], steps[i+deflateMaxMatch] = costs[i]+runCost, packMatch(deflateMaxMatch, 1)
+				i++
+			}
+		}
+		if c := costs[i] + litCost[data[start+i]]; c < costs[i+1] {
+			costs[i+1], steps[i+1] = c, 0
+		}
+		shortest := deflateMinMatch
+		for _, m := range matches.at(i) {
+			longest, d := unpackMatch(m)
+			base := costs[i] + distCost[distCode(d)]
+			for l := shortest; l <= longest; l++ {
+				if c := base + lengthCost[l]; c < costs[i+l] {
+					costs[i+l], steps[i+l] = c, packMatch(l, d)
+				}
+			}
+			shortest = longest + 1
+		}
+	}
+
+	var reversed []lz77Sym
+	for i := size; i > 0; {
+		if steps[i] == 0 {
+			i--
+			reversed = append(reversed, lz77Sym{litLen: uint16(data[start+i]), pos: int32(start + i)})
+			continue
+		}
+		length, d := unpackMatch(steps[i])
+		i -= length
+		reversed = append(reversed, lz77Sym{litLen: uint16(length), dist: uint16(d), pos: int32(start + i)})
+	}
+	lz := make([]lz77Sym, len(reversed))
+	for i, s := range reversed {
+		lz[len(lz)-1-i] = s
+	}
+	return lz
+}
+
+// packMatch and unpackMatch store a match length and distance in 32 bits.
+func packMatch(length, dist int) uint32 {
+	return uint32(length)<<16 | uint32(dist)
+}
+
+func unpackMatch(m uint32) (length, dist int) {
+	return int(m >> 16), int(m & 0xffff)
+}
+
+// matchTable holds, for each position, the closest match of each length:
+// matches of increasing length and distance, each the closest for the
+// lengths down to the previous one.
+type matchTable struct {
+	index   []int32
+	matches []uint32
+}
+
+func (t *matchTable) at(i int) []uint32 {
+	return t.matches[t.index[i]:t.index[i+1]]
+}
+
+// findMatches fills the matchTable of data[start:] with hash chains.
+func findMatches(data []byte, start int) *matchTable {
+	const hashBits = 15
+	hash := func(i int) int {
+		return (int(data[i])<<10 ^ int(data[i+1])<<5 ^ int(data[i+2])) & (1<<hashBits - 1)
+	}
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(data))
+	insert := func(i int) {
+		if i+deflateMinMatch <= len(data) {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	for i := max(0, start-deflateWindow); i < start; i++ {
+		insert(i)
+	}
+
+	t := &matchTable{index: make([]int32, 1, len(data)-start+1)}
+	for i := start; i < len(data); i++ {
+		limit := min(deflateMaxMatch, len(data)-i)
+		if limit >= deflateMinMatch {
+			best := deflateMinMatch - 1
+			chain := 0
+			for c := head[hash(i)]; c >= 0 && i-int(c) <= deflateWindow && chain < zopfliMaxChain; c = prev[c] {
+				chain++
+				if data[int(c)+best] != data[i+best] {
+					continue
+				}
+				l := 0
+				for l < limit && data[int(c)+l] == data[i+l] {
+					l++
+				}
+				if l > best {
+					best = l
+					t.matches = append(t.matches, packMatch(l, i-int(c)))
+					if l == limit {
+						break
+					}
+				}
+			}
+		}
+		t.index = append(t.index, int32(len(t.matches)))
+		insert(i)
+	}
+	return t
+}